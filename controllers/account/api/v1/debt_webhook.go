@@ -41,6 +41,8 @@ const (
 	mastersGroup               = "system:masters"
 	kubeSystemNamespace        = "kube-system"
 	defaultUserSystemNamespace = "user-system"
+	// resourcesGroup the metering config of the resources controller, eg: the MonitorPolicy, is never changed by the users
+	resourcesGroup = "resources.sealos.io"
 )
 
 const (
@@ -84,6 +86,9 @@ func (d DebtValidate) Handle(ctx context.Context, req admission.Request) admissi
 		if !strings.HasPrefix(g, saPrefix+":ns-") {
 			continue
 		}
+		if req.Kind.Group == resourcesGroup {
+			return admission.Denied(fmt.Sprintf("ns %s request %s %s permission denied", req.Namespace, req.Kind.Kind, req.Operation))
+		}
 		if isWhiteList(req) {
 			return admission.ValidationResponse(true, "")
 		}
@@ -0,0 +1,126 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package archive writes the minute monitors of a period as gzipped JSON lines before the retention drops them, and
+// restores them into a monitor store, eg: for a billing dispute months later. Unlike the rows of the exports, a line is
+// a whole resources.Monitor, so the restored monitors are the monitors archived.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	// Extension the extension of the archives
+	Extension = ".jsonl.gz"
+
+	// DefaultRestoreBatchSize the monitors inserted by a batch of the restore
+	DefaultRestoreBatchSize = 1000
+
+	dayLayout = "20060102"
+	// maxLineSize bounds a line of the archive, a monitor is far below
+	maxLineSize = 16 << 20
+)
+
+// DayKey returns the key of the archive of the UTC day under the prefix, eg: monitors/20240101.jsonl.gz
+func DayKey(prefix string, day time.Time) string {
+	key := day.UTC().Format(dayLayout) + Extension
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+// Write streams the minute monitors of all namespaces in [start, end) to w as gzipped JSON lines, and returns the
+// number of the monitors written
+func Write(ctx context.Context, store database.MonitorStore, w io.Writer, start, end time.Time) (int64, error) {
+	if !start.Before(end) {
+		return 0, fmt.Errorf("start time must be before end time")
+	}
+	gz := gzip.NewWriter(w)
+	encoder := json.NewEncoder(gz)
+	var monitors int64
+	if err := store.QueryMonitorsInRange(ctx, start, end, func(monitor *resources.Monitor) error {
+		monitors++
+		return encoder.Encode(monitor)
+	}); err != nil {
+		return monitors, fmt.Errorf("failed to archive the monitors: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return monitors, fmt.Errorf("failed to finish the archive: %w", err)
+	}
+	return monitors, nil
+}
+
+// Restore inserts the archived monitors of r in [start, end) into the store by batches of batchSize
+// (DefaultRestoreBatchSize if <= 0), and returns the number of the monitors restored. The stores skip the monitors
+// already stored by their MonitorID, so an archive restored twice or over the monitors kept doesn't duplicate them.
+func Restore(ctx context.Context, store database.MonitorStore, r io.Reader, start, end time.Time, batchSize int) (int64, error) {
+	if !start.Before(end) {
+		return 0, fmt.Errorf("start time must be before end time")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultRestoreBatchSize
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("invalid archive: %w", err)
+	}
+	defer gz.Close()
+
+	var (
+		restored int64
+		batch    = make([]*resources.Monitor, 0, batchSize)
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := store.InsertMonitorBatch(ctx, batch); err != nil {
+			return fmt.Errorf("failed to restore the monitors: %w", err)
+		}
+		restored += int64(len(batch))
+		batch = make([]*resources.Monitor, 0, batchSize)
+		return nil
+	}
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		monitor := &resources.Monitor{}
+		if err := json.Unmarshal(scanner.Bytes(), monitor); err != nil {
+			return restored, fmt.Errorf("invalid monitor at line %d of the archive: %w", line, err)
+		}
+		if monitor.Time.Before(start) || !monitor.Time.Before(end) {
+			continue
+		}
+		if batch = append(batch, monitor); len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return restored, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return restored, fmt.Errorf("failed to read the archive: %w", err)
+	}
+	return restored, flush()
+}
@@ -0,0 +1,87 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestDayKey(t *testing.T) {
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if got := DayKey("monitors", day); got != "monitors/20240102.jsonl.gz" {
+		t.Errorf("DayKey() = %s, want monitors/20240102.jsonl.gz", got)
+	}
+	if got := DayKey("", day); got != "20240102.jsonl.gz" {
+		t.Errorf("DayKey() without prefix = %s, want 20240102.jsonl.gz", got)
+	}
+}
+
+func TestArchive_roundTrip(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := databasetest.NewMemoryStore()
+	var monitors []*resources.Monitor
+	for i := 0; i < 30; i++ {
+		monitors = append(monitors, &resources.Monitor{Time: day.Add(time.Duration(i) * time.Hour), Category: "ns-a",
+			Type: resources.AppType[resources.DB], Name: "db-a", Used: resources.EnumUsedMap{0: 1000, 1: int64(i)},
+			Utilization: resources.EnumUsedMap{0: 50}, Tenant: map[string]string{"region": "a"}, IdempotencyKey: "key"})
+	}
+	if err := source.InsertMonitor(ctx, monitors...); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	written, err := Write(ctx, source, &buf, day, day.AddDate(0, 0, 1))
+	if err != nil || written != 24 {
+		t.Fatalf("Write() = %d, %v, want the 24 monitors of the day", written, err)
+	}
+	archived := buf.Bytes()
+
+	target := databasetest.NewMemoryStore()
+	restored, err := Restore(ctx, target, bytes.NewReader(archived), day, day.AddDate(0, 0, 1), 5)
+	if err != nil || restored != 24 {
+		t.Fatalf("Restore() = %d, %v, want 24", restored, err)
+	}
+	want := source.Monitors()[:24]
+	if got := target.Monitors(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored monitors = %+v, want %+v", got, want)
+	}
+	if got := target.Calls("InsertMonitorBatch"); got != 5 {
+		t.Errorf("Restore() inserted %d batches, want 5 batches of at most 5", got)
+	}
+
+	// restoring again doesn't duplicate the monitors, a range restores its monitors only
+	if _, err := Restore(ctx, target, bytes.NewReader(archived), day, day.AddDate(0, 0, 1), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(target.Monitors()); got != 24 {
+		t.Errorf("restored twice = %d monitors, want 24", got)
+	}
+	partial := databasetest.NewMemoryStore()
+	if restored, err = Restore(ctx, partial, bytes.NewReader(archived), day.Add(6*time.Hour), day.Add(12*time.Hour), 0); err != nil || restored != 6 {
+		t.Errorf("Restore() of 6 hours = %d, %v, want 6", restored, err)
+	}
+
+	if _, err := Restore(ctx, target, bytes.NewReader([]byte("not gzip")), day, day.AddDate(0, 0, 1), 0); err == nil {
+		t.Error("Restore() of an invalid archive expected error")
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// BillingReader reads the consumption billings of the accounts without changing them, eg: to reconcile the billings
+// with the monitors they were generated from (mongo)
+type BillingReader interface {
+	// QueryConsumptionBillings streams the consumption billings of the namespace with the time in (startTime, endTime]
+	// to handle. The time of a billing is the end of its billing period, so these are the billings of the periods in
+	// [startTime, endTime).
+	QueryConsumptionBillings(ctx context.Context, namespace string, startTime, endTime time.Time, handle func(billing *resources.Billing) error) error
+}
@@ -0,0 +1,64 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+
+	ch "github.com/ClickHouse/clickhouse-go/v2"
+
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+// https://github.com/ClickHouse/ClickHouse/blob/master/src/Common/ErrorCodes.cpp
+const (
+	errCodeTimeoutExceeded            = 159
+	errCodeTooManySimultaneousQueries = 202
+	errCodeSocketTimeout              = 209
+	errCodeNetworkError               = 210
+	errCodeMemoryLimitExceeded        = 241
+	errCodeTooManyParts               = 252
+	errCodeReadonly                   = 164
+	errCodeUnknownUser                = 192
+	errCodeAccessDenied               = 497
+	errCodeAuthenticationFailed       = 516
+)
+
+// classifyError marks the clickhouse error as transient, config or permanent for the retry layer
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, ch.ErrAcquireConnTimeout) {
+		return retry.Transient(err)
+	}
+	var exception *ch.Exception
+	if errors.As(err, &exception) {
+		switch exception.Code {
+		case errCodeTimeoutExceeded, errCodeTooManySimultaneousQueries, errCodeSocketTimeout, errCodeNetworkError,
+			errCodeMemoryLimitExceeded, errCodeTooManyParts:
+			return retry.Transient(err)
+		case errCodeReadonly, errCodeUnknownUser, errCodeAccessDenied, errCodeAuthenticationFailed:
+			return retry.Config(err)
+		}
+	}
+	return retry.Permanent(err)
+}
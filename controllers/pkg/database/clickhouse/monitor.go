@@ -0,0 +1,385 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/logger"
+)
+
+const monitorColumns = "time, category, type, name, used, property, utilization, objstorage, tenant, labels, idempotency_key"
+
+// InsertMonitor inserts the monitors of a namespace, the small inserts of the namespaces are coalesced by the async inserts.
+// The returned error is classified by retry.IsTransient / retry.IsPermanent
+func (c *clickhouseDB) InsertMonitor(ctx context.Context, monitors ...*resources.Monitor) error {
+	return c.insertUniqueMonitors(ctx, monitors)
+}
+
+// InsertMonitorBatch inserts the monitors of many namespaces, the daily partitions are created by the server
+func (c *clickhouseDB) InsertMonitorBatch(ctx context.Context, monitors []*resources.Monitor) error {
+	return c.insertUniqueMonitors(ctx, monitors)
+}
+
+// ReplaceMonitors deletes the monitors of the idempotency keys and inserts the monitors, the monitors without a key are only inserted.
+// Clickhouse has no transactions, a failed insert after the delete is corrected by the retry of the same monitors.
+func (c *clickhouseDB) ReplaceMonitors(ctx context.Context, monitors ...*resources.Monitor) error {
+	if keys := database.IdempotencyKeys(monitors); len(keys) > 0 {
+		start, end := database.MonitorTimeRange(monitors)
+		// the time range prunes the daily partitions
+		if _, err := c.deleteMonitors(ctx, c.MonitorTable, "has(?, idempotency_key) AND time >= ? AND time <= ?", keys, start, end); err != nil {
+			return err
+		}
+	}
+	return c.insertUniqueMonitors(ctx, monitors)
+}
+
+// insertUniqueMonitors inserts the monitors whose id is not in the monitor table yet with their ids. The MergeTree has
+// no unique keys, so the stored ids in the time range of the monitors are checked before the insert,
+// the same monitors inserted concurrently are not excluded.
+func (c *clickhouseDB) insertUniqueMonitors(ctx context.Context, monitors []*resources.Monitor) error {
+	if len(monitors) == 0 {
+		return nil
+	}
+	monitors = database.UniqueMonitors(monitors)
+	start, end := database.MonitorTimeRange(monitors)
+	rows, err := c.DB.QueryContext(ctx, fmt.Sprintf("SELECT monitor_id FROM %s WHERE time >= ? AND time <= ? AND has(?, monitor_id)", c.MonitorTable),
+		start, end, database.MonitorIDs(monitors))
+	if err != nil {
+		return classifyError(err)
+	}
+	defer rows.Close()
+	stored := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return classifyError(err)
+		}
+		stored[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return classifyError(err)
+	}
+	return c.insertRows(ctx, c.MonitorTable, monitorColumns+", monitor_id", database.ExcludeStoredMonitors(monitors, stored), uniqueMonitorValues)
+}
+
+// insertMonitors splits the monitors into the inserts of at most InsertBatchSize rows,
+// the inserts before a failed one are kept, eg: a batch of the monitor writer is smaller than the default size.
+func (c *clickhouseDB) insertMonitors(ctx context.Context, table string, monitors []*resources.Monitor) error {
+	return c.insertRows(ctx, table, monitorColumns, monitors, monitorValues)
+}
+
+// insertRows inserts the monitors into the columns in the blocks of at most InsertBatchSize rows
+func (c *clickhouseDB) insertRows(ctx context.Context, table, columns string, monitors []*resources.Monitor,
+	values func(monitor *resources.Monitor) ([]interface{}, error)) error {
+	for start := 0; start < len(monitors); start += c.InsertBatchSize {
+		end := start + c.InsertBatchSize
+		if end > len(monitors) {
+			end = len(monitors)
+		}
+		if err := c.insertBlock(ctx, table, columns, monitors[start:end], values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertBlock sends the monitors as one block of the native protocol
+func (c *clickhouseDB) insertBlock(ctx context.Context, table, columns string, monitors []*resources.Monitor,
+	rowValues func(monitor *resources.Monitor) ([]interface{}, error)) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return classifyError(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (%s)", table, columns))
+	if err != nil {
+		return classifyError(err)
+	}
+	defer stmt.Close()
+	for _, monitor := range monitors {
+		values, err := rowValues(monitor)
+		if err != nil {
+			return err
+		}
+		if _, err = stmt.ExecContext(ctx, values...); err != nil {
+			return classifyError(err)
+		}
+	}
+	return classifyError(tx.Commit())
+}
+
+// monitorValues the column values of the monitor, the null fields are the empty maps and strings
+func monitorValues(monitor *resources.Monitor) ([]interface{}, error) {
+	var objStorage string
+	if monitor.ObjStorage != nil {
+		data, err := json.Marshal(monitor.ObjStorage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal objstorage of %s: %w", monitor.Name, err)
+		}
+		objStorage = string(data)
+	}
+	used, utilization := map[uint8]int64(monitor.Used), map[uint8]int64(monitor.Utilization)
+	if used == nil {
+		used = map[uint8]int64{}
+	}
+	if utilization == nil {
+		utilization = map[uint8]int64{}
+	}
+	tenant, labels := monitor.Tenant, monitor.Labels
+	if tenant == nil {
+		tenant = map[string]string{}
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return []interface{}{monitor.Time.UTC(), monitor.Category, monitor.Type, monitor.Name, used, monitor.Property, utilization, objStorage, tenant,
+		labels, monitor.IdempotencyKey}, nil
+}
+
+// uniqueMonitorValues the column values of the monitor followed by its id
+func uniqueMonitorValues(monitor *resources.Monitor) ([]interface{}, error) {
+	values, err := monitorValues(monitor)
+	if err != nil {
+		return nil, err
+	}
+	return append(values, monitor.MonitorID), nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMonitor(rows rowScanner) (*resources.Monitor, error) {
+	var (
+		monitor           resources.Monitor
+		used, utilization map[uint8]int64
+		objStorage        string
+		tenant, labels    map[string]string
+	)
+	if err := rows.Scan(&monitor.Time, &monitor.Category, &monitor.Type, &monitor.Name, &used, &monitor.Property, &utilization, &objStorage, &tenant,
+		&labels, &monitor.IdempotencyKey); err != nil {
+		return nil, fmt.Errorf("scan error: %v", err)
+	}
+	monitor.Time = monitor.Time.UTC()
+	monitor.Used = used
+	if len(utilization) > 0 {
+		monitor.Utilization = utilization
+	}
+	if len(tenant) > 0 {
+		monitor.Tenant = tenant
+	}
+	if len(labels) > 0 {
+		monitor.Labels = labels
+	}
+	if objStorage != "" {
+		monitor.ObjStorage = &resources.ObjStorageDetail{}
+		if err := json.Unmarshal([]byte(objStorage), monitor.ObjStorage); err != nil {
+			return nil, fmt.Errorf("decode objstorage error: %v", err)
+		}
+	}
+	return &monitor, nil
+}
+
+func (c *clickhouseDB) GetDistinctMonitorCombinations(startTime, endTime time.Time, namespace string) ([]resources.Monitor, error) {
+	rows, err := c.DB.QueryContext(context.Background(), fmt.Sprintf(
+		`SELECT DISTINCT category, type, name FROM %s WHERE category = ? AND time >= ? AND time < ?`, c.MonitorTable),
+		namespace, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	var monitors []resources.Monitor
+	for rows.Next() {
+		var monitor resources.Monitor
+		if err := rows.Scan(&monitor.Category, &monitor.Type, &monitor.Name); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		monitors = append(monitors, monitor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return monitors, nil
+}
+
+// QueryMonitors streams the monitors of the namespace in [startTime, endTime) sorted by time,
+// the primary key (category, type, name, time) skips the granules of the other namespaces.
+func (c *clickhouseDB) QueryMonitors(ctx context.Context, namespace string, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	return c.queryMonitors(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE category = ? AND time >= ? AND time < ? ORDER BY time`, monitorColumns, c.MonitorTable),
+		handle, namespace, startTime.UTC(), endTime.UTC())
+}
+
+// QueryMonitorPage returns a page of the monitors of the namespace sorted by (time, type, name)
+func (c *clickhouseDB) QueryMonitorPage(ctx context.Context, query database.MonitorPageQuery) (database.MonitorPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return database.MonitorPage{}, err
+	}
+	where := []string{"category = ?", "time >= ?", "time < ?"}
+	args := []interface{}{query.Namespace, query.StartTime, query.EndTime}
+	if query.Type != nil {
+		where = append(where, "type = ?")
+		args = append(args, *query.Type)
+	}
+	if after := query.After; after != nil {
+		where = append(where, "(time, type, name) > (?, ?, ?)")
+		args = append(args, after.Time, after.Type, after.Name)
+	}
+	args = append(args, query.Limit+1)
+	var monitors []resources.Monitor
+	err = c.queryMonitors(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY time, type, name LIMIT ?`,
+		monitorColumns, c.MonitorTable, strings.Join(where, " AND ")), func(monitor *resources.Monitor) error {
+		monitors = append(monitors, *monitor)
+		return nil
+	}, args...)
+	if err != nil {
+		return database.MonitorPage{}, err
+	}
+	return database.NewMonitorPage(monitors, query.Limit), nil
+}
+
+func (c *clickhouseDB) queryMonitors(ctx context.Context, query string, handle func(monitor *resources.Monitor) error, args ...interface{}) error {
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		monitor, err := scanMonitor(rows)
+		if err != nil {
+			return err
+		}
+		if err := handle(monitor); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %v", err)
+	}
+	return nil
+}
+
+// GetObjectStorageUsage returns the per bucket usage of the user in [startTime, endTime) sorted by the bucket name.
+// The buckets deleted in the period are included with the monitors before the deletion.
+func (c *clickhouseDB) GetObjectStorageUsage(user string, startTime, endTime time.Time) ([]resources.ObjStorageBucketUsage, error) {
+	rows, err := c.DB.QueryContext(context.Background(), fmt.Sprintf(`SELECT name, enum, sum(used[enum]), min(time), max(time),
+	argMaxIf(objstorage, time, objstorage != '')
+FROM %s ARRAY JOIN mapKeys(used) AS enum
+WHERE category = ? AND type = ? AND time >= ? AND time < ?
+GROUP BY name, enum
+ORDER BY name`, c.MonitorTable),
+		"ns-"+user, resources.AppType[resources.ObjectStorage], startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	var result []resources.ObjStorageBucketUsage
+	for rows.Next() {
+		var (
+			bucket, detail string
+			enum           uint8
+			value          int64
+			first, last    time.Time
+		)
+		if err := rows.Scan(&bucket, &enum, &value, &first, &last, &detail); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		if len(result) == 0 || result[len(result)-1].Bucket != bucket {
+			result = append(result, resources.ObjStorageBucketUsage{Bucket: bucket, Used: resources.EnumUsedMap{}, FirstSeen: first.UTC(), LastSeen: last.UTC()})
+		}
+		usage := &result[len(result)-1]
+		usage.Used[enum] = value
+		if first.Before(usage.FirstSeen) {
+			usage.FirstSeen = first.UTC()
+		}
+		if last.After(usage.LastSeen) {
+			usage.LastSeen = last.UTC()
+		}
+		if detail != "" && usage.Detail == nil {
+			usage.Detail = &resources.ObjStorageDetail{}
+			if err := json.Unmarshal([]byte(detail), usage.Detail); err != nil {
+				return nil, fmt.Errorf("decode objstorage error: %v", err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return result, nil
+}
+
+// categoryTables returns the tables keeping the monitors of a category: the monitors, the rollups and the aggregates
+func (c *clickhouseDB) categoryTables() []string {
+	tables := []string{c.MonitorTable, c.rollupTable()}
+	for _, granularity := range database.MonitorGranularities {
+		tables = append(tables, c.aggregateTable(granularity))
+	}
+	return tables
+}
+
+// CountMonitorsByCategory counts the monitors, the rollups and the aggregates of the category (namespace)
+func (c *clickhouseDB) CountMonitorsByCategory(category string) (int64, error) {
+	var total int64
+	for _, table := range c.categoryTables() {
+		var count uint64
+		if err := c.DB.QueryRowContext(context.Background(), fmt.Sprintf("SELECT count() FROM %s WHERE category = ?", table), category).Scan(&count); err != nil {
+			return total, fmt.Errorf("failed to count monitors of %s in %s: %w", category, table, classifyError(err))
+		}
+		total += int64(count)
+	}
+	return total, nil
+}
+
+// DeleteMonitorsByCategory deletes the monitors, the rollups and the aggregates of the category (namespace) by lightweight
+// deletes. They are not split by database.PurgeBatchSize: a lightweight delete only masks the rows and the parts are
+// rewritten by the background merges, so it doesn't lock the table however many rows are deleted.
+func (c *clickhouseDB) DeleteMonitorsByCategory(category string) (int64, error) {
+	var total int64
+	for _, table := range c.categoryTables() {
+		deleted, err := c.deleteMonitors(context.Background(), table, "category = ?", category)
+		total += deleted
+		if err != nil {
+			return total, fmt.Errorf("failed to delete monitors of %s from %s: %w", category, table, err)
+		}
+	}
+	if total > 0 {
+		logger.Info("deleted monitors", "category", category, "count", total)
+	}
+	return total, nil
+}
+
+// deleteMonitors returns the number of the rows deleted, the lightweight delete doesn't report it, so they are counted first
+func (c *clickhouseDB) deleteMonitors(ctx context.Context, table, where string, args ...interface{}) (int64, error) {
+	var count uint64
+	if err := c.DB.QueryRowContext(ctx, fmt.Sprintf("SELECT count() FROM %s WHERE %s", table, where), args...).Scan(&count); err != nil {
+		return 0, classifyError(err)
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	if _, err := c.DB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", table, where), args...); err != nil {
+		return 0, classifyError(err)
+	}
+	return int64(count), nil
+}
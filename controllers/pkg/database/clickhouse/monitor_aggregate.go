@@ -0,0 +1,68 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// SaveMonitorAggregates inserts the aggregates of the period, then advances the latest period.
+// The aggregates of a re-run period are replaced by the merges of the ReplacingMergeTree, the reads use FINAL until then.
+func (c *clickhouseDB) SaveMonitorAggregates(ctx context.Context, granularity database.MonitorGranularity, start time.Time, aggregates []*resources.Monitor) error {
+	if err := granularity.Validate(); err != nil {
+		return err
+	}
+	if err := c.insertMonitors(ctx, c.aggregateTable(granularity), aggregates); err != nil {
+		return err
+	}
+	_, err := c.DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (granularity, latest, updated_at) VALUES (?, ?, ?)`, c.aggregateStateTable()),
+		string(granularity), start.UTC(), time.Now().UTC())
+	return classifyError(err)
+}
+
+// QueryMonitorAggregates streams the aggregates of all namespaces in [startTime, endTime) sorted by time
+func (c *clickhouseDB) QueryMonitorAggregates(ctx context.Context, granularity database.MonitorGranularity, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	if err := granularity.Validate(); err != nil {
+		return err
+	}
+	return c.queryMonitors(ctx, fmt.Sprintf(`SELECT %s FROM %s FINAL WHERE time >= ? AND time < ? ORDER BY time`,
+		monitorColumns, c.aggregateTable(granularity)), handle, startTime.UTC(), endTime.UTC())
+}
+
+// LatestMonitorAggregate returns the start of the latest aggregated period of the granularity, zero if none.
+// The re-aggregated periods insert older states, the latest is the max of them.
+func (c *clickhouseDB) LatestMonitorAggregate(ctx context.Context, granularity database.MonitorGranularity) (time.Time, error) {
+	if err := granularity.Validate(); err != nil {
+		return time.Time{}, err
+	}
+	var (
+		count  uint64
+		latest time.Time
+	)
+	err := c.DB.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(), max(latest) FROM %s WHERE granularity = ?`, c.aggregateStateTable()),
+		string(granularity)).Scan(&count, &latest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get the latest %s aggregate: %w", granularity, err)
+	}
+	if count == 0 {
+		return time.Time{}, nil
+	}
+	return latest.UTC(), nil
+}
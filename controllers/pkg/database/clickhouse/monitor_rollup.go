@@ -0,0 +1,56 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// QueryMonitorsInRange streams the minute monitors of all namespaces in [startTime, endTime)
+func (c *clickhouseDB) QueryMonitorsInRange(ctx context.Context, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	return c.queryMonitors(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE time >= ? AND time < ?`, monitorColumns, c.MonitorTable),
+		handle, startTime.UTC(), endTime.UTC())
+}
+
+// IsMonitorRollupDone returns true if the rollups of the hour were saved
+func (c *clickhouseDB) IsMonitorRollupDone(ctx context.Context, hour time.Time) (bool, error) {
+	var count uint64
+	err := c.DB.QueryRowContext(ctx, fmt.Sprintf(`SELECT count() FROM %s WHERE hour = ?`, c.rollupStateTable()), hour.UTC()).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to get the rollup state of %s: %w", hour.UTC().Format(time.RFC3339), err)
+	}
+	return count > 0, nil
+}
+
+// SaveMonitorRollups inserts the rollups of the hour, then marks the hour done.
+// The tables have no transactions across them, if it fails before the mark, the next run recomputes the hour
+// and inserts the same rollups again, the duplicates are replaced by the merges of the ReplacingMergeTree.
+func (c *clickhouseDB) SaveMonitorRollups(ctx context.Context, hour time.Time, rollups []*resources.Monitor) error {
+	if err := c.insertMonitors(ctx, c.rollupTable(), rollups); err != nil {
+		return err
+	}
+	_, err := c.DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (hour, rollups, updated_at) VALUES (?, ?, ?)`, c.rollupStateTable()),
+		hour.UTC(), uint32(len(rollups)), time.Now().UTC())
+	return classifyError(err)
+}
+
+// DeleteMonitorsInRange deletes the minute monitors of all namespaces in [startTime, endTime)
+func (c *clickhouseDB) DeleteMonitorsInRange(ctx context.Context, startTime, endTime time.Time) (int64, error) {
+	return c.deleteMonitors(ctx, c.MonitorTable, "time >= ? AND time < ?", startTime.UTC(), endTime.UTC())
+}
@@ -0,0 +1,106 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+// GetNamespaceUsage returns the used resources of all monitors of the namespace in [startTime, endTime),
+// the sums read the category prefix of the (category, type, name, time) order key
+func (c *clickhouseDB) GetNamespaceUsage(category string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	return c.sumUsage(database.UsageQuery{Category: category, Start: startTime, End: endTime})
+}
+
+// GetUsageByApp returns the used resources of the app of the namespace in [startTime, endTime), appType is a key of resources.AppType
+func (c *clickhouseDB) GetUsageByApp(category, appType, appName string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	q, err := database.NewAppUsageQuery(category, appType, appName, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	return c.sumUsage(q)
+}
+
+func (c *clickhouseDB) sumUsage(q database.UsageQuery) (map[uint8]int64, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	var (
+		count    uint64
+		earliest time.Time
+	)
+	if err := c.DB.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(), min(time) FROM %s WHERE category = ?`, c.aggregateTable(database.MonitorHourly)),
+		q.Category).Scan(&count, &earliest); err != nil {
+		return nil, fmt.Errorf("failed to get the earliest hourly aggregate of %s: %w", q.Category, err)
+	}
+	if count == 0 {
+		earliest = time.Time{}
+	}
+	latest, err := c.LatestMonitorAggregate(ctx, database.MonitorHourly)
+	if err != nil {
+		return nil, err
+	}
+	return database.SumUsage(ctx, q, earliest.UTC(), latest, c.sumUsageSpan)
+}
+
+// sumUsageSpan sums the used of the span, the rollups and the aggregates are read with FINAL so a re-run period is counted once
+func (c *clickhouseDB) sumUsageSpan(ctx context.Context, q database.UsageQuery, span database.UsageSpan) (map[uint8]int64, error) {
+	var from string
+	switch span.Source {
+	case database.UsageMinute:
+		from = c.MonitorTable
+	case database.UsageRollup:
+		from = c.rollupTable() + " FINAL"
+	case database.UsageHourly:
+		from = c.aggregateTable(database.MonitorHourly) + " FINAL"
+	default:
+		return nil, fmt.Errorf("unknown usage source %s", span.Source)
+	}
+	where := `category = ? AND time >= ? AND time < ?`
+	args := []interface{}{q.Category, span.Start, span.End}
+	if q.Type != nil {
+		where += ` AND type = ? AND name = ?`
+		args = append(args, *q.Type, q.Name)
+	}
+	rows, err := c.DB.QueryContext(ctx, fmt.Sprintf(`SELECT enum, sum(used[enum])
+FROM %s ARRAY JOIN mapKeys(used) AS enum
+WHERE %s
+GROUP BY enum`, from, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	used := make(map[uint8]int64)
+	for rows.Next() {
+		var (
+			enum  uint8
+			value int64
+		)
+		if err := rows.Scan(&enum, &value); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		used[enum] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return used, nil
+}
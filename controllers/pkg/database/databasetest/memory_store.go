@@ -0,0 +1,693 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package databasetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+// ErrInjected the default error of the calls failed by MemoryStore.FailNext, classified as transient
+var ErrInjected = retry.Transient(errors.New("injected failure"))
+
+// TrafficRecord the bytes sent and received by a pod of an app at the time, seeded by MemoryStore.AddTraffic
+type TrafficRecord struct {
+	Time      time.Time
+	Namespace string
+	Type      uint8
+	Name      string
+	Pod       string
+	Sent      int64
+	Recv      int64
+}
+
+// MemoryStore an in-memory database.MonitorStore, database.Traffic, database.TrafficPurger and database.Pinger for the
+// tests of the controllers, it passes RunMonitorStore. The monitors are copied in and out, the daily collections of the
+// retention are the UTC days of the monitors. FailNext and SetLatency inject failures and latency into the calls to
+// exercise the retries and the timeouts.
+type MemoryStore struct {
+	mu         sync.Mutex
+	monitors   []*resources.Monitor
+	rollups    map[string]*resources.Monitor
+	rolledUp   map[time.Time]bool
+	aggregates map[database.MonitorGranularity][]*resources.Monitor
+	latest     map[database.MonitorGranularity]time.Time
+	traffic    []TrafficRecord
+	gapReports []*database.MonitorGapReport
+
+	failures int
+	failErr  error
+	latency  time.Duration
+	calls    map[string]int
+}
+
+var (
+	_ database.MonitorStore  = &MemoryStore{}
+	_ database.Traffic       = &MemoryStore{}
+	_ database.TrafficPurger = &MemoryStore{}
+	_ database.Pinger        = &MemoryStore{}
+
+	_ database.MonitorGapStore = &MemoryStore{}
+)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		rollups:    map[string]*resources.Monitor{},
+		rolledUp:   map[time.Time]bool{},
+		aggregates: map[database.MonitorGranularity][]*resources.Monitor{},
+		latest:     map[database.MonitorGranularity]time.Time{},
+		calls:      map[string]int{},
+	}
+}
+
+// FailNext fails the next n calls with err, ErrInjected if nil
+func (s *MemoryStore) FailNext(n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		err = ErrInjected
+	}
+	s.failures, s.failErr = n, err
+}
+
+// SetLatency delays every call, the calls with a context return its error once it's done
+func (s *MemoryStore) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+// Calls returns the number of the calls of the method, including the failed ones, eg: Calls("InsertMonitor")
+func (s *MemoryStore) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// Monitors returns copies of the stored minute monitors sorted by time
+func (s *MemoryStore) Monitors() []*resources.Monitor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	monitors := copyMonitors(s.monitors)
+	sortByTime(monitors)
+	return monitors
+}
+
+// AddTraffic seeds the traffic records read by the database.Traffic methods
+func (s *MemoryStore) AddTraffic(records ...TrafficRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.traffic = append(s.traffic, records...)
+}
+
+// call counts the call of the method and injects the latency and the failure, the lock is held once it returns nil
+func (s *MemoryStore) call(ctx context.Context, method string) error {
+	s.mu.Lock()
+	s.calls[method]++
+	latency := s.latency
+	s.mu.Unlock()
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return retry.Transient(ctx.Err())
+		}
+	}
+	s.mu.Lock()
+	if s.failures > 0 {
+		s.failures--
+		err := s.failErr
+		s.mu.Unlock()
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	return nil
+}
+
+func copyMonitor(monitor *resources.Monitor) *resources.Monitor {
+	m := *monitor
+	m.Time = m.Time.UTC()
+	if monitor.Used != nil {
+		m.Used = make(resources.EnumUsedMap, len(monitor.Used))
+		for enum, used := range monitor.Used {
+			m.Used[enum] = used
+		}
+	}
+	return &m
+}
+
+func copyMonitors(monitors []*resources.Monitor) []*resources.Monitor {
+	copies := make([]*resources.Monitor, len(monitors))
+	for i, monitor := range monitors {
+		copies[i] = copyMonitor(monitor)
+	}
+	return copies
+}
+
+func sortByTime(monitors []*resources.Monitor) {
+	sort.SliceStable(monitors, func(i, j int) bool {
+		return monitors[i].Time.Before(monitors[j].Time)
+	})
+}
+
+func inRange(t, startTime, endTime time.Time) bool {
+	return !t.Before(startTime) && t.Before(endTime)
+}
+
+// InsertMonitor inserts the monitors, the monitors already stored under the same id are skipped
+func (s *MemoryStore) InsertMonitor(ctx context.Context, monitors ...*resources.Monitor) error {
+	if err := s.call(ctx, "InsertMonitor"); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	s.insertLocked(monitors)
+	return nil
+}
+
+func (s *MemoryStore) InsertMonitorBatch(ctx context.Context, monitors []*resources.Monitor) error {
+	if err := s.call(ctx, "InsertMonitorBatch"); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	s.insertLocked(monitors)
+	return nil
+}
+
+func (s *MemoryStore) insertLocked(monitors []*resources.Monitor) {
+	stored := make(map[string]bool, len(s.monitors))
+	for _, monitor := range s.monitors {
+		stored[monitor.MonitorID] = true
+	}
+	for _, monitor := range database.ExcludeStoredMonitors(database.UniqueMonitors(monitors), stored) {
+		s.monitors = append(s.monitors, copyMonitor(monitor))
+	}
+}
+
+// ReplaceMonitors deletes the monitors of the idempotency keys and inserts the monitors
+func (s *MemoryStore) ReplaceMonitors(ctx context.Context, monitors ...*resources.Monitor) error {
+	if err := s.call(ctx, "ReplaceMonitors"); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	keys := make(map[string]bool)
+	for _, key := range database.IdempotencyKeys(monitors) {
+		keys[key] = true
+	}
+	s.deleteLocked(func(monitor *resources.Monitor) bool {
+		return keys[monitor.IdempotencyKey]
+	})
+	s.insertLocked(monitors)
+	return nil
+}
+
+// deleteLocked deletes the minute monitors matched and returns the number deleted
+func (s *MemoryStore) deleteLocked(match func(monitor *resources.Monitor) bool) int {
+	kept := s.monitors[:0]
+	for _, monitor := range s.monitors {
+		if !match(monitor) {
+			kept = append(kept, monitor)
+		}
+	}
+	deleted := len(s.monitors) - len(kept)
+	s.monitors = kept
+	return deleted
+}
+
+func (s *MemoryStore) GetDistinctMonitorCombinations(startTime, endTime time.Time, namespace string) ([]resources.Monitor, error) {
+	if err := s.call(context.Background(), "GetDistinctMonitorCombinations"); err != nil {
+		return nil, err
+	}
+	defer s.mu.Unlock()
+	var combinations []resources.Monitor
+	seen := make(map[string]bool)
+	for _, monitor := range s.monitors {
+		if monitor.Category != namespace || !inRange(monitor.Time, startTime, endTime) {
+			continue
+		}
+		if key := fmt.Sprintf("%d/%s", monitor.Type, monitor.Name); !seen[key] {
+			seen[key] = true
+			combinations = append(combinations, resources.Monitor{Category: monitor.Category, Type: monitor.Type, Name: monitor.Name})
+		}
+	}
+	return combinations, nil
+}
+
+// QueryMonitors streams the monitors of the namespace in [startTime, endTime) sorted by time, the handle is called
+// without the lock held so it may call the store
+func (s *MemoryStore) QueryMonitors(ctx context.Context, namespace string, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	return s.query(ctx, "QueryMonitors", func(monitor *resources.Monitor) bool {
+		return monitor.Category == namespace && inRange(monitor.Time, startTime, endTime)
+	}, handle)
+}
+
+func (s *MemoryStore) QueryMonitorsInRange(ctx context.Context, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	return s.query(ctx, "QueryMonitorsInRange", func(monitor *resources.Monitor) bool {
+		return inRange(monitor.Time, startTime, endTime)
+	}, handle)
+}
+
+func (s *MemoryStore) query(ctx context.Context, method string, match func(monitor *resources.Monitor) bool, handle func(monitor *resources.Monitor) error) error {
+	if err := s.call(ctx, method); err != nil {
+		return err
+	}
+	var matched []*resources.Monitor
+	for _, monitor := range s.monitors {
+		if match(monitor) {
+			matched = append(matched, copyMonitor(monitor))
+		}
+	}
+	s.mu.Unlock()
+	sortByTime(matched)
+	for _, monitor := range matched {
+		if err := handle(monitor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) QueryMonitorPage(ctx context.Context, query database.MonitorPageQuery) (database.MonitorPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return database.MonitorPage{}, err
+	}
+	if err := s.call(ctx, "QueryMonitorPage"); err != nil {
+		return database.MonitorPage{}, err
+	}
+	var matched []resources.Monitor
+	for _, monitor := range s.monitors {
+		if monitor.Category != query.Namespace || !inRange(monitor.Time, query.StartTime, query.EndTime) ||
+			query.Type != nil && monitor.Type != *query.Type || query.After != nil && !query.After.Less(*database.CursorOf(monitor)) {
+			continue
+		}
+		matched = append(matched, *copyMonitor(monitor))
+	}
+	s.mu.Unlock()
+	sort.Slice(matched, func(i, j int) bool {
+		return database.CursorOf(&matched[i]).Less(*database.CursorOf(&matched[j]))
+	})
+	if len(matched) > query.Limit+1 {
+		matched = matched[:query.Limit+1]
+	}
+	return database.NewMonitorPage(matched, query.Limit), nil
+}
+
+// GetObjectStorageUsage returns the per bucket usage of the user in [startTime, endTime) sorted by the bucket name,
+// the detail is the one of the latest monitor of the bucket
+func (s *MemoryStore) GetObjectStorageUsage(user string, startTime, endTime time.Time) ([]resources.ObjStorageBucketUsage, error) {
+	if err := s.call(context.Background(), "GetObjectStorageUsage"); err != nil {
+		return nil, err
+	}
+	defer s.mu.Unlock()
+	namespace, bucket := "ns-"+user, resources.AppType[resources.ObjectStorage]
+	buckets := make(map[string]*resources.ObjStorageBucketUsage)
+	detailTime := make(map[string]time.Time)
+	for _, monitor := range s.monitors {
+		if monitor.Category != namespace || monitor.Type != bucket || !inRange(monitor.Time, startTime, endTime) {
+			continue
+		}
+		usage := buckets[monitor.Name]
+		if usage == nil {
+			usage = &resources.ObjStorageBucketUsage{Bucket: monitor.Name, Used: resources.EnumUsedMap{}, FirstSeen: monitor.Time, LastSeen: monitor.Time}
+			buckets[monitor.Name] = usage
+		}
+		for enum, used := range monitor.Used {
+			usage.Used[enum] += used
+		}
+		if monitor.Time.Before(usage.FirstSeen) {
+			usage.FirstSeen = monitor.Time
+		}
+		if monitor.Time.After(usage.LastSeen) {
+			usage.LastSeen = monitor.Time
+		}
+		if monitor.ObjStorage != nil && (usage.Detail == nil || monitor.Time.After(detailTime[monitor.Name])) {
+			detail := *monitor.ObjStorage
+			usage.Detail, detailTime[monitor.Name] = &detail, monitor.Time
+		}
+	}
+	result := make([]resources.ObjStorageBucketUsage, 0, len(buckets))
+	for _, usage := range buckets {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Bucket < result[j].Bucket
+	})
+	return result, nil
+}
+
+// DropMonitorCollectionsOlderThan drops the monitors of the UTC days before the cutoff day and returns the number of the days
+func (s *MemoryStore) DropMonitorCollectionsOlderThan(days int) (int, error) {
+	if err := s.call(context.Background(), "DropMonitorCollectionsOlderThan"); err != nil {
+		return 0, err
+	}
+	defer s.mu.Unlock()
+	cutoffDate := time.Now().UTC().AddDate(0, 0, -days).Truncate(24 * time.Hour)
+	dropped := make(map[time.Time]bool)
+	s.deleteLocked(func(monitor *resources.Monitor) bool {
+		if day := monitor.Time.Truncate(24 * time.Hour); day.Before(cutoffDate) {
+			dropped[day] = true
+			return true
+		}
+		return false
+	})
+	return len(dropped), nil
+}
+
+// CountMonitorsByCategory counts the minute monitors, the rollups and the aggregates of the category
+func (s *MemoryStore) CountMonitorsByCategory(category string) (int64, error) {
+	if err := s.call(context.Background(), "CountMonitorsByCategory"); err != nil {
+		return 0, err
+	}
+	defer s.mu.Unlock()
+	return s.purgeCategoryLocked(category, false), nil
+}
+
+// DeleteMonitorsByCategory deletes the minute monitors, the rollups and the aggregates of the category
+func (s *MemoryStore) DeleteMonitorsByCategory(category string) (int64, error) {
+	if err := s.call(context.Background(), "DeleteMonitorsByCategory"); err != nil {
+		return 0, err
+	}
+	defer s.mu.Unlock()
+	return s.purgeCategoryLocked(category, true), nil
+}
+
+// purgeCategoryLocked counts the records of the category, and deletes them if remove
+func (s *MemoryStore) purgeCategoryLocked(category string, remove bool) int64 {
+	var count int64
+	kept := s.monitors[:0]
+	for _, monitor := range s.monitors {
+		if monitor.Category == category {
+			count++
+			if remove {
+				continue
+			}
+		}
+		kept = append(kept, monitor)
+	}
+	s.monitors = kept
+	for key, rollup := range s.rollups {
+		if rollup.Category == category {
+			count++
+			if remove {
+				delete(s.rollups, key)
+			}
+		}
+	}
+	for granularity, aggregates := range s.aggregates {
+		kept := aggregates[:0]
+		for _, aggregate := range aggregates {
+			if aggregate.Category == category {
+				count++
+				if remove {
+					continue
+				}
+			}
+			kept = append(kept, aggregate)
+		}
+		s.aggregates[granularity] = kept
+	}
+	return count
+}
+
+func (s *MemoryStore) IsMonitorRollupDone(ctx context.Context, hour time.Time) (bool, error) {
+	if err := s.call(ctx, "IsMonitorRollupDone"); err != nil {
+		return false, err
+	}
+	defer s.mu.Unlock()
+	return s.rolledUp[hour.UTC()], nil
+}
+
+// SaveMonitorRollups upserts the rollups of the hour by (category, type, name, time) and marks the hour done
+func (s *MemoryStore) SaveMonitorRollups(ctx context.Context, hour time.Time, rollups []*resources.Monitor) error {
+	if err := s.call(ctx, "SaveMonitorRollups"); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	for _, rollup := range rollups {
+		key := fmt.Sprintf("%s/%d/%s/%s", rollup.Category, rollup.Type, rollup.Name, rollup.Time.UTC().Format(time.RFC3339))
+		s.rollups[key] = copyMonitor(rollup)
+	}
+	s.rolledUp[hour.UTC()] = true
+	return nil
+}
+
+func (s *MemoryStore) DeleteMonitorsInRange(ctx context.Context, startTime, endTime time.Time) (int64, error) {
+	if err := s.call(ctx, "DeleteMonitorsInRange"); err != nil {
+		return 0, err
+	}
+	defer s.mu.Unlock()
+	return int64(s.deleteLocked(func(monitor *resources.Monitor) bool {
+		return inRange(monitor.Time, startTime, endTime)
+	})), nil
+}
+
+// SaveMonitorAggregates replaces the aggregates of the period and advances the latest period
+func (s *MemoryStore) SaveMonitorAggregates(ctx context.Context, granularity database.MonitorGranularity, start time.Time, aggregates []*resources.Monitor) error {
+	if err := granularity.Validate(); err != nil {
+		return err
+	}
+	if err := s.call(ctx, "SaveMonitorAggregates"); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	start = start.UTC()
+	kept := s.aggregates[granularity][:0]
+	for _, aggregate := range s.aggregates[granularity] {
+		if !aggregate.Time.Equal(start) {
+			kept = append(kept, aggregate)
+		}
+	}
+	s.aggregates[granularity] = append(kept, copyMonitors(aggregates)...)
+	if start.After(s.latest[granularity]) {
+		s.latest[granularity] = start
+	}
+	return nil
+}
+
+func (s *MemoryStore) QueryMonitorAggregates(ctx context.Context, granularity database.MonitorGranularity, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	if err := granularity.Validate(); err != nil {
+		return err
+	}
+	if err := s.call(ctx, "QueryMonitorAggregates"); err != nil {
+		return err
+	}
+	var matched []*resources.Monitor
+	for _, aggregate := range s.aggregates[granularity] {
+		if inRange(aggregate.Time, startTime, endTime) {
+			matched = append(matched, copyMonitor(aggregate))
+		}
+	}
+	s.mu.Unlock()
+	sortByTime(matched)
+	for _, monitor := range matched {
+		if err := handle(monitor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) LatestMonitorAggregate(ctx context.Context, granularity database.MonitorGranularity) (time.Time, error) {
+	if err := granularity.Validate(); err != nil {
+		return time.Time{}, err
+	}
+	if err := s.call(ctx, "LatestMonitorAggregate"); err != nil {
+		return time.Time{}, err
+	}
+	defer s.mu.Unlock()
+	return s.latest[granularity], nil
+}
+
+func (s *MemoryStore) SaveMonitorGapReport(ctx context.Context, report *database.MonitorGapReport) error {
+	if err := s.call(ctx, "SaveMonitorGapReport"); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	saved := *report
+	saved.Gaps = append([]database.NamespaceMonitorGaps(nil), report.Gaps...)
+	s.gapReports = append(s.gapReports, &saved)
+	return nil
+}
+
+// GapReports returns the monitor gap reports saved, in the order of the saves
+func (s *MemoryStore) GapReports() []*database.MonitorGapReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*database.MonitorGapReport(nil), s.gapReports...)
+}
+
+func (s *MemoryStore) GetNamespaceUsage(category string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	return s.sumUsage(database.UsageQuery{Category: category, Start: startTime, End: endTime})
+}
+
+func (s *MemoryStore) GetUsageByApp(category, appType, appName string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	q, err := database.NewAppUsageQuery(category, appType, appName, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	return s.sumUsage(q)
+}
+
+// sumUsage sums the usage like the stores: the hourly aggregates of the namespace are preferred to the minute monitors
+func (s *MemoryStore) sumUsage(q database.UsageQuery) (map[uint8]int64, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.call(context.Background(), "GetUsage"); err != nil {
+		return nil, err
+	}
+	var earliest time.Time
+	for _, aggregate := range s.aggregates[database.MonitorHourly] {
+		if aggregate.Category == q.Category && (earliest.IsZero() || aggregate.Time.Before(earliest)) {
+			earliest = aggregate.Time
+		}
+	}
+	latest := s.latest[database.MonitorHourly]
+	s.mu.Unlock()
+	return database.SumUsage(context.Background(), q, earliest, latest, s.sumUsageSpan)
+}
+
+func (s *MemoryStore) sumUsageSpan(_ context.Context, q database.UsageQuery, span database.UsageSpan) (map[uint8]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var monitors []*resources.Monitor
+	switch span.Source {
+	case database.UsageMinute:
+		monitors = s.monitors
+	case database.UsageHourly:
+		monitors = s.aggregates[database.MonitorHourly]
+	case database.UsageRollup:
+		for _, rollup := range s.rollups {
+			monitors = append(monitors, rollup)
+		}
+	default:
+		return nil, fmt.Errorf("unknown usage source %s", span.Source)
+	}
+	used := make(map[uint8]int64)
+	for _, monitor := range monitors {
+		if monitor.Category != q.Category || !inRange(monitor.Time, span.Start, span.End) ||
+			q.Type != nil && (monitor.Type != *q.Type || monitor.Name != q.Name) {
+			continue
+		}
+		for enum, v := range monitor.Used {
+			used[enum] += v
+		}
+	}
+	return used, nil
+}
+
+func (s *MemoryStore) CreateMonitorTimeSeriesIfNotExist(_ time.Time) error {
+	return nil
+}
+
+func (s *MemoryStore) InitDefaultPropertyTypeLS() error {
+	return nil
+}
+
+// Ping fails with the failures injected by FailNext, so the tests simulate the lost connection
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	if err := s.call(ctx, "Ping"); err != nil {
+		return err
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Disconnect is counted by Calls("Disconnect"), it's not failed by FailNext
+func (s *MemoryStore) Disconnect(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls["Disconnect"]++
+	return nil
+}
+
+func (s *MemoryStore) GetTrafficSentBytes(startTime, endTime time.Time, namespace string, _type uint8, name string) (int64, error) {
+	return s.sumTraffic("GetTrafficSentBytes", startTime, endTime, func(record TrafficRecord) (int64, bool) {
+		return record.Sent, record.Namespace == namespace && record.Type == _type && record.Name == name
+	})
+}
+
+func (s *MemoryStore) GetTrafficRecvBytes(startTime, endTime time.Time, namespace string, _type uint8, name string) (int64, error) {
+	return s.sumTraffic("GetTrafficRecvBytes", startTime, endTime, func(record TrafficRecord) (int64, bool) {
+		return record.Recv, record.Namespace == namespace && record.Type == _type && record.Name == name
+	})
+}
+
+func (s *MemoryStore) GetPodTrafficSentBytes(startTime, endTime time.Time, namespace string, name string) (int64, error) {
+	return s.sumTraffic("GetPodTrafficSentBytes", startTime, endTime, func(record TrafficRecord) (int64, bool) {
+		return record.Sent, record.Namespace == namespace && record.Pod == name
+	})
+}
+
+func (s *MemoryStore) GetPodTrafficRecvBytes(startTime, endTime time.Time, namespace string, name string) (int64, error) {
+	return s.sumTraffic("GetPodTrafficRecvBytes", startTime, endTime, func(record TrafficRecord) (int64, bool) {
+		return record.Recv, record.Namespace == namespace && record.Pod == name
+	})
+}
+
+// CountTrafficByNamespace counts the seeded traffic records of the namespace
+func (s *MemoryStore) CountTrafficByNamespace(namespace string) (int64, error) {
+	return s.purgeTraffic("CountTrafficByNamespace", namespace, false)
+}
+
+// DeleteTrafficByNamespace deletes the seeded traffic records of the namespace
+func (s *MemoryStore) DeleteTrafficByNamespace(namespace string) (int64, error) {
+	return s.purgeTraffic("DeleteTrafficByNamespace", namespace, true)
+}
+
+func (s *MemoryStore) purgeTraffic(method, namespace string, remove bool) (int64, error) {
+	if err := s.call(context.Background(), method); err != nil {
+		return 0, err
+	}
+	defer s.mu.Unlock()
+	var count int64
+	kept := s.traffic[:0]
+	for _, record := range s.traffic {
+		if record.Namespace == namespace {
+			count++
+			if remove {
+				continue
+			}
+		}
+		kept = append(kept, record)
+	}
+	s.traffic = kept
+	return count, nil
+}
+
+// sumTraffic sums the bytes of the records matched in [startTime, endTime)
+func (s *MemoryStore) sumTraffic(method string, startTime, endTime time.Time, match func(record TrafficRecord) (int64, bool)) (int64, error) {
+	if err := s.call(context.Background(), method); err != nil {
+		return 0, err
+	}
+	defer s.mu.Unlock()
+	var bytes int64
+	for _, record := range s.traffic {
+		if v, ok := match(record); ok && inRange(record.Time, startTime, endTime) {
+			bytes += v
+		}
+	}
+	return bytes, nil
+}
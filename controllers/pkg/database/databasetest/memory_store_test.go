@@ -0,0 +1,86 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package databasetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+func TestMemoryStore(t *testing.T) {
+	RunMonitorStore(t, NewMemoryStore())
+}
+
+func TestMemoryStore_FailNext(t *testing.T) {
+	store := NewMemoryStore()
+	monitor := &resources.Monitor{Time: FixtureTime, Category: "ns-a", Name: "app-a", Used: resources.EnumUsedMap{cpu: 1}}
+	store.FailNext(2, nil)
+	for i := 0; i < 2; i++ {
+		if err := store.InsertMonitor(context.Background(), monitor); !retry.IsTransient(err) || !errors.Is(err, ErrInjected) {
+			t.Fatalf("InsertMonitor() call %d error = %v, want the injected transient error", i, err)
+		}
+	}
+	if err := store.InsertMonitor(context.Background(), monitor); err != nil {
+		t.Fatalf("InsertMonitor() after the failures error = %v", err)
+	}
+	if got := store.Calls("InsertMonitor"); got != 3 {
+		t.Errorf("Calls() = %d, want 3", got)
+	}
+	if got := store.Monitors(); len(got) != 1 {
+		t.Errorf("Monitors() = %d, want 1", len(got))
+	}
+
+	permanent := retry.Permanent(errors.New("bad document"))
+	store.FailNext(1, permanent)
+	if _, err := store.GetNamespaceUsage("ns-a", FixtureTime, FixtureTime.Add(time.Hour)); !errors.Is(err, permanent) {
+		t.Errorf("GetNamespaceUsage() error = %v, want %v", err, permanent)
+	}
+}
+
+func TestMemoryStore_SetLatency(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := store.InsertMonitor(ctx, &resources.Monitor{Time: FixtureTime, Category: "ns-a", Name: "app-a"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("InsertMonitor() error = %v, want the deadline exceeded", err)
+	}
+	if got := store.Monitors(); len(got) != 0 {
+		t.Errorf("Monitors() = %d, want none inserted after the timeout", len(got))
+	}
+}
+
+func TestMemoryStore_Traffic(t *testing.T) {
+	store := NewMemoryStore()
+	app := resources.AppType[resources.APP]
+	store.AddTraffic(
+		TrafficRecord{Time: FixtureTime, Namespace: "ns-a", Type: app, Name: "app-a", Pod: "app-a-0", Sent: 100, Recv: 10},
+		TrafficRecord{Time: FixtureTime.Add(time.Minute), Namespace: "ns-a", Type: app, Name: "app-a", Pod: "app-a-1", Sent: 200, Recv: 20},
+		// the end of the range is excluded
+		TrafficRecord{Time: FixtureTime.Add(time.Hour), Namespace: "ns-a", Type: app, Name: "app-a", Pod: "app-a-0", Sent: 400, Recv: 40},
+	)
+	end := FixtureTime.Add(time.Hour)
+	if sent, err := store.GetTrafficSentBytes(FixtureTime, end, "ns-a", app, "app-a"); err != nil || sent != 300 {
+		t.Errorf("GetTrafficSentBytes() = %d, %v, want 300", sent, err)
+	}
+	if recv, err := store.GetPodTrafficRecvBytes(FixtureTime, end, "ns-a", "app-a-0"); err != nil || recv != 10 {
+		t.Errorf("GetPodTrafficRecvBytes() = %d, %v, want 10", recv, err)
+	}
+}
@@ -0,0 +1,538 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package databasetest the conformance suite of the monitor stores, every database.MonitorStore must pass it
+// against a live database, eg: the mongo and the postgres integration tests. MemoryStore is the in-memory store
+// passing the suite, for the tests of the controllers without a database.
+package databasetest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// FixtureUser the user of the fixtures, the monitors are in the namespace ns-<user>
+const FixtureUser = "monitor-store-conformance"
+
+// FixtureTime the start of the fixtures, far in the past so the range deletes and the retention of the suite
+// don't touch the monitors of the other tests sharing the database
+var FixtureTime = time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+
+var (
+	cpu     = resources.DefaultPropertyTypeLS.StringMap["cpu"].Enum
+	memory  = resources.DefaultPropertyTypeLS.StringMap["memory"].Enum
+	storage = resources.DefaultPropertyTypeLS.StringMap["storage"].Enum
+)
+
+// Fixtures the monitors seeded by the suite: two apps and a bucket over two days
+func Fixtures() []*resources.Monitor {
+	namespace := "ns-" + FixtureUser
+	app, bucket := resources.AppType[resources.APP], resources.AppType[resources.ObjectStorage]
+	day1, day2 := FixtureTime.Add(10*time.Hour), FixtureTime.Add(34*time.Hour)
+	detail := &resources.ObjStorageDetail{CreationTime: FixtureTime.Add(-time.Hour), Region: "cn-1"}
+	return []*resources.Monitor{
+		{Time: day1, Category: namespace, Type: app, Name: "app-a", Used: resources.EnumUsedMap{cpu: 100, memory: 2048},
+			Tenant: map[string]string{"region": "cn-1"}, Labels: map[string]string{"team": "infra"}},
+		{Time: day1, Category: namespace, Type: app, Name: "app-b", Used: resources.EnumUsedMap{cpu: 50}, Property: "gpu-T4",
+			Utilization: resources.EnumUsedMap{cpu: 42}},
+		{Time: day1, Category: namespace, Type: bucket, Name: "bucket-a", Used: resources.EnumUsedMap{storage: 1024}, ObjStorage: detail},
+		{Time: day1.Add(time.Minute), Category: namespace, Type: app, Name: "app-a", Used: resources.EnumUsedMap{cpu: 100, memory: 2048},
+			Tenant: map[string]string{"region": "cn-1"}, Labels: map[string]string{"team": "infra"}},
+		{Time: day1.Add(time.Minute), Category: namespace, Type: bucket, Name: "bucket-a", Used: resources.EnumUsedMap{storage: 2048}, ObjStorage: detail},
+		{Time: day2, Category: namespace, Type: app, Name: "app-a", Used: resources.EnumUsedMap{cpu: 300}},
+		{Time: day2, Category: namespace, Type: bucket, Name: "bucket-a", Used: resources.EnumUsedMap{storage: 4096}, ObjStorage: detail},
+	}
+}
+
+// RunMonitorStore seeds the fixtures into the store and checks the behavior shared by all monitor stores.
+// The fixtures are deleted before and after the run, so a failed run doesn't break the next one.
+func RunMonitorStore(t *testing.T, store database.MonitorStore) {
+	ctx := context.Background()
+	namespace := "ns-" + FixtureUser
+	cleanup := func() {
+		if _, err := store.DeleteMonitorsByCategory(namespace); err != nil {
+			t.Errorf("DeleteMonitorsByCategory() error = %v", err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	fixtures := Fixtures()
+	// the monitors of a cycle are inserted at once, the monitors of the next day by the batch insert
+	if err := store.InsertMonitor(ctx, fixtures[:5]...); err != nil {
+		t.Fatalf("InsertMonitor() error = %v", err)
+	}
+	if err := store.InsertMonitorBatch(ctx, fixtures[5:]); err != nil {
+		t.Fatalf("InsertMonitorBatch() error = %v", err)
+	}
+	// an empty insert is a no-op
+	if err := store.InsertMonitor(ctx); err != nil {
+		t.Errorf("InsertMonitor() without monitors error = %v", err)
+	}
+
+	start, end := FixtureTime, FixtureTime.AddDate(0, 0, 2)
+	t.Run("QueryMonitors", func(t *testing.T) {
+		var got []*resources.Monitor
+		if err := store.QueryMonitors(ctx, namespace, start, end, func(monitor *resources.Monitor) error {
+			got = append(got, monitor)
+			return nil
+		}); err != nil {
+			t.Fatalf("QueryMonitors() error = %v", err)
+		}
+		assertMonitors(t, got, fixtures)
+		for i := 1; i < len(got); i++ {
+			if got[i].Time.Before(got[i-1].Time) {
+				t.Errorf("QueryMonitors() not sorted by time: %s before %s", got[i].Time, got[i-1].Time)
+			}
+		}
+	})
+
+	t.Run("QueryMonitorsInRange", func(t *testing.T) {
+		var got []*resources.Monitor
+		if err := store.QueryMonitorsInRange(ctx, start, start.AddDate(0, 0, 1), func(monitor *resources.Monitor) error {
+			if monitor.Category == namespace {
+				got = append(got, monitor)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("QueryMonitorsInRange() error = %v", err)
+		}
+		assertMonitors(t, got, fixtures[:5])
+	})
+
+	t.Run("GetDistinctMonitorCombinations", func(t *testing.T) {
+		got, err := store.GetDistinctMonitorCombinations(start, start.AddDate(0, 0, 1), namespace)
+		if err != nil {
+			t.Fatalf("GetDistinctMonitorCombinations() error = %v", err)
+		}
+		names := make([]string, 0, len(got))
+		for _, monitor := range got {
+			if monitor.Category != namespace {
+				t.Errorf("GetDistinctMonitorCombinations() category = %q, want %q", monitor.Category, namespace)
+			}
+			names = append(names, monitor.Name)
+		}
+		sort.Strings(names)
+		if want := []string{"app-a", "app-b", "bucket-a"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("GetDistinctMonitorCombinations() = %v, want %v", names, want)
+		}
+	})
+
+	t.Run("GetDistinctMonitorCombinations across the days", func(t *testing.T) {
+		// the window starts after the monitors of the first day and ends in the second day
+		got, err := store.GetDistinctMonitorCombinations(start.Add(20*time.Hour), start.Add(40*time.Hour), namespace)
+		if err != nil {
+			t.Fatalf("GetDistinctMonitorCombinations() error = %v", err)
+		}
+		names := make([]string, 0, len(got))
+		for _, monitor := range got {
+			names = append(names, monitor.Name)
+		}
+		sort.Strings(names)
+		if want := []string{"app-a", "bucket-a"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("GetDistinctMonitorCombinations() = %v, want %v", names, want)
+		}
+	})
+
+	t.Run("QueryMonitorPage", func(t *testing.T) {
+		sorted := append([]*resources.Monitor(nil), fixtures...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return database.CursorOf(sorted[i]).Less(*database.CursorOf(sorted[j]))
+		})
+		query := func(q database.MonitorPageQuery) database.MonitorPage {
+			t.Helper()
+			page, err := store.QueryMonitorPage(ctx, q)
+			if err != nil {
+				t.Fatalf("QueryMonitorPage(%+v) error = %v", q, err)
+			}
+			return page
+		}
+
+		// the pages of 2 monitors follow the cursors to the last page
+		var got []*resources.Monitor
+		q := database.MonitorPageQuery{Namespace: namespace, StartTime: start, EndTime: end, Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > len(fixtures) {
+				t.Fatalf("QueryMonitorPage() didn't reach the last page")
+			}
+			page := query(q)
+			if len(page.Monitors) > q.Limit {
+				t.Fatalf("QueryMonitorPage() = %d monitors, want at most %d", len(page.Monitors), q.Limit)
+			}
+			for i := range page.Monitors {
+				got = append(got, &page.Monitors[i])
+			}
+			if page.Next == nil {
+				break
+			}
+			q.After = page.Next
+		}
+		assertMonitors(t, got, fixtures)
+		for i := range got {
+			if database.CursorOf(got[i]).Less(*database.CursorOf(sorted[i])) || database.CursorOf(sorted[i]).Less(*database.CursorOf(got[i])) {
+				t.Errorf("QueryMonitorPage() monitor %d = %s/%s, want %s/%s", i, got[i].Time, got[i].Name, sorted[i].Time, sorted[i].Name)
+			}
+		}
+
+		// the page of all the monitors is the last page
+		if page := query(database.MonitorPageQuery{Namespace: namespace, StartTime: start, EndTime: end, Limit: len(fixtures)}); page.Next != nil || len(page.Monitors) != len(fixtures) {
+			t.Errorf("QueryMonitorPage() with limit %d = %d monitors, next %+v, want all monitors and no next", len(fixtures), len(page.Monitors), page.Next)
+		}
+
+		// the start time is included and the end time is excluded
+		first := fixtures[0].Time
+		page := query(database.MonitorPageQuery{Namespace: namespace, StartTime: first, EndTime: first.Add(time.Minute)})
+		assertMonitors(t, monitorPointers(page.Monitors), fixtures[:3])
+
+		bucket := resources.AppType[resources.ObjectStorage]
+		page = query(database.MonitorPageQuery{Namespace: namespace, Type: &bucket, StartTime: start, EndTime: end})
+		assertMonitors(t, monitorPointers(page.Monitors), []*resources.Monitor{fixtures[2], fixtures[4], fixtures[6]})
+
+		// the empty results: the range before the fixtures, another namespace and the cursor after the last monitor
+		for _, q := range []database.MonitorPageQuery{
+			{Namespace: namespace, StartTime: start.AddDate(0, 0, -1), EndTime: first},
+			{Namespace: namespace + "-empty", StartTime: start, EndTime: end},
+			{Namespace: namespace, StartTime: start, EndTime: end, After: database.CursorOf(sorted[len(sorted)-1])},
+		} {
+			if page := query(q); len(page.Monitors) != 0 || page.Next != nil {
+				t.Errorf("QueryMonitorPage(%+v) = %d monitors, next %+v, want empty", q, len(page.Monitors), page.Next)
+			}
+		}
+		if _, err := store.QueryMonitorPage(ctx, database.MonitorPageQuery{Namespace: namespace, StartTime: end, EndTime: start}); err == nil {
+			t.Error("QueryMonitorPage() expected error for the reversed range")
+		}
+	})
+
+	t.Run("ReplaceMonitors", func(t *testing.T) {
+		// a namespace of its own, the counts of the other subtests are not changed
+		replaced := namespace + "-replace"
+		defer func() {
+			if _, err := store.DeleteMonitorsByCategory(replaced); err != nil {
+				t.Errorf("DeleteMonitorsByCategory() error = %v", err)
+			}
+		}()
+		app := resources.AppType[resources.APP]
+		end := FixtureTime.Add(11 * time.Hour)
+		traffic := func(used int64) *resources.Monitor {
+			return &resources.Monitor{Time: end.Add(-time.Minute), Category: replaced, Type: app, Name: "app-a",
+				Used: resources.EnumUsedMap{cpu: used}, IdempotencyKey: resources.TrafficMonitorKey(replaced, app, "app-a", end)}
+		}
+		other := &resources.Monitor{Time: end.Add(-time.Minute), Category: replaced, Type: app, Name: "app-b", Used: resources.EnumUsedMap{cpu: 1}}
+		// the retry of a window replaces the first insert, the monitors without a key are inserted as is
+		if err := store.ReplaceMonitors(ctx, traffic(100), other); err != nil {
+			t.Fatalf("ReplaceMonitors() error = %v", err)
+		}
+		if err := store.ReplaceMonitors(ctx, traffic(200)); err != nil {
+			t.Fatalf("ReplaceMonitors() retry error = %v", err)
+		}
+		var got []*resources.Monitor
+		if err := store.QueryMonitors(ctx, replaced, FixtureTime, FixtureTime.AddDate(0, 0, 1), func(monitor *resources.Monitor) error {
+			got = append(got, monitor)
+			return nil
+		}); err != nil {
+			t.Fatalf("QueryMonitors() error = %v", err)
+		}
+		assertMonitors(t, got, []*resources.Monitor{traffic(200), other})
+		for _, monitor := range got {
+			if monitor.Name == "app-a" && monitor.IdempotencyKey != traffic(0).IdempotencyKey {
+				t.Errorf("idempotency key = %q, want %q", monitor.IdempotencyKey, traffic(0).IdempotencyKey)
+			}
+		}
+		if err := store.ReplaceMonitors(ctx); err != nil {
+			t.Errorf("ReplaceMonitors() without monitors error = %v", err)
+		}
+	})
+
+	t.Run("InsertTwice", func(t *testing.T) {
+		// the same records inserted again, eg: by a controller restarted within the minute, are no-ops
+		if err := store.InsertMonitor(ctx, Fixtures()[:5]...); err != nil {
+			t.Fatalf("InsertMonitor() again error = %v", err)
+		}
+		if err := store.InsertMonitorBatch(ctx, append(Fixtures(), Fixtures()[5:]...)); err != nil {
+			t.Fatalf("InsertMonitorBatch() again error = %v", err)
+		}
+		assertCount(t, store, namespace, len(fixtures))
+		if fixtures[0].MonitorID != "" {
+			t.Errorf("the inserts modified the monitors of the caller, id = %q", fixtures[0].MonitorID)
+		}
+	})
+
+	t.Run("GetObjectStorageUsage", func(t *testing.T) {
+		got, err := store.GetObjectStorageUsage(FixtureUser, start, end)
+		if err != nil {
+			t.Fatalf("GetObjectStorageUsage() error = %v", err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("GetObjectStorageUsage() = %d buckets, want 1", len(got))
+		}
+		usage := got[0]
+		if usage.Bucket != "bucket-a" || usage.Used[storage] != 1024+2048+4096 {
+			t.Errorf("GetObjectStorageUsage() = %s %v, want bucket-a storage %d", usage.Bucket, usage.Used, 1024+2048+4096)
+		}
+		if !usage.FirstSeen.Equal(fixtures[2].Time) || !usage.LastSeen.Equal(fixtures[6].Time) {
+			t.Errorf("GetObjectStorageUsage() seen %s - %s, want %s - %s", usage.FirstSeen, usage.LastSeen, fixtures[2].Time, fixtures[6].Time)
+		}
+		if usage.Detail == nil || usage.Detail.Region != "cn-1" {
+			t.Errorf("GetObjectStorageUsage() detail = %+v, want region cn-1", usage.Detail)
+		}
+	})
+
+	t.Run("GetNamespaceUsage", func(t *testing.T) {
+		// no hourly aggregates of the namespace yet, the usage is summed from the minute monitors
+		got, err := store.GetNamespaceUsage(namespace, start.In(time.FixedZone("UTC+8", 8*3600)), end)
+		if err != nil {
+			t.Fatalf("GetNamespaceUsage() error = %v", err)
+		}
+		if want := map[uint8]int64{cpu: 550, memory: 4096, storage: 7168}; !reflect.DeepEqual(got, want) {
+			t.Errorf("GetNamespaceUsage() = %v, want %v", got, want)
+		}
+		if got, err := store.GetUsageByApp(namespace, resources.APP, "app-a", start, end); err != nil ||
+			!reflect.DeepEqual(got, map[uint8]int64{cpu: 500, memory: 4096}) {
+			t.Errorf("GetUsageByApp() = %v, %v, want cpu 500 and memory 4096", got, err)
+		}
+		if got, err := store.GetUsageByApp(namespace, resources.ObjectStorage, "bucket-b", start, end); err != nil || len(got) != 0 {
+			t.Errorf("GetUsageByApp() of a missing bucket = %v, %v, want empty", got, err)
+		}
+		if _, err := store.GetUsageByApp(namespace, "unknown", "app-a", start, end); err == nil {
+			t.Error("GetUsageByApp() with an unknown app type, want error")
+		}
+		if _, err := store.GetNamespaceUsage("", start, end); err == nil {
+			t.Error("GetNamespaceUsage() without category, want error")
+		}
+		if _, err := store.GetNamespaceUsage(namespace, start, start.Add(database.MaxUsageRange+time.Hour)); err == nil {
+			t.Error("GetNamespaceUsage() longer than the max range, want error")
+		}
+	})
+
+	t.Run("MonitorRollups", func(t *testing.T) {
+		hour := fixtures[0].Time.Truncate(time.Hour)
+		rollup := &resources.Monitor{Time: hour, Category: namespace, Type: fixtures[0].Type, Name: "app-a", Used: resources.EnumUsedMap{cpu: 200}}
+		// saving the same hour twice replaces the rollups
+		for i := 0; i < 2; i++ {
+			if err := store.SaveMonitorRollups(ctx, hour, []*resources.Monitor{rollup}); err != nil {
+				t.Fatalf("SaveMonitorRollups() error = %v", err)
+			}
+		}
+		done, err := store.IsMonitorRollupDone(ctx, hour)
+		if err != nil || !done {
+			t.Errorf("IsMonitorRollupDone() = %v, %v, want true", done, err)
+		}
+	})
+
+	t.Run("MonitorAggregates", func(t *testing.T) {
+		hour := fixtures[0].Time.Truncate(time.Hour)
+		app := fixtures[0].Type
+		// the late monitors are re-aggregated, the saved period is replaced instead of duplicated
+		runs := [][]*resources.Monitor{
+			{{Time: hour, Category: namespace, Type: app, Name: "app-a", Used: resources.EnumUsedMap{cpu: 100}}},
+			{{Time: hour, Category: namespace, Type: app, Name: "app-a", Used: resources.EnumUsedMap{cpu: 150}},
+				{Time: hour, Category: namespace, Type: app, Name: "app-b", Used: resources.EnumUsedMap{cpu: 10}}},
+		}
+		for _, aggregates := range runs {
+			if err := store.SaveMonitorAggregates(ctx, database.MonitorHourly, hour, aggregates); err != nil {
+				t.Fatalf("SaveMonitorAggregates() error = %v", err)
+			}
+		}
+		got := make(map[string]int64)
+		if err := store.QueryMonitorAggregates(ctx, database.MonitorHourly, hour, hour.Add(time.Hour), func(monitor *resources.Monitor) error {
+			if monitor.Category == namespace {
+				got[monitor.Name] += monitor.Used[cpu]
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("QueryMonitorAggregates() error = %v", err)
+		}
+		if len(got) != 2 || got["app-a"] != 150 || got["app-b"] != 10 {
+			t.Errorf("QueryMonitorAggregates() = %v, want app-a 150 and app-b 10 once", got)
+		}
+		// saving an earlier period doesn't move the latest back
+		if err := store.SaveMonitorAggregates(ctx, database.MonitorHourly, hour.Add(-time.Hour), nil); err != nil {
+			t.Fatalf("SaveMonitorAggregates() without aggregates error = %v", err)
+		}
+		latest, err := store.LatestMonitorAggregate(ctx, database.MonitorHourly)
+		if err != nil || latest.Before(hour) {
+			t.Errorf("LatestMonitorAggregate() = %s, %v, want at least %s", latest, err, hour)
+		}
+
+		// the aggregated hour is read from the hourly aggregates instead of the minute monitors
+		if got, err := store.GetNamespaceUsage(namespace, hour, hour.Add(time.Hour)); err != nil || got[cpu] != 160 {
+			t.Errorf("GetNamespaceUsage() of the aggregated hour = %v, %v, want cpu 160", got, err)
+		}
+
+		day := database.MonitorDaily.Truncate(hour)
+		daily := &resources.Monitor{Time: day, Category: namespace, Type: app, Name: "app-a", Used: resources.EnumUsedMap{cpu: 150}}
+		if err := store.SaveMonitorAggregates(ctx, database.MonitorDaily, day, []*resources.Monitor{daily}); err != nil {
+			t.Fatalf("SaveMonitorAggregates() daily error = %v", err)
+		}
+		var days int
+		if err := store.QueryMonitorAggregates(ctx, database.MonitorDaily, day, day.AddDate(0, 0, 1), func(monitor *resources.Monitor) error {
+			if monitor.Category == namespace {
+				days++
+			}
+			return nil
+		}); err != nil || days != 1 {
+			t.Errorf("QueryMonitorAggregates() daily = %d, %v, want 1", days, err)
+		}
+		if err := store.SaveMonitorAggregates(ctx, "weekly", day, nil); err == nil {
+			t.Error("SaveMonitorAggregates() with an unknown granularity, want error")
+		}
+	})
+
+	t.Run("DeleteMonitorsByCategory", func(t *testing.T) {
+		// a namespace of its own, the monitors of the fixtures are not purged
+		purged := namespace + "-purge"
+		defer func() {
+			if _, err := store.DeleteMonitorsByCategory(purged); err != nil {
+				t.Errorf("DeleteMonitorsByCategory() error = %v", err)
+			}
+		}()
+		// more than a batch of the purge, of two days and an aggregate
+		at := FixtureTime.Add(12 * time.Hour)
+		monitors := make([]*resources.Monitor, 0, database.PurgeBatchSize)
+		for i := 0; i < database.PurgeBatchSize; i++ {
+			monitors = append(monitors, &resources.Monitor{Time: at, Category: purged, Type: resources.AppType[resources.APP],
+				Name: fmt.Sprintf("app-%d", i), Used: resources.EnumUsedMap{cpu: 100}})
+		}
+		if err := store.InsertMonitor(ctx, monitors...); err != nil {
+			t.Fatalf("InsertMonitor() error = %v", err)
+		}
+		if err := store.InsertMonitor(ctx, &resources.Monitor{Time: at.AddDate(0, 0, 1), Category: purged, Type: resources.AppType[resources.APP],
+			Name: "app-0", Used: resources.EnumUsedMap{cpu: 100}}); err != nil {
+			t.Fatalf("InsertMonitor() of the next day error = %v", err)
+		}
+		day := database.MonitorDaily.Truncate(at)
+		if err := store.SaveMonitorAggregates(ctx, database.MonitorDaily, day, []*resources.Monitor{
+			{Time: day, Category: purged, Type: resources.AppType[resources.APP], Name: "app-0", Used: resources.EnumUsedMap{cpu: 100}},
+		}); err != nil {
+			t.Fatalf("SaveMonitorAggregates() error = %v", err)
+		}
+		want := int64(database.PurgeBatchSize + 2)
+		kept, err := store.CountMonitorsByCategory(namespace)
+		if err != nil || kept == 0 {
+			t.Fatalf("CountMonitorsByCategory(%s) = %d, %v, want the fixtures", namespace, kept, err)
+		}
+		if count, err := store.CountMonitorsByCategory(purged); err != nil || count != want {
+			t.Errorf("CountMonitorsByCategory() = %d, %v, want %d", count, err, want)
+		}
+		if deleted, err := store.DeleteMonitorsByCategory(purged); err != nil || deleted != want {
+			t.Errorf("DeleteMonitorsByCategory() = %d, %v, want %d", deleted, err, want)
+		}
+		if count, err := store.CountMonitorsByCategory(purged); err != nil || count != 0 {
+			t.Errorf("CountMonitorsByCategory() after the delete = %d, %v, want 0", count, err)
+		}
+		if count, err := store.CountMonitorsByCategory(namespace); err != nil || count != kept {
+			t.Errorf("CountMonitorsByCategory(%s) after the delete = %d, %v, want %d untouched", namespace, count, err, kept)
+		}
+	})
+
+	t.Run("DeleteMonitorsInRange", func(t *testing.T) {
+		deleted, err := store.DeleteMonitorsInRange(ctx, fixtures[0].Time, fixtures[0].Time.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("DeleteMonitorsInRange() error = %v", err)
+		}
+		if deleted != 3 {
+			t.Errorf("DeleteMonitorsInRange() = %d, want 3", deleted)
+		}
+		assertCount(t, store, namespace, len(fixtures)-3)
+	})
+
+	t.Run("DropMonitorCollectionsOlderThan", func(t *testing.T) {
+		// the cutoff is the day after the fixtures, the newer monitors of the other tests are kept
+		days := int(time.Since(end).Hours() / 24)
+		dropped, err := store.DropMonitorCollectionsOlderThan(days)
+		if err != nil {
+			t.Fatalf("DropMonitorCollectionsOlderThan() error = %v", err)
+		}
+		if dropped == 0 {
+			t.Error("DropMonitorCollectionsOlderThan() = 0, want the collections of the fixtures dropped")
+		}
+		assertCount(t, store, namespace, 0)
+	})
+}
+
+func assertCount(t *testing.T, store database.MonitorStore, namespace string, want int) {
+	t.Helper()
+	count := 0
+	if err := store.QueryMonitors(context.Background(), namespace, FixtureTime, FixtureTime.AddDate(0, 0, 2), func(*resources.Monitor) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatalf("QueryMonitors() error = %v", err)
+	}
+	if count != want {
+		t.Errorf("QueryMonitors() = %d monitors, want %d", count, want)
+	}
+}
+
+// assertMonitors compares the monitors ignoring the order of the monitors of the same minute
+func monitorPointers(monitors []resources.Monitor) []*resources.Monitor {
+	pointers := make([]*resources.Monitor, len(monitors))
+	for i := range monitors {
+		pointers[i] = &monitors[i]
+	}
+	return pointers
+}
+
+func assertMonitors(t *testing.T, got, want []*resources.Monitor) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d monitors, want %d", len(got), len(want))
+	}
+	key := func(m *resources.Monitor) string {
+		return m.Time.UTC().Format(time.RFC3339) + "/" + m.Name
+	}
+	byKey := make(map[string]*resources.Monitor, len(got))
+	for _, m := range got {
+		byKey[key(m)] = m
+	}
+	for _, w := range want {
+		g, ok := byKey[key(w)]
+		if !ok {
+			t.Errorf("monitor %s is missing", key(w))
+			continue
+		}
+		if g.Category != w.Category || g.Type != w.Type || g.Property != w.Property {
+			t.Errorf("monitor %s = %s/%d/%q, want %s/%d/%q", key(w), g.Category, g.Type, g.Property, w.Category, w.Type, w.Property)
+		}
+		if !reflect.DeepEqual(g.Used, w.Used) {
+			t.Errorf("monitor %s used = %v, want %v", key(w), g.Used, w.Used)
+		}
+		if len(g.Utilization) != 0 || len(w.Utilization) != 0 {
+			if !reflect.DeepEqual(g.Utilization, w.Utilization) {
+				t.Errorf("monitor %s utilization = %v, want %v", key(w), g.Utilization, w.Utilization)
+			}
+		}
+		if len(g.Tenant) != 0 || len(w.Tenant) != 0 {
+			if !reflect.DeepEqual(g.Tenant, w.Tenant) {
+				t.Errorf("monitor %s tenant = %v, want %v", key(w), g.Tenant, w.Tenant)
+			}
+		}
+		if len(g.Labels) != 0 || len(w.Labels) != 0 {
+			if !reflect.DeepEqual(g.Labels, w.Labels) {
+				t.Errorf("monitor %s labels = %v, want %v", key(w), g.Labels, w.Labels)
+			}
+		}
+		if (g.ObjStorage == nil) != (w.ObjStorage == nil) ||
+			g.ObjStorage != nil && (g.ObjStorage.Region != w.ObjStorage.Region || !g.ObjStorage.CreationTime.Equal(w.ObjStorage.CreationTime)) {
+			t.Errorf("monitor %s objstorage = %+v, want %+v", key(w), g.ObjStorage, w.ObjStorage)
+		}
+	}
+}
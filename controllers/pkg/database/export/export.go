@@ -0,0 +1,146 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package export writes the raw monitors of a period as csv or parquet for the offline analysis, eg: the monthly
+// usage exports of the finance. The monitors are streamed from the store and written row by row, so the memory stays
+// bounded by a parquet row group however large the export is.
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatParquet Format = "parquet"
+)
+
+// ParseFormat parses csv or parquet
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(format); f {
+	case FormatCSV, FormatParquet:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid export format %q, must be one of: %s, %s", format, FormatCSV, FormatParquet)
+	}
+}
+
+// Columns the columns of the exported rows, one row per resource of a monitor
+var Columns = []string{"time", "category", "type", "name", "property", "resource", "used"}
+
+// Row the used of a resource of a monitor
+type Row struct {
+	Time     time.Time
+	Category string
+	Type     string
+	Name     string
+	Property string
+	Resource string
+	Used     int64
+}
+
+// Options the monitors to export
+type Options struct {
+	Start, End time.Time
+	// Category exports the monitors of the namespace only if set
+	Category string
+	Format   Format
+	// Properties names the resources of the monitors, resources.DefaultPropertyTypeLS if nil
+	Properties *resources.PropertyTypeLS
+}
+
+// rowWriter writes the rows in a format, Close writes what is buffered and the trailer of the format
+type rowWriter interface {
+	Write(row *Row) error
+	Close() error
+}
+
+// Export streams the monitors of the options to w and returns the number of the rows written
+func Export(ctx context.Context, store database.MonitorStore, w io.Writer, opts Options) (int64, error) {
+	if !opts.Start.Before(opts.End) {
+		return 0, fmt.Errorf("start time must be before end time")
+	}
+	properties := opts.Properties
+	if properties == nil {
+		properties = resources.DefaultPropertyTypeLS
+	}
+	var rw rowWriter
+	switch opts.Format {
+	case FormatCSV:
+		rw = newCSVWriter(w)
+	case FormatParquet:
+		var err error
+		if rw, err = newParquetWriter(w, defaultParquetRowGroupRows); err != nil {
+			return 0, fmt.Errorf("failed to start the parquet export: %w", err)
+		}
+	default:
+		return 0, fmt.Errorf("invalid export format %q", opts.Format)
+	}
+	var rows int64
+	err := database.StreamMonitors(ctx, store, opts.Start, opts.End, opts.Category, func(monitor *resources.Monitor) error {
+		for _, row := range MonitorRows(monitor, properties) {
+			if err := rw.Write(row); err != nil {
+				return err
+			}
+			rows++
+		}
+		return nil
+	})
+	if err != nil {
+		return rows, fmt.Errorf("failed to export the monitors: %w", err)
+	}
+	if err := rw.Close(); err != nil {
+		return rows, fmt.Errorf("failed to finish the export: %w", err)
+	}
+	return rows, nil
+}
+
+// MonitorRows returns one row per resource of the monitor sorted by the resource enum, the resources unknown to the
+// properties are named by their enum
+func MonitorRows(monitor *resources.Monitor, properties *resources.PropertyTypeLS) []*Row {
+	enums := make([]int, 0, len(monitor.Used))
+	for enum := range monitor.Used {
+		enums = append(enums, int(enum))
+	}
+	sort.Ints(enums)
+	rows := make([]*Row, 0, len(enums))
+	for _, enum := range enums {
+		resource := strconv.Itoa(enum)
+		if properties != nil {
+			if pType, ok := properties.EnumMap[uint8(enum)]; ok {
+				resource = pType.Name
+			}
+		}
+		rows = append(rows, &Row{
+			Time:     monitor.Time.UTC(),
+			Category: monitor.Category,
+			Type:     resources.AppTypeReverse[monitor.Type],
+			Name:     monitor.Name,
+			Property: monitor.Property,
+			Resource: resource,
+			Used:     monitor.Used[uint8(enum)],
+		})
+	}
+	return rows
+}
@@ -0,0 +1,223 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+	"github.com/apache/arrow/go/v13/parquet"
+	"github.com/apache/arrow/go/v13/parquet/file"
+	"github.com/apache/arrow/go/v13/parquet/pqarrow"
+	pqschema "github.com/apache/arrow/go/v13/parquet/schema"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+var exportStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// seedExportStore stores minutes monitors of 2 resources in each of the namespaces
+func seedExportStore(t *testing.T, minutes int, namespaces ...string) *databasetest.MemoryStore {
+	store := databasetest.NewMemoryStore()
+	cpu := resources.DefaultPropertyTypeLS.StringMap["cpu"]
+	memory := resources.DefaultPropertyTypeLS.StringMap["memory"]
+	for _, namespace := range namespaces {
+		var monitors []*resources.Monitor
+		for i := 0; i < minutes; i++ {
+			monitors = append(monitors, &resources.Monitor{Time: exportStart.Add(time.Duration(i) * time.Minute), Category: namespace,
+				Type: resources.AppType[resources.APP], Name: "app-a", Used: resources.EnumUsedMap{cpu.Enum: 100, memory.Enum: int64(i)}})
+		}
+		if err := store.InsertMonitor(context.Background(), monitors...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestExport_CSV(t *testing.T) {
+	store := seedExportStore(t, 30, "ns-a", "ns-b")
+	tests := []struct {
+		category string
+		end      time.Time
+		wantRows int64
+	}{
+		{end: exportStart.Add(time.Hour), wantRows: 120},
+		{category: "ns-a", end: exportStart.Add(time.Hour), wantRows: 60},
+		{category: "ns-a", end: exportStart.Add(10 * time.Minute), wantRows: 20},
+		{category: "ns-c", end: exportStart.Add(time.Hour), wantRows: 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%s", tt.category, tt.end.Sub(exportStart)), func(t *testing.T) {
+			var buf bytes.Buffer
+			rows, err := Export(context.Background(), store, &buf, Options{Start: exportStart, End: tt.end, Category: tt.category, Format: FormatCSV})
+			if err != nil {
+				t.Fatal(err)
+			}
+			records, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows != tt.wantRows || int64(len(records)) != tt.wantRows+1 {
+				t.Fatalf("rows = %d with %d records, want %d rows and the header", rows, len(records), tt.wantRows)
+			}
+			if !reflect.DeepEqual(records[0], Columns) {
+				t.Errorf("header = %v, want %v", records[0], Columns)
+			}
+			for _, record := range records[1:] {
+				if tt.category != "" && record[1] != tt.category {
+					t.Errorf("record %v of another category", record)
+				}
+			}
+		})
+	}
+}
+
+func TestExport_Parquet(t *testing.T) {
+	store := seedExportStore(t, 30, "ns-a", "ns-b")
+	var buf bytes.Buffer
+	rows, err := Export(context.Background(), store, &buf, Options{Start: exportStart, End: exportStart.Add(time.Hour), Category: "ns-b", Format: FormatParquet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 60 {
+		t.Fatalf("rows = %d, want 60", rows)
+	}
+	reader := openParquetFile(t, buf.Bytes())
+	if reader.NumRows() != 60 {
+		t.Errorf("num_rows = %d, want 60", reader.NumRows())
+	}
+	schema := reader.MetaData().Schema
+	if schema.NumColumns() != len(Columns) {
+		t.Fatalf("schema = %s, want %d columns", schema, len(Columns))
+	}
+	for i, column := range Columns {
+		wantType := parquet.Types.ByteArray
+		if column == "time" || column == "used" {
+			wantType = parquet.Types.Int64
+		}
+		if c := schema.Column(i); c.Name() != column || c.PhysicalType() != wantType || c.MaxDefinitionLevel() != 0 {
+			t.Errorf("schema column %d = %s %s, want required %s of %s", i, c.Name(), c.PhysicalType(), column, wantType)
+		}
+	}
+	if logical := schema.Column(0).LogicalType(); !logical.Equals(pqschema.NewTimestampLogicalType(true, pqschema.TimeUnitMillis)) {
+		t.Errorf("time logical type = %s, want TIMESTAMP(MILLIS) in UTC", logical)
+	}
+
+	got := readParquetRows(t, buf.Bytes())
+	if len(got) != 60 {
+		t.Fatalf("read %d rows, want 60", len(got))
+	}
+	cpu := resources.DefaultPropertyTypeLS.StringMap["cpu"]
+	want := Row{Time: exportStart, Category: "ns-b", Type: resources.APP, Name: "app-a", Resource: cpu.Name, Used: 100}
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("first row = %+v, want %+v", got[0], want)
+	}
+}
+
+func TestParquetWriter_rowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newParquetWriter(&buf, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := w.Write(&Row{Time: exportStart.Add(time.Duration(i) * time.Minute), Category: "ns-a", Type: resources.APP,
+			Name: "app-a", Resource: "cpu", Used: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader := openParquetFile(t, buf.Bytes())
+	var groupRows []int64
+	for i := 0; i < reader.NumRowGroups(); i++ {
+		groupRows = append(groupRows, reader.RowGroup(i).NumRows())
+	}
+	if !reflect.DeepEqual(groupRows, []int64{4, 4, 2}) {
+		t.Errorf("rows of the row groups = %v, want [4 4 2]", groupRows)
+	}
+	var used []int64
+	for i, row := range readParquetRows(t, buf.Bytes()) {
+		if want := exportStart.Add(time.Duration(i) * time.Minute); !row.Time.Equal(want) {
+			t.Errorf("row %d time = %s, want %s", i, row.Time, want)
+		}
+		used = append(used, row.Used)
+	}
+	if !reflect.DeepEqual(used, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("used = %v, want 0 to 9", used)
+	}
+}
+
+func TestParquetWriter_empty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newParquetWriter(&buf, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if reader := openParquetFile(t, buf.Bytes()); reader.NumRows() != 0 || reader.MetaData().Schema.NumColumns() != len(Columns) {
+		t.Errorf("empty file = %d rows of %s, want no rows of the columns", reader.NumRows(), reader.MetaData().Schema)
+	}
+}
+
+func openParquetFile(t *testing.T, data []byte) *file.Reader {
+	t.Helper()
+	reader, err := file.NewParquetReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("not a parquet file: %v", err)
+	}
+	t.Cleanup(func() { _ = reader.Close() })
+	return reader
+}
+
+// readParquetRows reads the rows of the file back by the arrow reader
+func readParquetRows(t *testing.T, data []byte) []Row {
+	t.Helper()
+	table, err := pqarrow.ReadTable(context.Background(), bytes.NewReader(data), nil, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Release()
+	reader := array.NewTableReader(table, 0)
+	defer reader.Release()
+	var rows []Row
+	for reader.Next() {
+		record := reader.Record()
+		for i := 0; i < int(record.NumRows()); i++ {
+			rows = append(rows, Row{
+				Time:     record.Column(0).(*array.Timestamp).Value(i).ToTime(arrow.Millisecond),
+				Category: record.Column(1).(*array.String).Value(i),
+				Type:     record.Column(2).(*array.String).Value(i),
+				Name:     record.Column(3).(*array.String).Value(i),
+				Property: record.Column(4).(*array.String).Value(i),
+				Resource: record.Column(5).(*array.String).Value(i),
+				Used:     record.Column(6).(*array.Int64).Value(i),
+			})
+		}
+	}
+	return rows
+}
@@ -0,0 +1,91 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"io"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+	"github.com/apache/arrow/go/v13/parquet"
+	"github.com/apache/arrow/go/v13/parquet/pqarrow"
+)
+
+// defaultParquetRowGroupRows bounds the rows buffered in memory before a row group is written
+const defaultParquetRowGroupRows = 100000
+
+// parquetSchema the required columns in the order of Columns, time is a TIMESTAMP(MILLIS) int64 in UTC
+var parquetSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}},
+	{Name: "category", Type: arrow.BinaryTypes.String},
+	{Name: "type", Type: arrow.BinaryTypes.String},
+	{Name: "name", Type: arrow.BinaryTypes.String},
+	{Name: "property", Type: arrow.BinaryTypes.String},
+	{Name: "resource", Type: arrow.BinaryTypes.String},
+	{Name: "used", Type: arrow.PrimitiveTypes.Int64},
+}, nil)
+
+// parquetWriter buffers the rows of a row group in arrow builders, and writes the row group once it has rowGroupRows
+// rows. The file writer keeps only the metadata of the written row groups for the footer.
+type parquetWriter struct {
+	w            *pqarrow.FileWriter
+	builder      *array.RecordBuilder
+	rowGroupRows int
+	rows         int
+}
+
+func newParquetWriter(w io.Writer, rowGroupRows int) (*parquetWriter, error) {
+	props := parquet.NewWriterProperties(parquet.WithMaxRowGroupLength(int64(rowGroupRows)))
+	fw, err := pqarrow.NewFileWriter(parquetSchema, w, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		return nil, err
+	}
+	return &parquetWriter{
+		w:            fw,
+		builder:      array.NewRecordBuilder(memory.DefaultAllocator, parquetSchema),
+		rowGroupRows: rowGroupRows,
+	}, nil
+}
+
+func (p *parquetWriter) Write(row *Row) error {
+	p.builder.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(row.Time.UnixMilli()))
+	for i, value := range []string{row.Category, row.Type, row.Name, row.Property, row.Resource} {
+		p.builder.Field(i + 1).(*array.StringBuilder).Append(value)
+	}
+	p.builder.Field(6).(*array.Int64Builder).Append(row.Used)
+	if p.rows++; p.rows < p.rowGroupRows {
+		return nil
+	}
+	return p.writeRowGroup()
+}
+
+func (p *parquetWriter) writeRowGroup() error {
+	record := p.builder.NewRecord()
+	defer record.Release()
+	p.rows = 0
+	return p.w.Write(record)
+}
+
+// Close writes the buffered rows as the last row group and the footer
+func (p *parquetWriter) Close() error {
+	defer p.builder.Release()
+	if p.rows > 0 {
+		if err := p.writeRowGroup(); err != nil {
+			return err
+		}
+	}
+	return p.w.Close()
+}
@@ -0,0 +1,211 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// the operations of the database metrics, the inserts, replaces and batches of the monitors are all insert
+const (
+	OperationInsert       = "insert"
+	OperationDistinct     = "distinct"
+	OperationTrafficBytes = "trafficBytes"
+	OperationDrop         = "drop"
+)
+
+var (
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sealos_database_operation_duration_seconds",
+		Help:    "Duration of the database operations by the operation, including the failed ones.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"operation"})
+	operationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_database_operation_errors_total",
+		Help: "Number of the failed database operations by the operation.",
+	}, []string{"operation"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(operationDuration, operationErrors)
+}
+
+func observeOperation(operation string, start time.Time, err error) {
+	operationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		operationErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// InstrumentedStore a MonitorStore recording the latency and the errors of its inserts, distincts and drops, so a
+// slow cycle is told apart from a slow database. The other calls are passed through unobserved.
+type InstrumentedStore struct {
+	MonitorStore
+}
+
+var (
+	_ MonitorStore            = &InstrumentedStore{}
+	_ DetailedMonitorInserter = &InstrumentedStore{}
+	_ Pinger                  = &InstrumentedStore{}
+)
+
+func NewInstrumentedStore(store MonitorStore) *InstrumentedStore {
+	return &InstrumentedStore{MonitorStore: store}
+}
+
+// Ping pings the store, nil if the store doesn't implement Pinger
+func (s *InstrumentedStore) Ping(ctx context.Context) error {
+	if pinger, ok := s.MonitorStore.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (s *InstrumentedStore) InsertMonitor(ctx context.Context, monitors ...*resources.Monitor) (err error) {
+	defer func(start time.Time) { observeOperation(OperationInsert, start, err) }(time.Now())
+	return s.MonitorStore.InsertMonitor(ctx, monitors...)
+}
+
+// InsertMonitorDetailed records an error if any of the monitors failed, see DetailedMonitorInserter
+func (s *InstrumentedStore) InsertMonitorDetailed(ctx context.Context, monitors ...*resources.Monitor) MonitorInsertResults {
+	start := time.Now()
+	results := InsertMonitorDetailed(ctx, s.MonitorStore, monitors...)
+	var err error
+	for _, err = range results {
+		// any of the errors, the failed monitors are counted by the caller
+		break
+	}
+	observeOperation(OperationInsert, start, err)
+	return results
+}
+
+func (s *InstrumentedStore) InsertMonitorBatch(ctx context.Context, monitors []*resources.Monitor) (err error) {
+	defer func(start time.Time) { observeOperation(OperationInsert, start, err) }(time.Now())
+	return s.MonitorStore.InsertMonitorBatch(ctx, monitors)
+}
+
+func (s *InstrumentedStore) ReplaceMonitors(ctx context.Context, monitors ...*resources.Monitor) (err error) {
+	defer func(start time.Time) { observeOperation(OperationInsert, start, err) }(time.Now())
+	return s.MonitorStore.ReplaceMonitors(ctx, monitors...)
+}
+
+func (s *InstrumentedStore) GetDistinctMonitorCombinations(startTime, endTime time.Time, namespace string) (monitors []resources.Monitor, err error) {
+	defer func(start time.Time) { observeOperation(OperationDistinct, start, err) }(time.Now())
+	return s.MonitorStore.GetDistinctMonitorCombinations(startTime, endTime, namespace)
+}
+
+func (s *InstrumentedStore) DropMonitorCollectionsOlderThan(days int) (dropped int, err error) {
+	defer func(start time.Time) { observeOperation(OperationDrop, start, err) }(time.Now())
+	return s.MonitorStore.DropMonitorCollectionsOlderThan(days)
+}
+
+// InstrumentedTraffic a Traffic recording the latency and the errors of its queries as trafficBytes
+type InstrumentedTraffic struct {
+	Traffic
+}
+
+func NewInstrumentedTraffic(traffic Traffic) *InstrumentedTraffic {
+	return &InstrumentedTraffic{Traffic: traffic}
+}
+
+func (t *InstrumentedTraffic) GetTrafficSentBytes(startTime, endTime time.Time, namespace string, _type uint8, name string) (bytes int64, err error) {
+	defer func(start time.Time) { observeOperation(OperationTrafficBytes, start, err) }(time.Now())
+	return t.Traffic.GetTrafficSentBytes(startTime, endTime, namespace, _type, name)
+}
+
+func (t *InstrumentedTraffic) GetTrafficRecvBytes(startTime, endTime time.Time, namespace string, _type uint8, name string) (bytes int64, err error) {
+	defer func(start time.Time) { observeOperation(OperationTrafficBytes, start, err) }(time.Now())
+	return t.Traffic.GetTrafficRecvBytes(startTime, endTime, namespace, _type, name)
+}
+
+func (t *InstrumentedTraffic) GetPodTrafficSentBytes(startTime, endTime time.Time, namespace string, name string) (bytes int64, err error) {
+	defer func(start time.Time) { observeOperation(OperationTrafficBytes, start, err) }(time.Now())
+	return t.Traffic.GetPodTrafficSentBytes(startTime, endTime, namespace, name)
+}
+
+func (t *InstrumentedTraffic) GetPodTrafficRecvBytes(startTime, endTime time.Time, namespace string, name string) (bytes int64, err error) {
+	defer func(start time.Time) { observeOperation(OperationTrafficBytes, start, err) }(time.Now())
+	return t.Traffic.GetPodTrafficRecvBytes(startTime, endTime, namespace, name)
+}
+
+// instrumentedInterface an Interface recording the operations of InstrumentedStore and InstrumentedTraffic
+type instrumentedInterface struct {
+	Interface
+	store   *InstrumentedStore
+	traffic *InstrumentedTraffic
+}
+
+// NewInstrumentedInterface records the monitor and traffic operations of the database shared by the controllers, the
+// billing and the users are passed through unobserved
+func NewInstrumentedInterface(db Interface) Interface {
+	return &instrumentedInterface{Interface: db, store: NewInstrumentedStore(db), traffic: NewInstrumentedTraffic(db)}
+}
+
+func (i *instrumentedInterface) InsertMonitor(ctx context.Context, monitors ...*resources.Monitor) error {
+	return i.store.InsertMonitor(ctx, monitors...)
+}
+
+func (i *instrumentedInterface) InsertMonitorBatch(ctx context.Context, monitors []*resources.Monitor) error {
+	return i.store.InsertMonitorBatch(ctx, monitors)
+}
+
+func (i *instrumentedInterface) ReplaceMonitors(ctx context.Context, monitors ...*resources.Monitor) error {
+	return i.store.ReplaceMonitors(ctx, monitors...)
+}
+
+func (i *instrumentedInterface) GetDistinctMonitorCombinations(startTime, endTime time.Time, namespace string) ([]resources.Monitor, error) {
+	return i.store.GetDistinctMonitorCombinations(startTime, endTime, namespace)
+}
+
+func (i *instrumentedInterface) DropMonitorCollectionsOlderThan(days int) (int, error) {
+	return i.store.DropMonitorCollectionsOlderThan(days)
+}
+
+func (i *instrumentedInterface) GetTrafficSentBytes(startTime, endTime time.Time, namespace string, _type uint8, name string) (int64, error) {
+	return i.traffic.GetTrafficSentBytes(startTime, endTime, namespace, _type, name)
+}
+
+func (i *instrumentedInterface) GetTrafficRecvBytes(startTime, endTime time.Time, namespace string, _type uint8, name string) (int64, error) {
+	return i.traffic.GetTrafficRecvBytes(startTime, endTime, namespace, _type, name)
+}
+
+func (i *instrumentedInterface) GetPodTrafficSentBytes(startTime, endTime time.Time, namespace string, name string) (int64, error) {
+	return i.traffic.GetPodTrafficSentBytes(startTime, endTime, namespace, name)
+}
+
+func (i *instrumentedInterface) GetPodTrafficRecvBytes(startTime, endTime time.Time, namespace string, name string) (int64, error) {
+	return i.traffic.GetPodTrafficRecvBytes(startTime, endTime, namespace, name)
+}
+
+// unwrapMonitorStore returns the store under the current connection of a ReconnectingStore and the InstrumentedStore,
+// to detect the optional interfaces of the store
+func unwrapMonitorStore(store MonitorStore) MonitorStore {
+	for {
+		switch s := store.(type) {
+		case *ReconnectingStore:
+			store = s.current()
+		case *InstrumentedStore:
+			store = s.MonitorStore
+		default:
+			return store
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// fixedStore returns the same results and error for every call
+type fixedStore struct {
+	MonitorStore
+	err      error
+	monitors []resources.Monitor
+	dropped  int
+	ttlDays  int
+}
+
+func (s *fixedStore) InsertMonitor(_ context.Context, _ ...*resources.Monitor) error {
+	return s.err
+}
+
+func (s *fixedStore) InsertMonitorBatch(_ context.Context, _ []*resources.Monitor) error {
+	return s.err
+}
+
+func (s *fixedStore) GetDistinctMonitorCombinations(_, _ time.Time, _ string) ([]resources.Monitor, error) {
+	return s.monitors, s.err
+}
+
+func (s *fixedStore) DropMonitorCollectionsOlderThan(_ int) (int, error) {
+	return s.dropped, s.err
+}
+
+func (s *fixedStore) SetMonitorTTL(_ context.Context, days int) (int, error) {
+	s.ttlDays = days
+	return 1, nil
+}
+
+// fixedTraffic returns the same bytes and error for every query
+type fixedTraffic struct {
+	Traffic
+	bytes int64
+	err   error
+}
+
+func (t *fixedTraffic) GetTrafficSentBytes(_, _ time.Time, _ string, _ uint8, _ string) (int64, error) {
+	return t.bytes, t.err
+}
+
+// observations returns the observed durations and the errors of the operation
+func observations(t *testing.T, operation string) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := operationDuration.WithLabelValues(operation).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), testutil.ToFloat64(operationErrors.WithLabelValues(operation))
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	ctx := context.Background()
+	failure := errors.New("server selection timeout")
+	tests := []struct {
+		name      string
+		operation string
+		err       error
+		call      func(store MonitorStore) (interface{}, error)
+		want      interface{}
+	}{
+		{name: "insert", operation: OperationInsert, call: func(store MonitorStore) (interface{}, error) {
+			return nil, store.InsertMonitor(ctx, &resources.Monitor{})
+		}},
+		{name: "insert failed", operation: OperationInsert, err: failure, call: func(store MonitorStore) (interface{}, error) {
+			return nil, store.InsertMonitorBatch(ctx, []*resources.Monitor{{}})
+		}},
+		{name: "insert detailed failed", operation: OperationInsert, err: failure, call: func(store MonitorStore) (interface{}, error) {
+			results := InsertMonitorDetailed(ctx, store, &resources.Monitor{}, &resources.Monitor{})
+			return len(results), results[0]
+		}, want: 2},
+		{name: "distinct", operation: OperationDistinct, call: func(store MonitorStore) (interface{}, error) {
+			return store.GetDistinctMonitorCombinations(time.Time{}, time.Time{}, "ns-a")
+		}, want: []resources.Monitor{{Category: "ns-a", Name: "app-a"}}},
+		{name: "drop failed", operation: OperationDrop, err: failure, call: func(store MonitorStore) (interface{}, error) {
+			return store.DropMonitorCollectionsOlderThan(30)
+		}, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInstrumentedStore(&fixedStore{err: tt.err, monitors: []resources.Monitor{{Category: "ns-a", Name: "app-a"}}, dropped: 3})
+			count, errs := observations(t, tt.operation)
+			got, err := tt.call(store)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("result = %v, want %v", got, tt.want)
+			}
+			wantErrs := 0.0
+			if tt.err != nil {
+				wantErrs = 1
+			}
+			if gotCount, gotErrs := observations(t, tt.operation); gotCount-count != 1 || gotErrs-errs != wantErrs {
+				t.Errorf("observed %d durations and %v errors, want 1 and %v", gotCount-count, gotErrs-errs, wantErrs)
+			}
+		})
+	}
+
+	// the optional interfaces of the store under the instrumentation are still detected
+	underlying := &fixedStore{}
+	reconnecting, err := NewReconnectingStore(ctx, func(_ context.Context) (MonitorStore, error) {
+		return NewInstrumentedStore(underlying), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetMonitorTTL(ctx, reconnecting, 30); err != nil || underlying.ttlDays != 30 {
+		t.Errorf("SetMonitorTTL() error = %v, days = %d, want 30", err, underlying.ttlDays)
+	}
+}
+
+func TestInstrumentedTraffic(t *testing.T) {
+	failure := errors.New("context deadline exceeded")
+	for _, err := range []error{nil, failure} {
+		traffic := NewInstrumentedTraffic(&fixedTraffic{bytes: 1024, err: err})
+		count, errs := observations(t, OperationTrafficBytes)
+		bytes, gotErr := traffic.GetTrafficSentBytes(time.Time{}, time.Time{}, "ns-a", 0, "app-a")
+		if bytes != 1024 || !errors.Is(gotErr, err) {
+			t.Errorf("GetTrafficSentBytes() = %d, %v, want 1024, %v", bytes, gotErr, err)
+		}
+		wantErrs := 0.0
+		if err != nil {
+			wantErrs = 1
+		}
+		if gotCount, gotErrs := observations(t, OperationTrafficBytes); gotCount-count != 1 || gotErrs-errs != wantErrs {
+			t.Errorf("observed %d durations and %v errors, want 1 and %v", gotCount-count, gotErrs-errs, wantErrs)
+		}
+	}
+}
@@ -16,10 +16,12 @@ package mongo
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/labring/sealos/controllers/pkg/common"
@@ -31,11 +33,13 @@ import (
 	"github.com/labring/sealos/controllers/pkg/crypto"
 	"github.com/labring/sealos/controllers/pkg/resources"
 	"github.com/labring/sealos/controllers/pkg/utils/logger"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -72,6 +76,18 @@ type mongoDB struct {
 	PricesConn        string
 	PropertiesConn    string
 	TrafficConn       string
+	// MonitorRoutes routes the resource name to the monitor collection group, see database.MonitorCollectionRoutes
+	MonitorRoutes map[string]string
+	// ReadClient the separate connection of the monitor reads, see database.MongoReadURI. nil uses Client
+	ReadClient *mongo.Client
+	// ReadPreference of the monitor reads, see database.MongoReadPreference. nil uses the client default
+	ReadPreference *readpref.ReadPref
+	// ReadMaxStaleness of the read preference, see database.MongoReadMaxStaleness. 0 doesn't bound the staleness
+	ReadMaxStaleness time.Duration
+	// ServerVersion the version of the mongo server, eg: 5.0.24. "" if unknown
+	ServerVersion string
+	// collections caches the kinds of the monitor collections by the database and the name, see monitorCollection
+	collections sync.Map
 }
 
 type AccountBalanceSpecBSON struct {
@@ -82,6 +98,11 @@ type AccountBalanceSpecBSON struct {
 }
 
 func (m *mongoDB) Disconnect(ctx context.Context) error {
+	if m.ReadClient != nil {
+		if err := m.ReadClient.Disconnect(ctx); err != nil {
+			return err
+		}
+	}
 	return m.Client.Disconnect(ctx)
 }
 
@@ -239,16 +260,303 @@ func (m *mongoDB) SaveBillings(billing ...*resources.Billing) error {
 
 // InsertMonitor insert monitor data to mongodb collection monitor + time (eg: monitor_20200101)
 // The monitor data is saved daily 2020-12-01 00:00:00 - 2020-12-01 23:59:59 => monitor_20201201
+// The routed resources are split to the collections of the groups (eg: monitor_traffic_20201201)
+// The returned error is classified by retry.IsTransient / retry.IsPermanent
 func (m *mongoDB) InsertMonitor(ctx context.Context, monitors ...*resources.Monitor) error {
 	if len(monitors) == 0 {
 		return nil
 	}
-	var manyMonitor []interface{}
+	monitors = database.UniqueMonitors(monitors)
+	enumGroups := m.monitorEnumGroups()
+	manyMonitor := make(map[string][]*resources.Monitor)
 	for i := range monitors {
-		manyMonitor = append(manyMonitor, monitors[i])
+		for group, monitor := range splitMonitor(monitors[i], enumGroups) {
+			manyMonitor[group] = append(manyMonitor[group], monitor)
+		}
 	}
-	_, err := m.getMonitorCollection(monitors[0].Time).InsertMany(ctx, manyMonitor)
-	return err
+	for _, group := range m.monitorGroups() {
+		if len(manyMonitor[group]) == 0 {
+			continue
+		}
+		if err := m.insertUniqueMonitors(ctx, m.getMonitorGroupCollectionName(group, monitors[0].Time), manyMonitor[group]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertMonitorBatch inserts the monitors of many namespaces with one bulk insert per collection,
+// the monitors are grouped by the day and the route group, eg: a batch flushed around midnight spans two days.
+// The returned error is classified by retry.IsTransient / retry.IsPermanent
+func (m *mongoDB) InsertMonitorBatch(ctx context.Context, monitors []*resources.Monitor) error {
+	monitors = database.UniqueMonitors(monitors)
+	enumGroups := m.monitorEnumGroups()
+	var collections []string
+	manyMonitor := make(map[string][]*resources.Monitor)
+	for i := range monitors {
+		for group, monitor := range splitMonitor(monitors[i], enumGroups) {
+			name := m.getMonitorGroupCollectionName(group, monitors[i].Time)
+			if _, ok := manyMonitor[name]; !ok {
+				collections = append(collections, name)
+			}
+			manyMonitor[name] = append(manyMonitor[name], monitor)
+		}
+	}
+	for _, name := range collections {
+		if err := m.insertUniqueMonitors(ctx, name, manyMonitor[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertUniqueMonitors inserts the monitors whose id is not in the collection yet, the monitors are returned by
+// database.UniqueMonitors. The collections with the unique index of the ids skip the duplicates on the insert.
+// The time series and the collections created by the inserts of the former controllers have no unique index, so the
+// stored ids in the time range of the monitors are checked before the insert, the same monitors inserted concurrently
+// are not excluded there.
+func (m *mongoDB) insertUniqueMonitors(ctx context.Context, name string, monitors []*resources.Monitor) error {
+	kind, err := m.insertMonitorCollection(ctx, name)
+	if err != nil {
+		return classifyError(err)
+	}
+	coll := m.Client.Database(m.AccountDB).Collection(name)
+	missing := monitors
+	if !kind.UniqueIDs {
+		stored, err := storedMonitorIDs(ctx, coll, monitors)
+		if err != nil {
+			return err
+		}
+		missing = database.ExcludeStoredMonitors(monitors, stored)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(missing))
+	for i, monitor := range missing {
+		docs[i] = monitor
+	}
+	_, err = coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(!kind.UniqueIDs))
+	if kind.UniqueIDs && onlyDuplicateKeyErrors(err) {
+		return nil
+	}
+	return classifyError(err)
+}
+
+// onlyDuplicateKeyErrors reports if all the failed documents of the bulk insert were already in the collection
+func onlyDuplicateKeyErrors(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr.WriteError) {
+			return false
+		}
+	}
+	return true
+}
+
+// storedMonitorIDs returns the ids of the monitors already in the collection
+func storedMonitorIDs(ctx context.Context, coll *mongo.Collection, monitors []*resources.Monitor) (map[string]bool, error) {
+	start, end := database.MonitorTimeRange(monitors)
+	filter := bson.M{
+		"time":       bson.M{"$gte": start, "$lte": end},
+		"monitor_id": bson.M{"$in": database.MonitorIDs(monitors)},
+	}
+	cur, err := coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"monitor_id": 1}))
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	var found []struct {
+		MonitorID string `bson:"monitor_id"`
+	}
+	if err := cur.All(ctx, &found); err != nil {
+		return nil, classifyError(err)
+	}
+	stored := make(map[string]bool, len(found))
+	for _, f := range found {
+		stored[f.MonitorID] = true
+	}
+	return stored, nil
+}
+
+// InsertMonitorDetailed inserts the monitors with unordered bulk inserts, so a poison monitor (eg: a document too large)
+// doesn't fail the others, and returns the errors of the failed monitors by index. The monitors may belong to different days,
+// a routed monitor is split to the collections of the groups and fails if any of its parts fails.
+func (m *mongoDB) InsertMonitorDetailed(ctx context.Context, monitors ...*resources.Monitor) database.MonitorInsertResults {
+	results := make(database.MonitorInsertResults)
+	enumGroups := m.monitorEnumGroups()
+	var collections []string
+	parts := make(map[string][]*resources.Monitor)
+	indexes := make(map[string][]int)
+	seen := make(map[string]bool)
+	for i, monitor := range monitors {
+		unique := *monitor
+		unique.MonitorID = resources.MonitorID(monitor)
+		// the later copies of a record are inserted by the first one
+		if seen[unique.MonitorID] {
+			continue
+		}
+		seen[unique.MonitorID] = true
+		for group, part := range splitMonitor(&unique, enumGroups) {
+			name := m.getMonitorGroupCollectionName(group, monitor.Time)
+			if _, ok := parts[name]; !ok {
+				collections = append(collections, name)
+			}
+			parts[name] = append(parts[name], part)
+			indexes[name] = append(indexes[name], i)
+		}
+	}
+	for _, name := range collections {
+		for j, err := range m.insertUnorderedMonitors(ctx, name, parts[name]) {
+			results[indexes[name][j]] = err
+		}
+	}
+	return results
+}
+
+// insertUnorderedMonitors inserts the monitors not in the collection yet with an unordered bulk insert,
+// and returns the errors of the failed monitors by their index in monitors, see insertUniqueMonitors
+func (m *mongoDB) insertUnorderedMonitors(ctx context.Context, name string, monitors []*resources.Monitor) database.MonitorInsertResults {
+	kind, err := m.insertMonitorCollection(ctx, name)
+	if err != nil {
+		return database.FailedMonitors(len(monitors), classifyError(err))
+	}
+	coll := m.Client.Database(m.AccountDB).Collection(name)
+	var stored map[string]bool
+	if !kind.UniqueIDs {
+		if stored, err = storedMonitorIDs(ctx, coll, monitors); err != nil {
+			return database.FailedMonitors(len(monitors), err)
+		}
+	}
+	var (
+		docs       []interface{}
+		docIndexes []int
+	)
+	failed := make(database.MonitorInsertResults)
+	for i, monitor := range monitors {
+		if stored[monitor.MonitorID] {
+			continue
+		}
+		// the driver fails the whole insert on a document too large, it's failed alone before the insert
+		if err := checkMonitorDocument(monitor); err != nil {
+			failed[i] = err
+			continue
+		}
+		docs = append(docs, monitor)
+		docIndexes = append(docIndexes, i)
+	}
+	if len(docs) == 0 {
+		return failed
+	}
+	_, err = coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return failed
+	}
+	var bulkErr mongo.BulkWriteException
+	// the write concern errors and the network errors leave the inserted documents unknown, all of them are retried
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		for _, i := range docIndexes {
+			failed[i] = classifyError(err)
+		}
+		return failed
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		// the monitor was already inserted
+		if kind.UniqueIDs && mongo.IsDuplicateKeyError(writeErr.WriteError) {
+			continue
+		}
+		failed[docIndexes[writeErr.Index]] = classifyError(mongo.WriteErrors{writeErr.WriteError})
+	}
+	return failed
+}
+
+// maxMonitorDocumentSize the max bson document size of the mongo server
+const maxMonitorDocumentSize = 16 * 1024 * 1024
+
+// checkMonitorDocument fails permanently if the monitor can't be stored as a document
+func checkMonitorDocument(monitor *resources.Monitor) error {
+	data, err := bson.Marshal(monitor)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("failed to marshal monitor %s/%s: %w", monitor.Category, monitor.Name, err))
+	}
+	if len(data) > maxMonitorDocumentSize {
+		return retry.Permanent(fmt.Errorf("monitor %s/%s is %d bytes, larger than the max document size %d",
+			monitor.Category, monitor.Name, len(data), maxMonitorDocumentSize))
+	}
+	return nil
+}
+
+// ReplaceMonitors deletes the monitors of the idempotency keys from the collections of their days and inserts the monitors.
+// The monitor collections don't support upserts by the key, a failed insert after the deletes is corrected by the retry of the same monitors.
+// The time series collections of mongo before 7.0 (created by the former controllers) can't delete by the key, the monitors
+// already stored there are kept and their replacements skipped, so a retry doesn't count them twice.
+func (m *mongoDB) ReplaceMonitors(ctx context.Context, monitors ...*resources.Monitor) error {
+	monitorsByDay := make(map[time.Time][]*resources.Monitor)
+	for _, monitor := range monitors {
+		if monitor.IdempotencyKey != "" {
+			day := monitor.Time.UTC().Truncate(24 * time.Hour)
+			monitorsByDay[day] = append(monitorsByDay[day], monitor)
+		}
+	}
+	kept := make(map[string]bool)
+	for day, keyed := range monitorsByDay {
+		filter := bson.M{"idempotency_key": bson.M{"$in": database.IdempotencyKeys(keyed)}}
+		for _, group := range m.monitorGroups() {
+			coll := m.getMonitorGroupCollection(group, day)
+			typ, err := m.monitorCollectionType(ctx, coll.Name())
+			if err != nil {
+				return classifyError(err)
+			}
+			if typ == "" {
+				continue
+			}
+			if deletableByKey(typ, m.ServerVersion) {
+				if _, err := coll.DeleteMany(ctx, filter); err != nil {
+					return classifyError(err)
+				}
+				continue
+			}
+			if err := storedIdempotencyKeys(ctx, coll, filter, kept); err != nil {
+				return err
+			}
+		}
+	}
+	if len(kept) > 0 {
+		logger.Info("kept the monitors of the time series not writable by the server", "version", m.ServerVersion, "count", len(kept))
+		monitors = excludeKeptMonitors(monitors, kept)
+	}
+	return m.InsertMonitorBatch(ctx, monitors)
+}
+
+// storedIdempotencyKeys adds the idempotency keys of the monitors of the collection matched by the filter to the keys
+func storedIdempotencyKeys(ctx context.Context, coll *mongo.Collection, filter interface{}, keys map[string]bool) error {
+	cur, err := coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"idempotency_key": 1}))
+	if err != nil {
+		return classifyError(err)
+	}
+	var found []struct {
+		IdempotencyKey string `bson:"idempotency_key"`
+	}
+	if err := cur.All(ctx, &found); err != nil {
+		return classifyError(err)
+	}
+	for _, f := range found {
+		keys[f.IdempotencyKey] = true
+	}
+	return nil
+}
+
+// excludeKeptMonitors returns the monitors whose idempotency key is not kept
+func excludeKeptMonitors(monitors []*resources.Monitor, kept map[string]bool) []*resources.Monitor {
+	var replacing []*resources.Monitor
+	for _, monitor := range monitors {
+		if monitor.IdempotencyKey == "" || !kept[monitor.IdempotencyKey] {
+			replacing = append(replacing, monitor)
+		}
+	}
+	return replacing
 }
 
 func (m *mongoDB) GetDistinctMonitorCombinations(startTime, endTime time.Time, namespace string) ([]resources.Monitor, error) {
@@ -268,23 +576,90 @@ func (m *mongoDB) GetDistinctMonitorCombinations(startTime, endTime time.Time, n
 			},
 		}}},
 	}
-	cursor, err := m.getMonitorCollection(startTime).Aggregate(context.Background(), pipeline)
+	var monitors []resources.Monitor
+	seen := make(map[string]bool)
+	handle := func(cursor *mongo.Cursor) error {
+		var result = make(map[string]resources.Monitor, 1)
+		if err := cursor.Decode(result); err != nil {
+			return fmt.Errorf("decode error: %v", err)
+		}
+		monitor := result["_id"]
+		if key := fmt.Sprintf("%s/%d/%s", monitor.Category, monitor.Type, monitor.Name); !seen[key] {
+			seen[key] = true
+			monitors = append(monitors, monitor)
+		}
+		return nil
+	}
+	// a window crossing the midnight is in the daily collections of both days, the combinations are merged.
+	// The combinations of the routed resources are only in the group collections, eg: the traffic of a pod
+	for day := startTime.UTC().Truncate(24 * time.Hour); day.Before(endTime); day = day.AddDate(0, 0, 1) {
+		for _, group := range m.monitorGroups() {
+			if err := m.aggregateMonitorCollection(m.getMonitorGroupWindowReadCollection(group, day, endTime), pipeline, handle); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return monitors, nil
+}
+
+func (m *mongoDB) aggregateMonitorCollection(coll *mongo.Collection, pipeline mongo.Pipeline, handle func(cursor *mongo.Cursor) error) error {
+	cursor, err := coll.Aggregate(context.Background(), pipeline)
 	if err != nil {
-		return nil, fmt.Errorf("aggregate error: %v", err)
+		return fmt.Errorf("aggregate error: %v", err)
 	}
 	defer cursor.Close(context.Background())
-	var monitors []resources.Monitor
 	for cursor.Next(context.Background()) {
-		var result = make(map[string]resources.Monitor, 1)
-		if err := cursor.Decode(result); err != nil {
-			return nil, fmt.Errorf("decode error: %v", err)
+		if err := handle(cursor); err != nil {
+			return err
 		}
-		monitors = append(monitors, result["_id"])
 	}
 	if err := cursor.Err(); err != nil {
-		return nil, fmt.Errorf("cursor error: %v", err)
+		return fmt.Errorf("cursor error: %v", err)
 	}
-	return monitors, nil
+	return nil
+}
+
+// QueryMonitors queries the daily monitor collections one by one, so that large time ranges are streamed instead of buffered.
+// With monitor routes, the monitors of a day are sorted by time in each group collection.
+func (m *mongoDB) QueryMonitors(ctx context.Context, namespace string, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	startTime, endTime = startTime.UTC(), endTime.UTC()
+	filter := bson.M{
+		"category": namespace,
+		"time": bson.M{
+			"$gte": startTime,
+			"$lt":  endTime,
+		},
+	}
+	findOptions := options.Find().SetSort(bson.D{primitive.E{Key: "time", Value: 1}})
+	for day := startTime.Truncate(24 * time.Hour); day.Before(endTime); day = day.AddDate(0, 0, 1) {
+		for _, group := range m.monitorGroups() {
+			if err := m.queryMonitorCollection(ctx, m.getMonitorGroupReadCollection(group, day), filter, findOptions, handle); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *mongoDB) queryMonitorCollection(ctx context.Context, coll *mongo.Collection, filter interface{}, findOptions *options.FindOptions, handle func(monitor *resources.Monitor) error) error {
+	cur, err := coll.Find(ctx, filter, findOptions)
+	if err != nil {
+		return fmt.Errorf("find error: %v", err)
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var monitor resources.Monitor
+		if err := cur.Decode(&monitor); err != nil {
+			return fmt.Errorf("decode error: %v", err)
+		}
+		if err := handle(&monitor); err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("cursor error: %v", err)
+	}
+	return nil
 }
 
 func (m *mongoDB) GetAllPricesMap() (map[string]resources.Price, error) {
@@ -421,29 +796,45 @@ func (m *mongoDB) GenerateBillingData(startTime, endTime time.Time, prols *resou
 		{{Key: "$project", Value: projectStage}},
 	}
 
-	cursor, err := m.getMonitorCollection(startTime).Aggregate(context.Background(), pipeline)
-	if err != nil {
-		return nil, 0, fmt.Errorf("aggregate error: %v", err)
+	type usedResult struct {
+		Type      uint8                 `bson:"type"`
+		Namespace string                `bson:"category"`
+		Name      string                `bson:"name"`
+		Used      resources.EnumUsedMap `bson:"used"`
+	}
+	// the used of the routed resources are aggregated in the group collections, merge them by the app
+	var results []*usedResult
+	resultIndex := make(map[string]*usedResult)
+	for _, group := range m.monitorGroups() {
+		err = m.aggregateMonitorCollection(m.getMonitorGroupCollection(group, startTime), pipeline, func(cursor *mongo.Cursor) error {
+			var result usedResult
+			if err := cursor.Decode(&result); err != nil {
+				return fmt.Errorf("decode error: %v", err)
+			}
+			key := fmt.Sprintf("%s/%d/%s", result.Namespace, result.Type, result.Name)
+			if merged, ok := resultIndex[key]; ok {
+				for enum, used := range result.Used {
+					merged.Used[enum] += used
+				}
+				return nil
+			}
+			if result.Used == nil {
+				result.Used = resources.EnumUsedMap{}
+			}
+			resultIndex[key] = &result
+			results = append(results, &result)
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
 	}
-	defer cursor.Close(context.Background())
 
 	var appCostsMap = make(map[string]map[uint8][]resources.AppCost)
 	// map[ns/type]int64
 	var nsTypeAmount = make(map[string]int64)
 
-	for cursor.Next(context.Background()) {
-		var result struct {
-			Type      uint8                 `bson:"type"`
-			Namespace string                `bson:"category"`
-			Name      string                `bson:"name"`
-			Used      resources.EnumUsedMap `bson:"used"`
-		}
-
-		err := cursor.Decode(&result)
-		if err != nil {
-			return nil, 0, fmt.Errorf("decode error: %v", err)
-		}
-
+	for _, result := range results {
 		//TODO delete
 		//logger.Info("generate billing data", "result", result)
 
@@ -462,7 +853,7 @@ func (m *mongoDB) GenerateBillingData(startTime, endTime time.Time, prols *resou
 		for property := range result.Used {
 			if prop, ok := prols.EnumMap[property]; ok {
 				if prop.UnitPrice > 0 {
-					appCost.UsedAmount[property] = int64(math.Ceil(float64(result.Used[property]) * prop.UnitPrice))
+					appCost.UsedAmount[property] = prop.UsedAmount(result.Used[property])
 					appCost.Amount += appCost.UsedAmount[property]
 				}
 			}
@@ -506,10 +897,6 @@ func (m *mongoDB) GenerateBillingData(startTime, endTime time.Time, prols *resou
 			//logger.Info("generate billing data", "billing", billing)
 		}
 	}
-
-	if err = cursor.Err(); err != nil {
-		return nil, 0, fmt.Errorf("cursor error: %v", err)
-	}
 	return orderID, amount, nil
 }
 
@@ -886,9 +1273,15 @@ func (m *mongoDB) CreateBillingIfNotExist() error {
 	return nil
 }
 
-// CreateMonitorTimeSeriesIfNotExist creates the time series table for monitor
+// CreateMonitorTimeSeriesIfNotExist creates the monitor collections of the day of all groups in advance, they are regular
+// collections, see createMonitorCollectionIfNotExist
 func (m *mongoDB) CreateMonitorTimeSeriesIfNotExist(collTime time.Time) error {
-	return m.CreateTimeSeriesIfNotExist(m.AccountDB, m.getMonitorCollectionName(collTime))
+	for _, group := range m.monitorGroups() {
+		if err := m.createMonitorCollectionIfNotExist(context.TODO(), m.getMonitorGroupCollectionName(group, collTime)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (m *mongoDB) CreateTimeSeriesIfNotExist(dbName, collectionName string) error {
@@ -905,26 +1298,114 @@ func (m *mongoDB) CreateTimeSeriesIfNotExist(dbName, collectionName string) erro
 	return m.Client.Database(dbName).RunCommand(context.TODO(), cmd).Err()
 }
 
-func (m *mongoDB) DropMonitorCollectionsOlderThan(days int) error {
+func (m *mongoDB) DropMonitorCollectionsOlderThan(days int) (int, error) {
 	db := m.Client.Database(m.AccountDB)
 	// Get the current time minus the number of days
-	cutoffDate := time.Now().UTC().AddDate(0, 0, -days)
-	cutoffName := m.getMonitorCollectionName(cutoffDate)
+	cutoffDate := time.Now().UTC().AddDate(0, 0, -days).Truncate(24 * time.Hour)
 
 	collections, err := db.ListCollectionNames(context.Background(), bson.M{})
 	if err != nil {
-		return err
+		return 0, err
 	}
+	dropped := 0
 	for i := range collections {
-		// Check if the collection name starts with the prefix and is older than the cutoff date
-		if strings.HasPrefix(collections[i], m.MonitorConnPrefix) && collections[i] < cutoffName {
+		// Check if the collection name starts with the prefix and is older than the cutoff date,
+		// the date is parsed so that the group collections (eg: monitor_traffic_20200101) are compared by the day as well
+		if date, ok := m.monitorCollectionDate(collections[i]); ok && date.Before(cutoffDate) {
 			if err := db.Collection(collections[i]).Drop(context.TODO()); err != nil {
-				return err
+				return dropped, err
 			}
+			m.forgetMonitorCollection(collections[i])
+			dropped++
 			logger.Info("dropped collection: ", collections[i])
 		}
 	}
-	return nil
+	return dropped, nil
+}
+
+// monitorCollections returns the names of the monitor collections, including the rollups and the aggregates
+func (m *mongoDB) monitorCollections(ctx context.Context) ([]string, error) {
+	collections, err := m.Client.Database(m.AccountDB).ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for i := range collections {
+		if strings.HasPrefix(collections[i], m.MonitorConnPrefix) {
+			names = append(names, collections[i])
+		}
+	}
+	return names, nil
+}
+
+// CountMonitorsByCategory counts the monitor data of the category (namespace) in all monitor collections
+func (m *mongoDB) CountMonitorsByCategory(category string) (int64, error) {
+	ctx := context.Background()
+	collections, err := m.monitorCollections(ctx)
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	var total int64
+	for _, name := range collections {
+		count, err := m.Client.Database(m.AccountDB).Collection(name).CountDocuments(ctx, bson.M{"category": category})
+		if err != nil {
+			return total, fmt.Errorf("failed to count monitors of %s in collection %s: %w", category, name, classifyError(err))
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// DeleteMonitorsByCategory deletes the monitor data of the category (namespace) from all monitor collections
+func (m *mongoDB) DeleteMonitorsByCategory(category string) (int64, error) {
+	ctx := context.Background()
+	collections, err := m.monitorCollections(ctx)
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	var total int64
+	for _, name := range collections {
+		deleted, err := deleteInBatches(ctx, m.Client.Database(m.AccountDB).Collection(name), bson.M{"category": category})
+		total += deleted
+		if err != nil {
+			return total, fmt.Errorf("failed to delete monitors of %s from collection %s: %w", category, name, classifyError(err))
+		}
+		if deleted > 0 {
+			logger.Info("deleted monitors", "category", category, "collection", name, "count", deleted)
+		}
+	}
+	return total, nil
+}
+
+// deleteInBatches deletes the documents matched by the filter by batches of database.PurgeBatchSize ids,
+// a single DeleteMany of a large tenant would hold the collection for long
+func deleteInBatches(ctx context.Context, coll *mongo.Collection, filter interface{}) (int64, error) {
+	var deleted int64
+	for {
+		cur, err := coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(database.PurgeBatchSize))
+		if err != nil {
+			return deleted, err
+		}
+		var docs []bson.M
+		if err = cur.All(ctx, &docs); err != nil {
+			return deleted, err
+		}
+		if len(docs) == 0 {
+			return deleted, nil
+		}
+		ids := make([]interface{}, 0, len(docs))
+		for _, doc := range docs {
+			ids = append(ids, doc["_id"])
+		}
+		result, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+		if len(docs) < database.PurgeBatchSize {
+			return deleted, nil
+		}
+	}
 }
 
 func (m *mongoDB) collectionExist(dbName, collectionName string) (bool, error) {
@@ -934,11 +1415,43 @@ func (m *mongoDB) collectionExist(dbName, collectionName string) (bool, error) {
 }
 
 func NewMongoInterface(ctx context.Context, URL string) (database.Interface, error) {
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(URL))
+	routes, err := parseMonitorRoutes(os.Getenv(database.MonitorCollectionRoutes))
+	if err != nil {
+		return nil, err
+	}
+	readPref, maxStaleness, err := parseReadPreference(os.Getenv(database.MongoReadPreference), os.Getenv(database.MongoReadMaxStaleness))
 	if err != nil {
 		return nil, err
 	}
+	clientOpts, err := clientOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := clientOpts.apply(options.Client().ApplyURI(URL))
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	readClient, err := connectReadClient(ctx, os.Getenv(database.MongoReadURI), clientOpts)
+	if err != nil {
+		_ = client.Disconnect(ctx)
+		return nil, err
+	}
 	err = client.Ping(ctx, nil)
+	if readClient != nil && err == nil {
+		err = readClient.Ping(ctx, readPref)
+	}
+	var version string
+	if err == nil {
+		// the time series are treated as not writable if the version is unknown
+		if version, err = serverVersion(ctx, client); err != nil {
+			logger.Warn("failed to get the mongo server version", "err", err)
+			err = nil
+		}
+	}
 	return &mongoDB{
 		Client:            client,
 		AccountDB:         DefaultAccountDBName,
@@ -951,5 +1464,10 @@ func NewMongoInterface(ctx context.Context, URL string) (database.Interface, err
 		PricesConn:        DefaultPricesConn,
 		PropertiesConn:    DefaultPropertiesConn,
 		TrafficConn:       DefaultTrafficConn,
+		MonitorRoutes:     routes,
+		ReadClient:        readClient,
+		ReadPreference:    readPref,
+		ReadMaxStaleness:  maxStaleness,
+		ServerVersion:     version,
 	}, err
 }
@@ -510,7 +510,7 @@ func TestMongoDB_DropMonitorCollectionsOlderThan(t *testing.T) {
 		}
 	}()
 	// 0711
-	if err = m.DropMonitorCollectionsOlderThan(30); err != nil {
+	if _, err = m.DropMonitorCollectionsOlderThan(30); err != nil {
 		t.Fatalf("failed to drop monitor collections older than 30 days: %v", err)
 	}
 }
@@ -0,0 +1,54 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	accountv1 "github.com/labring/sealos/controllers/account/api/v1"
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+var _ database.BillingReader = &mongoDB{}
+
+// QueryConsumptionBillings finds the consumption billings of the namespace in the billing collection sorted by time
+func (m *mongoDB) QueryConsumptionBillings(ctx context.Context, namespace string, startTime, endTime time.Time, handle func(billing *resources.Billing) error) error {
+	filter := bson.M{
+		"namespace": namespace,
+		"type":      accountv1.Consumption,
+		"time":      bson.M{"$gt": startTime.UTC(), "$lte": endTime.UTC()},
+	}
+	cursor, err := m.getBillingCollection().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "time", Value: 1}}))
+	if err != nil {
+		return classifyError(fmt.Errorf("failed to find the billings of %s: %w", namespace, err))
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		billing := &resources.Billing{}
+		if err := cursor.Decode(billing); err != nil {
+			return fmt.Errorf("failed to decode the billing: %w", err)
+		}
+		if err := handle(billing); err != nil {
+			return err
+		}
+	}
+	return classifyError(cursor.Err())
+}
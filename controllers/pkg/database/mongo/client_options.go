@@ -0,0 +1,105 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+// clientOptions the connection settings of the mongo clients overriding the uri and the driver defaults, zero keeps them
+type clientOptions struct {
+	MaxPoolSize            uint64
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	// TLSCAFile the root certificates of the server, enables the tls if set
+	TLSCAFile string
+}
+
+// clientOptionsFromEnv reads the settings of MONGO_MAX_POOL_SIZE, MONGO_CONNECT_TIMEOUT, MONGO_SERVER_SELECTION_TIMEOUT
+// and MONGO_TLS_CA_FILE, an invalid value fails the connect rather than silently keeping the default.
+func clientOptionsFromEnv() (clientOptions, error) {
+	var opts clientOptions
+	if value := os.Getenv(database.MongoMaxPoolSize); value != "" {
+		size, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q: %w", database.MongoMaxPoolSize, value, err)
+		}
+		opts.MaxPoolSize = size
+	}
+	for key, timeout := range map[string]*time.Duration{
+		database.MongoConnectTimeout:         &opts.ConnectTimeout,
+		database.MongoServerSelectionTimeout: &opts.ServerSelectionTimeout,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("invalid %s %q, must be a positive duration, eg: 10s", key, value)
+		}
+		*timeout = d
+	}
+	opts.TLSCAFile = os.Getenv(database.MongoTLSCAFile)
+	return opts, nil
+}
+
+// apply sets the settings on the client options parsed from the uri, so they take precedence over the uri options
+func (o clientOptions) apply(client *options.ClientOptions) (*options.ClientOptions, error) {
+	if o.MaxPoolSize > 0 {
+		client.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.ConnectTimeout > 0 {
+		client.SetConnectTimeout(o.ConnectTimeout)
+	}
+	if o.ServerSelectionTimeout > 0 {
+		client.SetServerSelectionTimeout(o.ServerSelectionTimeout)
+	}
+	if o.TLSCAFile != "" {
+		pem, err := os.ReadFile(o.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mongo tls ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in mongo tls ca file %s", o.TLSCAFile)
+		}
+		tlsConfig := client.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		tlsConfig.RootCAs = pool
+		client.SetTLSConfig(tlsConfig)
+	}
+	return client, nil
+}
+
+// Ping pings the primary, the health check of the monitor database reconnects the client if it fails persistently
+func (m *mongoDB) Ping(ctx context.Context) error {
+	return m.Client.Ping(ctx, readpref.Primary())
+}
+
+var _ database.Pinger = &mongoDB{}
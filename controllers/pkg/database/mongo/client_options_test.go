@@ -0,0 +1,118 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+func TestClientOptionsFromEnv(t *testing.T) {
+	t.Setenv(database.MongoMaxPoolSize, "20")
+	t.Setenv(database.MongoConnectTimeout, "5s")
+	t.Setenv(database.MongoServerSelectionTimeout, "3s")
+	opts, err := clientOptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := clientOptions{MaxPoolSize: 20, ConnectTimeout: 5 * time.Second, ServerSelectionTimeout: 3 * time.Second}
+	if opts != want {
+		t.Errorf("clientOptionsFromEnv() = %+v, want %+v", opts, want)
+	}
+	client, err := opts.apply(options.Client().ApplyURI("mongodb://127.0.0.1:1/?maxPoolSize=50&connectTimeoutMS=60000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the env overrides the uri
+	if *client.MaxPoolSize != 20 || *client.ConnectTimeout != 5*time.Second || *client.ServerSelectionTimeout != 3*time.Second {
+		t.Errorf("apply() = pool %d, connect timeout %s, server selection timeout %s, want 20, 5s, 3s",
+			*client.MaxPoolSize, *client.ConnectTimeout, *client.ServerSelectionTimeout)
+	}
+
+	for key, value := range map[string]string{
+		database.MongoMaxPoolSize:            "-1",
+		database.MongoConnectTimeout:         "5",
+		database.MongoServerSelectionTimeout: "0s",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := clientOptionsFromEnv(); err == nil {
+				t.Errorf("clientOptionsFromEnv() with %s=%q expected error", key, value)
+			}
+		})
+	}
+}
+
+func TestClientOptions_applyTLSCAFile(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (clientOptions{TLSCAFile: caFile}).apply(options.Client()); err == nil {
+		t.Error("apply() with an invalid ca file expected error")
+	}
+	if _, err := (clientOptions{TLSCAFile: filepath.Join(t.TempDir(), "missing.crt")}).apply(options.Client()); err == nil {
+		t.Error("apply() with a missing ca file expected error")
+	}
+}
+
+func TestMongoDB_Ping(t *testing.T) {
+	// the listener accepts the connections but never answers, the ping of the dead primary times out
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	ctx := context.Background()
+	opts, err := clientOptions{ServerSelectionTimeout: 200 * time.Millisecond}.apply(
+		options.Client().ApplyURI("mongodb://" + listener.Addr().String() + "/?directConnection=true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Disconnect(ctx) }()
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := (&mongoDB{Client: client}).Ping(ctx); err == nil {
+		t.Error("Ping() of the unresponsive server expected error")
+	}
+}
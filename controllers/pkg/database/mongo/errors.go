@@ -0,0 +1,63 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+const (
+	// https://www.mongodb.com/docs/manual/reference/error-codes/
+	errCodeUnauthorized         = 13
+	errCodeAuthenticationFailed = 18
+	// errCodeNamespaceExists the collection was created concurrently, eg: by an insert
+	errCodeNamespaceExists = 48
+)
+
+// classifyError marks the mongo error as transient, config or permanent for the retry layer
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if mongo.IsTimeout(err) || mongo.IsNetworkError(err) || errors.Is(err, context.DeadlineExceeded) {
+		return retry.Transient(err)
+	}
+	var selectionErr topology.ServerSelectionError
+	if errors.As(err, &selectionErr) {
+		return retry.Transient(err)
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		switch {
+		case serverErr.HasErrorLabel("RetryableWriteError"), serverErr.HasErrorLabel("TransientTransactionError"):
+			return retry.Transient(err)
+		case serverErr.HasErrorCode(errCodeUnauthorized), serverErr.HasErrorCode(errCodeAuthenticationFailed):
+			return retry.Config(err)
+		}
+	}
+	return retry.Permanent(err)
+}
+
+// hasErrorCode reports if the error is a server error of the code
+func hasErrorCode(err error, code int) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(code)
+}
@@ -0,0 +1,103 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// monitorAggregateStateSuffix the collection of the latest aggregated period of each granularity
+const monitorAggregateStateSuffix = "aggregate_state"
+
+// getMonitorAggregateCollection returns the collection of the granularity, eg: monitor_hourly, it has no day suffix so the retention keeps it
+func (m *mongoDB) getMonitorAggregateCollection(granularity database.MonitorGranularity) *mongo.Collection {
+	return m.Client.Database(m.AccountDB).Collection(m.MonitorConnPrefix + "_" + string(granularity))
+}
+
+func (m *mongoDB) getMonitorAggregateStateCollection() *mongo.Collection {
+	return m.Client.Database(m.AccountDB).Collection(m.MonitorConnPrefix + "_" + monitorAggregateStateSuffix)
+}
+
+// SaveMonitorAggregates upserts the aggregates of the period by category, type, name and time, then advances the latest period.
+// A re-run period replaces the same aggregates, the late monitors only add to them.
+func (m *mongoDB) SaveMonitorAggregates(ctx context.Context, granularity database.MonitorGranularity, start time.Time, aggregates []*resources.Monitor) error {
+	if err := granularity.Validate(); err != nil {
+		return err
+	}
+	start = start.UTC()
+	if len(aggregates) > 0 {
+		coll := m.getMonitorAggregateCollection(granularity)
+		if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "category", Value: 1}, {Key: "type", Value: 1}, {Key: "name", Value: 1}, {Key: "time", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}); err != nil {
+			return fmt.Errorf("failed to create index for monitor %s aggregates: %w", granularity, err)
+		}
+		models := make([]mongo.WriteModel, len(aggregates))
+		for i, aggregate := range aggregates {
+			models[i] = mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"category": aggregate.Category, "type": aggregate.Type, "name": aggregate.Name, "time": start}).
+				SetReplacement(aggregate).
+				SetUpsert(true)
+		}
+		if _, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return classifyError(err)
+		}
+	}
+	_, err := m.getMonitorAggregateStateCollection().UpdateOne(ctx, bson.M{"_id": string(granularity)},
+		bson.M{"$max": bson.M{"latest": start}, "$set": bson.M{"updated_at": time.Now().UTC()}}, options.Update().SetUpsert(true))
+	return classifyError(err)
+}
+
+// QueryMonitorAggregates streams the aggregates of all namespaces in [startTime, endTime) sorted by time
+func (m *mongoDB) QueryMonitorAggregates(ctx context.Context, granularity database.MonitorGranularity, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	if err := granularity.Validate(); err != nil {
+		return err
+	}
+	filter := bson.M{
+		"time": bson.M{
+			"$gte": startTime.UTC(),
+			"$lt":  endTime.UTC(),
+		},
+	}
+	return m.queryMonitorCollection(ctx, m.getMonitorAggregateCollection(granularity), filter, options.Find().SetSort(bson.D{{Key: "time", Value: 1}}), handle)
+}
+
+// LatestMonitorAggregate returns the start of the latest aggregated period of the granularity, zero if none
+func (m *mongoDB) LatestMonitorAggregate(ctx context.Context, granularity database.MonitorGranularity) (time.Time, error) {
+	if err := granularity.Validate(); err != nil {
+		return time.Time{}, err
+	}
+	var state struct {
+		Latest time.Time `bson:"latest"`
+	}
+	err := m.getMonitorAggregateStateCollection().FindOne(ctx, bson.M{"_id": string(granularity)}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get the latest %s aggregate: %w", granularity, err)
+	}
+	return state.Latest.UTC(), nil
+}
@@ -0,0 +1,156 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collectionTypeTimeSeries the type of the time series collections in the collection specifications
+	collectionTypeTimeSeries = "timeseries"
+	// monitorIDIndexName the unique index of the monitor_id of the regular monitor collections
+	monitorIDIndexName = "monitor_id_unique"
+)
+
+// monitorCollection the kind of a daily monitor collection
+type monitorCollection struct {
+	// Type "collection" or "timeseries", "" if the collection doesn't exist
+	Type string
+	// UniqueIDs the monitor_id is unique by monitorIDIndexName, the collections created by the inserts of the former
+	// controllers and the time series don't have it
+	UniqueIDs bool
+}
+
+// serverVersion returns the version of the mongo server, eg: 5.0.24
+func serverVersion(ctx context.Context, client *mongo.Client) (string, error) {
+	var info struct {
+		Version string `bson:"version"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info)
+	return info.Version, err
+}
+
+// timeSeriesWritable reports if the server deletes and updates the documents of the time series collections by any
+// field. Mongo before 7.0 only does by the metaField, and the monitor time series have none. An unknown version is not.
+func timeSeriesWritable(version string) bool {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	return err == nil && major >= 7
+}
+
+// monitorCollectionIndexes the indexes of the daily monitor collections, the TTL index of the time is created by
+// SetMonitorTTL
+func monitorCollectionIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// the monitors inserted before the ids have none
+			Keys: bson.D{{Key: "monitor_id", Value: 1}},
+			Options: options.Index().SetName(monitorIDIndexName).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"monitor_id": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "category", Value: 1}, {Key: "time", Value: 1}},
+			Options: options.Index().SetName("category_time"),
+		},
+		{
+			// only the traffic monitors have a key
+			Keys: bson.D{{Key: "idempotency_key", Value: 1}},
+			Options: options.Index().SetName("idempotency_key").
+				SetPartialFilterExpression(bson.M{"idempotency_key": bson.M{"$exists": true}}),
+		},
+	}
+}
+
+// createMonitorCollectionIfNotExist creates the daily monitor collection as a regular collection with its indexes.
+// The time series are not used since mongo before 7.0 can't delete their monitors by the idempotency key, and a time
+// series can't have a unique index.
+func (m *mongoDB) createMonitorCollectionIfNotExist(ctx context.Context, name string) error {
+	if exist, err := m.collectionExist(m.AccountDB, name); exist || err != nil {
+		return err
+	}
+	db := m.Client.Database(m.AccountDB)
+	if err := db.CreateCollection(ctx, name); err != nil && !hasErrorCode(err, errCodeNamespaceExists) {
+		return fmt.Errorf("failed to create monitor collection %s: %w", name, err)
+	}
+	if _, err := db.Collection(name).Indexes().CreateMany(ctx, monitorCollectionIndexes()); err != nil {
+		return fmt.Errorf("failed to create the indexes of monitor collection %s: %w", name, err)
+	}
+	return nil
+}
+
+// monitorCollectionType returns the type of the monitor collection in its specification, "collection" or "timeseries",
+// or "" if it doesn't exist yet
+func (m *mongoDB) monitorCollectionType(ctx context.Context, name string) (string, error) {
+	coll, err := m.monitorCollection(ctx, name)
+	return coll.Type, err
+}
+
+// monitorCollection returns the kind of the monitor collection. The kind of a daily collection doesn't change, it is
+// cached until the collection is dropped, the missing collection is not.
+func (m *mongoDB) monitorCollection(ctx context.Context, name string) (monitorCollection, error) {
+	key := m.AccountDB + "." + name
+	if coll, ok := m.collections.Load(key); ok {
+		return coll.(monitorCollection), nil
+	}
+	db := m.Client.Database(m.AccountDB)
+	specs, err := db.ListCollectionSpecifications(ctx, bson.M{"name": name})
+	if err != nil || len(specs) == 0 {
+		return monitorCollection{}, err
+	}
+	coll := monitorCollection{Type: specs[0].Type}
+	if coll.Type != collectionTypeTimeSeries {
+		indexes, err := db.Collection(name).Indexes().ListSpecifications(ctx)
+		if err != nil {
+			return monitorCollection{}, err
+		}
+		for _, index := range indexes {
+			if index.Name == monitorIDIndexName && index.Unique != nil && *index.Unique {
+				coll.UniqueIDs = true
+			}
+		}
+	}
+	m.collections.Store(key, coll)
+	return coll, nil
+}
+
+// insertMonitorCollection returns the kind of the monitor collection to insert into, the missing collection is created
+// with its indexes rather than by the insert
+func (m *mongoDB) insertMonitorCollection(ctx context.Context, name string) (monitorCollection, error) {
+	coll, err := m.monitorCollection(ctx, name)
+	if err != nil || coll.Type != "" {
+		return coll, err
+	}
+	if err := m.createMonitorCollectionIfNotExist(ctx, name); err != nil {
+		return coll, err
+	}
+	return m.monitorCollection(ctx, name)
+}
+
+// forgetMonitorCollection removes the dropped collection from the cache of the kinds
+func (m *mongoDB) forgetMonitorCollection(name string) {
+	m.collections.Delete(m.AccountDB + "." + name)
+}
+
+// deletableByKey reports if the monitors of the collection of the type can be deleted by their idempotency key
+func deletableByKey(collectionType, version string) bool {
+	return collectionType != collectionTypeTimeSeries || timeSeriesWritable(version)
+}
@@ -0,0 +1,216 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestDeletableByKey(t *testing.T) {
+	tests := []struct {
+		collectionType string
+		version        string
+		want           bool
+	}{
+		{collectionType: "collection", version: "5.0.24", want: true},
+		{collectionType: "collection", version: "", want: true},
+		{collectionType: collectionTypeTimeSeries, version: "5.0.24", want: false},
+		{collectionType: collectionTypeTimeSeries, version: "6.0.13", want: false},
+		{collectionType: collectionTypeTimeSeries, version: "7.0.2", want: true},
+		{collectionType: collectionTypeTimeSeries, version: "8.0.0-rc1", want: true},
+		// an unknown version is not trusted to delete from a time series
+		{collectionType: collectionTypeTimeSeries, version: "", want: false},
+		{collectionType: collectionTypeTimeSeries, version: "unknown", want: false},
+	}
+	for _, tt := range tests {
+		if got := deletableByKey(tt.collectionType, tt.version); got != tt.want {
+			t.Errorf("deletableByKey(%q, %q) = %v, want %v", tt.collectionType, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestExcludeKeptMonitors(t *testing.T) {
+	monitors := []*resources.Monitor{
+		{Name: "app-a", IdempotencyKey: "key-a"},
+		{Name: "app-b", IdempotencyKey: "key-b"},
+		{Name: "app-c"},
+	}
+	got := excludeKeptMonitors(monitors, map[string]bool{"key-a": true})
+	if len(got) != 2 || got[0].Name != "app-b" || got[1].Name != "app-c" {
+		t.Errorf("excludeKeptMonitors() = %v, want app-b and app-c", got)
+	}
+}
+
+func TestOnlyDuplicateKeyErrors(t *testing.T) {
+	writeErr := func(code int) mongo.BulkWriteError {
+		return mongo.BulkWriteError{WriteError: mongo.WriteError{Code: code}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil, want: false},
+		{name: "duplicates", err: mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{writeErr(11000), writeErr(11000)}}, want: true},
+		{name: "duplicate and another error", err: mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{writeErr(11000), writeErr(2)}}, want: false},
+		{name: "write concern", err: mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{writeErr(11000)},
+			WriteConcernError: &mongo.WriteConcernError{Code: 64}}, want: false},
+		{name: "network", err: errors.New("connection reset"), want: false},
+	}
+	for _, tt := range tests {
+		if got := onlyDuplicateKeyErrors(tt.err); got != tt.want {
+			t.Errorf("%s: onlyDuplicateKeyErrors() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMongoDB_InsertMonitorsUniqueIDs(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	ctx := context.Background()
+	db, err := NewMongoInterface(ctx, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		t.Fatalf("failed to connect mongo: error = %v", err)
+	}
+	defer func() {
+		if err = db.Disconnect(ctx); err != nil {
+			t.Errorf("failed to disconnect mongo: error = %v", err)
+		}
+	}()
+	m := db.(*mongoDB)
+	m.AccountDB = "sealos-resources-unique-test"
+	cleanup := func() {
+		if err := m.Client.Database(m.AccountDB).Drop(ctx); err != nil {
+			t.Errorf("failed to drop the test database: %v", err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// the collection created by the insert of a former controller has no index, the next day is created by the insert
+	legacy := m.getMonitorCollection(day)
+	if _, err := legacy.InsertOne(ctx, bson.M{"time": day, "category": "ns-b"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, at := range []time.Time{day, day.AddDate(0, 0, 1)} {
+		monitors := []*resources.Monitor{
+			{Time: at.Add(time.Minute), Category: "ns-a", Type: resources.AppType[resources.APP], Name: "app-a", Used: resources.EnumUsedMap{0: 1}},
+			{Time: at.Add(time.Minute), Category: "ns-a", Type: resources.AppType[resources.APP], Name: "app-b", Used: resources.EnumUsedMap{0: 1}},
+		}
+		// the first monitor inserted again among a new one, by the batch and by the detailed insert
+		if err := m.InsertMonitor(ctx, monitors[0]); err != nil {
+			t.Fatalf("InsertMonitor() error = %v", err)
+		}
+		if err := m.InsertMonitorBatch(ctx, monitors); err != nil {
+			t.Fatalf("InsertMonitorBatch() error = %v", err)
+		}
+		if results := m.InsertMonitorDetailed(ctx, monitors...); len(results) != 0 {
+			t.Fatalf("InsertMonitorDetailed() = %v, want the duplicates skipped", results)
+		}
+		count, err := m.getMonitorCollection(at).CountDocuments(ctx, bson.M{"category": "ns-a"})
+		if err != nil || count != 2 {
+			t.Errorf("monitors of %s = %d, %v, want 2", at.Format("20060102"), count, err)
+		}
+		coll, err := m.monitorCollection(ctx, m.getMonitorCollectionName(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := !at.Equal(day); coll.Type != "collection" || coll.UniqueIDs != want {
+			t.Errorf("collection of %s = %+v, want unique ids %v", at.Format("20060102"), coll, want)
+		}
+	}
+	if count, err := legacy.CountDocuments(ctx, bson.M{"category": "ns-b"}); err != nil || count != 1 {
+		t.Errorf("monitors of the former controller = %d, %v, want kept", count, err)
+	}
+}
+
+func TestMongoDB_ReplaceMonitorsTimeSeries(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	ctx := context.Background()
+	db, err := NewMongoInterface(ctx, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		t.Fatalf("failed to connect mongo: error = %v", err)
+	}
+	defer func() {
+		if err = db.Disconnect(ctx); err != nil {
+			t.Errorf("failed to disconnect mongo: error = %v", err)
+		}
+	}()
+	m := db.(*mongoDB)
+	m.AccountDB = "sealos-resources-replace-test"
+	cleanup := func() {
+		if err := m.Client.Database(m.AccountDB).Drop(ctx); err != nil {
+			t.Errorf("failed to drop the test database: %v", err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// the controllers before the regular collections created the time series
+	if err := m.CreateTimeSeriesIfNotExist(m.AccountDB, m.getMonitorCollectionName(day)); err != nil {
+		t.Fatal(err)
+	}
+	next := day.AddDate(0, 0, 1)
+	if err := m.CreateMonitorTimeSeriesIfNotExist(next); err != nil {
+		t.Fatal(err)
+	}
+	if typ, err := m.monitorCollectionType(ctx, m.getMonitorCollectionName(next)); err != nil || typ != "collection" {
+		t.Fatalf("type of the created collection = %q, %v, want a regular collection", typ, err)
+	}
+
+	app := resources.AppType[resources.APP]
+	for _, at := range []time.Time{day, next} {
+		end := at.Add(time.Hour)
+		traffic := func(used int64) *resources.Monitor {
+			return &resources.Monitor{Time: end.Add(-time.Minute), Category: "ns-a", Type: app, Name: "app-a",
+				Used: resources.EnumUsedMap{0: used}, IdempotencyKey: resources.TrafficMonitorKey("ns-a", app, "app-a", end)}
+		}
+		if err := m.ReplaceMonitors(ctx, traffic(100)); err != nil {
+			t.Fatalf("ReplaceMonitors() error = %v", err)
+		}
+		if err := m.ReplaceMonitors(ctx, traffic(200)); err != nil {
+			t.Fatalf("ReplaceMonitors() retry error = %v", err)
+		}
+		var got []*resources.Monitor
+		if err := m.QueryMonitors(ctx, "ns-a", at, at.AddDate(0, 0, 1), func(monitor *resources.Monitor) error {
+			got = append(got, monitor)
+			return nil
+		}); err != nil {
+			t.Fatalf("QueryMonitors() error = %v", err)
+		}
+		// the time series of a server before 7.0 keeps the first insert, the retry is never counted twice
+		want := int64(200)
+		if at.Equal(day) && !timeSeriesWritable(m.ServerVersion) {
+			want = 100
+		}
+		if len(got) != 1 || got[0].Used[0] != want {
+			t.Errorf("monitors of %s on mongo %s = %v, want one used %d", at.Format("20060102"), m.ServerVersion, got, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+// monitorGapsSuffix the collection of the monitor gap reports, eg: monitor_gaps, it has no day suffix so the retention keeps it
+const monitorGapsSuffix = "gaps"
+
+var _ database.MonitorGapStore = &mongoDB{}
+
+func (m *mongoDB) getMonitorGapsCollection() *mongo.Collection {
+	return m.Client.Database(m.AccountDB).Collection(m.MonitorConnPrefix + "_" + monitorGapsSuffix)
+}
+
+// SaveMonitorGapReport inserts the report into the monitor_gaps collection, the reports are never updated
+func (m *mongoDB) SaveMonitorGapReport(ctx context.Context, report *database.MonitorGapReport) error {
+	_, err := m.getMonitorGapsCollection().InsertOne(ctx, report)
+	return classifyError(err)
+}
@@ -0,0 +1,118 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// QueryMonitorPage returns a page of the monitors of the namespace sorted by (time, type, name).
+// The daily collections are read from the day of the cursor, the parts of a monitor split by the
+// monitor routes are merged back into one monitor so a page never ends between the parts.
+func (m *mongoDB) QueryMonitorPage(ctx context.Context, query database.MonitorPageQuery) (database.MonitorPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return database.MonitorPage{}, err
+	}
+	from := query.StartTime
+	if query.After != nil && query.After.Time.After(from) {
+		from = query.After.Time
+	}
+	var monitors []resources.Monitor
+	for day := from.Truncate(24 * time.Hour); day.Before(query.EndTime) && len(monitors) <= query.Limit; day = day.AddDate(0, 0, 1) {
+		// every group returns at most the remaining monitors, which covers the first remaining monitors of the merge
+		remaining := query.Limit + 1 - len(monitors)
+		findOptions := options.Find().SetSort(bson.D{
+			primitive.E{Key: "time", Value: 1}, primitive.E{Key: "type", Value: 1}, primitive.E{Key: "name", Value: 1},
+		}).SetLimit(int64(remaining))
+		var parts []resources.Monitor
+		for _, group := range m.monitorGroups() {
+			err := m.queryMonitorCollection(ctx, m.getMonitorGroupReadCollection(group, day), monitorPageFilter(query), findOptions, func(monitor *resources.Monitor) error {
+				parts = append(parts, *monitor)
+				return nil
+			})
+			if err != nil {
+				return database.MonitorPage{}, err
+			}
+		}
+		merged := mergeMonitorParts(parts)
+		if len(merged) > remaining {
+			merged = merged[:remaining]
+		}
+		monitors = append(monitors, merged...)
+	}
+	return database.NewMonitorPage(monitors, query.Limit), nil
+}
+
+func monitorPageFilter(query database.MonitorPageQuery) bson.M {
+	filter := bson.M{
+		"category": query.Namespace,
+		"time": bson.M{
+			"$gte": query.StartTime,
+			"$lt":  query.EndTime,
+		},
+	}
+	if query.Type != nil {
+		filter["type"] = *query.Type
+	}
+	if after := query.After; after != nil {
+		filter["$or"] = bson.A{
+			bson.M{"time": bson.M{"$gt": after.Time}},
+			bson.M{"time": after.Time, "type": bson.M{"$gt": after.Type}},
+			bson.M{"time": after.Time, "type": after.Type, "name": bson.M{"$gt": after.Name}},
+		}
+	}
+	return filter
+}
+
+// mergeMonitorParts merges the monitors of the same (time, type, name) of the groups and sorts them by the key
+func mergeMonitorParts(parts []resources.Monitor) []resources.Monitor {
+	sort.SliceStable(parts, func(i, j int) bool {
+		return database.CursorOf(&parts[i]).Less(*database.CursorOf(&parts[j]))
+	})
+	merged := make([]resources.Monitor, 0, len(parts))
+	for _, part := range parts {
+		if n := len(merged); n > 0 && !database.CursorOf(&merged[n-1]).Less(*database.CursorOf(&part)) {
+			last := &merged[n-1]
+			last.Used = mergeEnumUsed(last.Used, part.Used)
+			last.Utilization = mergeEnumUsed(last.Utilization, part.Utilization)
+			continue
+		}
+		merged = append(merged, part)
+	}
+	return merged
+}
+
+func mergeEnumUsed(dst, src resources.EnumUsedMap) resources.EnumUsedMap {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = resources.EnumUsedMap{}
+	}
+	for enum, v := range src {
+		dst[enum] += v
+	}
+	return dst
+}
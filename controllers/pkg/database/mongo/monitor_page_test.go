@@ -0,0 +1,59 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMergeMonitorParts(t *testing.T) {
+	minute := time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC)
+	parts := []resources.Monitor{
+		// the gpu part of the routed group is read after the default group
+		{Time: minute.Add(time.Minute), Type: 0, Name: "app-a", Used: resources.EnumUsedMap{0: 100}},
+		{Time: minute, Type: 0, Name: "app-b", Used: resources.EnumUsedMap{0: 200}},
+		{Time: minute, Type: 0, Name: "app-a", Used: resources.EnumUsedMap{0: 300, 1: 400}},
+		{Time: minute, Type: 0, Name: "app-a", Used: resources.EnumUsedMap{4: 1000}, Utilization: resources.EnumUsedMap{4: 50}},
+		{Time: minute, Type: 1, Name: "app-a", Used: resources.EnumUsedMap{0: 500}},
+	}
+	got := mergeMonitorParts(parts)
+	type key struct {
+		time time.Time
+		typ  uint8
+		name string
+	}
+	wantKeys := []key{{minute, 0, "app-a"}, {minute, 0, "app-b"}, {minute, 1, "app-a"}, {minute.Add(time.Minute), 0, "app-a"}}
+	if len(got) != len(wantKeys) {
+		t.Fatalf("mergeMonitorParts() = %d monitors, want %d", len(got), len(wantKeys))
+	}
+	for i, want := range wantKeys {
+		if k := (key{got[i].Time, got[i].Type, got[i].Name}); k != want {
+			t.Errorf("monitor %d = %v, want %v", i, k, want)
+		}
+	}
+	if want := (resources.EnumUsedMap{0: 300, 1: 400, 4: 1000}); !reflect.DeepEqual(got[0].Used, want) {
+		t.Errorf("merged used = %v, want %v", got[0].Used, want)
+	}
+	if want := (resources.EnumUsedMap{4: 50}); !reflect.DeepEqual(got[0].Utilization, want) {
+		t.Errorf("merged utilization = %v, want %v", got[0].Utilization, want)
+	}
+	if got := mergeMonitorParts(nil); len(got) != 0 {
+		t.Errorf("mergeMonitorParts(nil) = %v, want empty", got)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	// monitorRollupSuffix the collection of the hourly rollups, eg: monitor_rollup, it has no day suffix so the retention keeps it
+	monitorRollupSuffix = "rollup"
+	// monitorRollupStateSuffix the collection of the rolled up hours
+	monitorRollupStateSuffix = "rollup_state"
+)
+
+func (m *mongoDB) getMonitorRollupCollection() *mongo.Collection {
+	return m.Client.Database(m.AccountDB).Collection(m.MonitorConnPrefix + "_" + monitorRollupSuffix)
+}
+
+func (m *mongoDB) getMonitorRollupStateCollection() *mongo.Collection {
+	return m.Client.Database(m.AccountDB).Collection(m.MonitorConnPrefix + "_" + monitorRollupStateSuffix)
+}
+
+// QueryMonitorsInRange streams the minute monitors of all namespaces in [startTime, endTime) from the daily collections of all groups
+func (m *mongoDB) QueryMonitorsInRange(ctx context.Context, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	startTime, endTime = startTime.UTC(), endTime.UTC()
+	filter := bson.M{
+		"time": bson.M{
+			"$gte": startTime,
+			"$lt":  endTime,
+		},
+	}
+	for day := startTime.Truncate(24 * time.Hour); day.Before(endTime); day = day.AddDate(0, 0, 1) {
+		for _, group := range m.monitorGroups() {
+			if err := m.queryMonitorCollection(ctx, m.getMonitorGroupCollection(group, day), filter, options.Find(), handle); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IsMonitorRollupDone returns true if the rollups of the hour were saved
+func (m *mongoDB) IsMonitorRollupDone(ctx context.Context, hour time.Time) (bool, error) {
+	count, err := m.getMonitorRollupStateCollection().CountDocuments(ctx, bson.M{"_id": hour.UTC()})
+	if err != nil {
+		return false, fmt.Errorf("failed to get the rollup state of %s: %w", hour.UTC().Format(time.RFC3339), err)
+	}
+	return count > 0, nil
+}
+
+// SaveMonitorRollups upserts the rollups of the hour by category, type, name and time, then marks the hour done.
+// If it fails before the mark, the next run recomputes the hour from the minute monitors and replaces the same rollups.
+func (m *mongoDB) SaveMonitorRollups(ctx context.Context, hour time.Time, rollups []*resources.Monitor) error {
+	hour = hour.UTC()
+	if len(rollups) > 0 {
+		coll := m.getMonitorRollupCollection()
+		if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "category", Value: 1}, {Key: "type", Value: 1}, {Key: "name", Value: 1}, {Key: "time", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}); err != nil {
+			return fmt.Errorf("failed to create index for monitor rollup: %w", err)
+		}
+		models := make([]mongo.WriteModel, len(rollups))
+		for i, rollup := range rollups {
+			models[i] = mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"category": rollup.Category, "type": rollup.Type, "name": rollup.Name, "time": hour}).
+				SetReplacement(rollup).
+				SetUpsert(true)
+		}
+		if _, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return classifyError(err)
+		}
+	}
+	_, err := m.getMonitorRollupStateCollection().UpdateOne(ctx, bson.M{"_id": hour},
+		bson.M{"$set": bson.M{"rollups": len(rollups), "updated_at": time.Now().UTC()}}, options.Update().SetUpsert(true))
+	return classifyError(err)
+}
+
+// DeleteMonitorsInRange deletes the minute monitors of all namespaces in [startTime, endTime) from the daily collections of all groups
+func (m *mongoDB) DeleteMonitorsInRange(ctx context.Context, startTime, endTime time.Time) (int64, error) {
+	startTime, endTime = startTime.UTC(), endTime.UTC()
+	filter := bson.M{
+		"time": bson.M{
+			"$gte": startTime,
+			"$lt":  endTime,
+		},
+	}
+	var deleted int64
+	for day := startTime.Truncate(24 * time.Hour); day.Before(endTime); day = day.AddDate(0, 0, 1) {
+		for _, group := range m.monitorGroups() {
+			result, err := m.getMonitorGroupCollection(group, day).DeleteMany(ctx, filter)
+			if err != nil {
+				return deleted, classifyError(err)
+			}
+			deleted += result.DeletedCount
+		}
+	}
+	return deleted, nil
+}
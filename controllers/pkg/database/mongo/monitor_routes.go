@@ -0,0 +1,124 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// defaultMonitorGroup the monitor collection of the resources without a route, eg: monitor_20200101
+const defaultMonitorGroup = ""
+
+var monitorGroupRegexp = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// parseMonitorRoutes parses the comma separated resource=group routes, eg: network=traffic,storage=storage
+func parseMonitorRoutes(s string) (map[string]string, error) {
+	routes := make(map[string]string)
+	for _, route := range strings.Split(s, ",") {
+		if route = strings.TrimSpace(route); route == "" {
+			continue
+		}
+		name, group, ok := strings.Cut(route, "=")
+		name, group = strings.TrimSpace(name), strings.TrimSpace(group)
+		if !ok || name == "" || !monitorGroupRegexp.MatchString(group) {
+			return nil, fmt.Errorf("invalid monitor collection route %q, want resource=group with the group matching %s", route, monitorGroupRegexp)
+		}
+		routes[name] = group
+	}
+	return routes, nil
+}
+
+// monitorGroups returns the default group and the routed groups, sorted
+func (m *mongoDB) monitorGroups() []string {
+	groups := []string{defaultMonitorGroup}
+	seen := map[string]bool{defaultMonitorGroup: true}
+	for _, group := range m.MonitorRoutes {
+		if !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func (m *mongoDB) getMonitorGroupCollection(group string, collTime time.Time) *mongo.Collection {
+	return m.Client.Database(m.AccountDB).Collection(m.getMonitorGroupCollectionName(group, collTime))
+}
+
+func (m *mongoDB) getMonitorGroupCollectionName(group string, collTime time.Time) string {
+	if group == defaultMonitorGroup {
+		return m.getMonitorCollectionName(collTime)
+	}
+	return fmt.Sprintf("%s_%s_%s", m.MonitorConnPrefix, group, collTime.Format("20060102"))
+}
+
+// monitorCollectionDate parses the day of the monitor collection name, eg: monitor_traffic_20200101 -> 2020-01-01
+func (m *mongoDB) monitorCollectionDate(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, m.MonitorConnPrefix+"_") {
+		return time.Time{}, false
+	}
+	date, err := time.Parse("20060102", name[strings.LastIndex(name, "_")+1:])
+	return date, err == nil
+}
+
+// splitMonitor splits the used resources of the monitor by the routed groups
+func splitMonitor(monitor *resources.Monitor, enumGroups map[uint8]string) map[string]*resources.Monitor {
+	if len(enumGroups) == 0 {
+		return map[string]*resources.Monitor{defaultMonitorGroup: monitor}
+	}
+	split := make(map[string]*resources.Monitor)
+	get := func(group string) *resources.Monitor {
+		if split[group] == nil {
+			part := *monitor
+			part.Used, part.Utilization = resources.EnumUsedMap{}, nil
+			split[group] = &part
+		}
+		return split[group]
+	}
+	for enum, used := range monitor.Used {
+		get(enumGroups[enum]).Used[enum] = used
+	}
+	for enum, util := range monitor.Utilization {
+		part := get(enumGroups[enum])
+		if part.Utilization == nil {
+			part.Utilization = resources.EnumUsedMap{}
+		}
+		part.Utilization[enum] = util
+	}
+	return split
+}
+
+// monitorEnumGroups resolves the routed resource names to the enums of the property types
+func (m *mongoDB) monitorEnumGroups() map[uint8]string {
+	if len(m.MonitorRoutes) == 0 {
+		return nil
+	}
+	enumGroups := make(map[uint8]string, len(m.MonitorRoutes))
+	for name, group := range m.MonitorRoutes {
+		if pType, ok := resources.DefaultPropertyTypeLS.StringMap[name]; ok {
+			enumGroups[pType.Enum] = group
+		}
+	}
+	return enumGroups
+}
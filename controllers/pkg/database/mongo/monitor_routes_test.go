@@ -0,0 +1,156 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseMonitorRoutes(t *testing.T) {
+	routes, err := parseMonitorRoutes(" network=traffic, storage = storage ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"network": "traffic", "storage": "storage"}; !reflect.DeepEqual(routes, want) {
+		t.Errorf("parseMonitorRoutes() = %v, want %v", routes, want)
+	}
+	for _, invalid := range []string{"network", "=traffic", "network=", "network=Traffic_1"} {
+		if _, err := parseMonitorRoutes(invalid); err == nil {
+			t.Errorf("parseMonitorRoutes(%q) expected error", invalid)
+		}
+	}
+}
+
+func TestMonitorGroupCollections(t *testing.T) {
+	m := &mongoDB{MonitorConnPrefix: DefaultMonitorConn, MonitorRoutes: map[string]string{"network": "traffic", "storage": "traffic"}}
+	if groups := m.monitorGroups(); !reflect.DeepEqual(groups, []string{"", "traffic"}) {
+		t.Errorf("monitorGroups() = %v, want [ traffic]", groups)
+	}
+	day := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	if name := m.getMonitorGroupCollectionName("", day); name != "monitor_20240102" {
+		t.Errorf("default group collection = %s, want monitor_20240102", name)
+	}
+	if name := m.getMonitorGroupCollectionName("traffic", day); name != "monitor_traffic_20240102" {
+		t.Errorf("traffic group collection = %s, want monitor_traffic_20240102", name)
+	}
+	for name, want := range map[string]bool{"monitor_20240102": true, "monitor_traffic_20240102": true, "monitor_traffic": false, "metering": false} {
+		date, ok := m.monitorCollectionDate(name)
+		if ok != want {
+			t.Errorf("monitorCollectionDate(%s) ok = %v, want %v", name, ok, want)
+		}
+		if ok && !date.Equal(day.Truncate(24*time.Hour)) {
+			t.Errorf("monitorCollectionDate(%s) = %v, want %v", name, date, day.Truncate(24*time.Hour))
+		}
+	}
+}
+
+func TestSplitMonitor(t *testing.T) {
+	monitor := &resources.Monitor{
+		Category:    "ns-a",
+		Name:        "bucket-a",
+		Type:        resources.AppType[resources.ObjectStorage],
+		Used:        resources.EnumUsedMap{0: 100, 2: 10, 3: 1024},
+		Utilization: resources.EnumUsedMap{0: 50},
+	}
+	if split := splitMonitor(monitor, nil); len(split) != 1 || split[defaultMonitorGroup] != monitor {
+		t.Errorf("splitMonitor() without routes = %v, want the monitor in the default group", split)
+	}
+	split := splitMonitor(monitor, map[uint8]string{3: "traffic"})
+	if len(split) != 2 {
+		t.Fatalf("splitMonitor() = %v, want 2 groups", split)
+	}
+	if want := (resources.EnumUsedMap{0: 100, 2: 10}); !reflect.DeepEqual(split[defaultMonitorGroup].Used, want) {
+		t.Errorf("default group used = %v, want %v", split[defaultMonitorGroup].Used, want)
+	}
+	if want := (resources.EnumUsedMap{0: 50}); !reflect.DeepEqual(split[defaultMonitorGroup].Utilization, want) {
+		t.Errorf("default group utilization = %v, want %v", split[defaultMonitorGroup].Utilization, want)
+	}
+	traffic := split["traffic"]
+	if want := (resources.EnumUsedMap{3: 1024}); !reflect.DeepEqual(traffic.Used, want) || traffic.Utilization != nil {
+		t.Errorf("traffic group = %v, want used %v without utilization", traffic, want)
+	}
+	if traffic.Category != monitor.Category || traffic.Name != monitor.Name || traffic.Type != monitor.Type {
+		t.Errorf("traffic group monitor = %v, want the same app as %v", traffic, monitor)
+	}
+	if len(monitor.Used) != 3 {
+		t.Errorf("splitMonitor() modified the monitor: %v", monitor.Used)
+	}
+}
+
+func TestMongoDB_RoutedMonitors(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	t.Setenv("MONITOR_COLLECTION_ROUTES", resources.ResourceNetwork+"=traffic")
+	dbCTX := context.Background()
+	db, err := NewMongoInterface(dbCTX, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		t.Fatalf("failed to connect mongo: error = %v", err)
+	}
+	defer func() {
+		if err = db.Disconnect(dbCTX); err != nil {
+			t.Errorf("failed to disconnect mongo: error = %v", err)
+		}
+	}()
+	m := db.(*mongoDB)
+	m.AccountDB = "sealos-resources-test"
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	network := resources.DefaultPropertyTypeLS.StringMap[resources.ResourceNetwork].Enum
+	cpu := resources.DefaultPropertyTypeLS.StringMap["cpu"].Enum
+	namespace := "ns-routed-test"
+	defer func() {
+		if _, err := m.DeleteMonitorsByCategory(namespace); err != nil {
+			t.Errorf("failed to delete monitors: %v", err)
+		}
+	}()
+	if err := m.InsertMonitor(dbCTX,
+		&resources.Monitor{Time: now, Category: namespace, Type: 1, Name: "app-a", Used: resources.EnumUsedMap{cpu: 100, network: 2048}},
+		&resources.Monitor{Time: now, Category: namespace, Type: 1, Name: "app-b", Used: resources.EnumUsedMap{network: 1}},
+	); err != nil {
+		t.Fatalf("InsertMonitor() error = %v", err)
+	}
+
+	count, err := m.getMonitorGroupCollection("traffic", now).CountDocuments(dbCTX, map[string]string{"category": namespace})
+	if err != nil || count != 2 {
+		t.Errorf("traffic collection count = %d, %v, want 2", count, err)
+	}
+	combinations, err := m.GetDistinctMonitorCombinations(now, now.Add(time.Minute), namespace)
+	if err != nil || len(combinations) != 2 {
+		t.Errorf("GetDistinctMonitorCombinations() = %v, %v, want app-a and app-b once", combinations, err)
+	}
+	used := map[string]resources.EnumUsedMap{}
+	err = m.QueryMonitors(dbCTX, namespace, now, now.Add(time.Minute), func(monitor *resources.Monitor) error {
+		if used[monitor.Name] == nil {
+			used[monitor.Name] = resources.EnumUsedMap{}
+		}
+		for enum, v := range monitor.Used {
+			used[monitor.Name][enum] += v
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("QueryMonitors() error = %v", err)
+	}
+	if want := (resources.EnumUsedMap{cpu: 100, network: 2048}); !reflect.DeepEqual(used["app-a"], want) {
+		t.Errorf("QueryMonitors() app-a used = %v, want %v", used["app-a"], want)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/logger"
+)
+
+const (
+	// monitorSchemaSuffix the collection of the schema version per daily monitor collection, eg: monitor_schema
+	monitorSchemaSuffix = "schema"
+	// monitorSchemaLockSuffix the collection of the lock of the migrations
+	monitorSchemaLockSuffix = "schema_lock"
+	monitorSchemaLockID     = "monitor"
+)
+
+var _ database.MonitorSchemaStore = &mongoDB{}
+
+func (m *mongoDB) getMonitorSchemaCollection() *mongo.Collection {
+	return m.Client.Database(m.AccountDB).Collection(m.MonitorConnPrefix + "_" + monitorSchemaSuffix)
+}
+
+func (m *mongoDB) getMonitorSchemaLockCollection() *mongo.Collection {
+	return m.Client.Database(m.AccountDB).Collection(m.MonitorConnPrefix + "_" + monitorSchemaLockSuffix)
+}
+
+// MonitorSchemaMigrations the migrations of the daily monitor collections, the documents of the former shapes are
+// updated in place, which requires mongo 7.0 for the time series collections, see MonitorSchemaCollections
+func (m *mongoDB) MonitorSchemaMigrations() []database.MonitorSchemaMigration {
+	return []database.MonitorSchemaMigration{
+		{Version: 1, Description: "backfill the monitor_id of the monitors", Up: m.backfillMonitorIDs},
+		{Version: 2, Description: "backfill the objstorage detail of the bucket monitors", Up: m.backfillObjStorageDetails},
+	}
+}
+
+// MonitorSchemaCollections returns the daily monitor collections of all groups, the rollups and the aggregates are
+// rewritten by the aggregation and not versioned. The time series of mongo before 7.0 can't be updated in place, they
+// are left at their version until the server is upgraded or they are dropped by the retention.
+func (m *mongoDB) MonitorSchemaCollections(ctx context.Context) ([]string, error) {
+	collections, err := m.monitorCollections(ctx)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	var daily, deferred []string
+	for _, name := range collections {
+		if _, ok := m.monitorCollectionDate(name); !ok {
+			continue
+		}
+		typ, err := m.monitorCollectionType(ctx, name)
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		if !migratable(typ, m.ServerVersion) {
+			deferred = append(deferred, name)
+			continue
+		}
+		daily = append(daily, name)
+	}
+	if len(deferred) > 0 {
+		logger.Info("deferred the schema migrations of the time series not writable by the server", "version", m.ServerVersion, "collections", deferred)
+	}
+	return daily, nil
+}
+
+// migratable reports if the documents of the collection of the type can be updated in place by the migrations
+func migratable(collectionType, version string) bool {
+	return collectionType != collectionTypeTimeSeries || timeSeriesWritable(version)
+}
+
+func (m *mongoDB) MonitorSchemaVersion(ctx context.Context, collection string) (int, error) {
+	var doc struct {
+		Version int `bson:"version"`
+	}
+	err := m.getMonitorSchemaCollection().FindOne(ctx, bson.M{"_id": collection}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return doc.Version, classifyError(err)
+}
+
+func (m *mongoDB) SetMonitorSchemaVersion(ctx context.Context, collection string, version int) error {
+	_, err := m.getMonitorSchemaCollection().UpdateOne(ctx, bson.M{"_id": collection},
+		bson.M{"$set": bson.M{"version": version, "updated_at": time.Now().UTC()}}, options.Update().SetUpsert(true))
+	return classifyError(err)
+}
+
+// AcquireMonitorSchemaLock upserts the lock document if it expired or is held by the owner, the upsert of the lock
+// held by another owner fails on the duplicate _id
+func (m *mongoDB) AcquireMonitorSchemaLock(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	_, err := m.getMonitorSchemaLockCollection().UpdateOne(ctx,
+		bson.M{"_id": monitorSchemaLockID, "$or": bson.A{bson.M{"owner": owner}, bson.M{"expires_at": bson.M{"$lt": now}}}},
+		bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, classifyError(err)
+}
+
+func (m *mongoDB) ReleaseMonitorSchemaLock(ctx context.Context, owner string) error {
+	_, err := m.getMonitorSchemaLockCollection().DeleteOne(ctx, bson.M{"_id": monitorSchemaLockID, "owner": owner})
+	return classifyError(err)
+}
+
+// backfillMonitorIDs sets the monitor_id of the monitors inserted before the ids, the inserts look up the stored ids
+// to skip the monitors already inserted, so a retried insert duplicated the monitors without them
+func (m *mongoDB) backfillMonitorIDs(ctx context.Context, collection string) error {
+	coll := m.Client.Database(m.AccountDB).Collection(collection)
+	filter := bson.M{"monitor_id": bson.M{"$exists": false}}
+	for {
+		cur, err := coll.Find(ctx, filter, options.Find().SetLimit(database.PurgeBatchSize))
+		if err != nil {
+			return classifyError(err)
+		}
+		var docs []struct {
+			ID                primitive.ObjectID `bson:"_id"`
+			resources.Monitor `bson:",inline"`
+		}
+		if err = cur.All(ctx, &docs); err != nil {
+			return classifyError(err)
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		models := make([]mongo.WriteModel, len(docs))
+		for i := range docs {
+			models[i] = mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": docs[i].ID}).
+				SetUpdate(bson.M{"$set": bson.M{"monitor_id": resources.MonitorID(&docs[i].Monitor)}})
+		}
+		result, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return classifyError(err)
+		}
+		// the documents not updated would be found again by the next batch
+		if result.ModifiedCount == 0 {
+			return fmt.Errorf("no monitor_id of the %d monitors of %s was updated", len(docs), collection)
+		}
+		if len(docs) < database.PurgeBatchSize {
+			return nil
+		}
+	}
+}
+
+// backfillObjStorageDetails sets the objstorage detail of the bucket monitors inserted before the detail to the latest
+// detail of the bucket in the collection, the creation time and the region of a bucket don't change.
+// The buckets without any detail in the collection are left without it, the usage reports them without a detail.
+func (m *mongoDB) backfillObjStorageDetails(ctx context.Context, collection string) error {
+	coll := m.Client.Database(m.AccountDB).Collection(collection)
+	objStorageType := resources.AppType[resources.ObjectStorage]
+	cur, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"type": objStorageType, "objstorage": bson.M{"$ne": nil}}}},
+		{{Key: "$sort", Value: bson.M{"time": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"category": "$category", "name": "$name"},
+			"detail": bson.M{"$last": "$objstorage"},
+		}}},
+	})
+	if err != nil {
+		return classifyError(err)
+	}
+	var buckets []struct {
+		ID struct {
+			Category string `bson:"category"`
+			Name     string `bson:"name"`
+		} `bson:"_id"`
+		Detail *resources.ObjStorageDetail `bson:"detail"`
+	}
+	if err = cur.All(ctx, &buckets); err != nil {
+		return classifyError(err)
+	}
+	for _, bucket := range buckets {
+		_, err := coll.UpdateMany(ctx,
+			bson.M{"type": objStorageType, "category": bucket.ID.Category, "name": bucket.ID.Name, "objstorage": nil},
+			bson.M{"$set": bson.M{"objstorage": bucket.Detail}})
+		if err != nil {
+			return fmt.Errorf("failed to backfill the detail of bucket %s/%s: %w", bucket.ID.Category, bucket.ID.Name, classifyError(err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMongoDB_MigrateMonitorSchema(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	ctx := context.Background()
+	db, err := NewMongoInterface(ctx, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		t.Fatalf("failed to connect mongo: error = %v", err)
+	}
+	defer func() {
+		if err = db.Disconnect(ctx); err != nil {
+			t.Errorf("failed to disconnect mongo: error = %v", err)
+		}
+	}()
+	m := db.(*mongoDB)
+	m.AccountDB = "sealos-resources-schema-test"
+	cleanup := func() {
+		if err := m.Client.Database(m.AccountDB).Drop(ctx); err != nil {
+			t.Errorf("failed to drop the test database: %v", err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := m.CreateMonitorTimeSeriesIfNotExist(day); err != nil {
+		t.Fatal(err)
+	}
+	objStorageType := resources.AppType[resources.ObjectStorage]
+	detail := bson.M{"creation_time": day.Add(-time.Hour), "region": "cn-1"}
+	// the shapes of the former controllers: without monitor_id, then with it but without the bucket detail
+	fixtures := []interface{}{
+		bson.M{"time": day.Add(time.Minute), "category": "ns-a", "type": resources.AppType[resources.APP], "name": "app-a", "used": bson.M{"0": 100}},
+		bson.M{"time": day.Add(2 * time.Minute), "category": "ns-a", "type": objStorageType, "name": "bucket-a", "used": bson.M{"3": 10}},
+		bson.M{"time": day.Add(3 * time.Minute), "category": "ns-a", "type": objStorageType, "name": "bucket-a", "used": bson.M{"3": 10},
+			"monitor_id": "stored-id", "objstorage": detail},
+		bson.M{"time": day.Add(4 * time.Minute), "category": "ns-a", "type": objStorageType, "name": "bucket-b", "used": bson.M{"3": 10}},
+	}
+	coll := m.getMonitorCollection(day)
+	if _, err := coll.InsertMany(ctx, fixtures); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := database.MigrateMonitorSchema(ctx, m, "pod-a")
+	if err != nil {
+		t.Fatalf("MigrateMonitorSchema() error = %v", err)
+	}
+	latest := len(m.MonitorSchemaMigrations())
+	if version, err := m.MonitorSchemaVersion(ctx, coll.Name()); err != nil || version != latest || applied != latest {
+		t.Fatalf("version = %d, %v with %d applied, want %d", version, err, applied, latest)
+	}
+	var monitors []resources.Monitor
+	if err := m.QueryMonitors(ctx, "ns-a", day, day.AddDate(0, 0, 1), func(monitor *resources.Monitor) error {
+		monitors = append(monitors, *monitor)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(monitors) != len(fixtures) {
+		t.Fatalf("QueryMonitors() = %d monitors, want %d", len(monitors), len(fixtures))
+	}
+	for _, monitor := range monitors {
+		want := resources.MonitorID(&monitor)
+		if monitor.Name == "bucket-a" && monitor.Time.Equal(day.Add(3*time.Minute)) {
+			want = "stored-id"
+		}
+		if monitor.MonitorID != want {
+			t.Errorf("%s at %s monitor_id = %q, want %q", monitor.Name, monitor.Time, monitor.MonitorID, want)
+		}
+		switch monitor.Name {
+		case "bucket-a":
+			if monitor.ObjStorage == nil || monitor.ObjStorage.Region != "cn-1" {
+				t.Errorf("bucket-a at %s detail = %v, want backfilled", monitor.Time, monitor.ObjStorage)
+			}
+		default:
+			if monitor.ObjStorage != nil {
+				t.Errorf("%s detail = %v, want none without a detail of the bucket", monitor.Name, monitor.ObjStorage)
+			}
+		}
+	}
+
+	// a collection migrated by a newer controller is refused
+	if err := m.SetMonitorSchemaVersion(ctx, coll.Name(), latest+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.MigrateMonitorSchema(ctx, m, "pod-a"); !errors.Is(err, database.ErrMonitorSchemaTooNew) {
+		t.Errorf("MigrateMonitorSchema() error = %v, want %v", err, database.ErrMonitorSchemaTooNew)
+	}
+
+	// the lock of another owner is not taken until it expires
+	if acquired, err := m.AcquireMonitorSchemaLock(ctx, "pod-b", time.Minute); err != nil || !acquired {
+		t.Fatalf("AcquireMonitorSchemaLock(pod-b) = %v, %v, want acquired", acquired, err)
+	}
+	if acquired, err := m.AcquireMonitorSchemaLock(ctx, "pod-a", time.Minute); err != nil || acquired {
+		t.Errorf("AcquireMonitorSchemaLock(pod-a) = %v, %v, want held by pod-b", acquired, err)
+	}
+	if err := m.ReleaseMonitorSchemaLock(ctx, "pod-b"); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, err := m.AcquireMonitorSchemaLock(ctx, "pod-a", time.Minute); err != nil || !acquired {
+		t.Errorf("AcquireMonitorSchemaLock(pod-a) after the release = %v, %v, want acquired", acquired, err)
+	}
+}
+
+func TestMigratable(t *testing.T) {
+	tests := []struct {
+		collectionType string
+		version        string
+		want           bool
+	}{
+		{collectionType: "collection", version: "5.0.24", want: true},
+		{collectionType: collectionTypeTimeSeries, version: "5.0.24", want: false},
+		{collectionType: collectionTypeTimeSeries, version: "7.0.2", want: true},
+		{collectionType: collectionTypeTimeSeries, version: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := migratable(tt.collectionType, tt.version); got != tt.want {
+			t.Errorf("migratable(%q, %q) = %v, want %v", tt.collectionType, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestMongoDB_MigrateMonitorSchemaTimeSeries(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	ctx := context.Background()
+	db, err := NewMongoInterface(ctx, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		t.Fatalf("failed to connect mongo: error = %v", err)
+	}
+	defer func() {
+		if err = db.Disconnect(ctx); err != nil {
+			t.Errorf("failed to disconnect mongo: error = %v", err)
+		}
+	}()
+	m := db.(*mongoDB)
+	m.AccountDB = "sealos-resources-schema-ts-test"
+	cleanup := func() {
+		if err := m.Client.Database(m.AccountDB).Drop(ctx); err != nil {
+			t.Errorf("failed to drop the test database: %v", err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// the time series created by the former controllers, with a monitor inserted before the ids
+	timeSeries := m.getMonitorCollectionName(day)
+	if err := m.CreateTimeSeriesIfNotExist(m.AccountDB, timeSeries); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.getMonitorCollection(day).InsertOne(ctx, bson.M{"time": day.Add(time.Minute), "category": "ns-a",
+		"type": resources.AppType[resources.APP], "name": "app-a", "used": bson.M{"0": 100}}); err != nil {
+		t.Fatal(err)
+	}
+	regular := m.getMonitorCollectionName(day.AddDate(0, 0, 1))
+	if err := m.CreateMonitorTimeSeriesIfNotExist(day.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	version := func(collection string) int {
+		t.Helper()
+		v, err := m.MonitorSchemaVersion(ctx, collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	latest := len(m.MonitorSchemaMigrations())
+
+	// mongo 5.0 defers the time series and migrates the regular collections, the controller starts
+	server := m.ServerVersion
+	m.ServerVersion = "5.0.24"
+	applied, err := database.MigrateMonitorSchema(ctx, m, "pod-a")
+	if err != nil {
+		t.Fatalf("MigrateMonitorSchema() on mongo 5.0 error = %v", err)
+	}
+	if applied != latest || version(timeSeries) != 0 || version(regular) != latest {
+		t.Errorf("MigrateMonitorSchema() on mongo 5.0 applied %d, versions %d and %d, want the time series deferred",
+			applied, version(timeSeries), version(regular))
+	}
+
+	// the time series is migrated once the server writes them
+	if !timeSeriesWritable(server) {
+		return
+	}
+	m.ServerVersion = server
+	if applied, err = database.MigrateMonitorSchema(ctx, m, "pod-a"); err != nil || applied != latest || version(timeSeries) != latest {
+		t.Errorf("MigrateMonitorSchema() on mongo %s = %d, %v, version %d, want the time series migrated", server, applied, err, version(timeSeries))
+	}
+}
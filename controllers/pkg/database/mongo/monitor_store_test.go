@@ -0,0 +1,98 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+func TestMongoDB_MonitorStoreConformance(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	dbCTX := context.Background()
+	db, err := NewMongoInterface(dbCTX, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		t.Fatalf("failed to connect mongo: error = %v", err)
+	}
+	defer func() {
+		if err = db.Disconnect(dbCTX); err != nil {
+			t.Errorf("failed to disconnect mongo: error = %v", err)
+		}
+	}()
+	m := db.(*mongoDB)
+	m.AccountDB = "sealos-resources-test"
+	databasetest.RunMonitorStore(t, m)
+}
+
+func TestMongoDB_InsertMonitorDetailed(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	ctx := context.Background()
+	db, err := NewMongoInterface(ctx, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		t.Fatalf("failed to connect mongo: error = %v", err)
+	}
+	defer func() {
+		if err = db.Disconnect(ctx); err != nil {
+			t.Errorf("failed to disconnect mongo: error = %v", err)
+		}
+	}()
+	m := db.(*mongoDB)
+	m.AccountDB = "sealos-resources-test"
+	namespace := "ns-" + databasetest.FixtureUser + "-detailed"
+	cleanup := func() {
+		if _, err := m.DeleteMonitorsByCategory(namespace); err != nil {
+			t.Errorf("DeleteMonitorsByCategory() error = %v", err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	at := databasetest.FixtureTime.Add(10 * time.Hour)
+	monitor := func(name, property string) *resources.Monitor {
+		return &resources.Monitor{Time: at, Category: namespace, Type: resources.AppType[resources.APP], Name: name,
+			Used: resources.EnumUsedMap{0: 1}, Property: property}
+	}
+	// the poison monitor among the valid ones is larger than a document
+	monitors := []*resources.Monitor{monitor("app-a", ""), monitor("app-poison", strings.Repeat("x", maxMonitorDocumentSize)), monitor("app-b", "")}
+	results := database.InsertMonitorDetailed(ctx, m, monitors...)
+	if len(results) != 1 || !retry.IsPermanent(results[1]) {
+		t.Fatalf("InsertMonitorDetailed() = %v, want the poison monitor failed permanently", results)
+	}
+	if transient := results.Transient(monitors); len(transient) != 0 {
+		t.Errorf("Transient() = %d monitors, want none retried", len(transient))
+	}
+	count := 0
+	if err := m.QueryMonitors(ctx, namespace, databasetest.FixtureTime, databasetest.FixtureTime.AddDate(0, 0, 1), func(*resources.Monitor) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatalf("QueryMonitors() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("QueryMonitors() = %d monitors, want the 2 valid ones inserted", count)
+	}
+}
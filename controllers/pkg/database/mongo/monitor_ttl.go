@@ -0,0 +1,139 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+// monitorTTLIndexName the TTL index on the time of the daily monitor collections which are not time series, eg: the
+// collections created by the inserts before the time series
+const monitorTTLIndexName = "time_ttl"
+
+var _ database.MonitorTTLStore = &mongoDB{}
+
+// SetMonitorTTL sets the TTL of the daily monitor collections of all groups. A time series collection expires its
+// buckets by its expireAfterSeconds, the TTL of the time field maintained by the server, since a TTL index can't be
+// created on the time field of a time series. A regular collection expires the monitors by a TTL index on the time.
+// The collections created later don't have the TTL until it is set again.
+func (m *mongoDB) SetMonitorTTL(ctx context.Context, days int) (int, error) {
+	seconds := int64(days) * 24 * 60 * 60
+	specs, err := m.Client.Database(m.AccountDB).ListCollectionSpecifications(ctx,
+		bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(m.MonitorConnPrefix)}})
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	changed := 0
+	for _, spec := range specs {
+		if _, ok := m.monitorCollectionDate(spec.Name); !ok {
+			continue
+		}
+		var ok bool
+		switch spec.Type {
+		case "timeseries":
+			ok, err = m.setTimeSeriesTTL(ctx, spec, seconds)
+		case "collection":
+			ok, err = m.setTTLIndex(ctx, spec.Name, seconds)
+		default:
+			continue
+		}
+		if err != nil {
+			return changed, fmt.Errorf("failed to set the ttl of %s: %w", spec.Name, classifyError(err))
+		}
+		if ok {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+func (m *mongoDB) setTimeSeriesTTL(ctx context.Context, spec *mongo.CollectionSpecification, seconds int64) (bool, error) {
+	if timeSeriesTTL(spec.Options) == seconds {
+		return false, nil
+	}
+	return true, m.Client.Database(m.AccountDB).RunCommand(ctx, timeSeriesTTLCommand(spec.Name, seconds)).Err()
+}
+
+func (m *mongoDB) setTTLIndex(ctx context.Context, collection string, seconds int64) (bool, error) {
+	indexes := m.Client.Database(m.AccountDB).Collection(collection).Indexes()
+	specs, err := indexes.ListSpecifications(ctx)
+	if err != nil {
+		return false, err
+	}
+	var current *mongo.IndexSpecification
+	for _, spec := range specs {
+		if spec.Name == monitorTTLIndexName {
+			current = spec
+		}
+	}
+	switch {
+	case current == nil && seconds == 0:
+		return false, nil
+	case current == nil:
+		_, err = indexes.CreateOne(ctx, monitorTTLIndex(seconds))
+		return true, err
+	case seconds == 0:
+		// switching back to the job based retention drops the index, the job drops the collection later
+		_, err = indexes.DropOne(ctx, monitorTTLIndexName)
+		return true, err
+	case current.ExpireAfterSeconds != nil && int64(*current.ExpireAfterSeconds) == seconds:
+		return false, nil
+	default:
+		return true, m.Client.Database(m.AccountDB).RunCommand(ctx, ttlIndexCommand(collection, seconds)).Err()
+	}
+}
+
+// timeSeriesTTL returns the expireAfterSeconds of the options of a time series collection, 0 if it doesn't expire
+func timeSeriesTTL(opts bson.Raw) int64 {
+	value, err := opts.LookupErr("expireAfterSeconds")
+	if err != nil {
+		return 0
+	}
+	seconds, _ := value.AsInt64OK()
+	return seconds
+}
+
+// timeSeriesTTLCommand returns the collMod of the expireAfterSeconds of a time series collection, 0 turns it off
+func timeSeriesTTLCommand(collection string, seconds int64) bson.D {
+	var expire interface{} = "off"
+	if seconds > 0 {
+		expire = seconds
+	}
+	return bson.D{{Key: "collMod", Value: collection}, {Key: "expireAfterSeconds", Value: expire}}
+}
+
+// monitorTTLIndex returns the TTL index on the time of a regular monitor collection
+func monitorTTLIndex(seconds int64) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "time", Value: 1}},
+		Options: options.Index().SetName(monitorTTLIndexName).SetExpireAfterSeconds(int32(seconds)),
+	}
+}
+
+// ttlIndexCommand returns the collMod of the expireAfterSeconds of the TTL index, the index is changed in place
+func ttlIndexCommand(collection string, seconds int64) bson.D {
+	return bson.D{
+		{Key: "collMod", Value: collection},
+		{Key: "index", Value: bson.D{{Key: "name", Value: monitorTTLIndexName}, {Key: "expireAfterSeconds", Value: seconds}}},
+	}
+}
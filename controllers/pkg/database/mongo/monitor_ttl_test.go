@@ -0,0 +1,147 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMonitorTTLCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		got  bson.D
+		want bson.D
+	}{
+		{name: "time series ttl", got: timeSeriesTTLCommand("monitor_20240101", 2592000),
+			want: bson.D{{Key: "collMod", Value: "monitor_20240101"}, {Key: "expireAfterSeconds", Value: int64(2592000)}}},
+		{name: "time series ttl off", got: timeSeriesTTLCommand("monitor_20240101", 0),
+			want: bson.D{{Key: "collMod", Value: "monitor_20240101"}, {Key: "expireAfterSeconds", Value: "off"}}},
+		{name: "ttl index", got: ttlIndexCommand("monitor_20240101", 604800),
+			want: bson.D{{Key: "collMod", Value: "monitor_20240101"}, {Key: "index", Value: bson.D{
+				{Key: "name", Value: monitorTTLIndexName}, {Key: "expireAfterSeconds", Value: int64(604800)}}}}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s command = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	index := monitorTTLIndex(2592000)
+	if !reflect.DeepEqual(index.Keys, bson.D{{Key: "time", Value: 1}}) {
+		t.Errorf("ttl index keys = %v, want the time", index.Keys)
+	}
+	opts := index.Options
+	if opts.Name == nil || *opts.Name != monitorTTLIndexName || opts.ExpireAfterSeconds == nil || *opts.ExpireAfterSeconds != 2592000 {
+		t.Errorf("ttl index options = %+v, want %s expiring after 2592000 seconds", opts, monitorTTLIndexName)
+	}
+
+	raw, err := bson.Marshal(bson.M{"timeseries": bson.M{"timeField": "time"}, "expireAfterSeconds": int64(604800)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := timeSeriesTTL(raw); got != 604800 {
+		t.Errorf("timeSeriesTTL() = %d, want 604800", got)
+	}
+	if got := timeSeriesTTL(nil); got != 0 {
+		t.Errorf("timeSeriesTTL() without the options = %d, want 0", got)
+	}
+}
+
+func TestMongoDB_SetMonitorTTL(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	ctx := context.Background()
+	db, err := NewMongoInterface(ctx, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		t.Fatalf("failed to connect mongo: error = %v", err)
+	}
+	defer func() {
+		if err = db.Disconnect(ctx); err != nil {
+			t.Errorf("failed to disconnect mongo: error = %v", err)
+		}
+	}()
+	m := db.(*mongoDB)
+	m.AccountDB = "sealos-resources-ttl-test"
+	cleanup := func() {
+		if err := m.Client.Database(m.AccountDB).Drop(ctx); err != nil {
+			t.Errorf("failed to drop the test database: %v", err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// the time series created by the former controllers
+	if err := m.CreateTimeSeriesIfNotExist(m.AccountDB, m.getMonitorCollectionName(day)); err != nil {
+		t.Fatal(err)
+	}
+	// the collection created by the insert is a regular collection
+	regular := m.getMonitorCollection(day.AddDate(0, 0, 1))
+	if _, err := regular.InsertOne(ctx, bson.M{"time": day.AddDate(0, 0, 1), "category": "ns-a"}); err != nil {
+		t.Fatal(err)
+	}
+	timeSeries := m.getMonitorCollection(day).Name()
+
+	ttl := func() (int64, int64) {
+		t.Helper()
+		specs, err := m.Client.Database(m.AccountDB).ListCollectionSpecifications(ctx, bson.M{"name": timeSeries})
+		if err != nil || len(specs) != 1 {
+			t.Fatalf("failed to get the spec of %s: %v", timeSeries, err)
+		}
+		indexes, err := regular.Indexes().ListSpecifications(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var indexTTL int64
+		for _, index := range indexes {
+			if index.Name == monitorTTLIndexName && index.ExpireAfterSeconds != nil {
+				indexTTL = int64(*index.ExpireAfterSeconds)
+			}
+		}
+		return timeSeriesTTL(specs[0].Options), indexTTL
+	}
+
+	steps := []struct {
+		name        string
+		days        int
+		wantChanged int
+		wantSeconds int64
+	}{
+		{name: "ttl mode", days: 30, wantChanged: 2, wantSeconds: 30 * 86400},
+		{name: "ttl mode again", days: 30, wantChanged: 0, wantSeconds: 30 * 86400},
+		{name: "retention changed", days: 7, wantChanged: 2, wantSeconds: 7 * 86400},
+		{name: "back to the job", days: 0, wantChanged: 2, wantSeconds: 0},
+		{name: "job mode again", days: 0, wantChanged: 0, wantSeconds: 0},
+	}
+	for _, step := range steps {
+		changed, err := m.SetMonitorTTL(ctx, step.days)
+		if err != nil {
+			t.Fatalf("%s: SetMonitorTTL(%d) error = %v", step.name, step.days, err)
+		}
+		if changed != step.wantChanged {
+			t.Errorf("%s: SetMonitorTTL(%d) changed %d collections, want %d", step.name, step.days, changed, step.wantChanged)
+		}
+		if tsTTL, indexTTL := ttl(); tsTTL != step.wantSeconds || indexTTL != step.wantSeconds {
+			t.Errorf("%s: time series ttl = %d, index ttl = %d, want %d", step.name, tsTTL, indexTTL, step.wantSeconds)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+// GetNamespaceUsage returns the used resources of all monitors of the namespace in [startTime, endTime)
+func (m *mongoDB) GetNamespaceUsage(category string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	return m.sumUsage(database.UsageQuery{Category: category, Start: startTime, End: endTime})
+}
+
+// GetUsageByApp returns the used resources of the app of the namespace in [startTime, endTime), appType is a key of resources.AppType
+func (m *mongoDB) GetUsageByApp(category, appType, appName string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	q, err := database.NewAppUsageQuery(category, appType, appName, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	return m.sumUsage(q)
+}
+
+func (m *mongoDB) sumUsage(q database.UsageQuery) (map[uint8]int64, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	earliest, err := m.earliestHourlyAggregate(ctx, q.Category)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := m.LatestMonitorAggregate(ctx, database.MonitorHourly)
+	if err != nil {
+		return nil, err
+	}
+	return database.SumUsage(ctx, q, earliest, latest, m.sumUsageSpan)
+}
+
+// earliestHourlyAggregate returns the start of the first hourly aggregate of the namespace, zero if none,
+// the hours before it were not aggregated yet or the namespace had no monitors
+func (m *mongoDB) earliestHourlyAggregate(ctx context.Context, category string) (time.Time, error) {
+	var first struct {
+		Time time.Time `bson:"time"`
+	}
+	err := m.getMonitorAggregateCollection(database.MonitorHourly).FindOne(ctx, bson.M{"category": category},
+		options.FindOne().SetSort(bson.D{{Key: "time", Value: 1}}).SetProjection(bson.M{"time": 1})).Decode(&first)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get the earliest hourly aggregate of %s: %w", category, err)
+	}
+	return first.Time.UTC(), nil
+}
+
+// sumUsageSpan sums the used of the span, the minute monitors are read from the daily collections of all groups
+func (m *mongoDB) sumUsageSpan(ctx context.Context, q database.UsageQuery, span database.UsageSpan) (map[uint8]int64, error) {
+	match := bson.M{
+		"category": q.Category,
+		"time": bson.M{
+			"$gte": span.Start,
+			"$lt":  span.End,
+		},
+	}
+	if q.Type != nil {
+		match["type"] = *q.Type
+		match["name"] = q.Name
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$project", Value: bson.M{"used": bson.M{"$objectToArray": "$used"}}}},
+		{{Key: "$unwind", Value: "$used"}},
+		{{Key: "$group", Value: bson.M{"_id": "$used.k", "value": bson.M{"$sum": "$used.v"}}}},
+	}
+	var colls []*mongo.Collection
+	switch span.Source {
+	case database.UsageMinute:
+		for day := span.Start.Truncate(24 * time.Hour); day.Before(span.End); day = day.AddDate(0, 0, 1) {
+			for _, group := range m.monitorGroups() {
+				colls = append(colls, m.getMonitorGroupReadCollection(group, day))
+			}
+		}
+	case database.UsageRollup:
+		colls = append(colls, m.getMonitorRollupCollection())
+	case database.UsageHourly:
+		colls = append(colls, m.getMonitorAggregateCollection(database.MonitorHourly))
+	default:
+		return nil, fmt.Errorf("unknown usage source %s", span.Source)
+	}
+	used := make(map[uint8]int64)
+	for _, coll := range colls {
+		err := m.aggregateMonitorCollection(coll, pipeline, func(cursor *mongo.Cursor) error {
+			var row struct {
+				Enum  string `bson:"_id"`
+				Value int64  `bson:"value"`
+			}
+			if err := cursor.Decode(&row); err != nil {
+				return fmt.Errorf("decode error: %v", err)
+			}
+			enum, err := strconv.ParseUint(row.Enum, 10, 8)
+			if err != nil {
+				return fmt.Errorf("invalid used enum %q: %v", row.Enum, err)
+			}
+			used[uint8(enum)] += row.Value
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return used, nil
+}
@@ -0,0 +1,120 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// objStorageUsageRow the sum of a resource of a bucket in a monitor collection
+type objStorageUsageRow struct {
+	ID struct {
+		Name string `bson:"name"`
+		Enum string `bson:"enum"`
+	} `bson:"_id"`
+	Value  int64                       `bson:"value"`
+	First  time.Time                   `bson:"first"`
+	Last   time.Time                   `bson:"last"`
+	Detail *resources.ObjStorageDetail `bson:"detail"`
+}
+
+// GetObjectStorageUsage returns the per bucket usage of the user in [startTime, endTime) sorted by the bucket name.
+// The buckets deleted in the period are included with the monitors before the deletion.
+func (m *mongoDB) GetObjectStorageUsage(user string, startTime, endTime time.Time) ([]resources.ObjStorageBucketUsage, error) {
+	startTime, endTime = startTime.UTC(), endTime.UTC()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"category": "ns-" + user,
+			"type":     resources.AppType[resources.ObjectStorage],
+			"time": bson.M{
+				"$gte": startTime,
+				"$lt":  endTime,
+			},
+		}}},
+		{{Key: "$sort", Value: bson.M{"time": 1}}},
+		{{Key: "$project", Value: bson.M{
+			"name":       1,
+			"time":       1,
+			"objstorage": 1,
+			"used":       bson.M{"$objectToArray": "$used"},
+		}}},
+		{{Key: "$unwind", Value: "$used"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"name": "$name", "enum": "$used.k"},
+			"value":  bson.M{"$sum": "$used.v"},
+			"first":  bson.M{"$min": "$time"},
+			"last":   bson.M{"$max": "$time"},
+			"detail": bson.M{"$last": "$objstorage"},
+		}}},
+	}
+	usages := make(map[string]*resources.ObjStorageBucketUsage)
+	for day := startTime.Truncate(24 * time.Hour); day.Before(endTime); day = day.AddDate(0, 0, 1) {
+		for _, group := range m.monitorGroups() {
+			err := m.aggregateMonitorCollection(m.getMonitorGroupReadCollection(group, day), pipeline, func(cursor *mongo.Cursor) error {
+				var row objStorageUsageRow
+				if err := cursor.Decode(&row); err != nil {
+					return fmt.Errorf("decode error: %v", err)
+				}
+				return mergeObjStorageUsage(usages, row)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	result := make([]resources.ObjStorageBucketUsage, 0, len(usages))
+	for _, usage := range usages {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Bucket < result[j].Bucket
+	})
+	return result, nil
+}
+
+// mergeObjStorageUsage merges the row of a daily collection into the bucket usage, the detail of the latest row is kept
+func mergeObjStorageUsage(usages map[string]*resources.ObjStorageBucketUsage, row objStorageUsageRow) error {
+	enum, err := strconv.ParseUint(row.ID.Enum, 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid used enum %q of bucket %s: %v", row.ID.Enum, row.ID.Name, err)
+	}
+	usage, ok := usages[row.ID.Name]
+	if !ok {
+		usage = &resources.ObjStorageBucketUsage{Bucket: row.ID.Name, Used: resources.EnumUsedMap{}, FirstSeen: row.First, LastSeen: row.Last}
+		usages[row.ID.Name] = usage
+	}
+	usage.Used[uint8(enum)] += row.Value
+	if row.First.Before(usage.FirstSeen) {
+		usage.FirstSeen = row.First
+	}
+	if !row.Last.Before(usage.LastSeen) {
+		usage.LastSeen = row.Last
+		if row.Detail != nil {
+			usage.Detail = row.Detail
+		}
+	}
+	if usage.Detail == nil {
+		usage.Detail = row.Detail
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMergeObjStorageUsage(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	row := func(name, enum string, value int64, first, last time.Time, region string) objStorageUsageRow {
+		r := objStorageUsageRow{Value: value, First: first, Last: last}
+		r.ID.Name, r.ID.Enum = name, enum
+		if region != "" {
+			r.Detail = &resources.ObjStorageDetail{CreationTime: day, Region: region}
+		}
+		return r
+	}
+	usages := map[string]*resources.ObjStorageBucketUsage{}
+	rows := []objStorageUsageRow{
+		// the second day is merged before the first one, eg: the routed group collection
+		row("user-a-b1", "2", 200, day.AddDate(0, 0, 1), day.AddDate(0, 0, 1).Add(time.Hour), "us-east-1"),
+		row("user-a-b1", "2", 100, day, day.Add(time.Hour), "old-region"),
+		row("user-a-b1", "3", 50, day, day.Add(2*time.Hour), ""),
+	}
+	for _, r := range rows {
+		if err := mergeObjStorageUsage(usages, r); err != nil {
+			t.Fatalf("mergeObjStorageUsage() error = %v", err)
+		}
+	}
+	got := usages["user-a-b1"]
+	if want := (resources.EnumUsedMap{2: 300, 3: 50}); !reflect.DeepEqual(got.Used, want) {
+		t.Errorf("used = %v, want %v", got.Used, want)
+	}
+	if !got.FirstSeen.Equal(day) || !got.LastSeen.Equal(day.AddDate(0, 0, 1).Add(time.Hour)) {
+		t.Errorf("seen = %v - %v, want the whole period", got.FirstSeen, got.LastSeen)
+	}
+	if got.Detail == nil || got.Detail.Region != "us-east-1" {
+		t.Errorf("detail = %+v, want the latest region us-east-1", got.Detail)
+	}
+	if err := mergeObjStorageUsage(usages, row("user-a-b1", "x", 1, day, day, "")); err == nil {
+		t.Error("mergeObjStorageUsage() expected error for the invalid enum")
+	}
+}
+
+func TestMongoDB_GetObjectStorageUsage(t *testing.T) {
+	if os.Getenv("MONGODB_URI") == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	dbCTX := context.Background()
+	db, err := NewMongoInterface(dbCTX, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		t.Fatalf("failed to connect mongo: error = %v", err)
+	}
+	defer func() {
+		if err = db.Disconnect(dbCTX); err != nil {
+			t.Errorf("failed to disconnect mongo: error = %v", err)
+		}
+	}()
+	m := db.(*mongoDB)
+	m.AccountDB = "sealos-resources-test"
+
+	user := "objusage"
+	namespace := "ns-" + user
+	defer func() {
+		if _, err := m.DeleteMonitorsByCategory(namespace); err != nil {
+			t.Errorf("failed to delete monitors: %v", err)
+		}
+	}()
+	storage := resources.DefaultPropertyTypeLS.StringMap["storage"].Enum
+	network := resources.DefaultPropertyTypeLS.StringMap[resources.ResourceNetwork].Enum
+	objType := resources.AppType[resources.ObjectStorage]
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	created := day.AddDate(0, -1, 0)
+	detail := &resources.ObjStorageDetail{CreationTime: created, Region: "us-east-1"}
+	monitor := func(at time.Time, bucket string, used resources.EnumUsedMap) *resources.Monitor {
+		return &resources.Monitor{Time: at, Category: namespace, Type: objType, Name: bucket, Used: used, ObjStorage: detail}
+	}
+	if err := m.InsertMonitor(dbCTX,
+		monitor(day.Add(time.Minute), user+"-kept", resources.EnumUsedMap{storage: 10, network: 1}),
+		monitor(day.Add(2*time.Minute), user+"-kept", resources.EnumUsedMap{storage: 10}),
+		// the bucket is deleted on the first day
+		monitor(day.Add(time.Minute), user+"-deleted", resources.EnumUsedMap{storage: 5}),
+		monitor(day.AddDate(0, 0, 1).Add(time.Minute), user+"-kept", resources.EnumUsedMap{storage: 20, network: 2}),
+		// the other apps of the namespace are not object storage
+		&resources.Monitor{Time: day.Add(time.Minute), Category: namespace, Type: resources.AppType[resources.APP], Name: user + "-kept", Used: resources.EnumUsedMap{storage: 1000}},
+	); err != nil {
+		t.Fatalf("InsertMonitor() error = %v", err)
+	}
+
+	usage, err := m.GetObjectStorageUsage(user, day, day.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatalf("GetObjectStorageUsage() error = %v", err)
+	}
+	if len(usage) != 2 || usage[0].Bucket != user+"-deleted" || usage[1].Bucket != user+"-kept" {
+		t.Fatalf("GetObjectStorageUsage() = %+v, want the deleted and kept buckets", usage)
+	}
+	if want := (resources.EnumUsedMap{storage: 5}); !reflect.DeepEqual(usage[0].Used, want) || !usage[0].LastSeen.Equal(day.Add(time.Minute)) {
+		t.Errorf("deleted bucket = %+v, want used %v until the deletion", usage[0], want)
+	}
+	if want := (resources.EnumUsedMap{storage: 40, network: 3}); !reflect.DeepEqual(usage[1].Used, want) {
+		t.Errorf("kept bucket used = %v, want %v", usage[1].Used, want)
+	}
+	if usage[1].Detail == nil || !usage[1].Detail.CreationTime.Equal(created) || usage[1].Detail.Region != "us-east-1" {
+		t.Errorf("kept bucket detail = %+v, want %+v", usage[1].Detail, detail)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+// minReadMaxStaleness the smallest max staleness the servers accept, see the server selection spec
+const minReadMaxStaleness = 90 * time.Second
+
+// parseReadPreference returns nil if the mode is empty, the reads use the client default then. The max staleness
+// excludes the secondaries lagging behind the primary by more than it, it requires a mode other than primary.
+func parseReadPreference(mode, maxStaleness string) (*readpref.ReadPref, time.Duration, error) {
+	var staleness time.Duration
+	if maxStaleness != "" {
+		var err error
+		if staleness, err = time.ParseDuration(maxStaleness); err != nil || staleness < minReadMaxStaleness {
+			return nil, 0, fmt.Errorf("invalid %s %q, must be a duration of at least %s", database.MongoReadMaxStaleness, maxStaleness, minReadMaxStaleness)
+		}
+	}
+	if mode == "" {
+		if staleness > 0 {
+			return nil, 0, fmt.Errorf("%s requires %s", database.MongoReadMaxStaleness, database.MongoReadPreference)
+		}
+		return nil, 0, nil
+	}
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid mongo read preference %q: %w", mode, err)
+	}
+	if staleness == 0 {
+		rp, err := readpref.New(m)
+		return rp, 0, err
+	}
+	if m == readpref.PrimaryMode {
+		return nil, 0, fmt.Errorf("%s doesn't apply to the read preference %s", database.MongoReadMaxStaleness, mode)
+	}
+	rp, err := readpref.New(m, readpref.WithMaxStaleness(staleness))
+	return rp, staleness, err
+}
+
+// connectReadClient connects the separate connection of the monitor reads, nil if the uri is empty
+func connectReadClient(ctx context.Context, uri string, clientOpts clientOptions) (*mongo.Client, error) {
+	if uri == "" {
+		return nil, nil
+	}
+	opts, err := clientOpts.apply(options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect mongo read uri: %w", err)
+	}
+	return client, nil
+}
+
+// getMonitorGroupReadCollection the collection of the monitor reads, eg: the distinct combinations and the exports.
+// The reads use the read client and the read preference if set, so they don't compete with the inserts on the primary.
+func (m *mongoDB) getMonitorGroupReadCollection(group string, collTime time.Time) *mongo.Collection {
+	client := m.Client
+	if m.ReadClient != nil {
+		client = m.ReadClient
+	}
+	opts := options.Collection()
+	if m.ReadPreference != nil {
+		opts.SetReadPreference(m.ReadPreference)
+	}
+	return client.Database(m.AccountDB).Collection(m.getMonitorGroupCollectionName(group, collTime), opts)
+}
+
+// getMonitorGroupWindowReadCollection the read collection of the monitors of the window ending at the end. A window
+// within the max staleness of the reads is read from the primary, since a secondary lagging by up to the max staleness
+// may miss its latest monitors, eg: the combinations of the traffic join of the last minute. Without the max staleness
+// the reads of a lagging secondary are not bounded and all the windows use the read collection.
+func (m *mongoDB) getMonitorGroupWindowReadCollection(group string, collTime, end time.Time) *mongo.Collection {
+	if m.ReadMaxStaleness > 0 && end.After(time.Now().Add(-m.ReadMaxStaleness)) {
+		return m.getMonitorGroupCollection(group, collTime)
+	}
+	return m.getMonitorGroupReadCollection(group, collTime)
+}
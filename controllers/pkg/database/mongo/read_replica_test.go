@@ -0,0 +1,105 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestParseReadPreference(t *testing.T) {
+	if rp, _, err := parseReadPreference("", ""); err != nil || rp != nil {
+		t.Errorf("parseReadPreference(\"\") = %v, %v, want nil", rp, err)
+	}
+	rp, staleness, err := parseReadPreference("secondaryPreferred", "")
+	if err != nil || rp.Mode() != readpref.SecondaryPreferredMode || staleness != 0 {
+		t.Errorf("parseReadPreference(\"secondaryPreferred\") = %v, %s, %v, want secondaryPreferred", rp, staleness, err)
+	}
+	if _, _, err := parseReadPreference("replica", ""); err == nil {
+		t.Error("parseReadPreference(\"replica\") expected error")
+	}
+	rp, staleness, err = parseReadPreference("secondaryPreferred", "2m")
+	if err != nil || staleness != 2*time.Minute {
+		t.Fatalf("parseReadPreference() with the max staleness = %s, %v, want 2m", staleness, err)
+	}
+	if got, ok := rp.MaxStaleness(); !ok || got != 2*time.Minute {
+		t.Errorf("read preference max staleness = %s, %v, want 2m", got, ok)
+	}
+	for _, tt := range []struct{ mode, staleness string }{
+		{mode: "secondaryPreferred", staleness: "30s"},
+		{mode: "secondaryPreferred", staleness: "later"},
+		{mode: "primary", staleness: "2m"},
+		{mode: "", staleness: "2m"},
+	} {
+		if _, _, err := parseReadPreference(tt.mode, tt.staleness); err == nil {
+			t.Errorf("parseReadPreference(%q, %q) expected error", tt.mode, tt.staleness)
+		}
+	}
+}
+
+func TestMongoDB_getMonitorGroupReadCollection(t *testing.T) {
+	ctx := context.Background()
+	// the clients are not used, connect does not dial the server
+	primary, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = primary.Disconnect(ctx) }()
+	m := &mongoDB{Client: primary, AccountDB: DefaultAccountDBName, MonitorConnPrefix: DefaultMonitorConn}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if coll := m.getMonitorGroupReadCollection("", day); coll.Database().Client() != primary || coll.Name() != "monitor_20240101" {
+		t.Errorf("read collection without read client = %s on %p, want monitor_20240101 on the primary client", coll.Name(), coll.Database().Client())
+	}
+	secondary, err := connectReadClient(ctx, "mongodb://127.0.0.1:2", clientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = secondary.Disconnect(ctx) }()
+	m.ReadClient = secondary
+	if coll := m.getMonitorGroupReadCollection("traffic", day); coll.Database().Client() != secondary || coll.Name() != "monitor_traffic_20240101" {
+		t.Errorf("read collection = %s, want monitor_traffic_20240101 on the read client", coll.Name())
+	}
+
+	// the combinations of a window within the max staleness are read from the primary, the older ones from the read client
+	m.ReadMaxStaleness = 2 * time.Minute
+	now := time.Now()
+	tests := []struct {
+		name string
+		end  time.Time
+		want *mongo.Client
+	}{
+		{name: "the last minute", end: now, want: primary},
+		{name: "within the max staleness", end: now.Add(-time.Minute), want: primary},
+		{name: "older than the max staleness", end: now.Add(-time.Hour), want: secondary},
+	}
+	for _, tt := range tests {
+		if got := m.getMonitorGroupWindowReadCollection("", tt.end.Add(-time.Minute), tt.end).Database().Client(); got != tt.want {
+			t.Errorf("%s: window read collection on %p, want %p", tt.name, got, tt.want)
+		}
+	}
+	m.ReadMaxStaleness = 0
+	if got := m.getMonitorGroupWindowReadCollection("", now.Add(-time.Minute), now).Database().Client(); got != secondary {
+		t.Errorf("window read collection without the max staleness on %p, want the read client %p", got, secondary)
+	}
+
+	if client, err := connectReadClient(ctx, "", clientOptions{}); err != nil || client != nil {
+		t.Errorf("connectReadClient(\"\") = %v, %v, want nil", client, err)
+	}
+}
@@ -16,12 +16,18 @@ package mongo
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
 )
 
+var _ database.TrafficPurger = &mongoDB{}
+
 /* example:
 {
     _id: ObjectId("60eea26373c4cdcb6356827d"),
@@ -74,7 +80,7 @@ func (m *mongoDB) getPodTrafficBytes(sent bool, startTime, endTime time.Time, na
 	}
 	cur, err := m.getTrafficCollection().Aggregate(context.Background(), pipeline)
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 	defer cur.Close(context.Background())
 	total := int64(0)
@@ -83,11 +89,11 @@ func (m *mongoDB) getPodTrafficBytes(sent bool, startTime, endTime time.Time, na
 			Total int64 `bson:"total"`
 		}
 		if err := cur.Decode(&result); err != nil {
-			return 0, err
+			return 0, retry.Permanent(err)
 		}
 		total += result.Total
 	}
-	return total, nil
+	return total, classifyError(cur.Err())
 }
 
 func (m *mongoDB) getTrafficBytes(sent bool, startTime, endTime time.Time, namespace string, _type uint8, name string) (int64, error) {
@@ -110,7 +116,7 @@ func (m *mongoDB) getTrafficBytes(sent bool, startTime, endTime time.Time, names
 	}
 	cur, err := m.getTrafficCollection().Aggregate(context.Background(), pipeline)
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 	defer cur.Close(context.Background())
 	total := int64(0)
@@ -119,11 +125,26 @@ func (m *mongoDB) getTrafficBytes(sent bool, startTime, endTime time.Time, names
 			Total int64 `bson:"total"`
 		}
 		if err := cur.Decode(&result); err != nil {
-			return 0, err
+			return 0, retry.Permanent(err)
 		}
 		total += result.Total
 	}
-	return total, nil
+	return total, classifyError(cur.Err())
+}
+
+// CountTrafficByNamespace counts the traffic records of the namespace
+func (m *mongoDB) CountTrafficByNamespace(namespace string) (int64, error) {
+	count, err := m.getTrafficCollection().CountDocuments(context.Background(), bson.M{"traffic_meta.pod_namespace": namespace})
+	return count, classifyError(err)
+}
+
+// DeleteTrafficByNamespace deletes the traffic records of the namespace by batches
+func (m *mongoDB) DeleteTrafficByNamespace(namespace string) (int64, error) {
+	deleted, err := deleteInBatches(context.Background(), m.getTrafficCollection(), bson.M{"traffic_meta.pod_namespace": namespace})
+	if err != nil {
+		return deleted, fmt.Errorf("failed to delete traffic of %s: %w", namespace, classifyError(err))
+	}
+	return deleted, nil
 }
 
 func (m *mongoDB) getTrafficCollection() *mongo.Collection {
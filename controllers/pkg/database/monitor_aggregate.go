@@ -0,0 +1,57 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// MonitorGranularity the period of the pre-aggregated monitors, also the suffix of the aggregate tables, eg: monitor_hourly
+type MonitorGranularity string
+
+const (
+	// MonitorHourly the hourly sums of the minute monitors
+	MonitorHourly MonitorGranularity = "hourly"
+	// MonitorDaily the daily sums of the hourly aggregates
+	MonitorDaily MonitorGranularity = "daily"
+)
+
+// MonitorGranularities the granularities from the finest
+var MonitorGranularities = []MonitorGranularity{MonitorHourly, MonitorDaily}
+
+// Period returns the length of a period of the granularity, 0 if unknown
+func (g MonitorGranularity) Period() time.Duration {
+	switch g {
+	case MonitorHourly:
+		return time.Hour
+	case MonitorDaily:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// Truncate returns the start of the UTC period containing t
+func (g MonitorGranularity) Truncate(t time.Time) time.Time {
+	return t.UTC().Truncate(g.Period())
+}
+
+// Validate returns an error if the granularity is unknown, the stores validate it before using it as a table suffix
+func (g MonitorGranularity) Validate() error {
+	if g.Period() == 0 {
+		return fmt.Errorf("unknown monitor granularity %q", g)
+	}
+	return nil
+}
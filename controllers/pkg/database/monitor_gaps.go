@@ -0,0 +1,64 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// MonitorGap the expected monitors of a namespace missing in [Start, End)
+type MonitorGap struct {
+	Start time.Time `json:"start" bson:"start"`
+	End   time.Time `json:"end" bson:"end"`
+}
+
+// NamespaceMonitorGaps the gaps of the monitors of a namespace in the window of a MonitorGapReport
+type NamespaceMonitorGaps struct {
+	Namespace      string       `json:"namespace" bson:"namespace"`
+	MissingMinutes int64        `json:"missingMinutes" bson:"missing_minutes"`
+	Gaps           []MonitorGap `json:"gaps" bson:"gaps"`
+}
+
+// MonitorGapReport the summary of a check of the monitors of the namespaces in [WindowStart, WindowEnd), kept for the
+// later reconciliation of the bills metered in the gaps
+type MonitorGapReport struct {
+	CheckedAt   time.Time `json:"checkedAt" bson:"checked_at"`
+	WindowStart time.Time `json:"windowStart" bson:"window_start"`
+	WindowEnd   time.Time `json:"windowEnd" bson:"window_end"`
+	// Namespaces the number of the namespaces checked, the empty ones included
+	Namespaces     int                    `json:"namespaces" bson:"namespaces"`
+	MissingMinutes int64                  `json:"missingMinutes" bson:"missing_minutes"`
+	Gaps           []NamespaceMonitorGaps `json:"gaps" bson:"gaps"`
+}
+
+// MonitorGapStore is implemented by the stores keeping the reports of the monitor gap detection (mongo)
+type MonitorGapStore interface {
+	SaveMonitorGapReport(ctx context.Context, report *MonitorGapReport) error
+}
+
+// ErrMonitorGapsUnsupported the store doesn't keep the monitor gap reports
+var ErrMonitorGapsUnsupported = errors.New("the monitor storage doesn't keep the monitor gap reports")
+
+// SaveMonitorGapReport saves the report by the store under a ReconnectingStore or an InstrumentedStore, see
+// MonitorGapStore. It returns ErrMonitorGapsUnsupported if the store doesn't implement MonitorGapStore.
+func SaveMonitorGapReport(ctx context.Context, store MonitorStore, report *MonitorGapReport) error {
+	gapStore, ok := unwrapMonitorStore(store).(MonitorGapStore)
+	if !ok {
+		return ErrMonitorGapsUnsupported
+	}
+	return gapStore.SaveMonitorGapReport(ctx, report)
+}
@@ -0,0 +1,108 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+// MonitorInsertResults the errors of the monitors not inserted by their index in the inserted monitors,
+// empty if all the monitors are inserted
+type MonitorInsertResults map[int]error
+
+// DetailedMonitorInserter is implemented by the stores reporting the failed monitors of an insert, so a poison monitor
+// doesn't fail the valid ones and only the failed monitors are retried. The stores inserting all or nothing don't implement it.
+type DetailedMonitorInserter interface {
+	// InsertMonitorDetailed inserts as many of the monitors as possible and returns the errors of the failed ones,
+	// the errors are classified by retry.IsTransient / retry.IsPermanent
+	InsertMonitorDetailed(ctx context.Context, monitors ...*resources.Monitor) MonitorInsertResults
+}
+
+// InsertMonitorDetailed inserts the monitors by the store reporting the failed monitors if it implements
+// DetailedMonitorInserter, otherwise by InsertMonitor, all the monitors then fail with its error
+func InsertMonitorDetailed(ctx context.Context, store MonitorStore, monitors ...*resources.Monitor) MonitorInsertResults {
+	if inserter, ok := store.(DetailedMonitorInserter); ok {
+		return inserter.InsertMonitorDetailed(ctx, monitors...)
+	}
+	return FailedMonitors(len(monitors), store.InsertMonitor(ctx, monitors...))
+}
+
+// FailedMonitors returns the results of n monitors all failed with the error, empty if the error is nil
+func FailedMonitors(n int, err error) MonitorInsertResults {
+	results := make(MonitorInsertResults)
+	if err == nil {
+		return results
+	}
+	for i := 0; i < n; i++ {
+		results[i] = err
+	}
+	return results
+}
+
+// Transient returns the failed monitors that may be inserted by a retry, in the order of the monitors
+func (r MonitorInsertResults) Transient(monitors []*resources.Monitor) []*resources.Monitor {
+	var transient []*resources.Monitor
+	for _, i := range r.indexes() {
+		if retry.IsTransient(r[i]) {
+			transient = append(transient, monitors[i])
+		}
+	}
+	return transient
+}
+
+// Err returns nil if all the monitors are inserted, otherwise a *MonitorInsertError
+func (r MonitorInsertResults) Err() error {
+	if len(r) == 0 {
+		return nil
+	}
+	return &MonitorInsertError{Results: r}
+}
+
+func (r MonitorInsertResults) indexes() []int {
+	indexes := make([]int, 0, len(r))
+	for i := range r {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// MonitorInsertError the monitors failed by an insert, it's transient if any of them may be inserted by a retry
+type MonitorInsertError struct {
+	Results MonitorInsertResults
+}
+
+func (e *MonitorInsertError) Error() string {
+	indexes := e.Results.indexes()
+	return fmt.Sprintf("failed to insert %d monitors, the first (index %d): %v", len(indexes), indexes[0], e.Results[indexes[0]])
+}
+
+// Unwrap returns the distinct errors of the failed monitors, so errors.Is matches the classification of any of them
+func (e *MonitorInsertError) Unwrap() []error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, i := range e.Results.indexes() {
+		if err := e.Results[i]; !seen[err.Error()] {
+			seen[err.Error()] = true
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,83 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+// allOrNothingStore a store without the detailed insert
+type allOrNothingStore struct {
+	MonitorStore
+	err error
+}
+
+func (s *allOrNothingStore) InsertMonitor(_ context.Context, _ ...*resources.Monitor) error {
+	return s.err
+}
+
+// detailedStore fails the monitors of the errors
+type detailedStore struct {
+	allOrNothingStore
+	errs map[string]error
+}
+
+func (s *detailedStore) InsertMonitorDetailed(_ context.Context, monitors ...*resources.Monitor) MonitorInsertResults {
+	results := make(MonitorInsertResults)
+	for i, monitor := range monitors {
+		if err := s.errs[monitor.Name]; err != nil {
+			results[i] = err
+		}
+	}
+	return results
+}
+
+func TestInsertMonitorDetailed(t *testing.T) {
+	ctx := context.Background()
+	monitors := []*resources.Monitor{{Name: "app-a"}, {Name: "app-poison"}, {Name: "app-b"}, {Name: "app-c"}}
+
+	if results := InsertMonitorDetailed(ctx, &allOrNothingStore{}, monitors...); len(results) != 0 || results.Err() != nil {
+		t.Errorf("InsertMonitorDetailed() = %v, want all inserted", results)
+	}
+	down := retry.Transient(errors.New("unavailable"))
+	results := InsertMonitorDetailed(ctx, &allOrNothingStore{err: down}, monitors...)
+	if len(results) != len(monitors) || len(results.Transient(monitors)) != len(monitors) {
+		t.Errorf("InsertMonitorDetailed() of the all or nothing store = %v, want all failed", results)
+	}
+
+	poison := retry.Permanent(errors.New("document too large"))
+	store := &detailedStore{errs: map[string]error{"app-poison": poison, "app-c": down}}
+	results = InsertMonitorDetailed(ctx, store, monitors...)
+	if len(results) != 2 || results[1] != poison || results[3] != down {
+		t.Fatalf("InsertMonitorDetailed() = %v, want the poison and app-c failed", results)
+	}
+	if transient := results.Transient(monitors); len(transient) != 1 || transient[0].Name != "app-c" {
+		t.Errorf("Transient() = %v, want only app-c retried", transient)
+	}
+	err := results.Err()
+	var insertErr *MonitorInsertError
+	if !errors.As(err, &insertErr) || !retry.IsTransient(err) || !retry.IsPermanent(err) {
+		t.Errorf("Err() = %v, want a MonitorInsertError matching both failures", err)
+	}
+	// the same error of all the monitors is unwrapped once
+	if errs := FailedMonitors(3, down).Err().(*MonitorInsertError).Unwrap(); len(errs) != 1 {
+		t.Errorf("Unwrap() = %v, want the error once", errs)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	DefaultMonitorPageLimit = 1000
+	MaxMonitorPageLimit     = 10000
+)
+
+// MonitorPageQuery the monitors of a namespace in [StartTime, EndTime), a page is sorted by (time, type, name)
+type MonitorPageQuery struct {
+	Namespace string
+	// Type the resource type of the monitors, eg: resources.AppType[resources.DB], all types if nil
+	Type      *uint8
+	StartTime time.Time
+	EndTime   time.Time
+	// After the Next cursor of the previous page, the first page if nil
+	After *MonitorCursor
+	// Limit the max monitors of a page, DefaultMonitorPageLimit if <= 0 and capped by MaxMonitorPageLimit
+	Limit int
+}
+
+// MonitorCursor the position of the last monitor of a page
+type MonitorCursor struct {
+	Time time.Time `json:"time"`
+	Type uint8     `json:"type"`
+	Name string    `json:"name"`
+}
+
+// MonitorPage a page of the monitors, Next is nil on the last page
+type MonitorPage struct {
+	Monitors []resources.Monitor `json:"monitors"`
+	Next     *MonitorCursor      `json:"next,omitempty"`
+}
+
+var errInvalidMonitorPageQuery = errors.New("invalid monitor page query")
+
+// Normalize validates the query and returns it with the UTC range and the effective limit
+func (q MonitorPageQuery) Normalize() (MonitorPageQuery, error) {
+	if q.Namespace == "" {
+		return q, fmt.Errorf("%w: empty namespace", errInvalidMonitorPageQuery)
+	}
+	if !q.StartTime.Before(q.EndTime) {
+		return q, fmt.Errorf("%w: start time %s is not before end time %s", errInvalidMonitorPageQuery, q.StartTime, q.EndTime)
+	}
+	q.StartTime, q.EndTime = q.StartTime.UTC(), q.EndTime.UTC()
+	if q.After != nil {
+		after := *q.After
+		after.Time = after.Time.UTC()
+		q.After = &after
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultMonitorPageLimit
+	} else if q.Limit > MaxMonitorPageLimit {
+		q.Limit = MaxMonitorPageLimit
+	}
+	return q, nil
+}
+
+// CursorOf returns the cursor positioned at the monitor
+func CursorOf(monitor *resources.Monitor) *MonitorCursor {
+	return &MonitorCursor{Time: monitor.Time.UTC(), Type: monitor.Type, Name: monitor.Name}
+}
+
+// Less reports whether the cursor sorts before the other one by (time, type, name)
+func (c MonitorCursor) Less(other MonitorCursor) bool {
+	if !c.Time.Equal(other.Time) {
+		return c.Time.Before(other.Time)
+	}
+	if c.Type != other.Type {
+		return c.Type < other.Type
+	}
+	return c.Name < other.Name
+}
+
+// NewMonitorPage returns the first limit monitors as the page, the monitors are fetched with limit+1
+// so that a page is known to be the last one without another query.
+func NewMonitorPage(monitors []resources.Monitor, limit int) MonitorPage {
+	if len(monitors) <= limit {
+		return MonitorPage{Monitors: monitors}
+	}
+	monitors = monitors[:limit]
+	return MonitorPage{Monitors: monitors, Next: CursorOf(&monitors[limit-1])}
+}
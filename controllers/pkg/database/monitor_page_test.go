@@ -0,0 +1,98 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMonitorPageQuery_Normalize(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.FixedZone("UTC+8", 8*3600))
+	end := start.Add(time.Hour)
+	tests := []struct {
+		name      string
+		query     MonitorPageQuery
+		wantLimit int
+		wantErr   bool
+	}{
+		{name: "default limit", query: MonitorPageQuery{Namespace: "ns-a", StartTime: start, EndTime: end}, wantLimit: DefaultMonitorPageLimit},
+		{name: "capped limit", query: MonitorPageQuery{Namespace: "ns-a", StartTime: start, EndTime: end, Limit: MaxMonitorPageLimit + 1}, wantLimit: MaxMonitorPageLimit},
+		{name: "limit", query: MonitorPageQuery{Namespace: "ns-a", StartTime: start, EndTime: end, Limit: 10}, wantLimit: 10},
+		{name: "empty namespace", query: MonitorPageQuery{StartTime: start, EndTime: end}, wantErr: true},
+		{name: "empty range", query: MonitorPageQuery{Namespace: "ns-a", StartTime: start, EndTime: start}, wantErr: true},
+		{name: "reversed range", query: MonitorPageQuery{Namespace: "ns-a", StartTime: end, EndTime: start}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.query.Normalize()
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidMonitorPageQuery) {
+					t.Errorf("Normalize() error = %v, want errInvalidMonitorPageQuery", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got.Limit != tt.wantLimit {
+				t.Errorf("Normalize() limit = %d, want %d", got.Limit, tt.wantLimit)
+			}
+			if got.StartTime.Location() != time.UTC || !got.StartTime.Equal(start) {
+				t.Errorf("Normalize() start time = %s, want %s in UTC", got.StartTime, start.UTC())
+			}
+		})
+	}
+}
+
+func TestNewMonitorPage(t *testing.T) {
+	minute := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	monitors := []resources.Monitor{
+		{Time: minute, Name: "app-a"},
+		{Time: minute, Name: "app-b"},
+		{Time: minute.Add(time.Minute), Name: "app-a"},
+	}
+	if page := NewMonitorPage(nil, 2); len(page.Monitors) != 0 || page.Next != nil {
+		t.Errorf("NewMonitorPage(nil) = %+v, want the empty last page", page)
+	}
+	if page := NewMonitorPage(monitors[:2], 2); len(page.Monitors) != 2 || page.Next != nil {
+		t.Errorf("NewMonitorPage() of limit monitors = %+v, want the last page", page)
+	}
+	page := NewMonitorPage(monitors, 2)
+	if len(page.Monitors) != 2 || page.Next == nil || *page.Next != (MonitorCursor{Time: minute, Name: "app-b"}) {
+		t.Errorf("NewMonitorPage() of limit+1 monitors = %+v, want 2 monitors and the cursor at app-b", page)
+	}
+}
+
+func TestMonitorCursor_Less(t *testing.T) {
+	minute := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ordered := []MonitorCursor{
+		{Time: minute, Type: 0, Name: "b"},
+		{Time: minute, Type: 1, Name: "a"},
+		{Time: minute, Type: 1, Name: "b"},
+		{Time: minute.Add(time.Millisecond), Type: 0, Name: "a"},
+	}
+	for i := 1; i < len(ordered); i++ {
+		if !ordered[i-1].Less(ordered[i]) || ordered[i].Less(ordered[i-1]) {
+			t.Errorf("cursor %+v should sort before %+v", ordered[i-1], ordered[i])
+		}
+	}
+	if ordered[0].Less(ordered[0]) {
+		t.Error("a cursor should not sort before itself")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// Pinger is implemented by the stores checking their connection, eg: by the health check of the monitor database
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// replacedDisconnectTimeout bounds the wait of the in-flight calls of a replaced connection before it is closed
+const replacedDisconnectTimeout = 30 * time.Second
+
+// ReconnectingStore a MonitorStore whose connection is replaced by Reconnect, eg: the connection to a failed over
+// primary the driver keeps using. The calls use the current connection, a replaced one is disconnected in the background.
+type ReconnectingStore struct {
+	mu      sync.RWMutex
+	store   MonitorStore
+	connect func(ctx context.Context) (MonitorStore, error)
+}
+
+var (
+	_ MonitorStore            = &ReconnectingStore{}
+	_ DetailedMonitorInserter = &ReconnectingStore{}
+	_ Pinger                  = &ReconnectingStore{}
+)
+
+// NewReconnectingStore connects the store by connect, which is called again by Reconnect
+func NewReconnectingStore(ctx context.Context, connect func(ctx context.Context) (MonitorStore, error)) (*ReconnectingStore, error) {
+	store, err := connect(ctx)
+	if err != nil {
+		if store != nil {
+			_ = store.Disconnect(ctx)
+		}
+		return nil, err
+	}
+	return &ReconnectingStore{store: store, connect: connect}, nil
+}
+
+func (s *ReconnectingStore) current() MonitorStore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store
+}
+
+// Ping pings the current connection, nil if the store doesn't implement Pinger
+func (s *ReconnectingStore) Ping(ctx context.Context) error {
+	if pinger, ok := s.current().(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Reconnect connects a new store and replaces the current one, the current one is kept if the connect fails
+func (s *ReconnectingStore) Reconnect(ctx context.Context) error {
+	store, err := s.connect(ctx)
+	if err != nil {
+		if store != nil {
+			_ = store.Disconnect(ctx)
+		}
+		return err
+	}
+	s.mu.Lock()
+	replaced := s.store
+	s.store = store
+	s.mu.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), replacedDisconnectTimeout)
+		defer cancel()
+		_ = replaced.Disconnect(ctx)
+	}()
+	return nil
+}
+
+func (s *ReconnectingStore) InsertMonitor(ctx context.Context, monitors ...*resources.Monitor) error {
+	return s.current().InsertMonitor(ctx, monitors...)
+}
+
+func (s *ReconnectingStore) InsertMonitorDetailed(ctx context.Context, monitors ...*resources.Monitor) MonitorInsertResults {
+	return InsertMonitorDetailed(ctx, s.current(), monitors...)
+}
+
+func (s *ReconnectingStore) InsertMonitorBatch(ctx context.Context, monitors []*resources.Monitor) error {
+	return s.current().InsertMonitorBatch(ctx, monitors)
+}
+
+func (s *ReconnectingStore) ReplaceMonitors(ctx context.Context, monitors ...*resources.Monitor) error {
+	return s.current().ReplaceMonitors(ctx, monitors...)
+}
+
+func (s *ReconnectingStore) GetDistinctMonitorCombinations(startTime, endTime time.Time, namespace string) ([]resources.Monitor, error) {
+	return s.current().GetDistinctMonitorCombinations(startTime, endTime, namespace)
+}
+
+func (s *ReconnectingStore) QueryMonitors(ctx context.Context, namespace string, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	return s.current().QueryMonitors(ctx, namespace, startTime, endTime, handle)
+}
+
+func (s *ReconnectingStore) QueryMonitorPage(ctx context.Context, query MonitorPageQuery) (MonitorPage, error) {
+	return s.current().QueryMonitorPage(ctx, query)
+}
+
+func (s *ReconnectingStore) GetObjectStorageUsage(user string, startTime, endTime time.Time) ([]resources.ObjStorageBucketUsage, error) {
+	return s.current().GetObjectStorageUsage(user, startTime, endTime)
+}
+
+func (s *ReconnectingStore) DropMonitorCollectionsOlderThan(days int) (int, error) {
+	return s.current().DropMonitorCollectionsOlderThan(days)
+}
+
+func (s *ReconnectingStore) CountMonitorsByCategory(category string) (int64, error) {
+	return s.current().CountMonitorsByCategory(category)
+}
+
+func (s *ReconnectingStore) DeleteMonitorsByCategory(category string) (int64, error) {
+	return s.current().DeleteMonitorsByCategory(category)
+}
+
+func (s *ReconnectingStore) QueryMonitorsInRange(ctx context.Context, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	return s.current().QueryMonitorsInRange(ctx, startTime, endTime, handle)
+}
+
+func (s *ReconnectingStore) IsMonitorRollupDone(ctx context.Context, hour time.Time) (bool, error) {
+	return s.current().IsMonitorRollupDone(ctx, hour)
+}
+
+func (s *ReconnectingStore) SaveMonitorRollups(ctx context.Context, hour time.Time, rollups []*resources.Monitor) error {
+	return s.current().SaveMonitorRollups(ctx, hour, rollups)
+}
+
+func (s *ReconnectingStore) DeleteMonitorsInRange(ctx context.Context, startTime, endTime time.Time) (int64, error) {
+	return s.current().DeleteMonitorsInRange(ctx, startTime, endTime)
+}
+
+func (s *ReconnectingStore) SaveMonitorAggregates(ctx context.Context, granularity MonitorGranularity, start time.Time, aggregates []*resources.Monitor) error {
+	return s.current().SaveMonitorAggregates(ctx, granularity, start, aggregates)
+}
+
+func (s *ReconnectingStore) QueryMonitorAggregates(ctx context.Context, granularity MonitorGranularity, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	return s.current().QueryMonitorAggregates(ctx, granularity, startTime, endTime, handle)
+}
+
+func (s *ReconnectingStore) LatestMonitorAggregate(ctx context.Context, granularity MonitorGranularity) (time.Time, error) {
+	return s.current().LatestMonitorAggregate(ctx, granularity)
+}
+
+func (s *ReconnectingStore) GetNamespaceUsage(category string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	return s.current().GetNamespaceUsage(category, startTime, endTime)
+}
+
+func (s *ReconnectingStore) GetUsageByApp(category, appType, appName string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	return s.current().GetUsageByApp(category, appType, appName, startTime, endTime)
+}
+
+func (s *ReconnectingStore) CreateMonitorTimeSeriesIfNotExist(collTime time.Time) error {
+	return s.current().CreateMonitorTimeSeriesIfNotExist(collTime)
+}
+
+func (s *ReconnectingStore) InitDefaultPropertyTypeLS() error {
+	return s.current().InitDefaultPropertyTypeLS()
+}
+
+func (s *ReconnectingStore) Disconnect(ctx context.Context) error {
+	return s.current().Disconnect(ctx)
+}
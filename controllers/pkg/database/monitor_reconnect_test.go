@@ -0,0 +1,111 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// connStore a connection of the reconnecting store, the pings fail once it's down
+type connStore struct {
+	MonitorStore
+	down         atomic.Bool
+	inserted     atomic.Int32
+	disconnected chan struct{}
+}
+
+func (s *connStore) Ping(_ context.Context) error {
+	if s.down.Load() {
+		return errors.New("connection reset by peer")
+	}
+	return nil
+}
+
+func (s *connStore) InsertMonitor(_ context.Context, _ ...*resources.Monitor) error {
+	s.inserted.Add(1)
+	return nil
+}
+
+func (s *connStore) Disconnect(_ context.Context) error {
+	close(s.disconnected)
+	return nil
+}
+
+func TestReconnectingStore(t *testing.T) {
+	ctx := context.Background()
+	var conns []*connStore
+	var connectErr error
+	store, err := NewReconnectingStore(ctx, func(_ context.Context) (MonitorStore, error) {
+		if connectErr != nil {
+			return nil, connectErr
+		}
+		conn := &connStore{disconnected: make(chan struct{})}
+		conns = append(conns, conn)
+		return conn, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Ping(ctx); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	conns[0].down.Store(true)
+	if err := store.Ping(ctx); err == nil {
+		t.Fatal("Ping() of the dead connection expected error")
+	}
+
+	// the failed connect keeps the current connection
+	connectErr = errors.New("no reachable servers")
+	if err := store.Reconnect(ctx); !errors.Is(err, connectErr) {
+		t.Fatalf("Reconnect() error = %v, want %v", err, connectErr)
+	}
+	if len(conns) != 1 || store.current() != conns[0] {
+		t.Fatal("the failed reconnect replaced the connection")
+	}
+
+	connectErr = nil
+	if err := store.Reconnect(ctx); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+	if err := store.Ping(ctx); err != nil {
+		t.Errorf("Ping() after the reconnect error = %v", err)
+	}
+	if err := store.InsertMonitor(ctx, &resources.Monitor{}); err != nil {
+		t.Fatal(err)
+	}
+	if conns[0].inserted.Load() != 0 || conns[1].inserted.Load() != 1 {
+		t.Errorf("inserts = %d on the replaced connection, %d on the new one, want 0, 1", conns[0].inserted.Load(), conns[1].inserted.Load())
+	}
+	select {
+	case <-conns[0].disconnected:
+	case <-time.After(time.Second):
+		t.Error("the replaced connection is not disconnected")
+	}
+}
+
+func TestNewReconnectingStore_connectError(t *testing.T) {
+	connectErr := errors.New("auth failed")
+	if _, err := NewReconnectingStore(context.Background(), func(_ context.Context) (MonitorStore, error) {
+		return nil, connectErr
+	}); !errors.Is(err, connectErr) {
+		t.Errorf("NewReconnectingStore() error = %v, want %v", err, connectErr)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// IdempotencyKeys returns the distinct idempotency keys of the monitors, the monitors without a key are skipped
+func IdempotencyKeys(monitors []*resources.Monitor) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, monitor := range monitors {
+		if key := monitor.IdempotencyKey; key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// MonitorTimeRange returns the first and the last time of the monitors in UTC, both are included
+func MonitorTimeRange(monitors []*resources.Monitor) (start, end time.Time) {
+	for i, monitor := range monitors {
+		t := monitor.Time.UTC()
+		if i == 0 || t.Before(start) {
+			start = t
+		}
+		if i == 0 || t.After(end) {
+			end = t
+		}
+	}
+	return start, end
+}
+
+// UniqueMonitors returns copies of the monitors with their MonitorID set, the later copies of a record in the batch
+// are dropped. The monitors of the caller are not modified, they may be retried or written to another store.
+func UniqueMonitors(monitors []*resources.Monitor) []*resources.Monitor {
+	unique := make([]*resources.Monitor, 0, len(monitors))
+	seen := make(map[string]bool, len(monitors))
+	for _, monitor := range monitors {
+		id := resources.MonitorID(monitor)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		m := *monitor
+		m.MonitorID = id
+		unique = append(unique, &m)
+	}
+	return unique
+}
+
+// MonitorIDs returns the ids of the monitors returned by UniqueMonitors
+func MonitorIDs(monitors []*resources.Monitor) []string {
+	ids := make([]string, len(monitors))
+	for i, monitor := range monitors {
+		ids[i] = monitor.MonitorID
+	}
+	return ids
+}
+
+// ExcludeStoredMonitors returns the monitors whose id is not stored yet, for the stores checking the existence
+// before the insert instead of a unique index
+func ExcludeStoredMonitors(monitors []*resources.Monitor, stored map[string]bool) []*resources.Monitor {
+	if len(stored) == 0 {
+		return monitors
+	}
+	var missing []*resources.Monitor
+	for _, monitor := range monitors {
+		if !stored[monitor.MonitorID] {
+			missing = append(missing, monitor)
+		}
+	}
+	return missing
+}
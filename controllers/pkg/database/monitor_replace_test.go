@@ -0,0 +1,78 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	monitors := []*resources.Monitor{
+		{Name: "app-a", IdempotencyKey: "traffic/a"},
+		{Name: "app-b"},
+		{Name: "app-a", IdempotencyKey: "traffic/a"},
+		{Name: "app-c", IdempotencyKey: "traffic/c"},
+	}
+	if got, want := IdempotencyKeys(monitors), []string{"traffic/a", "traffic/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IdempotencyKeys() = %v, want %v", got, want)
+	}
+	if got := IdempotencyKeys(monitors[1:2]); len(got) != 0 {
+		t.Errorf("IdempotencyKeys() without keys = %v, want empty", got)
+	}
+}
+
+func TestMonitorTimeRange(t *testing.T) {
+	minute := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	start, end := MonitorTimeRange([]*resources.Monitor{
+		{Time: minute.Add(time.Minute)},
+		{Time: minute.In(time.FixedZone("UTC+8", 8*3600))},
+		{Time: minute.Add(2 * time.Minute)},
+	})
+	if !start.Equal(minute) || !end.Equal(minute.Add(2*time.Minute)) || start.Location() != time.UTC {
+		t.Errorf("MonitorTimeRange() = %s, %s, want %s, %s", start, end, minute, minute.Add(2*time.Minute))
+	}
+}
+
+func TestUniqueMonitors(t *testing.T) {
+	minute := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	monitors := []*resources.Monitor{
+		{Category: "ns-a", Name: "app-a", Time: minute, Used: resources.EnumUsedMap{0: 1}},
+		{Category: "ns-a", Name: "app-b", Time: minute},
+		{Category: "ns-a", Name: "app-a", Time: minute, Used: resources.EnumUsedMap{0: 2}},
+		{Category: "ns-a", Name: "app-a", Time: minute.Add(time.Minute)},
+	}
+	unique := UniqueMonitors(monitors)
+	if len(unique) != 3 || unique[0].Used[0] != 1 || unique[1].Name != "app-b" || !unique[2].Time.Equal(minute.Add(time.Minute)) {
+		t.Fatalf("UniqueMonitors() = %v, want the first copy of app-a kept", unique)
+	}
+	for i, monitor := range unique {
+		if monitor.MonitorID == "" {
+			t.Errorf("UniqueMonitors()[%d] without the id", i)
+		}
+	}
+	if monitors[0].MonitorID != "" {
+		t.Errorf("UniqueMonitors() modified the monitors of the caller")
+	}
+
+	stored := map[string]bool{unique[1].MonitorID: true}
+	missing := ExcludeStoredMonitors(unique, stored)
+	if got, want := MonitorIDs(missing), []string{unique[0].MonitorID, unique[2].MonitorID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ExcludeStoredMonitors() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MonitorSchemaMigration migrates a monitor collection to Version. The migrations of a store are numbered from 1 in
+// order, Up must be idempotent since it runs again if the version was not saved, eg: the controller was killed.
+type MonitorSchemaMigration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, collection string) error
+}
+
+// MonitorSchemaStore is implemented by the stores keeping a schema version per monitor collection (mongo), the stores
+// migrating their tables by the idempotent DDL on connect (postgres, clickhouse) don't implement it
+type MonitorSchemaStore interface {
+	// MonitorSchemaMigrations returns the migrations of the store ordered by version
+	MonitorSchemaMigrations() []MonitorSchemaMigration
+	// MonitorSchemaCollections returns the monitor collections kept at a schema version
+	MonitorSchemaCollections(ctx context.Context) ([]string, error)
+	// MonitorSchemaVersion returns the schema version of the collection, 0 if it was never migrated
+	MonitorSchemaVersion(ctx context.Context, collection string) (int, error)
+	SetMonitorSchemaVersion(ctx context.Context, collection string, version int) error
+	// AcquireMonitorSchemaLock acquires the lock of the migrations, or extends it if held by the owner, for the ttl.
+	// It returns false if another owner holds the lock.
+	AcquireMonitorSchemaLock(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+	ReleaseMonitorSchemaLock(ctx context.Context, owner string) error
+}
+
+// ErrMonitorSchemaTooNew the monitor collections were migrated by a newer controller, the controller refuses to run
+// against them since its queries may misread the newer documents
+var ErrMonitorSchemaTooNew = errors.New("the monitor schema is newer than the controller supports")
+
+const (
+	// monitorSchemaLockTTL the lock expires if the owner died, it is extended while the migrations run
+	monitorSchemaLockTTL = 2 * time.Minute
+	// monitorSchemaLockPollInterval the wait between the attempts to acquire the lock held by another controller
+	monitorSchemaLockPollInterval = 5 * time.Second
+)
+
+// MigrateMonitorSchema migrates the monitor collections of the store to the latest version of its migrations under
+// the lock of the owner, eg: the pod name, so the controllers started together don't migrate the same collections.
+// It waits for the lock until the ctx is done, and returns the number of the migrations applied to the collections.
+// The store (or the current connection of a ReconnectingStore, unwrapped from an InstrumentedStore) not implementing
+// MonitorSchemaStore is not migrated.
+func MigrateMonitorSchema(ctx context.Context, store MonitorStore, owner string) (int, error) {
+	store = unwrapMonitorStore(store)
+	schemaStore, ok := store.(MonitorSchemaStore)
+	if !ok {
+		return 0, nil
+	}
+	migrations := schemaStore.MonitorSchemaMigrations()
+	if err := validateMonitorSchemaMigrations(migrations); err != nil {
+		return 0, err
+	}
+	if err := acquireMonitorSchemaLock(ctx, schemaStore, owner); err != nil {
+		return 0, err
+	}
+	defer func() {
+		// released even if the ctx is done, the lock would otherwise block the other controllers until it expires
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = schemaStore.ReleaseMonitorSchemaLock(releaseCtx, owner)
+	}()
+
+	// the lock is extended while the migrations run, a migration failed to extend it stops at the next collection
+	renewCtx, cancelRenew := context.WithCancel(ctx)
+	lost := make(chan error, 1)
+	go renewMonitorSchemaLock(renewCtx, schemaStore, owner, lost)
+	defer cancelRenew()
+
+	return runMonitorSchemaMigrations(ctx, schemaStore, migrations, lost)
+}
+
+func validateMonitorSchemaMigrations(migrations []MonitorSchemaMigration) error {
+	for i, migration := range migrations {
+		if migration.Version != i+1 || migration.Up == nil {
+			return fmt.Errorf("invalid monitor schema migration %d %q: the migrations must be numbered from 1 in order with an up", migration.Version, migration.Description)
+		}
+	}
+	return nil
+}
+
+func acquireMonitorSchemaLock(ctx context.Context, store MonitorSchemaStore, owner string) error {
+	for {
+		acquired, err := store.AcquireMonitorSchemaLock(ctx, owner, monitorSchemaLockTTL)
+		if err != nil {
+			return fmt.Errorf("failed to acquire the monitor schema lock: %w", err)
+		}
+		if acquired {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the monitor schema lock is held by another controller: %w", ctx.Err())
+		case <-time.After(monitorSchemaLockPollInterval):
+		}
+	}
+}
+
+func renewMonitorSchemaLock(ctx context.Context, store MonitorSchemaStore, owner string, lost chan<- error) {
+	ticker := time.NewTicker(monitorSchemaLockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		acquired, err := store.AcquireMonitorSchemaLock(ctx, owner, monitorSchemaLockTTL)
+		if err == nil && !acquired {
+			err = errors.New("the lock was taken by another controller")
+		}
+		if err != nil && ctx.Err() == nil {
+			lost <- fmt.Errorf("failed to extend the monitor schema lock: %w", err)
+			return
+		}
+	}
+}
+
+// runMonitorSchemaMigrations checks the versions of all the collections before migrating any of them, so a controller
+// older than a collection doesn't migrate the others either
+func runMonitorSchemaMigrations(ctx context.Context, store MonitorSchemaStore, migrations []MonitorSchemaMigration, lost <-chan error) (int, error) {
+	collections, err := store.MonitorSchemaCollections(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the monitor collections: %w", err)
+	}
+	sort.Strings(collections)
+	latest := len(migrations)
+	versions := make(map[string]int, len(collections))
+	for _, collection := range collections {
+		version, err := store.MonitorSchemaVersion(ctx, collection)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get the schema version of %s: %w", collection, err)
+		}
+		if version > latest {
+			return 0, fmt.Errorf("%w: %s is at version %d, the latest known is %d", ErrMonitorSchemaTooNew, collection, version, latest)
+		}
+		versions[collection] = version
+	}
+	applied := 0
+	for _, collection := range collections {
+		for _, migration := range migrations[versions[collection]:] {
+			select {
+			case err := <-lost:
+				return applied, err
+			default:
+			}
+			if err := migration.Up(ctx, collection); err != nil {
+				return applied, fmt.Errorf("failed to migrate %s to version %d (%s): %w", collection, migration.Version, migration.Description, err)
+			}
+			if err := store.SetMonitorSchemaVersion(ctx, collection, migration.Version); err != nil {
+				return applied, fmt.Errorf("failed to set the schema version of %s to %d: %w", collection, migration.Version, err)
+			}
+			applied++
+		}
+	}
+	return applied, nil
+}
@@ -0,0 +1,198 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// schemaStore keeps the documents of the collections as maps, the migrations backfill the fields of the old shapes
+type schemaStore struct {
+	MonitorStore
+	mu        sync.Mutex
+	docs      map[string][]map[string]any
+	versions  map[string]int
+	lockOwner string
+	failAt    int
+}
+
+func newSchemaStore() *schemaStore {
+	return &schemaStore{
+		docs: map[string][]map[string]any{
+			// the first shape without the id, then the id without the detail
+			"monitor_20240101": {{"category": "ns-a", "name": "bucket-a"}},
+			"monitor_20240102": {{"category": "ns-a", "name": "bucket-a", "monitor_id": "id-a"}},
+		},
+		versions: map[string]int{},
+	}
+}
+
+func (s *schemaStore) backfill(field string, value func(doc map[string]any) any) func(context.Context, string) error {
+	return func(_ context.Context, collection string) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, doc := range s.docs[collection] {
+			if _, ok := doc[field]; !ok {
+				doc[field] = value(doc)
+			}
+		}
+		return nil
+	}
+}
+
+func (s *schemaStore) MonitorSchemaMigrations() []MonitorSchemaMigration {
+	migrations := []MonitorSchemaMigration{
+		{Version: 1, Description: "backfill monitor_id", Up: s.backfill("monitor_id", func(doc map[string]any) any {
+			return doc["category"].(string) + "/" + doc["name"].(string)
+		})},
+		{Version: 2, Description: "backfill detail", Up: s.backfill("detail", func(map[string]any) any { return "cn-1" })},
+	}
+	if s.failAt > 0 {
+		migrations[s.failAt-1].Up = func(context.Context, string) error { return errors.New("update failed") }
+	}
+	return migrations
+}
+
+func (s *schemaStore) MonitorSchemaCollections(_ context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var collections []string
+	for collection := range s.docs {
+		collections = append(collections, collection)
+	}
+	return collections, nil
+}
+
+func (s *schemaStore) MonitorSchemaVersion(_ context.Context, collection string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.versions[collection], nil
+}
+
+func (s *schemaStore) SetMonitorSchemaVersion(_ context.Context, collection string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[collection] = version
+	return nil
+}
+
+func (s *schemaStore) AcquireMonitorSchemaLock(_ context.Context, owner string, _ time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lockOwner != "" && s.lockOwner != owner {
+		return false, nil
+	}
+	s.lockOwner = owner
+	return true, nil
+}
+
+func (s *schemaStore) ReleaseMonitorSchemaLock(_ context.Context, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lockOwner == owner {
+		s.lockOwner = ""
+	}
+	return nil
+}
+
+func TestMigrateMonitorSchema(t *testing.T) {
+	ctx := context.Background()
+	store := newSchemaStore()
+	applied, err := MigrateMonitorSchema(ctx, store, "pod-a")
+	if err != nil {
+		t.Fatalf("MigrateMonitorSchema() error = %v", err)
+	}
+	if applied != 4 || store.versions["monitor_20240101"] != 2 || store.versions["monitor_20240102"] != 2 {
+		t.Fatalf("applied = %d, versions = %v, want 4 applied and the collections at 2", applied, store.versions)
+	}
+	for collection, docs := range store.docs {
+		if doc := docs[0]; doc["monitor_id"] == nil || doc["detail"] != "cn-1" {
+			t.Errorf("%s document = %v, want the fields backfilled", collection, doc)
+		}
+	}
+	if store.docs["monitor_20240102"][0]["monitor_id"] != "id-a" {
+		t.Errorf("monitor_id = %v, want the stored id kept", store.docs["monitor_20240102"][0]["monitor_id"])
+	}
+	if store.lockOwner != "" {
+		t.Errorf("lock owner = %q, want released", store.lockOwner)
+	}
+	// the migrated collections are not migrated again
+	if applied, err := MigrateMonitorSchema(ctx, store, "pod-b"); err != nil || applied != 0 {
+		t.Errorf("MigrateMonitorSchema() again = %d, %v, want nothing applied", applied, err)
+	}
+}
+
+func TestMigrateMonitorSchema_resume(t *testing.T) {
+	ctx := context.Background()
+	store := newSchemaStore()
+	store.versions["monitor_20240102"] = 1
+	store.failAt = 2
+	if _, err := MigrateMonitorSchema(ctx, store, "pod-a"); err == nil {
+		t.Fatal("MigrateMonitorSchema() of the failing migration expected error")
+	}
+	// the first collection stopped at the version before the failed migration
+	if store.versions["monitor_20240101"] != 1 || store.versions["monitor_20240102"] != 1 {
+		t.Fatalf("versions = %v, want both at 1", store.versions)
+	}
+	store.failAt = 0
+	applied, err := MigrateMonitorSchema(ctx, store, "pod-a")
+	if err != nil || applied != 2 {
+		t.Fatalf("MigrateMonitorSchema() resumed = %d, %v, want the 2 remaining migrations applied", applied, err)
+	}
+}
+
+func TestMigrateMonitorSchema_tooNew(t *testing.T) {
+	store := newSchemaStore()
+	store.versions["monitor_20240102"] = 3
+	if _, err := MigrateMonitorSchema(context.Background(), store, "pod-a"); !errors.Is(err, ErrMonitorSchemaTooNew) {
+		t.Fatalf("MigrateMonitorSchema() error = %v, want %v", err, ErrMonitorSchemaTooNew)
+	}
+	// no collection is migrated by the older controller
+	if store.versions["monitor_20240101"] != 0 || store.docs["monitor_20240101"][0]["monitor_id"] != nil {
+		t.Errorf("monitor_20240101 migrated to %d, want untouched", store.versions["monitor_20240101"])
+	}
+}
+
+func TestMigrateMonitorSchema_locked(t *testing.T) {
+	store := newSchemaStore()
+	store.lockOwner = "pod-b"
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := MigrateMonitorSchema(ctx, store, "pod-a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("MigrateMonitorSchema() error = %v, want the wait for the lock timed out", err)
+	}
+	if len(store.versions) != 0 || store.lockOwner != "pod-b" {
+		t.Errorf("versions = %v, lock owner = %q, want nothing migrated under the lock of pod-b", store.versions, store.lockOwner)
+	}
+}
+
+func TestMigrateMonitorSchema_reconnecting(t *testing.T) {
+	store := newSchemaStore()
+	reconnecting, err := NewReconnectingStore(context.Background(), func(context.Context) (MonitorStore, error) { return store, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := MigrateMonitorSchema(context.Background(), reconnecting, "pod-a"); err != nil || applied != 4 {
+		t.Errorf("MigrateMonitorSchema() of the reconnecting store = %d, %v, want the connection migrated", applied, err)
+	}
+	// the stores without a schema version are not migrated
+	if applied, err := MigrateMonitorSchema(context.Background(), &connStore{}, "pod-a"); err != nil || applied != 0 {
+		t.Errorf("MigrateMonitorSchema() of the store without schema = %d, %v, want skipped", applied, err)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// StreamMonitors streams the monitors in [startTime, endTime) to fn through the cursor of the store, the monitors of
+// the category (namespace) only if it's not empty. The monitors are not buffered, so the memory stays bounded
+// however many are streamed, and an error of fn stops the stream.
+func StreamMonitors(ctx context.Context, store MonitorStore, startTime, endTime time.Time, category string, fn func(monitor *resources.Monitor) error) error {
+	if category != "" {
+		return store.QueryMonitors(ctx, category, startTime, endTime, fn)
+	}
+	return store.QueryMonitorsInRange(ctx, startTime, endTime, fn)
+}
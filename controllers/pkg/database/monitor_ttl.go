@@ -0,0 +1,43 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+// MonitorTTLStore is implemented by the stores expiring the monitors themselves by a TTL on the monitor time (mongo),
+// which the ttl retention mode uses instead of dropping the expired daily collections
+type MonitorTTLStore interface {
+	// SetMonitorTTL sets the TTL of the daily monitor collections to the days, 0 removes it. It is idempotent, the
+	// collections already at the TTL are not changed, and returns the number of the collections changed.
+	SetMonitorTTL(ctx context.Context, days int) (int, error)
+}
+
+// ErrMonitorTTLUnsupported the store doesn't expire the monitors by a TTL
+var ErrMonitorTTLUnsupported = errors.New("the monitor storage doesn't support the ttl retention")
+
+// SetMonitorTTL sets the TTL of the store, or of the current connection of a ReconnectingStore and the store under an
+// InstrumentedStore, see MonitorTTLStore.
+// It returns ErrMonitorTTLUnsupported if the store doesn't implement MonitorTTLStore.
+func SetMonitorTTL(ctx context.Context, store MonitorStore, days int) (int, error) {
+	store = unwrapMonitorStore(store)
+	ttlStore, ok := store.(MonitorTTLStore)
+	if !ok {
+		return 0, ErrMonitorTTLUnsupported
+	}
+	return ttlStore.SetMonitorTTL(ctx, days)
+}
@@ -0,0 +1,153 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// MaxUsageRange the longest range of a usage query, a quarter of the billing
+const MaxUsageRange = 92 * 24 * time.Hour
+
+// UsageSource the monitors a usage is summed from
+type UsageSource int
+
+const (
+	// UsageMinute the minute monitors
+	UsageMinute UsageSource = iota
+	// UsageHourly the hourly aggregates of MonitorHourly
+	UsageHourly
+	// UsageRollup the hourly rollups of the minute monitors deleted by the rollup, counted by the start of the hour
+	UsageRollup
+)
+
+func (s UsageSource) String() string {
+	switch s {
+	case UsageMinute:
+		return "minute"
+	case UsageHourly:
+		return "hourly"
+	case UsageRollup:
+		return "rollup"
+	}
+	return fmt.Sprintf("UsageSource(%d)", int(s))
+}
+
+// UsageQuery the used resources of a namespace in [Start, End), or of an app of the namespace if Type is set
+type UsageQuery struct {
+	Category string
+	// Type the resource type of the app, eg: resources.AppType[resources.DB], all monitors of the namespace if nil
+	Type *uint8
+	// Name the app name, required with Type
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+var errInvalidUsageQuery = errors.New("invalid usage query")
+
+// NewAppUsageQuery returns the query of the app, appType is a key of resources.AppType, eg: resources.DB
+func NewAppUsageQuery(category, appType, appName string, start, end time.Time) (UsageQuery, error) {
+	t, ok := resources.AppType[appType]
+	if !ok {
+		return UsageQuery{}, fmt.Errorf("%w: unknown app type %q", errInvalidUsageQuery, appType)
+	}
+	return UsageQuery{Category: category, Type: &t, Name: appName, Start: start, End: end}, nil
+}
+
+// Normalize validates the query and returns it with the UTC range, the range is at most MaxUsageRange
+func (q UsageQuery) Normalize() (UsageQuery, error) {
+	if q.Category == "" {
+		return UsageQuery{}, fmt.Errorf("%w: category is required", errInvalidUsageQuery)
+	}
+	if q.Type != nil && q.Name == "" {
+		return UsageQuery{}, fmt.Errorf("%w: app name is required", errInvalidUsageQuery)
+	}
+	if !q.Start.Before(q.End) {
+		return UsageQuery{}, fmt.Errorf("%w: start %s must be before end %s", errInvalidUsageQuery, q.Start.Format(time.RFC3339), q.End.Format(time.RFC3339))
+	}
+	if q.End.Sub(q.Start) > MaxUsageRange {
+		return UsageQuery{}, fmt.Errorf("%w: range %s is longer than %s", errInvalidUsageQuery, q.End.Sub(q.Start), MaxUsageRange)
+	}
+	q.Start, q.End = q.Start.UTC(), q.End.UTC()
+	return q, nil
+}
+
+// UsageSpan the range [Start, End) read from a source
+type UsageSpan struct {
+	Source UsageSource
+	Start  time.Time
+	End    time.Time
+}
+
+// PlanUsage splits [start, end) into the spans of the sources. The full hours in [earliest, latest] of the hourly
+// aggregates are read from them, the rest from the minute monitors and from the rollups of the deleted minute monitors.
+// The hourly aggregates are not used if earliest is zero.
+func PlanUsage(start, end, earliest, latest time.Time) []UsageSpan {
+	var spans []UsageSpan
+	minutes := func(from, to time.Time) {
+		if from.Before(to) {
+			spans = append(spans, UsageSpan{Source: UsageMinute, Start: from, End: to}, UsageSpan{Source: UsageRollup, Start: from, End: to})
+		}
+	}
+	hourStart, hourEnd := start.Truncate(time.Hour), end.Truncate(time.Hour)
+	if hourStart.Before(start) {
+		hourStart = hourStart.Add(time.Hour)
+	}
+	if !earliest.IsZero() {
+		if hourStart.Before(earliest) {
+			hourStart = earliest
+		}
+		if covered := latest.Add(time.Hour); hourEnd.After(covered) {
+			hourEnd = covered
+		}
+	}
+	if earliest.IsZero() || !hourStart.Before(hourEnd) {
+		minutes(start, end)
+		return spans
+	}
+	minutes(start, hourStart)
+	spans = append(spans, UsageSpan{Source: UsageHourly, Start: hourStart, End: hourEnd})
+	minutes(hourEnd, end)
+	return spans
+}
+
+// UsageSummer sums the used resources of the monitors of the query in the span
+type UsageSummer func(ctx context.Context, q UsageQuery, span UsageSpan) (map[uint8]int64, error)
+
+// SumUsage validates the query and sums the used resources of the spans planned with the hourly aggregates in [earliest, latest]
+func SumUsage(ctx context.Context, q UsageQuery, earliest, latest time.Time, sum UsageSummer) (map[uint8]int64, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[uint8]int64)
+	for _, span := range PlanUsage(q.Start, q.End, earliest, latest) {
+		spanUsed, err := sum(ctx, q, span)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sum the %s usage of %s in [%s, %s): %w", span.Source, q.Category,
+				span.Start.Format(time.RFC3339), span.End.Format(time.RFC3339), err)
+		}
+		for enum, v := range spanUsed {
+			used[enum] += v
+		}
+	}
+	return used, nil
+}
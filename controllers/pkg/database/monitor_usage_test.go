@@ -0,0 +1,137 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestUsageQuery_Normalize(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.FixedZone("UTC+8", 8*3600))
+	end := start.Add(time.Hour)
+	app := resources.AppType[resources.APP]
+	tests := []struct {
+		name    string
+		query   UsageQuery
+		wantErr bool
+	}{
+		{name: "namespace", query: UsageQuery{Category: "ns-a", Start: start, End: end}},
+		{name: "app", query: UsageQuery{Category: "ns-a", Type: &app, Name: "app-a", Start: start, End: end}},
+		{name: "max range", query: UsageQuery{Category: "ns-a", Start: start, End: start.Add(MaxUsageRange)}},
+		{name: "empty category", query: UsageQuery{Start: start, End: end}, wantErr: true},
+		{name: "app without name", query: UsageQuery{Category: "ns-a", Type: &app, Start: start, End: end}, wantErr: true},
+		{name: "empty range", query: UsageQuery{Category: "ns-a", Start: start, End: start}, wantErr: true},
+		{name: "reversed range", query: UsageQuery{Category: "ns-a", Start: end, End: start}, wantErr: true},
+		{name: "too long", query: UsageQuery{Category: "ns-a", Start: start, End: start.Add(MaxUsageRange + time.Second)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.query.Normalize()
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidUsageQuery) {
+					t.Errorf("Normalize() error = %v, want errInvalidUsageQuery", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got.Start.Location() != time.UTC || !got.Start.Equal(start) || got.End.Location() != time.UTC {
+				t.Errorf("Normalize() range = %s - %s, want %s in UTC", got.Start, got.End, start.UTC())
+			}
+		})
+	}
+	if _, err := NewAppUsageQuery("ns-a", "unknown", "app-a", start, end); !errors.Is(err, errInvalidUsageQuery) {
+		t.Errorf("NewAppUsageQuery() with an unknown type error = %v, want errInvalidUsageQuery", err)
+	}
+}
+
+func TestPlanUsage(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours, minutes int) time.Time {
+		return day.Add(time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute)
+	}
+	minutes := func(from, to time.Time) []UsageSpan {
+		return []UsageSpan{{Source: UsageMinute, Start: from, End: to}, {Source: UsageRollup, Start: from, End: to}}
+	}
+	hourly := func(from, to time.Time) []UsageSpan {
+		return []UsageSpan{{Source: UsageHourly, Start: from, End: to}}
+	}
+	concat := func(spans ...[]UsageSpan) []UsageSpan {
+		var all []UsageSpan
+		for _, s := range spans {
+			all = append(all, s...)
+		}
+		return all
+	}
+	tests := []struct {
+		name             string
+		start, end       time.Time
+		earliest, latest time.Time
+		want             []UsageSpan
+	}{
+		{name: "no aggregates", start: at(0, 0), end: at(5, 0), want: minutes(at(0, 0), at(5, 0))},
+		{name: "aggregated", start: at(0, 0), end: at(5, 0), earliest: at(0, 0), latest: at(10, 0), want: hourly(at(0, 0), at(5, 0))},
+		{name: "partial hours", start: at(0, 30), end: at(5, 15), earliest: at(0, 0), latest: at(10, 0),
+			want: concat(minutes(at(0, 30), at(1, 0)), hourly(at(1, 0), at(5, 0)), minutes(at(5, 0), at(5, 15)))},
+		{name: "after the latest", start: at(0, 0), end: at(5, 0), earliest: at(0, 0), latest: at(2, 0),
+			want: concat(hourly(at(0, 0), at(3, 0)), minutes(at(3, 0), at(5, 0)))},
+		{name: "before the earliest", start: at(0, 0), end: at(5, 0), earliest: at(2, 0), latest: at(10, 0),
+			want: concat(minutes(at(0, 0), at(2, 0)), hourly(at(2, 0), at(5, 0)))},
+		{name: "within an hour", start: at(1, 10), end: at(1, 50), earliest: at(0, 0), latest: at(10, 0), want: minutes(at(1, 10), at(1, 50))},
+		{name: "not aggregated yet", start: at(0, 0), end: at(5, 0), earliest: at(6, 0), latest: at(10, 0), want: minutes(at(0, 0), at(5, 0))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PlanUsage(tt.start, tt.end, tt.earliest, tt.latest); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanUsage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSumUsage(t *testing.T) {
+	start, end := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC), time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	got, err := SumUsage(context.Background(), UsageQuery{Category: "ns-a", Start: start, End: end}, start.Truncate(time.Hour), end,
+		func(_ context.Context, q UsageQuery, span UsageSpan) (map[uint8]int64, error) {
+			if q.Category != "ns-a" {
+				t.Errorf("sum of category %q, want ns-a", q.Category)
+			}
+			return map[uint8]int64{0: int64(span.Source) + 1, 1: span.End.Sub(span.Start).Milliseconds() / 60000}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// minute, rollup and hourly spans of 30, 30 and 120 minutes
+	if want := map[uint8]int64{0: 1 + 3 + 2, 1: 30 + 30 + 120}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SumUsage() = %v, want %v", got, want)
+	}
+	failed := errors.New("connection reset")
+	if _, err := SumUsage(context.Background(), UsageQuery{Category: "ns-a", Start: start, End: end}, time.Time{}, time.Time{},
+		func(context.Context, UsageQuery, UsageSpan) (map[uint8]int64, error) {
+			return nil, failed
+		}); !errors.Is(err, failed) {
+		t.Errorf("SumUsage() error = %v, want %v", err, failed)
+	}
+	if _, err := SumUsage(context.Background(), UsageQuery{Start: start, End: end}, time.Time{}, time.Time{}, nil); !errors.Is(err, errInvalidUsageQuery) {
+		t.Errorf("SumUsage() without category error = %v, want errInvalidUsageQuery", err)
+	}
+}
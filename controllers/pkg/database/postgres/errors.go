@@ -0,0 +1,62 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	errClassConnection        = "08"
+	errClassInvalidAuth       = "28"
+	errCodeInsufficientPriv   = "42501"
+	errCodeSerialization      = "40001"
+	errCodeDeadlock           = "40P01"
+	errCodeAdminShutdown      = "57P01"
+	errCodeCannotConnectNow   = "57P03"
+	errCodeTooManyConnections = "53300"
+)
+
+// classifyError marks the postgres error as transient, config or permanent for the retry layer
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || pgconn.Timeout(err) {
+		return retry.Transient(err)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, errClassConnection),
+			pgErr.Code == errCodeSerialization, pgErr.Code == errCodeDeadlock,
+			pgErr.Code == errCodeAdminShutdown, pgErr.Code == errCodeCannotConnectNow, pgErr.Code == errCodeTooManyConnections:
+			return retry.Transient(err)
+		case strings.HasPrefix(pgErr.Code, errClassInvalidAuth), pgErr.Code == errCodeInsufficientPriv:
+			return retry.Config(err)
+		}
+	}
+	return retry.Permanent(err)
+}
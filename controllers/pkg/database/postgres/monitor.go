@@ -0,0 +1,488 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/logger"
+)
+
+// maxInsertRows bounds the rows of an insert statement, postgres allows at most 65535 parameters
+const maxInsertRows = 1000
+
+const monitorColumns = "time, category, type, name, used, property, utilization, objstorage, tenant, labels, idempotency_key"
+
+// InsertMonitor inserts the monitors into the daily partition of the first monitor in a transaction,
+// the monitors of a reconcile share the same time.
+// The returned error is classified by retry.IsTransient / retry.IsPermanent
+func (p *postgresDB) InsertMonitor(ctx context.Context, monitors ...*resources.Monitor) error {
+	if len(monitors) == 0 {
+		return nil
+	}
+	if err := p.ensurePartition(ctx, monitors[0].Time); err != nil {
+		return err
+	}
+	return p.insertMonitors(ctx, monitors)
+}
+
+// insertMonitors inserts the monitors in a transaction, the stored monitors of the idempotency keys are deleted first if any,
+// the monitors already stored under the same id are skipped
+func (p *postgresDB) insertMonitors(ctx context.Context, monitors []*resources.Monitor, keys ...string) error {
+	monitors = database.UniqueMonitors(monitors)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return classifyError(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if len(keys) > 0 {
+		start, end := database.MonitorTimeRange(monitors)
+		// the time range prunes the daily partitions
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE idempotency_key = ANY($1) AND time >= $2 AND time <= $3`, p.MonitorTable),
+			keys, start, end); err != nil {
+			return classifyError(err)
+		}
+	}
+	for start := 0; start < len(monitors); start += maxInsertRows {
+		end := start + maxInsertRows
+		if end > len(monitors) {
+			end = len(monitors)
+		}
+		stmt, args, err := insertUniqueMonitorStatement(p.MonitorTable, monitors[start:end])
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, stmt, args...); err != nil {
+			return classifyError(err)
+		}
+	}
+	return classifyError(tx.Commit())
+}
+
+// InsertMonitorBatch inserts the monitors of many namespaces in a transaction, the partitions of all days are ensured
+func (p *postgresDB) InsertMonitorBatch(ctx context.Context, monitors []*resources.Monitor) error {
+	if len(monitors) == 0 {
+		return nil
+	}
+	if err := p.ensurePartitions(ctx, monitors); err != nil {
+		return err
+	}
+	return p.insertMonitors(ctx, monitors)
+}
+
+// ReplaceMonitors deletes the monitors of the idempotency keys and inserts the monitors in a transaction,
+// the monitors without a key are only inserted
+func (p *postgresDB) ReplaceMonitors(ctx context.Context, monitors ...*resources.Monitor) error {
+	if len(monitors) == 0 {
+		return nil
+	}
+	if err := p.ensurePartitions(ctx, monitors); err != nil {
+		return err
+	}
+	return p.insertMonitors(ctx, monitors, database.IdempotencyKeys(monitors)...)
+}
+
+func (p *postgresDB) ensurePartitions(ctx context.Context, monitors []*resources.Monitor) error {
+	days := make(map[string]bool)
+	for _, monitor := range monitors {
+		if name := partitionName(p.MonitorTable, monitor.Time); !days[name] {
+			days[name] = true
+			if err := p.ensurePartition(ctx, monitor.Time); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func insertMonitorStatement(table string, monitors []*resources.Monitor) (string, []interface{}, error) {
+	return monitorInsertStatement(table, monitors, false)
+}
+
+// insertUniqueMonitorStatement inserts the monitors returned by database.UniqueMonitors with their ids,
+// the monitors already stored are skipped by the unique index of the ids
+func insertUniqueMonitorStatement(table string, monitors []*resources.Monitor) (string, []interface{}, error) {
+	return monitorInsertStatement(table, monitors, true)
+}
+
+func monitorInsertStatement(table string, monitors []*resources.Monitor, unique bool) (string, []interface{}, error) {
+	columns := 11
+	if unique {
+		columns++
+	}
+	var values strings.Builder
+	args := make([]interface{}, 0, len(monitors)*columns)
+	for i, monitor := range monitors {
+		used, err := json.Marshal(monitor.Used)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshal used of %s: %w", monitor.Name, err)
+		}
+		utilization, err := marshalNullable(monitor.Utilization, len(monitor.Utilization) == 0)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshal utilization of %s: %w", monitor.Name, err)
+		}
+		objStorage, err := marshalNullable(monitor.ObjStorage, monitor.ObjStorage == nil)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshal objstorage of %s: %w", monitor.Name, err)
+		}
+		tenant, err := marshalNullable(monitor.Tenant, len(monitor.Tenant) == 0)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshal tenant of %s: %w", monitor.Name, err)
+		}
+		labels, err := marshalNullable(monitor.Labels, len(monitor.Labels) == 0)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshal labels of %s: %w", monitor.Name, err)
+		}
+		if i > 0 {
+			values.WriteString(", ")
+		}
+		values.WriteString("(")
+		for c := 1; c <= columns; c++ {
+			if c > 1 {
+				values.WriteString(", ")
+			}
+			values.WriteString("$" + strconv.Itoa(i*columns+c))
+		}
+		values.WriteString(")")
+		args = append(args, monitor.Time.UTC(), monitor.Category, int16(monitor.Type), monitor.Name, string(used),
+			sql.NullString{String: monitor.Property, Valid: monitor.Property != ""}, utilization, objStorage, tenant, labels,
+			sql.NullString{String: monitor.IdempotencyKey, Valid: monitor.IdempotencyKey != ""})
+		if unique {
+			args = append(args, monitor.MonitorID)
+		}
+	}
+	if unique {
+		return fmt.Sprintf("INSERT INTO %s (%s, monitor_id) VALUES %s ON CONFLICT (monitor_id, time) DO NOTHING",
+			table, monitorColumns, values.String()), args, nil
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, monitorColumns, values.String()), args, nil
+}
+
+func marshalNullable(v interface{}, null bool) (sql.NullString, error) {
+	if null {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(v)
+	return sql.NullString{String: string(data), Valid: err == nil}, err
+}
+
+func (p *postgresDB) GetDistinctMonitorCombinations(startTime, endTime time.Time, namespace string) ([]resources.Monitor, error) {
+	rows, err := p.DB.QueryContext(context.Background(), fmt.Sprintf(
+		`SELECT DISTINCT category, type, name FROM %s WHERE category = $1 AND time >= $2 AND time < $3`, p.MonitorTable),
+		namespace, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	var monitors []resources.Monitor
+	for rows.Next() {
+		var (
+			monitor resources.Monitor
+			_type   int16
+		)
+		if err := rows.Scan(&monitor.Category, &_type, &monitor.Name); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		monitor.Type = uint8(_type)
+		monitors = append(monitors, monitor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return monitors, nil
+}
+
+// QueryMonitors streams the monitors of the namespace in [startTime, endTime) sorted by time
+func (p *postgresDB) QueryMonitors(ctx context.Context, namespace string, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	rows, err := p.DB.QueryContext(ctx, fmt.Sprintf(
+		`SELECT %s FROM %s WHERE category = $1 AND time >= $2 AND time < $3 ORDER BY time`, monitorColumns, p.MonitorTable),
+		namespace, startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		monitor, err := scanMonitor(rows)
+		if err != nil {
+			return err
+		}
+		if err := handle(monitor); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %v", err)
+	}
+	return nil
+}
+
+// QueryMonitorPage returns a page of the monitors of the namespace sorted by (time, type, name),
+// the cursor is compared as a row value so the page is read from the index instead of skipped with an offset.
+func (p *postgresDB) QueryMonitorPage(ctx context.Context, query database.MonitorPageQuery) (database.MonitorPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return database.MonitorPage{}, err
+	}
+	where := []string{"category = $1", "time >= $2", "time < $3"}
+	args := []interface{}{query.Namespace, query.StartTime, query.EndTime}
+	if query.Type != nil {
+		args = append(args, int16(*query.Type))
+		where = append(where, fmt.Sprintf("type = $%d", len(args)))
+	}
+	if after := query.After; after != nil {
+		args = append(args, after.Time, int16(after.Type), after.Name)
+		where = append(where, fmt.Sprintf("(time, type, name) > ($%d, $%d, $%d)", len(args)-2, len(args)-1, len(args)))
+	}
+	args = append(args, query.Limit+1)
+	rows, err := p.DB.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY time, type, name LIMIT $%d`,
+		monitorColumns, p.MonitorTable, strings.Join(where, " AND "), len(args)), args...)
+	if err != nil {
+		return database.MonitorPage{}, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	var monitors []resources.Monitor
+	for rows.Next() {
+		monitor, err := scanMonitor(rows)
+		if err != nil {
+			return database.MonitorPage{}, err
+		}
+		monitors = append(monitors, *monitor)
+	}
+	if err := rows.Err(); err != nil {
+		return database.MonitorPage{}, fmt.Errorf("rows error: %v", err)
+	}
+	return database.NewMonitorPage(monitors, query.Limit), nil
+}
+
+func scanMonitor(rows *sql.Rows) (*resources.Monitor, error) {
+	var (
+		monitor                         resources.Monitor
+		_type                           int16
+		used                            string
+		property, utilization, objStore sql.NullString
+		tenant, labels, idempotencyKey  sql.NullString
+	)
+	if err := rows.Scan(&monitor.Time, &monitor.Category, &_type, &monitor.Name, &used, &property, &utilization, &objStore, &tenant, &labels, &idempotencyKey); err != nil {
+		return nil, fmt.Errorf("scan error: %v", err)
+	}
+	monitor.Time = monitor.Time.UTC()
+	monitor.Type = uint8(_type)
+	monitor.Property = property.String
+	monitor.IdempotencyKey = idempotencyKey.String
+	if err := json.Unmarshal([]byte(used), &monitor.Used); err != nil {
+		return nil, fmt.Errorf("decode used error: %v", err)
+	}
+	if utilization.Valid {
+		if err := json.Unmarshal([]byte(utilization.String), &monitor.Utilization); err != nil {
+			return nil, fmt.Errorf("decode utilization error: %v", err)
+		}
+	}
+	if objStore.Valid {
+		monitor.ObjStorage = &resources.ObjStorageDetail{}
+		if err := json.Unmarshal([]byte(objStore.String), monitor.ObjStorage); err != nil {
+			return nil, fmt.Errorf("decode objstorage error: %v", err)
+		}
+	}
+	if tenant.Valid {
+		if err := json.Unmarshal([]byte(tenant.String), &monitor.Tenant); err != nil {
+			return nil, fmt.Errorf("decode tenant error: %v", err)
+		}
+	}
+	if labels.Valid {
+		if err := json.Unmarshal([]byte(labels.String), &monitor.Labels); err != nil {
+			return nil, fmt.Errorf("decode labels error: %v", err)
+		}
+	}
+	return &monitor, nil
+}
+
+// GetObjectStorageUsage returns the per bucket usage of the user in [startTime, endTime) sorted by the bucket name.
+// The buckets deleted in the period are included with the monitors before the deletion.
+func (p *postgresDB) GetObjectStorageUsage(user string, startTime, endTime time.Time) ([]resources.ObjStorageBucketUsage, error) {
+	rows, err := p.DB.QueryContext(context.Background(), fmt.Sprintf(`SELECT m.name, u.key, SUM(u.value::BIGINT)::BIGINT, MIN(m.time), MAX(m.time),
+	(ARRAY_AGG(m.objstorage ORDER BY m.time DESC) FILTER (WHERE m.objstorage IS NOT NULL))[1]
+FROM %s m, JSONB_EACH_TEXT(m.used) u
+WHERE m.category = $1 AND m.type = $2 AND m.time >= $3 AND m.time < $4
+GROUP BY m.name, u.key
+ORDER BY m.name`, p.MonitorTable),
+		"ns-"+user, int16(resources.AppType[resources.ObjectStorage]), startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	var result []resources.ObjStorageBucketUsage
+	for rows.Next() {
+		var (
+			bucket, enum string
+			value        int64
+			first, last  time.Time
+			detail       sql.NullString
+		)
+		if err := rows.Scan(&bucket, &enum, &value, &first, &last, &detail); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		e, err := strconv.ParseUint(enum, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid used enum %q of bucket %s: %v", enum, bucket, err)
+		}
+		if len(result) == 0 || result[len(result)-1].Bucket != bucket {
+			result = append(result, resources.ObjStorageBucketUsage{Bucket: bucket, Used: resources.EnumUsedMap{}, FirstSeen: first.UTC(), LastSeen: last.UTC()})
+		}
+		usage := &result[len(result)-1]
+		usage.Used[uint8(e)] = value
+		if first.Before(usage.FirstSeen) {
+			usage.FirstSeen = first.UTC()
+		}
+		if last.After(usage.LastSeen) {
+			usage.LastSeen = last.UTC()
+		}
+		if detail.Valid && usage.Detail == nil {
+			usage.Detail = &resources.ObjStorageDetail{}
+			if err := json.Unmarshal([]byte(detail.String), usage.Detail); err != nil {
+				return nil, fmt.Errorf("decode objstorage error: %v", err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return result, nil
+}
+
+// CreateMonitorTimeSeriesIfNotExist creates the daily partition of the time in advance
+func (p *postgresDB) CreateMonitorTimeSeriesIfNotExist(collTime time.Time) error {
+	return p.ensurePartition(context.Background(), collTime)
+}
+
+// DropMonitorCollectionsOlderThan drops the daily partitions (or the hypertable chunks) before the cutoff day
+func (p *postgresDB) DropMonitorCollectionsOlderThan(days int) (int, error) {
+	cutoffDate := time.Now().UTC().AddDate(0, 0, -days).Truncate(24 * time.Hour)
+	if p.Timescale {
+		var dropped int
+		err := p.DB.QueryRowContext(context.Background(), `SELECT count(*) FROM drop_chunks($1::REGCLASS, older_than => $2::TIMESTAMPTZ)`,
+			p.MonitorTable, cutoffDate).Scan(&dropped)
+		return dropped, err
+	}
+	partitions, err := p.listPartitions(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	dropped := 0
+	for _, partition := range partitions {
+		if date, ok := partitionDate(p.MonitorTable, partition); ok && date.Before(cutoffDate) {
+			if _, err := p.DB.ExecContext(context.Background(), fmt.Sprintf("DROP TABLE IF EXISTS %s", partition)); err != nil {
+				return dropped, err
+			}
+			p.partitions.Delete(partition)
+			dropped++
+			logger.Info("dropped partition", "partition", partition)
+		}
+	}
+	return dropped, nil
+}
+
+func (p *postgresDB) listPartitions(ctx context.Context) ([]string, error) {
+	rows, err := p.DB.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i
+JOIN pg_class c ON c.oid = i.inhrelid
+WHERE i.inhparent = $1::REGCLASS`, p.MonitorTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the partitions of %s: %w", p.MonitorTable, err)
+	}
+	defer rows.Close()
+	var partitions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, name)
+	}
+	return partitions, rows.Err()
+}
+
+// categoryTables returns the tables keeping the monitors of a category: the monitors, the rollups and the aggregates
+func (p *postgresDB) categoryTables() []string {
+	tables := []string{p.MonitorTable, p.rollupTable()}
+	for _, granularity := range database.MonitorGranularities {
+		tables = append(tables, p.aggregateTable(granularity))
+	}
+	return tables
+}
+
+// CountMonitorsByCategory counts the monitors, the rollups and the aggregates of the category (namespace)
+func (p *postgresDB) CountMonitorsByCategory(category string) (int64, error) {
+	var total int64
+	for _, table := range p.categoryTables() {
+		var count int64
+		if err := p.DB.QueryRowContext(context.Background(), fmt.Sprintf("SELECT count(*) FROM %s WHERE category = $1", table), category).Scan(&count); err != nil {
+			return total, fmt.Errorf("failed to count monitors of %s in %s: %w", category, table, classifyError(err))
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// DeleteMonitorsByCategory deletes the monitor data of the category (namespace) from all partitions, the rollups and the aggregates
+func (p *postgresDB) DeleteMonitorsByCategory(category string) (int64, error) {
+	var total int64
+	for _, table := range p.categoryTables() {
+		deleted, err := p.deleteCategoryInBatches(context.Background(), table, category)
+		total += deleted
+		if err != nil {
+			return total, fmt.Errorf("failed to delete monitors of %s from %s: %w", category, table, classifyError(err))
+		}
+	}
+	if total > 0 {
+		logger.Info("deleted monitors", "category", category, "count", total)
+	}
+	return total, nil
+}
+
+// deleteCategoryInBatches deletes the rows of the category by batches of database.PurgeBatchSize, the rows are
+// addressed by (tableoid, ctid) since the ctid is only unique in a partition
+func (p *postgresDB) deleteCategoryInBatches(ctx context.Context, table, category string) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %[1]s WHERE (tableoid, ctid) IN (SELECT tableoid, ctid FROM %[1]s WHERE category = $1 LIMIT %[2]d)",
+		table, database.PurgeBatchSize)
+	var deleted int64
+	for {
+		result, err := p.DB.ExecContext(ctx, query, category)
+		if err != nil {
+			return deleted, err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += count
+		if count < database.PurgeBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// InitDefaultPropertyTypeLS keeps the built-in properties, the properties stored in the account database are not read
+func (p *postgresDB) InitDefaultPropertyTypeLS() error {
+	return nil
+}
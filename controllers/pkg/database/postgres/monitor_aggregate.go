@@ -0,0 +1,106 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// SaveMonitorAggregates replaces the aggregates of the period and advances the latest period in a transaction
+func (p *postgresDB) SaveMonitorAggregates(ctx context.Context, granularity database.MonitorGranularity, start time.Time, aggregates []*resources.Monitor) error {
+	if err := granularity.Validate(); err != nil {
+		return err
+	}
+	table := p.aggregateTable(granularity)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return classifyError(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE time = $1`, table), start.UTC()); err != nil {
+		return classifyError(err)
+	}
+	for i := 0; i < len(aggregates); i += maxInsertRows {
+		end := i + maxInsertRows
+		if end > len(aggregates) {
+			end = len(aggregates)
+		}
+		stmt, args, err := insertMonitorStatement(table, aggregates[i:end])
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, stmt, args...); err != nil {
+			return classifyError(err)
+		}
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %[1]s (granularity, latest, updated_at) VALUES ($1, $2, $3)
+ON CONFLICT (granularity) DO UPDATE SET latest = GREATEST(%[1]s.latest, EXCLUDED.latest), updated_at = EXCLUDED.updated_at`, p.aggregateStateTable()),
+		string(granularity), start.UTC(), time.Now().UTC()); err != nil {
+		return classifyError(err)
+	}
+	return classifyError(tx.Commit())
+}
+
+// QueryMonitorAggregates streams the aggregates of all namespaces in [startTime, endTime) sorted by time
+func (p *postgresDB) QueryMonitorAggregates(ctx context.Context, granularity database.MonitorGranularity, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	if err := granularity.Validate(); err != nil {
+		return err
+	}
+	rows, err := p.DB.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE time >= $1 AND time < $2 ORDER BY time`,
+		monitorColumns, p.aggregateTable(granularity)), startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		monitor, err := scanMonitor(rows)
+		if err != nil {
+			return err
+		}
+		if err := handle(monitor); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %v", err)
+	}
+	return nil
+}
+
+// LatestMonitorAggregate returns the start of the latest aggregated period of the granularity, zero if none
+func (p *postgresDB) LatestMonitorAggregate(ctx context.Context, granularity database.MonitorGranularity) (time.Time, error) {
+	if err := granularity.Validate(); err != nil {
+		return time.Time{}, err
+	}
+	var latest time.Time
+	err := p.DB.QueryRowContext(ctx, fmt.Sprintf(`SELECT latest FROM %s WHERE granularity = $1`, p.aggregateStateTable()),
+		string(granularity)).Scan(&latest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get the latest %s aggregate: %w", granularity, err)
+	}
+	return latest.UTC(), nil
+}
@@ -0,0 +1,97 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// QueryMonitorsInRange streams the minute monitors of all namespaces in [startTime, endTime)
+func (p *postgresDB) QueryMonitorsInRange(ctx context.Context, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	rows, err := p.DB.QueryContext(ctx, fmt.Sprintf(
+		`SELECT %s FROM %s WHERE time >= $1 AND time < $2`, monitorColumns, p.MonitorTable), startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		monitor, err := scanMonitor(rows)
+		if err != nil {
+			return err
+		}
+		if err := handle(monitor); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %v", err)
+	}
+	return nil
+}
+
+// IsMonitorRollupDone returns true if the rollups of the hour were saved
+func (p *postgresDB) IsMonitorRollupDone(ctx context.Context, hour time.Time) (bool, error) {
+	var done bool
+	err := p.DB.QueryRowContext(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE hour = $1)`, p.rollupStateTable()), hour.UTC()).Scan(&done)
+	if err != nil {
+		return false, fmt.Errorf("failed to get the rollup state of %s: %w", hour.UTC().Format(time.RFC3339), err)
+	}
+	return done, nil
+}
+
+// SaveMonitorRollups upserts the rollups of the hour and marks the hour done in a transaction
+func (p *postgresDB) SaveMonitorRollups(ctx context.Context, hour time.Time, rollups []*resources.Monitor) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return classifyError(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for start := 0; start < len(rollups); start += maxInsertRows {
+		end := start + maxInsertRows
+		if end > len(rollups) {
+			end = len(rollups)
+		}
+		stmt, args, err := insertMonitorStatement(p.rollupTable(), rollups[start:end])
+		if err != nil {
+			return err
+		}
+		stmt += ` ON CONFLICT (category, type, name, time) DO UPDATE SET used = EXCLUDED.used, property = EXCLUDED.property,
+	utilization = EXCLUDED.utilization, objstorage = EXCLUDED.objstorage, tenant = EXCLUDED.tenant, labels = EXCLUDED.labels`
+		if _, err = tx.ExecContext(ctx, stmt, args...); err != nil {
+			return classifyError(err)
+		}
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (hour, rollups, updated_at) VALUES ($1, $2, $3)
+ON CONFLICT (hour) DO UPDATE SET rollups = EXCLUDED.rollups, updated_at = EXCLUDED.updated_at`, p.rollupStateTable()),
+		hour.UTC(), len(rollups), time.Now().UTC()); err != nil {
+		return classifyError(err)
+	}
+	return classifyError(tx.Commit())
+}
+
+// DeleteMonitorsInRange deletes the minute monitors of all namespaces in [startTime, endTime)
+func (p *postgresDB) DeleteMonitorsInRange(ctx context.Context, startTime, endTime time.Time) (int64, error) {
+	result, err := p.DB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE time >= $1 AND time < $2", p.MonitorTable), startTime.UTC(), endTime.UTC())
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,48 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+)
+
+func TestPostgresDB_MonitorStoreConformance(t *testing.T) {
+	if os.Getenv("POSTGRES_URI") == "" {
+		t.Skip("POSTGRES_URI not set")
+	}
+	ctx := context.Background()
+	db, err := NewPostgresInterface(ctx, os.Getenv("POSTGRES_URI"), os.Getenv("POSTGRES_TIMESCALEDB") == "true")
+	if err != nil {
+		t.Fatalf("failed to connect postgres: error = %v", err)
+	}
+	p := db.(*postgresDB)
+	p.MonitorTable = "monitor_conformance"
+	if err = p.migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := p.DB.ExecContext(ctx, "DROP TABLE IF EXISTS monitor_conformance, monitor_conformance_rollup, monitor_conformance_rollup_state, monitor_conformance_hourly, monitor_conformance_daily, monitor_conformance_aggregate_state CASCADE"); err != nil {
+			t.Errorf("failed to drop the test table: %v", err)
+		}
+		if err = db.Disconnect(ctx); err != nil {
+			t.Errorf("failed to disconnect postgres: error = %v", err)
+		}
+	}()
+	databasetest.RunMonitorStore(t, p)
+}
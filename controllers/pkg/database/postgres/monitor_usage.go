@@ -0,0 +1,106 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+// GetNamespaceUsage returns the used resources of all monitors of the namespace in [startTime, endTime),
+// the sums use the (category, time) index of the monitors
+func (p *postgresDB) GetNamespaceUsage(category string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	return p.sumUsage(database.UsageQuery{Category: category, Start: startTime, End: endTime})
+}
+
+// GetUsageByApp returns the used resources of the app of the namespace in [startTime, endTime), appType is a key of resources.AppType.
+// The sums use the (category, type, name, time) index of the monitors.
+func (p *postgresDB) GetUsageByApp(category, appType, appName string, startTime, endTime time.Time) (map[uint8]int64, error) {
+	q, err := database.NewAppUsageQuery(category, appType, appName, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	return p.sumUsage(q)
+}
+
+func (p *postgresDB) sumUsage(q database.UsageQuery) (map[uint8]int64, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	var earliest sql.NullTime
+	if err := p.DB.QueryRowContext(ctx, fmt.Sprintf(`SELECT MIN(time) FROM %s WHERE category = $1`, p.aggregateTable(database.MonitorHourly)),
+		q.Category).Scan(&earliest); err != nil {
+		return nil, fmt.Errorf("failed to get the earliest hourly aggregate of %s: %w", q.Category, err)
+	}
+	latest, err := p.LatestMonitorAggregate(ctx, database.MonitorHourly)
+	if err != nil {
+		return nil, err
+	}
+	return database.SumUsage(ctx, q, earliest.Time.UTC(), latest, p.sumUsageSpan)
+}
+
+func (p *postgresDB) sumUsageSpan(ctx context.Context, q database.UsageQuery, span database.UsageSpan) (map[uint8]int64, error) {
+	var table string
+	switch span.Source {
+	case database.UsageMinute:
+		table = p.MonitorTable
+	case database.UsageRollup:
+		table = p.rollupTable()
+	case database.UsageHourly:
+		table = p.aggregateTable(database.MonitorHourly)
+	default:
+		return nil, fmt.Errorf("unknown usage source %s", span.Source)
+	}
+	where := `m.category = $1 AND m.time >= $2 AND m.time < $3`
+	args := []interface{}{q.Category, span.Start, span.End}
+	if q.Type != nil {
+		where += ` AND m.type = $4 AND m.name = $5`
+		args = append(args, int16(*q.Type), q.Name)
+	}
+	rows, err := p.DB.QueryContext(ctx, fmt.Sprintf(`SELECT u.key, SUM(u.value::BIGINT)::BIGINT
+FROM %s m, JSONB_EACH_TEXT(m.used) u
+WHERE %s
+GROUP BY u.key`, table, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	used := make(map[uint8]int64)
+	for rows.Next() {
+		var (
+			enum  string
+			value int64
+		)
+		if err := rows.Scan(&enum, &value); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		e, err := strconv.ParseUint(enum, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid used enum %q: %v", enum, err)
+		}
+		used[uint8(e)] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return used, nil
+}
@@ -0,0 +1,218 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	// register the pgx driver of database/sql
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+const (
+	// DefaultMonitorTable the parent table of the monitors, the daily partitions are named like monitor_20200101
+	DefaultMonitorTable = "monitor"
+
+	partitionDateLayout = "20060102"
+)
+
+// postgresDB stores the monitors in a table partitioned by day,
+// or in a timescaledb hypertable with the daily chunks if timescale is set.
+type postgresDB struct {
+	DB           *sql.DB
+	MonitorTable string
+	Timescale    bool
+
+	// partitions caches the daily partitions known to exist
+	partitions sync.Map
+}
+
+// NewPostgresInterface connects to postgres and migrates the monitor schema
+func NewPostgresInterface(ctx context.Context, uri string, timescale bool) (database.MonitorStore, error) {
+	if uri == "" {
+		return nil, fmt.Errorf("postgres dsn is empty, please check env: %s", database.MonitorDBDSN)
+	}
+	db, err := sql.Open("pgx", uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %w", err)
+	}
+	p := &postgresDB{DB: db, MonitorTable: DefaultMonitorTable, Timescale: timescale}
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to ping postgres: %w", err)
+	}
+	if err = p.migrate(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// migrate creates the monitor table and the indexes if not exist, the statements are idempotent
+func (p *postgresDB) migrate(ctx context.Context) error {
+	var statements []string
+	if p.Timescale {
+		// requires the timescaledb library preloaded by the server, the extension is created in the database if not yet
+		statements = append(statements, `CREATE EXTENSION IF NOT EXISTS timescaledb`)
+	}
+	statements = append(statements, monitorTableDDL(p.MonitorTable, p.Timescale))
+	if p.Timescale {
+		statements = append(statements, fmt.Sprintf(
+			`SELECT create_hypertable('%s', 'time', chunk_time_interval => INTERVAL '1 day', if_not_exists => TRUE)`, p.MonitorTable))
+	}
+	statements = append(statements,
+		// the columns added after the table was created
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS tenant JSONB`, p.MonitorTable),
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS idempotency_key TEXT`, p.MonitorTable),
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS monitor_id TEXT`, p.MonitorTable),
+		// the unique index of the partitioned table must include the partition key, the rows inserted before the ids are null
+		fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %[1]s_monitor_id_idx ON %[1]s (monitor_id, time)`, p.MonitorTable),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_category_time_idx ON %[1]s (category, time)`, p.MonitorTable),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_objstorage_idx ON %[1]s (category, type, name, time)`, p.MonitorTable),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_idempotency_key_idx ON %[1]s (idempotency_key) WHERE idempotency_key IS NOT NULL`, p.MonitorTable),
+		// the hourly rollups are kept out of the daily partitions, so the retention doesn't drop them
+		monitorRollupTableDDL(p.rollupTable()),
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS idempotency_key TEXT`, p.rollupTable()),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	hour       TIMESTAMPTZ PRIMARY KEY,
+	rollups    INTEGER     NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+)`, p.rollupStateTable()),
+		// the hourly and daily aggregates keep the minute monitors, they are kept out of the partitions as well
+		monitorRollupTableDDL(p.aggregateTable(database.MonitorHourly)),
+		monitorRollupTableDDL(p.aggregateTable(database.MonitorDaily)),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	granularity TEXT        PRIMARY KEY,
+	latest      TIMESTAMPTZ NOT NULL,
+	updated_at  TIMESTAMPTZ NOT NULL
+)`, p.aggregateStateTable()),
+	)
+	for _, table := range []string{p.MonitorTable, p.rollupTable(), p.aggregateTable(database.MonitorHourly), p.aggregateTable(database.MonitorDaily)} {
+		statements = append(statements, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS labels JSONB`, table))
+	}
+	for _, stmt := range statements {
+		if _, err := p.DB.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to migrate the monitor schema: %w, statement: %s", err, stmt)
+		}
+	}
+	return nil
+}
+
+// monitorTableDDL the monitor table, the used resources are a json object keyed by the property enum like the mongo documents
+func monitorTableDDL(table string, timescale bool) string {
+	partition := " PARTITION BY RANGE (time)"
+	if timescale {
+		partition = ""
+	}
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	time        TIMESTAMPTZ NOT NULL,
+	category    TEXT        NOT NULL,
+	type        SMALLINT    NOT NULL,
+	name        TEXT        NOT NULL,
+	used        JSONB       NOT NULL,
+	property    TEXT,
+	utilization JSONB,
+	objstorage  JSONB,
+	tenant      JSONB,
+	labels      JSONB,
+	idempotency_key TEXT,
+	monitor_id  TEXT
+)%s`, table, partition)
+}
+
+// monitorRollupTableDDL the hourly rollups with the columns of the monitors, unique by the monitor and the hour
+func monitorRollupTableDDL(table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	time        TIMESTAMPTZ NOT NULL,
+	category    TEXT        NOT NULL,
+	type        SMALLINT    NOT NULL,
+	name        TEXT        NOT NULL,
+	used        JSONB       NOT NULL,
+	property    TEXT,
+	utilization JSONB,
+	objstorage  JSONB,
+	tenant      JSONB,
+	labels      JSONB,
+	idempotency_key TEXT,
+	PRIMARY KEY (category, type, name, time)
+)`, table)
+}
+
+func (p *postgresDB) rollupTable() string {
+	return p.MonitorTable + "_rollup"
+}
+
+func (p *postgresDB) rollupStateTable() string {
+	return p.MonitorTable + "_rollup_state"
+}
+
+// aggregateTable returns the table of the granularity, eg: monitor_hourly
+func (p *postgresDB) aggregateTable(granularity database.MonitorGranularity) string {
+	return p.MonitorTable + "_" + string(granularity)
+}
+
+func (p *postgresDB) aggregateStateTable() string {
+	return p.MonitorTable + "_aggregate_state"
+}
+
+// partitionName returns the daily partition of the time, eg: monitor_20200101
+func partitionName(table string, t time.Time) string {
+	return table + "_" + t.UTC().Format(partitionDateLayout)
+}
+
+// partitionDate parses the day of the daily partition, false if the name is not a partition of the table
+func partitionDate(table, name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, table+"_")
+	if !ok || len(suffix) != len(partitionDateLayout) {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(partitionDateLayout, suffix)
+	return date, err == nil
+}
+
+// ensurePartition creates the daily partition of the time if not exists, the hypertable creates the chunks itself
+func (p *postgresDB) ensurePartition(ctx context.Context, t time.Time) error {
+	if p.Timescale {
+		return nil
+	}
+	name := partitionName(p.MonitorTable, t)
+	if _, ok := p.partitions.Load(name); ok {
+		return nil
+	}
+	day := t.UTC().Truncate(24 * time.Hour)
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')`,
+		name, p.MonitorTable, day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339))
+	if _, err := p.DB.ExecContext(ctx, stmt); err != nil {
+		return classifyError(fmt.Errorf("failed to create partition %s: %w", name, err))
+	}
+	p.partitions.Store(name, struct{}{})
+	return nil
+}
+
+func (p *postgresDB) Disconnect(_ context.Context) error {
+	return p.DB.Close()
+}
+
+// Ping checks a pooled connection, the broken connections are dropped by the pool
+func (p *postgresDB) Ping(ctx context.Context) error {
+	return p.DB.PingContext(ctx)
+}
@@ -0,0 +1,174 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stdout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// stdoutDB writes the monitors as JSON lines for the local debugging without a database, eg: against a kind cluster.
+// The monitors of a write are encoded together and written at once, so the lines follow the batches of the writer and
+// the batches of the concurrent writes are not interleaved. Nothing is kept: the reads return no monitors, and the
+// rollups, the aggregates and the deletes do nothing.
+type stdoutDB struct {
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+var _ database.MonitorStore = &stdoutDB{}
+
+// NewStdoutInterface writes the monitors to stdout, or appends them to the file at the path if not empty
+func NewStdoutInterface(path string) (database.MonitorStore, error) {
+	if path == "" {
+		return NewWriterInterface(os.Stdout), nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the monitor output: %w", err)
+	}
+	return &stdoutDB{out: file, closer: file}, nil
+}
+
+// NewWriterInterface writes the monitors to out
+func NewWriterInterface(out io.Writer) database.MonitorStore {
+	return &stdoutDB{out: out}
+}
+
+// write encodes the monitors with their MonitorID as the lines of a single write
+func (s *stdoutDB) write(monitors []*resources.Monitor) error {
+	if len(monitors) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, monitor := range database.UniqueMonitors(monitors) {
+		if err := encoder.Encode(monitor); err != nil {
+			return fmt.Errorf("failed to encode the monitor: %w", err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.out.Write(buf.Bytes())
+	return err
+}
+
+func (s *stdoutDB) InsertMonitor(_ context.Context, monitors ...*resources.Monitor) error {
+	return s.write(monitors)
+}
+
+func (s *stdoutDB) InsertMonitorBatch(_ context.Context, monitors []*resources.Monitor) error {
+	return s.write(monitors)
+}
+
+// ReplaceMonitors writes the monitors again, the replaced lines are not removed
+func (s *stdoutDB) ReplaceMonitors(_ context.Context, monitors ...*resources.Monitor) error {
+	return s.write(monitors)
+}
+
+func (s *stdoutDB) GetDistinctMonitorCombinations(_, _ time.Time, _ string) ([]resources.Monitor, error) {
+	return nil, nil
+}
+
+func (s *stdoutDB) QueryMonitors(_ context.Context, _ string, _, _ time.Time, _ func(monitor *resources.Monitor) error) error {
+	return nil
+}
+
+func (s *stdoutDB) QueryMonitorPage(_ context.Context, query database.MonitorPageQuery) (database.MonitorPage, error) {
+	if _, err := query.Normalize(); err != nil {
+		return database.MonitorPage{}, err
+	}
+	return database.MonitorPage{Monitors: []resources.Monitor{}}, nil
+}
+
+func (s *stdoutDB) GetObjectStorageUsage(_ string, _, _ time.Time) ([]resources.ObjStorageBucketUsage, error) {
+	return nil, nil
+}
+
+func (s *stdoutDB) DropMonitorCollectionsOlderThan(_ int) (int, error) {
+	return 0, nil
+}
+
+func (s *stdoutDB) CountMonitorsByCategory(_ string) (int64, error) {
+	return 0, nil
+}
+
+func (s *stdoutDB) DeleteMonitorsByCategory(_ string) (int64, error) {
+	return 0, nil
+}
+
+func (s *stdoutDB) QueryMonitorsInRange(_ context.Context, _, _ time.Time, _ func(monitor *resources.Monitor) error) error {
+	return nil
+}
+
+func (s *stdoutDB) IsMonitorRollupDone(_ context.Context, _ time.Time) (bool, error) {
+	return false, nil
+}
+
+func (s *stdoutDB) SaveMonitorRollups(_ context.Context, _ time.Time, _ []*resources.Monitor) error {
+	return nil
+}
+
+func (s *stdoutDB) DeleteMonitorsInRange(_ context.Context, _, _ time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (s *stdoutDB) SaveMonitorAggregates(_ context.Context, granularity database.MonitorGranularity, _ time.Time, _ []*resources.Monitor) error {
+	return granularity.Validate()
+}
+
+func (s *stdoutDB) QueryMonitorAggregates(_ context.Context, granularity database.MonitorGranularity, _, _ time.Time, _ func(monitor *resources.Monitor) error) error {
+	return granularity.Validate()
+}
+
+func (s *stdoutDB) LatestMonitorAggregate(_ context.Context, granularity database.MonitorGranularity) (time.Time, error) {
+	return time.Time{}, granularity.Validate()
+}
+
+func (s *stdoutDB) GetNamespaceUsage(_ string, _, _ time.Time) (map[uint8]int64, error) {
+	return map[uint8]int64{}, nil
+}
+
+func (s *stdoutDB) GetUsageByApp(_, _, _ string, _, _ time.Time) (map[uint8]int64, error) {
+	return map[uint8]int64{}, nil
+}
+
+func (s *stdoutDB) CreateMonitorTimeSeriesIfNotExist(_ time.Time) error {
+	return nil
+}
+
+func (s *stdoutDB) InitDefaultPropertyTypeLS() error {
+	return nil
+}
+
+// Disconnect closes the file of the output, stdout is kept open
+func (s *stdoutDB) Disconnect(_ context.Context) error {
+	if s.closer == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closer.Close()
+}
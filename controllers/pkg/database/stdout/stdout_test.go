@@ -0,0 +1,89 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stdout
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestStdoutDB_file(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "monitors.jsonl")
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	monitor := func(name string) *resources.Monitor {
+		return &resources.Monitor{Time: now, Category: "ns-a", Name: name, Used: resources.EnumUsedMap{0: 1000}}
+	}
+	store, err := NewStdoutInterface(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the duplicates of a batch are written once
+	if err := store.InsertMonitorBatch(ctx, []*resources.Monitor{monitor("app-a"), monitor("app-b"), monitor("app-a")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertMonitor(ctx, monitor("app-c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// a restarted controller appends to the file
+	if store, err = NewStdoutInterface(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertMonitor(ctx, monitor("app-d")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var got resources.Monitor
+		if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+			t.Fatalf("invalid json line %q: %v", scanner.Text(), err)
+		}
+		if got.MonitorID == "" || !got.Time.Equal(now) || got.Used[0] != 1000 {
+			t.Errorf("line = %+v, want the monitor with its id", got)
+		}
+		names = append(names, got.Name)
+	}
+	if want := []string{"app-a", "app-b", "app-c", "app-d"}; len(names) != len(want) || names[0] != want[0] || names[3] != want[3] {
+		t.Errorf("written monitors = %v, want %v", names, want)
+	}
+
+	var count int
+	if err := store.QueryMonitors(ctx, "ns-a", now, now.Add(time.Minute), func(*resources.Monitor) error {
+		count++
+		return nil
+	}); err != nil || count != 0 {
+		t.Errorf("QueryMonitors() = %d monitors, %v, want nothing kept", count, err)
+	}
+}
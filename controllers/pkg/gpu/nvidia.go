@@ -59,6 +59,10 @@ type NvidiaGPU struct {
 	GpuDeploy  Deployment
 	GpuDetails DetailInformation
 	MigInfo    MigInformation
+	// Labels all labels of the node
+	Labels map[string]string
+	// Capacity the gpus advertised by the device plugin, the physical gpus times the replicas if the gpus are time-sliced
+	Capacity int64
 }
 
 type Information struct {
@@ -104,7 +108,9 @@ type MigInformation struct {
 
 //nvidia.com/gpu
 
-func GetNodeGpuModel(c client.Client) (map[string]NvidiaGPU, error) {
+// GetNodeGpuModel returns the gpu of the nodes keyed by the node name, the nodes without gpu are skipped.
+// A cluster without gpu nodes returns an empty map, only the errors of listing the nodes are returned.
+func GetNodeGpuModel(c client.Reader) (map[string]NvidiaGPU, error) {
 	nodeList := &corev1.NodeList{}
 	err := c.List(context.Background(), nodeList)
 	if err != nil {
@@ -112,7 +118,11 @@ func GetNodeGpuModel(c client.Client) (map[string]NvidiaGPU, error) {
 	}
 
 	gpuModels := make(map[string]NvidiaGPU)
-	for _, node := range nodeList.Items {
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if !IsGpuNode(node) {
+			continue
+		}
 		gpu := NvidiaGPU{
 			GpuInfo: Information{
 				Gpu:         node.Labels[NvidiaGpuKey],
@@ -129,12 +139,25 @@ func GetNodeGpuModel(c client.Client) (map[string]NvidiaGPU, error) {
 				CudaRuntimeMinor: node.Labels[NvidiaCudaRuntimeMinorKey],
 			},
 			// fill in the rest similarly...
+			Labels: node.Labels,
+		}
+		if capacity, ok := node.Status.Capacity[NvidiaGpuKey]; ok {
+			gpu.Capacity = capacity.Value()
 		}
 		gpuModels[node.Name] = gpu
 	}
 	return gpuModels, nil
 }
 
+// IsGpuNode returns true if the node is labeled by the gpu feature discovery or allocates nvidia gpu
+func IsGpuNode(node *corev1.Node) bool {
+	if node.Labels[NvidiaGpuProductKey] != "" || node.Labels[NvidiaGpuPresentKey] == "true" {
+		return true
+	}
+	allocatable, ok := node.Status.Allocatable[NvidiaGpuKey]
+	return ok && !allocatable.IsZero()
+}
+
 const (
 	Alias                      = "alias"
 	NodeInfoConfigmapNamespace = "node-system"
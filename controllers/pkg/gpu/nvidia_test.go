@@ -0,0 +1,74 @@
+// Copyright © 2023 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gpu
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// nodeReader lists the nodes or fails with err
+type nodeReader struct {
+	client.Reader
+	nodes []corev1.Node
+	err   error
+}
+
+func (r *nodeReader) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	if r.err != nil {
+		return r.err
+	}
+	list.(*corev1.NodeList).Items = r.nodes
+	return nil
+}
+
+func TestGetNodeGpuModel(t *testing.T) {
+	cpuNode := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cpu", Labels: map[string]string{"kubernetes.io/os": "linux"}}}
+	labeledNode := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "labeled", Labels: map[string]string{NvidiaGpuProductKey: "Tesla-T4"}}}
+	allocatableNode := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "allocatable"},
+		Status:     corev1.NodeStatus{Allocatable: corev1.ResourceList{NvidiaGpuKey: resource.MustParse("1")}},
+	}
+
+	// a cpu only cluster is not an error
+	models, err := GetNodeGpuModel(&nodeReader{nodes: []corev1.Node{cpuNode}})
+	if err != nil || len(models) != 0 {
+		t.Errorf("GetNodeGpuModel() of the cpu only cluster = %v, %v, want empty, nil", models, err)
+	}
+
+	models, err = GetNodeGpuModel(&nodeReader{nodes: []corev1.Node{cpuNode, labeledNode, allocatableNode}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 {
+		t.Errorf("GetNodeGpuModel() = %d nodes, want 2", len(models))
+	}
+	if got := models["labeled"].GpuInfo.GpuProduct; got != "Tesla-T4" {
+		t.Errorf("gpu product of the labeled node = %q, want Tesla-T4", got)
+	}
+	if _, ok := models["allocatable"]; !ok {
+		t.Error("the node allocating nvidia gpu without the gpu labels is missing")
+	}
+
+	if _, err = GetNodeGpuModel(&nodeReader{err: errors.New("forbidden")}); err == nil {
+		t.Error("GetNodeGpuModel() expected the list error")
+	}
+}
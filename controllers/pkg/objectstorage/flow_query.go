@@ -0,0 +1,275 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstorage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// FlowQueryPresetMinioV2 the bucket traffic metrics of the minio v2 metrics api, labeled by instance
+	FlowQueryPresetMinioV2 = "minio-v2"
+	// FlowQueryPresetMinioV3 the bucket api traffic metrics of the minio v3 metrics api, labeled by server
+	FlowQueryPresetMinioV3 = "minio-v3"
+)
+
+// FlowQuery the prometheus query templates of the object storage flow,
+// the templates are rendered with the placeholders {{.Bucket}}, {{.Instance}}, {{.Window}}, {{.Step}} and {{.Matchers}}.
+// Received and Sent must return a vector with at most one sample,
+// Probe (optional) is rendered without {{.Bucket}} and must return a non-empty vector if the metrics exist.
+type FlowQuery struct {
+	Received string
+	Sent     string
+	Probe    string
+	// Window the metering window rendered as {{.Window}}, eg: increase(m[{{.Window}}:{{.Step}}])
+	Window time.Duration
+	// Step the resolution of the subqueries rendered as {{.Step}}, empty if 0 so the evaluation interval of prometheus is used
+	Step time.Duration
+	// InstanceLabel and InstanceMetric discover the {{.Instance}} by the values of the label of the metric
+	InstanceLabel  string
+	InstanceMetric string
+	// Matchers the extra label matchers rendered as {{.Matchers}} inside the selectors, eg: the external label cluster
+	// of a federated prometheus scraping the object storage of several clusters
+	Matchers map[string]string
+}
+
+// MaxFlowQueryPoints bounds the points of a window, the same limit as the prometheus range queries
+const MaxFlowQueryPoints = 11000
+
+var FlowQueryPresets = map[string]FlowQuery{
+	FlowQueryPresetMinioV2: {
+		Received: `sum(minio_bucket_traffic_received_bytes{bucket="{{.Bucket}}", instance="{{.Instance}}"{{.Matchers}}})`,
+		Sent:     `sum(minio_bucket_traffic_sent_bytes{bucket="{{.Bucket}}", instance="{{.Instance}}"{{.Matchers}}})`,
+		Probe:    `count(minio_bucket_traffic_received_bytes{instance="{{.Instance}}"{{.Matchers}}})`,
+
+		InstanceLabel:  "instance",
+		InstanceMetric: "minio_bucket_traffic_received_bytes",
+	},
+	FlowQueryPresetMinioV3: {
+		Received: `sum(minio_bucket_api_traffic_received_bytes{bucket="{{.Bucket}}", server="{{.Instance}}"{{.Matchers}}})`,
+		Sent:     `sum(minio_bucket_api_traffic_sent_bytes{bucket="{{.Bucket}}", server="{{.Instance}}"{{.Matchers}}})`,
+		Probe:    `count(minio_bucket_api_traffic_received_bytes{server="{{.Instance}}"{{.Matchers}}})`,
+
+		InstanceLabel:  "server",
+		InstanceMetric: "minio_bucket_api_traffic_received_bytes",
+	},
+}
+
+// DefaultFlowQuery the query used before the templates were configurable
+var DefaultFlowQuery = FlowQueryPresets[FlowQueryPresetMinioV2]
+
+type flowQueryData struct {
+	Bucket   string
+	Instance string
+	Window   string
+	Step     string
+	// Matchers the matchers with a leading comma, eg: `, cluster="a"`, empty without matchers
+	Matchers string
+}
+
+// NewFlowQuery returns the preset (default minio-v2) with the non-empty templates overridden
+func NewFlowQuery(preset, received, sent, probe string) (FlowQuery, error) {
+	if preset == "" {
+		preset = FlowQueryPresetMinioV2
+	}
+	query, ok := FlowQueryPresets[preset]
+	if !ok {
+		return FlowQuery{}, fmt.Errorf("unknown flow query preset %q, must be one of: %s, %s", preset, FlowQueryPresetMinioV2, FlowQueryPresetMinioV3)
+	}
+	if received != "" {
+		query.Received = received
+	}
+	if sent != "" {
+		query.Sent = sent
+	}
+	if probe != "" {
+		query.Probe = probe
+	}
+	for _, tmpl := range []string{query.Received, query.Sent, query.Probe} {
+		if _, err := template.New("flow").Parse(tmpl); err != nil {
+			return FlowQuery{}, fmt.Errorf("invalid flow query template %q: %w", tmpl, err)
+		}
+	}
+	return query, nil
+}
+
+// WithRange returns the query with the window and the step, the step must divide the window
+// into at most MaxFlowQueryPoints points
+func (q FlowQuery) WithRange(window, step time.Duration) (FlowQuery, error) {
+	switch {
+	case window <= 0 || window%time.Second != 0:
+		return FlowQuery{}, fmt.Errorf("invalid flow query window %s, must be whole seconds", window)
+	case step < 0 || step%time.Second != 0:
+		return FlowQuery{}, fmt.Errorf("invalid flow query step %s, must be whole seconds", step)
+	case step > window || (step > 0 && window%step != 0):
+		return FlowQuery{}, fmt.Errorf("flow query step %s must divide the window %s", step, window)
+	case step > 0 && window/step > MaxFlowQueryPoints:
+		return FlowQuery{}, fmt.Errorf("flow query step %s is too small for the window %s, at most %d points", step, window, MaxFlowQueryPoints)
+	}
+	q.Window, q.Step = window, step
+	return q, nil
+}
+
+func (q FlowQuery) data(bucket, instance string) flowQueryData {
+	data := flowQueryData{Bucket: bucket, Instance: instance}
+	if q.Window > 0 {
+		data.Window = model.Duration(q.Window).String()
+	}
+	if q.Step > 0 {
+		data.Step = model.Duration(q.Step).String()
+	}
+	for _, name := range sortedMatcherNames(q.Matchers) {
+		data.Matchers += ", " + name + "=" + strconv.Quote(q.Matchers[name])
+	}
+	return data
+}
+
+// WithMatchers returns the query scoped by the equality label matchers, the templates must render {{.Matchers}}
+// inside their selectors, otherwise the query of a federated prometheus would sum the traffic of all the clusters
+func (q FlowQuery) WithMatchers(matchers map[string]string) (FlowQuery, error) {
+	if len(matchers) == 0 {
+		return q, nil
+	}
+	for name := range matchers {
+		if !model.LabelName(name).IsValid() {
+			return FlowQuery{}, fmt.Errorf("invalid flow query label matcher name %q", name)
+		}
+	}
+	for _, tmpl := range []string{q.Received, q.Sent, q.Probe} {
+		if tmpl != "" && !strings.Contains(tmpl, "{{.Matchers}}") {
+			return FlowQuery{}, fmt.Errorf("flow query template %q must render {{.Matchers}} to be scoped by the label matchers", tmpl)
+		}
+	}
+	q.Matchers = matchers
+	return q, nil
+}
+
+// ParseLabelMatchers parses the comma separated equality matchers, eg: cluster=hz-1,region="cn-east",
+// the values may be quoted
+func ParseLabelMatchers(s string) (map[string]string, error) {
+	matchers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label matcher %q, want name=value", pair)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid label matcher %q: %w", pair, err)
+			}
+			value = unquoted
+		}
+		if !model.LabelName(name).IsValid() {
+			return nil, fmt.Errorf("invalid label matcher %q, invalid label name %q", pair, name)
+		}
+		matchers[name] = value
+	}
+	return matchers, nil
+}
+
+func sortedMatcherNames(matchers map[string]string) []string {
+	names := make([]string, 0, len(matchers))
+	for name := range matchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render renders the received and sent queries of the bucket
+func (q FlowQuery) Render(bucket, instance string) (received, sent string, err error) {
+	data := q.data(bucket, instance)
+	if received, err = renderFlowQuery(q.Received, data); err != nil {
+		return "", "", err
+	}
+	if sent, err = renderFlowQuery(q.Sent, data); err != nil {
+		return "", "", err
+	}
+	return received, sent, nil
+}
+
+func renderFlowQuery(tmpl string, data flowQueryData) (string, error) {
+	t, err := template.New("flow").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid flow query template %q: %w", tmpl, err)
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to render flow query template %q: %w", tmpl, err)
+	}
+	return sb.String(), nil
+}
+
+// parseFlowResult parses the bytes of the flow query result, an empty vector means no traffic
+func parseFlowResult(value model.Value) (int64, error) {
+	if value == nil {
+		return 0, errors.New("empty prometheus result")
+	}
+	switch v := value.(type) {
+	case model.Vector:
+		switch len(v) {
+		case 0:
+			return 0, nil
+		case 1:
+			return sampleBytes(v[0].Value)
+		}
+		return 0, fmt.Errorf("unexpected prometheus result: %d samples, the query must be aggregated to one sample", len(v))
+	case *model.Scalar:
+		return sampleBytes(v.Value)
+	}
+	return 0, fmt.Errorf("unexpected prometheus result type %s, want vector", value.Type())
+}
+
+// sampleBytes converts the sample to bytes, the values out of the int64 range (eg: +Inf) are saturated
+// instead of converted to an undefined value, the negative values are returned as is for the caller to guard.
+func sampleBytes(value model.SampleValue) (int64, error) {
+	v := float64(value)
+	switch {
+	case math.IsNaN(v):
+		return 0, errors.New("the sample is not a number")
+	case v >= math.MaxInt64:
+		return math.MaxInt64, nil
+	case v <= math.MinInt64:
+		return math.MinInt64, nil
+	}
+	return int64(v), nil
+}
+
+// addBytes adds the bytes saturated to the int64 range
+func addBytes(a, b int64) int64 {
+	sum := a + b
+	switch {
+	case a > 0 && b > 0 && sum < 0:
+		return math.MaxInt64
+	case a < 0 && b < 0 && sum >= 0:
+		return math.MinInt64
+	}
+	return sum
+}
@@ -0,0 +1,355 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstorage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+func TestFlowQuery_Render(t *testing.T) {
+	tests := []struct {
+		preset       string
+		wantReceived string
+		wantSent     string
+	}{
+		{
+			preset:       FlowQueryPresetMinioV2,
+			wantReceived: `sum(minio_bucket_traffic_received_bytes{bucket="ns-a-data", instance="minio:9000"})`,
+			wantSent:     `sum(minio_bucket_traffic_sent_bytes{bucket="ns-a-data", instance="minio:9000"})`,
+		},
+		{
+			preset:       FlowQueryPresetMinioV3,
+			wantReceived: `sum(minio_bucket_api_traffic_received_bytes{bucket="ns-a-data", server="minio:9000"})`,
+			wantSent:     `sum(minio_bucket_api_traffic_sent_bytes{bucket="ns-a-data", server="minio:9000"})`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			query, err := NewFlowQuery(tt.preset, "", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			received, sent, err := query.Render("ns-a-data", "minio:9000")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if received != tt.wantReceived {
+				t.Errorf("received query = %s, want %s", received, tt.wantReceived)
+			}
+			if sent != tt.wantSent {
+				t.Errorf("sent query = %s, want %s", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestNewFlowQuery(t *testing.T) {
+	if _, err := NewFlowQuery("unknown", "", "", ""); err == nil {
+		t.Error("NewFlowQuery() with unknown preset, want error")
+	}
+	if _, err := NewFlowQuery("", "sum(x{bucket=\"{{.Bucket}\"})", "", ""); err == nil {
+		t.Error("NewFlowQuery() with invalid template, want error")
+	}
+	query, err := NewFlowQuery("", `sum(custom_rx{b="{{.Bucket}}"})`, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Sent != DefaultFlowQuery.Sent {
+		t.Errorf("sent query = %s, want the preset %s", query.Sent, DefaultFlowQuery.Sent)
+	}
+	if received, _, _ := query.Render("b1", ""); received != `sum(custom_rx{b="b1"})` {
+		t.Errorf("received query = %s, want the override", received)
+	}
+}
+
+func TestFlowQuery_WithMatchers(t *testing.T) {
+	matchers, err := ParseLabelMatchers(` cluster=hz-1, region="cn \"east\"",`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := DefaultFlowQuery.WithMatchers(matchers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, _, err := query.Render("ns-a-data", "minio:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `sum(minio_bucket_traffic_received_bytes{bucket="ns-a-data", instance="minio:9000", cluster="hz-1", region="cn \"east\""})`; received != want {
+		t.Errorf("received query = %s, want %s", received, want)
+	}
+	if probe, _ := renderFlowQuery(query.Probe, query.data("", "minio:9000")); probe != `count(minio_bucket_traffic_received_bytes{instance="minio:9000", cluster="hz-1", region="cn \"east\""})` {
+		t.Errorf("probe query = %s, want scoped by the matchers", probe)
+	}
+
+	for _, s := range []string{"cluster", "1cluster=a", `cluster="a`} {
+		if _, err := ParseLabelMatchers(s); err == nil {
+			t.Errorf("ParseLabelMatchers(%s) want error", s)
+		}
+	}
+	custom, err := NewFlowQuery("", `sum(custom_rx{b="{{.Bucket}}"})`, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := custom.WithMatchers(matchers); err == nil {
+		t.Error("WithMatchers() with a template not rendering the matchers, want error")
+	}
+	if _, err := custom.WithMatchers(nil); err != nil {
+		t.Errorf("WithMatchers() without matchers error = %v", err)
+	}
+}
+
+func TestFlowQuery_WithRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  time.Duration
+		step    time.Duration
+		wantErr bool
+	}{
+		{name: "no step", window: time.Minute},
+		{name: "step divides window", window: time.Hour, step: 30 * time.Second},
+		{name: "step equals window", window: time.Minute, step: time.Minute},
+		{name: "no window", window: 0, wantErr: true},
+		{name: "sub-second window", window: 1500 * time.Millisecond, wantErr: true},
+		{name: "sub-second step", window: time.Minute, step: 500 * time.Millisecond, wantErr: true},
+		{name: "negative step", window: time.Minute, step: -time.Second, wantErr: true},
+		{name: "step over window", window: time.Minute, step: 2 * time.Minute, wantErr: true},
+		{name: "step not dividing window", window: time.Minute, step: 7 * time.Second, wantErr: true},
+		{name: "too many points", window: 24 * time.Hour, step: time.Second, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DefaultFlowQuery.WithRange(tt.window, tt.step)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("WithRange(%s, %s) err = %v, wantErr %v", tt.window, tt.step, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFlowQuery_RenderRange(t *testing.T) {
+	query, err := NewFlowQuery("", `sum(increase(rx{bucket="{{.Bucket}}"}[{{.Window}}:{{.Step}}]))`, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received, _, _ := query.Render("b1", ""); received != `sum(increase(rx{bucket="b1"}[:]))` {
+		t.Errorf("received query without range = %s", received)
+	}
+	if query, err = query.WithRange(2*time.Hour, 90*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if received, _, _ := query.Render("b1", ""); received != `sum(increase(rx{bucket="b1"}[2h:1m30s]))` {
+		t.Errorf("received query = %s, want the window 2h and the step 1m30s", received)
+	}
+}
+
+func TestParseFlowResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   model.Value
+		want    int64
+		wantErr bool
+	}{
+		{name: "one sample", value: model.Vector{{Value: 1024}}, want: 1024},
+		{name: "no traffic", value: model.Vector{}, want: 0},
+		{name: "not aggregated", value: model.Vector{{Value: 1}, {Value: 2}}, wantErr: true},
+		{name: "scalar", value: &model.Scalar{Value: 7}, want: 7},
+		{name: "matrix", value: model.Matrix{}, wantErr: true},
+		{name: "nil", value: nil, wantErr: true},
+		{name: "counter reset", value: model.Vector{{Value: -512}}, want: -512},
+		{name: "overflow", value: model.Vector{{Value: 1e30}}, want: math.MaxInt64},
+		{name: "infinity", value: &model.Scalar{Value: model.SampleValue(math.Inf(-1))}, want: math.MinInt64},
+		{name: "not a number", value: model.Vector{{Value: model.SampleValue(math.NaN())}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFlowResult(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFlowResult() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseFlowResult() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddBytes(t *testing.T) {
+	tests := []struct {
+		a, b, want int64
+	}{
+		{a: 1024, b: 2048, want: 3072},
+		{a: -512, b: 2048, want: 1536},
+		{a: math.MaxInt64, b: 1, want: math.MaxInt64},
+		{a: math.MinInt64, b: -1, want: math.MinInt64},
+	}
+	for _, tt := range tests {
+		if got := addBytes(tt.a, tt.b); got != tt.want {
+			t.Errorf("addBytes(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// newFakePrometheus answers the instant queries by the metric name in the query
+func newFakePrometheus(t *testing.T, results map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		query := req.Form.Get("query")
+		result := `{"resultType":"vector","result":[]}`
+		for metric, r := range results {
+			if strings.Contains(query, metric+"{") {
+				result = r
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":%s}`, result)
+	}))
+}
+
+func vectorResult(value string) string {
+	return fmt.Sprintf(`{"resultType":"vector","result":[{"metric":{},"value":[1700000000,%q]}]}`, value)
+}
+
+func TestQueryPrometheusFlow(t *testing.T) {
+	tests := []struct {
+		preset   string
+		received string
+		sent     string
+	}{
+		{preset: FlowQueryPresetMinioV2, received: "minio_bucket_traffic_received_bytes", sent: "minio_bucket_traffic_sent_bytes"},
+		{preset: FlowQueryPresetMinioV3, received: "minio_bucket_api_traffic_received_bytes", sent: "minio_bucket_api_traffic_sent_bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			prom := newFakePrometheus(t, map[string]string{tt.received: vectorResult("100"), tt.sent: vectorResult("23")})
+			defer prom.Close()
+			query := FlowQueryPresets[tt.preset]
+			flow, err := QueryPrometheusFlow(prom.URL, query, "ns-a-data", "minio:9000")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if flow != 123 {
+				t.Errorf("QueryPrometheusFlow() = %v, want 123", flow)
+			}
+			if err := ValidateFlowQuery(prom.URL, query, "minio:9000"); err != nil {
+				t.Errorf("ValidateFlowQuery() err = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestQueryPrometheusFlow_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		transient bool
+		config    bool
+	}{
+		{name: "forbidden by the auth proxy", status: http.StatusForbidden, body: "forbidden", config: true},
+		{name: "unauthorized", status: http.StatusUnauthorized, body: "unauthorized", config: true},
+		{name: "bad query", status: http.StatusBadRequest, body: `{"status":"error","errorType":"bad_data","error":"parse error"}`},
+		{name: "server error", status: http.StatusInternalServerError, body: "internal error", transient: true},
+		{name: "unavailable", status: http.StatusServiceUnavailable, body: `{"status":"error","errorType":"unavailable","error":"not ready"}`, transient: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer prom.Close()
+			_, err := QueryPrometheusFlow(prom.URL, FlowQueryPresets[FlowQueryPresetMinioV2], "ns-a-data", "minio:9000")
+			if err == nil {
+				t.Fatal("QueryPrometheusFlow() expected error")
+			}
+			if got := retry.IsTransient(err); got != tt.transient {
+				t.Errorf("IsTransient(%v) = %v, want %v", err, got, tt.transient)
+			}
+			if got := errors.Is(err, retry.ErrConfig); got != tt.config {
+				t.Errorf("config error %v = %v, want %v", err, got, tt.config)
+			}
+			if !tt.transient && !retry.IsPermanent(err) {
+				t.Errorf("IsPermanent(%v) = false, want true", err)
+			}
+		})
+	}
+}
+
+func TestQueryPrometheusFlow_Step(t *testing.T) {
+	var queries []string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		queries = append(queries, req.Form.Get("query"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":%s}`, vectorResult("10"))
+	}))
+	defer prom.Close()
+	query, err := NewFlowQuery("", `sum(increase(rx{bucket="{{.Bucket}}"}[{{.Window}}:{{.Step}}]))`,
+		`sum(increase(tx{bucket="{{.Bucket}}"}[{{.Window}}:{{.Step}}]))`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query, err = query.WithRange(time.Hour, 15*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := QueryPrometheusFlow(prom.URL, query, "b1", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`sum(increase(rx{bucket="b1"}[1h:15s]))`, `sum(increase(tx{bucket="b1"}[1h:15s]))`}
+	if len(queries) != len(want) {
+		t.Fatalf("queries = %q, want %q", queries, want)
+	}
+	for i := range want {
+		if queries[i] != want[i] {
+			t.Errorf("query %d = %s, want %s", i, queries[i], want[i])
+		}
+	}
+}
+
+func TestValidateFlowQuery(t *testing.T) {
+	t.Run("metrics not found", func(t *testing.T) {
+		// the old metric names are exported, the new preset must fail validation instead of returning zero
+		prom := newFakePrometheus(t, map[string]string{"minio_bucket_traffic_received_bytes": vectorResult("1")})
+		defer prom.Close()
+		err := ValidateFlowQuery(prom.URL, FlowQueryPresets[FlowQueryPresetMinioV3], "minio:9000")
+		if err == nil || !retry.IsPermanent(err) {
+			t.Errorf("ValidateFlowQuery() err = %v, want config error", err)
+		}
+	})
+	t.Run("unexpected shape", func(t *testing.T) {
+		prom := newFakePrometheus(t, map[string]string{"minio_bucket_traffic_received_bytes": `{"resultType":"matrix","result":[]}`})
+		defer prom.Close()
+		if err := ValidateFlowQuery(prom.URL, DefaultFlowQuery, "minio:9000"); err == nil {
+			t.Error("ValidateFlowQuery() err = nil, want unexpected shape error")
+		}
+	})
+}
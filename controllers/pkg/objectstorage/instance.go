@@ -0,0 +1,63 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstorage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+// DiscoverInstance returns the only value of the instance label of the flow metrics in the last hour,
+// filtered by the prometheus job of the object storage and the label matchers of the query if set.
+// It fails if no or several instances are found, the instance must then be configured explicitly.
+func DiscoverInstance(host string, query FlowQuery, job string) (string, error) {
+	if query.InstanceLabel == "" || query.InstanceMetric == "" {
+		return "", retry.Config(fmt.Errorf("the flow query has no instance label to discover"))
+	}
+	v1api, err := newPrometheusAPI(host)
+	if err != nil {
+		return "", err
+	}
+	var selector []string
+	if job != "" {
+		selector = append(selector, "job="+strconv.Quote(job))
+	}
+	for _, name := range sortedMatcherNames(query.Matchers) {
+		selector = append(selector, name+"="+strconv.Quote(query.Matchers[name]))
+	}
+	match := query.InstanceMetric
+	if len(selector) > 0 {
+		match += "{" + strings.Join(selector, ", ") + "}"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	values, _, err := v1api.LabelValues(ctx, query.InstanceLabel, []string{match}, now.Add(-time.Hour), now)
+	if err != nil {
+		return "", classifyPrometheusError(fmt.Errorf("failed to query the values of label %s, match: %v, err: %w", query.InstanceLabel, match, err))
+	}
+	switch len(values) {
+	case 0:
+		return "", retry.Config(fmt.Errorf("no object storage instance found by label %s of %v", query.InstanceLabel, match))
+	case 1:
+		return string(values[0]), nil
+	}
+	return "", retry.Config(fmt.Errorf("multiple object storage instances found by label %s of %v: %v", query.InstanceLabel, match, values))
+}
@@ -0,0 +1,78 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstorage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeLabelValues answers the label values of the label, and records the match of the request
+func newFakeLabelValues(t *testing.T, label string, values []string, match *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/label/"+label+"/values" {
+			t.Errorf("path = %s, want the values of label %s", req.URL.Path, label)
+		}
+		if err := req.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		*match = req.Form.Get("match[]")
+		data, _ := json.Marshal(map[string]interface{}{"status": "success", "data": values})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}))
+}
+
+func TestDiscoverInstance(t *testing.T) {
+	tests := []struct {
+		name      string
+		preset    string
+		job       string
+		matchers  map[string]string
+		values    []string
+		want      string
+		wantMatch string
+		wantErr   bool
+	}{
+		{name: "single instance", preset: FlowQueryPresetMinioV2, job: "minio", values: []string{"minio.objectstorage-system:80"},
+			want: "minio.objectstorage-system:80", wantMatch: `minio_bucket_traffic_received_bytes{job="minio"}`},
+		{name: "v3 server label", preset: FlowQueryPresetMinioV3, values: []string{"minio-0:9000"},
+			want: "minio-0:9000", wantMatch: "minio_bucket_api_traffic_received_bytes"},
+		{name: "federated cluster", preset: FlowQueryPresetMinioV2, job: "minio", matchers: map[string]string{"cluster": "hz-1", "region": "cn"},
+			values: []string{"minio:80"}, want: "minio:80", wantMatch: `minio_bucket_traffic_received_bytes{job="minio", cluster="hz-1", region="cn"}`},
+		{name: "no instance", preset: FlowQueryPresetMinioV2, values: []string{}, wantErr: true},
+		{name: "multiple instances", preset: FlowQueryPresetMinioV2, values: []string{"minio-a:80", "minio-b:80"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := FlowQueryPresets[tt.preset].WithMatchers(tt.matchers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var match string
+			prom := newFakeLabelValues(t, query.InstanceLabel, tt.values, &match)
+			defer prom.Close()
+			got, err := DiscoverInstance(prom.URL, query, tt.job)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("DiscoverInstance() = %q, %v, want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+			if !tt.wantErr && match != tt.wantMatch {
+				t.Errorf("match = %s, want %s", match, tt.wantMatch)
+			}
+		})
+	}
+}
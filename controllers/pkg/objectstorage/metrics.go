@@ -0,0 +1,158 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstorage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	ScanResultScanned = "scanned"
+	ScanResultSkipped = "skipped"
+	ScanResultFailed  = "failed"
+
+	// FailureStageSize the objects of the bucket failed to list, the bucket is not metered
+	FailureStageSize = "size"
+	// FailureStageFlow the flow of the bucket failed to query, only the bucket size is metered
+	FailureStageFlow = "flow"
+)
+
+// the metrics are not labeled by bucket to keep the cardinality bounded
+var (
+	bucketScanDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sealos_objectstorage_bucket_scan_duration_seconds",
+		Help:    "Duration of listing the objects of a bucket to calculate the bucket size.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	})
+	cycleBuckets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sealos_objectstorage_cycle_buckets",
+		Help: "Number of buckets scanned, skipped or failed in the last metering cycle.",
+	}, []string{"result"})
+	bucketFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_objectstorage_bucket_failures_total",
+		Help: "Number of the bucket metering failures by the stage, the other buckets of the user are still metered.",
+	}, []string{"stage"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(bucketScanDuration, cycleBuckets, bucketFailures)
+}
+
+// BucketScan the scan result of a bucket
+type BucketScan struct {
+	Bucket   string
+	Duration time.Duration
+	Objects  int64
+}
+
+// ScanCycle collects the bucket scans of one metering cycle, it is safe for concurrent use
+type ScanCycle struct {
+	mu      sync.Mutex
+	scans   []BucketScan
+	skipped int
+	failed  int
+}
+
+func NewScanCycle() *ScanCycle {
+	return &ScanCycle{}
+}
+
+// Scan lists the objects of the bucket and returns the bucket size,
+// the non-current versions and the delete markers are also listed if versions is true.
+// The listing is canceled with the context, eg: the scan timeout.
+func (c *ScanCycle) Scan(ctx context.Context, client *minio.Client, bucket string, versions bool) (BucketSize, error) {
+	start := time.Now()
+	size, err := sumBucketObjects(listBucketObjects(ctx, client, bucket, versions))
+	if err == nil {
+		// the listing may stop without an error once the context is done, the partial sum must not be metered
+		err = ctx.Err()
+	}
+	if err != nil {
+		c.Fail()
+		return BucketSize{}, err
+	}
+	c.observe(bucket, time.Since(start), size.Objects+size.Versions)
+	return size, nil
+}
+
+func (c *ScanCycle) observe(bucket string, duration time.Duration, objects int64) {
+	bucketScanDuration.Observe(duration.Seconds())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scans = append(c.scans, BucketScan{Bucket: bucket, Duration: duration, Objects: objects})
+}
+
+// Skip records a bucket skipped from the scan, eg: exempt from billing
+func (c *ScanCycle) Skip() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skipped++
+}
+
+// Fail records a bucket failed to scan
+func (c *ScanCycle) Fail() {
+	bucketFailures.WithLabelValues(FailureStageSize).Inc()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed++
+}
+
+// FlowFailed records a scanned bucket whose flow failed to query, it is still counted as scanned in the cycle
+func (c *ScanCycle) FlowFailed() {
+	bucketFailures.WithLabelValues(FailureStageFlow).Inc()
+}
+
+// ScanSummary the bucket counts and the slowest bucket scans of a cycle
+type ScanSummary struct {
+	Scanned int
+	Skipped int
+	Failed  int
+	Slowest []BucketScan
+}
+
+// Finish sets the cycle metrics and returns the summary with the n slowest bucket scans of the cycle
+func (c *ScanCycle) Finish(n int) ScanSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cycleBuckets.WithLabelValues(ScanResultScanned).Set(float64(len(c.scans)))
+	cycleBuckets.WithLabelValues(ScanResultSkipped).Set(float64(c.skipped))
+	cycleBuckets.WithLabelValues(ScanResultFailed).Set(float64(c.failed))
+	return ScanSummary{
+		Scanned: len(c.scans),
+		Skipped: c.skipped,
+		Failed:  c.failed,
+		Slowest: slowestBuckets(c.scans, n),
+	}
+}
+
+// slowestBuckets returns the n slowest scans sorted by the duration desc, the scans are not modified
+func slowestBuckets(scans []BucketScan, n int) []BucketScan {
+	sorted := make([]BucketScan, len(scans))
+	copy(sorted, scans)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Duration > sorted[j].Duration
+	})
+	if n >= 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
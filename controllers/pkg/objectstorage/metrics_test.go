@@ -0,0 +1,87 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstorage
+
+import (
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+func scanDurationSampleCount(t *testing.T) uint64 {
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "sealos_objectstorage_bucket_scan_duration_seconds" {
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	t.Fatal("bucket scan duration histogram not registered")
+	return 0
+}
+
+func TestScanCycle(t *testing.T) {
+	before := scanDurationSampleCount(t)
+	cycle := NewScanCycle()
+	cycle.observe("ns-a-small", 10*time.Millisecond, 1)
+	cycle.observe("ns-a-giant", 30*time.Second, 1000000)
+	cycle.observe("ns-b-medium", 2*time.Second, 1000)
+	cycle.Skip()
+	cycle.Fail()
+	if got := scanDurationSampleCount(t) - before; got != 3 {
+		t.Errorf("histogram observed %d samples, want 3", got)
+	}
+
+	summary := cycle.Finish(2)
+	if summary.Scanned != 3 || summary.Skipped != 1 || summary.Failed != 1 {
+		t.Errorf("Finish() counts = %d/%d/%d, want 3/1/1", summary.Scanned, summary.Skipped, summary.Failed)
+	}
+	if len(summary.Slowest) != 2 || summary.Slowest[0].Bucket != "ns-a-giant" || summary.Slowest[1].Bucket != "ns-b-medium" {
+		t.Errorf("Finish() slowest = %v, want ns-a-giant, ns-b-medium", summary.Slowest)
+	}
+}
+
+func TestSlowestBuckets(t *testing.T) {
+	scans := []BucketScan{
+		{Bucket: "a", Duration: 1 * time.Second},
+		{Bucket: "b", Duration: 3 * time.Second},
+		{Bucket: "c", Duration: 2 * time.Second},
+	}
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{n: 0, want: []string{}},
+		{n: 2, want: []string{"b", "c"}},
+		{n: 5, want: []string{"b", "c", "a"}},
+	}
+	for _, tt := range tests {
+		got := slowestBuckets(scans, tt.n)
+		if len(got) != len(tt.want) {
+			t.Fatalf("slowestBuckets(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+		for i := range got {
+			if got[i].Bucket != tt.want[i] {
+				t.Errorf("slowestBuckets(%d)[%d] = %s, want %s", tt.n, i, got[i].Bucket, tt.want[i])
+			}
+		}
+	}
+	if scans[0].Bucket != "a" {
+		t.Error("slowestBuckets() modified the input scans")
+	}
+}
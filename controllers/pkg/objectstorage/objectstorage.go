@@ -16,49 +16,133 @@ package objectstorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
+	"net"
 	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
 )
 
 func ListUserObjectStorageBucket(client *minio.Client, username string) ([]string, error) {
-	buckets, err := client.ListBuckets(context.Background())
+	buckets, err := ListUserObjectStorageBuckets(context.Background(), client, username)
+	if err != nil {
+		return nil, err
+	}
+	expectBuckets := make([]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		expectBuckets = append(expectBuckets, bucket.Name)
+	}
+	return expectBuckets, nil
+}
+
+// ListUserObjectStorageBuckets returns the buckets of the user with the creation time
+func ListUserObjectStorageBuckets(ctx context.Context, client *minio.Client, username string) ([]minio.BucketInfo, error) {
+	buckets, err := client.ListBuckets(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	var expectBuckets []string
+	var expectBuckets []minio.BucketInfo
 	for _, bucket := range buckets {
 		if strings.HasPrefix(bucket.Name, username) {
-			expectBuckets = append(expectBuckets, bucket.Name)
+			expectBuckets = append(expectBuckets, bucket)
 		}
 	}
 	return expectBuckets, nil
 }
 
-func GetObjectStorageSize(client *minio.Client, bucket string) (int64, int64) {
-	objects := client.ListObjects(context.Background(), bucket, minio.ListObjectsOptions{
-		Recursive: true,
+// GetBucketDetail returns the metadata of the bucket, the region is resolved from the bucket location cached by the client.
+// The region is left empty if the location failed to get.
+func GetBucketDetail(ctx context.Context, client *minio.Client, bucket minio.BucketInfo) *resources.ObjStorageDetail {
+	detail := &resources.ObjStorageDetail{CreationTime: bucket.CreationDate.UTC()}
+	if region, err := client.GetBucketLocation(ctx, bucket.Name); err == nil {
+		detail.Region = region
+	}
+	return detail
+}
+
+// GetObjectStorageSize returns the size and count of the latest objects, the error is returned if the listing failed
+func GetObjectStorageSize(client *minio.Client, bucket string) (int64, int64, error) {
+	size, err := sumBucketObjects(listBucketObjects(context.Background(), client, bucket, false))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list objects of bucket %s: %w", bucket, err)
+	}
+	return size.Current, size.Objects, nil
+}
+
+// BucketSize the size of a bucket, the non-current versions are only listed if versions is requested
+type BucketSize struct {
+	// Current the size of the latest versions
+	Current int64
+	// Noncurrent the size of the non-current versions
+	Noncurrent int64
+	// Objects the count of the latest versions, excluding the delete markers
+	Objects int64
+	// Versions the count of the non-current versions and the delete markers
+	Versions int64
+}
+
+// Total the disk size used by all versions
+func (s BucketSize) Total() int64 {
+	return s.Current + s.Noncurrent
+}
+
+// GetObjectStorageVersionsSize lists all versions of the bucket once, also for the unversioned buckets,
+// so the versioning status is not requested per bucket.
+func GetObjectStorageVersionsSize(client *minio.Client, bucket string) (BucketSize, error) {
+	return sumBucketObjects(listBucketObjects(context.Background(), client, bucket, true))
+}
+
+func listBucketObjects(ctx context.Context, client *minio.Client, bucket string, versions bool) <-chan minio.ObjectInfo {
+	return client.ListObjects(ctx, bucket, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithVersions: versions,
 	})
-	var totalSize int64
-	var objectsCount int64
+}
+
+// sumBucketObjects sums the listed objects, the latest version of an object is current and the older ones are non-current.
+// A delete marker has no data, it is counted as a version only. The channel is drained on error.
+func sumBucketObjects(objects <-chan minio.ObjectInfo) (BucketSize, error) {
+	var (
+		size BucketSize
+		err  error
+	)
 	for object := range objects {
-		totalSize += object.Size
-		objectsCount++
+		if object.Err != nil {
+			err = object.Err
+			continue
+		}
+		switch {
+		case object.IsDeleteMarker:
+			size.Versions++
+		case object.IsLatest || object.VersionID == "":
+			// the objects listed without versions have no version id
+			size.Current += object.Size
+			size.Objects++
+		default:
+			size.Noncurrent += object.Size
+			size.Versions++
+		}
+	}
+	if err != nil {
+		return BucketSize{}, err
 	}
-	return totalSize, objectsCount
+	return size, nil
 }
 
-func GetObjectStorageFlow(promURL, bucket, instance string) (int64, error) {
-	flow, err := QueryPrometheus(promURL, bucket, instance)
+// GetObjectStorageFlow the returned error is classified by retry.IsTransient / retry.IsPermanent
+func GetObjectStorageFlow(promURL string, query FlowQuery, bucket, instance string) (int64, error) {
+	flow, err := QueryPrometheusFlow(promURL, query, bucket, instance)
 	if err != nil {
-		return 0, fmt.Errorf("failed to query prometheus, bucket: %v, err: %v", bucket, err)
+		return 0, fmt.Errorf("failed to query prometheus, bucket: %v, err: %w", bucket, err)
 	}
 	return flow, nil
 }
@@ -72,7 +156,10 @@ func GetUserObjectStorageSize(client *minio.Client, username string) (int64, int
 	var totalSize int64
 	var objectsCount int64
 	for _, bucketName := range buckets {
-		size, count := GetObjectStorageSize(client, bucketName)
+		size, count, err := GetObjectStorageSize(client, bucketName)
+		if err != nil {
+			return 0, 0, err
+		}
 		totalSize += size
 		objectsCount += count
 	}
@@ -97,52 +184,114 @@ func GetUserObjectStorageFlow(client *minio.Client, promURL, username, instance
 	return totalFlow, nil
 }
 
+// QueryPrometheus queries the flow of the bucket with the default query
 func QueryPrometheus(host, bucketName, instance string) (int64, error) {
-	client, err := api.NewClient(api.Config{
-		Address: host,
-	})
+	return QueryPrometheusFlow(host, DefaultFlowQuery, bucketName, instance)
+}
+
+// QueryPrometheusFlow queries the received and sent bytes of the bucket with the query templates
+func QueryPrometheusFlow(host string, query FlowQuery, bucketName, instance string) (int64, error) {
+	rcvdQuery, sentQuery, err := query.Render(bucketName, instance)
 	if err != nil {
-		return 0, fmt.Errorf("failed to new prometheus client, host: %v, err: %v", host, err)
+		return 0, retry.Config(err)
+	}
+	v1api, err := newPrometheusAPI(host)
+	if err != nil {
+		return 0, err
 	}
-
-	v1api := v1.NewAPI(client)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	rcvdQuery := "sum(minio_bucket_traffic_received_bytes{bucket=\"" + bucketName + "\", instance=\"" + instance + "\"})"
-	rcvdResult, rcvdWarnings, err := v1api.Query(ctx, rcvdQuery, time.Now(), v1.WithTimeout(5*time.Second))
+	rcvdBytes, err := queryFlowBytes(ctx, v1api, rcvdQuery)
 	if err != nil {
-		return 0, fmt.Errorf("failed to query prometheus, query: %v, err: %v", rcvdQuery, err)
+		return 0, err
 	}
-
-	if len(rcvdWarnings) > 0 {
-		return 0, fmt.Errorf("there are warnings: %v", rcvdWarnings)
+	sentBytes, err := queryFlowBytes(ctx, v1api, sentQuery)
+	if err != nil {
+		return 0, err
 	}
+	return addBytes(rcvdBytes, sentBytes), nil
+}
 
-	sentQuery := "sum(minio_bucket_traffic_sent_bytes{bucket=\"" + bucketName + "\", instance=\"" + instance + "\"})"
-	sentResult, sentWarnings, err := v1api.Query(ctx, sentQuery, time.Now(), v1.WithTimeout(5*time.Second))
+// ValidateFlowQuery executes the queries once to check the result shape,
+// and the probe query to check the metrics exist for the instance.
+func ValidateFlowQuery(host string, query FlowQuery, instance string) error {
+	rcvdQuery, sentQuery, err := query.Render("", instance)
 	if err != nil {
-		return 0, fmt.Errorf("failed to query prometheus, query: %v, err: %v", sentQuery, err)
+		return retry.Config(err)
 	}
-
-	if len(sentWarnings) > 0 {
-		return 0, fmt.Errorf("there are warnings: %v", sentWarnings)
+	v1api, err := newPrometheusAPI(host)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, q := range []string{rcvdQuery, sentQuery} {
+		if _, err := queryFlowBytes(ctx, v1api, q); err != nil {
+			return err
+		}
+	}
+	if query.Probe == "" {
+		return nil
+	}
+	probeQuery, err := renderFlowQuery(query.Probe, query.data("", instance))
+	if err != nil {
+		return retry.Config(err)
 	}
+	result, _, err := v1api.Query(ctx, probeQuery, time.Now(), v1.WithTimeout(5*time.Second))
+	if err != nil {
+		return classifyPrometheusError(fmt.Errorf("failed to query prometheus, query: %v, err: %w", probeQuery, err))
+	}
+	if vector, ok := result.(model.Vector); !ok || len(vector) == 0 {
+		return retry.Config(fmt.Errorf("no object storage traffic metrics found, query: %v, please check the metric name and labels", probeQuery))
+	}
+	return nil
+}
 
-	re := regexp.MustCompile(`\d+`)
-	rcvdStr := re.FindString(rcvdResult.String())
-	sentStr := re.FindString(sentResult.String())
+func newPrometheusAPI(host string) (v1.API, error) {
+	if host == "" {
+		return nil, retry.Config(fmt.Errorf("prometheus host is empty"))
+	}
+	client, err := api.NewClient(api.Config{
+		Address: host,
+	})
+	if err != nil {
+		return nil, retry.Config(fmt.Errorf("failed to new prometheus client, host: %v, err: %v", host, err))
+	}
+	return v1.NewAPI(client), nil
+}
 
-	rcvdBytes, err := strconv.ParseInt(rcvdStr, 10, 64)
+func queryFlowBytes(ctx context.Context, v1api v1.API, query string) (int64, error) {
+	result, warnings, err := v1api.Query(ctx, query, time.Now(), v1.WithTimeout(5*time.Second))
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse rcvdStr %s to int64: %v", rcvdStr, err)
+		return 0, classifyPrometheusError(fmt.Errorf("failed to query prometheus, query: %v, err: %w", query, err))
 	}
-	sentBytes, err := strconv.ParseInt(sentStr, 10, 64)
+	if len(warnings) > 0 {
+		return 0, retry.Permanent(fmt.Errorf("there are warnings: %v", warnings))
+	}
+	bytes, err := parseFlowResult(result)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse sentStr %s to int64: %v", sentStr, err)
+		return 0, retry.Permanent(fmt.Errorf("failed to parse the result of query %v: %w", query, err))
 	}
+	return bytes, nil
+}
 
-	fmt.Printf("received bytes: %d, send bytes: %d\n", rcvdBytes, sentBytes)
-
-	return rcvdBytes + sentBytes, nil
+// classifyPrometheusError timeout, server and connection failures are transient, the client errors other than the
+// bad queries (eg: 401 or 403 of an auth proxy, 404 of a wrong url) are misconfiguration, the others are permanent
+func classifyPrometheusError(err error) error {
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Type {
+		case v1.ErrTimeout, v1.ErrCanceled, v1.ErrServer:
+			return retry.Transient(err)
+		case v1.ErrClient:
+			return retry.Config(err)
+		}
+		return retry.Permanent(err)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return retry.Transient(err)
+	}
+	return retry.Permanent(err)
 }
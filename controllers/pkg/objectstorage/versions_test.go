@@ -0,0 +1,94 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstorage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func fakeListing(objects ...minio.ObjectInfo) <-chan minio.ObjectInfo {
+	ch := make(chan minio.ObjectInfo, len(objects))
+	for _, object := range objects {
+		ch <- object
+	}
+	close(ch)
+	return ch
+}
+
+func TestSumBucketObjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []minio.ObjectInfo
+		want    BucketSize
+		wantErr bool
+	}{
+		{
+			name: "unversioned listing",
+			objects: []minio.ObjectInfo{
+				{Key: "a", Size: 100},
+				{Key: "b", Size: 200},
+			},
+			want: BucketSize{Current: 300, Objects: 2},
+		},
+		{
+			name: "current and non-current versions",
+			objects: []minio.ObjectInfo{
+				{Key: "a", VersionID: "v3", IsLatest: true, Size: 100},
+				{Key: "a", VersionID: "v2", Size: 1000},
+				{Key: "a", VersionID: "v1", Size: 2000},
+				{Key: "b", VersionID: "null", IsLatest: true, Size: 50},
+			},
+			want: BucketSize{Current: 150, Noncurrent: 3000, Objects: 2, Versions: 2},
+		},
+		{
+			name: "deleted object with delete marker",
+			objects: []minio.ObjectInfo{
+				{Key: "a", VersionID: "v2", IsLatest: true, IsDeleteMarker: true},
+				{Key: "a", VersionID: "v1", Size: 500},
+			},
+			want: BucketSize{Noncurrent: 500, Versions: 2},
+		},
+		{
+			name: "listing error",
+			objects: []minio.ObjectInfo{
+				{Key: "a", VersionID: "v1", IsLatest: true, Size: 100},
+				{Err: errors.New("access denied")},
+				{Key: "b", VersionID: "v1", IsLatest: true, Size: 100},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := fakeListing(tt.objects...)
+			got, err := sumBucketObjects(objects)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sumBucketObjects() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("sumBucketObjects() = %+v, want %+v", got, tt.want)
+			}
+			if _, ok := <-objects; ok {
+				t.Error("sumBucketObjects() did not drain the listing")
+			}
+			if got.Total() != tt.want.Current+tt.want.Noncurrent {
+				t.Errorf("Total() = %d, want %d", got.Total(), tt.want.Current+tt.want.Noncurrent)
+			}
+		})
+	}
+}
@@ -56,6 +56,10 @@ type ResourceNamed struct {
 	// db or app or terminal or job or other
 	_type  string
 	labels map[string]string
+	// objStorage the bucket metadata of the object storage
+	objStorage *ObjStorageDetail
+	// property tags the monitors of the resource, the resources of the same app with different properties are metered apart
+	property string
 }
 
 func NewResourceNamed(cr client.Object) *ResourceNamed {
@@ -97,6 +101,29 @@ func NewObjStorageResourceNamed(bucket string) *ResourceNamed {
 	}
 }
 
+// NewObjStorageResourceNamedWithDetail the detail is saved in the monitors of the bucket
+func NewObjStorageResourceNamedWithDetail(bucket string, detail *ObjStorageDetail) *ResourceNamed {
+	p := NewObjStorageResourceNamed(bucket)
+	p.objStorage = detail
+	return p
+}
+
+// ObjStorageDetail returns the bucket metadata, nil if not an object storage
+func (p *ResourceNamed) ObjStorageDetail() *ObjStorageDetail {
+	return p.objStorage
+}
+
+// WithProperty tags the resource with the property, eg: the pending pvcs, saved in the property of the monitors
+func (p *ResourceNamed) WithProperty(property string) *ResourceNamed {
+	p.property = property
+	return p
+}
+
+// Property returns the property of the monitors, empty if not tagged
+func (p *ResourceNamed) Property() string {
+	return p.property
+}
+
 const (
 	acmesolver                          = "acmesolver"
 	acmesolverContainerArgsDomainPrefix = "--domain="
@@ -220,5 +247,8 @@ func (p *ResourceNamed) Name() string {
 }
 
 func (p *ResourceNamed) String() string {
+	if p.property != "" {
+		return p._type + "/" + p._name + "/" + p.property
+	}
 	return p._type + "/" + p._name
 }
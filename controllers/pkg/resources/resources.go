@@ -15,7 +15,11 @@
 package resources
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
@@ -79,11 +83,63 @@ type Price struct {
 type Monitor struct {
 	Time time.Time `json:"time" bson:"time"`
 	// equal namespace
-	Category string      `json:"category" bson:"category"`
-	Type     uint8       `json:"type" bson:"type"`
-	Name     string      `json:"name" bson:"name"`
+	Category string `json:"category" bson:"category"`
+	Type     uint8  `json:"type" bson:"type"`
+	Name     string `json:"name" bson:"name"`
+	// Used the used of each property enum in whole units of the property, or in 1/Scale of the unit if the property
+	// has a Scale, eg: cpu unit 1 with scale 1000 stores 1500 for 1.5 cores. The scale of the property is applied
+	// again when the used is billed, so the monitors must be read with the same properties they were written with.
 	Used     EnumUsedMap `json:"used" bson:"used"`
 	Property string      `json:"property,omitempty" bson:"property,omitempty"`
+	// Utilization the actual utilization percent (0-100) of the reserved resources, eg: gpu by dcgm.
+	// It is informational only and not billed.
+	Utilization EnumUsedMap `json:"utilization,omitempty" bson:"utilization,omitempty"`
+	// ObjStorage the bucket metadata of the object storage monitors
+	ObjStorage *ObjStorageDetail `json:"objstorage,omitempty" bson:"objstorage,omitempty"`
+	// Tenant the metadata of the tenant copied from the namespace, eg: region, accountID and plan
+	Tenant map[string]string `json:"tenant,omitempty" bson:"tenant,omitempty"`
+	// Labels the namespace labels copied by their keys for the reports, eg: cost-center and team
+	Labels map[string]string `json:"labels,omitempty" bson:"labels,omitempty"`
+	// IdempotencyKey identifies the monitor rewritten by the retries, eg: the traffic of a window,
+	// the monitors of the same key are replaced instead of inserted twice
+	IdempotencyKey string `json:"idempotencyKey,omitempty" bson:"idempotency_key,omitempty"`
+	// MonitorID the deterministic id of the record set by the stores on insert, see MonitorID
+	MonitorID string `json:"monitorID,omitempty" bson:"monitor_id,omitempty"`
+}
+
+// TrafficMonitorKey the idempotency key of the traffic monitor of the app in the window ending at the end
+func TrafficMonitorKey(namespace string, _type uint8, name string, end time.Time) string {
+	return fmt.Sprintf("traffic/%s/%d/%s/%s", namespace, _type, name, end.UTC().Format(time.RFC3339))
+}
+
+// MonitorID returns the deterministic id of the logical record of the monitor, derived from the namespace, type, name
+// and time (in milliseconds, the precision of the stores) with the property and the idempotency key, so the stores
+// can tell the same record inserted twice, eg: by a controller restarted within the minute.
+func MonitorID(monitor *Monitor) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s\x00%d\x00%s\x00%s", monitor.Category, monitor.Type, monitor.Name,
+		monitor.Time.UTC().UnixMilli(), monitor.Property, monitor.IdempotencyKey)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ObjStorageDetail the metadata of a bucket
+type ObjStorageDetail struct {
+	CreationTime time.Time `json:"creation_time" bson:"creation_time"`
+	Region       string    `json:"region,omitempty" bson:"region,omitempty"`
+	// CollectedAt the time each resource of the bucket was collected by the property name, the size is listed from the
+	// bucket and the flow queried from prometheus apart. A resource without a time was not collected (eg: the flow failed),
+	// a time before the monitor time is carried over from an earlier collection.
+	CollectedAt map[string]time.Time `json:"collected_at,omitempty" bson:"collected_at,omitempty"`
+}
+
+// ObjStorageBucketUsage the usage of a bucket summed in a period, the deleted buckets are included for the period they existed
+type ObjStorageBucketUsage struct {
+	Bucket string `json:"bucket"`
+	// Used the sum of the used of the monitors, eg: the storage is summed per minute
+	Used      EnumUsedMap `json:"used"`
+	FirstSeen time.Time   `json:"first_seen"`
+	LastSeen  time.Time   `json:"last_seen"`
+	// Detail the latest bucket metadata in the period, nil if the monitors have no detail
+	Detail *ObjStorageDetail `json:"detail,omitempty"`
 }
 
 type BillingType int
@@ -179,7 +235,7 @@ var AppTypeReverse = map[uint8]string{
 	db: DB, app: APP, terminal: TERMINAL, job: JOB, other: OTHER, objectStorage: ObjectStorage,
 }
 
-// resource consumption
+// resource consumption, the used of a scaled property is in 1/Scale of its unit, see PropertyType.Scale
 type EnumUsedMap map[uint8]int64
 
 type PropertyType struct {
@@ -213,6 +269,35 @@ type PropertyType struct {
 	UnitString string `json:"unit" bson:"unit"`
 	//charging cycle second
 	UnitPeriod string `json:"unit_period,omitempty" bson:"unit_period,omitempty"`
+	// Ratio is the measured quantity of one counted object, eg: nodeport 1:1000, one node port is measured as quantity 1000
+	Ratio int64 `json:"ratio,omitempty" bson:"ratio,omitempty"`
+	// Scale stores the used in 1/Scale of the unit instead of rounding it up to whole units, eg: cpu unit 1 with scale 1000
+	// stores the millicores. The used of a property without scale (0 or 1) is rounded up to whole units.
+	Scale int64 `json:"scale,omitempty" bson:"scale,omitempty"`
+}
+
+// scale returns the stored fraction of the unit, 1 if the property is not scaled
+func (p PropertyType) scale() int64 {
+	if p.Scale > 1 {
+		return p.Scale
+	}
+	return 1
+}
+
+// UsedUnits returns the used of the milli value in 1/Scale of the unit, rounded up.
+// It's computed in integers, so the large quantities (eg: the storage bytes) are not truncated by the float precision.
+func (p PropertyType) UsedUnits(milliValue int64) int64 {
+	unit, scale := p.Unit.MilliValue(), p.scale()
+	used := milliValue / unit * scale
+	if rem := milliValue % unit; rem > 0 {
+		used += (rem*scale + unit - 1) / unit
+	}
+	return used
+}
+
+// UsedAmount returns the amount of the used at the unit price, the used of a scaled property is divided by the scale
+func (p PropertyType) UsedAmount(used int64) int64 {
+	return int64(math.Ceil(float64(used) * p.UnitPrice / float64(p.scale())))
 }
 
 type PropertyTypeLS struct {
@@ -270,9 +355,13 @@ var DefaultPropertyTypeList = []PropertyType{
 		UnitPrice:  2.083,
 		ViewPrice:  2083,
 		UnitString: "1",
+		Ratio:      DefaultNodePortRatio,
 	},
 }
 
+// DefaultNodePortRatio one node port is measured as quantity 1000
+const DefaultNodePortRatio = 1000
+
 var DefaultPropertyTypeLS = newPropertyTypeLS(DefaultPropertyTypeList)
 
 func ConvertEnumUsedToString(costs map[uint8]int64) (costsMap map[string]int64) {
@@ -292,6 +381,77 @@ func NewPropertyTypeLS(types []PropertyType) (ls *PropertyTypeLS) {
 	return newPropertyTypeLS(types)
 }
 
+// ParsePropertyTypeLS decrypts the prices of the properties and validates them, unlike NewPropertyTypeLS an invalid set
+// is returned as an error instead of falling back to the default properties
+func ParsePropertyTypeLS(types []PropertyType) (*PropertyTypeLS, error) {
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no property found")
+	}
+	types, err := decryptPrice(append([]PropertyType{}, types...))
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidatePropertyTypes(types); err != nil {
+		return nil, err
+	}
+	return newPropertyTypeLS(types), nil
+}
+
+// RequiredPropertyNames the properties metered by every cycle, the used of a resource without a property is dropped
+var RequiredPropertyNames = []string{
+	corev1.ResourceCPU.String(),
+	corev1.ResourceMemory.String(),
+	corev1.ResourceStorage.String(),
+	ResourceNetwork,
+	corev1.ResourceServicesNodePorts.String(),
+}
+
+// ValidatePropertyTypes checks the required properties are set, the names and the enums of the properties are unique
+// and their units are positive. All the invalid properties are returned in one error.
+func ValidatePropertyTypes(types []PropertyType) error {
+	var errs []error
+	names := make(map[string]struct{}, len(types))
+	enums := make(map[uint8]string, len(types))
+	for i := range types {
+		if types[i].Name == "" {
+			errs = append(errs, fmt.Errorf("the name of the property of enum %d is empty", types[i].Enum))
+		} else if _, ok := names[types[i].Name]; ok {
+			errs = append(errs, fmt.Errorf("duplicate property %s", types[i].Name))
+		}
+		names[types[i].Name] = struct{}{}
+		if name, ok := enums[types[i].Enum]; ok {
+			errs = append(errs, fmt.Errorf("enum %d of property %s collides with property %s", types[i].Enum, types[i].Name, name))
+		} else {
+			enums[types[i].Enum] = types[i].Name
+		}
+		unit := types[i].Unit
+		if unit.IsZero() && types[i].UnitString != "" {
+			var err error
+			if unit, err = resource.ParseQuantity(types[i].UnitString); err != nil {
+				errs = append(errs, fmt.Errorf("invalid unit %q of property %s: %v", types[i].UnitString, types[i].Name, err))
+				continue
+			}
+		}
+		if unit.Sign() <= 0 {
+			errs = append(errs, fmt.Errorf("the unit of property %s must be positive", types[i].Name))
+		}
+	}
+	for _, name := range RequiredPropertyNames {
+		if _, ok := names[name]; !ok {
+			errs = append(errs, fmt.Errorf("required property %s not found", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the properties by ValidatePropertyTypes, eg: before metering with them
+func (ls *PropertyTypeLS) Validate() error {
+	if ls == nil || len(ls.Types) == 0 {
+		return fmt.Errorf("no property found")
+	}
+	return ValidatePropertyTypes(ls.Types)
+}
+
 func newPropertyTypeLS(types []PropertyType) (ls *PropertyTypeLS) {
 	ls = &PropertyTypeLS{
 		Types:     types,
@@ -323,6 +483,14 @@ func decryptPrice(types []PropertyType) ([]PropertyType, error) {
 	return types, nil
 }
 
+// GetRatio returns the measurement ratio of the property, defaultRatio is returned if the property or ratio is not set
+func (ls *PropertyTypeLS) GetRatio(name string, defaultRatio int64) int64 {
+	if pType, ok := ls.StringMap[name]; ok && pType.Ratio > 0 {
+		return pType.Ratio
+	}
+	return defaultRatio
+}
+
 type PropertyTypeEnumMap map[uint8]PropertyType
 
 type PropertyTypeStringMap map[string]PropertyType
@@ -335,6 +503,15 @@ const GpuResourcePrefix = "gpu-"
 const ResourceGPU corev1.ResourceName = gpu.NvidiaGpuKey
 const ResourceNetwork = "network"
 
+// ResourceObjStorageNoncurrent the non-current versions of the versioned object storage buckets
+const ResourceObjStorageNoncurrent = "storage.noncurrent"
+
+// ResourceLoadBalancerPorts the ports of the provisioned LoadBalancer services
+const ResourceLoadBalancerPorts = "services.loadbalancers.ports"
+
+// ResourceLoadBalancerIPs the ingress ips assigned to the LoadBalancer services
+const ResourceLoadBalancerIPs = "services.loadbalancers.ips"
+
 const (
 	ResourceRequestGpu corev1.ResourceName = "requests." + gpu.NvidiaGpuKey
 	ResourceLimitGpu   corev1.ResourceName = "limits." + gpu.NvidiaGpuKey
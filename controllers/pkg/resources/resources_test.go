@@ -0,0 +1,184 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestPropertyType_UsedUnits(t *testing.T) {
+	tests := []struct {
+		name       string
+		unit       string
+		scale      int64
+		milliValue int64
+		want       int64
+	}{
+		{name: "rounded up to the unit", unit: "1", milliValue: 1500, want: 2},
+		{name: "scale 1 is not scaled", unit: "1", scale: 1, milliValue: 1500, want: 2},
+		{name: "millicores", unit: "1", scale: 1000, milliValue: 1500, want: 1500},
+		{name: "sub scale rounded up", unit: "1", scale: 100, milliValue: 1501, want: 151},
+		{name: "zero", unit: "1", scale: 1000, milliValue: 0, want: 0},
+		{name: "large storage not truncated", unit: "1Mi", milliValue: (1<<50 + 1) * 1000, want: 1<<30 + 1},
+		{name: "scaled mebibytes", unit: "1Mi", scale: 1024, milliValue: 1536 * 1024 * 1000, want: 1536},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := PropertyType{Unit: resource.MustParse(tt.unit), Scale: tt.scale}
+			if got := p.UsedUnits(tt.milliValue); got != tt.want {
+				t.Errorf("UsedUnits(%d) = %d, want %d", tt.milliValue, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPropertyType_UsedAmount(t *testing.T) {
+	cpu := PropertyType{UnitPrice: 2, Unit: resource.MustParse("1")}
+	if got := cpu.UsedAmount(cpu.UsedUnits(1500)); got != 4 {
+		t.Errorf("unscaled amount = %d, want 4", got)
+	}
+	cpu.Scale = 1000
+	if got := cpu.UsedAmount(cpu.UsedUnits(1500)); got != 3 {
+		t.Errorf("scaled amount = %d, want 3", got)
+	}
+	if got := cpu.UsedAmount(cpu.UsedUnits(1)); got != 1 {
+		t.Errorf("scaled amount of a millicore = %d, want 1", got)
+	}
+}
+
+func TestMonitorID(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	base := Monitor{Category: "ns-a", Type: 1, Name: "app", Time: at, Used: EnumUsedMap{0: 100}}
+	id := MonitorID(&base)
+
+	same := base
+	same.Time = at.In(time.FixedZone("UTC+8", 8*3600))
+	same.Used = EnumUsedMap{0: 200}
+	same.Tenant = map[string]string{"region": "a"}
+	if got := MonitorID(&same); got != id {
+		t.Errorf("MonitorID() of the same record = %s, want %s", got, id)
+	}
+	for name, change := range map[string]func(m *Monitor){
+		"category": func(m *Monitor) { m.Category = "ns-b" },
+		"type":     func(m *Monitor) { m.Type = 2 },
+		"name":     func(m *Monitor) { m.Name = "app-2" },
+		"time":     func(m *Monitor) { m.Time = at.Add(time.Minute) },
+		"property": func(m *Monitor) { m.Property = "pending" },
+		"key":      func(m *Monitor) { m.IdempotencyKey = "traffic" },
+	} {
+		other := base
+		change(&other)
+		if MonitorID(&other) == id {
+			t.Errorf("MonitorID() with another %s = the same id", name)
+		}
+	}
+}
+
+// withProperty returns the default properties with the property of the name changed by mutate
+func withProperty(name string, mutate func(pt *PropertyType)) []PropertyType {
+	types := append([]PropertyType{}, DefaultPropertyTypeList...)
+	for i := range types {
+		if types[i].Name == name {
+			mutate(&types[i])
+		}
+	}
+	return types
+}
+
+// withoutProperty returns the default properties without the property of the name
+func withoutProperty(name string) []PropertyType {
+	var types []PropertyType
+	for _, pt := range DefaultPropertyTypeList {
+		if pt.Name != name {
+			types = append(types, pt)
+		}
+	}
+	return types
+}
+
+func unitString(unit string) func(pt *PropertyType) {
+	return func(pt *PropertyType) {
+		pt.Unit, pt.UnitString = resource.Quantity{}, unit
+	}
+}
+
+func TestValidatePropertyTypes(t *testing.T) {
+	cpu := DefaultPropertyTypeLS.StringMap["cpu"]
+	tests := []struct {
+		name    string
+		types   []PropertyType
+		wantErr []string
+	}{
+		{name: "default", types: DefaultPropertyTypeList},
+		{name: "extra property", types: append(append([]PropertyType{}, DefaultPropertyTypeList...), PropertyType{Name: "gpu", Enum: 100, UnitString: "1"})},
+		{name: "empty name", types: withProperty("storage", func(pt *PropertyType) { pt.Name = "" }),
+			wantErr: []string{"the name of the property of enum 2 is empty", "required property storage not found"}},
+		{name: "duplicate name", types: append(append([]PropertyType{}, DefaultPropertyTypeList...), PropertyType{Name: "cpu", Enum: 100, UnitString: "1"}),
+			wantErr: []string{"duplicate property cpu"}},
+		{name: "enum collision", types: withProperty("memory", func(pt *PropertyType) { pt.Enum = cpu.Enum }),
+			wantErr: []string{"enum 0 of property memory collides with property cpu"}},
+		{name: "zero unit", types: withProperty("cpu", unitString("0")),
+			wantErr: []string{"the unit of property cpu must be positive"}},
+		{name: "negative unit", types: withProperty("cpu", unitString("-1")),
+			wantErr: []string{"the unit of property cpu must be positive"}},
+		{name: "no unit", types: withProperty("cpu", unitString("")),
+			wantErr: []string{"the unit of property cpu must be positive"}},
+		{name: "invalid unit", types: withProperty("cpu", unitString("a core")),
+			wantErr: []string{`invalid unit "a core" of property cpu`}},
+		{name: "all errors", types: withProperty("memory", func(pt *PropertyType) {
+			pt.Enum, pt.Name, pt.Unit, pt.UnitString = cpu.Enum, "cpu", resource.Quantity{}, "0"
+		}), wantErr: []string{"duplicate property cpu", "enum 0 of property cpu collides with property cpu", "the unit of property cpu must be positive", "required property memory not found"}},
+	}
+	for _, name := range RequiredPropertyNames {
+		tests = append(tests, struct {
+			name    string
+			types   []PropertyType
+			wantErr []string
+		}{name: "no " + name, types: withoutProperty(name), wantErr: []string{"required property " + name + " not found"}})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePropertyTypes(tt.types)
+			if (err != nil) != (len(tt.wantErr) > 0) {
+				t.Fatalf("ValidatePropertyTypes() error = %v, want %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("ValidatePropertyTypes() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPropertyTypeLS_Validate(t *testing.T) {
+	if err := DefaultPropertyTypeLS.Validate(); err != nil {
+		t.Errorf("Validate() of the default properties error = %v", err)
+	}
+	var nilLS *PropertyTypeLS
+	if err := nilLS.Validate(); err == nil {
+		t.Error("Validate() of nil properties expected error")
+	}
+	if err := (&PropertyTypeLS{}).Validate(); err == nil {
+		t.Error("Validate() of no property expected error")
+	}
+	if err := newPropertyTypeLS(withoutProperty("memory")).Validate(); err == nil || !strings.Contains(err.Error(), "required property memory not found") {
+		t.Errorf("Validate() without memory error = %v", err)
+	}
+}
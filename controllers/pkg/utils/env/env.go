@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 )
 
 func GetEnvWithDefault(key, defaultValue string) string {
@@ -36,6 +37,29 @@ func GetInt64EnvWithDefault(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+func GetBoolEnvWithDefault(key string, defaultValue bool) bool {
+	if env, ok := os.LookupEnv(key); ok && env != "" {
+		if value, err := strconv.ParseBool(env); err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+// GetDurationEnvWithDefault returns the default if the env is not set. A value which is not a duration (eg: 72 without
+// a unit) is an error instead of the default, so a mistyped setting fails the startup rather than being ignored.
+func GetDurationEnvWithDefault(key string, defaultValue time.Duration) (time.Duration, error) {
+	env, ok := os.LookupEnv(key)
+	if !ok || env == "" {
+		return defaultValue, nil
+	}
+	value, err := time.ParseDuration(env)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, env, err)
+	}
+	return value, nil
+}
+
 func CheckEnvSetting(keys []string) error {
 	for _, key := range keys {
 		if val, ok := os.LookupEnv(key); !ok || val == "" {
@@ -0,0 +1,43 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDurationEnvWithDefault(t *testing.T) {
+	const key = "TEST_DURATION"
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{raw: "", want: time.Minute},
+		{raw: "0", want: 0},
+		{raw: "90s", want: 90 * time.Second},
+		// the values without a unit are not replaced by the default silently
+		{raw: "72", wantErr: true},
+		{raw: "1day", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv(key, tt.raw)
+		got, err := GetDurationEnvWithDefault(key, time.Minute)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("GetDurationEnvWithDefault() of %q = %s, %v, want %s, wantErr %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import "errors"
+
+var (
+	// ErrTransient the failure is temporary, eg: network error or timeout, the action can be retried later
+	ErrTransient = errors.New("transient error")
+	// ErrPermanent the failure will not recover by retrying, eg: malformed data
+	ErrPermanent = errors.New("permanent error")
+	// ErrConfig the failure is caused by misconfiguration, eg: invalid url or credentials, it is permanent
+	ErrConfig = errors.New("config error")
+)
+
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+func classify(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{kind: kind, err: err}
+}
+
+// Transient marks the error as transient, nil is returned if err is nil
+func Transient(err error) error {
+	return classify(ErrTransient, err)
+}
+
+// Permanent marks the error as permanent, nil is returned if err is nil
+func Permanent(err error) error {
+	return classify(ErrPermanent, err)
+}
+
+// Config marks the error as misconfiguration, nil is returned if err is nil
+func Config(err error) error {
+	return classify(ErrConfig, err)
+}
+
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrPermanent) || errors.Is(err, ErrConfig)
+}
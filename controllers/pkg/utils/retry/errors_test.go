@@ -0,0 +1,92 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryTransient(t *testing.T) {
+	errDown := errors.New("mongo down")
+	tries := 0
+	err := RetryTransient(3, time.Millisecond, func() error {
+		tries++
+		if tries < 3 {
+			return Transient(errDown)
+		}
+		return nil
+	})
+	if err != nil || tries != 3 {
+		t.Errorf("RetryTransient() err = %v, tries = %d, want nil, 3", err, tries)
+	}
+
+	tries = 0
+	err = RetryTransient(3, time.Millisecond, func() error {
+		tries++
+		return fmt.Errorf("insert monitor: %w", Permanent(errors.New("malformed data")))
+	})
+	if !IsPermanent(err) || tries != 1 {
+		t.Errorf("RetryTransient() err = %v, tries = %d, want permanent error, 1", err, tries)
+	}
+
+	err = RetryTransient(2, time.Millisecond, func() error {
+		return Transient(errDown)
+	})
+	if !IsTransient(err) || !errors.Is(err, errDown) {
+		t.Errorf("RetryTransient() err = %v, want transient error wrapping %v", err, errDown)
+	}
+	if IsTransient(Config(errDown)) || !IsPermanent(Config(errDown)) {
+		t.Errorf("config error should be permanent")
+	}
+}
+
+func TestRetryTransientWithJitter(t *testing.T) {
+	errThrottled := errors.New("too many requests")
+	tries := 0
+	start := time.Now()
+	err := RetryTransientWithJitter(3, 10*time.Millisecond, 1, func() error {
+		tries++
+		if tries < 3 {
+			return Transient(errThrottled)
+		}
+		return nil
+	})
+	if err != nil || tries != 3 {
+		t.Errorf("RetryTransientWithJitter() err = %v, tries = %d, want nil, 3", err, tries)
+	}
+	// the backoff of 10ms and 30ms is jittered up to twice
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Errorf("RetryTransientWithJitter() took %s, want the 40ms backoff jittered up to 80ms", elapsed)
+	}
+
+	tries = 0
+	err = RetryTransientWithJitter(3, time.Millisecond, 1, func() error {
+		tries++
+		return errors.New("forbidden")
+	})
+	if err == nil || tries != 1 {
+		t.Errorf("RetryTransientWithJitter() err = %v, tries = %d, want the error, 1", err, tries)
+	}
+
+	err = RetryTransientWithJitter(2, time.Millisecond, 1, func() error {
+		return Transient(errThrottled)
+	})
+	if !IsTransient(err) || !errors.Is(err, errThrottled) {
+		t.Errorf("RetryTransientWithJitter() err = %v, want transient error wrapping %v", err, errThrottled)
+	}
+}
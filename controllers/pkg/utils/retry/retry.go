@@ -17,6 +17,8 @@ package retry
 import (
 	"fmt"
 	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 func Retry(tryTimes int, trySleepTime time.Duration, action func() error) error {
@@ -29,5 +31,36 @@ func Retry(tryTimes int, trySleepTime time.Duration, action func() error) error
 
 		time.Sleep(trySleepTime * time.Duration(2*i+1))
 	}
-	return fmt.Errorf("retry action timeout: %v", err)
+	return fmt.Errorf("retry action timeout: %w", err)
+}
+
+// RetryTransient retries the action only if it fails with a transient error, other errors are returned immediately
+func RetryTransient(tryTimes int, trySleepTime time.Duration, action func() error) error {
+	var err error
+	for i := 0; i < tryTimes; i++ {
+		err = action()
+		if err == nil || !IsTransient(err) {
+			return err
+		}
+
+		time.Sleep(trySleepTime * time.Duration(2*i+1))
+	}
+	return fmt.Errorf("retry action timeout: %w", err)
+}
+
+// RetryTransientWithJitter retries the action only if it fails with a transient error like RetryTransient, the backoff
+// is jittered by up to jitter times of it, so the retries of many callers failed at once don't hit the server together.
+// There is no backoff after the last try.
+func RetryTransientWithJitter(tryTimes int, trySleepTime time.Duration, jitter float64, action func() error) error {
+	var err error
+	for i := 0; i < tryTimes; i++ {
+		err = action()
+		if err == nil || !IsTransient(err) {
+			return err
+		}
+		if i < tryTimes-1 {
+			time.Sleep(wait.Jitter(trySleepTime*time.Duration(2*i+1), jitter))
+		}
+	}
+	return fmt.Errorf("retry action timeout: %w", err)
 }
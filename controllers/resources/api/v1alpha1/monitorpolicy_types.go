@@ -0,0 +1,81 @@
+/*
+Copyright 2024 sealos.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MeteredResource a resource metered by the monitor, gpu stands for the gpus of all models
+// +kubebuilder:validation:Enum=cpu;memory;storage;network;services.nodeports;services.loadbalancers.ports;services.loadbalancers.ips;gpu
+type MeteredResource string
+
+const (
+	MeteredResourceCPU               MeteredResource = "cpu"
+	MeteredResourceMemory            MeteredResource = "memory"
+	MeteredResourceStorage           MeteredResource = "storage"
+	MeteredResourceNetwork           MeteredResource = "network"
+	MeteredResourceNodePorts         MeteredResource = "services.nodeports"
+	MeteredResourceLoadBalancerPorts MeteredResource = "services.loadbalancers.ports"
+	MeteredResourceLoadBalancerIPs   MeteredResource = "services.loadbalancers.ips"
+	MeteredResourceGPU               MeteredResource = "gpu"
+)
+
+// MonitorPolicySpec defines how the resources of the selected namespaces are metered, overriding the global config of
+// the controller
+type MonitorPolicySpec struct {
+	// NamespaceSelector selects the namespaces of the policy by their labels, no namespace is selected if not set
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Disabled stops metering the namespace
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+	// Resources the resources metered, all resources are metered if empty
+	// +optional
+	Resources []MeteredResource `json:"resources,omitempty"`
+	// Interval the metering interval, a multiple of the minute dividing the hour, eg: 5m.
+	// The usage metered at the start of an interval stands for the whole interval. Every minute if not set.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Disabled",type=boolean,JSONPath=`.spec.disabled`
+//+kubebuilder:printcolumn:name="Interval",type=string,JSONPath=`.spec.interval`
+
+// MonitorPolicy is the Schema for the monitorpolicies API, cluster scoped so the tenants can't change the metering of
+// their own namespaces. A namespace uses at most one policy.
+type MonitorPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MonitorPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MonitorPolicyList contains a list of MonitorPolicy
+type MonitorPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MonitorPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MonitorPolicy{}, &MonitorPolicyList{})
+}
@@ -0,0 +1,160 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// monitor-export exports the raw monitors of a period as csv or parquet to a local file or to a bucket of the object
+// storage, the monitor database and the object storage are configured by the envs of the resources controller.
+//
+//	monitor-export --start 2024-01-01T00:00:00Z --end 2024-02-01T00:00:00Z --format parquet --output s3://finance/2024-01.parquet
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/labring/sealos/controllers/pkg/database/export"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+	"github.com/labring/sealos/controllers/resources/controllers"
+)
+
+// uploadPartSize bounds the memory of an upload of the unknown size, minio buffers a part at a time
+const uploadPartSize = 16 << 20
+
+func main() {
+	var start, end, category, format, output string
+	flag.StringVar(&start, "start", "", "The start of the period in RFC3339, inclusive.")
+	flag.StringVar(&end, "end", "", "The end of the period in RFC3339, exclusive.")
+	flag.StringVar(&category, "category", "", "Export the monitors of the namespace only.")
+	flag.StringVar(&format, "format", string(export.FormatCSV), "The format of the export: csv or parquet.")
+	flag.StringVar(&output, "output", "-", "The local path, s3://bucket/key to upload to the object storage (MINIO_ENDPOINT), or - for stdout.")
+	flag.Parse()
+
+	if err := run(start, end, category, format, output); err != nil {
+		fmt.Fprintln(os.Stderr, "monitor-export:", err)
+		os.Exit(1)
+	}
+}
+
+func run(start, end, category, format, output string) error {
+	opts := export.Options{Category: category}
+	var err error
+	if opts.Start, err = time.Parse(time.RFC3339, start); err != nil {
+		return fmt.Errorf("invalid start time: %w", err)
+	}
+	if opts.End, err = time.Parse(time.RFC3339, end); err != nil {
+		return fmt.Errorf("invalid end time: %w", err)
+	}
+	if opts.Format, err = export.ParseFormat(format); err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	store, err := controllers.NewMonitorDBClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect the monitor database: %w", err)
+	}
+	defer func() {
+		_ = store.Disconnect(context.Background())
+	}()
+	if err := store.InitDefaultPropertyTypeLS(); err != nil {
+		return fmt.Errorf("failed to get the property types: %w", err)
+	}
+
+	var rows int64
+	switch {
+	case output == "-":
+		rows, err = export.Export(ctx, store, os.Stdout, opts)
+	case strings.HasPrefix(output, "s3://"):
+		rows, err = exportToObjectStorage(ctx, strings.TrimPrefix(output, "s3://"), func(w io.Writer) (int64, error) {
+			return export.Export(ctx, store, w, opts)
+		})
+	default:
+		rows, err = exportToFile(output, func(w io.Writer) (int64, error) {
+			return export.Export(ctx, store, w, opts)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d rows to %s\n", rows, output)
+	return nil
+}
+
+// exportToFile removes the partial file of a failed export
+func exportToFile(path string, write func(w io.Writer) (int64, error)) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := write(f)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return rows, err
+}
+
+// exportToObjectStorage streams the export to the object of bucket/key, the object is not created if the export fails
+func exportToObjectStorage(ctx context.Context, target string, write func(w io.Writer) (int64, error)) (int64, error) {
+	bucket, key, ok := strings.Cut(target, "/")
+	if !ok || bucket == "" || key == "" {
+		return 0, fmt.Errorf("invalid output s3://%s, must be s3://bucket/key", target)
+	}
+	endpoint := os.Getenv(controllers.MinioEndpoint)
+	if endpoint == "" {
+		return 0, fmt.Errorf("the object storage is not configured, please check env: %s", controllers.MinioEndpoint)
+	}
+	load := controllers.CredentialsLoader(controllers.EnvCredentialsLoader)
+	if dir := os.Getenv(controllers.MinioCredentialsDir); dir != "" {
+		load = controllers.FileCredentialsLoader(dir)
+	}
+	client, err := controllers.NewObjStorageClient(endpoint, env.GetBoolEnvWithDefault(controllers.MinioSecure, false), load)
+	if err != nil {
+		return 0, fmt.Errorf("failed to init the object storage client: %w", err)
+	}
+
+	type result struct {
+		rows int64
+		err  error
+	}
+	pr, pw := io.Pipe()
+	done := make(chan result, 1)
+	go func() {
+		rows, err := write(pw)
+		// the upload is aborted by the error, so a partial export is never stored
+		_ = pw.CloseWithError(err)
+		done <- result{rows: rows, err: err}
+	}()
+	_, err = client.Client().PutObject(ctx, bucket, key, pr, -1, minio.PutObjectOptions{PartSize: uploadPartSize})
+	// unblocks the export if the upload failed first
+	_ = pr.CloseWithError(err)
+	res := <-done
+	if res.err != nil {
+		return res.rows, res.err
+	}
+	if err != nil {
+		return res.rows, fmt.Errorf("failed to upload the export: %w", err)
+	}
+	return res.rows, nil
+}
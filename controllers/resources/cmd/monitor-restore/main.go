@@ -0,0 +1,88 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// monitor-restore restores the monitors of a period from an archive of the monitor retention into the monitor
+// database, the monitor database and the object storage are configured by the envs of the resources controller.
+//
+//	monitor-restore --start 2024-01-01T00:00:00Z --end 2024-01-02T00:00:00Z --archive s3://archive/monitors/20240101.jsonl.gz
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+	"github.com/labring/sealos/controllers/resources/controllers"
+)
+
+func main() {
+	var start, end, source string
+	flag.StringVar(&start, "start", "", "The start of the period in RFC3339, inclusive.")
+	flag.StringVar(&end, "end", "", "The end of the period in RFC3339, exclusive.")
+	flag.StringVar(&source, "archive", "", "The archive to restore, s3://bucket/key of the object storage (MINIO_ENDPOINT).")
+	flag.Parse()
+
+	if err := run(start, end, source); err != nil {
+		fmt.Fprintln(os.Stderr, "monitor-restore:", err)
+		os.Exit(1)
+	}
+}
+
+func run(start, end, source string) error {
+	startTime, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return fmt.Errorf("invalid start time: %w", err)
+	}
+	endTime, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return fmt.Errorf("invalid end time: %w", err)
+	}
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(source, "s3://"), "/")
+	if !strings.HasPrefix(source, "s3://") || !ok || bucket == "" || key == "" {
+		return fmt.Errorf("invalid archive %q, must be s3://bucket/key", source)
+	}
+	endpoint := os.Getenv(controllers.MinioEndpoint)
+	if endpoint == "" {
+		return fmt.Errorf("the object storage is not configured, please check env: %s", controllers.MinioEndpoint)
+	}
+	load := controllers.CredentialsLoader(controllers.EnvCredentialsLoader)
+	if dir := os.Getenv(controllers.MinioCredentialsDir); dir != "" {
+		load = controllers.FileCredentialsLoader(dir)
+	}
+	client, err := controllers.NewObjStorageClient(endpoint, env.GetBoolEnvWithDefault(controllers.MinioSecure, false), load)
+	if err != nil {
+		return fmt.Errorf("failed to init the object storage client: %w", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	store, err := controllers.NewMonitorDBClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect the monitor database: %w", err)
+	}
+	defer func() {
+		_ = store.Disconnect(context.Background())
+	}()
+	restored, err := controllers.RestoreMonitorArchive(ctx, client, bucket, key, store, startTime, endTime)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "restored %d monitors from %s\n", restored, source)
+	return nil
+}
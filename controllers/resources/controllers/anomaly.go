@@ -0,0 +1,187 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// UsageAnomalyFactor flags a namespace resource whose usage jumps beyond factor * the rolling average, disabled if <= 1
+	UsageAnomalyFactor = "USAGE_ANOMALY_FACTOR"
+	// UsageAnomalyWindow the number of the previous cycles in the rolling history, default 10
+	UsageAnomalyWindow = "USAGE_ANOMALY_WINDOW"
+
+	DefaultUsageAnomalyWindow = 10
+)
+
+// labeled by the resource only, the namespace is logged to keep the cardinality bounded
+var usageAnomalies = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sealos_resources_usage_anomalies_total",
+	Help: "Number of the namespace resource usage jumps beyond the anomaly factor of the rolling average.",
+}, []string{"resource"})
+
+func init() {
+	metrics.Registry.MustRegister(usageAnomalies)
+}
+
+// usageAnomaly a resource of the namespace jumped beyond the factor
+type usageAnomaly struct {
+	Enum    uint8
+	Average float64
+	Current int64
+}
+
+// usageHistory the rolling usage of a resource, a ring buffer of the last window cycles
+type usageHistory struct {
+	values []int64
+	next   int
+	full   bool
+}
+
+func (h *usageHistory) average() (float64, bool) {
+	n := h.next
+	if h.full {
+		n = len(h.values)
+	}
+	if n == 0 {
+		return 0, false
+	}
+	var sum int64
+	for _, v := range h.values[:n] {
+		sum += v
+	}
+	return float64(sum) / float64(n), true
+}
+
+func (h *usageHistory) add(v int64) {
+	h.values[h.next] = v
+	h.next = (h.next + 1) % len(h.values)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// anomalyDetector compares the namespace usage of each cycle with the rolling history of the namespace.
+// The history is only kept in memory and starts empty after a restart.
+type anomalyDetector struct {
+	factor float64
+	window int
+
+	mu      sync.Mutex
+	history map[string]map[uint8]*usageHistory
+}
+
+func newAnomalyDetector(factor float64, window int) *anomalyDetector {
+	if window <= 0 {
+		window = DefaultUsageAnomalyWindow
+	}
+	return &anomalyDetector{factor: factor, window: window, history: make(map[string]map[uint8]*usageHistory)}
+}
+
+// newAnomalyDetectorFromEnv returns nil if the anomaly factor is not set
+func newAnomalyDetectorFromEnv() (*anomalyDetector, error) {
+	raw := os.Getenv(UsageAnomalyFactor)
+	if raw == "" {
+		return nil, nil
+	}
+	factor, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", UsageAnomalyFactor, raw, err)
+	}
+	if factor <= 1 {
+		return nil, nil
+	}
+	return newAnomalyDetector(factor, int(env.GetInt64EnvWithDefault(UsageAnomalyWindow, DefaultUsageAnomalyWindow))), nil
+}
+
+// observe records the usage of the namespace and returns the resources jumped beyond the factor of the average.
+// The resources without a full history window are not flagged, so new workloads are not reported.
+func (d *anomalyDetector) observe(namespace string, used map[uint8]int64) []usageAnomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	nsHistory := d.history[namespace]
+	if nsHistory == nil {
+		nsHistory = make(map[uint8]*usageHistory)
+		d.history[namespace] = nsHistory
+	}
+	var anomalies []usageAnomaly
+	for enum, h := range nsHistory {
+		// the resource disappeared in this cycle, record the zero usage
+		if _, ok := used[enum]; !ok {
+			h.add(0)
+		}
+	}
+	for enum, current := range used {
+		h := nsHistory[enum]
+		if h == nil {
+			h = &usageHistory{values: make([]int64, d.window)}
+			nsHistory[enum] = h
+		}
+		if avg, ok := h.average(); ok && h.full && avg > 0 && float64(current) > avg*d.factor {
+			anomalies = append(anomalies, usageAnomaly{Enum: enum, Average: avg, Current: current})
+		}
+		h.add(current)
+	}
+	return anomalies
+}
+
+// forget drops the history of the namespaces not in the active set
+func (d *anomalyDetector) forget(active map[string]struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for namespace := range d.history {
+		if _, ok := active[namespace]; !ok {
+			delete(d.history, namespace)
+		}
+	}
+}
+
+func namespaceNames(namespaces []corev1.Namespace) map[string]struct{} {
+	names := make(map[string]struct{}, len(namespaces))
+	for i := range namespaces {
+		names[namespaces[i].Name] = struct{}{}
+	}
+	return names
+}
+
+// detectUsageAnomalies sums the usage of the monitors of the namespace and reports the jumps
+func (r *MonitorReconciler) detectUsageAnomalies(namespace string, monitors []*resources.Monitor) {
+	if r.anomalyDetector == nil {
+		return
+	}
+	properties := r.properties()
+	for _, anomaly := range r.anomalyDetector.observe(namespace, sumMonitorsUsed(monitors)) {
+		resourceName := strconv.Itoa(int(anomaly.Enum))
+		if properties != nil {
+			if pType, ok := properties.EnumMap[anomaly.Enum]; ok {
+				resourceName = pType.Name
+			}
+		}
+		usageAnomalies.WithLabelValues(resourceName).Inc()
+		r.Logger.Info("usage anomaly detected", "namespace", namespace, "resource", resourceName,
+			"current", anomaly.Current, "average", anomaly.Average, "factor", r.anomalyDetector.factor)
+	}
+}
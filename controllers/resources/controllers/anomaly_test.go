@@ -0,0 +1,86 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+)
+
+func TestAnomalyDetector_Observe(t *testing.T) {
+	const cpu, memory uint8 = 0, 1
+	d := newAnomalyDetector(50, 3)
+	// the window is not full yet, a jump is not flagged
+	for i, used := range []int64{100, 100, 6000} {
+		if anomalies := d.observe("ns-a", map[uint8]int64{cpu: used, memory: 1024}); len(anomalies) != 0 {
+			t.Fatalf("cycle %d: observe() = %v, want no anomaly before the window is full", i, anomalies)
+		}
+	}
+	// the average of (100, 100, 6000) is 2066, 50x is not reached
+	if anomalies := d.observe("ns-a", map[uint8]int64{cpu: 100000, memory: 1024}); len(anomalies) != 0 {
+		t.Fatalf("observe() = %v, want no anomaly", anomalies)
+	}
+	for _, used := range []int64{100, 100, 100} {
+		d.observe("ns-a", map[uint8]int64{cpu: used, memory: 1024})
+	}
+	anomalies := d.observe("ns-a", map[uint8]int64{cpu: 5001, memory: 1024})
+	if len(anomalies) != 1 || anomalies[0].Enum != cpu || anomalies[0].Average != 100 || anomalies[0].Current != 5001 {
+		t.Fatalf("observe() = %+v, want the cpu jump from 100 to 5001", anomalies)
+	}
+	// other namespaces have their own history
+	if anomalies := d.observe("ns-b", map[uint8]int64{cpu: 1 << 30}); len(anomalies) != 0 {
+		t.Errorf("observe(ns-b) = %v, want no anomaly", anomalies)
+	}
+}
+
+func TestAnomalyDetector_ZeroAverage(t *testing.T) {
+	d := newAnomalyDetector(2, 2)
+	d.observe("ns-a", map[uint8]int64{0: 100})
+	// the resource is gone for 2 cycles, the average drops to 0 and the restart is not flagged
+	d.observe("ns-a", map[uint8]int64{})
+	d.observe("ns-a", map[uint8]int64{})
+	if anomalies := d.observe("ns-a", map[uint8]int64{0: 100}); len(anomalies) != 0 {
+		t.Errorf("observe() = %v, want no anomaly on a zero average", anomalies)
+	}
+}
+
+func TestAnomalyDetector_Forget(t *testing.T) {
+	d := newAnomalyDetector(2, 1)
+	d.observe("ns-a", map[uint8]int64{0: 1})
+	d.observe("ns-b", map[uint8]int64{0: 1})
+	d.forget(map[string]struct{}{"ns-b": {}})
+	if _, ok := d.history["ns-a"]; ok {
+		t.Error("history of the deleted namespace ns-a is kept")
+	}
+	if anomalies := d.observe("ns-b", map[uint8]int64{0: 3}); len(anomalies) != 1 {
+		t.Errorf("observe(ns-b) = %v, want 1 anomaly", anomalies)
+	}
+}
+
+func TestNewAnomalyDetectorFromEnv(t *testing.T) {
+	t.Setenv(UsageAnomalyFactor, "")
+	if d, err := newAnomalyDetectorFromEnv(); err != nil || d != nil {
+		t.Errorf("newAnomalyDetectorFromEnv() = %v, %v, want disabled", d, err)
+	}
+	t.Setenv(UsageAnomalyFactor, "x")
+	if _, err := newAnomalyDetectorFromEnv(); err == nil {
+		t.Error("newAnomalyDetectorFromEnv() expected error")
+	}
+	t.Setenv(UsageAnomalyFactor, "50")
+	t.Setenv(UsageAnomalyWindow, "5")
+	d, err := newAnomalyDetectorFromEnv()
+	if err != nil || d == nil || d.factor != 50 || d.window != 5 {
+		t.Errorf("newAnomalyDetectorFromEnv() = %+v, %v, want factor 50 window 5", d, err)
+	}
+}
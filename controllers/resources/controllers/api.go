@@ -0,0 +1,210 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	MonitorExportPath = "/api/v1/monitors/export"
+	// ObjStorageUsagePath returns the per bucket object storage usage of a user
+	ObjStorageUsagePath = "/api/v1/objectstorage/usage"
+	// GpuModelsPath returns the gpu of the nodes detected by the controller, for debugging the gpu billing
+	GpuModelsPath = "/api/v1/admin/gpu-models"
+	// flush the csv rows to the client every exportFlushRows rows
+	exportFlushRows = 1000
+)
+
+var monitorExportHeader = []string{"time", "type", "name", "resource", "used"}
+
+// RegisterHandlers registers the http api of the monitor reconciler, every endpoint requires the admin token since
+// the api serves the usage of any namespace
+func (r *MonitorReconciler) RegisterHandlers(mux *http.ServeMux) error {
+	if r.adminToken == "" {
+		return fmt.Errorf("the api server requires %s, the usage must not be served without authentication", AdminToken)
+	}
+	mux.Handle(MonitorExportPath, requireAdminToken(r.adminToken, http.HandlerFunc(r.exportMonitorsHandler)))
+	mux.Handle(ObjStorageUsagePath, requireAdminToken(r.adminToken, http.HandlerFunc(r.objStorageUsageHandler)))
+	mux.Handle(GpuModelsPath, requireAdminToken(r.adminToken, http.HandlerFunc(r.gpuModelsHandler)))
+	mux.Handle(DualWriteVerifyPath, requireAdminToken(r.adminToken, http.HandlerFunc(r.dualWriteVerifyHandler)))
+	if r.pprofToken != "" {
+		registerPprofHandlers(mux, r.pprofToken)
+	}
+	return nil
+}
+
+// parseTimeRange parses the RFC3339 start and end of the query, start must be before end
+func parseTimeRange(req *http.Request) (time.Time, time.Time, error) {
+	query := req.URL.Query()
+	startTime, err := time.Parse(time.RFC3339, query.Get("start"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start time: %v", err)
+	}
+	endTime, err := time.Parse(time.RFC3339, query.Get("end"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end time: %v", err)
+	}
+	if !startTime.Before(endTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start time must be before end time")
+	}
+	return startTime, endTime, nil
+}
+
+// exportMonitorsHandler streams the monitors of the namespace as csv.
+// eg: GET /api/v1/monitors/export?namespace=ns-xxx&start=2024-01-01T00:00:00Z&end=2024-01-02T00:00:00Z
+func (r *MonitorReconciler) exportMonitorsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	namespace := query.Get("namespace")
+	if namespace == "" {
+		http.Error(w, "namespace is required", http.StatusBadRequest)
+		return
+	}
+	startTime, endTime, err := parseTimeRange(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s-%s.csv", namespace, startTime.Format("20060102150405"), endTime.Format("20060102150405"))))
+	cw := csv.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	if err := cw.Write(monitorExportHeader); err != nil {
+		r.Logger.Error(err, "failed to write csv header")
+		return
+	}
+	rows := 0
+	err = r.DBClient.QueryMonitors(req.Context(), namespace, startTime, endTime, func(monitor *resources.Monitor) error {
+		for _, record := range r.monitorCSVRecords(monitor) {
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+			if rows++; rows%exportFlushRows == 0 {
+				cw.Flush()
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+		return cw.Error()
+	})
+	cw.Flush()
+	if err != nil {
+		// the header is already sent, the error can only be logged
+		r.Logger.Error(err, "failed to export monitors", "namespace", namespace, "start", startTime, "end", endTime)
+	}
+}
+
+// monitorCSVRecords returns one record per resource of the monitor, sorted by the resource enum
+func (r *MonitorReconciler) monitorCSVRecords(monitor *resources.Monitor) [][]string {
+	enums := make([]int, 0, len(monitor.Used))
+	for enum := range monitor.Used {
+		enums = append(enums, int(enum))
+	}
+	sort.Ints(enums)
+	records := make([][]string, 0, len(enums))
+	properties := r.properties()
+	for _, enum := range enums {
+		resourceName := strconv.Itoa(enum)
+		if pType, ok := properties.EnumMap[uint8(enum)]; ok {
+			resourceName = pType.Name
+		}
+		records = append(records, []string{
+			monitor.Time.UTC().Format(time.RFC3339),
+			resources.AppTypeReverse[monitor.Type],
+			monitor.Name,
+			resourceName,
+			strconv.FormatInt(monitor.Used[uint8(enum)], 10),
+		})
+	}
+	return records
+}
+
+// objStorageUsageHandler returns the per bucket usage of the user as json, the resources are named by the properties.
+// eg: GET /api/v1/objectstorage/usage?user=xxx&start=2024-01-01T00:00:00Z&end=2024-02-01T00:00:00Z
+func (r *MonitorReconciler) objStorageUsageHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := req.URL.Query().Get("user")
+	if user == "" {
+		http.Error(w, "user is required", http.StatusBadRequest)
+		return
+	}
+	startTime, endTime, err := parseTimeRange(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	usage, err := r.DBClient.GetObjectStorageUsage(user, startTime, endTime)
+	if err != nil {
+		r.Logger.Error(err, "failed to get object storage usage", "user", user, "start", startTime, "end", endTime)
+		http.Error(w, "failed to get object storage usage", http.StatusInternalServerError)
+		return
+	}
+	buckets := make([]objStorageBucketUsage, 0, len(usage))
+	for i := range usage {
+		buckets = append(buckets, objStorageBucketUsage{
+			Bucket:    usage[i].Bucket,
+			Used:      r.namedUsed(usage[i].Used),
+			FirstSeen: usage[i].FirstSeen,
+			LastSeen:  usage[i].LastSeen,
+			Detail:    usage[i].Detail,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		User    string                  `json:"user"`
+		Buckets []objStorageBucketUsage `json:"buckets"`
+	}{User: user, Buckets: buckets}); err != nil {
+		r.Logger.Error(err, "failed to write object storage usage", "user", user)
+	}
+}
+
+// objStorageBucketUsage the bucket usage with the resource names instead of the enums
+type objStorageBucketUsage struct {
+	Bucket    string                      `json:"bucket"`
+	Used      map[string]int64            `json:"used"`
+	FirstSeen time.Time                   `json:"first_seen"`
+	LastSeen  time.Time                   `json:"last_seen"`
+	Detail    *resources.ObjStorageDetail `json:"detail,omitempty"`
+}
+
+func (r *MonitorReconciler) namedUsed(used resources.EnumUsedMap) map[string]int64 {
+	named := make(map[string]int64, len(used))
+	properties := r.properties()
+	for enum, v := range used {
+		resourceName := strconv.Itoa(int(enum))
+		if pType, ok := properties.EnumMap[enum]; ok {
+			resourceName = pType.Name
+		}
+		named[resourceName] = v
+	}
+	return named
+}
@@ -0,0 +1,245 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/gpu"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// fakeMonitorDB only implements the monitor query of database.Interface
+type fakeMonitorDB struct {
+	database.Interface
+	monitors []*resources.Monitor
+}
+
+func (f *fakeMonitorDB) QueryMonitors(_ context.Context, namespace string, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	for _, monitor := range f.monitors {
+		if monitor.Category != namespace || monitor.Time.Before(startTime) || !monitor.Time.Before(endTime) {
+			continue
+		}
+		if err := handle(monitor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeMonitorDB) GetObjectStorageUsage(user string, startTime, endTime time.Time) ([]resources.ObjStorageBucketUsage, error) {
+	usages := map[string]*resources.ObjStorageBucketUsage{}
+	var order []string
+	err := f.QueryMonitors(context.Background(), "ns-"+user, startTime, endTime, func(monitor *resources.Monitor) error {
+		if monitor.Type != resources.AppType[resources.ObjectStorage] {
+			return nil
+		}
+		usage, ok := usages[monitor.Name]
+		if !ok {
+			usage = &resources.ObjStorageBucketUsage{Bucket: monitor.Name, Used: resources.EnumUsedMap{}, FirstSeen: monitor.Time}
+			usages[monitor.Name] = usage
+			order = append(order, monitor.Name)
+		}
+		for enum, v := range monitor.Used {
+			usage.Used[enum] += v
+		}
+		usage.LastSeen, usage.Detail = monitor.Time, monitor.ObjStorage
+		return nil
+	})
+	result := make([]resources.ObjStorageBucketUsage, 0, len(order))
+	for _, name := range order {
+		result = append(result, *usages[name])
+	}
+	return result, err
+}
+
+// newAdminRequest returns a request with the admin token "secret"
+func newAdminRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
+
+func TestMonitorReconciler_RegisterHandlers(t *testing.T) {
+	if err := (&MonitorReconciler{}).RegisterHandlers(http.NewServeMux()); err == nil {
+		t.Error("RegisterHandlers() without the admin token error = nil, want the api refused")
+	}
+	r := &MonitorReconciler{Logger: logr.Discard(), DBClient: &fakeMonitorDB{}, Properties: resources.DefaultPropertyTypeLS, adminToken: "secret"}
+	mux := http.NewServeMux()
+	if err := r.RegisterHandlers(mux); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{MonitorExportPath, ObjStorageUsagePath, GpuModelsPath, DualWriteVerifyPath} {
+		for _, authorization := range []string{"", "Bearer wrong", "secret"} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%s with Authorization %q status = %d, want %d", path, authorization, w.Code, http.StatusUnauthorized)
+			}
+		}
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAdminRequest(http.MethodGet, GpuModelsPath, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("%s with the admin token status = %d, want %d", GpuModelsPath, w.Code, http.StatusOK)
+	}
+}
+
+func TestMonitorReconciler_exportMonitorsHandler(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := &fakeMonitorDB{monitors: []*resources.Monitor{
+		{Category: "ns-test", Time: start, Type: resources.AppType[resources.APP], Name: "nginx", Used: resources.EnumUsedMap{0: 100, 1: 256}},
+		{Category: "ns-test", Time: start.Add(time.Minute), Type: resources.AppType[resources.DB], Name: "mongo", Used: resources.EnumUsedMap{2: 1024}},
+		{Category: "ns-other", Time: start, Type: resources.AppType[resources.APP], Name: "other", Used: resources.EnumUsedMap{0: 1}},
+		{Category: "ns-test", Time: start.Add(time.Hour), Type: resources.AppType[resources.APP], Name: "late", Used: resources.EnumUsedMap{0: 1}},
+	}}
+	r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, Properties: resources.DefaultPropertyTypeLS, adminToken: "secret"}
+	mux := http.NewServeMux()
+	if err := r.RegisterHandlers(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	req := newAdminRequest(http.MethodGet, MonitorExportPath+"?namespace=ns-test&start=2024-01-01T00:00:00Z&end=2024-01-01T00:30:00Z", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export status = %v, body: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("export content type = %v, want text/csv", ct)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read csv: %v", err)
+	}
+	want := [][]string{
+		monitorExportHeader,
+		{"2024-01-01T00:00:00Z", resources.APP, "nginx", "cpu", "100"},
+		{"2024-01-01T00:00:00Z", resources.APP, "nginx", "memory", "256"},
+		{"2024-01-01T00:01:00Z", resources.DB, "mongo", "storage", "1024"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("export records = %v, want %v", records, want)
+	}
+
+	for _, query := range []string{"?start=2024-01-01T00:00:00Z&end=2024-01-02T00:00:00Z", "?namespace=ns-test&start=bad&end=2024-01-02T00:00:00Z", "?namespace=ns-test&start=2024-01-02T00:00:00Z&end=2024-01-01T00:00:00Z"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newAdminRequest(http.MethodGet, MonitorExportPath+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("export %s status = %v, want %v", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestMonitorReconciler_objStorageUsageHandler(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	objType := resources.AppType[resources.ObjectStorage]
+	detail := &resources.ObjStorageDetail{CreationTime: start.AddDate(0, -1, 0), Region: "us-east-1"}
+	db := &fakeMonitorDB{monitors: []*resources.Monitor{
+		{Category: "ns-user-a", Time: start, Type: objType, Name: "user-a-logs", Used: resources.EnumUsedMap{2: 10, 3: 1}, ObjStorage: detail},
+		{Category: "ns-user-a", Time: start.Add(time.Minute), Type: objType, Name: "user-a-logs", Used: resources.EnumUsedMap{2: 20}, ObjStorage: detail},
+		{Category: "ns-user-a", Time: start, Type: resources.AppType[resources.APP], Name: "nginx", Used: resources.EnumUsedMap{0: 100}},
+	}}
+	r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, Properties: resources.DefaultPropertyTypeLS, adminToken: "secret"}
+	mux := http.NewServeMux()
+	if err := r.RegisterHandlers(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAdminRequest(http.MethodGet, ObjStorageUsagePath+"?user=user-a&start=2024-01-01T00:00:00Z&end=2024-02-01T00:00:00Z", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("usage status = %v, body: %s", w.Code, w.Body.String())
+	}
+	var got struct {
+		User    string                  `json:"user"`
+		Buckets []objStorageBucketUsage `json:"buckets"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode usage: %v", err)
+	}
+	if got.User != "user-a" || len(got.Buckets) != 1 {
+		t.Fatalf("usage = %+v, want the bucket user-a-logs only", got)
+	}
+	bucket := got.Buckets[0]
+	if want := map[string]int64{"storage": 30, "network": 1}; !reflect.DeepEqual(bucket.Used, want) {
+		t.Errorf("bucket used = %v, want %v", bucket.Used, want)
+	}
+	if bucket.Detail == nil || bucket.Detail.Region != "us-east-1" || !bucket.LastSeen.Equal(start.Add(time.Minute)) {
+		t.Errorf("bucket = %+v, want the detail and last seen at %v", bucket, start.Add(time.Minute))
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAdminRequest(http.MethodGet, ObjStorageUsagePath+"?start=2024-01-01T00:00:00Z&end=2024-02-01T00:00:00Z", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("usage without user status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestMonitorReconciler_gpuModelsHandler(t *testing.T) {
+	r := &MonitorReconciler{
+		Logger:           logr.Discard(),
+		adminToken:       "secret",
+		GpuReplicasLabel: gpu.NvidiaGpuReplicasKey,
+		NvidiaGpu: map[string]gpu.NvidiaGPU{
+			"node-a": {
+				GpuInfo:    gpu.Information{GpuProduct: "Tesla-T4", GpuCount: "2"},
+				GpuDetails: gpu.DetailInformation{GpuMemory: "15360"},
+				Labels:     map[string]string{gpu.NvidiaGpuReplicasKey: "4"},
+			},
+		},
+	}
+	mux := http.NewServeMux()
+	if err := r.RegisterHandlers(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newAdminRequest(http.MethodGet, GpuModelsPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("gpu models status = %v, body: %s", w.Code, w.Body.String())
+	}
+	var got struct {
+		Nodes map[string]gpuNodeModel `json:"nodes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode gpu models: %v", err)
+	}
+	want := map[string]gpuNodeModel{"node-a": {Product: "Tesla-T4", Count: "2", Memory: "15360", Replicas: 4, ReplicasSource: gpuReplicasFromLabel}}
+	if !reflect.DeepEqual(got.Nodes, want) {
+		t.Errorf("gpu models = %+v, want %+v", got.Nodes, want)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, newAdminRequest(http.MethodPost, GpuModelsPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("gpu models POST status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// BillingReconciliation compares the billings of the previous day of each namespace with its monitors once a day,
+	// default false. The billings are read from BILLING_MONGO_URI, nothing is changed.
+	BillingReconciliation = "BILLING_RECONCILIATION"
+	// BillingReconciliationHour the UTC hour of the daily reconciliation, default 5, once the billings of the day are generated
+	BillingReconciliationHour = "BILLING_RECONCILIATION_HOUR"
+	// BillingReconciliationThreshold the percent of the metered used a billed property may differ by, default 1
+	BillingReconciliationThreshold = "BILLING_RECONCILIATION_THRESHOLD"
+	// BillingReconciliationPeriod the billing period of the account controller, the averaged properties are billed by
+	// the average of each period, default 1h
+	BillingReconciliationPeriod = "BILLING_RECONCILIATION_PERIOD"
+
+	DefaultBillingReconciliationHour      = 5
+	DefaultBillingReconciliationThreshold = 1.0
+	DefaultBillingReconciliationPeriod    = time.Hour
+
+	EventReasonBillingDiscrepancy = "BillingDiscrepancy"
+
+	// billingReconciliationTimeout bounds reconciling the namespaces of a day
+	billingReconciliationTimeout = time.Hour
+)
+
+var (
+	billingReconciliationRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_resources_billing_reconciliation_runs_total",
+		Help: "Number of the daily billing reconciliation runs by the result.",
+	}, []string{"result"})
+	billingDiscrepancies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_resources_billing_discrepancies_total",
+		Help: "Number of the billed properties of the namespaces differing from their monitors beyond the threshold, by the property and the direction.",
+	}, []string{"property", "direction"})
+	billingDiscrepancyNamespaces = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sealos_resources_billing_discrepancy_namespaces",
+		Help: "Number of the namespaces with a billing discrepancy beyond the threshold in the latest billing reconciliation.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(billingReconciliationRuns, billingDiscrepancies, billingDiscrepancyNamespaces)
+}
+
+// BillingDelta the billed used of a property of a namespace against the used its monitors are billed
+type BillingDelta struct {
+	Property string `json:"property"`
+	// Metered the used of the monitors as billed, the averaged properties are averaged per billing period
+	Metered int64 `json:"metered"`
+	Billed  int64 `json:"billed"`
+	// Delta Billed - Metered, negative for an under-charge and positive for an over-charge
+	Delta int64 `json:"delta"`
+	// Exceeded the delta is beyond the threshold
+	Exceeded bool `json:"exceeded"`
+}
+
+// NamespaceBillingReconciliation the properties of a namespace whose billed used differs from the metered
+type NamespaceBillingReconciliation struct {
+	Namespace string `json:"namespace"`
+	// MeteredChecksum and BilledChecksum the sha256 of the compared used by the property, equal if the namespace matches
+	MeteredChecksum string         `json:"meteredChecksum"`
+	BilledChecksum  string         `json:"billedChecksum"`
+	Deltas          []BillingDelta `json:"deltas"`
+}
+
+// exceeded returns whether a delta of the namespace is beyond the threshold
+func (n *NamespaceBillingReconciliation) exceeded() bool {
+	for _, delta := range n.Deltas {
+		if delta.Exceeded {
+			return true
+		}
+	}
+	return false
+}
+
+// BillingReconciliationReport the billings of the namespaces in [WindowStart, WindowEnd) compared with their monitors
+type BillingReconciliationReport struct {
+	WindowStart time.Time `json:"windowStart"`
+	WindowEnd   time.Time `json:"windowEnd"`
+	Namespaces  int       `json:"namespaces"`
+	Matched     int       `json:"matched"`
+	// Exceeded the namespaces with a delta beyond the threshold
+	Exceeded int `json:"exceeded"`
+	// Discrepancies the namespaces not matched, with the deltas within the threshold as well
+	Discrepancies []NamespaceBillingReconciliation `json:"discrepancies"`
+}
+
+// BillingReconciliationOptions how the billings are compared with the monitors
+type BillingReconciliationOptions struct {
+	// Properties the billed properties, the unpriced ones are not billed and not compared
+	Properties *resources.PropertyTypeLS
+	// Period the billing period, the window must be aligned to it
+	Period time.Duration
+	// Threshold the percent of the metered used a billed property may differ by
+	Threshold float64
+}
+
+// ReconcileBillings compares the consumption billings of the namespaces in the closed billing window [start, end) with
+// the used of their monitors by the property, and reports the namespaces not matched. It only reads the monitors and
+// the billings. The monitors are summed as the billing sums them: the summed properties as is, and the averaged ones
+// by the average of each billing period. The billing rounds the averages of each app and period, so a delta within one
+// unit per billed app is not beyond the threshold. The differenced properties (DIF) are not comparable by the sums and
+// are skipped.
+func ReconcileBillings(ctx context.Context, store database.MonitorStore, billings database.BillingReader, namespaces []string,
+	start, end time.Time, opts BillingReconciliationOptions) (*BillingReconciliationReport, error) {
+	if opts.Properties == nil {
+		return nil, fmt.Errorf("the billed properties are required")
+	}
+	if opts.Period <= 0 {
+		return nil, fmt.Errorf("invalid billing period %s", opts.Period)
+	}
+	start, end = start.UTC(), end.UTC()
+	if !start.Before(end) || !start.Truncate(opts.Period).Equal(start) || !end.Truncate(opts.Period).Equal(end) {
+		return nil, fmt.Errorf("invalid billing window [%s, %s): must be closed billing periods of %s",
+			start.Format(time.RFC3339), end.Format(time.RFC3339), opts.Period)
+	}
+	report := &BillingReconciliationReport{WindowStart: start, WindowEnd: end, Namespaces: len(namespaces)}
+	for _, namespace := range namespaces {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		reconciliation, err := reconcileNamespaceBillings(ctx, store, billings, namespace, start, end, opts)
+		if err != nil {
+			return report, err
+		}
+		if len(reconciliation.Deltas) == 0 {
+			report.Matched++
+			continue
+		}
+		if reconciliation.exceeded() {
+			report.Exceeded++
+		}
+		report.Discrepancies = append(report.Discrepancies, *reconciliation)
+	}
+	return report, nil
+}
+
+func reconcileNamespaceBillings(ctx context.Context, store database.MonitorStore, billings database.BillingReader, namespace string,
+	start, end time.Time, opts BillingReconciliationOptions) (*NamespaceBillingReconciliation, error) {
+	used, err := store.GetNamespaceUsage(namespace, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum the monitors of %s: %w", namespace, err)
+	}
+	billed := map[uint8]int64{}
+	apps := int64(0)
+	err = billings.QueryConsumptionBillings(ctx, namespace, start, end, func(billing *resources.Billing) error {
+		for _, cost := range billing.AppCosts {
+			apps++
+			for enum, v := range cost.Used {
+				billed[enum] += v
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the billings of %s: %w", namespace, err)
+	}
+
+	minutes := opts.Period.Minutes()
+	compared := make([]resources.PropertyType, 0, len(opts.Properties.EnumMap))
+	for _, property := range opts.Properties.EnumMap {
+		if property.UnitPrice > 0 && property.PriceType != resources.DIF {
+			compared = append(compared, property)
+		}
+	}
+	sort.Slice(compared, func(i, j int) bool { return compared[i].Enum < compared[j].Enum })
+	metered := make(map[uint8]int64, len(compared))
+	for _, property := range compared {
+		metered[property.Enum] = used[property.Enum]
+		if property.PriceType != resources.SUM {
+			metered[property.Enum] = int64(math.Round(float64(used[property.Enum]) / minutes))
+		}
+	}
+	reconciliation := &NamespaceBillingReconciliation{
+		Namespace:       namespace,
+		MeteredChecksum: usedChecksum(compared, metered),
+		BilledChecksum:  usedChecksum(compared, billed),
+	}
+	if reconciliation.MeteredChecksum == reconciliation.BilledChecksum {
+		return reconciliation, nil
+	}
+	for _, property := range compared {
+		delta := billed[property.Enum] - metered[property.Enum]
+		if delta == 0 {
+			continue
+		}
+		tolerance := opts.Threshold / 100 * float64(metered[property.Enum])
+		if property.PriceType != resources.SUM && float64(apps) > tolerance {
+			tolerance = float64(apps)
+		}
+		reconciliation.Deltas = append(reconciliation.Deltas, BillingDelta{
+			Property: property.Name,
+			Metered:  metered[property.Enum],
+			Billed:   billed[property.Enum],
+			Delta:    delta,
+			Exceeded: math.Abs(float64(delta)) > tolerance,
+		})
+	}
+	return reconciliation, nil
+}
+
+// usedChecksum returns the sha256 of the used of the properties in their order
+func usedChecksum(properties []resources.PropertyType, used map[uint8]int64) string {
+	hash := sha256.New()
+	for _, property := range properties {
+		_, _ = fmt.Fprintf(hash, "%d=%d\n", property.Enum, used[property.Enum])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// billingReconciliation reconciles the billings of the previous UTC day once a day at the hour
+type billingReconciliation struct {
+	hour      int
+	period    time.Duration
+	threshold float64
+	clock     clock.Clock
+	// elected is closed when the replica becomes the leader, only the leader reconciles the billings
+	elected <-chan struct{}
+	// billings and recorder are set by SetupBillingReconciliation
+	billings database.BillingReader
+	recorder record.EventRecorder
+}
+
+// newBillingReconciliationFromEnv returns nil if the reconciliation is disabled
+func newBillingReconciliationFromEnv(elected <-chan struct{}) (*billingReconciliation, error) {
+	if !env.GetBoolEnvWithDefault(BillingReconciliation, false) {
+		return nil, nil
+	}
+	hour := env.GetInt64EnvWithDefault(BillingReconciliationHour, DefaultBillingReconciliationHour)
+	if hour < 0 || hour > 23 {
+		return nil, fmt.Errorf("invalid %s %d: must be in [0, 23]", BillingReconciliationHour, hour)
+	}
+	threshold := DefaultBillingReconciliationThreshold
+	if raw := os.Getenv(BillingReconciliationThreshold); raw != "" {
+		var err error
+		if threshold, err = strconv.ParseFloat(raw, 64); err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid %s %q: must be a non-negative percent", BillingReconciliationThreshold, raw)
+		}
+	}
+	period, err := env.GetDurationEnvWithDefault(BillingReconciliationPeriod, DefaultBillingReconciliationPeriod)
+	if err != nil {
+		return nil, err
+	}
+	if period < time.Minute || (24*time.Hour)%period != 0 {
+		return nil, fmt.Errorf("invalid %s %s: must be at least 1m and divide a day", BillingReconciliationPeriod, period)
+	}
+	return &billingReconciliation{hour: int(hour), period: period, threshold: threshold, clock: clock.RealClock{}, elected: elected}, nil
+}
+
+// SetupBillingReconciliation sets the billings compared with the monitors and the recorder of the discrepancy events,
+// it's required if the billing reconciliation is enabled
+func (r *MonitorReconciler) SetupBillingReconciliation(billings database.BillingReader, recorder record.EventRecorder) error {
+	if r.billingReconciliation == nil {
+		return nil
+	}
+	if billings == nil {
+		return fmt.Errorf("%s requires the billings of env: %s", BillingReconciliation, database.BillingMongoURI)
+	}
+	r.billingReconciliation.billings, r.billingReconciliation.recorder = billings, recorder
+	return nil
+}
+
+// BillingReconciliationEnabled returns whether the billings are reconciled, see SetupBillingReconciliation
+func (r *MonitorReconciler) BillingReconciliationEnabled() bool {
+	return r.billingReconciliation != nil
+}
+
+// nextRun returns the next reconciliation hour after now
+func (b *billingReconciliation) nextRun(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), b.hour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (r *MonitorReconciler) startBillingReconciliation() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if r.billingReconciliation.elected != nil {
+			select {
+			case <-r.billingReconciliation.elected:
+			case <-r.stopCh:
+				return
+			}
+		}
+		for {
+			now := r.billingReconciliation.clock.Now()
+			select {
+			case <-r.billingReconciliation.clock.After(r.billingReconciliation.nextRun(now).Sub(now)):
+				if _, err := r.reconcileBillings(r.billingReconciliation.clock.Now()); err != nil {
+					r.Logger.Error(err, "failed to reconcile the billings")
+				}
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// reconcileBillings reconciles the billings of the tenant namespaces of the previous UTC day of now, the namespaces
+// deleted since are not reconciled. The properties beyond the threshold are counted and raise a warning event on the
+// namespace.
+func (r *MonitorReconciler) reconcileBillings(now time.Time) (*BillingReconciliationReport, error) {
+	namespaceList, err := r.getNamespaceList()
+	if err != nil {
+		billingReconciliationRuns.WithLabelValues("failure").Inc()
+		return nil, fmt.Errorf("failed to list the namespaces: %w", err)
+	}
+	namespaces := make(map[string]*corev1.Namespace, len(namespaceList.Items))
+	names := make([]string, 0, len(namespaceList.Items))
+	for i := range namespaceList.Items {
+		namespaces[namespaceList.Items[i].Name] = &namespaceList.Items[i]
+		names = append(names, namespaceList.Items[i].Name)
+	}
+	end := now.UTC().Truncate(24 * time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), billingReconciliationTimeout)
+	defer cancel()
+	report, err := ReconcileBillings(ctx, r.DBClient, r.billingReconciliation.billings, names, end.AddDate(0, 0, -1), end,
+		BillingReconciliationOptions{Properties: r.properties(), Period: r.billingReconciliation.period, Threshold: r.billingReconciliation.threshold})
+	if err != nil {
+		billingReconciliationRuns.WithLabelValues("failure").Inc()
+		return report, err
+	}
+	billingReconciliationRuns.WithLabelValues("success").Inc()
+	billingDiscrepancyNamespaces.Set(float64(report.Exceeded))
+	for _, reconciliation := range report.Discrepancies {
+		for _, delta := range reconciliation.Deltas {
+			if !delta.Exceeded {
+				continue
+			}
+			direction := "over"
+			if delta.Delta < 0 {
+				direction = "under"
+			}
+			billingDiscrepancies.WithLabelValues(delta.Property, direction).Inc()
+			r.Logger.Info("billing discrepancy", "namespace", reconciliation.Namespace, "property", delta.Property,
+				"metered", delta.Metered, "billed", delta.Billed, "delta", delta.Delta, "day", report.WindowStart.Format(time.DateOnly))
+			if r.billingReconciliation.recorder != nil {
+				r.billingReconciliation.recorder.Eventf(namespaces[reconciliation.Namespace], corev1.EventTypeWarning, EventReasonBillingDiscrepancy,
+					"%s %s-charged on %s: billed %d, metered %d", delta.Property, direction, report.WindowStart.Format(time.DateOnly), delta.Billed, delta.Metered)
+			}
+		}
+	}
+	r.Logger.Info("billing reconciliation", "day", report.WindowStart.Format(time.DateOnly), "namespaces", report.Namespaces,
+		"matched", report.Matched, "exceeded", report.Exceeded)
+	return report, nil
+}
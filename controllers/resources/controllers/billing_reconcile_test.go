@@ -0,0 +1,209 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// fakeBillingReader keeps the billings by the namespace
+type fakeBillingReader map[string][]*resources.Billing
+
+func (f fakeBillingReader) QueryConsumptionBillings(_ context.Context, namespace string, startTime, endTime time.Time, handle func(billing *resources.Billing) error) error {
+	for _, billing := range f[namespace] {
+		if billing.Time.After(startTime) && !billing.Time.After(endTime) {
+			if err := handle(billing); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// billingFixture seeds 2 hours of the monitors of the namespaces from start with 100 cpu and 10 network per minute,
+// the cpu is billed 100 per hour and the network 600
+func billingFixture(t *testing.T, start time.Time, namespaces ...string) (*databasetest.MemoryStore, *resources.PropertyTypeLS) {
+	store := databasetest.NewMemoryStore()
+	var monitors []*resources.Monitor
+	for _, namespace := range namespaces {
+		for minute := 0; minute < 120; minute++ {
+			monitors = append(monitors, &resources.Monitor{Time: start.Add(time.Duration(minute) * time.Minute), Category: namespace,
+				Type: resources.AppType[resources.APP], Name: "app", Used: resources.EnumUsedMap{0: 100, 1: 50, 3: 10, 4: int64(minute)}})
+		}
+	}
+	if err := store.InsertMonitor(context.Background(), monitors...); err != nil {
+		t.Fatal(err)
+	}
+	// the unpriced memory and the differenced property are not compared
+	properties := &resources.PropertyTypeLS{EnumMap: map[uint8]resources.PropertyType{
+		0: {Name: "cpu", Enum: 0, PriceType: resources.AVG, UnitPrice: 1},
+		1: {Name: "memory", Enum: 1, PriceType: resources.AVG},
+		3: {Name: "network", Enum: 3, PriceType: resources.SUM, UnitPrice: 1},
+		4: {Name: "gpu-hours", Enum: 4, PriceType: resources.DIF, UnitPrice: 1},
+	}}
+	return store, properties
+}
+
+func hourlyBillings(namespace string, start time.Time, used ...resources.EnumUsedMap) []*resources.Billing {
+	billings := make([]*resources.Billing, 0, len(used))
+	for i := range used {
+		billings = append(billings, &resources.Billing{Time: start.Add(time.Duration(i+1) * time.Hour), Namespace: namespace,
+			AppCosts: []resources.AppCost{{Name: "app", Used: used[i]}}})
+	}
+	return billings
+}
+
+func TestReconcileBillings(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	store, properties := billingFixture(t, start, "ns-match", "ns-under", "ns-over", "ns-rounded")
+	billed := resources.EnumUsedMap{0: 100, 3: 600}
+	billings := fakeBillingReader{
+		"ns-match": hourlyBillings("ns-match", start, billed, billed),
+		// the second hour is not billed
+		"ns-under": hourlyBillings("ns-under", start, billed),
+		"ns-over":  hourlyBillings("ns-over", start, resources.EnumUsedMap{0: 150, 3: 600}, resources.EnumUsedMap{0: 150, 3: 600}),
+		// the billing rounds the average of each app up by a unit
+		"ns-rounded": hourlyBillings("ns-rounded", start, resources.EnumUsedMap{0: 101, 3: 600}, billed),
+	}
+	opts := BillingReconciliationOptions{Properties: properties, Period: time.Hour, Threshold: 0.5}
+	report, err := ReconcileBillings(context.Background(), store, billings, []string{"ns-match", "ns-under", "ns-over", "ns-rounded"}, start, end, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Namespaces != 4 || report.Matched != 1 || report.Exceeded != 2 || len(report.Discrepancies) != 3 {
+		t.Fatalf("ReconcileBillings() = %+v, want 1 matched and 2 of the 3 discrepancies exceeded", report)
+	}
+	deltas := map[string]map[string]BillingDelta{}
+	for _, reconciliation := range report.Discrepancies {
+		if reconciliation.MeteredChecksum == reconciliation.BilledChecksum {
+			t.Errorf("the checksums of %s are equal", reconciliation.Namespace)
+		}
+		deltas[reconciliation.Namespace] = map[string]BillingDelta{}
+		for _, delta := range reconciliation.Deltas {
+			deltas[reconciliation.Namespace][delta.Property] = delta
+		}
+	}
+	want := map[string]map[string]BillingDelta{
+		"ns-under": {
+			"cpu":     {Property: "cpu", Metered: 200, Billed: 100, Delta: -100, Exceeded: true},
+			"network": {Property: "network", Metered: 1200, Billed: 600, Delta: -600, Exceeded: true},
+		},
+		"ns-over":    {"cpu": {Property: "cpu", Metered: 200, Billed: 300, Delta: 100, Exceeded: true}},
+		"ns-rounded": {"cpu": {Property: "cpu", Metered: 200, Billed: 201, Delta: 1}},
+	}
+	for namespace, wantDeltas := range want {
+		if len(deltas[namespace]) != len(wantDeltas) {
+			t.Errorf("deltas of %s = %+v, want %+v", namespace, deltas[namespace], wantDeltas)
+			continue
+		}
+		for property, delta := range wantDeltas {
+			if got := deltas[namespace][property]; got != delta {
+				t.Errorf("delta of %s %s = %+v, want %+v", namespace, property, got, delta)
+			}
+		}
+	}
+	if got := store.Calls("InsertMonitor"); got != 1 {
+		t.Errorf("the monitors were written %d times, want only the seeding", got)
+	}
+
+	// the window must be closed billing periods
+	if _, err := ReconcileBillings(context.Background(), store, billings, nil, start.Add(time.Minute), end, opts); err == nil {
+		t.Error("ReconcileBillings() of an unaligned window expected error")
+	}
+}
+
+func TestNewBillingReconciliationFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "disabled", env: map[string]string{}, wantNil: true},
+		{name: "default", env: map[string]string{BillingReconciliation: "true"}},
+		{name: "invalid hour", env: map[string]string{BillingReconciliation: "true", BillingReconciliationHour: "24"}, wantErr: true},
+		{name: "negative threshold", env: map[string]string{BillingReconciliation: "true", BillingReconciliationThreshold: "-1"}, wantErr: true},
+		{name: "period not dividing a day", env: map[string]string{BillingReconciliation: "true", BillingReconciliationPeriod: "7h"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{BillingReconciliation, BillingReconciliationHour, BillingReconciliationThreshold, BillingReconciliationPeriod} {
+				t.Setenv(key, tt.env[key])
+			}
+			b, err := newBillingReconciliationFromEnv(nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newBillingReconciliationFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (b == nil) != tt.wantNil {
+				t.Errorf("newBillingReconciliationFromEnv() = %v, wantNil %v", b, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestMonitorReconciler_reconcileBillings(t *testing.T) {
+	now := time.Date(2024, 1, 2, 5, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, properties := billingFixture(t, day, "ns-match", "ns-under")
+	billed := resources.EnumUsedMap{0: 100, 3: 600}
+	recorder := record.NewFakeRecorder(10)
+	r := &MonitorReconciler{
+		Client: fake.NewClientBuilder().WithObjects(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-match"}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-under"}}).Build(),
+		Logger:            logr.Discard(),
+		DBClient:          store,
+		Properties:        properties,
+		NamespaceSelector: labels.Everything(),
+		billingReconciliation: &billingReconciliation{period: time.Hour, threshold: 1, recorder: recorder, billings: fakeBillingReader{
+			"ns-match": hourlyBillings("ns-match", day, billed, billed),
+			"ns-under": hourlyBillings("ns-under", day, billed),
+		}},
+	}
+	if err := r.SetupBillingReconciliation(nil, recorder); err == nil {
+		t.Error("SetupBillingReconciliation() without the billings expected error")
+	}
+	before := testutil.ToFloat64(billingDiscrepancies.WithLabelValues("cpu", "under"))
+	report, err := r.reconcileBillings(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.WindowStart.Equal(day) || report.Matched != 1 || report.Exceeded != 1 {
+		t.Errorf("reconcileBillings() = %+v, want the previous day with ns-under exceeded", report)
+	}
+	if got := testutil.ToFloat64(billingDiscrepancies.WithLabelValues("cpu", "under")) - before; got != 1 {
+		t.Errorf("cpu under-charges increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(billingDiscrepancyNamespaces); got != 1 {
+		t.Errorf("discrepancy namespaces = %v, want 1", got)
+	}
+	// the cpu and the network of ns-under
+	if got := len(recorder.Events); got != 2 {
+		t.Errorf("events = %d, want 2", got)
+	}
+}
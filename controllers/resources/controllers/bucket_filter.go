@@ -0,0 +1,193 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ObjStorageExemptBucketPrefixes comma separated prefixes of the bucket name (without the owner prefix) which are not billed
+	ObjStorageExemptBucketPrefixes = "OBJECT_STORAGE_EXEMPT_BUCKET_PREFIXES"
+	// ObjStorageExemptBucketSuffixes comma separated suffixes of the bucket name which are not billed
+	ObjStorageExemptBucketSuffixes = "OBJECT_STORAGE_EXEMPT_BUCKET_SUFFIXES"
+	// ObjStorageExemptBucketPattern the regexp of the bucket name (without the owner prefix) which is not billed
+	ObjStorageExemptBucketPattern = "OBJECT_STORAGE_EXEMPT_BUCKET_PATTERN"
+	// ObjStorageExemptBucketsAnnotation the regexp of the exempt bucket names of the user namespace, it replaces the
+	// prefixes, the suffixes and the pattern of the envs for the tenant, an empty value exempts no bucket by the name
+	ObjStorageExemptBucketsAnnotation = "objectstorage.sealos.io/exempt-buckets"
+
+	// BucketBillingTagKey buckets tagged with sealos.io/billing=exempt are not billed
+	BucketBillingTagKey    = "sealos.io/billing"
+	BucketBillingTagExempt = "exempt"
+
+	DefaultBucketTagCacheTTL = 10 * time.Minute
+)
+
+type bucketTagEntry struct {
+	exempt    bool
+	expiredAt time.Time
+}
+
+// bucketExemption the buckets exempt from billing by the name, the global one of the envs or the one of a tenant
+type bucketExemption struct {
+	prefixes []string
+	suffixes []string
+	// pattern matches the name without the owner prefix, nil matches none
+	pattern *regexp.Regexp
+}
+
+func (e *bucketExemption) match(user, bucket string) bool {
+	name := strings.TrimPrefix(strings.TrimPrefix(bucket, user), "-")
+	for _, prefix := range e.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, suffix := range e.suffixes {
+		if strings.HasSuffix(bucket, suffix) {
+			return true
+		}
+	}
+	return e.pattern != nil && e.pattern.MatchString(name)
+}
+
+// bucketFilter decides whether the bucket is exempt from billing by the name or the billing tag,
+// the tag lookups are cached to avoid an extra api call per bucket per minute.
+type bucketFilter struct {
+	names   bucketExemption
+	ttl     time.Duration
+	getTags func(bucket string) (map[string]string, error)
+
+	mu    sync.Mutex
+	cache map[string]bucketTagEntry
+}
+
+func newBucketFilter(names bucketExemption, getTags func(bucket string) (map[string]string, error)) *bucketFilter {
+	return &bucketFilter{
+		names:   names,
+		ttl:     DefaultBucketTagCacheTTL,
+		getTags: getTags,
+		cache:   make(map[string]bucketTagEntry),
+	}
+}
+
+func newBucketFilterFromEnv(getTags func(bucket string) (map[string]string, error)) (*bucketFilter, error) {
+	names := bucketExemption{
+		prefixes: splitList(os.Getenv(ObjStorageExemptBucketPrefixes)),
+		suffixes: splitList(os.Getenv(ObjStorageExemptBucketSuffixes)),
+	}
+	if pattern := os.Getenv(ObjStorageExemptBucketPattern); pattern != "" {
+		var err error
+		if names.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", ObjStorageExemptBucketPattern, pattern, err)
+		}
+	}
+	return newBucketFilter(names, getTags), nil
+}
+
+// namespaceBucketExemption returns the exemption of the annotation of the user namespace, nil uses the global one
+func namespaceBucketExemption(namespace *corev1.Namespace) (*bucketExemption, error) {
+	pattern, ok := namespace.Annotations[ObjStorageExemptBucketsAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	if pattern = strings.TrimSpace(pattern); pattern == "" {
+		return &bucketExemption{}, nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", ObjStorageExemptBucketsAnnotation, pattern, err)
+	}
+	return &bucketExemption{pattern: compiled}, nil
+}
+
+// exempt returns true if the bucket of the user should be skipped from billing, the names of the tenant replace the
+// global ones if not nil, the billing tag applies to both
+func (f *bucketFilter) exempt(user, bucket string, names *bucketExemption) bool {
+	if names == nil {
+		names = &f.names
+	}
+	if names.match(user, bucket) {
+		return true
+	}
+	return f.exemptByTag(bucket)
+}
+
+func (f *bucketFilter) exemptByTag(bucket string) bool {
+	if f.getTags == nil {
+		return false
+	}
+	now := time.Now()
+	f.mu.Lock()
+	entry, ok := f.cache[bucket]
+	f.mu.Unlock()
+	if ok && now.Before(entry.expiredAt) {
+		return entry.exempt
+	}
+	tags, err := f.getTags(bucket)
+	if err != nil {
+		// bill the bucket if the tags are unknown, retry the lookup in the next cycle
+		return false
+	}
+	entry = bucketTagEntry{exempt: tags[BucketBillingTagKey] == BucketBillingTagExempt, expiredAt: now.Add(f.ttl)}
+	f.mu.Lock()
+	f.cache[bucket] = entry
+	f.mu.Unlock()
+	return entry.exempt
+}
+
+func (r *MonitorReconciler) getBucketTags(bucket string) (map[string]string, error) {
+	if r.ObjStorageClient == nil {
+		return nil, nil
+	}
+	var bucketTags map[string]string
+	err := r.ObjStorageClient.Do(func(client *minio.Client) error {
+		ctx, cancel := withTimeout(r.ObjStorageTimeout)
+		defer cancel()
+		t, err := client.GetBucketTagging(ctx, bucket)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchTagSet" {
+				return nil
+			}
+			return err
+		}
+		bucketTags = t.ToMap()
+		return nil
+	})
+	if err != nil {
+		r.Logger.Error(err, "failed to get bucket tagging", "bucket", bucket)
+	}
+	return bucketTags, err
+}
+
+// splitList splits the comma separated list and removes the empty items
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,140 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"regexp"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBucketFilter_exempt(t *testing.T) {
+	bucketTags := map[string]map[string]string{
+		"user1-tagged":  {BucketBillingTagKey: BucketBillingTagExempt},
+		"user1-billing": {BucketBillingTagKey: "normal"},
+	}
+	lookups := map[string]int{}
+	getTags := func(bucket string) (map[string]string, error) {
+		lookups[bucket]++
+		return bucketTags[bucket], nil
+	}
+	tests := []struct {
+		name     string
+		prefixes []string
+		suffixes []string
+		pattern  string
+		getTags  func(bucket string) (map[string]string, error)
+		bucket   string
+		want     bool
+	}{
+		{name: "prefix", prefixes: []string{"preview"}, bucket: "user1-preview-site", want: true},
+		{name: "prefix not match", prefixes: []string{"preview"}, bucket: "user1-data", want: false},
+		{name: "suffix", suffixes: []string{"-static"}, bucket: "user1-site-static", want: true},
+		{name: "pattern", pattern: `^backup-\d+$`, bucket: "user1-backup-20240101", want: true},
+		{name: "pattern matches the name without the owner", pattern: `^user1`, bucket: "user1-data", want: false},
+		{name: "tag", getTags: getTags, bucket: "user1-tagged", want: true},
+		{name: "tag not exempt", getTags: getTags, bucket: "user1-billing", want: false},
+		{name: "prefix and tag", prefixes: []string{"preview"}, getTags: getTags, bucket: "user1-preview", want: true},
+		{name: "prefix not match and tag", prefixes: []string{"preview"}, getTags: getTags, bucket: "user1-tagged", want: true},
+		{name: "none", prefixes: []string{"preview"}, suffixes: []string{"-static"}, getTags: getTags, bucket: "user1-billing", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := bucketExemption{prefixes: tt.prefixes, suffixes: tt.suffixes}
+			if tt.pattern != "" {
+				names.pattern = regexp.MustCompile(tt.pattern)
+			}
+			f := newBucketFilter(names, tt.getTags)
+			if got := f.exempt("user1", tt.bucket, nil); got != tt.want {
+				t.Errorf("exempt(%s) = %v, want %v", tt.bucket, got, tt.want)
+			}
+		})
+	}
+
+	// the tag lookups are cached
+	lookups = map[string]int{}
+	f := newBucketFilter(bucketExemption{}, getTags)
+	for i := 0; i < 3; i++ {
+		if !f.exempt("user1", "user1-tagged", nil) {
+			t.Fatalf("exempt(user1-tagged) = false, want true")
+		}
+	}
+	if lookups["user1-tagged"] != 1 {
+		t.Errorf("tag lookups = %d, want 1", lookups["user1-tagged"])
+	}
+	// the prefix matched bucket does not need the tag lookup
+	f = newBucketFilter(bucketExemption{prefixes: []string{"preview"}}, getTags)
+	f.exempt("user1", "user1-preview", nil)
+	if lookups["user1-preview"] != 0 {
+		t.Errorf("tag lookups of prefix exempt bucket = %d, want 0", lookups["user1-preview"])
+	}
+}
+
+func TestBucketFilter_exempt_namespace(t *testing.T) {
+	t.Setenv(ObjStorageExemptBucketPrefixes, "preview")
+	t.Setenv(ObjStorageExemptBucketPattern, `^backup-`)
+	f, err := newBucketFilterFromEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespace := func(annotations map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user1", Annotations: annotations}}
+	}
+	tests := []struct {
+		name      string
+		namespace *corev1.Namespace
+		bucket    string
+		want      bool
+		wantErr   bool
+	}{
+		{name: "global prefix", namespace: namespace(nil), bucket: "user1-preview-site", want: true},
+		{name: "global pattern", namespace: namespace(nil), bucket: "user1-backup-db", want: true},
+		{name: "tenant pattern", namespace: namespace(map[string]string{ObjStorageExemptBucketsAnnotation: `^(system|sys)-`}),
+			bucket: "user1-system-logs", want: true},
+		// the tenant pattern replaces the global exemption
+		{name: "tenant pattern replaces the global", namespace: namespace(map[string]string{ObjStorageExemptBucketsAnnotation: `^system-`}),
+			bucket: "user1-backup-db", want: false},
+		{name: "tenant without exemption", namespace: namespace(map[string]string{ObjStorageExemptBucketsAnnotation: ""}),
+			bucket: "user1-preview-site", want: false},
+		// the invalid annotation falls back to the global exemption
+		{name: "invalid tenant pattern", namespace: namespace(map[string]string{ObjStorageExemptBucketsAnnotation: `(`}),
+			bucket: "user1-preview-site", want: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := namespaceBucketExemption(tt.namespace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("namespaceBucketExemption() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := f.exempt("user1", tt.bucket, names); got != tt.want {
+				t.Errorf("exempt(%s) = %v, want %v", tt.bucket, got, tt.want)
+			}
+		})
+	}
+
+	t.Setenv(ObjStorageExemptBucketPattern, `(`)
+	if _, err := newBucketFilterFromEnv(nil); err == nil {
+		t.Error("newBucketFilterFromEnv() of the invalid pattern expected error")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	got := splitList(" preview, ,static-,")
+	if len(got) != 2 || got[0] != "preview" || got[1] != "static-" {
+		t.Errorf("splitList() = %v, want [preview static-]", got)
+	}
+}
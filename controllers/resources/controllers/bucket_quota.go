@@ -0,0 +1,338 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/minio/madmin-go/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	objectstoragev1 "github/labring/sealos/controllers/objectstorage/api/v1"
+
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// ObjStorageQuotaEnforcement disabled (default), dry-run: only log the enforcement, enabled: call the enforcement hooks
+	ObjStorageQuotaEnforcement = "OBJECT_STORAGE_QUOTA_ENFORCEMENT"
+	// ObjStorageQuotaConfigMap namespace/name of the configmap with the object storage quota per user, eg: data: {"user-a": "10Gi"}
+	ObjStorageQuotaConfigMap = "OBJECT_STORAGE_QUOTA_CONFIGMAP"
+	// ObjStorageQuotaHysteresis the percent below the quota the usage must drop to before the enforcement is released, default 10
+	ObjStorageQuotaHysteresis = "OBJECT_STORAGE_QUOTA_HYSTERESIS"
+	// ObjStorageQuotaAnnotation the quota annotation of the user namespace, overrides the configmap
+	ObjStorageQuotaAnnotation = "objectstorage.sealos.io/quota"
+
+	QuotaEnforcementDisabled = "disabled"
+	QuotaEnforcementDryRun   = "dry-run"
+	QuotaEnforcementEnabled  = "enabled"
+
+	DefaultQuotaHysteresisPercent = 10
+	DefaultQuotaConfigMapTTL      = 1 * time.Minute
+
+	EventReasonObjStorageQuotaExceeded = "ObjectStorageQuotaExceeded"
+	EventReasonObjStorageQuotaReleased = "ObjectStorageQuotaReleased"
+)
+
+// BucketUsage the metered size of a bucket
+type BucketUsage struct {
+	Bucket string
+	Size   int64
+}
+
+// ObjStorageUsage the metered object storage of a user
+type ObjStorageUsage struct {
+	User    string
+	Buckets []BucketUsage
+	Size    int64
+	// FailedBuckets the buckets failed to scan, they are not in the size
+	FailedBuckets []string
+}
+
+// QuotaEnforcer is the hook called when the object storage usage of a user crosses the quota
+type QuotaEnforcer interface {
+	// Enforce stops the user from growing the object storage
+	Enforce(ctx context.Context, namespace *corev1.Namespace, usage *ObjStorageUsage, quota int64) error
+	// Release lifts the enforcement once the usage dropped below the hysteresis band
+	Release(ctx context.Context, namespace *corev1.Namespace, usage *ObjStorageUsage, quota int64) error
+}
+
+// QuotaEnforcers calls the enforcers in order
+type QuotaEnforcers []QuotaEnforcer
+
+func (e QuotaEnforcers) Enforce(ctx context.Context, namespace *corev1.Namespace, usage *ObjStorageUsage, quota int64) error {
+	var errs []error
+	for _, enforcer := range e {
+		errs = append(errs, enforcer.Enforce(ctx, namespace, usage, quota))
+	}
+	return errors.Join(errs...)
+}
+
+func (e QuotaEnforcers) Release(ctx context.Context, namespace *corev1.Namespace, usage *ObjStorageUsage, quota int64) error {
+	var errs []error
+	for _, enforcer := range e {
+		errs = append(errs, enforcer.Release(ctx, namespace, usage, quota))
+	}
+	return errors.Join(errs...)
+}
+
+// minioQuotaEnforcer freezes the buckets of the user by setting the hard quota of each bucket to its current size
+type minioQuotaEnforcer struct {
+	client   *ObjStorageClient
+	endpoint string
+}
+
+func (e *minioQuotaEnforcer) setBucketQuotas(ctx context.Context, usage *ObjStorageUsage, quota func(bucket BucketUsage) *madmin.BucketQuota) error {
+	ak, sk := e.client.credentials()
+	adminClient, err := objectstoragev1.NewOSAdminClient(e.endpoint, ak, sk)
+	if err != nil {
+		return fmt.Errorf("failed to new minio admin client: %w", err)
+	}
+	var errs []error
+	for _, bucket := range usage.Buckets {
+		if err := adminClient.SetBucketQuota(ctx, bucket.Bucket, quota(bucket)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set quota of bucket %s: %w", bucket.Bucket, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *minioQuotaEnforcer) Enforce(ctx context.Context, _ *corev1.Namespace, usage *ObjStorageUsage, _ int64) error {
+	return e.setBucketQuotas(ctx, usage, func(bucket BucketUsage) *madmin.BucketQuota {
+		// quota 0 clears the quota, an empty bucket is frozen at 1 byte
+		size := bucket.Size
+		if size < 1 {
+			size = 1
+		}
+		return &madmin.BucketQuota{Quota: uint64(size), Type: madmin.HardQuota}
+	})
+}
+
+func (e *minioQuotaEnforcer) Release(ctx context.Context, _ *corev1.Namespace, usage *ObjStorageUsage, _ int64) error {
+	return e.setBucketQuotas(ctx, usage, func(BucketUsage) *madmin.BucketQuota {
+		return &madmin.BucketQuota{}
+	})
+}
+
+// eventQuotaEnforcer emits the quota events on the user namespace
+type eventQuotaEnforcer struct {
+	recorder record.EventRecorder
+}
+
+func (e *eventQuotaEnforcer) Enforce(_ context.Context, namespace *corev1.Namespace, usage *ObjStorageUsage, quota int64) error {
+	e.recorder.Eventf(namespace, corev1.EventTypeWarning, EventReasonObjStorageQuotaExceeded,
+		"object storage usage %s exceeds the quota %s, the buckets are frozen", formatBytes(usage.Size), formatBytes(quota))
+	return nil
+}
+
+func (e *eventQuotaEnforcer) Release(_ context.Context, namespace *corev1.Namespace, usage *ObjStorageUsage, quota int64) error {
+	e.recorder.Eventf(namespace, corev1.EventTypeNormal, EventReasonObjStorageQuotaReleased,
+		"object storage usage %s is below the quota %s, the buckets are unfrozen", formatBytes(usage.Size), formatBytes(quota))
+	return nil
+}
+
+// dryRunQuotaEnforcer only logs the enforcement
+type dryRunQuotaEnforcer struct {
+	logr.Logger
+}
+
+func (e *dryRunQuotaEnforcer) Enforce(_ context.Context, namespace *corev1.Namespace, usage *ObjStorageUsage, quota int64) error {
+	e.Info("dry-run: object storage quota exceeded, would freeze the buckets", "namespace", namespace.Name, "user", usage.User,
+		"used", usage.Size, "quota", quota, "buckets", len(usage.Buckets))
+	return nil
+}
+
+func (e *dryRunQuotaEnforcer) Release(_ context.Context, namespace *corev1.Namespace, usage *ObjStorageUsage, quota int64) error {
+	e.Info("dry-run: object storage below quota, would unfreeze the buckets", "namespace", namespace.Name, "user", usage.User,
+		"used", usage.Size, "quota", quota, "buckets", len(usage.Buckets))
+	return nil
+}
+
+func formatBytes(bytes int64) string {
+	return resource.NewQuantity(bytes, resource.BinarySI).String()
+}
+
+type quotaAction int
+
+const (
+	quotaActionNone quotaAction = iota
+	quotaActionEnforce
+	quotaActionRelease
+)
+
+// quotaTracker decides the enforcement with hysteresis: the user is enforced once the usage exceeds the quota,
+// and released only after the usage drops below quota * (100 - hysteresis) / 100, so it doesn't flap around the quota.
+// The state is only kept in memory, the enforced users are enforced again after a restart if still above the quota.
+type quotaTracker struct {
+	hysteresis int64
+	mu         sync.Mutex
+	enforced   map[string]bool
+}
+
+func newQuotaTracker(hysteresisPercent int64) *quotaTracker {
+	if hysteresisPercent < 0 || hysteresisPercent >= 100 {
+		hysteresisPercent = DefaultQuotaHysteresisPercent
+	}
+	return &quotaTracker{hysteresis: hysteresisPercent, enforced: make(map[string]bool)}
+}
+
+// decide returns the action for the usage, quota <= 0 means no quota
+func (t *quotaTracker) decide(user string, used, quota int64) quotaAction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	enforced := t.enforced[user]
+	switch {
+	case !enforced && quota > 0 && used > quota:
+		return quotaActionEnforce
+	case enforced && (quota <= 0 || used < quota-quota*t.hysteresis/100):
+		return quotaActionRelease
+	}
+	return quotaActionNone
+}
+
+// commit records the action after the enforcement hooks succeeded, a failed action is retried in the next cycle
+func (t *quotaTracker) commit(user string, action quotaAction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch action {
+	case quotaActionEnforce:
+		t.enforced[user] = true
+	case quotaActionRelease:
+		delete(t.enforced, user)
+	}
+}
+
+// quotaSource reads the quota of the user from the namespace annotation or the cached configmap
+type quotaSource struct {
+	reader    client.Reader
+	configMap *types.NamespacedName
+	ttl       time.Duration
+
+	mu        sync.Mutex
+	data      map[string]string
+	expiredAt time.Time
+}
+
+func newQuotaSource(reader client.Reader, configMap string) (*quotaSource, error) {
+	s := &quotaSource{reader: reader, ttl: DefaultQuotaConfigMapTTL}
+	if configMap != "" {
+		namespace, name, ok := strings.Cut(configMap, "/")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("invalid quota configmap %q, want namespace/name", configMap)
+		}
+		s.configMap = &types.NamespacedName{Namespace: namespace, Name: name}
+	}
+	return s, nil
+}
+
+// quota returns the quota bytes of the user, 0 if not set
+func (s *quotaSource) quota(namespace *corev1.Namespace, user string) (int64, error) {
+	value, ok := namespace.Annotations[ObjStorageQuotaAnnotation]
+	if !ok {
+		data, err := s.configMapData()
+		if err != nil {
+			return 0, err
+		}
+		value = data[user]
+	}
+	if value == "" {
+		return 0, nil
+	}
+	quota, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid object storage quota %q of user %s: %w", value, user, err)
+	}
+	return quota.Value(), nil
+}
+
+func (s *quotaSource) configMapData() (map[string]string, error) {
+	if s.configMap == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().Before(s.expiredAt) {
+		return s.data, nil
+	}
+	cm := &corev1.ConfigMap{}
+	if err := s.reader.Get(context.Background(), *s.configMap, cm); err != nil {
+		return nil, fmt.Errorf("failed to get quota configmap %s: %w", s.configMap, err)
+	}
+	s.data, s.expiredAt = cm.Data, time.Now().Add(s.ttl)
+	return s.data, nil
+}
+
+// SetupObjStorageQuota enables the object storage quota enforcement by the mode, the enforcement is disabled by default
+func (r *MonitorReconciler) SetupObjStorageQuota(mode string, recorder record.EventRecorder, minioEndpoint string) (err error) {
+	switch mode {
+	case "", QuotaEnforcementDisabled:
+		return nil
+	case QuotaEnforcementDryRun:
+		r.quotaEnforcer = &dryRunQuotaEnforcer{Logger: r.Logger.WithName("quota")}
+	case QuotaEnforcementEnabled:
+		r.quotaEnforcer = QuotaEnforcers{
+			&minioQuotaEnforcer{client: r.ObjStorageClient, endpoint: minioEndpoint},
+			&eventQuotaEnforcer{recorder: recorder},
+		}
+	default:
+		return fmt.Errorf("invalid object storage quota enforcement %q, must be one of: %s, %s, %s", mode, QuotaEnforcementDisabled, QuotaEnforcementDryRun, QuotaEnforcementEnabled)
+	}
+	if r.quotaSource, err = newQuotaSource(r.APIReader, os.Getenv(ObjStorageQuotaConfigMap)); err != nil {
+		return err
+	}
+	r.quotaTracker = newQuotaTracker(env.GetInt64EnvWithDefault(ObjStorageQuotaHysteresis, DefaultQuotaHysteresisPercent))
+	return nil
+}
+
+// enforceObjStorageQuota compares the metered object storage of the user with the quota and calls the enforcement hook
+func (r *MonitorReconciler) enforceObjStorageQuota(namespace *corev1.Namespace, usage *ObjStorageUsage) {
+	if r.quotaEnforcer == nil || usage == nil {
+		return
+	}
+	quota, err := r.quotaSource.quota(namespace, usage.User)
+	if err != nil {
+		r.Logger.Error(err, "failed to get object storage quota", "namespace", namespace.Name, "user", usage.User)
+		return
+	}
+	action := r.quotaTracker.decide(usage.User, usage.Size, quota)
+	// the size is incomplete with the failed buckets, a lower size may not be real
+	if action == quotaActionRelease && len(usage.FailedBuckets) > 0 && quota > 0 {
+		return
+	}
+	switch action {
+	case quotaActionEnforce:
+		err = r.quotaEnforcer.Enforce(context.Background(), namespace, usage, quota)
+	case quotaActionRelease:
+		err = r.quotaEnforcer.Release(context.Background(), namespace, usage, quota)
+	default:
+		return
+	}
+	if err != nil {
+		r.Logger.Error(err, "failed to enforce object storage quota", "namespace", namespace.Name, "user", usage.User, "used", usage.Size, "quota", quota)
+		return
+	}
+	r.quotaTracker.commit(usage.User, action)
+}
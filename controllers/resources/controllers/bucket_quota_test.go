@@ -0,0 +1,136 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestQuotaTracker_Hysteresis(t *testing.T) {
+	const quota = 1000
+	tracker := newQuotaTracker(10)
+	// the usage grows over the quota, stays in the hysteresis band, then drops below it
+	steps := []struct {
+		used int64
+		want quotaAction
+	}{
+		{used: 900, want: quotaActionNone},
+		{used: 1000, want: quotaActionNone},
+		{used: 1001, want: quotaActionEnforce},
+		{used: 1200, want: quotaActionNone},
+		{used: 950, want: quotaActionNone},
+		{used: 900, want: quotaActionNone},
+		{used: 1100, want: quotaActionNone},
+		{used: 899, want: quotaActionRelease},
+		{used: 950, want: quotaActionNone},
+		{used: 1001, want: quotaActionEnforce},
+	}
+	for i, step := range steps {
+		action := tracker.decide("user-a", step.used, quota)
+		if action != step.want {
+			t.Fatalf("step %d: decide(used=%d) = %v, want %v", i, step.used, action, step.want)
+		}
+		tracker.commit("user-a", action)
+	}
+}
+
+func TestQuotaTracker_NoQuota(t *testing.T) {
+	tracker := newQuotaTracker(10)
+	if action := tracker.decide("user-a", 1<<40, 0); action != quotaActionNone {
+		t.Errorf("decide() without quota = %v, want none", action)
+	}
+	tracker.commit("user-a", quotaActionEnforce)
+	if action := tracker.decide("user-a", 1<<40, 0); action != quotaActionRelease {
+		t.Errorf("decide() after the quota is removed = %v, want release", action)
+	}
+}
+
+type fakeQuotaEnforcer struct {
+	enforced, released int
+	err                error
+}
+
+func (f *fakeQuotaEnforcer) Enforce(context.Context, *corev1.Namespace, *ObjStorageUsage, int64) error {
+	f.enforced++
+	return f.err
+}
+
+func (f *fakeQuotaEnforcer) Release(context.Context, *corev1.Namespace, *ObjStorageUsage, int64) error {
+	f.released++
+	return f.err
+}
+
+func TestMonitorReconciler_enforceObjStorageQuota(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "sealos-system", Name: "objectstorage-quota"},
+		Data:       map[string]string{"user-a": "1Ki"},
+	}
+	source, err := newQuotaSource(fake.NewClientBuilder().WithObjects(cm).Build(), "sealos-system/objectstorage-quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enforcer := &fakeQuotaEnforcer{}
+	r := &MonitorReconciler{Logger: logr.Discard(), quotaEnforcer: enforcer, quotaSource: source, quotaTracker: newQuotaTracker(10)}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}
+
+	// the enforcement failed, it is retried in the next cycle
+	enforcer.err = errors.New("minio unavailable")
+	r.enforceObjStorageQuota(namespace, &ObjStorageUsage{User: "user-a", Size: 2048})
+	enforcer.err = nil
+	r.enforceObjStorageQuota(namespace, &ObjStorageUsage{User: "user-a", Size: 2048})
+	r.enforceObjStorageQuota(namespace, &ObjStorageUsage{User: "user-a", Size: 1000})
+	if enforcer.enforced != 2 || enforcer.released != 0 {
+		t.Errorf("enforced %d, released %d, want 2 enforced (1 retried), 0 released", enforcer.enforced, enforcer.released)
+	}
+	r.enforceObjStorageQuota(namespace, &ObjStorageUsage{User: "user-a", Size: 100})
+	if enforcer.released != 1 {
+		t.Errorf("released %d, want 1", enforcer.released)
+	}
+
+	// the namespace annotation overrides the configmap
+	namespace.Annotations = map[string]string{ObjStorageQuotaAnnotation: "10Ki"}
+	r.enforceObjStorageQuota(namespace, &ObjStorageUsage{User: "user-a", Size: 2048})
+	if enforcer.enforced != 2 {
+		t.Errorf("enforced %d with the annotation quota, want 2", enforcer.enforced)
+	}
+	// users without quota are never enforced
+	r.enforceObjStorageQuota(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-b"}}, &ObjStorageUsage{User: "user-b", Size: 1 << 40})
+	if enforcer.enforced != 2 {
+		t.Errorf("enforced %d for the user without quota, want 2", enforcer.enforced)
+	}
+}
+
+func TestMonitorReconciler_SetupObjStorageQuota(t *testing.T) {
+	r := &MonitorReconciler{Logger: logr.Discard()}
+	if err := r.SetupObjStorageQuota("", nil, ""); err != nil || r.quotaEnforcer != nil {
+		t.Errorf("SetupObjStorageQuota(\"\") = %v, enforcer %v, want disabled", err, r.quotaEnforcer)
+	}
+	if err := r.SetupObjStorageQuota(QuotaEnforcementDryRun, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.quotaEnforcer.(*dryRunQuotaEnforcer); !ok {
+		t.Errorf("dry-run enforcer = %T, want *dryRunQuotaEnforcer", r.quotaEnforcer)
+	}
+	if err := (&MonitorReconciler{Logger: logr.Discard()}).SetupObjStorageQuota("strict", nil, ""); err == nil {
+		t.Error("SetupObjStorageQuota(\"strict\") expected error")
+	}
+}
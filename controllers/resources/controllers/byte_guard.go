@@ -0,0 +1,75 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// MaxWindowBytes caps the object storage flow of a bucket and the traffic of an app in a window, eg: 10Ti,
+	// a larger value is bogus (eg: a counter reset read as a huge delta) and metered as the cap. Default 1Pi, 0 disables the cap.
+	MaxWindowBytes = "MAX_WINDOW_BYTES"
+
+	DefaultMaxWindowBytes = "1Pi"
+
+	byteSourceObjStorageFlow = "objstorage_flow"
+	byteSourceTraffic        = "traffic"
+)
+
+// labeled by the source and the reason only, the bucket or the app is logged to keep the cardinality bounded
+var byteAnomalies = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sealos_resources_byte_anomalies_total",
+	Help: "Number of the negative or implausibly large byte counts of a window clamped before metering.",
+}, []string{"source", "reason"})
+
+func init() {
+	metrics.Registry.MustRegister(byteAnomalies)
+}
+
+// parseMaxWindowBytes parses the cap of the bytes of a window, the default if empty
+func parseMaxWindowBytes(value string) (int64, error) {
+	if value == "" {
+		value = DefaultMaxWindowBytes
+	}
+	limit, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", MaxWindowBytes, value, err)
+	}
+	if limit.Sign() < 0 {
+		return 0, fmt.Errorf("invalid %s %q, must not be negative", MaxWindowBytes, value)
+	}
+	return limit.Value(), nil
+}
+
+// guardBytes clamps the bytes of a window read from prometheus: the negative bytes (eg: a counter reset) are metered
+// as zero, and the bytes beyond MaxWindowBytes as the cap. The anomalies are counted and logged with the subject.
+func (r *MonitorReconciler) guardBytes(source, subject string, bytes int64) int64 {
+	switch {
+	case bytes < 0:
+		byteAnomalies.WithLabelValues(source, "negative").Inc()
+		r.Logger.Info("negative bytes metered as zero", "source", source, "subject", subject, "bytes", bytes)
+		return 0
+	case r.MaxWindowBytes > 0 && bytes > r.MaxWindowBytes:
+		byteAnomalies.WithLabelValues(source, "capped").Inc()
+		r.Logger.Info("implausible bytes capped", "source", source, "subject", subject, "bytes", bytes, "max", r.MaxWindowBytes)
+		return r.MaxWindowBytes
+	}
+	return bytes
+}
@@ -0,0 +1,137 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseMaxWindowBytes(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "", want: 1 << 50},
+		{value: "10Ti", want: 10 << 40},
+		{value: "0", want: 0},
+		{value: "-1Gi", wantErr: true},
+		{value: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMaxWindowBytes(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMaxWindowBytes(%q) = %d, %v, want %d, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMonitorReconciler_guardBytes(t *testing.T) {
+	r := &MonitorReconciler{Logger: logr.Discard(), MaxWindowBytes: 1 << 40}
+	tests := []struct {
+		name   string
+		bytes  int64
+		want   int64
+		reason string
+	}{
+		{name: "plausible", bytes: 1 << 30, want: 1 << 30},
+		{name: "counter reset", bytes: -4096, want: 0, reason: "negative"},
+		{name: "absurd", bytes: math.MaxInt64, want: 1 << 40, reason: "capped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before float64
+			if tt.reason != "" {
+				before = testutil.ToFloat64(byteAnomalies.WithLabelValues(byteSourceObjStorageFlow, tt.reason))
+			}
+			if got := r.guardBytes(byteSourceObjStorageFlow, "user-a/bucket-a", tt.bytes); got != tt.want {
+				t.Errorf("guardBytes(%d) = %d, want %d", tt.bytes, got, tt.want)
+			}
+			if tt.reason != "" {
+				if got := testutil.ToFloat64(byteAnomalies.WithLabelValues(byteSourceObjStorageFlow, tt.reason)) - before; got != 1 {
+					t.Errorf("%s anomalies = %v, want 1", tt.reason, got)
+				}
+			}
+		})
+	}
+	// the cap is disabled by 0, only the negative bytes are clamped
+	r.MaxWindowBytes = 0
+	if got := r.guardBytes(byteSourceTraffic, "ns-user-a/app-a", 1<<60); got != 1<<60 {
+		t.Errorf("guardBytes() without cap = %d, want %d", got, int64(1<<60))
+	}
+}
+
+// fixedTrafficSource returns the same bytes for every app
+type fixedTrafficSource int64
+
+func (s fixedTrafficSource) GetTrafficSentBytes(_, _ time.Time, _ string, _ uint8, _ string) (int64, error) {
+	return int64(s), nil
+}
+
+func TestMonitorReconciler_monitorPodTrafficUsed_ByteGuard(t *testing.T) {
+	app := resources.AppType[resources.APP]
+	network := resources.DefaultPropertyTypeLS.StringMap[resources.ResourceNetwork]
+	end := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	start := end.Add(-time.Hour)
+	tests := []struct {
+		name  string
+		bytes int64
+		// wantBytes the bytes metered, no traffic monitor if 0
+		wantBytes int64
+	}{
+		{name: "negative", bytes: -1 << 30},
+		{name: "absurd", bytes: math.MaxInt64, wantBytes: 1 << 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := databasetest.NewMemoryStore()
+			if err := db.InsertMonitor(context.Background(), &resources.Monitor{Time: start, Category: "ns-user-a", Type: app, Name: "app-a",
+				Used: resources.EnumUsedMap{0: 1}}); err != nil {
+				t.Fatal(err)
+			}
+			r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, TrafficClient: fixedTrafficSource(tt.bytes),
+				Properties: resources.DefaultPropertyTypeLS, MaxWindowBytes: 1 << 40}
+			if err := r.monitorPodTrafficUsed(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}, start, end); err != nil {
+				t.Fatalf("monitorPodTrafficUsed() error = %v", err)
+			}
+			var metered []int64
+			for _, monitor := range db.Monitors() {
+				if monitor.IdempotencyKey != "" {
+					metered = append(metered, monitor.Used[network.Enum])
+				}
+			}
+			if tt.wantBytes == 0 {
+				if len(metered) != 0 {
+					t.Errorf("traffic metered = %v, want none", metered)
+				}
+				return
+			}
+			if want := network.UsedUnits(tt.wantBytes * 1000); len(metered) != 1 || metered[0] != want {
+				t.Errorf("traffic metered = %v, want %d", metered, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// ReconcileWarmUpDelay the wait after the startup before the first reconcile, default 0
+	ReconcileWarmUpDelay = "RECONCILE_WARMUP_DELAY"
+	// CacheSyncTimeout bounds waiting for the informers of the metered resources to sync before the first reconcile,
+	// default 2m. The first reconcile starts once it's timed out, 0 doesn't wait for the informers.
+	CacheSyncTimeout = "CACHE_SYNC_TIMEOUT"
+
+	DefaultCacheSyncTimeout = 2 * time.Minute
+)
+
+var cacheSyncTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "sealos_resources_cache_sync_timeouts_total",
+	Help: "Number of the startups whose first reconcile started before the informers of the metered resources were synced.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(cacheSyncTimeouts)
+}
+
+// warmUpObjects returns the resources read from the cache by each cycle. The pods listed from the api server page by
+// page (POD_LIST_PAGE_SIZE) are not, their informer would keep all the pods in memory, unless the cpu over-commit or the
+// gpu aggregation lists them from the cache.
+func (r *MonitorReconciler) warmUpObjects() []client.Object {
+	objects := []client.Object{&corev1.Namespace{}, &corev1.PersistentVolumeClaim{}, &corev1.Service{}}
+	if r.PodListPageSize <= 0 || r.APIReader == nil || r.cpuOvercommit != nil || r.GpuNodeAggregator != nil {
+		objects = append(objects, &corev1.Pod{})
+	}
+	return objects
+}
+
+// cacheWarmUp delays the first reconcile until the informers are synced, the informers of the cache are only started
+// once the resources are first read, so the first cycle would otherwise wait for each of them in turn
+type cacheWarmUp struct {
+	cache       cache.Informers
+	delay       time.Duration
+	syncTimeout time.Duration
+}
+
+func newCacheWarmUpFromEnv(c cache.Informers) (*cacheWarmUp, error) {
+	delay, err := env.GetDurationEnvWithDefault(ReconcileWarmUpDelay, 0)
+	if err != nil {
+		return nil, err
+	}
+	syncTimeout, err := env.GetDurationEnvWithDefault(CacheSyncTimeout, DefaultCacheSyncTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheWarmUp{cache: c, delay: delay, syncTimeout: syncTimeout}, nil
+}
+
+// warmUp waits the warm-up delay, then starts the informers of the metered resources and waits for them to sync.
+// False if the reconciler is stopped meanwhile.
+func (r *MonitorReconciler) warmUp() bool {
+	w := r.cacheWarmUp
+	if w == nil {
+		return true
+	}
+	if w.delay > 0 {
+		r.Logger.Info("wait for the warm-up delay before the first reconcile", "delay", w.delay)
+		select {
+		case <-time.After(w.delay):
+		case <-r.stopCh:
+			return false
+		}
+	}
+	if w.syncTimeout <= 0 || w.cache == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), w.syncTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	start := time.Now()
+	for _, obj := range r.warmUpObjects() {
+		if _, err := w.cache.GetInformer(ctx, obj); err != nil {
+			r.Logger.Error(err, "failed to start informer before the first reconcile", "kind", fmt.Sprintf("%T", obj))
+		}
+	}
+	synced := w.cache.WaitForCacheSync(ctx)
+	select {
+	case <-r.stopCh:
+		return false
+	default:
+	}
+	if !synced {
+		cacheSyncTimeouts.Inc()
+		r.Logger.Error(fmt.Errorf("informers not synced in %s", w.syncTimeout), "the first reconcile may meter partial resources")
+		return true
+	}
+	r.Logger.Info("informers synced before the first reconcile", "elapsed", time.Since(start))
+	return true
+}
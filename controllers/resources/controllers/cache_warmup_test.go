@@ -0,0 +1,114 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// syncingInformers are synced once synced is closed
+type syncingInformers struct {
+	*informertest.FakeInformers
+	synced chan struct{}
+}
+
+func (s *syncingInformers) WaitForCacheSync(ctx context.Context) bool {
+	select {
+	case <-s.synced:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func TestMonitorReconciler_warmUp(t *testing.T) {
+	newReconciler := func(w *cacheWarmUp) *MonitorReconciler {
+		return &MonitorReconciler{Logger: logr.Discard(), cacheWarmUp: w, stopCh: make(chan struct{})}
+	}
+	warmUp := func(r *MonitorReconciler) <-chan bool {
+		done := make(chan bool, 1)
+		go func() { done <- r.warmUp() }()
+		return done
+	}
+
+	t.Run("waits for the cache sync", func(t *testing.T) {
+		informers := &syncingInformers{FakeInformers: &informertest.FakeInformers{}, synced: make(chan struct{})}
+		done := warmUp(newReconciler(&cacheWarmUp{cache: informers, syncTimeout: time.Minute}))
+		select {
+		case <-done:
+			t.Fatal("warmUp() returned before the cache is synced")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(informers.synced)
+		if !<-done {
+			t.Fatal("warmUp() = false, want the first reconcile")
+		}
+		if got := len(informers.InformersByGVK); got != 4 {
+			t.Errorf("informers started = %d, want the namespaces, the pods, the pvcs and the services", got)
+		}
+	})
+
+	t.Run("pods listed by pages", func(t *testing.T) {
+		informers := &syncingInformers{FakeInformers: &informertest.FakeInformers{}, synced: make(chan struct{})}
+		close(informers.synced)
+		r := newReconciler(&cacheWarmUp{cache: informers, syncTimeout: time.Minute})
+		r.PodListPageSize, r.APIReader = 500, fake.NewClientBuilder().Build()
+		if !<-warmUp(r) {
+			t.Fatal("warmUp() = false, want the first reconcile")
+		}
+		if _, ok := informers.InformersByGVK[corev1.SchemeGroupVersion.WithKind("Pod")]; ok || len(informers.InformersByGVK) != 3 {
+			t.Errorf("informers started = %v, want no pod informer", informers.InformersByGVK)
+		}
+	})
+
+	t.Run("sync timeout", func(t *testing.T) {
+		before := testutil.ToFloat64(cacheSyncTimeouts)
+		informers := &syncingInformers{FakeInformers: &informertest.FakeInformers{}, synced: make(chan struct{})}
+		if !<-warmUp(newReconciler(&cacheWarmUp{cache: informers, syncTimeout: 10 * time.Millisecond})) {
+			t.Error("warmUp() = false, want the first reconcile once timed out")
+		}
+		if got := testutil.ToFloat64(cacheSyncTimeouts) - before; got != 1 {
+			t.Errorf("cache sync timeouts increased by %v, want 1", got)
+		}
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		informers := &syncingInformers{FakeInformers: &informertest.FakeInformers{}, synced: make(chan struct{})}
+		r := newReconciler(&cacheWarmUp{cache: informers, syncTimeout: time.Minute})
+		done := warmUp(r)
+		close(r.stopCh)
+		if <-done {
+			t.Error("warmUp() = true, want no reconcile once stopped")
+		}
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		start := time.Now()
+		if !<-warmUp(newReconciler(&cacheWarmUp{delay: 20 * time.Millisecond})) {
+			t.Fatal("warmUp() = false, want the first reconcile")
+		}
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+			t.Errorf("warmUp() returned after %s, want the delay", elapsed)
+		}
+	})
+}
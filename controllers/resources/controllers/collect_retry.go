@@ -0,0 +1,74 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+const (
+	// CollectRetryAttempts the attempts of collecting the monitors of a namespace, default 2, 1 disables the retry.
+	// A collection is retried once a list still fails with a transient error after its LIST_RETRY_ATTEMPTS, the other
+	// errors skip the namespace for the cycle at once.
+	CollectRetryAttempts = "COLLECT_RETRY_ATTEMPTS"
+
+	DefaultCollectRetryAttempts = 2
+
+	// collectRetryJitter jitters the backoff up to twice, so the namespaces failed at once don't collect again together
+	collectRetryJitter = 1.0
+)
+
+// collectRetryInterval the base backoff of the collection retry, longer than the list retry, so a throttled or restarting
+// api server has recovered
+var collectRetryInterval = time.Second
+
+var collectRetries = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "sealos_resources_collect_retries_total",
+	Help: "Number of the retries of collecting the monitors of a namespace after a transient api server error.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(collectRetries)
+}
+
+// collectMonitorsWithRetry collects the monitors of the namespace at the time, and collects them again from scratch on
+// the transient errors with a jittered backoff, so a transient api server error doesn't drop the minute of the namespace.
+// The inserts are retried apart by the writes of the monitors.
+func (r *MonitorReconciler) collectMonitorsWithRetry(namespace *corev1.Namespace, timeStamp time.Time) ([]*resources.Monitor, error) {
+	attempts := r.CollectRetryAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var monitors []*resources.Monitor
+	tries := 0
+	err := retry.RetryTransientWithJitter(attempts, collectRetryInterval, collectRetryJitter, func() (err error) {
+		if tries++; tries > 1 {
+			collectRetries.Inc()
+		}
+		monitors, err = r.collectMonitors(namespace, timeStamp, false)
+		if retry.IsTransient(err) && tries < attempts {
+			r.Logger.Info("retry collecting the monitors after a transient error", "namespace", namespace.Name, "attempt", tries, "error", err.Error())
+		}
+		return err
+	})
+	return monitors, err
+}
@@ -0,0 +1,111 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// flakyPodClient fails the first pod lists with the errors, the other lists are served by the client
+type flakyPodClient struct {
+	client.Client
+	errs  []error
+	calls int
+}
+
+func (f *flakyPodClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, ok := list.(*corev1.PodList); ok {
+		f.calls++
+		if len(f.errs) > 0 {
+			err := f.errs[0]
+			f.errs = f.errs[1:]
+			return err
+		}
+	}
+	return f.Client.List(ctx, list, opts...)
+}
+
+func TestMonitorReconciler_monitorResourceUsage_CollectRetry(t *testing.T) {
+	listInterval, collectInterval := listRetryInterval, collectRetryInterval
+	listRetryInterval, collectRetryInterval = time.Millisecond, time.Millisecond
+	defer func() { listRetryInterval, collectRetryInterval = listInterval, collectInterval }()
+
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: "app-a-0", Labels: map[string]string{resources.AppLabelKey: "app-a"}},
+		Spec:       corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{qosContainer(nil, qosResources("1", "1Gi"))}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+	}
+	pods := schema.GroupResource{Resource: "pods"}
+	throttled := apierrors.NewTooManyRequests("slow down", 1)
+	tests := []struct {
+		name         string
+		errs         []error
+		wantCalls    int
+		wantRetries  float64
+		wantMonitors int
+	}{
+		{name: "no error", wantCalls: 1, wantMonitors: 1},
+		// the 2 list attempts of the first collection fail, the second collection lists the pods
+		{name: "transient", errs: []error{throttled, throttled}, wantCalls: 3, wantRetries: 1, wantMonitors: 1},
+		{name: "exhausted", errs: []error{throttled, throttled, throttled, throttled}, wantCalls: 4, wantRetries: 1},
+		{name: "not retryable", errs: []error{apierrors.NewForbidden(pods, "", errors.New("rbac"))}, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &flakyPodClient{Client: fake.NewClientBuilder().WithObjects(pod).Build(), errs: tt.errs}
+			db := databasetest.NewMemoryStore()
+			r := &MonitorReconciler{
+				Client:               c,
+				Logger:               logr.Discard(),
+				DBClient:             db,
+				Properties:           resources.DefaultPropertyTypeLS,
+				MeteringPolicy:       MeteringPolicyLimits,
+				GpuMeteringPolicy:    GpuMeteringPolicyReservation,
+				ListRetryAttempts:    2,
+				CollectRetryAttempts: 2,
+			}
+			before := testutil.ToFloat64(collectRetries)
+			err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}})
+			if (err != nil) != (tt.wantMonitors == 0) {
+				t.Fatalf("monitorResourceUsage() error = %v, want monitors %d", err, tt.wantMonitors)
+			}
+			if c.calls != tt.wantCalls {
+				t.Errorf("pod lists = %d, want %d", c.calls, tt.wantCalls)
+			}
+			if got := testutil.ToFloat64(collectRetries) - before; got != tt.wantRetries {
+				t.Errorf("collect retries = %v, want %v", got, tt.wantRetries)
+			}
+			if got := len(db.Monitors()); got != tt.wantMonitors {
+				t.Errorf("monitors = %d, want %d", got, tt.wantMonitors)
+			}
+		})
+	}
+}
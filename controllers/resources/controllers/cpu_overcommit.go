@@ -0,0 +1,147 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// CPUOvercommitWeighting weights the metered cpu of the pods on the over-committed nodes by the allocatable of the
+	// node over the cpu requested on it if true, default false
+	CPUOvercommitWeighting = "CPU_OVERCOMMIT_WEIGHTING"
+
+	cpuOvercommitRefreshTimeout = 30 * time.Second
+)
+
+var cpuOvercommittedNodes = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "sealos_resources_cpu_overcommitted_nodes",
+	Help: "Number of the nodes whose cpu requests exceed the allocatable in the last cycle, their pods are metered by the weighted cpu.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(cpuOvercommittedNodes)
+}
+
+// cpuOvercommit weights the cpu of the pods by the allocation pressure of their nodes. The requests of all the pods
+// of a node are summed before the cpu is attributed to the namespaces, a node whose requests exceed its allocatable
+// gets the weight allocatable / requests, so the pods of the node share the cpu it really has:
+//
+//	weight(node) = min(1, allocatable cpu of the node / sum of the cpu requests of the pods on the node)
+//	metered cpu  = cpu of the container by METERING_POLICY * weight(node)
+//
+// The nodes not over-committed, and the nodes unknown or without the allocatable, are weighted 1.
+type cpuOvercommit struct {
+	mu sync.RWMutex
+	// weights the weights of the over-committed nodes of the last refresh
+	weights map[string]float64
+}
+
+// newCPUOvercommitFromEnv returns nil if the weighting is disabled
+func newCPUOvercommitFromEnv() *cpuOvercommit {
+	if !env.GetBoolEnvWithDefault(CPUOvercommitWeighting, false) {
+		return nil
+	}
+	return &cpuOvercommit{}
+}
+
+// refresh lists the nodes and the pods of all the namespaces to weight the nodes of the cycle, the weights of the
+// last cycle are kept if the listing failed
+func (o *cpuOvercommit) refresh(ctx context.Context, reader client.Reader, logger logr.Logger) {
+	if o == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, cpuOvercommitRefreshTimeout)
+	defer cancel()
+	nodes := &corev1.NodeList{}
+	if err := reader.List(ctx, nodes); err != nil {
+		logger.Error(err, "failed to list the nodes to weight the over-committed cpu")
+		return
+	}
+	pods := &corev1.PodList{}
+	if err := reader.List(ctx, pods); err != nil {
+		logger.Error(err, "failed to list the pods to weight the over-committed cpu")
+		return
+	}
+	weights := nodeCPUWeights(nodes.Items, pods.Items)
+	cpuOvercommittedNodes.Set(float64(len(weights)))
+	o.mu.Lock()
+	o.weights = weights
+	o.mu.Unlock()
+}
+
+// weigh returns the cpu of a pod on the node weighted by the node, the quantity itself if the node isn't over-committed
+func (o *cpuOvercommit) weigh(node string, cpu resource.Quantity) resource.Quantity {
+	if o == nil {
+		return cpu
+	}
+	o.mu.RLock()
+	weight, ok := o.weights[node]
+	o.mu.RUnlock()
+	if !ok {
+		return cpu
+	}
+	return *resource.NewMilliQuantity(int64(math.Round(float64(cpu.MilliValue())*weight)), resource.DecimalSI)
+}
+
+// nodeCPUWeights returns the weights of the over-committed nodes, the nodes weighted 1 are left out
+func nodeCPUWeights(nodes []corev1.Node, pods []corev1.Pod) map[string]float64 {
+	requested := map[string]int64{}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		requested[pod.Spec.NodeName] += podCPURequest(pod)
+	}
+	weights := map[string]float64{}
+	for _, node := range nodes {
+		allocatable := node.Status.Allocatable.Cpu().MilliValue()
+		if allocatable > 0 && requested[node.Name] > allocatable {
+			weights[node.Name] = float64(allocatable) / float64(requested[node.Name])
+		}
+	}
+	return weights
+}
+
+// podCPURequest returns the cpu millis the pod requests on its node as the scheduler counts them: the larger one of
+// the sum of the containers and the largest init container, plus the overhead
+func podCPURequest(pod *corev1.Pod) int64 {
+	var containers, initContainers int64
+	for _, container := range pod.Spec.Containers {
+		containers += container.Resources.Requests.Cpu().MilliValue()
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if request := container.Resources.Requests.Cpu().MilliValue(); request > initContainers {
+			initContainers = request
+		}
+	}
+	if initContainers > containers {
+		containers = initContainers
+	}
+	return containers + pod.Spec.Overhead.Cpu().MilliValue()
+}
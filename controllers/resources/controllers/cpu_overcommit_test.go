@@ -0,0 +1,131 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func overcommitNode(name, cpu string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}},
+	}
+}
+
+func overcommitPod(namespace, app, node, cpu string, phase corev1.PodPhase) *corev1.Pod {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: app + "-0", Labels: map[string]string{resources.AppLabelKey: app}},
+		Spec:       corev1.PodSpec{NodeName: node, Containers: []corev1.Container{qosContainer(qosResources(cpu, "1Gi"), nil)}},
+		Status:     corev1.PodStatus{Phase: phase, StartTime: &started},
+	}
+}
+
+func TestNodeCPUWeights(t *testing.T) {
+	nodes := []corev1.Node{*overcommitNode("node-a", "2"), *overcommitNode("node-b", "4"), *overcommitNode("node-c", "0")}
+	initPod := overcommitPod("ns-a", "app-init", "node-b", "500m", corev1.PodRunning)
+	// the largest init container counts instead of the containers requesting less
+	initPod.Spec.InitContainers = []corev1.Container{qosContainer(qosResources("5", ""), nil)}
+	pods := []corev1.Pod{
+		*overcommitPod("ns-a", "app-a", "node-a", "2", corev1.PodRunning),
+		*overcommitPod("ns-b", "app-b", "node-a", "1", corev1.PodPending),
+		*overcommitPod("ns-b", "app-done", "node-a", "4", corev1.PodSucceeded),
+		*initPod,
+		*overcommitPod("ns-c", "app-c", "node-c", "1", corev1.PodRunning),
+		*overcommitPod("ns-c", "app-unscheduled", "", "8", corev1.PodPending),
+	}
+	want := map[string]float64{"node-a": 2.0 / 3, "node-b": 0.8}
+	if got := nodeCPUWeights(nodes, pods); !reflect.DeepEqual(got, want) {
+		t.Errorf("nodeCPUWeights() = %v, want %v", got, want)
+	}
+
+	o := &cpuOvercommit{weights: map[string]float64{"node-a": 0.5}}
+	if got := o.weigh("node-a", resource.MustParse("1500m")); got.MilliValue() != 750 {
+		t.Errorf("weigh() = %s, want 750m", got.String())
+	}
+	if got := o.weigh("node-b", resource.MustParse("1500m")); got.MilliValue() != 1500 {
+		t.Errorf("weigh() of the node not over-committed = %s, want 1500m", got.String())
+	}
+}
+
+func TestMonitorReconciler_monitorResourceUsage_CPUOvercommit(t *testing.T) {
+	// node-a has 2 cores allocatable and 4 requested, node-b isn't over-committed
+	objects := []client.Object{
+		overcommitNode("node-a", "2"),
+		overcommitNode("node-b", "4"),
+		overcommitPod("ns-user-a", "app-a", "node-a", "2", corev1.PodRunning),
+		overcommitPod("ns-user-a", "app-c", "node-b", "1", corev1.PodRunning),
+		overcommitPod("ns-user-b", "app-b", "node-a", "2", corev1.PodRunning),
+	}
+	c := fake.NewClientBuilder().WithObjects(objects...).Build()
+	cpu := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()]
+	memory := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceMemory.String()]
+	tests := []struct {
+		name    string
+		enabled bool
+		want    map[string]int64
+	}{
+		{name: "disabled", want: map[string]int64{"app-a": 2000, "app-c": 1000}},
+		{name: "weighted", enabled: true, want: map[string]int64{"app-a": 1000, "app-c": 1000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(CPUOvercommitWeighting, strconv.FormatBool(tt.enabled))
+			db := databasetest.NewMemoryStore()
+			r := &MonitorReconciler{
+				Client:            c,
+				Logger:            logr.Discard(),
+				DBClient:          db,
+				Properties:        resources.DefaultPropertyTypeLS,
+				MeteringPolicy:    MeteringPolicyRequests,
+				GpuMeteringPolicy: GpuMeteringPolicyReservation,
+				cpuOvercommit:     newCPUOvercommitFromEnv(),
+			}
+			r.cpuOvercommit.refresh(context.Background(), r.Client, r.Logger)
+			if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+				t.Fatalf("monitorResourceUsage() error = %v", err)
+			}
+			monitors := db.Monitors()
+			if len(monitors) != len(tt.want) {
+				t.Fatalf("monitors = %d, want %d", len(monitors), len(tt.want))
+			}
+			gi := resource.MustParse("1Gi")
+			for _, monitor := range monitors {
+				if got, want := monitor.Used[cpu.Enum], cpu.UsedUnits(tt.want[monitor.Name]); got != want {
+					t.Errorf("cpu of %s = %d, want %d", monitor.Name, got, want)
+				}
+				// the memory is never weighted
+				if got, want := monitor.Used[memory.Enum], memory.UsedUnits(gi.MilliValue()); got != want {
+					t.Errorf("memory of %s = %d, want %d", monitor.Name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// CrashLoopRestartThreshold a waiting container restarted at least threshold times is crash looping, default 3,
+	// 0 only detects the containers waiting in CrashLoopBackOff
+	CrashLoopRestartThreshold = "CRASH_LOOP_RESTART_THRESHOLD"
+
+	DefaultCrashLoopRestartThreshold = 3
+
+	reasonCrashLoopBackOff = "CrashLoopBackOff"
+)
+
+// not labeled by the namespace to keep the cardinality bounded, the crash looping pods are logged
+var crashLoopPods = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "sealos_resources_crashloop_pods_metered_total",
+	Help: "Number of the crash looping pods metered, counted once per pod per cycle, eg: the rate is the number of the crash looping pods.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(crashLoopPods)
+}
+
+// podCrashLooping returns the restarts of the pod and true if a container of the pod is crash looping:
+// waiting in CrashLoopBackOff, or waiting after at least threshold restarts, eg: between two back offs.
+// The init containers are included, a pod crash looping in an init container stays pending.
+// The pods already succeeded or failed are not restarted anymore, they are never crash looping.
+func podCrashLooping(pod *corev1.Pod, threshold int32) (int32, bool) {
+	var (
+		restarts     int32
+		crashLooping bool
+	)
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return 0, false
+	}
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for i := range statuses {
+			status := &statuses[i]
+			restarts += status.RestartCount
+			if status.State.Waiting != nil && status.State.Waiting.Reason == reasonCrashLoopBackOff {
+				crashLooping = true
+			}
+			if threshold > 0 && status.RestartCount >= threshold && status.State.Waiting != nil {
+				crashLooping = true
+			}
+		}
+	}
+	return restarts, crashLooping
+}
+
+// podReservesNode returns true if the containers of the started pod keep the reservation of the node between the restarts:
+// the pod is crash looping, or restarted below the threshold, eg: an init container restarted once and running again
+// while the pod is still pending. The pods already succeeded or failed have no restarts, see podCrashLooping.
+func podReservesNode(restarts int32, crashLooping bool) bool {
+	return crashLooping || restarts > 0
+}
+
+// observeCrashLooping counts and logs the scheduled crash looping pod, its cpu and memory are metered
+// even if the pod is not running, since the containers keep the reservation of the node between the restarts.
+func (r *MonitorReconciler) observeCrashLooping(pod *corev1.Pod, restarts int32) {
+	crashLoopPods.Inc()
+	r.Logger.V(1).Info("metering crash looping pod", "namespace", pod.Namespace, "pod", pod.Name, "phase", pod.Status.Phase, "restarts", restarts)
+}
@@ -0,0 +1,122 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestPodCrashLooping(t *testing.T) {
+	waiting := func(reason string, restarts int32) corev1.ContainerStatus {
+		return corev1.ContainerStatus{RestartCount: restarts, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}}
+	}
+	running := corev1.ContainerStatus{RestartCount: 5, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+	tests := []struct {
+		name      string
+		phase     corev1.PodPhase
+		init      []corev1.ContainerStatus
+		statuses  []corev1.ContainerStatus
+		threshold int32
+		want      bool
+	}{
+		{name: "back off", phase: corev1.PodRunning, statuses: []corev1.ContainerStatus{waiting(reasonCrashLoopBackOff, 1)}, threshold: 3, want: true},
+		{name: "init back off", phase: corev1.PodPending, init: []corev1.ContainerStatus{waiting(reasonCrashLoopBackOff, 2)}, threshold: 3, want: true},
+		{name: "restarting", phase: corev1.PodRunning, statuses: []corev1.ContainerStatus{waiting("ContainerCreating", 3)}, threshold: 3, want: true},
+		{name: "restarts disabled", phase: corev1.PodRunning, statuses: []corev1.ContainerStatus{waiting("ContainerCreating", 3)}, threshold: 0, want: false},
+		{name: "recovered", phase: corev1.PodRunning, statuses: []corev1.ContainerStatus{running}, threshold: 3, want: false},
+		{name: "image pulling", phase: corev1.PodPending, statuses: []corev1.ContainerStatus{waiting("ErrImagePull", 0)}, threshold: 3, want: false},
+		{name: "failed", phase: corev1.PodFailed, statuses: []corev1.ContainerStatus{waiting(reasonCrashLoopBackOff, 10)}, threshold: 3, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Status: corev1.PodStatus{Phase: tt.phase, InitContainerStatuses: tt.init, ContainerStatuses: tt.statuses}}
+			if _, got := podCrashLooping(pod, tt.threshold); got != tt.want {
+				t.Errorf("podCrashLooping() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMonitorReconciler_monitorResourceUsage_CrashLoop(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	newPod := func(name string, status corev1.PodStatus) client.Object {
+		status.StartTime = &started
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: name, Labels: map[string]string{resources.AppLabelKey: name}},
+			Spec: corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+				},
+			}}},
+			Status: status,
+		}
+	}
+	backOff := corev1.ContainerStatus{Name: "app", RestartCount: 7,
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reasonCrashLoopBackOff}}}
+	c := fake.NewClientBuilder().WithObjects(
+		// the init container never completes, the pod stays pending
+		newPod("crash-init", corev1.PodStatus{Phase: corev1.PodPending, InitContainerStatuses: []corev1.ContainerStatus{backOff}}),
+		newPod("crash-running", corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{backOff}}),
+		newPod("image-pulling", corev1.PodStatus{Phase: corev1.PodPending, ContainerStatuses: []corev1.ContainerStatus{{Name: "app",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}}}}),
+		// restarted below the threshold, the init container runs again and the pod is still pending
+		newPod("init-restarted", corev1.PodStatus{Phase: corev1.PodPending, InitContainerStatuses: []corev1.ContainerStatus{{Name: "init",
+			RestartCount: 1, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}}}),
+		// the failed pod is not restarted anymore, its reservation is released
+		newPod("failed", corev1.PodStatus{Phase: corev1.PodFailed, ContainerStatuses: []corev1.ContainerStatus{backOff}}),
+	).Build()
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{
+		Client:                    c,
+		Logger:                    logr.Discard(),
+		DBClient:                  db,
+		Properties:                resources.DefaultPropertyTypeLS,
+		MeteringPolicy:            MeteringPolicyRequests,
+		GpuMeteringPolicy:         GpuMeteringPolicyReservation,
+		CrashLoopRestartThreshold: DefaultCrashLoopRestartThreshold,
+	}
+	if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+		t.Fatalf("monitorResourceUsage() error = %v", err)
+	}
+	cpu := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()]
+	request := resource.MustParse("500m")
+	want := request.MilliValue() / cpu.Unit.MilliValue()
+	metered := map[string]int64{}
+	for _, monitor := range db.Monitors() {
+		metered[monitor.Name] = monitor.Used[cpu.Enum]
+	}
+	for _, name := range []string{"crash-init", "crash-running", "init-restarted"} {
+		if metered[name] != want {
+			t.Errorf("restarting pod %s cpu = %d, want %d by the requests", name, metered[name], want)
+		}
+	}
+	for _, name := range []string{"image-pulling", "failed"} {
+		if _, ok := metered[name]; ok {
+			t.Errorf("the pod %s not running for 10 minutes is metered", name)
+		}
+	}
+}
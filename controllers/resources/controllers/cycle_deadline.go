@@ -0,0 +1,142 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// ReconcileCycleDeadline the fraction of the reconcile period after which a cycle stops starting the namespaces, eg: 0.8,
+// the namespaces in flight are still committed and the rest are skipped to the next cycle, disabled if not set
+const ReconcileCycleDeadline = "RECONCILE_CYCLE_DEADLINE"
+
+var (
+	reconcileDeadlineExceeded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_reconcile_deadline_exceeded_total",
+		Help: "Number of the reconcile cycles cut at the cycle deadline.",
+	})
+	reconcileSkippedNamespaces = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_reconcile_skipped_namespaces_total",
+		Help: "Number of the namespaces skipped to the next cycle at the cycle deadline.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileDeadlineExceeded, reconcileSkippedNamespaces)
+}
+
+// parseCycleDeadline returns the fraction of the reconcile period, 0 if not set
+func parseCycleDeadline(raw string) (float64, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	fraction, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", ReconcileCycleDeadline, raw, err)
+	}
+	if fraction < 0 || fraction > 1 {
+		return 0, fmt.Errorf("invalid %s %v: must be in (0, 1], 0 disables it", ReconcileCycleDeadline, fraction)
+	}
+	return fraction, nil
+}
+
+func newCycleDeadlineFromEnv() (float64, error) {
+	return parseCycleDeadline(os.Getenv(ReconcileCycleDeadline))
+}
+
+// cycleDeadline returns the deadline of the cycle started at start, zero if disabled
+func (r *MonitorReconciler) cycleDeadline(start time.Time) time.Time {
+	if r.CycleDeadline <= 0 {
+		return time.Time{}
+	}
+	return start.Add(time.Duration(r.CycleDeadline * float64(r.periodicReconcile)))
+}
+
+// skippedNamespaces the namespaces skipped at the deadline of the last cycle, they are processed first by the next cycle
+type skippedNamespaces struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+// prioritize returns the namespaces with the skipped ones first, the order is kept otherwise
+func (s *skippedNamespaces) prioritize(namespaces []corev1.Namespace) []corev1.Namespace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.names) == 0 {
+		return namespaces
+	}
+	sorted := make([]corev1.Namespace, 0, len(namespaces))
+	for i := range namespaces {
+		if _, ok := s.names[namespaces[i].Name]; ok {
+			sorted = append(sorted, namespaces[i])
+		}
+	}
+	for i := range namespaces {
+		if _, ok := s.names[namespaces[i].Name]; !ok {
+			sorted = append(sorted, namespaces[i])
+		}
+	}
+	return sorted
+}
+
+func (s *skippedNamespaces) set(namespaces []corev1.Namespace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = make(map[string]struct{}, len(namespaces))
+	for i := range namespaces {
+		s.names[namespaces[i].Name] = struct{}{}
+	}
+}
+
+// processNamespacesBefore processes the namespaces, the skipped namespaces of the last cycle first, and stops starting
+// them at the deadline (or on shutdown). The namespaces started are finished and committed, the rest are returned
+// and prioritized by the next cycle if the deadline cut the cycle.
+func (r *MonitorReconciler) processNamespacesBefore(deadline time.Time, namespaces []corev1.Namespace, workers int, process func(namespace *corev1.Namespace)) []corev1.Namespace {
+	namespaces = r.skippedNamespaces.prioritize(namespaces)
+	ctx, cancel := context.WithCancel(context.Background())
+	if !deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(context.Background(), deadline)
+	}
+	defer cancel()
+	// stop dispatching the pending namespaces on shutdown
+	go func() {
+		select {
+		case <-r.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	skipped := namespaces[processNamespaces(ctx, namespaces, workers, process):]
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) || len(skipped) == 0 {
+		r.skippedNamespaces.set(nil)
+		return nil
+	}
+	r.skippedNamespaces.set(skipped)
+	reconcileDeadlineExceeded.Inc()
+	reconcileSkippedNamespaces.Add(float64(len(skipped)))
+	r.Logger.Info("reconcile cycle deadline exceeded, the rest namespaces are skipped to the next cycle",
+		"skipped", len(skipped), "total", len(namespaces), "deadline", deadline.Format(time.RFC3339))
+	return skipped
+}
@@ -0,0 +1,95 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseCycleDeadline(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    float64
+		wantErr bool
+	}{
+		{raw: "", want: 0},
+		{raw: "0.8", want: 0.8},
+		{raw: "1", want: 1},
+		{raw: "1.5", wantErr: true},
+		{raw: "-0.1", wantErr: true},
+		{raw: "half", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCycleDeadline(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseCycleDeadline(%q) = %v, %v, want %v, wantErr %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+	r := &MonitorReconciler{periodicReconcile: time.Minute, CycleDeadline: 0.5}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := r.cycleDeadline(start); !got.Equal(start.Add(30 * time.Second)) {
+		t.Errorf("cycleDeadline() = %s, want 30s after the start", got)
+	}
+	if got := (&MonitorReconciler{periodicReconcile: time.Minute}).cycleDeadline(start); !got.IsZero() {
+		t.Errorf("cycleDeadline() disabled = %s, want zero", got)
+	}
+}
+
+func TestMonitorReconciler_processNamespacesBefore(t *testing.T) {
+	r := &MonitorReconciler{Logger: logr.Discard(), stopCh: make(chan struct{})}
+	namespaces := newTestNamespaces(10)
+	skippedBefore := testutil.ToFloat64(reconcileSkippedNamespaces)
+
+	// a slow cycle near its deadline: the two namespaces in flight are committed, no namespace is started after it
+	var mu sync.Mutex
+	var committed []string
+	deadline := time.Now().Add(50 * time.Millisecond)
+	skipped := r.processNamespacesBefore(deadline, namespaces, 2, func(namespace *corev1.Namespace) {
+		time.Sleep(time.Until(deadline) + 20*time.Millisecond)
+		mu.Lock()
+		committed = append(committed, namespace.Name)
+		mu.Unlock()
+	})
+	if len(committed) != 2 || len(skipped) != 8 {
+		t.Fatalf("committed %v and skipped %d namespaces, want 2 committed and 8 skipped", committed, len(skipped))
+	}
+	if skipped[0].Name != "ns-2" {
+		t.Errorf("first skipped namespace = %s, want ns-2", skipped[0].Name)
+	}
+	if got := testutil.ToFloat64(reconcileSkippedNamespaces) - skippedBefore; got != 8 {
+		t.Errorf("skipped namespaces metric = %v, want 8", got)
+	}
+
+	// the next cycle starts with the skipped namespaces
+	var order []string
+	if skipped = r.processNamespacesBefore(time.Time{}, namespaces, 1, func(namespace *corev1.Namespace) {
+		order = append(order, namespace.Name)
+	}); len(skipped) != 0 {
+		t.Fatalf("skipped %d namespaces without a deadline, want 0", len(skipped))
+	}
+	if len(order) != 10 || order[0] != "ns-2" || order[7] != "ns-9" || order[8] != "ns-0" || order[9] != "ns-1" {
+		t.Errorf("processed order = %v, want the skipped namespaces first", order)
+	}
+	// the complete cycle clears the skipped namespaces
+	if got := r.skippedNamespaces.prioritize(namespaces); got[0].Name != "ns-0" {
+		t.Errorf("first namespace after a complete cycle = %s, want ns-0", got[0].Name)
+	}
+}
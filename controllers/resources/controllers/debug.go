@@ -0,0 +1,166 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// EnablePprof serves net/http/pprof on the api server under PprofPath, requires AdminToken
+	EnablePprof = "ENABLE_PPROF"
+	// AdminToken the bearer token of the api and the debug endpoints, eg: Authorization: Bearer <token>
+	AdminToken = "ADMIN_TOKEN"
+	// GoroutineCheckInterval the interval of checking the live goroutines, default 1m, 0 disables the check
+	GoroutineCheckInterval = "GOROUTINE_CHECK_INTERVAL"
+	// GoroutineGrowthChecks warns once the goroutines reached a new high in the consecutive checks, default 10
+	GoroutineGrowthChecks = "GOROUTINE_GROWTH_CHECKS"
+
+	// PprofPath the prefix of the pprof endpoints, eg: /debug/pprof/goroutine?debug=2
+	PprofPath = "/debug/pprof/"
+
+	DefaultGoroutineCheckInterval = time.Minute
+	DefaultGoroutineGrowthChecks  = 10
+)
+
+var (
+	goroutinesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sealos_resources_goroutines",
+		Help: "Number of the live goroutines at the last check.",
+	})
+	goroutineGrowthWarnings = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_goroutine_growth_warnings_total",
+		Help: "Number of the warnings of the goroutines growing in the consecutive checks, a likely leak.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(goroutinesGauge, goroutineGrowthWarnings)
+}
+
+// newPprofTokenFromEnv returns the admin token if the pprof is enabled, empty if disabled
+func newPprofTokenFromEnv() (string, error) {
+	if !env.GetBoolEnvWithDefault(EnablePprof, false) {
+		return "", nil
+	}
+	token := os.Getenv(AdminToken)
+	if token == "" {
+		return "", fmt.Errorf("%s requires %s, the profiles must not be served without authentication", EnablePprof, AdminToken)
+	}
+	return token, nil
+}
+
+// registerPprofHandlers serves the pprof endpoints to the requests with the bearer token
+func registerPprofHandlers(mux *http.ServeMux, token string) {
+	mux.Handle(PprofPath, requireAdminToken(token, http.HandlerFunc(pprof.Index)))
+	mux.Handle(PprofPath+"cmdline", requireAdminToken(token, http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle(PprofPath+"profile", requireAdminToken(token, http.HandlerFunc(pprof.Profile)))
+	mux.Handle(PprofPath+"symbol", requireAdminToken(token, http.HandlerFunc(pprof.Symbol)))
+	mux.Handle(PprofPath+"trace", requireAdminToken(token, http.HandlerFunc(pprof.Trace)))
+}
+
+// requireAdminToken serves the requests with the bearer token, an empty token rejects every request
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// goroutineGuard tracks the live goroutines, the goroutines of a healthy controller return to about the same number
+// after each cycle, while a leak (eg: a worker stuck in sem.Acquire or a client call without a timeout) makes a new
+// high in every check. It warns once the goroutines reached a new high in checks consecutive checks.
+type goroutineGuard struct {
+	interval time.Duration
+	checks   int
+
+	high    int
+	growing int
+}
+
+// newGoroutineGuardFromEnv returns nil if the interval is 0
+func newGoroutineGuardFromEnv() (*goroutineGuard, error) {
+	interval, err := env.GetDurationEnvWithDefault(GoroutineCheckInterval, DefaultGoroutineCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+	checks := env.GetInt64EnvWithDefault(GoroutineGrowthChecks, DefaultGoroutineGrowthChecks)
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid %s %s: must be >= 0", GoroutineCheckInterval, interval)
+	}
+	if interval == 0 {
+		return nil, nil
+	}
+	if checks < 1 {
+		return nil, fmt.Errorf("invalid %s %d: must be >= 1", GoroutineGrowthChecks, checks)
+	}
+	return &goroutineGuard{interval: interval, checks: int(checks)}, nil
+}
+
+// observe records the goroutines of a check and returns true if it warned
+func (g *goroutineGuard) observe(goroutines int, logger logr.Logger) bool {
+	goroutinesGauge.Set(float64(goroutines))
+	if goroutines <= g.high {
+		g.growing = 0
+		return false
+	}
+	first := g.high == 0
+	g.high = goroutines
+	if first {
+		return false
+	}
+	g.growing++
+	if g.growing < g.checks {
+		return false
+	}
+	g.growing = 0
+	goroutineGrowthWarnings.Inc()
+	logger.Info("the goroutines keep growing, likely leaked, see "+PprofPath+"goroutine?debug=2", "goroutines", goroutines, "checks", g.checks)
+	return true
+}
+
+func (r *MonitorReconciler) startGoroutineGuard() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.goroutineGuard.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.goroutineGuard.observe(runtime.NumGoroutine(), r.Logger)
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,115 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestGoroutineGuard_observe(t *testing.T) {
+	g := &goroutineGuard{checks: 3}
+	// a new high after each of 3 checks warns, the steady goroutines don't
+	tests := []struct {
+		goroutines int
+		want       bool
+	}{
+		{goroutines: 100}, {goroutines: 120}, {goroutines: 110}, {goroutines: 120},
+		{goroutines: 130}, {goroutines: 140}, {goroutines: 150, want: true},
+		{goroutines: 160}, {goroutines: 150}, {goroutines: 170}, {goroutines: 180}, {goroutines: 190, want: true},
+	}
+	for i, tt := range tests {
+		if got := g.observe(tt.goroutines, logr.Discard()); got != tt.want {
+			t.Errorf("check %d observe(%d) = %v, want %v", i, tt.goroutines, got, tt.want)
+		}
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	mux := http.NewServeMux()
+	registerPprofHandlers(mux, "secret")
+	tests := []struct {
+		authorization string
+		want          int
+	}{
+		{want: http.StatusUnauthorized},
+		{authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{authorization: "secret", want: http.StatusUnauthorized},
+		{authorization: "Bearer secret", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, PprofPath+"goroutine?debug=1", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("Authorization %q status = %d, want %d", tt.authorization, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestMonitorReconciler_processNamespaceList_goroutines(t *testing.T) {
+	namespaces := newTestNamespaces(20)
+	var objects []client.Object
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	for _, namespace := range namespaces {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Name: "app-0", Labels: map[string]string{resources.AppLabelKey: "app"}},
+			Spec:       corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{qosContainer(qosResources("1", "1Gi"), nil)}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		})
+	}
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{
+		Client:            fake.NewClientBuilder().WithObjects(objects...).Build(),
+		Logger:            logr.Discard(),
+		DBClient:          db,
+		Properties:        resources.DefaultPropertyTypeLS,
+		MeteringPolicy:    MeteringPolicyRequests,
+		GpuMeteringPolicy: GpuMeteringPolicyReservation,
+	}
+
+	baseline := runtime.NumGoroutine()
+	for cycle := 0; cycle < 3; cycle++ {
+		if err := r.processNamespaceList(&corev1.NamespaceList{Items: namespaces}, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.Monitors()) == 0 {
+		t.Fatal("no monitors inserted")
+	}
+	// the exited goroutines may take a moment to be reaped
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > baseline {
+		t.Errorf("goroutines = %d after the cycles, want at most the baseline %d", got, baseline)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/labring/sealos/controllers/pkg/gpu"
+)
+
+const (
+	// GpuNodeAggregationEnv reconciles the gpu billed to the pods of each node: none (default) or capacity
+	GpuNodeAggregationEnv = "GPU_NODE_AGGREGATION"
+
+	GpuNodeAggregationNone     = "none"
+	GpuNodeAggregationCapacity = "capacity"
+
+	gpuNodeAggregationTimeout = 30 * time.Second
+)
+
+// GpuNodeUsage the gpu of a node in milli gpus
+type GpuNodeUsage struct {
+	// Billed the sum of the gpu billed to the pods of the node, the time-sliced replicas already divided
+	Billed int64
+	// Physical the physical gpus of the node
+	Physical int64
+}
+
+// GpuNodeAggregator reconciles the gpu billed to the pods of each node after the gpu is accumulated per pod, eg: the
+// partial gpus of the pods sharing the gpus of a node may sum up to more than the node has. Weights returns the factor
+// of the billed gpu of the pods of each node, the nodes left out are billed as is.
+type GpuNodeAggregator interface {
+	Weights(nodes map[string]GpuNodeUsage) map[string]float64
+}
+
+// GpuCapacityAggregator caps the billed gpu of a node at its physical gpus, distributed to the pods of the node in
+// proportion to their billed gpu: weight = physical / billed if billed > physical
+type GpuCapacityAggregator struct{}
+
+func (GpuCapacityAggregator) Weights(nodes map[string]GpuNodeUsage) map[string]float64 {
+	weights := map[string]float64{}
+	for name, usage := range nodes {
+		if usage.Physical > 0 && usage.Billed > usage.Physical {
+			weights[name] = float64(usage.Physical) / float64(usage.Billed)
+		}
+	}
+	return weights
+}
+
+// parseGpuNodeAggregator returns nil if the gpu is billed per pod only
+func parseGpuNodeAggregator(value string) (GpuNodeAggregator, error) {
+	switch value {
+	case "", GpuNodeAggregationNone:
+		return nil, nil
+	case GpuNodeAggregationCapacity:
+		return GpuCapacityAggregator{}, nil
+	}
+	return nil, fmt.Errorf("invalid %s %q, must be one of: %s, %s", GpuNodeAggregationEnv, value, GpuNodeAggregationNone, GpuNodeAggregationCapacity)
+}
+
+// gpuNodeWeights the weights of the gpu nodes of the last cycle, the zero value weights nothing
+type gpuNodeWeights struct {
+	mu      sync.RWMutex
+	weights map[string]float64
+}
+
+func (w *gpuNodeWeights) set(weights map[string]float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.weights = weights
+}
+
+// weigh returns the billed gpu of a pod on the node weighted by the node, the quantity itself if the node has no weight
+func (w *gpuNodeWeights) weigh(node string, billed resource.Quantity) resource.Quantity {
+	w.mu.RLock()
+	weight, ok := w.weights[node]
+	w.mu.RUnlock()
+	if !ok {
+		return billed
+	}
+	return *resource.NewMilliQuantity(int64(math.Round(float64(billed.MilliValue())*weight)), resource.DecimalSI)
+}
+
+// refreshGpuNodeWeights sums the gpu billed to the pods of all the namespaces per node and weights the nodes by the
+// GpuNodeAggregator once per cycle, the weights of the last cycle are kept if the pods fail to list
+func (r *MonitorReconciler) refreshGpuNodeWeights(ctx context.Context) {
+	if r.GpuNodeAggregator == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, gpuNodeAggregationTimeout)
+	defer cancel()
+	pods := &corev1.PodList{}
+	if err := r.Client.List(ctx, pods); err != nil {
+		r.Logger.Error(err, "failed to list the pods to aggregate the gpu of the nodes")
+		return
+	}
+	r.gpuNodeWeights.set(r.GpuNodeAggregator.Weights(r.gpuNodeUsage(pods.Items)))
+}
+
+// gpuNodeUsage returns the gpu billed to the metered pods of each gpu node and its physical gpus
+func (r *MonitorReconciler) gpuNodeUsage(pods []corev1.Pod) map[string]GpuNodeUsage {
+	nodes := map[string]GpuNodeUsage{}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed || !r.GpuMeteringPolicy.metered(pod) {
+			continue
+		}
+		gpuModel, ok := r.gpuModel(pod.Spec.NodeName)
+		if !ok {
+			continue
+		}
+		usage, ok := nodes[pod.Spec.NodeName]
+		if !ok {
+			// the nodes without the physical gpu count label are not capped
+			count, _ := strconv.ParseInt(gpuModel.GpuInfo.GpuCount, 10, 64)
+			usage.Physical = count * 1000
+		}
+		for _, container := range pod.Spec.Containers {
+			if gpuRequest, ok := container.Resources.Limits[gpu.NvidiaGpuKey]; ok {
+				billed, _, _ := r.billedGpu(gpuModel, gpuRequest)
+				usage.Billed += billed.MilliValue()
+			}
+		}
+		nodes[pod.Spec.NodeName] = usage
+	}
+	return nodes
+}
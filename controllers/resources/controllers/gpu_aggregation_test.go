@@ -0,0 +1,143 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/gpu"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseGpuNodeAggregator(t *testing.T) {
+	for value, want := range map[string]GpuNodeAggregator{"": nil, "none": nil, "capacity": GpuCapacityAggregator{}} {
+		if got, err := parseGpuNodeAggregator(value); err != nil || got != want {
+			t.Errorf("parseGpuNodeAggregator(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if _, err := parseGpuNodeAggregator("round"); err == nil {
+		t.Error("parseGpuNodeAggregator(round) expected error")
+	}
+}
+
+func TestGpuCapacityAggregator(t *testing.T) {
+	got := GpuCapacityAggregator{}.Weights(map[string]GpuNodeUsage{
+		"over-billed": {Billed: 3000, Physical: 2000},
+		"full":        {Billed: 2000, Physical: 2000},
+		"idle":        {Billed: 500, Physical: 2000},
+		"no-count":    {Billed: 3000},
+	})
+	if want := map[string]float64{"over-billed": 2.0 / 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Weights() = %v, want %v", got, want)
+	}
+}
+
+// fixedGpuAggregator a custom aggregator weighting every node with a gpu billed
+type fixedGpuAggregator struct {
+	weight float64
+	usage  map[string]GpuNodeUsage
+}
+
+func (a *fixedGpuAggregator) Weights(nodes map[string]GpuNodeUsage) map[string]float64 {
+	a.usage = nodes
+	weights := map[string]float64{}
+	for name := range nodes {
+		weights[name] = a.weight
+	}
+	return weights
+}
+
+func TestMonitorReconciler_GpuNodeAggregation(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	gpuPod := func(namespace, name, node, gpus string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec: corev1.PodSpec{NodeName: node, Containers: []corev1.Container{
+				qosContainer(nil, corev1.ResourceList{gpu.NvidiaGpuKey: resource.MustParse(gpus)}),
+			}},
+			Status: corev1.PodStatus{Phase: phase, StartTime: &started},
+		}
+	}
+	// the pods of two tenants share the single gpu of the node shared, the node sliced has 4 replicas of its gpu
+	objects := []client.Object{
+		gpuPod("ns-user-a", "train", "shared", "1", corev1.PodRunning),
+		gpuPod("ns-user-b", "infer", "shared", "1", corev1.PodRunning),
+		gpuPod("ns-user-b", "done", "shared", "1", corev1.PodSucceeded),
+		gpuPod("ns-user-a", "sliced-0", "sliced", "2", corev1.PodRunning),
+		gpuPod("ns-user-b", "sliced-1", "sliced", "2", corev1.PodPending),
+		gpuPod("ns-user-b", "no-gpu-node", "cpu-node", "1", corev1.PodRunning),
+	}
+	newReconciler := func(aggregator GpuNodeAggregator) *MonitorReconciler {
+		return &MonitorReconciler{
+			Client:            fake.NewClientBuilder().WithObjects(objects...).Build(),
+			Logger:            logr.Discard(),
+			GpuReplicasLabel:  gpu.NvidiaGpuReplicasKey,
+			GpuMeteringPolicy: GpuMeteringPolicyReservation,
+			GpuNodeAggregator: aggregator,
+			NvidiaGpu: map[string]gpu.NvidiaGPU{
+				"shared": {GpuInfo: gpu.Information{GpuProduct: "Tesla-T4", GpuCount: "1"}, Capacity: 1},
+				"sliced": {GpuInfo: gpu.Information{GpuProduct: "Tesla-T4", GpuCount: "1"}, Labels: map[string]string{gpu.NvidiaGpuReplicasKey: "4"}},
+			},
+		}
+	}
+	billed := func(r *MonitorReconciler, node, req string) int64 {
+		t.Helper()
+		rs := initResources()
+		if err := r.getGPUResourceUsage(corev1.Pod{Spec: corev1.PodSpec{NodeName: node}}, resource.MustParse(req), rs); err != nil {
+			t.Fatalf("getGPUResourceUsage() error = %v", err)
+		}
+		return rs[resources.NewGpuResource("Tesla-T4")].MilliValue()
+	}
+
+	tests := []struct {
+		name       string
+		aggregator GpuNodeAggregator
+		wantShared int64
+		wantSliced int64
+	}{
+		{name: "per pod", wantShared: 1000, wantSliced: 500},
+		{name: "capped at the capacity", aggregator: GpuCapacityAggregator{}, wantShared: 500, wantSliced: 500},
+		{name: "custom", aggregator: &fixedGpuAggregator{weight: 0.1}, wantShared: 100, wantSliced: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReconciler(tt.aggregator)
+			r.refreshGpuNodeWeights(context.Background())
+			if got := billed(r, "shared", "1"); got != tt.wantShared {
+				t.Errorf("billed milli gpu on the shared node = %d, want %d", got, tt.wantShared)
+			}
+			if got := billed(r, "sliced", "2"); got != tt.wantSliced {
+				t.Errorf("billed milli gpu on the sliced node = %d, want %d", got, tt.wantSliced)
+			}
+		})
+	}
+
+	custom := &fixedGpuAggregator{weight: 1}
+	newReconciler(custom).refreshGpuNodeWeights(context.Background())
+	want := map[string]GpuNodeUsage{"shared": {Billed: 2000, Physical: 1000}, "sliced": {Billed: 1000, Physical: 1000}}
+	if !reflect.DeepEqual(custom.usage, want) {
+		t.Errorf("gpu node usage = %v, want %v", custom.usage, want)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labring/sealos/controllers/pkg/gpu"
+)
+
+func (r *MonitorReconciler) gpuModel(nodeName string) (gpu.NvidiaGPU, bool) {
+	r.gpuMu.RLock()
+	defer r.gpuMu.RUnlock()
+	gpuModel, ok := r.NvidiaGpu[nodeName]
+	return gpuModel, ok
+}
+
+// refreshGpuModels lists the gpu nodes again, eg: a pod runs on a gpu node joined after the start
+func (r *MonitorReconciler) refreshGpuModels() error {
+	gpuModels, err := gpu.GetNodeGpuModel(r.Client)
+	if err != nil {
+		return fmt.Errorf("get node gpu model failed: %w", err)
+	}
+	r.gpuMu.Lock()
+	r.NvidiaGpu = gpuModels
+	r.gpuMu.Unlock()
+	return nil
+}
+
+// gpuNodeModel the gpu of a node as the controller bills it
+type gpuNodeModel struct {
+	Product string `json:"product"`
+	Count   string `json:"count"`
+	Memory  string `json:"memory,omitempty"`
+	// Replicas the time-sliced replicas per physical gpu, a replica is billed as 1/replicas gpu
+	Replicas int64 `json:"replicas"`
+	// ReplicasSource the replicas are read from the label, the capacity of the node or the default
+	ReplicasSource string `json:"replicasSource"`
+	MigStrategy    string `json:"migStrategy,omitempty"`
+}
+
+// gpuModelsHandler returns the gpu of the nodes detected by the controller as json, keyed by the node name.
+// It only reads the detected models, the nodes are listed again when a pod runs on an unknown node.
+// eg: GET /api/v1/admin/gpu-models
+func (r *MonitorReconciler) gpuModelsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.gpuMu.RLock()
+	nodes := make(map[string]gpuNodeModel, len(r.NvidiaGpu))
+	for name, gpuModel := range r.NvidiaGpu {
+		replicas, source := r.getGpuReplicas(gpuModel)
+		nodes[name] = gpuNodeModel{
+			Product:        gpuModel.GpuInfo.GpuProduct,
+			Count:          gpuModel.GpuInfo.GpuCount,
+			Memory:         gpuModel.GpuDetails.GpuMemory,
+			Replicas:       replicas,
+			ReplicasSource: source,
+			MigStrategy:    gpuModel.MigInfo.MigStrategy,
+		}
+	}
+	r.gpuMu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		ReplicasLabel string                  `json:"replicasLabel"`
+		Nodes         map[string]gpuNodeModel `json:"nodes"`
+	}{ReplicasLabel: r.GpuReplicasLabel, Nodes: nodes}); err != nil {
+		r.Logger.Error(err, "failed to write gpu models")
+	}
+}
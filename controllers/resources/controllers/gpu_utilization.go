@@ -0,0 +1,143 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	// GpuUtilizationCollector records the dcgm gpu utilization of the gpu apps in the monitors if true,
+	// the utilization is not billed, the gpu is still billed by the reservation.
+	GpuUtilizationCollector = "GPU_UTILIZATION_COLLECTOR"
+	// GpuUtilizationQuery the utilization percent query template per pod, placeholder {{.Namespace}}
+	GpuUtilizationQuery = "GPU_UTILIZATION_QUERY"
+
+	DefaultGpuUtilizationQuery = `avg by (pod) (avg_over_time(DCGM_FI_DEV_GPU_UTIL{namespace="{{.Namespace}}"}[1m]))`
+)
+
+// gpuUtilizationCollector queries the actual gpu utilization of the pods from the dcgm exporter metrics
+type gpuUtilizationCollector struct {
+	promAPI v1.API
+	query   *template.Template
+}
+
+func newGpuUtilizationCollector(promURL, query string) (*gpuUtilizationCollector, error) {
+	if promURL == "" {
+		return nil, fmt.Errorf("gpu utilization collector requires env: %s", PrometheusURL)
+	}
+	if query == "" {
+		query = DefaultGpuUtilizationQuery
+	}
+	tmpl, err := template.New("gpu-utilization").Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gpu utilization query template %q: %w", query, err)
+	}
+	promClient, err := api.NewClient(api.Config{Address: promURL})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new prometheus client: %w", err)
+	}
+	return &gpuUtilizationCollector{promAPI: v1.NewAPI(promClient), query: tmpl}, nil
+}
+
+// podUtilization returns the gpu utilization percent by the pod name of the namespace
+func (c *gpuUtilizationCollector) podUtilization(namespace string) (map[string]float64, error) {
+	var sb strings.Builder
+	if err := c.query.Execute(&sb, struct{ Namespace string }{Namespace: namespace}); err != nil {
+		return nil, fmt.Errorf("failed to render gpu utilization query: %w", err)
+	}
+	query := sb.String()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, _, err := c.promAPI.Query(ctx, query, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prometheus, query: %v, err: %w", query, err)
+	}
+	vector, ok := result.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("unexpected prometheus result type %s, query: %v", result.Type(), query)
+	}
+	utilization := make(map[string]float64, len(vector))
+	for _, sample := range vector {
+		if pod := string(sample.Metric["pod"]); pod != "" {
+			utilization[pod] = float64(sample.Value)
+		}
+	}
+	return utilization, nil
+}
+
+// gpuPods the gpu pods of the apps in the namespace, app -> gpu resource -> pod names
+type gpuPods map[string]map[corev1.ResourceName][]string
+
+func (p gpuPods) add(app string, gpuResource corev1.ResourceName, pod string) {
+	if p[app] == nil {
+		p[app] = make(map[corev1.ResourceName][]string)
+	}
+	p[app][gpuResource] = append(p[app][gpuResource], pod)
+}
+
+// getGpuUtilization returns the average gpu utilization percent of the app pods by the gpu enum,
+// the pods without the dcgm metrics are ignored.
+func (r *MonitorReconciler) getGpuUtilization(namespace string, pods gpuPods) map[string]map[uint8]int64 {
+	if r.gpuUtilization == nil || len(pods) == 0 {
+		return nil
+	}
+	podUtil, err := r.gpuUtilization.podUtilization(namespace)
+	if err != nil {
+		r.Logger.Error(err, "failed to query gpu utilization", "namespace", namespace)
+		return nil
+	}
+	return averageGpuUtilization(r.properties().StringMap, pods, podUtil)
+}
+
+func averageGpuUtilization(properties map[string]resources.PropertyType, pods gpuPods, podUtil map[string]float64) map[string]map[uint8]int64 {
+	utilization := make(map[string]map[uint8]int64)
+	for app, gpuResources := range pods {
+		for gpuResource, names := range gpuResources {
+			pType, ok := properties[gpuResource.String()]
+			if !ok {
+				continue
+			}
+			var total float64
+			var count int
+			for _, name := range names {
+				if util, ok := podUtil[name]; ok {
+					total += util
+					count++
+				}
+			}
+			if count == 0 {
+				continue
+			}
+			if utilization[app] == nil {
+				utilization[app] = make(map[uint8]int64)
+			}
+			utilization[app][pType.Enum] = int64(math.Round(total / float64(count)))
+		}
+	}
+	return utilization
+}
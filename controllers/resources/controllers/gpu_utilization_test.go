@@ -0,0 +1,79 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestGpuUtilizationCollector_podUtilization(t *testing.T) {
+	var gotQuery string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		gotQuery = req.Form.Get("query")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[`+
+			`{"metric":{"pod":"train-0"},"value":[1700000000,"5.4"]},`+
+			`{"metric":{"pod":"train-1"},"value":[1700000000,"80"]}]}}`)
+	}))
+	defer prom.Close()
+
+	c, err := newGpuUtilizationCollector(prom.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	util, err := c.podUtilization("ns-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `avg by (pod) (avg_over_time(DCGM_FI_DEV_GPU_UTIL{namespace="ns-a"}[1m]))`; gotQuery != want {
+		t.Errorf("query = %s, want %s", gotQuery, want)
+	}
+	if util["train-0"] != 5.4 || util["train-1"] != 80 {
+		t.Errorf("podUtilization() = %v, want train-0: 5.4, train-1: 80", util)
+	}
+}
+
+func TestAverageGpuUtilization(t *testing.T) {
+	a100 := resources.NewGpuResource("NVIDIA-A100")
+	properties := map[string]resources.PropertyType{a100.String(): {Name: a100.String(), Enum: 7}}
+	pods := gpuPods{}
+	pods.add("app-train", a100, "train-0")
+	pods.add("app-train", a100, "train-1")
+	pods.add("app-idle", a100, "idle-0")
+	pods.add("app-no-metrics", a100, "new-0")
+	pods.add("app-unknown", "gpu-unknown", "unknown-0")
+
+	got := averageGpuUtilization(properties, pods, map[string]float64{"train-0": 10, "train-1": 91, "idle-0": 5, "unknown-0": 50})
+	if got["app-train"][7] != 51 {
+		t.Errorf("app-train utilization = %v, want 51", got["app-train"][7])
+	}
+	if got["app-idle"][7] != 5 {
+		t.Errorf("app-idle utilization = %v, want 5", got["app-idle"][7])
+	}
+	if _, ok := got["app-no-metrics"]; ok {
+		t.Errorf("app-no-metrics utilization = %v, want none", got["app-no-metrics"])
+	}
+	if _, ok := got["app-unknown"]; ok {
+		t.Errorf("app-unknown utilization = %v, want none", got["app-unknown"])
+	}
+}
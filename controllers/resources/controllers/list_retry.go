@@ -0,0 +1,85 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+const (
+	// ListRetryAttempts the attempts of listing the pods, the pvcs and the services of a namespace, default 3, 1 disables
+	// the retry. Only the timeouts, the throttling (429), the conflicts and the broken connections are retried.
+	ListRetryAttempts = "LIST_RETRY_ATTEMPTS"
+
+	DefaultListRetryAttempts = 3
+
+	// listRetryJitter jitters the backoff up to twice, so the namespaces failed at once don't retry together
+	listRetryJitter = 1.0
+)
+
+// listRetryInterval the base backoff of the list retry, the nth retry waits (2n-1) times of it before the jitter
+var listRetryInterval = 200 * time.Millisecond
+
+var listRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sealos_resources_list_retries_total",
+	Help: "Number of the retries of listing the resources of a namespace after a transient api server error.",
+}, []string{"resource"})
+
+func init() {
+	metrics.Registry.MustRegister(listRetries)
+}
+
+// classifyListError marks the api server errors worth a retry transient: the timeouts, the throttling, the conflicts and
+// the broken connections. The other errors (eg: forbidden, an invalid selector) are returned as is and not retried,
+// retrying them would only add load to a broken api server.
+func classifyListError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), apierrors.IsTooManyRequests(err), apierrors.IsConflict(err),
+		utilnet.IsConnectionReset(err), utilnet.IsProbableEOF(err), errors.Is(err, context.DeadlineExceeded):
+		return retry.Transient(err)
+	}
+	return err
+}
+
+// listWithRetry lists the resources by the reader and retries the transient errors with a jittered backoff
+func (r *MonitorReconciler) listWithRetry(reader client.Reader, resource string, list client.ObjectList, opts ...client.ListOption) error {
+	attempts := r.ListRetryAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	tries := 0
+	return retry.RetryTransientWithJitter(attempts, listRetryInterval, listRetryJitter, func() error {
+		if tries++; tries > 1 {
+			listRetries.WithLabelValues(resource).Inc()
+		}
+		err := classifyListError(reader.List(context.Background(), list, opts...))
+		if retry.IsTransient(err) && tries < attempts {
+			r.Logger.V(1).Info("retry listing after a transient error", "resource", resource, "attempt", tries, "error", err.Error())
+		}
+		return err
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// flakyReader fails the first lists with the errors, then lists the pods
+type flakyReader struct {
+	client.Reader
+	errs  []error
+	pods  []corev1.Pod
+	calls int
+}
+
+func (f *flakyReader) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	list.(*corev1.PodList).Items = f.pods
+	return nil
+}
+
+func TestMonitorReconciler_listWithRetry(t *testing.T) {
+	interval := listRetryInterval
+	listRetryInterval = time.Millisecond
+	defer func() { listRetryInterval = interval }()
+
+	pods := schema.GroupResource{Resource: "pods"}
+	throttled := apierrors.NewTooManyRequests("slow down", 1)
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "throttled", errs: []error{throttled, throttled}, wantCalls: 3},
+		{name: "timeout", errs: []error{apierrors.NewServerTimeout(pods, "list", 1)}, wantCalls: 2},
+		{name: "conflict", errs: []error{apierrors.NewConflict(pods, "", errors.New("changed"))}, wantCalls: 2},
+		{name: "deadline", errs: []error{context.DeadlineExceeded}, wantCalls: 2},
+		{name: "exhausted", errs: []error{throttled, throttled, throttled, throttled}, wantCalls: 3, wantErr: true},
+		{name: "forbidden", errs: []error{apierrors.NewForbidden(pods, "", errors.New("rbac"))}, wantCalls: 1, wantErr: true},
+		{name: "not retryable", errs: []error{errors.New("no kind is registered")}, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := &flakyReader{errs: tt.errs, pods: []corev1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "app-a-0"}}}}
+			r := &MonitorReconciler{Logger: logr.Discard(), ListRetryAttempts: 3}
+			before := testutil.ToFloat64(listRetries.WithLabelValues("pods"))
+			podList := corev1.PodList{}
+			err := r.listWithRetry(reader, "pods", &podList, &client.ListOptions{Namespace: "ns-user-a"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if reader.calls != tt.wantCalls {
+				t.Errorf("listWithRetry() calls = %d, want %d", reader.calls, tt.wantCalls)
+			}
+			if got := testutil.ToFloat64(listRetries.WithLabelValues("pods")) - before; got != float64(tt.wantCalls-1) {
+				t.Errorf("list retries = %v, want %d", got, tt.wantCalls-1)
+			}
+			if !tt.wantErr && len(podList.Items) != 1 {
+				t.Errorf("listWithRetry() pods = %d, want 1", len(podList.Items))
+			}
+		})
+	}
+
+	// the retry is disabled by 1 attempt
+	reader := &flakyReader{errs: []error{throttled}}
+	r := &MonitorReconciler{Logger: logr.Discard(), ListRetryAttempts: 1}
+	if err := r.listWithRetry(reader, "pods", &corev1.PodList{}); err == nil || reader.calls != 1 {
+		t.Errorf("listWithRetry() without retry = %v, calls %d, want the error, 1 call", err, reader.calls)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// loadBalancerQuantities returns the measured ports and ingress ips of the LoadBalancer service, nil if its load
+// balancer is pending. The ports and the ips are metered apart to be priced apart, each one only if its property is
+// configured, and one port or ip is measured as a node port (default 1:1000, configured by the property ratio).
+func (r *MonitorReconciler) loadBalancerQuantities(svc *corev1.Service) map[corev1.ResourceName]resource.Quantity {
+	ingress := svc.Status.LoadBalancer.Ingress
+	if len(ingress) == 0 {
+		return nil
+	}
+	ips := 0
+	for i := range ingress {
+		if ingress[i].IP != "" {
+			ips++
+		}
+	}
+	counts := map[string]int{
+		resources.ResourceLoadBalancerPorts: len(svc.Spec.Ports),
+		resources.ResourceLoadBalancerIPs:   ips,
+	}
+	quantities := make(map[corev1.ResourceName]resource.Quantity, len(counts))
+	properties := r.properties()
+	for name, count := range counts {
+		if _, ok := properties.StringMap[name]; !ok || count == 0 {
+			continue
+		}
+		ratio := properties.GetRatio(name, resources.DefaultNodePortRatio)
+		quantities[corev1.ResourceName(name)] = *resource.NewQuantity(int64(count)*ratio, resource.BinarySI)
+	}
+	return quantities
+}
@@ -0,0 +1,85 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMonitorReconciler_monitorResourceUsage_LoadBalancer(t *testing.T) {
+	service := func(app string, ingress ...corev1.LoadBalancerIngress) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: app, Labels: map[string]string{resources.AppLabelKey: app}},
+			Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer,
+				Ports: []corev1.ServicePort{{Name: "http", Port: 80}, {Name: "https", Port: 443}}},
+			Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: ingress}},
+		}
+	}
+	c := fake.NewClientBuilder().WithObjects(
+		service("app-lb", corev1.LoadBalancerIngress{IP: "192.0.2.1"}, corev1.LoadBalancerIngress{IP: "192.0.2.2"}),
+		// the load balancer is not provisioned yet
+		service("app-pending"),
+	).Build()
+	types := append([]resources.PropertyType{}, resources.DefaultPropertyTypeList...)
+	types = append(types,
+		resources.PropertyType{Name: resources.ResourceLoadBalancerPorts, Enum: 5, PriceType: resources.AVG, Unit: resource.MustParse("1")},
+		resources.PropertyType{Name: resources.ResourceLoadBalancerIPs, Enum: 6, PriceType: resources.AVG, Unit: resource.MustParse("1")},
+	)
+	properties := &resources.PropertyTypeLS{Types: types, StringMap: resources.PropertyTypeStringMap{}, EnumMap: resources.PropertyTypeEnumMap{}}
+	for _, pType := range types {
+		properties.StringMap[pType.Name] = pType
+		properties.EnumMap[pType.Enum] = pType
+	}
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{
+		Client:            c,
+		Logger:            logr.Discard(),
+		DBClient:          db,
+		Properties:        properties,
+		MeteringPolicy:    MeteringPolicyLimits,
+		GpuMeteringPolicy: GpuMeteringPolicyReservation,
+	}
+	if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+		t.Fatal(err)
+	}
+	monitors := db.Monitors()
+	if len(monitors) != 1 || monitors[0].Name != "app-lb" {
+		t.Fatalf("monitors = %+v, want only app-lb", monitors)
+	}
+	// the 2 ports and the 2 ips are measured 1000 each
+	want := resources.EnumUsedMap{5: 2000, 6: 2000}
+	if got := monitors[0].Used; len(got) != len(want) || got[5] != want[5] || got[6] != want[6] {
+		t.Errorf("used = %v, want %v", got, want)
+	}
+
+	// without the properties the LoadBalancer services are not metered
+	db = databasetest.NewMemoryStore()
+	r.DBClient, r.Properties = db, resources.DefaultPropertyTypeLS
+	if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(db.Monitors()); got != 0 {
+		t.Errorf("monitors without the properties = %d, want 0", got)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/utils/logger"
+)
+
+const (
+	// MeteringPauseThreshold pauses the metering after the consecutive failed monitor inserts, default 10, 0 disables the pause
+	MeteringPauseThreshold = "METERING_PAUSE_THRESHOLD"
+
+	DefaultMeteringPauseThreshold = 10
+)
+
+var (
+	meteringPaused = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sealos_resources_metering_paused",
+		Help: "1 if the metering is paused because the monitor database is unhealthy, otherwise 0.",
+	})
+	meteringPausedCycles = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_metering_paused_cycles_total",
+		Help: "Number of the reconcile cycles skipped while the metering is paused.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(meteringPaused, meteringPausedCycles)
+}
+
+// errMeteringPaused the monitors are not inserted while the metering is paused
+var errMeteringPaused = errors.New("metering is paused, the monitor database is unhealthy")
+
+// meteringValve pauses the metering while the monitor database is unhealthy, so the namespace workers don't spend
+// the cycle retrying the inserts of the monitors that are lost anyway, and the failures are logged once.
+// After threshold consecutive failed inserts the valve closes: the rest of the cycle is skipped and the next cycles
+// only probe the database, the metering resumes at the first cycle the probe succeeds.
+// The methods of a nil valve never pause.
+type meteringValve struct {
+	threshold int
+
+	mu       sync.Mutex
+	failures int
+	closed   bool
+}
+
+// newMeteringValve returns nil if the threshold <= 0
+func newMeteringValve(threshold int) *meteringValve {
+	if threshold <= 0 {
+		return nil
+	}
+	return &meteringValve{threshold: threshold}
+}
+
+// paused returns true while the metering is paused
+func (v *meteringValve) paused() bool {
+	if v == nil {
+		return false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.closed
+}
+
+// record counts the result of a monitor insert, the metering is paused once the failures reach the threshold
+func (v *meteringValve) record(err error) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err == nil {
+		v.failures = 0
+		return
+	}
+	if v.closed {
+		return
+	}
+	v.failures++
+	if v.failures < v.threshold {
+		return
+	}
+	v.closed = true
+	meteringPaused.Set(1)
+	logger.Error("metering paused, the monitor database is unhealthy, the database is probed at the start of each cycle",
+		"consecutive failures", v.failures, "err", err)
+}
+
+// startCycle probes the database if the metering is paused and returns whether the cycle may meter,
+// the failed probes are counted in the skipped cycles without logging.
+func (v *meteringValve) startCycle(probe func() error) bool {
+	if v == nil {
+		return true
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.closed {
+		return true
+	}
+	if err := probe(); err != nil {
+		meteringPausedCycles.Inc()
+		return false
+	}
+	v.closed, v.failures = false, 0
+	meteringPaused.Set(0)
+	logger.Info("monitor database recovered, metering resumed")
+	return true
+}
+
+// probeMonitorDB reads the latest hourly aggregate, a small read every store sends to the database
+func (r *MonitorReconciler) probeMonitorDB() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := r.DBClient.LatestMonitorAggregate(ctx, database.MonitorHourly)
+	return err
+}
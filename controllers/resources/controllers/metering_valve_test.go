@@ -0,0 +1,138 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+func TestMeteringValve(t *testing.T) {
+	errDown := errors.New("mongo unavailable")
+	v := newMeteringValve(3)
+	v.record(errDown)
+	v.record(errDown)
+	v.record(nil)
+	v.record(errDown)
+	v.record(errDown)
+	if v.paused() {
+		t.Fatal("metering paused before 3 consecutive failures")
+	}
+	v.record(errDown)
+	if !v.paused() || testutil.ToFloat64(meteringPaused) != 1 {
+		t.Fatal("metering is not paused after 3 consecutive failures")
+	}
+
+	skipped := testutil.ToFloat64(meteringPausedCycles)
+	if v.startCycle(func() error { return errDown }) || !v.paused() {
+		t.Fatal("cycle started with the database still down")
+	}
+	if got := testutil.ToFloat64(meteringPausedCycles) - skipped; got != 1 {
+		t.Errorf("skipped cycles = %v, want 1", got)
+	}
+	if !v.startCycle(func() error { return nil }) || v.paused() || testutil.ToFloat64(meteringPaused) != 0 {
+		t.Fatal("metering not resumed after a successful probe")
+	}
+	// the failures are counted from zero once resumed
+	v.record(errDown)
+	v.record(errDown)
+	if v.paused() {
+		t.Error("metering paused again before 3 new consecutive failures")
+	}
+}
+
+func TestMeteringValve_Disabled(t *testing.T) {
+	v := newMeteringValve(0)
+	if v != nil {
+		t.Fatalf("newMeteringValve(0) = %+v, want nil", v)
+	}
+	for i := 0; i < 10; i++ {
+		v.record(errors.New("mongo unavailable"))
+	}
+	if v.paused() || !v.startCycle(func() error { return errors.New("mongo unavailable") }) {
+		t.Error("disabled valve pauses the metering")
+	}
+}
+
+// downDB fails every insert and the probes until it's up
+type downDB struct {
+	database.MonitorStore
+	up      bool
+	inserts int
+	probes  int
+}
+
+func (db *downDB) InsertMonitor(_ context.Context, _ ...*resources.Monitor) error {
+	db.inserts++
+	if db.up {
+		return nil
+	}
+	return retry.Permanent(errors.New("mongo unavailable"))
+}
+
+func (db *downDB) LatestMonitorAggregate(_ context.Context, _ database.MonitorGranularity) (time.Time, error) {
+	db.probes++
+	if db.up {
+		return time.Time{}, nil
+	}
+	return time.Time{}, errors.New("mongo unavailable")
+}
+
+func TestMonitorReconciler_processNamespaceList_MeteringPaused(t *testing.T) {
+	db := &downDB{}
+	r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, meteringValve: newMeteringValve(2)}
+	for i := 0; i < 5; i++ {
+		if err := r.writeMonitors("ns-a", namespaceMonitors("ns-a", 1)); err == nil {
+			t.Fatalf("write %d to the down database succeeded", i)
+		}
+	}
+	// the writes after the pause don't reach the database
+	if db.inserts != 2 {
+		t.Errorf("inserts = %d, want 2 before the pause", db.inserts)
+	}
+	if err := r.writeMonitors("ns-a", namespaceMonitors("ns-a", 1)); !errors.Is(err, errMeteringPaused) {
+		t.Errorf("writeMonitors() while paused = %v, want %v", err, errMeteringPaused)
+	}
+
+	namespaces := &corev1.NamespaceList{Items: []corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "ns-a"}}}}
+	// the namespaces are not processed while paused, the controller client is not even set
+	if err := r.processNamespaceList(namespaces, time.Time{}); err != nil {
+		t.Fatalf("processNamespaceList() while paused error = %v", err)
+	}
+	if db.inserts != 2 {
+		t.Errorf("inserts = %d while paused, want none", db.inserts)
+	}
+	if r.meteringValve.startCycle(r.probeMonitorDB) || db.probes != 1 {
+		t.Fatalf("cycle started with the database down, probes = %d", db.probes)
+	}
+	db.up = true
+	if !r.meteringValve.startCycle(r.probeMonitorDB) {
+		t.Fatal("metering not resumed once the database is up")
+	}
+	if err := r.writeMonitors("ns-a", namespaceMonitors("ns-a", 1)); err != nil || db.inserts != 3 {
+		t.Errorf("writeMonitors() after resume = %v with %d inserts, want inserted", err, db.inserts)
+	}
+}
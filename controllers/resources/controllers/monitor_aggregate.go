@@ -0,0 +1,255 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// MonitorAggregation saves the hourly and daily aggregates of the monitors, the minute monitors are kept
+	MonitorAggregation = "MONITOR_AGGREGATION"
+	// MonitorAggregationDelay the delay after the end of an hour before it's aggregated, so the minute monitors of the hour are written, default 5m
+	MonitorAggregationDelay = "MONITOR_AGGREGATION_DELAY"
+	// MonitorAggregationLookback the hours caught up at most after a restart, default 24h
+	MonitorAggregationLookback = "MONITOR_AGGREGATION_LOOKBACK"
+
+	DefaultMonitorAggregationDelay    = 5 * time.Minute
+	DefaultMonitorAggregationLookback = 24 * time.Hour
+)
+
+var (
+	monitorAggregationRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_aggregation_runs_total",
+		Help: "Number of the hourly monitor aggregation runs by the result.",
+	}, []string{"result"})
+	monitorAggregatedPeriods = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_aggregated_periods_total",
+		Help: "Number of the periods aggregated by the granularity, including the re-aggregated hours.",
+	}, []string{"granularity"})
+	monitorAggregationLatest = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sealos_resources_monitor_aggregation_latest_timestamp_seconds",
+		Help: "The start of the latest aggregated period by the granularity.",
+	}, []string{"granularity"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(monitorAggregationRuns, monitorAggregatedPeriods, monitorAggregationLatest)
+}
+
+// monitorAggregation aggregates the complete hours once an hour, and the days whose hours are final.
+// An hour is aggregated after the delay, then re-aggregated once by the next run for the late traffic monitors,
+// so a day is aggregated from the hourly aggregates after its last hour is re-aggregated.
+type monitorAggregation struct {
+	delay    time.Duration
+	lookback time.Duration
+	clock    clock.Clock
+	// elected is closed when the replica becomes the leader, only the leader aggregates the monitors
+	elected <-chan struct{}
+}
+
+// newMonitorAggregationFromEnv returns nil if the aggregation is disabled.
+// The hours of the lookback must be newer than the rollup age, or they are aggregated after their minute monitors are deleted.
+func newMonitorAggregationFromEnv(elected <-chan struct{}, rollupAge time.Duration) (*monitorAggregation, error) {
+	if !env.GetBoolEnvWithDefault(MonitorAggregation, false) {
+		return nil, nil
+	}
+	delay, err := env.GetDurationEnvWithDefault(MonitorAggregationDelay, DefaultMonitorAggregationDelay)
+	if err != nil {
+		return nil, err
+	}
+	lookback, err := env.GetDurationEnvWithDefault(MonitorAggregationLookback, DefaultMonitorAggregationLookback)
+	if err != nil {
+		return nil, err
+	}
+	delay, lookback, err = parseMonitorAggregation(delay, lookback, rollupAge)
+	if err != nil {
+		return nil, err
+	}
+	return &monitorAggregation{delay: delay, lookback: lookback, clock: clock.RealClock{}, elected: elected}, nil
+}
+
+func parseMonitorAggregation(delay, lookback, rollupAge time.Duration) (time.Duration, time.Duration, error) {
+	if delay < 0 || delay >= time.Hour {
+		return 0, 0, fmt.Errorf("invalid %s %s: must be in [0, 1h)", MonitorAggregationDelay, delay)
+	}
+	if lookback < time.Hour {
+		return 0, 0, fmt.Errorf("invalid %s %s: must be at least 1h", MonitorAggregationLookback, lookback)
+	}
+	if rollupAge > 0 && lookback+2*time.Hour > rollupAge {
+		return 0, 0, fmt.Errorf("invalid %s %s: the hours must be newer than %s %s", MonitorAggregationLookback, lookback, MonitorRollupAge, rollupAge)
+	}
+	return delay, lookback.Truncate(time.Hour), nil
+}
+
+// nextRun returns the delay after the next hour
+func (a *monitorAggregation) nextRun(now time.Time) time.Time {
+	return now.UTC().Add(-a.delay).Truncate(time.Hour).Add(time.Hour + a.delay)
+}
+
+// hoursToAggregate returns the hours to aggregate before end from the oldest.
+// The latest aggregated hour is re-aggregated once with the new hours, a run without new hours does nothing.
+func (a *monitorAggregation) hoursToAggregate(latest, end time.Time) []time.Time {
+	start := end.Add(-a.lookback)
+	if !latest.IsZero() {
+		if !latest.Add(time.Hour).Before(end) {
+			return nil
+		}
+		if !latest.Before(start) {
+			start = latest
+		}
+	}
+	var hours []time.Time
+	for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+		hours = append(hours, hour)
+	}
+	return hours
+}
+
+// daysToAggregate returns the days whose hours are all before final, after the latest aggregated day.
+// Without a latest day, the first day is the first full day of the lookback, the hours before it were not aggregated.
+func (a *monitorAggregation) daysToAggregate(latest, final time.Time) []time.Time {
+	end := database.MonitorDaily.Truncate(final)
+	earliest := database.MonitorDaily.Truncate(final.Add(-a.lookback))
+	start := earliest
+	if latest.IsZero() {
+		if !start.Equal(final.Add(-a.lookback)) {
+			start = start.AddDate(0, 0, 1)
+		}
+	} else if start = latest.AddDate(0, 0, 1); start.Before(earliest) {
+		start = earliest
+	}
+	var days []time.Time
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+// aggregateHour replaces the hourly aggregates of the hour with the sums of its minute monitors
+func (r *MonitorReconciler) aggregateHour(ctx context.Context, hour time.Time) (int, error) {
+	aggregate := newMonitorRollup(hour)
+	if err := r.DBClient.QueryMonitorsInRange(ctx, hour, hour.Add(time.Hour), func(monitor *resources.Monitor) error {
+		aggregate.add(monitor)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to query the monitors of %s: %w", hour.Format(time.RFC3339), err)
+	}
+	return r.saveMonitorAggregates(ctx, database.MonitorHourly, hour, aggregate.result())
+}
+
+// aggregateDay replaces the daily aggregates of the day with the sums of its hourly aggregates,
+// the utilization is the average of the hourly averages
+func (r *MonitorReconciler) aggregateDay(ctx context.Context, day time.Time) (int, error) {
+	aggregate := newMonitorRollup(day)
+	if err := r.DBClient.QueryMonitorAggregates(ctx, database.MonitorHourly, day, day.AddDate(0, 0, 1), func(monitor *resources.Monitor) error {
+		aggregate.add(monitor)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to query the hourly aggregates of %s: %w", day.Format(time.RFC3339), err)
+	}
+	return r.saveMonitorAggregates(ctx, database.MonitorDaily, day, aggregate.result())
+}
+
+func (r *MonitorReconciler) saveMonitorAggregates(ctx context.Context, granularity database.MonitorGranularity, start time.Time, aggregates []*resources.Monitor) (int, error) {
+	if err := r.DBClient.SaveMonitorAggregates(ctx, granularity, start, aggregates); err != nil {
+		return 0, fmt.Errorf("failed to save the %s aggregates of %s: %w", granularity, start.Format(time.RFC3339), err)
+	}
+	monitorAggregatedPeriods.WithLabelValues(string(granularity)).Inc()
+	monitorAggregationLatest.WithLabelValues(string(granularity)).Set(float64(start.Unix()))
+	return len(aggregates), nil
+}
+
+// aggregateMonitors aggregates the hours complete at now, then the days whose hours are final.
+// The last hour is only re-aggregated by the next run, so the days before it are final.
+func (r *MonitorReconciler) aggregateMonitors(now time.Time) error {
+	ctx := context.Background()
+	end := now.UTC().Add(-r.aggregation.delay).Truncate(time.Hour)
+	latest, err := r.DBClient.LatestMonitorAggregate(ctx, database.MonitorHourly)
+	if err != nil {
+		return err
+	}
+	var hours, days, aggregates int
+	for _, hour := range r.aggregation.hoursToAggregate(latest, end) {
+		select {
+		case <-r.stopCh:
+			return nil
+		default:
+		}
+		n, err := r.aggregateHour(ctx, hour)
+		if err != nil {
+			return err
+		}
+		hours, aggregates = hours+1, aggregates+n
+	}
+	latestDay, err := r.DBClient.LatestMonitorAggregate(ctx, database.MonitorDaily)
+	if err != nil {
+		return err
+	}
+	for _, day := range r.aggregation.daysToAggregate(latestDay, end.Add(-time.Hour)) {
+		n, err := r.aggregateDay(ctx, day)
+		if err != nil {
+			return err
+		}
+		days, aggregates = days+1, aggregates+n
+	}
+	r.Logger.Info("monitor aggregation", "end", end.Format(time.RFC3339), "hours", hours, "days", days, "aggregates", aggregates)
+	return nil
+}
+
+func (r *MonitorReconciler) runMonitorAggregation() {
+	if err := r.aggregateMonitors(r.aggregation.clock.Now()); err != nil {
+		monitorAggregationRuns.WithLabelValues("failure").Inc()
+		r.Logger.Error(err, "failed to aggregate the monitors")
+		return
+	}
+	monitorAggregationRuns.WithLabelValues("success").Inc()
+}
+
+func (r *MonitorReconciler) startMonitorAggregation() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		// the replicas not elected wait here, so a single replica aggregates the monitors
+		if r.aggregation.elected != nil {
+			select {
+			case <-r.aggregation.elected:
+			case <-r.stopCh:
+				return
+			}
+		}
+		// the hours missed while no replica was leading are caught up at once
+		r.runMonitorAggregation()
+		for {
+			now := r.aggregation.clock.Now()
+			select {
+			case <-r.aggregation.clock.After(r.aggregation.nextRun(now).Sub(now)):
+				r.runMonitorAggregation()
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,199 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseMonitorAggregation(t *testing.T) {
+	tests := []struct {
+		name      string
+		delay     time.Duration
+		lookback  time.Duration
+		rollupAge time.Duration
+		wantErr   bool
+	}{
+		{name: "default", delay: DefaultMonitorAggregationDelay, lookback: DefaultMonitorAggregationLookback},
+		{name: "no delay", delay: 0, lookback: time.Hour},
+		{name: "negative delay", delay: -time.Minute, lookback: time.Hour, wantErr: true},
+		{name: "delay of an hour", delay: time.Hour, lookback: time.Hour, wantErr: true},
+		{name: "short lookback", delay: time.Minute, lookback: 30 * time.Minute, wantErr: true},
+		{name: "newer than the rollup age", lookback: 24 * time.Hour, rollupAge: 72 * time.Hour},
+		{name: "older than the rollup age", lookback: 72 * time.Hour, rollupAge: 72 * time.Hour, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseMonitorAggregation(tt.delay, tt.lookback, tt.rollupAge); (err != nil) != tt.wantErr {
+				t.Errorf("parseMonitorAggregation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMonitorAggregation_schedule(t *testing.T) {
+	a := &monitorAggregation{delay: 5 * time.Minute, lookback: 3 * time.Hour}
+	end := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	if got := a.nextRun(end.Add(3 * time.Minute)); !got.Equal(end.Add(5 * time.Minute)) {
+		t.Errorf("nextRun(10:03) = %s, want 10:05", got)
+	}
+	if got := a.nextRun(end.Add(5 * time.Minute)); !got.Equal(end.Add(65 * time.Minute)) {
+		t.Errorf("nextRun(10:05) = %s, want 11:05", got)
+	}
+
+	hours := func(latest time.Time) []time.Time {
+		return a.hoursToAggregate(latest, end)
+	}
+	if got := hours(time.Time{}); len(got) != 3 || !got[0].Equal(end.Add(-3*time.Hour)) {
+		t.Errorf("hoursToAggregate() without latest = %v, want the 3 hours of the lookback", got)
+	}
+	// the latest hour is re-aggregated once with the new hour
+	if got := hours(end.Add(-2 * time.Hour)); len(got) != 2 || !got[0].Equal(end.Add(-2*time.Hour)) {
+		t.Errorf("hoursToAggregate() = %v, want the latest hour and the new one", got)
+	}
+	if got := hours(end.Add(-time.Hour)); len(got) != 0 {
+		t.Errorf("hoursToAggregate() up to date = %v, want none", got)
+	}
+	if got := hours(end.Add(-48 * time.Hour)); len(got) != 3 {
+		t.Errorf("hoursToAggregate() behind the lookback = %v, want the 3 hours of the lookback", got)
+	}
+
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if got := a.daysToAggregate(day.AddDate(0, 0, -1), day.Add(23*time.Hour)); len(got) != 0 {
+		t.Errorf("daysToAggregate() before the last hour is final = %v, want none", got)
+	}
+	if got := a.daysToAggregate(day.AddDate(0, 0, -1), day.AddDate(0, 0, 1)); len(got) != 1 || !got[0].Equal(day) {
+		t.Errorf("daysToAggregate() = %v, want %s", got, day)
+	}
+	if got := a.daysToAggregate(day, day.AddDate(0, 0, 1)); len(got) != 0 {
+		t.Errorf("daysToAggregate() up to date = %v, want none", got)
+	}
+	// the first run only aggregated the hours of the lookback, the day is partial
+	if got := a.daysToAggregate(time.Time{}, day.AddDate(0, 0, 1)); len(got) != 0 {
+		t.Errorf("daysToAggregate() without latest = %v, want none", got)
+	}
+}
+
+// fakeAggregateDB keeps the minute monitors and the aggregates in memory
+type fakeAggregateDB struct {
+	database.MonitorStore
+	monitors   []*resources.Monitor
+	aggregates map[database.MonitorGranularity]map[time.Time][]*resources.Monitor
+	latest     map[database.MonitorGranularity]time.Time
+}
+
+func (f *fakeAggregateDB) QueryMonitorsInRange(_ context.Context, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	for _, monitor := range f.monitors {
+		if !monitor.Time.Before(startTime) && monitor.Time.Before(endTime) {
+			if err := handle(monitor); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeAggregateDB) SaveMonitorAggregates(_ context.Context, granularity database.MonitorGranularity, start time.Time, aggregates []*resources.Monitor) error {
+	f.aggregates[granularity][start] = aggregates
+	if start.After(f.latest[granularity]) {
+		f.latest[granularity] = start
+	}
+	return nil
+}
+
+func (f *fakeAggregateDB) QueryMonitorAggregates(_ context.Context, granularity database.MonitorGranularity, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	for start, aggregates := range f.aggregates[granularity] {
+		if start.Before(startTime) || !start.Before(endTime) {
+			continue
+		}
+		for _, aggregate := range aggregates {
+			if err := handle(aggregate); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeAggregateDB) LatestMonitorAggregate(_ context.Context, granularity database.MonitorGranularity) (time.Time, error) {
+	return f.latest[granularity], nil
+}
+
+func TestMonitorReconciler_aggregateMonitors(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := &fakeAggregateDB{
+		aggregates: map[database.MonitorGranularity]map[time.Time][]*resources.Monitor{database.MonitorHourly: {}, database.MonitorDaily: {}},
+		latest:     map[database.MonitorGranularity]time.Time{},
+	}
+	for hour := day; hour.Before(day.AddDate(0, 0, 1)); hour = hour.Add(time.Hour) {
+		for minute := 0; minute < 60; minute++ {
+			db.monitors = append(db.monitors, &resources.Monitor{Time: hour.Add(time.Duration(minute) * time.Minute), Category: "ns-a", Name: "app",
+				Used: resources.EnumUsedMap{0: 1}})
+		}
+	}
+	r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, stopCh: make(chan struct{}),
+		aggregation: &monitorAggregation{delay: 5 * time.Minute, lookback: 24 * time.Hour}}
+	hourly := testutil.ToFloat64(monitorAggregatedPeriods.WithLabelValues(string(database.MonitorHourly)))
+
+	// the minute monitors of the last hour are complete after the delay
+	if err := r.aggregateMonitors(day.AddDate(0, 0, 1).Add(5 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.aggregates[database.MonitorHourly]) != 24 {
+		t.Fatalf("aggregated %d hours, want 24", len(db.aggregates[database.MonitorHourly]))
+	}
+	if got := db.aggregates[database.MonitorHourly][day][0].Used[0]; got != 60 {
+		t.Errorf("hourly used = %d, want 60", got)
+	}
+	if len(db.aggregates[database.MonitorDaily]) != 0 {
+		t.Error("the day is aggregated before its last hour is re-aggregated")
+	}
+
+	// a late traffic monitor of the last hour is counted by the re-aggregation of the next run, the hour is replaced
+	last := day.Add(23 * time.Hour)
+	db.monitors = append(db.monitors, &resources.Monitor{Time: last.Add(59 * time.Minute), Category: "ns-a", Type: 5, Name: "traffic",
+		Used: resources.EnumUsedMap{7: 1024}})
+	if err := r.aggregateMonitors(day.AddDate(0, 0, 1).Add(65 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.aggregates[database.MonitorHourly][last]; len(got) != 2 || got[1].Used[7] != 1024 {
+		t.Errorf("re-aggregated hour = %+v, want the late traffic", got)
+	}
+	daily := db.aggregates[database.MonitorDaily][day]
+	if len(daily) != 2 || daily[0].Used[0] != 24*60 || daily[1].Used[7] != 1024 {
+		t.Errorf("daily aggregates = %+v, want the sums of the hours", daily)
+	}
+	// 24 hours, the re-aggregated hour and the new hour
+	if got := testutil.ToFloat64(monitorAggregatedPeriods.WithLabelValues(string(database.MonitorHourly))) - hourly; got != 26 {
+		t.Errorf("aggregated hourly periods = %v, want 26", got)
+	}
+
+	// the hours are re-aggregated once, a rerun in the same hour does nothing
+	saved := len(db.aggregates[database.MonitorHourly])
+	if err := r.aggregateMonitors(day.AddDate(0, 0, 1).Add(70 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(monitorAggregatedPeriods.WithLabelValues(string(database.MonitorHourly))) - hourly; got != 26 || len(db.aggregates[database.MonitorHourly]) != saved {
+		t.Errorf("aggregated hourly periods after the rerun = %v, want 26", got)
+	}
+}
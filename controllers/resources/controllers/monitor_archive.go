@@ -0,0 +1,277 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/database/archive"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// MonitorArchiveBucket archives the expired days of the monitors into the bucket of the object storage before the
+	// retention drops them, the archival is skipped if empty
+	MonitorArchiveBucket = "MONITOR_ARCHIVE_BUCKET"
+	// MonitorArchivePrefix the prefix of the keys of the archives in the bucket, default monitors
+	MonitorArchivePrefix = "MONITOR_ARCHIVE_PREFIX"
+	// MonitorArchiveLookbackDays the expired days checked for the archives by each run, default 7. The days expired
+	// before the lookback are dropped without being archived, eg: after the controller was stopped for longer.
+	MonitorArchiveLookbackDays = "MONITOR_ARCHIVE_LOOKBACK_DAYS"
+
+	DefaultMonitorArchivePrefix       = "monitors"
+	DefaultMonitorArchiveLookbackDays = 7
+
+	// monitorArchiveChecksumMeta the user metadata of the sha256 of an archive, set once the archive is uploaded
+	monitorArchiveChecksumMeta = "Sha256"
+	// monitorArchiveTimeout bounds archiving a day, the monitors of a day are streamed into a local file first
+	monitorArchiveTimeout = time.Hour
+)
+
+var (
+	monitorArchivedDays = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_archived_days_total",
+		Help: "Number of the expired days of the monitors archived into the object storage.",
+	})
+	monitorArchivedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_archived_bytes_total",
+		Help: "Number of the compressed bytes of the monitor archives uploaded.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(monitorArchivedDays, monitorArchivedBytes)
+}
+
+// errMonitorArchiveNotFound the day has no verified archive
+var errMonitorArchiveNotFound = errors.New("monitor archive not found")
+
+// monitorArchiveObjects stores the archives, see minioArchiveObjects
+type monitorArchiveObjects interface {
+	// stat returns the size and the sha256 of the archive, errMonitorArchiveNotFound if it's not stored
+	stat(ctx context.Context, key string) (int64, string, error)
+	put(ctx context.Context, key string, r io.Reader, size int64, checksum string) error
+	remove(ctx context.Context, key string) error
+}
+
+// monitorArchive archives the expired days of the monitors before the retention job drops them. A day is exported
+// into a local file, uploaded with its sha256, and verified by the size and the sha256 of the stored object; the drop
+// runs only once all the expired days of the lookback are archived. The archives are the record of the progress, so an
+// interrupted run is resumed by the next one from the days not archived yet.
+type monitorArchive struct {
+	bucket   string
+	prefix   string
+	lookback int
+	// objects the archives in the bucket, the object storage of the reconciler if nil
+	objects monitorArchiveObjects
+}
+
+// newMonitorArchiveFromEnv returns nil if the archival is skipped. The ttl retention mode expires the monitors in the
+// storage without a run to archive them first, so it's not supported.
+func newMonitorArchiveFromEnv(retentionMode string) (*monitorArchive, error) {
+	bucket := os.Getenv(MonitorArchiveBucket)
+	if bucket == "" {
+		return nil, nil
+	}
+	if retentionMode != MonitorRetentionModeJob {
+		return nil, fmt.Errorf("invalid %s %s: the monitors are archived by the %s retention mode only", MonitorRetentionMode, retentionMode, MonitorRetentionModeJob)
+	}
+	lookback := env.GetInt64EnvWithDefault(MonitorArchiveLookbackDays, DefaultMonitorArchiveLookbackDays)
+	if lookback < 1 {
+		return nil, fmt.Errorf("invalid %s %d: must be at least 1", MonitorArchiveLookbackDays, lookback)
+	}
+	return &monitorArchive{
+		bucket:   bucket,
+		prefix:   strings.Trim(env.GetEnvWithDefault(MonitorArchivePrefix, DefaultMonitorArchivePrefix), "/"),
+		lookback: int(lookback),
+	}, nil
+}
+
+// expiredDays returns the days of the lookback the retention of the days drops at now from the oldest, the cutoff is
+// the one of DropMonitorCollectionsOlderThan
+func (a *monitorArchive) expiredDays(now time.Time, days int) []time.Time {
+	cutoff := now.UTC().AddDate(0, 0, -days).Truncate(24 * time.Hour)
+	expired := make([]time.Time, 0, a.lookback)
+	for day := cutoff.AddDate(0, 0, -a.lookback); day.Before(cutoff); day = day.AddDate(0, 0, 1) {
+		expired = append(expired, day)
+	}
+	return expired
+}
+
+// archiveDay archives the monitors of the day if not archived yet, and returns false if the day was archived before
+// or has no monitors
+func (a *monitorArchive) archiveDay(ctx context.Context, store database.MonitorStore, day time.Time) (bool, error) {
+	key := archive.DayKey(a.prefix, day)
+	if _, _, err := a.objects.stat(ctx, key); err == nil {
+		return false, nil
+	} else if !errors.Is(err, errMonitorArchiveNotFound) {
+		return false, fmt.Errorf("failed to check the archive %s: %w", key, err)
+	}
+	file, err := os.CreateTemp("", "monitor-archive-*"+archive.Extension)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	hash := sha256.New()
+	monitors, err := archive.Write(ctx, store, io.MultiWriter(file, hash), day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return false, fmt.Errorf("failed to export the monitors of %s: %w", day.Format(time.DateOnly), err)
+	}
+	if monitors == 0 {
+		return false, nil
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if err = a.objects.put(ctx, key, file, size, checksum); err != nil {
+		return false, fmt.Errorf("failed to upload the archive %s: %w", key, err)
+	}
+	storedSize, storedChecksum, err := a.objects.stat(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify the archive %s: %w", key, err)
+	}
+	if storedSize != size || storedChecksum != checksum {
+		// the archive would be taken as verified by the next run
+		if err = a.objects.remove(ctx, key); err != nil {
+			return false, fmt.Errorf("failed to remove the unverified archive %s: %w", key, err)
+		}
+		return false, fmt.Errorf("failed to verify the archive %s: stored %d bytes of sha256 %s, uploaded %d bytes of sha256 %s",
+			key, storedSize, storedChecksum, size, checksum)
+	}
+	monitorArchivedDays.Inc()
+	monitorArchivedBytes.Add(float64(size))
+	return true, nil
+}
+
+// archiveExpiredMonitors archives the expired days of the primary store, the drop must not run if it fails
+func (r *MonitorReconciler) archiveExpiredMonitors(now time.Time) error {
+	if r.archive.objects == nil {
+		if r.ObjStorageClient == nil {
+			return fmt.Errorf("the object storage of %s is not configured, please check env: %s", MonitorArchiveBucket, MinioEndpoint)
+		}
+		r.archive.objects = &minioArchiveObjects{client: r.ObjStorageClient, bucket: r.archive.bucket}
+	}
+	store := r.DBClient
+	if dual, ok := store.(*DualWriteStore); ok {
+		store = dual.MonitorStore
+	}
+	archived := 0
+	for _, day := range r.archive.expiredDays(now, r.retention.days) {
+		select {
+		case <-r.stopCh:
+			return fmt.Errorf("stopped archiving the monitors of %s", day.Format(time.DateOnly))
+		default:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), monitorArchiveTimeout)
+		ok, err := r.archive.archiveDay(ctx, store, day)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if ok {
+			archived++
+			r.Logger.Info("archived the expired monitors", "day", day.Format(time.DateOnly), "bucket", r.archive.bucket)
+		}
+	}
+	r.Logger.Info("monitor archival", "archived days", archived, "lookback days", r.archive.lookback)
+	return nil
+}
+
+// minioArchiveObjects stores the archives in a bucket of the object storage
+type minioArchiveObjects struct {
+	client *ObjStorageClient
+	bucket string
+}
+
+// stat returns errMonitorArchiveNotFound for an object without the checksum, it was not uploaded by the archival
+func (o *minioArchiveObjects) stat(ctx context.Context, key string) (int64, string, error) {
+	var info minio.ObjectInfo
+	err := o.client.Do(func(client *minio.Client) (err error) {
+		info, err = client.StatObject(ctx, o.bucket, key, minio.StatObjectOptions{})
+		return err
+	})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return 0, "", errMonitorArchiveNotFound
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	checksum := info.Metadata.Get("X-Amz-Meta-" + monitorArchiveChecksumMeta)
+	if checksum == "" {
+		return 0, "", errMonitorArchiveNotFound
+	}
+	return info.Size, checksum, nil
+}
+
+// put uploads the archive with the md5 of the parts, so the storage rejects the parts corrupted in transit
+func (o *minioArchiveObjects) put(ctx context.Context, key string, r io.Reader, size int64, checksum string) error {
+	return o.client.Do(func(client *minio.Client) error {
+		if seeker, ok := r.(io.Seeker); ok {
+			// the operation retried with the reloaded credentials uploads the archive again
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		_, err := client.PutObject(ctx, o.bucket, key, r, size, minio.PutObjectOptions{
+			ContentType:    "application/gzip",
+			UserMetadata:   map[string]string{monitorArchiveChecksumMeta: checksum},
+			SendContentMd5: true,
+		})
+		return err
+	})
+}
+
+func (o *minioArchiveObjects) remove(ctx context.Context, key string) error {
+	return o.client.Do(func(client *minio.Client) error {
+		return client.RemoveObject(ctx, o.bucket, key, minio.RemoveObjectOptions{})
+	})
+}
+
+// RestoreMonitorArchive inserts the monitors in [start, end) of the archive at bucket/key into the store, eg: the
+// archive of a day dropped by the retention for a billing dispute. It returns the number of the monitors restored.
+// The monitors restored into the days older than the retention are dropped again by its next run.
+func RestoreMonitorArchive(ctx context.Context, client *ObjStorageClient, bucket, key string, store database.MonitorStore, start, end time.Time) (int64, error) {
+	var object *minio.Object
+	err := client.Do(func(client *minio.Client) (err error) {
+		object, err = client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the archive %s/%s: %w", bucket, key, err)
+	}
+	defer object.Close()
+	return archive.Restore(ctx, store, object, start, end, archive.DefaultRestoreBatchSize)
+}
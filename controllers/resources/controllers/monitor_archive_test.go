@@ -0,0 +1,159 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/utils/clock"
+
+	"github.com/labring/sealos/controllers/pkg/database/archive"
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// fakeArchiveObjects keeps the archives in memory, corrupt stores the archives truncated
+type fakeArchiveObjects struct {
+	objects map[string][]byte
+	sums    map[string]string
+	puts    int
+	corrupt bool
+}
+
+func (o *fakeArchiveObjects) stat(_ context.Context, key string) (int64, string, error) {
+	data, ok := o.objects[key]
+	if !ok {
+		return 0, "", errMonitorArchiveNotFound
+	}
+	return int64(len(data)), o.sums[key], nil
+}
+
+func (o *fakeArchiveObjects) put(_ context.Context, key string, r io.Reader, _ int64, checksum string) error {
+	o.puts++
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if o.corrupt {
+		data = data[:len(data)/2]
+	}
+	o.objects[key], o.sums[key] = data, checksum
+	return nil
+}
+
+func (o *fakeArchiveObjects) remove(_ context.Context, key string) error {
+	delete(o.objects, key)
+	return nil
+}
+
+func TestNewMonitorArchiveFromEnv(t *testing.T) {
+	t.Setenv(MonitorArchiveBucket, "")
+	if a, err := newMonitorArchiveFromEnv(MonitorRetentionModeJob); a != nil || err != nil {
+		t.Errorf("newMonitorArchiveFromEnv() without bucket = %v, %v, want skipped", a, err)
+	}
+	t.Setenv(MonitorArchiveBucket, "archive")
+	t.Setenv(MonitorArchivePrefix, "/monitors/")
+	a, err := newMonitorArchiveFromEnv(MonitorRetentionModeJob)
+	if err != nil || a.prefix != "monitors" || a.lookback != DefaultMonitorArchiveLookbackDays {
+		t.Errorf("newMonitorArchiveFromEnv() = %+v, %v, want the bucket with the default lookback", a, err)
+	}
+	if _, err := newMonitorArchiveFromEnv(MonitorRetentionModeTTL); err == nil {
+		t.Error("newMonitorArchiveFromEnv() of the ttl mode expected error")
+	}
+	t.Setenv(MonitorArchiveLookbackDays, "0")
+	if _, err := newMonitorArchiveFromEnv(MonitorRetentionModeJob); err == nil {
+		t.Error("newMonitorArchiveFromEnv() without lookback expected error")
+	}
+}
+
+func TestMonitorReconciler_dropExpiredMonitors_archive(t *testing.T) {
+	ctx := context.Background()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	store := databasetest.NewMemoryStore()
+	var monitors []*resources.Monitor
+	// the days 12 and 11 days ago expire with a retention of 10 days, today is kept
+	for _, day := range []time.Time{today.AddDate(0, 0, -12), today.AddDate(0, 0, -11), today} {
+		for hour := 0; hour < 3; hour++ {
+			monitors = append(monitors, &resources.Monitor{Time: day.Add(time.Duration(hour) * time.Hour), Category: "ns-a",
+				Name: "app", Used: resources.EnumUsedMap{0: 1000}})
+		}
+	}
+	if err := store.InsertMonitor(ctx, monitors...); err != nil {
+		t.Fatal(err)
+	}
+	objects := &fakeArchiveObjects{objects: map[string][]byte{}, sums: map[string]string{}, corrupt: true}
+	r := &MonitorReconciler{
+		Logger:    logr.Discard(),
+		DBClient:  store,
+		retention: &monitorRetention{days: 10, hour: 3, mode: MonitorRetentionModeJob, clock: clock.RealClock{}},
+		archive:   &monitorArchive{bucket: "archive", prefix: "monitors", lookback: 7, objects: objects},
+	}
+
+	// the archive failed the verification is removed, nothing is dropped
+	r.dropExpiredMonitors()
+	if len(objects.objects) != 0 {
+		t.Errorf("the archives after a failed verification = %d, want removed", len(objects.objects))
+	}
+	if got := len(store.Monitors()); got != 9 {
+		t.Fatalf("the monitors after a failed archival = %d, want all 9 kept", got)
+	}
+	if got := store.Calls("DropMonitorCollectionsOlderThan"); got != 0 {
+		t.Errorf("dropped %d times after a failed archival, want none", got)
+	}
+
+	// the next run archives the corrupted day again and resumes from it
+	objects.corrupt = false
+	objects.puts = 0
+	r.dropExpiredMonitors()
+	if objects.puts != 2 || len(objects.objects) != 2 {
+		t.Errorf("archived %d puts, %d objects, want the 2 expired days", objects.puts, len(objects.objects))
+	}
+	if got := len(store.Monitors()); got != 3 {
+		t.Errorf("the monitors after the archival = %d, want the 3 of today", got)
+	}
+
+	// the archived days are restored
+	restored := databasetest.NewMemoryStore()
+	day := today.AddDate(0, 0, -11)
+	n, err := archive.Restore(ctx, restored, bytes.NewReader(objects.objects[archive.DayKey("monitors", day)]), day, day.AddDate(0, 0, 1), 0)
+	if err != nil || n != 3 {
+		t.Errorf("Restore() = %d, %v, want the 3 monitors of the day", n, err)
+	}
+
+	// the archived days are not uploaded again
+	objects.puts = 0
+	r.dropExpiredMonitors()
+	if objects.puts != 0 {
+		t.Errorf("archived %d days again, want none", objects.puts)
+	}
+}
+
+func TestMonitorReconciler_archiveExpiredMonitors_objectStorage(t *testing.T) {
+	r := &MonitorReconciler{
+		Logger:    logr.Discard(),
+		DBClient:  databasetest.NewMemoryStore(),
+		retention: &monitorRetention{days: 10},
+		archive:   &monitorArchive{bucket: "archive", lookback: 1},
+	}
+	if err := r.archiveExpiredMonitors(time.Now()); err == nil || errors.Is(err, errMonitorArchiveNotFound) {
+		t.Errorf("archiveExpiredMonitors() without the object storage = %v, want the configuration error", err)
+	}
+}
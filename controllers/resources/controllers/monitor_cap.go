@@ -0,0 +1,96 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// MaxMonitorsPerNamespace caps the monitors (the distinct resource names) of a namespace in a cycle, eg: a tenant
+// spawning thousands of uniquely named pods metered per pod. The largest consumers are kept, 0 disables the cap (default)
+const MaxMonitorsPerNamespace = "MAX_MONITORS_PER_NAMESPACE"
+
+// labeled by nothing, the namespace is logged to keep the cardinality bounded
+var (
+	monitorCapExceeded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_cap_exceeded_total",
+		Help: "Number of the namespace cycles whose monitors exceeded MAX_MONITORS_PER_NAMESPACE and were truncated.",
+	})
+	monitorsTruncated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_monitors_truncated_total",
+		Help: "Number of the monitors beyond MAX_MONITORS_PER_NAMESPACE dropped from the cycles, they are not metered.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(monitorCapExceeded, monitorsTruncated)
+}
+
+// capNamespaceMonitors keeps the max largest monitors of the namespace if it has more, see capMonitors
+func (r *MonitorReconciler) capNamespaceMonitors(namespace string, monitors []*resources.Monitor) []*resources.Monitor {
+	if r.MaxMonitorsPerNamespace <= 0 || len(monitors) <= r.MaxMonitorsPerNamespace {
+		return monitors
+	}
+	kept := capMonitors(r.properties(), monitors, r.MaxMonitorsPerNamespace)
+	monitorCapExceeded.Inc()
+	monitorsTruncated.Add(float64(len(monitors) - len(kept)))
+	r.Logger.Error(fmt.Errorf("%d monitors exceed the cap %d", len(monitors), r.MaxMonitorsPerNamespace),
+		"the smallest monitors of the namespace are not metered", "namespace", namespace, "dropped", len(monitors)-len(kept))
+	return kept
+}
+
+// capMonitors returns the max largest monitors by their amount at the unit prices, then by their used units, and by
+// the type, the name and the property to keep the truncation stable across the cycles. The monitors are not modified.
+func capMonitors(properties *resources.PropertyTypeLS, monitors []*resources.Monitor, max int) []*resources.Monitor {
+	type ranked struct {
+		monitor      *resources.Monitor
+		amount, used int64
+	}
+	sorted := make([]ranked, len(monitors))
+	for i, monitor := range monitors {
+		sorted[i].monitor = monitor
+		for enum, used := range monitor.Used {
+			sorted[i].used += used
+			if property, ok := properties.EnumMap[enum]; ok {
+				sorted[i].amount += property.UsedAmount(used)
+			}
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case a.amount != b.amount:
+			return a.amount > b.amount
+		case a.used != b.used:
+			return a.used > b.used
+		case a.monitor.Type != b.monitor.Type:
+			return a.monitor.Type < b.monitor.Type
+		case a.monitor.Name != b.monitor.Name:
+			return a.monitor.Name < b.monitor.Name
+		}
+		return a.monitor.Property < b.monitor.Property
+	})
+	kept := make([]*resources.Monitor, max)
+	for i := range kept {
+		kept[i] = sorted[i].monitor
+	}
+	return kept
+}
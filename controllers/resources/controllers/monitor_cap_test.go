@@ -0,0 +1,102 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestCapMonitors(t *testing.T) {
+	cpu := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()].Enum
+	monitors := []*resources.Monitor{
+		{Name: "app-small", Used: resources.EnumUsedMap{cpu: 10}},
+		{Name: "app-tie-b", Used: resources.EnumUsedMap{cpu: 500}},
+		{Name: "app-large", Used: resources.EnumUsedMap{cpu: 2000}},
+		{Name: "app-tie-a", Used: resources.EnumUsedMap{cpu: 500}},
+	}
+	names := func(monitors []*resources.Monitor) []string {
+		var names []string
+		for _, monitor := range monitors {
+			names = append(names, monitor.Name)
+		}
+		return names
+	}
+	// the ties are broken by the name, whatever the order of the monitors
+	want := []string{"app-large", "app-tie-a"}
+	for i := 0; i < 2; i++ {
+		if got := names(capMonitors(resources.DefaultPropertyTypeLS, monitors, 2)); !reflect.DeepEqual(got, want) {
+			t.Errorf("capMonitors() = %v, want %v", got, want)
+		}
+		monitors[1], monitors[3] = monitors[3], monitors[1]
+	}
+	if monitors[0].Name != "app-small" {
+		t.Errorf("capMonitors() reordered the monitors")
+	}
+}
+
+func TestMonitorReconciler_monitorResourceUsage_Cap(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	var objects []client.Object
+	for i := 1; i <= 5; i++ {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: fmt.Sprintf("pod-%d", i),
+				Labels: map[string]string{resources.AppLabelKey: fmt.Sprintf("app-%d", i)}},
+			Spec:   corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{qosContainer(nil, qosResources(fmt.Sprintf("%d", i), "1Gi"))}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		})
+	}
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{
+		Client:                  fake.NewClientBuilder().WithObjects(objects...).Build(),
+		Logger:                  logr.Discard(),
+		DBClient:                db,
+		Properties:              resources.DefaultPropertyTypeLS,
+		MeteringPolicy:          MeteringPolicyLimits,
+		GpuMeteringPolicy:       GpuMeteringPolicyReservation,
+		MaxMonitorsPerNamespace: 3,
+	}
+	exceeded, truncated := testutil.ToFloat64(monitorCapExceeded), testutil.ToFloat64(monitorsTruncated)
+	if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+		t.Fatalf("monitorResourceUsage() error = %v", err)
+	}
+	var got []string
+	for _, monitor := range db.Monitors() {
+		got = append(got, monitor.Name)
+	}
+	sort.Strings(got)
+	if want := []string{"app-3", "app-4", "app-5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("monitors = %v, want the largest %v", got, want)
+	}
+	if d := testutil.ToFloat64(monitorCapExceeded) - exceeded; d != 1 {
+		t.Errorf("cap exceeded = %v, want 1", d)
+	}
+	if d := testutil.ToFloat64(monitorsTruncated) - truncated; d != 2 {
+		t.Errorf("monitors truncated = %v, want 2", d)
+	}
+}
@@ -18,16 +18,18 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/labring/sealos/controllers/pkg/utils/env"
 
-	"golang.org/x/sync/semaphore"
-
 	"k8s.io/apimachinery/pkg/selection"
 
 	"k8s.io/apimachinery/pkg/labels"
@@ -50,7 +52,10 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -59,18 +64,149 @@ import (
 type MonitorReconciler struct {
 	client.Client
 	logr.Logger
-	Interval              time.Duration
-	Scheme                *runtime.Scheme
-	stopCh                chan struct{}
-	wg                    sync.WaitGroup
-	periodicReconcile     time.Duration
-	NvidiaGpu             map[string]gpu.NvidiaGPU
-	DBClient              database.Interface
-	TrafficClient         database.Interface
-	Properties            *resources.PropertyTypeLS
-	PromURL               string
-	ObjStorageClient      *minio.Client
-	ObjectStorageInstance string
+	Interval          time.Duration
+	Scheme            *runtime.Scheme
+	stopCh            chan struct{}
+	wg                sync.WaitGroup
+	periodicReconcile time.Duration
+	reconcileClock    clock.WithTicker
+	NvidiaGpu         map[string]gpu.NvidiaGPU
+	DBClient          database.MonitorStore
+	TrafficClient     TrafficSource
+	Properties        *resources.PropertyTypeLS
+	PromURL           string
+	ObjStorageClient  *ObjStorageClient
+	// ObjStorageTenantClients scans the buckets with per tenant credentials if set, otherwise the admin client is used
+	ObjStorageTenantClients *TenantObjStorageClients
+	ObjectStorageInstance   string
+	// ObjStorageTimeout the timeout of the object storage metadata calls, ObjStorageScanTimeout of listing a bucket
+	ObjStorageTimeout     time.Duration
+	ObjStorageScanTimeout time.Duration
+	// ObjStorageBucketConcurrency the number of the buckets of a namespace scanned at the same time, sequential if <= 1
+	ObjStorageBucketConcurrency int
+	// ObjStorageFlowQuery the prometheus query templates of the bucket flow
+	ObjStorageFlowQuery objstorage.FlowQuery
+	flowQueryValidator  flowQueryValidator
+	// SidecarContainers the names of the sidecar containers metered apart from their app, eg: istio-proxy
+	SidecarContainers []string
+	// MeterByQOSClass meters the pods of each QoS class of an app apart, tagged with the property qos/<class>
+	MeterByQOSClass bool
+	// MeterPodOverhead meters the pod overhead with the containers of the pod
+	MeterPodOverhead bool
+	// PendingPVCGrace meters the pvcs pending for longer than the grace, 0 only meters the bound pvcs
+	PendingPVCGrace time.Duration
+	// MaxWindowBytes caps the object storage flow and the traffic bytes of a window, 0 disables the cap
+	MaxWindowBytes int64
+	// PurgeGracePeriod is the time to keep monitors of the deleted tenant, 0 means never purge
+	PurgeGracePeriod time.Duration
+	MeteringPolicy   MeteringPolicy
+	// UnboundedContainerDefaults the cpu and memory metered for the containers without a request or limit of them
+	UnboundedContainerDefaults corev1.ResourceList
+	// MeteringGranularity decides whether the pods of a workload are metered together or apart
+	MeteringGranularity MeteringGranularity
+	// GpuMeteringPolicy decides when the gpu of a pod is metered
+	GpuMeteringPolicy GpuMeteringPolicy
+	// CrashLoopRestartThreshold the restarts of a waiting container to be crash looping, 0 only detects CrashLoopBackOff
+	CrashLoopRestartThreshold int32
+	// NoncurrentBilling decides how the non-current versions of the object storage are metered
+	NoncurrentBilling NoncurrentBilling
+	// GpuReplicasLabel the node label key of the time-slicing gpu replicas, default nvidia.com/gpu.replicas
+	GpuReplicasLabel string
+	// APIReader reads from the api server directly, used by the paginated pod list
+	APIReader             client.Reader
+	PodListPageSize       int64
+	PodListFromWatchCache bool
+	tenants               *tenantTracker
+	bucketFilter          *bucketFilter
+	gpuUtilization        *gpuUtilizationCollector
+	// monitorPolicies the MonitorPolicy overrides of the namespaces, refreshed once per cycle
+	monitorPolicies *monitorPolicies
+	// MaxMonitorsPerNamespace caps the monitors of a namespace in a cycle keeping the largest ones, 0 disables the cap
+	MaxMonitorsPerNamespace int
+	// ListRetryAttempts the attempts of listing the pods, the pvcs and the services of a namespace on the transient errors
+	ListRetryAttempts int
+	// CollectRetryAttempts the attempts of collecting the monitors of a namespace on the transient errors
+	CollectRetryAttempts int
+	// SkipInitialAlignment runs the first reconcile immediately instead of waiting for the next minute
+	SkipInitialAlignment bool
+	// NamespaceUserLabel the namespace label of the owning user, the user is derived from the namespace name if not set
+	NamespaceUserLabel string
+	// NamespaceSelector selects the tenant namespaces to meter, nil selects the namespaces with userv1.UserLabelOwnerKey
+	NamespaceSelector labels.Selector
+	// MonitorEnrichers attach the tenant metadata to the monitors before they are inserted, eg: region, accountID and plan
+	MonitorEnrichers []MonitorEnricher
+	// TrafficWindow the window of the traffic monitors, DefaultTrafficWindow if 0
+	TrafficWindow time.Duration
+	// BillingLocation the time zone the traffic windows are aligned to, UTC if nil
+	BillingLocation *time.Location
+	// MonitorTimeTruncation the boundary the time of the resource monitors is truncated to, the minute if 0
+	MonitorTimeTruncation time.Duration
+	// cycleTime the time of the resource monitors of the current cycle, zero out of a cycle
+	cycleTime time.Time
+	// CycleDeadline the fraction of the reconcile period after which no namespace is started in the cycle, 0 disables it
+	CycleDeadline     float64
+	skippedNamespaces skippedNamespaces
+	// RollupAge rolls the minute monitors older than the age up into hourly sums, 0 disables the rollup
+	RollupAge      time.Duration
+	RollupLookback time.Duration
+	// quotaEnforcer is called when the object storage of a user crosses the quota, nil if disabled
+	quotaEnforcer QuotaEnforcer
+	quotaSource   *quotaSource
+	quotaTracker  *quotaTracker
+	// objStorageScan collects the bucket scans of the current cycle
+	objStorageScan *objstorage.ScanCycle
+	// anomalyDetector flags the namespace usage jumps, nil if disabled
+	anomalyDetector *anomalyDetector
+	// objStorageBreaker skips the object storage metering while the object storage is unavailable, nil if disabled
+	objStorageBreaker *objStorageBreaker
+	// meteringValve pauses the metering while the monitor database is unhealthy, nil never pauses
+	meteringValve *meteringValve
+	// monitorDBHealth pings the monitor database and reconnects it on the persistent failures, nil if disabled
+	monitorDBHealth *monitorDBHealth
+	// usageMetrics meters the cpu or memory by the actual usage of the containers, nil meters the requests or limits
+	usageMetrics *usageMetrics
+	// goroutineGuard warns of the goroutines growing across the checks, nil if disabled
+	goroutineGuard *goroutineGuard
+	// adminToken the bearer token of the api endpoints, the api server doesn't start without it
+	adminToken string
+	// cycleProperties the properties of the running cycle swapped at the start of each cycle, while the traffic, the
+	// sub-sampler and the api read them from other goroutines
+	cycleProperties atomic.Pointer[resources.PropertyTypeLS]
+	// pprofToken the admin token of the pprof endpoints, empty if the pprof is disabled
+	pprofToken string
+	// subSampler aggregates the sub-minute samples into the monitors of the minute, nil samples once per minute
+	subSampler *subSampler
+	// monitorWriter coalesces the monitors of the namespaces into bulk inserts, nil inserts per namespace
+	monitorWriter *monitorWriter
+	// monitorQueue inserts the monitors asynchronously with a bounded capacity, nil waits for the inserts
+	monitorQueue *monitorQueue
+	// objStorageBackpressured skips the object storage metering of the cycle under the backpressure of the monitor queue
+	objStorageBackpressured bool
+	// retention drops the expired monitors daily or sets their TTL, nil doesn't run
+	retention *monitorRetention
+	// archive archives the expired monitors before the retention drops them, nil drops them without
+	archive *monitorArchive
+	// aggregation saves the hourly and daily aggregates of the monitors, nil if disabled
+	aggregation *monitorAggregation
+	// gapDetection checks the monitors of the namespaces for the missing minutes, nil if disabled
+	gapDetection *monitorGapDetection
+	// billingReconciliation compares the billings of the namespaces with their monitors daily, nil if disabled
+	billingReconciliation *billingReconciliation
+	// usageExporter publishes the used of the namespaces as prometheus gauges, nil if disabled
+	usageExporter *usageExporter
+	// propertiesReloader reloads the properties from the configmap, nil if the properties are only loaded at startup
+	propertiesReloader *propertiesReloader
+	// namespaceCache serves the namespace list from the informer of the manager cache, nil lists by the client
+	namespaceCache *namespaceCache
+	// cacheWarmUp delays the first reconcile until the informers are synced, nil starts it at once
+	cacheWarmUp *cacheWarmUp
+	// cpuOvercommit weights the cpu of the pods on the over-committed nodes, nil if disabled
+	cpuOvercommit *cpuOvercommit
+	// GpuNodeAggregator reconciles the gpu billed to the pods of each node once per cycle, nil bills the gpu per pod only
+	GpuNodeAggregator GpuNodeAggregator
+	gpuNodeWeights    gpuNodeWeights
+	// gpuMu guards NvidiaGpu, the nodes are listed again when a pod runs on an unknown node
+	gpuMu sync.RWMutex
 }
 
 type quantity struct {
@@ -82,12 +218,143 @@ const (
 	PrometheusURL         = "PROM_URL"
 	ObjectStorageInstance = "OBJECT_STORAGE_INSTANCE"
 	ConcurrentLimit       = "CONCURRENT_LIMIT"
+	MeteringPolicyEnv     = "METERING_POLICY"
+	GpuMeteringPolicyEnv  = "GPU_METERING_POLICY"
+	// PodListPageSize lists the pods from the api server page by page if > 0, otherwise from the informer cache
+	PodListPageSize = "POD_LIST_PAGE_SIZE"
+	// PodListFromWatchCache lists the paged pods with resourceVersion=0, served by the api server watch cache
+	PodListFromWatchCache = "POD_LIST_FROM_WATCH_CACHE"
+	// SkipInitialAlignment runs the first reconcile immediately if true, default false
+	SkipInitialAlignment = "SKIP_INITIAL_ALIGNMENT"
+	// NamespaceUserLabel the namespace label key of the owning user, eg: user.sealos.io/owner
+	NamespaceUserLabel = "NAMESPACE_USER_LABEL"
+	// NamespaceLabelKey the label key of the tenant namespaces, default userv1.UserLabelOwnerKey
+	NamespaceLabelKey = "NAMESPACE_LABEL_KEY"
+	// NamespaceLabelValue the label value of the tenant namespaces, any value if not set
+	NamespaceLabelValue = "NAMESPACE_LABEL_VALUE"
+	// NamespaceSelector the label selector of the tenant namespaces, overrides the label key and value
+	NamespaceSelector = "NAMESPACE_SELECTOR"
+	// MeterPodOverhead adds the pod overhead of the RuntimeClass (eg: the kata or gvisor sandbox) to the cpu and memory
+	// of the started pods if true, default false
+	MeterPodOverhead = "METER_POD_OVERHEAD"
+	// GpuReplicasLabelKey the node label of the advertised-to-physical gpu ratio, eg: 4 if the gpu is time-sliced into 4 replicas
+	GpuReplicasLabelKey = "GPU_REPLICAS_LABEL_KEY"
+
+	gpuReplicasFromLabel    = "label"
+	gpuReplicasFromCapacity = "capacity"
+	gpuReplicasDefault      = "default"
+)
+
+// MeteringPolicy decides which value of the container resources is metered for cpu and memory
+type MeteringPolicy string
+
+const (
+	// MeteringPolicyLimits meters the limits, or the requests if the limits are not set. (default)
+	// The tenant pays for the burst capacity it is allowed to use.
+	MeteringPolicyLimits MeteringPolicy = "limits"
+	// MeteringPolicyRequests meters the requests, or the limits if the requests are not set.
+	// The tenant pays for the guaranteed reservation only, the burst above the requests is free.
+	MeteringPolicyRequests MeteringPolicy = "requests"
+	// MeteringPolicyMax meters the larger one of the requests and limits.
+	MeteringPolicyMax MeteringPolicy = "max"
+)
+
+func parseMeteringPolicy(policy string) (MeteringPolicy, error) {
+	switch p := MeteringPolicy(policy); p {
+	case "":
+		return MeteringPolicyLimits, nil
+	case MeteringPolicyLimits, MeteringPolicyRequests, MeteringPolicyMax:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid metering policy %q, must be one of: %s, %s, %s", policy, MeteringPolicyLimits, MeteringPolicyRequests, MeteringPolicyMax)
+}
+
+// normalizePromURL requires the http(s) scheme and host of the prometheus url and strips the trailing slash,
+// eg: http://prometheus:9090/ -> http://prometheus:9090
+func normalizePromURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid prometheus url %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid prometheus url %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid prometheus url %q: host is empty", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid prometheus url %q: query and fragment are not allowed", raw)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// GpuMeteringPolicy decides whether the gpu of a pod bound to a node is metered while the pod is not running.
+// The pods not scheduled to a node are never metered, the gpu model is unknown until then.
+type GpuMeteringPolicy string
+
+const (
+	// GpuMeteringPolicyReservation meters the gpu once the pod is bound to a node, also while it is pending. (default)
+	GpuMeteringPolicyReservation GpuMeteringPolicy = "reservation"
+	// GpuMeteringPolicyRunning meters the gpu like cpu and memory, the pods not started for more than 1 minute are not metered.
+	GpuMeteringPolicyRunning GpuMeteringPolicy = "running"
 )
 
+func parseGpuMeteringPolicy(policy string) (GpuMeteringPolicy, error) {
+	switch p := GpuMeteringPolicy(policy); p {
+	case "":
+		return GpuMeteringPolicyReservation, nil
+	case GpuMeteringPolicyReservation, GpuMeteringPolicyRunning:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid gpu metering policy %q, must be one of: %s, %s", policy, GpuMeteringPolicyReservation, GpuMeteringPolicyRunning)
+}
+
+func (p GpuMeteringPolicy) metered(pod *corev1.Pod) bool {
+	if pod.Spec.NodeName == "" {
+		return false
+	}
+	return p != GpuMeteringPolicyRunning || !podNotStarted(pod)
+}
+
+// podNotStarted the pod is not running and not started in the last minute, its cpu and memory are not metered
+func podNotStarted(pod *corev1.Pod) bool {
+	return pod.Status.Phase != corev1.PodRunning && (pod.Status.StartTime == nil || time.Since(pod.Status.StartTime.Time) > 1*time.Minute)
+}
+
+func (p MeteringPolicy) quantity(res corev1.ResourceRequirements, name corev1.ResourceName) resource.Quantity {
+	limit, hasLimit := res.Limits[name]
+	request, hasRequest := res.Requests[name]
+	switch p {
+	case MeteringPolicyRequests:
+		if hasRequest {
+			return request
+		}
+		return limit
+	case MeteringPolicyMax:
+		if limit.Cmp(request) >= 0 {
+			return limit
+		}
+		return request
+	default:
+		if hasLimit {
+			return limit
+		}
+		return request
+	}
+}
+
 var concurrentLimit = int64(DefaultConcurrencyLimit)
 
 const (
 	DefaultConcurrencyLimit = 1000
+	// DefaultSlowestBucketsLogged the number of the slowest bucket scans logged per cycle
+	DefaultSlowestBucketsLogged = 10
 )
 
 //+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
@@ -101,6 +368,8 @@ const (
 //+kubebuilder:rbac:groups=infra.sealos.io,resources=infras/finalizers,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=services/status,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 func NewMonitorReconciler(mgr ctrl.Manager) (*MonitorReconciler, error) {
 	r := &MonitorReconciler{
@@ -108,44 +377,237 @@ func NewMonitorReconciler(mgr ctrl.Manager) (*MonitorReconciler, error) {
 		Logger:                ctrl.Log.WithName("controllers").WithName("Monitor"),
 		stopCh:                make(chan struct{}),
 		periodicReconcile:     1 * time.Minute,
-		PromURL:               os.Getenv(PrometheusURL),
 		ObjectStorageInstance: os.Getenv(ObjectStorageInstance),
+		GpuReplicasLabel:      env.GetEnvWithDefault(GpuReplicasLabelKey, gpu.NvidiaGpuReplicasKey),
+		APIReader:             mgr.GetAPIReader(),
+		PodListPageSize:       env.GetInt64EnvWithDefault(PodListPageSize, 0),
+		PodListFromWatchCache: env.GetBoolEnvWithDefault(PodListFromWatchCache, false),
+		ListRetryAttempts:     int(env.GetInt64EnvWithDefault(ListRetryAttempts, DefaultListRetryAttempts)),
+		CollectRetryAttempts:  int(env.GetInt64EnvWithDefault(CollectRetryAttempts, DefaultCollectRetryAttempts)),
+		MeterByQOSClass:       env.GetBoolEnvWithDefault(MeterByQOSClass, false),
+		MeterPodOverhead:      env.GetBoolEnvWithDefault(MeterPodOverhead, false),
+		SkipInitialAlignment:  env.GetBoolEnvWithDefault(SkipInitialAlignment, false),
+		NamespaceUserLabel:    os.Getenv(NamespaceUserLabel),
+		tenants:               newTenantTracker(),
+		monitorPolicies:       newMonitorPolicies(),
+		objStorageBreaker: newObjStorageBreaker(int(env.GetInt64EnvWithDefault(ObjStorageBreakerThreshold, DefaultObjStorageBreakerThreshold)),
+			int(env.GetInt64EnvWithDefault(ObjStorageBreakerCooldown, DefaultObjStorageBreakerCooldown))),
+		meteringValve: newMeteringValve(int(env.GetInt64EnvWithDefault(MeteringPauseThreshold, DefaultMeteringPauseThreshold))),
 	}
-	concurrentLimit = env.GetInt64EnvWithDefault(ConcurrentLimit, DefaultConcurrencyLimit)
 	var err error
+	// the db client is set after the reconciler is created
+	if r.monitorWriter, err = newMonitorWriterFromEnv(r.insertMonitorDetailed); err != nil {
+		return nil, err
+	}
+	r.monitorQueue = newMonitorQueueFromEnv(r.insertNamespaceMonitors, r.Logger)
+	r.cpuOvercommit = newCPUOvercommitFromEnv()
+	r.usageExporter = newUsageExporterFromEnv()
+	if r.namespaceCache, err = newNamespaceCache(mgr.GetCache()); err != nil {
+		return nil, err
+	}
+	if r.cacheWarmUp, err = newCacheWarmUpFromEnv(mgr.GetCache()); err != nil {
+		return nil, err
+	}
+	r.SidecarContainers = splitList(os.Getenv(SidecarContainerNames))
+	concurrentLimit = env.GetInt64EnvWithDefault(ConcurrentLimit, DefaultConcurrencyLimit)
+	r.ObjStorageBucketConcurrency = int(env.GetInt64EnvWithDefault(ObjStorageBucketConcurrency, DefaultObjStorageBucketConcurrency))
+	r.CrashLoopRestartThreshold = int32(env.GetInt64EnvWithDefault(CrashLoopRestartThreshold, DefaultCrashLoopRestartThreshold))
+	r.MaxMonitorsPerNamespace = int(env.GetInt64EnvWithDefault(MaxMonitorsPerNamespace, 0))
+	if r.PendingPVCGrace, err = env.GetDurationEnvWithDefault(PendingPVCMeteringGrace, 0); err != nil {
+		return nil, err
+	}
+	if r.RollupAge, err = env.GetDurationEnvWithDefault(MonitorRollupAge, 0); err != nil {
+		return nil, err
+	}
+	if r.RollupLookback, err = env.GetDurationEnvWithDefault(MonitorRollupLookback, DefaultMonitorRollupLookback); err != nil {
+		return nil, err
+	}
+	if r.ObjStorageTimeout, err = env.GetDurationEnvWithDefault(ObjStorageTimeout, DefaultObjStorageTimeout); err != nil {
+		return nil, err
+	}
+	if r.ObjStorageScanTimeout, err = env.GetDurationEnvWithDefault(ObjStorageScanTimeout, DefaultObjStorageScanTimeout); err != nil {
+		return nil, err
+	}
+	if r.bucketFilter, err = newBucketFilterFromEnv(r.getBucketTags); err != nil {
+		return nil, err
+	}
+	if r.MeteringPolicy, err = parseMeteringPolicy(os.Getenv(MeteringPolicyEnv)); err != nil {
+		return nil, err
+	}
+	if r.UnboundedContainerDefaults, err = parseUnboundedContainerDefaults(); err != nil {
+		return nil, err
+	}
+	if r.PurgeGracePeriod, err = newPurgeGracePeriodFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.MeteringGranularity, err = parseMeteringGranularity(os.Getenv(MeteringGranularityEnv)); err != nil {
+		return nil, err
+	}
+	if r.NamespaceSelector, err = newNamespaceSelector(os.Getenv(NamespaceSelector), os.Getenv(NamespaceLabelKey), os.Getenv(NamespaceLabelValue)); err != nil {
+		return nil, err
+	}
+	r.Logger.Info("tenant namespace selector", "selector", r.NamespaceSelector.String())
+	if r.GpuMeteringPolicy, err = parseGpuMeteringPolicy(os.Getenv(GpuMeteringPolicyEnv)); err != nil {
+		return nil, err
+	}
+	if r.GpuNodeAggregator, err = parseGpuNodeAggregator(os.Getenv(GpuNodeAggregationEnv)); err != nil {
+		return nil, err
+	}
+	if r.NoncurrentBilling, err = parseNoncurrentBilling(os.Getenv(ObjStorageNoncurrentBillingEnv)); err != nil {
+		return nil, err
+	}
+	if r.ObjStorageFlowQuery, err = newObjStorageFlowQueryFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.PromURL, err = normalizePromURL(os.Getenv(PrometheusURL)); err != nil {
+		return nil, err
+	}
+	if r.PromURL != "" {
+		r.Logger.Info("prometheus url", "url", r.PromURL)
+	}
+	if r.TrafficWindow, err = newTrafficWindowFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.BillingLocation, err = parseBillingTimezone(os.Getenv(BillingTimezone)); err != nil {
+		return nil, err
+	}
+	if r.MonitorTimeTruncation, err = newMonitorTimeTruncationFromEnv(r.periodicReconcile); err != nil {
+		return nil, err
+	}
+	if r.CycleDeadline, err = newCycleDeadlineFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.MaxWindowBytes, err = parseMaxWindowBytes(os.Getenv(MaxWindowBytes)); err != nil {
+		return nil, err
+	}
+	if r.anomalyDetector, err = newAnomalyDetectorFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.subSampler, err = newSubSamplerFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.monitorDBHealth, err = newMonitorDBHealthFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.goroutineGuard, err = newGoroutineGuardFromEnv(); err != nil {
+		return nil, err
+	}
+	r.adminToken = os.Getenv(AdminToken)
+	if r.pprofToken, err = newPprofTokenFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.retention, err = newMonitorRetentionFromEnv(mgr.Elected()); err != nil {
+		return nil, err
+	}
+	if r.retention.days == 0 {
+		r.Logger.Info("monitor retention is disabled, the monitors are never dropped")
+	}
+	if r.archive, err = newMonitorArchiveFromEnv(r.retention.mode); err != nil {
+		return nil, err
+	}
+	if r.aggregation, err = newMonitorAggregationFromEnv(mgr.Elected(), r.RollupAge); err != nil {
+		return nil, err
+	}
+	if r.gapDetection, err = newMonitorGapDetectionFromEnv(mgr.Elected(), r.RollupAge); err != nil {
+		return nil, err
+	}
+	if r.billingReconciliation, err = newBillingReconciliationFromEnv(mgr.Elected()); err != nil {
+		return nil, err
+	}
+	if r.MonitorEnrichers, err = newMonitorEnrichersFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.usageMetrics, err = newUsageMetricsFromEnv(r.PromURL); err != nil {
+		return nil, err
+	}
+	if env.GetBoolEnvWithDefault(GpuUtilizationCollector, false) {
+		if r.gpuUtilization, err = newGpuUtilizationCollector(r.PromURL, os.Getenv(GpuUtilizationQuery)); err != nil {
+			return nil, err
+		}
+	}
+	// the cache of the manager is not started yet, the nodes are read from the api server directly
 	err = retry.Retry(2, 1*time.Second, func() error {
-		r.NvidiaGpu, err = gpu.GetNodeGpuModel(mgr.GetClient())
+		r.NvidiaGpu, err = gpu.GetNodeGpuModel(mgr.GetAPIReader())
 		if err != nil {
-			return fmt.Errorf("failed to get node gpu model: %v", err)
+			return fmt.Errorf("failed to get node gpu model: %w", err)
 		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	r.Logger.Info("get gpu model", "gpu model", r.NvidiaGpu)
+	if len(r.NvidiaGpu) == 0 {
+		r.Logger.Info("no gpu nodes found, only cpu, memory and storage are metered until a gpu node joins")
+	} else {
+		r.Logger.Info("get gpu model", "gpu model", r.NvidiaGpu)
+	}
 	return r, nil
 }
 
 func (r *MonitorReconciler) StartReconciler(ctx context.Context) error {
+	if r.namespaceCache != nil {
+		r.startNamespaceCache()
+	}
 	r.startPeriodicReconcile()
+	if r.subSampler != nil {
+		r.startSubSampling()
+	}
 	if r.TrafficClient != nil {
 		r.startMonitorTraffic()
 	}
+	if r.RollupAge > 0 {
+		r.startMonitorRollup()
+	}
+	if r.retention != nil {
+		r.startMonitorRetention()
+	}
+	if r.aggregation != nil {
+		r.startMonitorAggregation()
+	}
+	if r.gapDetection != nil {
+		r.startMonitorGapDetection()
+	}
+	if r.billingReconciliation != nil {
+		r.startBillingReconciliation()
+	}
+	if r.propertiesReloader != nil {
+		r.startPropertiesReload()
+	}
+	if r.monitorDBHealth != nil {
+		r.startMonitorDBHealth()
+	}
+	if r.goroutineGuard != nil {
+		r.startGoroutineGuard()
+	}
 	<-ctx.Done()
 	r.stopPeriodicReconcile()
 	return nil
 }
 
 func (r *MonitorReconciler) startPeriodicReconcile() {
+	if r.monitorWriter != nil {
+		r.monitorWriter.start()
+	}
+	if r.monitorQueue != nil {
+		r.monitorQueue.start()
+	}
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
-		waitNextMinute()
-		ticker := time.NewTicker(r.periodicReconcile)
+		if !r.warmUp() {
+			return
+		}
+		if r.SkipInitialAlignment {
+			// run the first pass immediately, the next passes are still aligned to the minute
+			r.enqueueNamespacesForReconcile()
+		}
+		if !waitNextMinute(r.clock(), r.stopCh) {
+			return
+		}
+		ticker := r.clock().NewTicker(r.periodicReconcile)
 		for {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 				r.enqueueNamespacesForReconcile()
 			case <-r.stopCh:
 				ticker.Stop()
@@ -157,51 +619,88 @@ func (r *MonitorReconciler) startPeriodicReconcile() {
 
 func (r *MonitorReconciler) getNamespaceList() (*corev1.NamespaceList, error) {
 	namespaceList := &corev1.NamespaceList{}
-	req, err := labels.NewRequirement(userv1.UserLabelOwnerKey, selection.Exists, nil)
+	selector := r.NamespaceSelector
+	if selector == nil {
+		var err error
+		if selector, err = newNamespaceSelector("", "", ""); err != nil {
+			return nil, err
+		}
+	}
+	return namespaceList, r.namespaceReader().List(context.Background(), namespaceList, &client.ListOptions{
+		LabelSelector: selector,
+	})
+}
+
+// newNamespaceSelector returns the selector of the tenant namespaces. The label selector string is used if set, eg: "tenant in (a,b),!system",
+// otherwise the namespaces with the label key (default userv1.UserLabelOwnerKey) are selected, and the label value must match if set.
+func newNamespaceSelector(selector, key, value string) (labels.Selector, error) {
+	if selector != "" {
+		s, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector %q: %v", selector, err)
+		}
+		return s, nil
+	}
+	if key == "" {
+		key = userv1.UserLabelOwnerKey
+	}
+	op, values := selection.Exists, []string(nil)
+	if value != "" {
+		op, values = selection.Equals, []string{value}
+	}
+	req, err := labels.NewRequirement(key, op, values)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create label requirement: %v", err)
 	}
-	return namespaceList, r.List(context.Background(), namespaceList, &client.ListOptions{
-		LabelSelector: labels.NewSelector().Add(*req),
-	})
+	return labels.NewSelector().Add(*req), nil
 }
 
-func waitNextMinute() {
-	waitTime := time.Until(time.Now().Truncate(time.Minute).Add(1 * time.Minute))
-	if waitTime > 0 {
-		logger.Info("wait for first reconcile", "waitTime", waitTime)
-		time.Sleep(waitTime)
+// clock returns the clock aligning the periodic reconcile to the minute, the real clock if reconcileClock is not set
+func (r *MonitorReconciler) clock() clock.WithTicker {
+	if r.reconcileClock == nil {
+		return clock.RealClock{}
 	}
+	return r.reconcileClock
 }
 
-func waitNextHour() {
-	waitTime := time.Until(time.Now().Truncate(time.Hour).Add(1 * time.Hour))
-	if waitTime > 0 {
-		logger.Info("wait for first reconcile", "waitTime", waitTime)
-		time.Sleep(waitTime)
+// waitNextMinute returns false if stopped before the next minute, so the stop doesn't wait for the first reconcile
+func waitNextMinute(c clock.Clock, stopCh <-chan struct{}) bool {
+	now := c.Now()
+	waitTime := now.Truncate(time.Minute).Add(1 * time.Minute).Sub(now)
+	if waitTime <= 0 {
+		return true
+	}
+	logger.Info("wait for first reconcile", "waitTime", waitTime)
+	select {
+	case <-c.After(waitTime):
+		return true
+	case <-stopCh:
+		return false
 	}
 }
 
+// startMonitorTraffic meters the traffic of each window after the window ends, the first window starts now
 func (r *MonitorReconciler) startMonitorTraffic() {
+	window := r.TrafficWindow
+	if window <= 0 {
+		window = DefaultTrafficWindow
+	}
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
-		startTime, endTime := time.Now().UTC(), time.Now().Truncate(time.Hour).Add(1*time.Hour).UTC()
-		waitNextHour()
-		ticker := time.NewTicker(1 * time.Hour)
-		if err := r.MonitorPodTrafficUsed(startTime, endTime); err != nil {
-			r.Logger.Error(err, "failed to monitor pod traffic used")
-		}
+		now := time.Now().UTC()
+		startTime, endTime := now, trafficWindowEnd(now, window, r.BillingLocation)
 		for {
+			// the windows are not of a fixed length across the daylight saving time transitions, so each end is computed
+			timer := time.NewTimer(time.Until(endTime))
 			select {
-			case <-ticker.C:
-				startTime, endTime = endTime, endTime.Add(1*time.Hour)
+			case <-timer.C:
 				if err := r.MonitorPodTrafficUsed(startTime, endTime); err != nil {
 					r.Logger.Error(err, "failed to monitor pod traffic used")
-					break
 				}
+				startTime, endTime = endTime, trafficWindowEnd(endTime, window, r.BillingLocation)
 			case <-r.stopCh:
-				ticker.Stop()
+				timer.Stop()
 				return
 			}
 		}
@@ -211,88 +710,199 @@ func (r *MonitorReconciler) startMonitorTraffic() {
 func (r *MonitorReconciler) stopPeriodicReconcile() {
 	close(r.stopCh)
 	r.wg.Wait()
+	// flush the pending monitors once no namespace is processed, the batches of the queue drained are not lingered
+	if r.monitorWriter != nil {
+		r.monitorWriter.drain()
+	}
+	if r.monitorQueue != nil {
+		r.monitorQueue.stop()
+	}
+	if r.monitorWriter != nil {
+		r.monitorWriter.stop()
+	}
 }
 
 func (r *MonitorReconciler) enqueueNamespacesForReconcile() {
 	r.Logger.Info("enqueue namespaces for reconcile", "time", time.Now().Format(time.RFC3339))
+	if !r.meteringValve.startCycle(r.probeMonitorDB) {
+		return
+	}
+	deadline := r.cycleDeadline(time.Now())
 
 	namespaceList, err := r.getNamespaceList()
 	if err != nil {
 		r.Logger.Error(err, "failed to list namespaces")
 		return
 	}
+	// deleted or terminating tenants are no longer metered
+	namespaceList = r.tenants.sync(namespaceList, time.Now())
 
-	if err := r.processNamespaceList(namespaceList); err != nil {
+	if err := r.processNamespaceList(namespaceList, deadline); err != nil {
 		r.Logger.Error(err, "failed to process namespace", "time", time.Now().Format(time.RFC3339))
 	}
+	r.purgeDeletedTenants()
 }
 
-func (r *MonitorReconciler) processNamespaceList(namespaceList *corev1.NamespaceList) error {
+// processNamespaceList meters the namespaces, no namespace is started after the deadline unless it's zero
+func (r *MonitorReconciler) processNamespaceList(namespaceList *corev1.NamespaceList, deadline time.Time) error {
 	logger.Info("start processNamespaceList", "namespaceList len", len(namespaceList.Items), "time", time.Now().Format(time.RFC3339))
 	if len(namespaceList.Items) == 0 {
 		r.Logger.Error(fmt.Errorf("no namespace to process"), "")
 		return nil
 	}
-	sem := semaphore.NewWeighted(concurrentLimit)
+	r.cycleTime = r.monitorTime(time.Now())
+	defer func() {
+		r.cycleTime = time.Time{}
+	}()
+	r.snapshotProperties()
+	r.monitorPolicies.refresh(context.Background(), r.Client, r.Logger)
+	r.cpuOvercommit.refresh(context.Background(), r.Client, r.Logger)
+	r.refreshGpuNodeWeights(context.Background())
+	r.objStorageScan = objstorage.NewScanCycle()
+	r.objStorageBackpressured = r.cycleBackpressured()
+	if r.ObjStorageClient != nil && !r.objStorageBackpressured {
+		r.objStorageBreaker.startCycle(r.probeObjStorage)
+	}
+	r.processNamespacesBefore(deadline, namespaceList.Items, int(concurrentLimit), func(namespace *corev1.Namespace) {
+		// the rest of the cycle is skipped once the metering is paused
+		if r.meteringValve.paused() {
+			return
+		}
+		if err := r.monitorResourceUsage(namespace); err != nil && !errors.Is(err, errMeteringPaused) {
+			r.Logger.Error(err, "monitor pod resource", "namespace", namespace.Name)
+		}
+	})
+	if r.anomalyDetector != nil {
+		r.anomalyDetector.forget(namespaceNames(namespaceList.Items))
+	}
+	if r.subSampler != nil {
+		r.subSampler.forget(namespaceNames(namespaceList.Items))
+	}
+	if r.usageExporter != nil {
+		r.usageExporter.forget(namespaceNames(namespaceList.Items))
+	}
+	if r.ObjStorageClient != nil {
+		r.logObjStorageScan(r.objStorageScan.Finish(DefaultSlowestBucketsLogged))
+	}
+	logger.Info("end processNamespaceList", "time", time.Now().Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// processNamespaces processes the namespaces with a pool of workers pulling from a channel,
+// so the number of goroutines is bounded by workers instead of the number of namespaces.
+// The namespaces not yet dispatched are dropped once the context is done, the number dispatched is returned.
+func processNamespaces(ctx context.Context, namespaces []corev1.Namespace, workers int, process func(namespace *corev1.Namespace)) int {
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(namespaces) {
+		workers = len(namespaces)
+	}
+	queue := make(chan *corev1.Namespace)
+	dispatched := len(namespaces)
 	wg := sync.WaitGroup{}
-	wg.Add(len(namespaceList.Items))
-	for i := range namespaceList.Items {
-		go func(namespace *corev1.Namespace) {
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
 			defer wg.Done()
-			if err := sem.Acquire(context.Background(), 1); err != nil {
-				fmt.Printf("Failed to acquire semaphore: %v\n", err)
-				return
-			}
-			defer sem.Release(1)
-			if err := r.monitorResourceUsage(namespace); err != nil {
-				r.Logger.Error(err, "monitor pod resource", "namespace", namespace.Name)
+			for namespace := range queue {
+				process(namespace)
 			}
-		}(&namespaceList.Items[i])
+		}()
+	}
+dispatch:
+	for i := range namespaces {
+		select {
+		case queue <- &namespaces[i]:
+		case <-ctx.Done():
+			logger.Info("stop processing namespaces", "processed", i, "total", len(namespaces))
+			dispatched = i
+			break dispatch
+		}
 	}
+	close(queue)
 	wg.Wait()
-	logger.Info("end processNamespaceList", "time", time.Now().Format("2006-01-02 15:04:05"))
-	return nil
+	return dispatched
 }
 
 func (r *MonitorReconciler) monitorResourceUsage(namespace *corev1.Namespace) error {
-	timeStamp := time.Now().UTC()
-	podList := corev1.PodList{}
+	// the boundary of the cycle, a controller restarted within it writes the same monitor ids and the stores skip them
+	timeStamp := r.resourceMonitorTime()
+	// the monitor policy of the namespace overrides the global config
+	policy := r.monitorPolicies.get(namespace)
+	if !policy.meters(timeStamp) {
+		return nil
+	}
+	monitors, err := r.collectMonitorsWithRetry(namespace, timeStamp)
+	if err != nil {
+		return err
+	}
+	monitors = r.subSampler.aggregate(namespace.Name, timeStamp, monitors)
+	r.exportUsage(namespace.Name, monitors)
+	monitors = policy.apply(r.properties(), monitors)
+	monitors = r.capNamespaceMonitors(namespace.Name, monitors)
+	r.enrichMonitors(namespace, monitors)
+	r.detectUsageAnomalies(namespace.Name, monitors)
+	return r.writeMonitors(namespace.Name, monitors)
+}
+
+// collectMonitors returns the monitors of the resources used by the namespace at the time. The usage only collections
+// (the sub-minute samples and ComputeNamespaceUsage) skip the object storage, the gpu utilization and the crash looping
+// pods, which are collected once per minute by the metering.
+func (r *MonitorReconciler) collectMonitors(namespace *corev1.Namespace, timeStamp time.Time, usageOnly bool) ([]*resources.Monitor, error) {
 	resUsed := map[string]map[corev1.ResourceName]*quantity{}
 	resNamed := make(map[string]*resources.ResourceNamed)
-	if err := r.List(context.Background(), &podList, &client.ListOptions{Namespace: namespace.Name}); err != nil {
-		return err
+	gpuAppPods := gpuPods{}
+	pods, err := r.listPods(namespace.Name)
+	if err != nil {
+		return nil, err
 	}
-	for _, pod := range podList.Items {
+	usage := r.usageOfNamespace(namespace.Name)
+	for _, pod := range pods {
 		if pod.Spec.NodeName == "" || (pod.Status.Phase == corev1.PodSucceeded && time.Since(pod.Status.StartTime.Time) > 1*time.Minute) {
 			continue
 		}
-		podResNamed := resources.NewResourceNamed(&pod)
+		// the pods of the same workload share the key and are summed unless metered per pod, see MeteringGranularity
+		podResNamed := resources.NewResourceNamed(&pod).WithProperty(joinProperties(r.podProperty(&pod), r.qosProperty(&pod)))
 		resNamed[podResNamed.String()] = podResNamed
 		if resUsed[podResNamed.String()] == nil {
 			resUsed[podResNamed.String()] = initResources()
 		}
-		// skip pods that do not start for more than 1 minute
-		skip := pod.Status.Phase != corev1.PodRunning && (pod.Status.StartTime == nil || time.Since(pod.Status.StartTime.Time) > 1*time.Minute)
+		restarts, crashLooping := podCrashLooping(&pod, r.CrashLoopRestartThreshold)
+		// the crash looping pods are counted once per minute
+		if crashLooping && !usageOnly {
+			r.observeCrashLooping(&pod, restarts)
+		}
+		// skip pods that do not start for more than 1 minute, the restarting pods still reserve the node
+		skip := podNotStarted(&pod) && !podReservesNode(restarts, crashLooping)
+		meterGpu := r.GpuMeteringPolicy.metered(&pod) || !skip
 		for _, container := range pod.Spec.Containers {
-			// gpu only use limit and not ignore pod pending status
-			if gpuRequest, ok := container.Resources.Limits[gpu.NvidiaGpuKey]; ok {
+			// gpu only use limit, the pending pods are metered by the gpu metering policy
+			if gpuRequest, ok := container.Resources.Limits[gpu.NvidiaGpuKey]; ok && meterGpu {
 				err := r.getGPUResourceUsage(pod, gpuRequest, resUsed[podResNamed.String()])
 				if err != nil {
 					r.Logger.Error(err, "get gpu resource usage failed", "pod", pod.Name)
+				} else if r.gpuUtilization != nil && !usageOnly {
+					gpuModel, _ := r.gpuModel(pod.Spec.NodeName)
+					gpuAppPods.add(podResNamed.String(), resources.NewGpuResource(gpuModel.GpuInfo.GpuProduct), pod.Name)
 				}
 			}
 			if skip {
 				continue
 			}
-			if cpuRequest, ok := container.Resources.Limits[corev1.ResourceCPU]; ok {
-				resUsed[podResNamed.String()][corev1.ResourceCPU].Add(cpuRequest)
-			} else {
-				resUsed[podResNamed.String()][corev1.ResourceCPU].Add(container.Resources.Requests[corev1.ResourceCPU])
-			}
-			if memoryRequest, ok := container.Resources.Limits[corev1.ResourceMemory]; ok {
-				resUsed[podResNamed.String()][corev1.ResourceMemory].Add(memoryRequest)
-			} else {
-				resUsed[podResNamed.String()][corev1.ResourceMemory].Add(container.Resources.Requests[corev1.ResourceMemory])
+			res := r.containerResource(&pod, podResNamed, container.Name, resNamed, resUsed)
+			resUsed[res][corev1.ResourceCPU].Add(r.cpuOvercommit.weigh(pod.Spec.NodeName, r.containerQuantity(usage, &pod, &container, corev1.ResourceCPU)))
+			resUsed[res][corev1.ResourceMemory].Add(r.containerQuantity(usage, &pod, &container, corev1.ResourceMemory))
+		}
+		// the sandbox reserves the overhead on the node besides the containers, whatever the metering policy
+		if r.MeterPodOverhead && !skip {
+			for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+				if overhead, ok := pod.Spec.Overhead[name]; ok {
+					if name == corev1.ResourceCPU {
+						overhead = r.cpuOvercommit.weigh(pod.Spec.NodeName, overhead)
+					}
+					resUsed[podResNamed.String()][name].Add(overhead)
+				}
 			}
 		}
 	}
@@ -300,14 +910,14 @@ func (r *MonitorReconciler) monitorResourceUsage(namespace *corev1.Namespace) er
 	//logger.Info("mid", "namespace", namespace.Name, "time", timeStamp.Format("2006-01-02 15:04:05"), "resourceMap", resourceMap, "podsRes", podsRes)
 
 	pvcList := corev1.PersistentVolumeClaimList{}
-	if err := r.List(context.Background(), &pvcList, &client.ListOptions{Namespace: namespace.Name}); err != nil {
-		return fmt.Errorf("failed to list pvc: %v", err)
+	if err := r.listWithRetry(r.Client, "pvcs", &pvcList, &client.ListOptions{Namespace: namespace.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list pvc: %w", err)
 	}
 	for _, pvc := range pvcList.Items {
-		if pvc.Status.Phase != corev1.ClaimBound || pvc.Name == resources.KubeBlocksBackUpName {
+		pvcRes, metered := r.meteredPVC(&pvc, timeStamp)
+		if !metered {
 			continue
 		}
-		pvcRes := resources.NewResourceNamed(&pvc)
 		if resUsed[pvcRes.String()] == nil {
 			resNamed[pvcRes.String()] = pvcRes
 			resUsed[pvcRes.String()] = initResources()
@@ -315,11 +925,18 @@ func (r *MonitorReconciler) monitorResourceUsage(namespace *corev1.Namespace) er
 		resUsed[pvcRes.String()][corev1.ResourceStorage].Add(pvc.Spec.Resources.Requests[corev1.ResourceStorage])
 	}
 	svcList := corev1.ServiceList{}
-	if err := r.List(context.Background(), &svcList, &client.ListOptions{Namespace: namespace.Name}); err != nil {
-		return fmt.Errorf("failed to list svc: %v", err)
+	if err := r.listWithRetry(r.Client, "services", &svcList, &client.ListOptions{Namespace: namespace.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list svc: %w", err)
 	}
 	for _, svc := range svcList.Items {
-		if svc.Spec.Type != corev1.ServiceTypeNodePort {
+		var used map[corev1.ResourceName]resource.Quantity
+		switch svc.Spec.Type {
+		case corev1.ServiceTypeNodePort:
+			used = map[corev1.ResourceName]resource.Quantity{corev1.ResourceServicesNodePorts: r.nodePortQuantity()}
+		case corev1.ServiceTypeLoadBalancer:
+			used = r.loadBalancerQuantities(&svc)
+		}
+		if len(used) == 0 {
 			continue
 		}
 		svcRes := resources.NewResourceNamed(&svc)
@@ -327,43 +944,177 @@ func (r *MonitorReconciler) monitorResourceUsage(namespace *corev1.Namespace) er
 			resNamed[svcRes.String()] = svcRes
 			resUsed[svcRes.String()] = initResources()
 		}
-		// nodeport 1:1000, the measurement is quantity 1000
-		resUsed[svcRes.String()][corev1.ResourceServicesNodePorts].Add(*resource.NewQuantity(1000, resource.BinarySI))
+		for name, q := range used {
+			if resUsed[svcRes.String()][name] == nil {
+				resUsed[svcRes.String()][name] = &quantity{Quantity: resource.NewQuantity(0, resource.DecimalSI), detail: ""}
+			}
+			resUsed[svcRes.String()][name].Add(q)
+		}
 	}
 
 	var monitors []*resources.Monitor
 
-	if username := config.GetUserNameByNamespace(namespace.Name); r.ObjStorageClient != nil {
-		if err := r.getObjStorageUsed(username, &resNamed, &resUsed); err != nil {
+	// the other resources are still metered if the object storage is unavailable
+	if username := r.namespaceUser(namespace); r.ObjStorageClient != nil && !usageOnly && !r.objStorageBackpressured && r.objStorageBreaker.allow() {
+		exempt, err := namespaceBucketExemption(namespace)
+		if err != nil {
+			r.Logger.Error(err, "use the global bucket exemption", "namespace", namespace.Name)
+		}
+		usage, err := r.getObjStorageUsed(username, exempt, &resNamed, &resUsed)
+		r.objStorageBreaker.record(err)
+		if err != nil {
 			r.Logger.Error(err, "failed to get object storage used", "username", username)
+		} else {
+			r.enforceObjStorageQuota(namespace, usage)
 		}
 	}
+	gpuUtil := r.getGpuUtilization(namespace.Name, gpuAppPods)
 	for name, podResource := range resUsed {
 		isEmpty, used := r.getResourceUsed(podResource)
 		if isEmpty {
 			continue
 		}
 		monitors = append(monitors, &resources.Monitor{
-			Category: namespace.Name,
-			Used:     used,
-			Time:     timeStamp,
-			Type:     resNamed[name].Type(),
-			Name:     resNamed[name].Name(),
+			Category:    namespace.Name,
+			Used:        used,
+			Time:        timeStamp,
+			Type:        resNamed[name].Type(),
+			Name:        resNamed[name].Name(),
+			Utilization: gpuUtil[name],
+			ObjStorage:  resNamed[name].ObjStorageDetail(),
+			Property:    resNamed[name].Property(),
 		})
 	}
-	return r.DBClient.InsertMonitor(context.Background(), monitors...)
+	return monitors, nil
+}
+
+// namespaceUser resolves the owning user of the namespace by the user label,
+// and falls back to the name convention ns-<user> if the label is not set.
+func (r *MonitorReconciler) namespaceUser(namespace *corev1.Namespace) string {
+	if r.NamespaceUserLabel != "" {
+		if user := namespace.Labels[r.NamespaceUserLabel]; user != "" {
+			return user
+		}
+	}
+	return config.GetUserNameByNamespace(namespace.Name)
+}
+
+// insertMonitor backs off on transient db errors and fails fast on permanent ones. If the store reports the failed monitors,
+// only the monitors failed with transient errors are retried and the permanent failures (eg: a poison monitor) are dropped,
+// the other monitors of the namespace are still inserted.
+func (r *MonitorReconciler) insertMonitor(monitors ...*resources.Monitor) error {
+	return monitorInsertErr(len(monitors), r.insertMonitorDetailed(monitors...))
+}
+
+// insertMonitorDetailed inserts the monitors retrying the transient failures, and returns the errors of the monitors
+// not inserted by their index in the monitors
+func (r *MonitorReconciler) insertMonitorDetailed(monitors ...*resources.Monitor) database.MonitorInsertResults {
+	failed := make(database.MonitorInsertResults)
+	pending := make([]int, len(monitors))
+	for i := range pending {
+		pending[i] = i
+	}
+	_ = retry.RetryTransient(3, 1*time.Second, func() error {
+		batch := make([]*resources.Monitor, len(pending))
+		for i, index := range pending {
+			batch[i] = monitors[index]
+		}
+		results := database.InsertMonitorDetailed(context.Background(), r.DBClient, batch...)
+		var retrying []int
+		for i, index := range pending {
+			err, ok := results[i]
+			if !ok {
+				delete(failed, index)
+				continue
+			}
+			failed[index] = err
+			if retry.IsTransient(err) {
+				retrying = append(retrying, index)
+			}
+		}
+		if pending = retrying; len(pending) > 0 {
+			return results.Err()
+		}
+		return nil
+	})
+	// the database is unhealthy if no monitor is inserted, a poison monitor among the inserted ones is not counted
+	if len(failed) < len(monitors) {
+		r.meteringValve.record(nil)
+	} else {
+		r.meteringValve.record(monitorInsertErr(len(monitors), failed))
+	}
+	return failed
+}
+
+// monitorInsertErr returns the error of the failed monitors of n inserted monitors, transient if any of them may be
+// inserted by a retry, otherwise permanent since the monitors are dropped
+func monitorInsertErr(n int, failed database.MonitorInsertResults) error {
+	err := failed.Err()
+	if err == nil || retry.IsTransient(err) {
+		return err
+	}
+	return retry.Permanent(fmt.Errorf("dropped %d of %d monitors: %w", len(failed), n, err))
+}
+
+// replaceMonitor replaces the monitors of the same idempotency keys
+func (r *MonitorReconciler) replaceMonitor(monitors ...*resources.Monitor) error {
+	return retry.RetryTransient(3, 1*time.Second, func() error {
+		return r.DBClient.ReplaceMonitors(context.Background(), monitors...)
+	})
+}
+
+// nodePortQuantity returns the measured quantity of one node port, configured by the property ratio (default nodeport 1:1000)
+func (r *MonitorReconciler) nodePortQuantity() resource.Quantity {
+	return *resource.NewQuantity(r.properties().GetRatio(corev1.ResourceServicesNodePorts.String(), resources.DefaultNodePortRatio), resource.BinarySI)
+}
+
+// listPods lists the pods of the namespace.
+// By default the pods are read from the informer cache, which is fresh but keeps all pods in the controller memory.
+// If the page size is set, the scheduled pods are listed from the api server page by page to cut the api server
+// and memory pressure, with resourceVersion=0 the pages are served by the api server watch cache,
+// which is cheaper but may be slightly stale and the api server may ignore the page size.
+func (r *MonitorReconciler) listPods(namespace string) ([]corev1.Pod, error) {
+	if r.PodListPageSize <= 0 || r.APIReader == nil {
+		podList := corev1.PodList{}
+		if err := r.listWithRetry(r.Client, "pods", &podList, &client.ListOptions{Namespace: namespace}); err != nil {
+			return nil, err
+		}
+		return podList.Items, nil
+	}
+	var pods []corev1.Pod
+	opts := &client.ListOptions{
+		Namespace: namespace,
+		// unscheduled pods are not metered
+		FieldSelector: fields.OneTermNotEqualSelector("spec.nodeName", ""),
+		Limit:         r.PodListPageSize,
+	}
+	if r.PodListFromWatchCache {
+		opts.Raw = &metav1.ListOptions{ResourceVersion: "0"}
+	}
+	for {
+		podList := corev1.PodList{}
+		if err := r.listWithRetry(r.APIReader, "pods", &podList, opts); err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
+		pods = append(pods, podList.Items...)
+		if podList.Continue == "" {
+			return pods, nil
+		}
+		opts.Continue = podList.Continue
+	}
 }
 
 func (r *MonitorReconciler) getResourceUsed(podResource map[corev1.ResourceName]*quantity) (bool, map[uint8]int64) {
 	used := map[uint8]int64{}
 	isEmpty := true
+	properties := r.properties()
 	for i := range podResource {
 		if podResource[i].MilliValue() == 0 {
 			continue
 		}
 		isEmpty = false
-		if pType, ok := r.Properties.StringMap[i.String()]; ok {
-			used[pType.Enum] = int64(math.Ceil(float64(podResource[i].MilliValue()) / float64(pType.Unit.MilliValue())))
+		if pType, ok := properties.StringMap[i.String()]; ok {
+			used[pType.Enum] = pType.UsedUnits(podResource[i].MilliValue())
 			continue
 		}
 		r.Logger.Error(fmt.Errorf("not found resource type"), "resource", i.String())
@@ -371,32 +1122,73 @@ func (r *MonitorReconciler) getResourceUsed(podResource map[corev1.ResourceName]
 	return isEmpty, used
 }
 
-func (r *MonitorReconciler) getObjStorageUsed(user string, namedMap *map[string]*resources.ResourceNamed, resMap *map[string]map[corev1.ResourceName]*quantity) error {
-	buckets, err := objstorage.ListUserObjectStorageBucket(r.ObjStorageClient, user)
+// getObjStorageUsed adds the object storage used of the user buckets and returns the metered bucket sizes,
+// the buckets exempt by the names of the tenant (the global ones if nil) are skipped
+func (r *MonitorReconciler) getObjStorageUsed(user string, exempt *bucketExemption, namedMap *map[string]*resources.ResourceNamed, resMap *map[string]map[corev1.ResourceName]*quantity) (*ObjStorageUsage, error) {
+	var (
+		buckets    []minio.BucketInfo
+		scanClient *minio.Client
+	)
+	err := r.objStorageDo(user, func(client *minio.Client) (err error) {
+		scanClient = client
+		ctx, cancel := withTimeout(r.ObjStorageTimeout)
+		defer cancel()
+		buckets, err = objstorage.ListUserObjectStorageBuckets(ctx, client, user)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to list object storage user %s storage size: %w", user, err)
+		return nil, fmt.Errorf("failed to list object storage user %s storage size: %w", user, err)
 	}
+	usage := &ObjStorageUsage{User: user}
 	if len(buckets) == 0 {
-		return nil
+		return usage, nil
 	}
-	for i := range buckets {
-		size, count := objstorage.GetObjectStorageSize(r.ObjStorageClient, buckets[i])
-		if count == 0 {
+	// the buckets are scanned in parallel, the results are merged into the maps of the namespace in the order of the buckets
+	for _, scan := range r.scanBuckets(user, exempt, scanClient, buckets) {
+		if scan.skipped {
 			continue
 		}
-		bytes, err := objstorage.GetObjectStorageFlow(r.PromURL, buckets[i], r.ObjectStorageInstance)
-		if err != nil {
-			return fmt.Errorf("failed to get object storage user storage flow: %w", err)
+		if scan.err != nil {
+			r.Logger.Error(scan.err, "failed to scan object storage bucket, skip it", "bucket", scan.bucket)
+			usage.FailedBuckets = append(usage.FailedBuckets, scan.bucket)
+			continue
 		}
-		objStorageNamed := resources.NewObjStorageResourceNamed(buckets[i])
+		// the non-current versions use the disk, they count toward the quota if listed
+		usage.Buckets = append(usage.Buckets, BucketUsage{Bucket: scan.bucket, Size: scan.size.Total()})
+		usage.Size += scan.size.Total()
+		if scan.size.Objects+scan.size.Versions == 0 {
+			continue
+		}
+		objStorageNamed := resources.NewObjStorageResourceNamedWithDetail(scan.bucket, scan.detail)
 		(*namedMap)[objStorageNamed.String()] = objStorageNamed
 		if _, ok := (*resMap)[objStorageNamed.String()]; !ok {
 			(*resMap)[objStorageNamed.String()] = initResources()
 		}
-		(*resMap)[objStorageNamed.String()][corev1.ResourceStorage].Add(*resource.NewQuantity(size, resource.BinarySI))
-		(*resMap)[objStorageNamed.String()][resources.ResourceNetwork].Add(*resource.NewQuantity(bytes, resource.BinarySI))
+		r.NoncurrentBilling.meterBucketSize((*resMap)[objStorageNamed.String()], scan.size)
+		// the size is still metered if the flow of the bucket failed, the other buckets are not affected
+		if scan.flowErr != nil {
+			r.Logger.Error(scan.flowErr, "failed to get object storage bucket flow", "bucket", scan.bucket)
+			continue
+		}
+		(*resMap)[objStorageNamed.String()][resources.ResourceNetwork].Add(*resource.NewQuantity(scan.flow, resource.BinarySI))
 	}
-	return nil
+	return usage, nil
+}
+
+func (r *MonitorReconciler) logObjStorageScan(summary objstorage.ScanSummary) {
+	slowest := make([]string, len(summary.Slowest))
+	for i, scan := range summary.Slowest {
+		slowest[i] = fmt.Sprintf("%s(%s, %d objects)", scan.Bucket, scan.Duration.Round(time.Millisecond), scan.Objects)
+	}
+	r.Logger.Info("object storage scan cycle", "scanned", summary.Scanned, "skipped", summary.Skipped, "failed", summary.Failed, "slowest buckets", slowest)
+}
+
+// objStorageDo runs the operation with the tenant scoped client if enabled, otherwise with the admin client
+func (r *MonitorReconciler) objStorageDo(user string, op func(client *minio.Client) error) error {
+	if r.ObjStorageTenantClients != nil {
+		return r.ObjStorageTenantClients.Do(user, op)
+	}
+	return r.ObjStorageClient.Do(op)
 }
 
 func (r *MonitorReconciler) MonitorPodTrafficUsed(startTime, endTime time.Time) error {
@@ -419,12 +1211,18 @@ func (r *MonitorReconciler) monitorPodTrafficUsed(namespace corev1.Namespace, st
 		return fmt.Errorf("failed to get distinct monitor combinations: %w", err)
 	}
 	for _, monitor := range monitors {
-		bytes, err := r.TrafficClient.GetTrafficSentBytes(startTime, endTime, namespace.Name, monitor.Type, monitor.Name)
+		var bytes int64
+		err := retry.RetryTransient(3, 1*time.Second, func() (err error) {
+			bytes, err = r.TrafficClient.GetTrafficSentBytes(startTime, endTime, namespace.Name, monitor.Type, monitor.Name)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("failed to get traffic sent bytes: %w", err)
 		}
-		unit := r.Properties.StringMap[resources.ResourceNetwork].Unit
-		used := int64(math.Ceil(float64(resource.NewQuantity(bytes, resource.BinarySI).MilliValue()) / float64(unit.MilliValue())))
+		bytes = r.guardBytes(byteSourceTraffic, namespace.Name+"/"+monitor.Name, bytes)
+		network := r.properties().StringMap[resources.ResourceNetwork]
+		unit := network.Unit
+		used := network.UsedUnits(resource.NewQuantity(bytes, resource.BinarySI).MilliValue())
 		if used == 0 {
 			continue
 		}
@@ -432,12 +1230,15 @@ func (r *MonitorReconciler) monitorPodTrafficUsed(namespace corev1.Namespace, st
 		ro := resources.Monitor{
 			Category: namespace.Name,
 			Name:     monitor.Name,
-			Used:     map[uint8]int64{r.Properties.StringMap[resources.ResourceNetwork].Enum: used},
-			Time:     endTime.Add(-1 * time.Minute),
+			Used:     map[uint8]int64{network.Enum: used},
+			Time:     trafficMonitorTime(endTime),
 			Type:     monitor.Type,
+			// the retry of the window replaces the traffic instead of counting it twice
+			IdempotencyKey: resources.TrafficMonitorKey(namespace.Name, monitor.Type, monitor.Name, endTime),
 		}
+		r.enrichMonitors(&namespace, []*resources.Monitor{&ro})
 		r.Logger.Info("monitor traffic used", "monitor", ro)
-		err = r.DBClient.InsertMonitor(context.Background(), &ro)
+		err = r.replaceMonitor(&ro)
 		if err != nil {
 			return fmt.Errorf("failed to insert monitor: %w", err)
 		}
@@ -447,23 +1248,60 @@ func (r *MonitorReconciler) monitorPodTrafficUsed(namespace corev1.Namespace, st
 
 func (r *MonitorReconciler) getGPUResourceUsage(pod corev1.Pod, gpuReq resource.Quantity, rs map[corev1.ResourceName]*quantity) (err error) {
 	nodeName := pod.Spec.NodeName
-	gpuModel, exist := r.NvidiaGpu[nodeName]
+	gpuModel, exist := r.gpuModel(nodeName)
 	if !exist {
-		if r.NvidiaGpu, err = gpu.GetNodeGpuModel(r.Client); err != nil {
-			return fmt.Errorf("get node gpu model failed: %w", err)
+		if err = r.refreshGpuModels(); err != nil {
+			return err
 		}
-		if gpuModel, exist = r.NvidiaGpu[nodeName]; !exist {
+		if gpuModel, exist = r.gpuModel(nodeName); !exist {
 			return fmt.Errorf("node %s not found gpu model", nodeName)
 		}
 	}
 	if _, ok := rs[resources.NewGpuResource(gpuModel.GpuInfo.GpuProduct)]; !ok {
 		rs[resources.NewGpuResource(gpuModel.GpuInfo.GpuProduct)] = initGpuResources()
 	}
-	logger.Info("gpu request", "pod", pod.Name, "namespace", pod.Namespace, "gpu req", gpuReq.String(), "node", nodeName, "gpu model", gpuModel.GpuInfo.GpuProduct)
-	rs[resources.NewGpuResource(gpuModel.GpuInfo.GpuProduct)].Add(gpuReq)
+	billed, replicas, source := r.billedGpu(gpuModel, gpuReq)
+	// the gpu shared by the pods of the node is reconciled by the GpuNodeAggregator
+	billed = r.gpuNodeWeights.weigh(nodeName, billed)
+	logger.Info("gpu request", "pod", pod.Name, "namespace", pod.Namespace, "gpu req", gpuReq.String(), "billed gpu", billed.String(),
+		"replicas", replicas, "replicas source", source, "node", nodeName, "gpu model", gpuModel.GpuInfo.GpuProduct)
+	rs[resources.NewGpuResource(gpuModel.GpuInfo.GpuProduct)].Add(billed)
 	return nil
 }
 
+// billedGpu returns the gpu billed for the request on the node with the replicas of the node and their source,
+// time-sliced gpu is billed by the physical gpu, eg: 4 replicas per gpu, 1 replica = 0.25 gpu
+func (r *MonitorReconciler) billedGpu(gpuModel gpu.NvidiaGPU, gpuReq resource.Quantity) (resource.Quantity, int64, string) {
+	replicas, source := r.getGpuReplicas(gpuModel)
+	if replicas > 1 {
+		return *resource.NewMilliQuantity(gpuReq.MilliValue()/replicas, resource.DecimalSI), replicas, source
+	}
+	return gpuReq, replicas, source
+}
+
+// getGpuReplicas returns the advertised-to-physical gpu ratio of the node and where it's read from:
+// the replicas label, else the gpus advertised by the device plugin divided by the physical gpu count label
+// of the gpu feature discovery, else 1.
+func (r *MonitorReconciler) getGpuReplicas(gpuModel gpu.NvidiaGPU) (int64, string) {
+	if value := gpuModel.Labels[r.GpuReplicasLabel]; value != "" {
+		replicas, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && replicas >= 1 {
+			return replicas, gpuReplicasFromLabel
+		}
+		r.Logger.Error(fmt.Errorf("invalid gpu replicas %q", value), "ignore the gpu replicas label", "label", r.GpuReplicasLabel)
+	}
+	count, err := strconv.ParseInt(gpuModel.GpuInfo.GpuCount, 10, 64)
+	if err != nil || count < 1 || gpuModel.Capacity <= count {
+		return 1, gpuReplicasDefault
+	}
+	// the device plugin advertises every physical gpu the same number of times
+	if gpuModel.Capacity%count != 0 {
+		r.Logger.Error(fmt.Errorf("gpu capacity %d is not a multiple of the gpu count %d", gpuModel.Capacity, count), "use default gpu replicas 1")
+		return 1, gpuReplicasDefault
+	}
+	return gpuModel.Capacity / count, gpuReplicasFromCapacity
+}
+
 func initResources() (rs map[corev1.ResourceName]*quantity) {
 	rs = make(map[corev1.ResourceName]*quantity)
 	rs[resources.ResourceGPU] = initGpuResources()
@@ -478,7 +1316,3 @@ func initResources() (rs map[corev1.ResourceName]*quantity) {
 func initGpuResources() *quantity {
 	return &quantity{Quantity: resource.NewQuantity(0, resource.DecimalSI), detail: ""}
 }
-
-func (r *MonitorReconciler) DropMonitorCollectionOlder() error {
-	return r.DBClient.DropMonitorCollectionsOlderThan(30)
-}
@@ -13,3 +13,559 @@
 // limitations under the License.
 
 package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	testingclock "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/gpu"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	userv1 "github.com/labring/sealos/controllers/user/api/v1"
+)
+
+func TestMonitorReconciler_getResourceUsed_NodePort(t *testing.T) {
+	nodePortType := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceServicesNodePorts.String()]
+	tests := []struct {
+		name      string
+		ratio     int64
+		nodePorts int
+		want      int64
+	}{
+		{name: "default ratio", ratio: 0, nodePorts: 2, want: 2 * resources.DefaultNodePortRatio},
+		{name: "custom ratio", ratio: 1, nodePorts: 3, want: 3},
+		{name: "no node port", ratio: 100, nodePorts: 0, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &MonitorReconciler{Logger: logr.Discard(), Properties: &resources.PropertyTypeLS{StringMap: map[string]resources.PropertyType{}}}
+			for name, pType := range resources.DefaultPropertyTypeLS.StringMap {
+				r.Properties.StringMap[name] = pType
+			}
+			nodePortType.Ratio = tt.ratio
+			r.Properties.StringMap[nodePortType.Name] = nodePortType
+			rs := initResources()
+			for i := 0; i < tt.nodePorts; i++ {
+				rs[corev1.ResourceServicesNodePorts].Add(r.nodePortQuantity())
+			}
+			isEmpty, used := r.getResourceUsed(rs)
+			if isEmpty != (tt.want == 0) {
+				t.Fatalf("getResourceUsed() isEmpty = %v, want %v", isEmpty, tt.want == 0)
+			}
+			if got := used[nodePortType.Enum]; got != tt.want {
+				t.Errorf("getResourceUsed() nodeport used = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeteringPolicy_quantity(t *testing.T) {
+	container := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+	}
+	onlyRequests := corev1.ResourceRequirements{Requests: container.Requests}
+	tests := []struct {
+		name      string
+		policy    MeteringPolicy
+		resources corev1.ResourceRequirements
+		wantCPU   string
+		wantMem   string
+	}{
+		{name: "limits", policy: MeteringPolicyLimits, resources: container, wantCPU: "500m", wantMem: "512Mi"},
+		{name: "requests", policy: MeteringPolicyRequests, resources: container, wantCPU: "100m", wantMem: "1Gi"},
+		{name: "max", policy: MeteringPolicyMax, resources: container, wantCPU: "500m", wantMem: "1Gi"},
+		{name: "limits fallback to requests", policy: MeteringPolicyLimits, resources: onlyRequests, wantCPU: "100m", wantMem: "1Gi"},
+		{name: "max without limits", policy: MeteringPolicyMax, resources: onlyRequests, wantCPU: "100m", wantMem: "1Gi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := tt.policy.quantity(tt.resources, corev1.ResourceCPU)
+			if want := resource.MustParse(tt.wantCPU); cpu.Cmp(want) != 0 {
+				t.Errorf("cpu quantity = %v, want %v", cpu.String(), tt.wantCPU)
+			}
+			mem := tt.policy.quantity(tt.resources, corev1.ResourceMemory)
+			if want := resource.MustParse(tt.wantMem); mem.Cmp(want) != 0 {
+				t.Errorf("memory quantity = %v, want %v", mem.String(), tt.wantMem)
+			}
+		})
+	}
+}
+
+func TestMonitorReconciler_monitorResourceUsage_PodOverhead(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	newPod := func(name string, phase corev1.PodPhase, startTime *metav1.Time) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: name, Labels: map[string]string{resources.AppLabelKey: "app-a"}},
+			Spec: corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{qosContainer(qosResources("1", "1Gi"), nil)},
+				Overhead: qosResources("250m", "160Mi")},
+			Status: corev1.PodStatus{Phase: phase, StartTime: startTime},
+		}
+	}
+	c := fake.NewClientBuilder().WithObjects(newPod("app-a-0", corev1.PodRunning, &started), newPod("app-a-1", corev1.PodPending, nil)).Build()
+	cpu := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()]
+	memory := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceMemory.String()]
+	units := func(property resources.PropertyType, value string) int64 {
+		q := resource.MustParse(value)
+		return property.UsedUnits(q.MilliValue())
+	}
+
+	// the pod not started is metered neither for the containers nor for the overhead
+	tests := []struct {
+		overhead   bool
+		wantCPU    int64
+		wantMemory int64
+	}{
+		{overhead: false, wantCPU: units(cpu, "1"), wantMemory: units(memory, "1Gi")},
+		{overhead: true, wantCPU: units(cpu, "1250m"), wantMemory: units(memory, "1184Mi")},
+	}
+	for _, tt := range tests {
+		db := databasetest.NewMemoryStore()
+		r := &MonitorReconciler{
+			Client:            c,
+			Logger:            logr.Discard(),
+			DBClient:          db,
+			Properties:        resources.DefaultPropertyTypeLS,
+			MeteringPolicy:    MeteringPolicyRequests,
+			GpuMeteringPolicy: GpuMeteringPolicyReservation,
+			MeterPodOverhead:  tt.overhead,
+		}
+		if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+			t.Fatalf("monitorResourceUsage() error = %v", err)
+		}
+		monitors := db.Monitors()
+		if len(monitors) != 1 {
+			t.Fatalf("monitors = %d, want 1", len(monitors))
+		}
+		if used := monitors[0].Used; used[cpu.Enum] != tt.wantCPU || used[memory.Enum] != tt.wantMemory {
+			t.Errorf("overhead %v used = %v, want cpu %d and memory %d", tt.overhead, used, tt.wantCPU, tt.wantMemory)
+		}
+	}
+}
+
+func TestParseMeteringPolicy(t *testing.T) {
+	if p, err := parseMeteringPolicy(""); err != nil || p != MeteringPolicyLimits {
+		t.Errorf("parseMeteringPolicy(\"\") = %v, %v, want %v", p, err, MeteringPolicyLimits)
+	}
+	if _, err := parseMeteringPolicy("average"); err == nil {
+		t.Errorf("parseMeteringPolicy(\"average\") expected error")
+	}
+}
+
+func TestGpuMeteringPolicy_metered(t *testing.T) {
+	gpuPod := func(nodeName string, phase corev1.PodPhase, startedAgo time.Duration) *corev1.Pod {
+		pod := &corev1.Pod{
+			Spec:   corev1.PodSpec{NodeName: nodeName},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+		if startedAgo > 0 {
+			pod.Status.StartTime = &metav1.Time{Time: time.Now().Add(-startedAgo)}
+		}
+		return pod
+	}
+	tests := []struct {
+		name            string
+		pod             *corev1.Pod
+		wantReservation bool
+		wantRunning     bool
+	}{
+		{name: "pending unschedulable", pod: gpuPod("", corev1.PodPending, 0), wantReservation: false, wantRunning: false},
+		{name: "pending bound to node", pod: gpuPod("gpu-node", corev1.PodPending, 0), wantReservation: true, wantRunning: false},
+		{name: "pending started recently", pod: gpuPod("gpu-node", corev1.PodPending, 30*time.Second), wantReservation: true, wantRunning: true},
+		{name: "pending for long", pod: gpuPod("gpu-node", corev1.PodPending, 10*time.Minute), wantReservation: true, wantRunning: false},
+		{name: "running", pod: gpuPod("gpu-node", corev1.PodRunning, 10*time.Minute), wantReservation: true, wantRunning: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GpuMeteringPolicyReservation.metered(tt.pod); got != tt.wantReservation {
+				t.Errorf("reservation metered() = %v, want %v", got, tt.wantReservation)
+			}
+			if got := GpuMeteringPolicyRunning.metered(tt.pod); got != tt.wantRunning {
+				t.Errorf("running metered() = %v, want %v", got, tt.wantRunning)
+			}
+		})
+	}
+}
+
+func TestParseGpuMeteringPolicy(t *testing.T) {
+	if p, err := parseGpuMeteringPolicy(""); err != nil || p != GpuMeteringPolicyReservation {
+		t.Errorf("parseGpuMeteringPolicy(\"\") = %v, %v, want %v", p, err, GpuMeteringPolicyReservation)
+	}
+	if _, err := parseGpuMeteringPolicy("scheduled"); err == nil {
+		t.Errorf("parseGpuMeteringPolicy(\"scheduled\") expected error")
+	}
+}
+
+func TestMonitorReconciler_namespaceUser(t *testing.T) {
+	const label = "user.sealos.io/owner"
+	tests := []struct {
+		name      string
+		userLabel string
+		namespace *corev1.Namespace
+		want      string
+	}{
+		{name: "name convention", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}, want: "user-a"},
+		{name: "label", userLabel: label, namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-billing", Labels: map[string]string{label: "user-b"}}}, want: "user-b"},
+		{name: "label not set falls back", userLabel: label, namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-c"}}, want: "user-c"},
+		{name: "label ignored if not configured", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-d", Labels: map[string]string{label: "user-b"}}}, want: "user-d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &MonitorReconciler{NamespaceUserLabel: tt.userLabel}
+			if got := r.namespaceUser(tt.namespace); got != tt.want {
+				t.Errorf("namespaceUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewNamespaceSelector(t *testing.T) {
+	tests := []struct {
+		name       string
+		selector   string
+		key, value string
+		labels     map[string]string
+		want       bool
+		wantErr    bool
+	}{
+		{name: "default key", labels: map[string]string{userv1.UserLabelOwnerKey: "user-a"}, want: true},
+		{name: "default key missing", labels: map[string]string{"tenant": "user-a"}, want: false},
+		{name: "custom key", key: "tenant", labels: map[string]string{"tenant": "user-a"}, want: true},
+		{name: "custom key and value", key: "tenant", value: "paid", labels: map[string]string{"tenant": "free"}, want: false},
+		{name: "selector overrides key", selector: "tier in (paid,trial),!system", key: "tenant", labels: map[string]string{"tier": "trial"}, want: true},
+		{name: "selector excludes", selector: "tier in (paid,trial),!system", labels: map[string]string{"tier": "paid", "system": ""}, want: false},
+		{name: "invalid selector", selector: "tier in (", wantErr: true},
+		{name: "invalid key", key: "-bad-", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := newNamespaceSelector(tt.selector, tt.key, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newNamespaceSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := selector.Matches(labels.Set(tt.labels)); got != tt.want {
+				t.Errorf("selector %q matches %v = %v, want %v", selector.String(), tt.labels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePromURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "", want: ""},
+		{raw: "http://prometheus:9090", want: "http://prometheus:9090"},
+		{raw: "http://prometheus:9090/", want: "http://prometheus:9090"},
+		{raw: " https://prom.example.com/prometheus// ", want: "https://prom.example.com/prometheus"},
+		{raw: "prometheus:9090", wantErr: true},
+		{raw: "prometheus.monitoring.svc", wantErr: true},
+		{raw: "ftp://prometheus:9090", wantErr: true},
+		{raw: "http://", wantErr: true},
+		{raw: "http://prometheus:9090/api/v1/query?query=up", wantErr: true},
+		{raw: "http://prom etheus:9090", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizePromURL(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizePromURL(%q) err = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizePromURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestMonitorReconciler_getGPUResourceUsage_TimeSlicing(t *testing.T) {
+	r := &MonitorReconciler{
+		Logger:           logr.Discard(),
+		GpuReplicasLabel: gpu.NvidiaGpuReplicasKey,
+		NvidiaGpu: map[string]gpu.NvidiaGPU{
+			"time-sliced": {
+				GpuInfo: gpu.Information{GpuProduct: "Tesla-T4"},
+				Labels:  map[string]string{gpu.NvidiaGpuReplicasKey: "4"},
+			},
+			"dedicated": {
+				GpuInfo: gpu.Information{GpuProduct: "Tesla-T4"},
+			},
+			// no replicas label, the device plugin advertises 8 replicas of each of the 2 physical gpus
+			"advertised": {
+				GpuInfo:  gpu.Information{GpuProduct: "Tesla-T4", GpuCount: "2"},
+				Capacity: 16,
+			},
+			"dedicated-advertised": {
+				GpuInfo:  gpu.Information{GpuProduct: "Tesla-T4", GpuCount: "2"},
+				Capacity: 2,
+			},
+		},
+	}
+	tests := []struct {
+		node      string
+		req       string
+		wantMilli int64
+	}{
+		{node: "time-sliced", req: "2", wantMilli: 500},
+		{node: "time-sliced", req: "4", wantMilli: 1000},
+		{node: "dedicated", req: "2", wantMilli: 2000},
+		{node: "advertised", req: "1", wantMilli: 125},
+		{node: "advertised", req: "8", wantMilli: 1000},
+		{node: "dedicated-advertised", req: "1", wantMilli: 1000},
+	}
+	for _, tt := range tests {
+		rs := initResources()
+		pod := corev1.Pod{Spec: corev1.PodSpec{NodeName: tt.node}}
+		if err := r.getGPUResourceUsage(pod, resource.MustParse(tt.req), rs); err != nil {
+			t.Fatalf("getGPUResourceUsage() error = %v", err)
+		}
+		if got := rs[resources.NewGpuResource("Tesla-T4")].MilliValue(); got != tt.wantMilli {
+			t.Errorf("node %s gpu %s: recorded milli gpu = %v, want %v", tt.node, tt.req, got, tt.wantMilli)
+		}
+	}
+}
+
+// poisonDB reports the failed monitors: the poison monitor always fails, the flaky monitor fails the first insert
+type poisonDB struct {
+	database.MonitorStore
+	flaky    int
+	inserted []string
+	tries    [][]string
+}
+
+func (db *poisonDB) InsertMonitorDetailed(_ context.Context, monitors ...*resources.Monitor) database.MonitorInsertResults {
+	results := make(database.MonitorInsertResults)
+	var names []string
+	for i, monitor := range monitors {
+		names = append(names, monitor.Name)
+		switch {
+		case monitor.Name == "app-poison":
+			results[i] = retry.Permanent(errors.New("document too large"))
+		case monitor.Name == "app-flaky" && db.flaky > 0:
+			db.flaky--
+			results[i] = retry.Transient(errors.New("write conflict"))
+		default:
+			db.inserted = append(db.inserted, monitor.Name)
+		}
+	}
+	db.tries = append(db.tries, names)
+	return results
+}
+
+func TestMonitorReconciler_insertMonitor_PartialFailure(t *testing.T) {
+	db := &poisonDB{flaky: 1}
+	r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, meteringValve: newMeteringValve(1)}
+	var monitors []*resources.Monitor
+	for _, name := range []string{"app-a", "app-poison", "app-flaky", "app-b"} {
+		monitors = append(monitors, &resources.Monitor{Category: "ns-a", Name: name})
+	}
+	err := r.insertMonitor(monitors...)
+	if !retry.IsPermanent(err) {
+		t.Errorf("insertMonitor() error = %v, want the poison monitor reported as permanent", err)
+	}
+	// only the flaky monitor is retried, the poison one is dropped
+	if len(db.tries) != 2 || len(db.tries[1]) != 1 || db.tries[1][0] != "app-flaky" {
+		t.Errorf("tries = %v, want the second try of app-flaky only", db.tries)
+	}
+	if len(db.inserted) != 3 {
+		t.Errorf("inserted = %v, want app-a, app-b and app-flaky", db.inserted)
+	}
+	// the database is healthy, the poison monitor doesn't pause the metering
+	if r.meteringValve.paused() {
+		t.Error("metering paused by a poison monitor")
+	}
+}
+
+// pagedPodReader serves the pods of a namespace page by page like the api server, applying the field selector
+type pagedPodReader struct {
+	client.Reader
+	pods  []corev1.Pod
+	calls []client.ListOptions
+}
+
+func (p *pagedPodReader) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	options := client.ListOptions{}
+	options.ApplyOptions(opts)
+	p.calls = append(p.calls, options)
+	var matched []corev1.Pod
+	for _, pod := range p.pods {
+		if pod.Namespace != options.Namespace {
+			continue
+		}
+		if options.FieldSelector != nil && !options.FieldSelector.Matches(fields.Set{"spec.nodeName": pod.Spec.NodeName}) {
+			continue
+		}
+		matched = append(matched, pod)
+	}
+	start := 0
+	if options.Continue != "" {
+		var err error
+		if start, err = strconv.Atoi(options.Continue); err != nil {
+			return err
+		}
+	}
+	end := len(matched)
+	podList := list.(*corev1.PodList)
+	if options.Limit > 0 && start+int(options.Limit) < end {
+		end = start + int(options.Limit)
+		podList.Continue = strconv.Itoa(end)
+	}
+	podList.Items = matched[start:end]
+	return nil
+}
+
+func TestMonitorReconciler_listPods_Paged(t *testing.T) {
+	var pods []corev1.Pod
+	for i := 0; i < 5; i++ {
+		pods = append(pods, corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-a", Name: fmt.Sprintf("app-%d", i)}, Spec: corev1.PodSpec{NodeName: "node-a"}})
+	}
+	pods = append(pods,
+		corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-a", Name: "pending"}},
+		corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-b", Name: "other"}, Spec: corev1.PodSpec{NodeName: "node-a"}},
+	)
+	for _, fromWatchCache := range []bool{false, true} {
+		reader := &pagedPodReader{pods: pods}
+		r := &MonitorReconciler{Logger: logr.Discard(), APIReader: reader, PodListPageSize: 2, PodListFromWatchCache: fromWatchCache}
+		got, err := r.listPods("ns-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, pod := range got {
+			names = append(names, pod.Name)
+		}
+		// every page is collected, the unscheduled pod is not listed
+		if want := []string{"app-0", "app-1", "app-2", "app-3", "app-4"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("watch cache %v: listed pods = %v, want %v", fromWatchCache, names, want)
+		}
+		if len(reader.calls) != 3 {
+			t.Fatalf("watch cache %v: listed %d pages, want 3", fromWatchCache, len(reader.calls))
+		}
+		for i, call := range reader.calls {
+			if call.Namespace != "ns-a" || call.Limit != 2 || call.FieldSelector.String() != "spec.nodeName!=" {
+				t.Errorf("page %d options = %+v, want ns-a by 2 with the field selector spec.nodeName!=", i, call)
+			}
+			if wantContinue := []string{"", "2", "4"}[i]; call.Continue != wantContinue {
+				t.Errorf("page %d continue = %q, want %q", i, call.Continue, wantContinue)
+			}
+			resourceVersion := ""
+			if call.Raw != nil {
+				resourceVersion = call.Raw.ResourceVersion
+			}
+			if wantVersion := map[bool]string{true: "0"}[fromWatchCache]; resourceVersion != wantVersion {
+				t.Errorf("watch cache %v: page %d resource version = %q, want %q", fromWatchCache, i, resourceVersion, wantVersion)
+			}
+		}
+	}
+}
+
+// passClient reports the time of the clock each time a pass lists the namespaces
+type passClient struct {
+	client.WithWatch
+	clock  *testingclock.FakeClock
+	passes chan time.Time
+}
+
+func (c *passClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, ok := list.(*corev1.NamespaceList); ok {
+		c.passes <- c.clock.Now()
+	}
+	return c.WithWatch.List(ctx, list, opts...)
+}
+
+func TestMonitorReconciler_startPeriodicReconcile_SkipInitialAlignment(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC)
+	tests := []struct {
+		name                 string
+		skipInitialAlignment bool
+		want                 []time.Time
+	}{
+		{name: "aligned", want: []time.Time{start.Add(90 * time.Second), start.Add(150 * time.Second)}},
+		// the first pass runs immediately, the next ones are still at the minute
+		{name: "skip initial alignment", skipInitialAlignment: true, want: []time.Time{start, start.Add(90 * time.Second), start.Add(150 * time.Second)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClock := testingclock.NewFakeClock(start)
+			c := &passClient{WithWatch: fake.NewClientBuilder().Build(), clock: fakeClock, passes: make(chan time.Time, 10)}
+			r := &MonitorReconciler{
+				Client:               c,
+				Logger:               logr.Discard(),
+				DBClient:             databasetest.NewMemoryStore(),
+				Properties:           resources.DefaultPropertyTypeLS,
+				stopCh:               make(chan struct{}),
+				periodicReconcile:    time.Minute,
+				reconcileClock:       fakeClock,
+				SkipInitialAlignment: tt.skipInitialAlignment,
+				tenants:              newTenantTracker(),
+			}
+			r.startPeriodicReconcile()
+			defer func() {
+				close(r.stopCh)
+				r.wg.Wait()
+			}()
+
+			var got []time.Time
+			receive := func() {
+				select {
+				case at := <-c.passes:
+					got = append(got, at)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("passes = %v, want %v", got, tt.want)
+				}
+			}
+			// waitForWaiter waits until the reconcile waits for the clock, by the next minute or the ticker
+			waitForWaiter := func() {
+				deadline := time.Now().Add(5 * time.Second)
+				for !fakeClock.HasWaiters() {
+					if time.Now().After(deadline) {
+						t.Fatal("the reconcile doesn't wait for the clock")
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}
+			if tt.skipInitialAlignment {
+				receive()
+			}
+			// the next minute starts the ticker, the first aligned pass is at the tick
+			waitForWaiter()
+			fakeClock.Step(30 * time.Second)
+			for len(got) < len(tt.want) {
+				waitForWaiter()
+				fakeClock.Step(time.Minute)
+				receive()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("passes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/database/clickhouse"
+	"github.com/labring/sealos/controllers/pkg/database/mongo"
+	"github.com/labring/sealos/controllers/pkg/database/postgres"
+	"github.com/labring/sealos/controllers/pkg/database/stdout"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const defaultMonitorSchemaMigrationTimeout = 30 * time.Minute
+
+// NewMonitorDBClient connects to the monitor storage selected by MONITOR_DB_DRIVER, mongo by default,
+// the former MONITOR_DATABASE and POSTGRES_URI are still read if the new envs are not set.
+func NewMonitorDBClient(ctx context.Context) (database.MonitorStore, error) {
+	driver := env.GetEnvWithDefault(database.MonitorDBDriver, env.GetEnvWithDefault(database.MonitorDatabase, database.MonitorDatabaseMongo))
+	dsn := os.Getenv(database.MongoURI)
+	if driver != database.MonitorDatabaseMongo {
+		dsn = env.GetEnvWithDefault(database.MonitorDBDSN, os.Getenv(database.PostgresURI))
+	}
+	return connectMonitorDB(ctx, database.MonitorDBDriver, driver, dsn)
+}
+
+// NewMonitorSecondaryDBClient connects to the secondary monitor storage of the dual write, nil if MONITOR_SECONDARY_DB_DRIVER is not set
+func NewMonitorSecondaryDBClient(ctx context.Context) (database.MonitorStore, error) {
+	driver := os.Getenv(database.MonitorSecondaryDBDriver)
+	if driver == "" {
+		return nil, nil
+	}
+	return connectMonitorDB(ctx, database.MonitorSecondaryDBDriver, driver, os.Getenv(database.MonitorSecondaryDBDSN))
+}
+
+// MigrateMonitorDBSchema migrates the schema of the monitor collections of the primary and the secondary storage, the
+// migrations of the controllers started together run once under the lock of the pod
+func MigrateMonitorDBSchema(primary, secondary database.MonitorStore, logger logr.Logger) error {
+	owner, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get the hostname as the owner of the schema lock: %w", err)
+	}
+	timeout, err := env.GetDurationEnvWithDefault(database.MonitorSchemaMigrationTimeout, defaultMonitorSchemaMigrationTimeout)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for i, store := range []database.MonitorStore{primary, secondary} {
+		if store == nil {
+			continue
+		}
+		name := []string{"primary", "secondary"}[i]
+		applied, err := database.MigrateMonitorSchema(ctx, store, owner)
+		if err != nil {
+			return fmt.Errorf("failed to migrate the schema of the %s monitor storage: %w", name, err)
+		}
+		if applied > 0 {
+			logger.Info("migrated the monitor schema", "storage", name, "migrations", applied)
+		}
+	}
+	return nil
+}
+
+func connectMonitorDB(ctx context.Context, driverEnv, driver, dsn string) (database.MonitorStore, error) {
+	switch driver {
+	case database.MonitorDatabaseMongo:
+		return mongo.NewMongoInterface(ctx, dsn)
+	case database.MonitorDatabasePostgres:
+		return postgres.NewPostgresInterface(ctx, dsn, env.GetBoolEnvWithDefault(database.PostgresTimescaleDB, false))
+	case database.MonitorDatabaseClickHouse:
+		return clickhouse.NewClickHouseInterface(ctx, clickhouse.Config{
+			DSN:             dsn,
+			CAFile:          os.Getenv(database.ClickHouseCAFile),
+			InsertBatchSize: int(env.GetInt64EnvWithDefault(database.ClickHouseInsertBatchSize, clickhouse.DefaultInsertBatchSize)),
+		})
+	case database.MonitorDatabaseStdout:
+		return stdout.NewStdoutInterface(dsn)
+	default:
+		return nil, fmt.Errorf("invalid %s %q, must be one of: %s, %s, %s, %s", driverEnv, driver,
+			database.MonitorDatabaseMongo, database.MonitorDatabasePostgres, database.MonitorDatabaseClickHouse, database.MonitorDatabaseStdout)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// MonitorDBHealthInterval the interval of pinging the monitor database, default 10s, 0 disables the health check
+	MonitorDBHealthInterval = "MONITOR_DB_HEALTH_INTERVAL"
+	// MonitorDBHealthTimeout the timeout of a ping, default 3s
+	MonitorDBHealthTimeout = "MONITOR_DB_HEALTH_TIMEOUT"
+	// MonitorDBReconnectThreshold reconnects the monitor database after the consecutive failed pings, default 3
+	MonitorDBReconnectThreshold = "MONITOR_DB_RECONNECT_THRESHOLD"
+
+	DefaultMonitorDBHealthInterval     = 10 * time.Second
+	DefaultMonitorDBHealthTimeout      = 3 * time.Second
+	DefaultMonitorDBReconnectThreshold = 3
+)
+
+var (
+	monitorDBUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sealos_resources_monitor_db_up",
+		Help: "1 if the last ping of the monitor database succeeded, otherwise 0.",
+	})
+	monitorDBReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_db_reconnects_total",
+		Help: "Number of the reconnections of the monitor database after the consecutive failed pings by the result.",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(monitorDBUp, monitorDBReconnects)
+}
+
+// monitorDBReconnector replaces the connection of the monitor database, eg: database.ReconnectingStore
+type monitorDBReconnector interface {
+	Reconnect(ctx context.Context) error
+}
+
+// monitorDBHealth pings the monitor database every interval, so a dead connection (eg: to the primary before a
+// failover) is noticed before the inserts of the next cycle fail. After threshold consecutive failed pings the store
+// is reconnected if it supports it, the next attempt waits for threshold more failures.
+// The methods of a nil health check report the database up.
+type monitorDBHealth struct {
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+
+	mu       sync.Mutex
+	failures int
+	lastErr  error
+}
+
+// newMonitorDBHealthFromEnv returns nil if the interval is 0
+func newMonitorDBHealthFromEnv() (*monitorDBHealth, error) {
+	interval, err := env.GetDurationEnvWithDefault(MonitorDBHealthInterval, DefaultMonitorDBHealthInterval)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := env.GetDurationEnvWithDefault(MonitorDBHealthTimeout, DefaultMonitorDBHealthTimeout)
+	if err != nil {
+		return nil, err
+	}
+	threshold := env.GetInt64EnvWithDefault(MonitorDBReconnectThreshold, DefaultMonitorDBReconnectThreshold)
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid %s %s: must be >= 0", MonitorDBHealthInterval, interval)
+	}
+	if interval == 0 {
+		return nil, nil
+	}
+	if timeout <= 0 || timeout > interval {
+		return nil, fmt.Errorf("invalid %s %s: must be in (0, %s]", MonitorDBHealthTimeout, timeout, interval)
+	}
+	if threshold < 1 {
+		return nil, fmt.Errorf("invalid %s %d: must be >= 1", MonitorDBReconnectThreshold, threshold)
+	}
+	return &monitorDBHealth{interval: interval, timeout: timeout, threshold: int(threshold)}, nil
+}
+
+// check pings the store and reconnects it once the pings failed threshold times in a row.
+// The stores without database.Pinger are always up.
+func (h *monitorDBHealth) check(store database.MonitorStore, logger logr.Logger) {
+	pinger, ok := store.(database.Pinger)
+	if !ok {
+		monitorDBUp.Set(1)
+		return
+	}
+	err := h.ping(pinger)
+	if err == nil {
+		return
+	}
+	if !h.reconnectDue() {
+		logger.Error(err, "failed to ping the monitor database")
+		return
+	}
+	reconnector, ok := store.(monitorDBReconnector)
+	if !ok {
+		logger.Error(err, "failed to ping the monitor database, the store doesn't support reconnecting")
+		return
+	}
+	logger.Error(err, "failed to ping the monitor database, reconnecting", "failures", h.threshold)
+	ctx, cancel := context.WithTimeout(context.Background(), h.interval)
+	defer cancel()
+	if err := reconnector.Reconnect(ctx); err != nil {
+		monitorDBReconnects.WithLabelValues("failure").Inc()
+		logger.Error(err, "failed to reconnect the monitor database")
+		return
+	}
+	monitorDBReconnects.WithLabelValues("success").Inc()
+	logger.Info("reconnected the monitor database")
+	// the state is reported by the new connection rather than waiting for the next interval
+	if err := h.ping(pinger); err != nil {
+		logger.Error(err, "failed to ping the reconnected monitor database")
+	}
+}
+
+// ping records the result of a ping with the timeout
+func (h *monitorDBHealth) ping(pinger database.Pinger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	err := pinger.Ping(ctx)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastErr = err
+	if err != nil {
+		h.failures++
+		monitorDBUp.Set(0)
+	} else {
+		h.failures = 0
+		monitorDBUp.Set(1)
+	}
+	return err
+}
+
+// reconnectDue returns true once the failures reach the threshold, and starts counting again
+func (h *monitorDBHealth) reconnectDue() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failures < h.threshold {
+		return false
+	}
+	h.failures = 0
+	return true
+}
+
+// ReadyzCheck fails while the last ping of the monitor database failed
+func (h *monitorDBHealth) ReadyzCheck(_ *http.Request) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastErr != nil {
+		return fmt.Errorf("monitor database is unavailable: %w", h.lastErr)
+	}
+	return nil
+}
+
+func (r *MonitorReconciler) startMonitorDBHealth() {
+	// the store was pinged when it connected
+	monitorDBUp.Set(1)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.monitorDBHealth.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.monitorDBHealth.check(r.DBClient, r.Logger)
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// MonitorDBReadyzCheck fails while the monitor database doesn't answer the pings of the health check
+func (r *MonitorReconciler) MonitorDBReadyzCheck(req *http.Request) error {
+	return r.monitorDBHealth.ReadyzCheck(req)
+}
@@ -0,0 +1,152 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+)
+
+// listenerStore a monitor store connected to a listener, the pings dial it so closing the listener kills the connection
+type listenerStore struct {
+	*databasetest.MemoryStore
+	addr string
+}
+
+func (s *listenerStore) Ping(ctx context.Context) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	return listener
+}
+
+func TestMonitorDBHealth_failover(t *testing.T) {
+	primary := listen(t)
+	// the address of the server the next connect reaches, eg: the primary elected by the failover
+	addr := primary.Addr().String()
+	var conns []*listenerStore
+	store, err := database.NewReconnectingStore(context.Background(), func(ctx context.Context) (database.MonitorStore, error) {
+		conn := &listenerStore{MemoryStore: databasetest.NewMemoryStore(), addr: addr}
+		if err := conn.Ping(ctx); err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+		return conn, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &monitorDBHealth{interval: time.Second, timeout: 200 * time.Millisecond, threshold: 2}
+	h.check(store, logr.Discard())
+	if err := h.ReadyzCheck(nil); err != nil || testutil.ToFloat64(monitorDBUp) != 1 {
+		t.Fatalf("ReadyzCheck() = %v, up = %v, want ready and up", err, testutil.ToFloat64(monitorDBUp))
+	}
+
+	// the primary goes down and no server is reachable yet, the connect fails
+	_ = primary.Close()
+	reconnected := testutil.ToFloat64(monitorDBReconnects.WithLabelValues("success"))
+	failed := testutil.ToFloat64(monitorDBReconnects.WithLabelValues("failure"))
+	h.check(store, logr.Discard())
+	if err := h.ReadyzCheck(nil); err == nil || testutil.ToFloat64(monitorDBUp) != 0 {
+		t.Fatalf("ReadyzCheck() = %v, up = %v, want not ready and down", err, testutil.ToFloat64(monitorDBUp))
+	}
+	if len(conns) != 1 {
+		t.Fatal("reconnected before the threshold")
+	}
+	h.check(store, logr.Discard())
+	if got := testutil.ToFloat64(monitorDBReconnects.WithLabelValues("failure")) - failed; got != 1 || len(conns) != 1 {
+		t.Fatalf("failed reconnects = %v, connections = %d, want 1, 1", got, len(conns))
+	}
+
+	// the new primary is elected, the store reconnects after threshold more failures
+	addr = listen(t).Addr().String()
+	h.check(store, logr.Discard())
+	if len(conns) != 1 {
+		t.Fatal("reconnected before the threshold")
+	}
+	h.check(store, logr.Discard())
+	if got := testutil.ToFloat64(monitorDBReconnects.WithLabelValues("success")) - reconnected; got != 1 || len(conns) != 2 {
+		t.Fatalf("reconnects = %v, connections = %d, want 1, 2", got, len(conns))
+	}
+	if err := h.ReadyzCheck(nil); err != nil || testutil.ToFloat64(monitorDBUp) != 1 {
+		t.Errorf("ReadyzCheck() = %v, up = %v after the reconnect, want ready and up", err, testutil.ToFloat64(monitorDBUp))
+	}
+	// the connection to the old primary is closed in the background
+	deadline := time.Now().Add(time.Second)
+	for conns[0].Calls("Disconnect") == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conns[0].Calls("Disconnect") == 0 {
+		t.Error("the connection to the old primary is not disconnected")
+	}
+}
+
+func TestMonitorDBHealth_storeWithoutPinger(t *testing.T) {
+	var h *monitorDBHealth
+	if err := h.ReadyzCheck(nil); err != nil {
+		t.Errorf("ReadyzCheck() of the disabled health check = %v, want nil", err)
+	}
+	h = &monitorDBHealth{interval: time.Second, timeout: time.Second, threshold: 1}
+	// the stores without Ping are always up
+	h.check(struct{ database.MonitorStore }{}, logr.Discard())
+	if err := h.ReadyzCheck(nil); err != nil || testutil.ToFloat64(monitorDBUp) != 1 {
+		t.Errorf("ReadyzCheck() = %v, up = %v, want ready and up", err, testutil.ToFloat64(monitorDBUp))
+	}
+}
+
+func TestNewMonitorDBHealthFromEnv(t *testing.T) {
+	h, err := newMonitorDBHealthFromEnv()
+	if err != nil || h == nil || h.interval != DefaultMonitorDBHealthInterval || h.timeout != DefaultMonitorDBHealthTimeout ||
+		h.threshold != DefaultMonitorDBReconnectThreshold {
+		t.Fatalf("newMonitorDBHealthFromEnv() = %+v, %v, want the defaults", h, err)
+	}
+	t.Setenv(MonitorDBHealthInterval, "0")
+	if h, err := newMonitorDBHealthFromEnv(); err != nil || h != nil {
+		t.Errorf("newMonitorDBHealthFromEnv() = %+v, %v, want disabled", h, err)
+	}
+	t.Setenv(MonitorDBHealthInterval, "5")
+	if _, err := newMonitorDBHealthFromEnv(); err == nil {
+		t.Error("newMonitorDBHealthFromEnv() with the interval without a unit expected error")
+	}
+	t.Setenv(MonitorDBHealthInterval, "5s")
+	t.Setenv(MonitorDBHealthTimeout, "10s")
+	if _, err := newMonitorDBHealthFromEnv(); err == nil {
+		t.Error("newMonitorDBHealthFromEnv() with the timeout longer than the interval expected error")
+	}
+	t.Setenv(MonitorDBHealthTimeout, "1s")
+	t.Setenv(MonitorDBReconnectThreshold, "0")
+	if _, err := newMonitorDBHealthFromEnv(); err == nil {
+		t.Error("newMonitorDBHealthFromEnv() with the threshold 0 expected error")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// MonitorTenantMetadata comma separated field=key pairs of the namespace labels or annotations copied to the monitor tenant,
+// eg: region=sealos.io/region,accountID=sealos.io/account-id,plan=sealos.io/plan
+const MonitorTenantMetadata = "MONITOR_TENANT_METADATA"
+
+// MonitorNamespaceLabels comma separated keys of the namespace labels copied to the monitor labels, eg: cost-center,team
+const MonitorNamespaceLabels = "MONITOR_NAMESPACE_LABELS"
+
+// MonitorEnricher attaches the tenant metadata or the labels to the monitors of the namespace before they are inserted
+type MonitorEnricher func(namespace *corev1.Namespace, monitors []*resources.Monitor)
+
+// parseTenantMetadata parses the field=key pairs, the field is the key in the monitor tenant
+func parseTenantMetadata(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for _, pair := range splitList(s) {
+		field, key, ok := strings.Cut(pair, "=")
+		field, key = strings.TrimSpace(field), strings.TrimSpace(key)
+		if !ok || field == "" || key == "" {
+			return nil, fmt.Errorf("invalid %s %q, must be field=key pairs, eg: region=sealos.io/region", MonitorTenantMetadata, pair)
+		}
+		if _, ok := fields[field]; ok {
+			return nil, fmt.Errorf("invalid %s, duplicate field %q", MonitorTenantMetadata, field)
+		}
+		fields[field] = key
+	}
+	return fields, nil
+}
+
+// newNamespaceMetadataEnricher copies the namespace labels, or the annotations if the label is not set, to the monitor tenant.
+// The keys not set on the namespace are omitted, the monitors of a namespace share the same tenant map.
+func newNamespaceMetadataEnricher(fields map[string]string) MonitorEnricher {
+	return func(namespace *corev1.Namespace, monitors []*resources.Monitor) {
+		tenant := make(map[string]string, len(fields))
+		for field, key := range fields {
+			if v := namespace.Labels[key]; v != "" {
+				tenant[field] = v
+			} else if v = namespace.Annotations[key]; v != "" {
+				tenant[field] = v
+			}
+		}
+		if len(tenant) == 0 {
+			return
+		}
+		for _, monitor := range monitors {
+			if monitor.Tenant == nil {
+				monitor.Tenant = tenant
+				continue
+			}
+			for k, v := range tenant {
+				monitor.Tenant[k] = v
+			}
+		}
+	}
+}
+
+// newNamespaceLabelsEnricher copies the namespace labels of the keys to the monitor labels. The labels are resolved
+// once per namespace, the keys not set on the namespace are omitted and the monitors of a namespace share the same map.
+func newNamespaceLabelsEnricher(keys []string) MonitorEnricher {
+	return func(namespace *corev1.Namespace, monitors []*resources.Monitor) {
+		labels := make(map[string]string, len(keys))
+		for _, key := range keys {
+			if v, ok := namespace.Labels[key]; ok {
+				labels[key] = v
+			}
+		}
+		if len(labels) == 0 {
+			return
+		}
+		for _, monitor := range monitors {
+			monitor.Labels = labels
+		}
+	}
+}
+
+// newMonitorEnrichersFromEnv returns the namespace metadata enricher if the tenant metadata is configured,
+// and the namespace labels enricher if the label keys are configured
+func newMonitorEnrichersFromEnv() ([]MonitorEnricher, error) {
+	fields, err := parseTenantMetadata(os.Getenv(MonitorTenantMetadata))
+	if err != nil {
+		return nil, err
+	}
+	var enrichers []MonitorEnricher
+	if len(fields) > 0 {
+		enrichers = append(enrichers, newNamespaceMetadataEnricher(fields))
+	}
+	if keys := splitList(os.Getenv(MonitorNamespaceLabels)); len(keys) > 0 {
+		enrichers = append(enrichers, newNamespaceLabelsEnricher(keys))
+	}
+	return enrichers, nil
+}
+
+func (r *MonitorReconciler) enrichMonitors(namespace *corev1.Namespace, monitors []*resources.Monitor) {
+	for _, enrich := range r.MonitorEnrichers {
+		enrich(namespace, monitors)
+	}
+}
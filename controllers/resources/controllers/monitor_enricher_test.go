@@ -0,0 +1,147 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseTenantMetadata(t *testing.T) {
+	fields, err := parseTenantMetadata(" region=sealos.io/region, accountID = sealos.io/account-id,,plan=sealos.io/plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"region": "sealos.io/region", "accountID": "sealos.io/account-id", "plan": "sealos.io/plan"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("parseTenantMetadata() = %v, want %v", fields, want)
+	}
+	for _, s := range []string{"region", "=sealos.io/region", "region=", "plan=a,plan=b"} {
+		if _, err := parseTenantMetadata(s); err == nil {
+			t.Errorf("parseTenantMetadata(%q) expected error", s)
+		}
+	}
+	if fields, err := parseTenantMetadata(""); err != nil || len(fields) != 0 {
+		t.Errorf("parseTenantMetadata(\"\") = %v, %v, want empty", fields, err)
+	}
+}
+
+func TestNamespaceMetadataEnricher(t *testing.T) {
+	enrich := newNamespaceMetadataEnricher(map[string]string{
+		"region":    "sealos.io/region",
+		"accountID": "sealos.io/account-id",
+		"plan":      "sealos.io/plan",
+	})
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "ns-user-a",
+		Labels:      map[string]string{"sealos.io/region": "us-east-1", "sealos.io/plan": "pro"},
+		Annotations: map[string]string{"sealos.io/account-id": "acc-1", "sealos.io/plan": "free"},
+	}}
+	monitors := []*resources.Monitor{{Name: "app"}, {Name: "bucket", Tenant: map[string]string{"source": "custom"}}}
+	enrich(namespace, monitors)
+	// the label wins over the annotation
+	want := map[string]string{"region": "us-east-1", "accountID": "acc-1", "plan": "pro"}
+	if !reflect.DeepEqual(monitors[0].Tenant, want) {
+		t.Errorf("tenant = %v, want %v", monitors[0].Tenant, want)
+	}
+	if monitors[1].Tenant["source"] != "custom" || monitors[1].Tenant["plan"] != "pro" {
+		t.Errorf("tenant = %v, want merged into the existing tenant", monitors[1].Tenant)
+	}
+
+	bare := []*resources.Monitor{{Name: "app"}}
+	enrich(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-b"}}, bare)
+	if bare[0].Tenant != nil {
+		t.Errorf("tenant = %v of the namespace without metadata, want nil", bare[0].Tenant)
+	}
+}
+
+func TestMonitorReconciler_enrichMonitors(t *testing.T) {
+	var calls []string
+	r := &MonitorReconciler{MonitorEnrichers: []MonitorEnricher{
+		func(namespace *corev1.Namespace, monitors []*resources.Monitor) {
+			calls = append(calls, "first")
+			monitors[0].Tenant = map[string]string{"accountID": namespace.Name}
+		},
+		func(_ *corev1.Namespace, monitors []*resources.Monitor) {
+			calls = append(calls, "second")
+			monitors[0].Tenant["plan"] = "pro"
+		},
+	}}
+	monitors := []*resources.Monitor{{Name: "app"}}
+	r.enrichMonitors(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}, monitors)
+	if !reflect.DeepEqual(calls, []string{"first", "second"}) || monitors[0].Tenant["accountID"] != "ns-user-a" || monitors[0].Tenant["plan"] != "pro" {
+		t.Errorf("enrichMonitors() calls %v, tenant %v", calls, monitors[0].Tenant)
+	}
+	// no enricher configured
+	(&MonitorReconciler{}).enrichMonitors(&corev1.Namespace{}, monitors)
+}
+
+func TestMonitorReconciler_monitorResourceUsage_NamespaceLabels(t *testing.T) {
+	t.Setenv(MonitorNamespaceLabels, "cost-center, team,owner")
+	enrichers, err := newMonitorEnrichersFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	newPod := func(name, app string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: name, Labels: map[string]string{resources.AppLabelKey: app}},
+			Spec:       corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{qosContainer(qosResources("1", "1Gi"), nil)}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		}
+	}
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{
+		Client:            fake.NewClientBuilder().WithObjects(newPod("app-a-0", "app-a"), newPod("app-b-0", "app-b")).Build(),
+		Logger:            logr.Discard(),
+		DBClient:          db,
+		Properties:        resources.DefaultPropertyTypeLS,
+		MeteringPolicy:    MeteringPolicyRequests,
+		GpuMeteringPolicy: GpuMeteringPolicyReservation,
+		MonitorEnrichers:  enrichers,
+	}
+	// owner is not set on the namespace and omitted, the other labels are not copied
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a", Labels: map[string]string{
+		"cost-center": "cc-42", "team": "infra", "kubernetes.io/metadata.name": "ns-user-a",
+	}}}
+	if err := r.monitorResourceUsage(namespace); err != nil {
+		t.Fatalf("monitorResourceUsage() error = %v", err)
+	}
+	monitors := db.Monitors()
+	if len(monitors) != 2 {
+		t.Fatalf("monitors = %d, want 2", len(monitors))
+	}
+	want := map[string]string{"cost-center": "cc-42", "team": "infra"}
+	for _, monitor := range monitors {
+		if !reflect.DeepEqual(monitor.Labels, want) {
+			t.Errorf("monitor %s labels = %v, want %v", monitor.Name, monitor.Labels, want)
+		}
+	}
+
+	bare := []*resources.Monitor{{Name: "app"}}
+	r.enrichMonitors(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-b"}}, bare)
+	if bare[0].Labels != nil {
+		t.Errorf("labels = %v of the namespace without the labels, want nil", bare[0].Labels)
+	}
+}
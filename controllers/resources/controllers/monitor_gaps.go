@@ -0,0 +1,307 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// MonitorGapDetection checks periodically that the namespaces have the monitors of every minute metered
+	MonitorGapDetection = "MONITOR_GAP_DETECTION"
+	// MonitorGapWindow the trailing window checked by each run, default 6h
+	MonitorGapWindow = "MONITOR_GAP_WINDOW"
+	// MonitorGapInterval the interval of the runs, default 1h
+	MonitorGapInterval = "MONITOR_GAP_INTERVAL"
+	// MonitorGapSample the namespaces checked by each run picked at random, 0 (default) checks all
+	MonitorGapSample = "MONITOR_GAP_SAMPLE"
+	// MonitorGapWebhook the url the reports with gaps are posted to as json, none if empty
+	MonitorGapWebhook = "MONITOR_GAP_WEBHOOK"
+
+	DefaultMonitorGapWindow   = 6 * time.Hour
+	DefaultMonitorGapInterval = time.Hour
+
+	// monitorGapSettleDelay the latest minutes not checked, their monitors may still be queued or inserted
+	monitorGapSettleDelay    = 5 * time.Minute
+	monitorGapWebhookTimeout = 10 * time.Second
+)
+
+var (
+	monitorMissingMinutes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_missing_minutes_total",
+		Help: "Number of the metered minutes of the namespaces found without monitors by the gap detection.",
+	})
+	monitorGapDetectionRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_gap_detection_runs_total",
+		Help: "Number of the monitor gap detection runs by the result.",
+	}, []string{"result"})
+	monitorGapNamespaces = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sealos_resources_monitor_gap_namespaces",
+		Help: "Number of the namespaces with gaps found by the latest gap detection run.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(monitorMissingMinutes, monitorGapDetectionRuns, monitorGapNamespaces)
+}
+
+// monitorGapDetection checks the monitors of the namespaces in the trailing window once per interval. The windows of
+// the runs overlap, so a gap is reported by each run until it leaves the window.
+type monitorGapDetection struct {
+	window   time.Duration
+	interval time.Duration
+	sample   int
+	webhook  string
+	client   *http.Client
+	clock    clock.Clock
+	// elected is closed when the replica becomes the leader, only the leader checks the monitors
+	elected <-chan struct{}
+}
+
+// newMonitorGapDetectionFromEnv returns nil if the detection is disabled.
+// The window must be newer than the rollup age, or the minute monitors are rolled up into hours and found missing.
+func newMonitorGapDetectionFromEnv(elected <-chan struct{}, rollupAge time.Duration) (*monitorGapDetection, error) {
+	if !env.GetBoolEnvWithDefault(MonitorGapDetection, false) {
+		return nil, nil
+	}
+	window, err := env.GetDurationEnvWithDefault(MonitorGapWindow, DefaultMonitorGapWindow)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := env.GetDurationEnvWithDefault(MonitorGapInterval, DefaultMonitorGapInterval)
+	if err != nil {
+		return nil, err
+	}
+	d := &monitorGapDetection{
+		window:   window,
+		interval: interval,
+		sample:   int(env.GetInt64EnvWithDefault(MonitorGapSample, 0)),
+		webhook:  os.Getenv(MonitorGapWebhook),
+		client:   &http.Client{Timeout: monitorGapWebhookTimeout},
+		clock:    clock.RealClock{},
+		elected:  elected,
+	}
+	if d.window < time.Minute {
+		return nil, fmt.Errorf("invalid %s %s: must be at least 1m", MonitorGapWindow, d.window)
+	}
+	if rollupAge > 0 && d.window+monitorGapSettleDelay >= rollupAge {
+		return nil, fmt.Errorf("invalid %s %s: the window must be newer than %s %s", MonitorGapWindow, d.window, MonitorRollupAge, rollupAge)
+	}
+	if d.interval < time.Minute {
+		return nil, fmt.Errorf("invalid %s %s: must be at least 1m", MonitorGapInterval, d.interval)
+	}
+	if d.sample < 0 {
+		return nil, fmt.Errorf("invalid %s %d: must not be negative", MonitorGapSample, d.sample)
+	}
+	if d.webhook != "" {
+		if u, err := url.Parse(d.webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid %s %q: must be an http or https url", MonitorGapWebhook, d.webhook)
+		}
+	}
+	return d, nil
+}
+
+// monitorGaps returns the gaps of the minutes of [start, end) metered by the policy without a monitor, and the missing
+// minutes, a monitor of the policy interval stands for its minutes. A namespace without any monitor in the window has
+// nothing metered, eg: it has no running pods, and has no gaps.
+func monitorGaps(present map[time.Time]bool, start, end time.Time, policy *namespacePolicy) ([]database.MonitorGap, int64) {
+	if len(present) == 0 {
+		return nil, 0
+	}
+	minutes := int64(1)
+	if policy != nil {
+		minutes = policy.minutes
+	}
+	var (
+		gaps    []database.MonitorGap
+		missing int64
+	)
+	for t := start; t.Before(end); t = t.Add(time.Minute) {
+		if !policy.meters(t) || present[t] {
+			continue
+		}
+		missing += minutes
+		gapEnd := t.Add(time.Duration(minutes) * time.Minute)
+		if gapEnd.After(end) {
+			gapEnd = end
+		}
+		// the gaps of the consecutive metered minutes are merged
+		if n := len(gaps); n > 0 && gaps[n-1].End.Equal(t) {
+			gaps[n-1].End = gapEnd
+			continue
+		}
+		gaps = append(gaps, database.MonitorGap{Start: t, End: gapEnd})
+	}
+	return gaps, missing
+}
+
+// sampleNamespaces returns n namespaces picked at random, all if n is 0 or not less than the namespaces
+func sampleNamespaces(namespaces []corev1.Namespace, n int) []corev1.Namespace {
+	if n <= 0 || n >= len(namespaces) {
+		return namespaces
+	}
+	sampled := append([]corev1.Namespace(nil), namespaces...)
+	rand.Shuffle(len(sampled), func(i, j int) {
+		sampled[i], sampled[j] = sampled[j], sampled[i]
+	})
+	return sampled[:n]
+}
+
+// namespaceMonitorGaps returns the gaps of the monitors of the namespace in [start, end), the minutes before the
+// namespace was created are not checked. The current policy of the namespace is applied to the whole window.
+func (r *MonitorReconciler) namespaceMonitorGaps(ctx context.Context, namespace *corev1.Namespace, start, end time.Time) (*database.NamespaceMonitorGaps, error) {
+	policy := r.monitorPolicies.get(namespace)
+	if !policy.enabled() {
+		return nil, nil
+	}
+	if created := namespace.CreationTimestamp.Time.UTC(); created.After(start) {
+		start = created.Truncate(time.Minute).Add(time.Minute)
+	}
+	if !start.Before(end) {
+		return nil, nil
+	}
+	present := map[time.Time]bool{}
+	if err := r.DBClient.QueryMonitors(ctx, namespace.Name, start, end, func(monitor *resources.Monitor) error {
+		present[monitor.Time.UTC().Truncate(time.Minute)] = true
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to query the monitors of %s: %w", namespace.Name, err)
+	}
+	gaps, missing := monitorGaps(present, start, end, policy)
+	if len(gaps) == 0 {
+		return nil, nil
+	}
+	return &database.NamespaceMonitorGaps{Namespace: namespace.Name, MissingMinutes: missing, Gaps: gaps}, nil
+}
+
+// detectMonitorGaps checks the monitors of the sampled namespaces in the window before now, and saves the report
+func (r *MonitorReconciler) detectMonitorGaps(now time.Time) (*database.MonitorGapReport, error) {
+	ctx := context.Background()
+	end := now.UTC().Truncate(time.Minute).Add(-monitorGapSettleDelay)
+	report := &database.MonitorGapReport{CheckedAt: now.UTC(), WindowStart: end.Add(-r.gapDetection.window), WindowEnd: end}
+	namespaceList, err := r.getNamespaceList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the namespaces: %w", err)
+	}
+	namespaces := sampleNamespaces(namespaceList.Items, r.gapDetection.sample)
+	for i := range namespaces {
+		select {
+		case <-r.stopCh:
+			return report, nil
+		default:
+		}
+		gaps, err := r.namespaceMonitorGaps(ctx, &namespaces[i], report.WindowStart, end)
+		if err != nil {
+			return nil, err
+		}
+		report.Namespaces++
+		if gaps == nil {
+			continue
+		}
+		r.Logger.Info("monitor gaps found", "namespace", gaps.Namespace, "missing minutes", gaps.MissingMinutes,
+			"gaps", len(gaps.Gaps), "first", gaps.Gaps[0].Start.Format(time.RFC3339))
+		report.MissingMinutes += gaps.MissingMinutes
+		report.Gaps = append(report.Gaps, *gaps)
+	}
+	monitorMissingMinutes.Add(float64(report.MissingMinutes))
+	monitorGapNamespaces.Set(float64(len(report.Gaps)))
+
+	store := r.DBClient
+	if dual, ok := store.(*DualWriteStore); ok {
+		store = dual.MonitorStore
+	}
+	if err := database.SaveMonitorGapReport(ctx, store, report); err != nil && !errors.Is(err, database.ErrMonitorGapsUnsupported) {
+		return report, fmt.Errorf("failed to save the monitor gap report: %w", err)
+	}
+	if len(report.Gaps) > 0 && r.gapDetection.webhook != "" {
+		if err := r.gapDetection.notify(ctx, report); err != nil {
+			return report, err
+		}
+	}
+	r.Logger.Info("monitor gap detection", "window start", report.WindowStart.Format(time.RFC3339), "window end", end.Format(time.RFC3339),
+		"namespaces", report.Namespaces, "namespaces with gaps", len(report.Gaps), "missing minutes", report.MissingMinutes)
+	return report, nil
+}
+
+// notify posts the report to the webhook
+func (d *monitorGapDetection) notify(ctx context.Context, report *database.MonitorGapReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post the monitor gap report: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to post the monitor gap report: %s", resp.Status)
+	}
+	return nil
+}
+
+func (r *MonitorReconciler) runMonitorGapDetection() {
+	if _, err := r.detectMonitorGaps(r.gapDetection.clock.Now()); err != nil {
+		monitorGapDetectionRuns.WithLabelValues("failure").Inc()
+		r.Logger.Error(err, "failed to detect the monitor gaps")
+		return
+	}
+	monitorGapDetectionRuns.WithLabelValues("success").Inc()
+}
+
+func (r *MonitorReconciler) startMonitorGapDetection() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if r.gapDetection.elected != nil {
+			select {
+			case <-r.gapDetection.elected:
+			case <-r.stopCh:
+				return
+			}
+		}
+		for {
+			select {
+			case <-r.gapDetection.clock.After(r.gapDetection.interval):
+				r.runMonitorGapDetection()
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
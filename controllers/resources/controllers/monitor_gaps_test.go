@@ -0,0 +1,212 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestNewMonitorGapDetectionFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		rollupAge time.Duration
+		wantNil   bool
+		wantErr   bool
+	}{
+		{name: "disabled", env: map[string]string{}, wantNil: true},
+		{name: "default", env: map[string]string{MonitorGapDetection: "true"}},
+		{name: "newer than the rollup age", env: map[string]string{MonitorGapDetection: "true"}, rollupAge: 72 * time.Hour},
+		{name: "older than the rollup age", env: map[string]string{MonitorGapDetection: "true", MonitorGapWindow: "72h"}, rollupAge: 72 * time.Hour, wantErr: true},
+		{name: "short window", env: map[string]string{MonitorGapDetection: "true", MonitorGapWindow: "30s"}, wantErr: true},
+		{name: "short interval", env: map[string]string{MonitorGapDetection: "true", MonitorGapInterval: "10s"}, wantErr: true},
+		{name: "negative sample", env: map[string]string{MonitorGapDetection: "true", MonitorGapSample: "-1"}, wantErr: true},
+		{name: "webhook", env: map[string]string{MonitorGapDetection: "true", MonitorGapWebhook: "https://alert.example.com/gaps"}},
+		{name: "invalid webhook", env: map[string]string{MonitorGapDetection: "true", MonitorGapWebhook: "alert.example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{MonitorGapDetection, MonitorGapWindow, MonitorGapInterval, MonitorGapSample, MonitorGapWebhook} {
+				t.Setenv(key, tt.env[key])
+			}
+			d, err := newMonitorGapDetectionFromEnv(nil, tt.rollupAge)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newMonitorGapDetectionFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (d == nil) != tt.wantNil {
+				t.Errorf("newMonitorGapDetectionFromEnv() = %v, wantNil %v", d, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestMonitorGaps(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(30 * time.Minute)
+	minutes := func(from, to int) map[time.Time]bool {
+		present := map[time.Time]bool{}
+		for i := from; i < to; i++ {
+			present[start.Add(time.Duration(i)*time.Minute)] = true
+		}
+		return present
+	}
+	at := func(minute int) time.Time {
+		return start.Add(time.Duration(minute) * time.Minute)
+	}
+
+	if gaps, missing := monitorGaps(nil, start, end, nil); gaps != nil || missing != 0 {
+		t.Errorf("monitorGaps() without monitors = %v, %d, want no gaps", gaps, missing)
+	}
+	if gaps, missing := monitorGaps(minutes(0, 30), start, end, nil); gaps != nil || missing != 0 {
+		t.Errorf("monitorGaps() complete = %v, %d, want no gaps", gaps, missing)
+	}
+
+	present := minutes(0, 30)
+	for _, minute := range []int{5, 6, 7, 20} {
+		delete(present, at(minute))
+	}
+	gaps, missing := monitorGaps(present, start, end, nil)
+	want := []database.MonitorGap{{Start: at(5), End: at(8)}, {Start: at(20), End: at(21)}}
+	if len(gaps) != len(want) || missing != 4 {
+		t.Fatalf("monitorGaps() = %v, %d, want %v, 4", gaps, missing, want)
+	}
+	for i := range want {
+		if !gaps[i].Start.Equal(want[i].Start) || !gaps[i].End.Equal(want[i].End) {
+			t.Errorf("monitorGaps()[%d] = %v, want %v", i, gaps[i], want[i])
+		}
+	}
+
+	// a namespace metered every 5 minutes only has the monitors of the multiples of 5
+	policy := &namespacePolicy{minutes: 5}
+	present = map[time.Time]bool{at(0): true, at(5): true, at(20): true, at(25): true}
+	gaps, missing = monitorGaps(present, start, end, policy)
+	if len(gaps) != 1 || !gaps[0].Start.Equal(at(10)) || !gaps[0].End.Equal(at(20)) || missing != 10 {
+		t.Errorf("monitorGaps() of the 5m policy = %v, %d, want [10, 20), 10", gaps, missing)
+	}
+}
+
+func TestSampleNamespaces(t *testing.T) {
+	namespaces := make([]corev1.Namespace, 5)
+	if got := sampleNamespaces(namespaces, 0); len(got) != 5 {
+		t.Errorf("sampleNamespaces(0) = %d namespaces, want all", len(got))
+	}
+	if got := sampleNamespaces(namespaces, 2); len(got) != 2 {
+		t.Errorf("sampleNamespaces(2) = %d namespaces, want 2", len(got))
+	}
+	if got := sampleNamespaces(namespaces, 10); len(got) != 5 {
+		t.Errorf("sampleNamespaces(10) = %d namespaces, want all", len(got))
+	}
+}
+
+func TestMonitorReconciler_detectMonitorGaps(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	end := now.Truncate(time.Minute).Add(-monitorGapSettleDelay)
+	start := end.Add(-time.Hour)
+	created := metav1.NewTime(start.Add(-24 * time.Hour))
+	namespace := func(name string, created metav1.Time) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: created}}
+	}
+	// ns-new was created in the window, the minutes before are not checked
+	newCreated := metav1.NewTime(start.Add(30*time.Minute + 10*time.Second))
+	c := fake.NewClientBuilder().WithObjects(namespace("ns-complete", created), namespace("ns-gaps", created),
+		namespace("ns-empty", created), namespace("ns-new", newCreated)).Build()
+
+	db := databasetest.NewMemoryStore()
+	var monitors []*resources.Monitor
+	for minute := 0; minute < 60; minute++ {
+		ts := start.Add(time.Duration(minute) * time.Minute)
+		monitors = append(monitors, &resources.Monitor{Time: ts, Category: "ns-complete", Name: "app"})
+		// ns-gaps misses the minutes 10 to 19 and 45 of the window
+		if (minute < 10 || minute >= 20) && minute != 45 {
+			monitors = append(monitors, &resources.Monitor{Time: ts.Add(20 * time.Second), Category: "ns-gaps", Name: "app"})
+		}
+		if minute > 30 {
+			monitors = append(monitors, &resources.Monitor{Time: ts, Category: "ns-new", Name: "app"})
+		}
+	}
+	if err := db.InsertMonitor(context.Background(), monitors...); err != nil {
+		t.Fatal(err)
+	}
+
+	var posted *database.MonitorGapReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		posted = &database.MonitorGapReport{}
+		if err := json.NewDecoder(req.Body).Decode(posted); err != nil {
+			t.Errorf("failed to decode the posted report: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	r := &MonitorReconciler{
+		Client:            c,
+		Logger:            logr.Discard(),
+		DBClient:          db,
+		NamespaceSelector: labels.Everything(),
+		monitorPolicies:   newMonitorPolicies(),
+		gapDetection:      &monitorGapDetection{window: time.Hour, webhook: server.URL, client: server.Client()},
+	}
+	before := testutil.ToFloat64(monitorMissingMinutes)
+	report, err := r.detectMonitorGaps(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Namespaces != 4 || report.MissingMinutes != 11 || len(report.Gaps) != 1 {
+		t.Fatalf("detectMonitorGaps() = %d namespaces, %d missing minutes, gaps %+v, want 4, 11 and the gaps of ns-gaps",
+			report.Namespaces, report.MissingMinutes, report.Gaps)
+	}
+	gaps := report.Gaps[0]
+	if gaps.Namespace != "ns-gaps" || len(gaps.Gaps) != 2 || !gaps.Gaps[0].Start.Equal(start.Add(10*time.Minute)) ||
+		!gaps.Gaps[0].End.Equal(start.Add(20*time.Minute)) || !gaps.Gaps[1].Start.Equal(start.Add(45*time.Minute)) {
+		t.Errorf("detectMonitorGaps() gaps = %+v, want [10, 20) and [45, 46) of ns-gaps", gaps)
+	}
+	if got := testutil.ToFloat64(monitorMissingMinutes) - before; got != 11 {
+		t.Errorf("missing minutes metric increased by %v, want 11", got)
+	}
+	if reports := db.GapReports(); len(reports) != 1 || reports[0].MissingMinutes != 11 {
+		t.Errorf("saved gap reports = %+v, want the report", reports)
+	}
+	if posted == nil || posted.MissingMinutes != 11 || len(posted.Gaps) != 1 {
+		t.Errorf("posted report = %+v, want the report", posted)
+	}
+
+	// a run without gaps saves the report but doesn't post it
+	posted = nil
+	r.Client = fake.NewClientBuilder().WithObjects(namespace("ns-complete", created), namespace("ns-empty", created)).Build()
+	if report, err = r.detectMonitorGaps(now); err != nil || len(report.Gaps) != 0 {
+		t.Fatalf("detectMonitorGaps() = %+v, %v, want no gaps", report, err)
+	}
+	if posted != nil {
+		t.Errorf("posted report = %+v, want none without gaps", posted)
+	}
+	if reports := db.GapReports(); len(reports) != 2 {
+		t.Errorf("saved %d gap reports, want 2", len(reports))
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	resourcesv1alpha1 "github.com/labring/sealos/controllers/resources/api/v1alpha1"
+)
+
+//+kubebuilder:rbac:groups=resources.sealos.io,resources=monitorpolicies,verbs=get;list;watch
+
+// monitorPolicyRefreshTimeout bounds the wait of the policy informer to sync, eg: the list is forbidden by the rbac
+const monitorPolicyRefreshTimeout = 10 * time.Second
+
+// namespacePolicy the metering of a namespace overridden by its MonitorPolicy, nil meters by the global config
+type namespacePolicy struct {
+	disabled bool
+	// resources the metered resources, all if nil
+	resources map[resourcesv1alpha1.MeteredResource]bool
+	// minutes the metering interval in minutes, 1 if not overridden
+	minutes int64
+}
+
+func newNamespacePolicy(spec resourcesv1alpha1.MonitorPolicySpec) (*namespacePolicy, error) {
+	policy := &namespacePolicy{disabled: spec.Disabled, minutes: 1}
+	if len(spec.Resources) > 0 {
+		policy.resources = make(map[resourcesv1alpha1.MeteredResource]bool, len(spec.Resources))
+		for _, res := range spec.Resources {
+			policy.resources[res] = true
+		}
+	}
+	if spec.Interval != nil {
+		interval := spec.Interval.Duration
+		if interval < time.Minute || interval%time.Minute != 0 || time.Hour%interval != 0 {
+			return nil, fmt.Errorf("invalid interval %s, must be a multiple of the minute dividing the hour", interval)
+		}
+		policy.minutes = int64(interval / time.Minute)
+	}
+	return policy, nil
+}
+
+// meters returns true if the namespace is metered at the minute: it is not disabled and the minute starts an interval
+func (p *namespacePolicy) meters(timeStamp time.Time) bool {
+	if p == nil {
+		return true
+	}
+	return !p.disabled && int64(timeStamp.Minute())%p.minutes == 0
+}
+
+// enabled returns true if the namespace is metered at all, the disabled namespaces are not sampled
+func (p *namespacePolicy) enabled() bool {
+	return p == nil || !p.disabled
+}
+
+// apply drops the resources not metered by the policy from the monitors, and scales the average resources up to the
+// minutes of the interval, so a monitor stands for the whole interval. The accumulated resources (eg: the object storage
+// flow) are not scaled, they only cover the minute metered. The monitors left without usage are dropped.
+func (p *namespacePolicy) apply(properties *resources.PropertyTypeLS, monitors []*resources.Monitor) []*resources.Monitor {
+	if p == nil || (p.resources == nil && p.minutes == 1) {
+		return monitors
+	}
+	kept := monitors[:0]
+	for _, monitor := range monitors {
+		for enum, used := range monitor.Used {
+			property, ok := properties.EnumMap[enum]
+			if p.resources != nil && (!ok || !p.resources[meteredResource(property.Name)]) {
+				delete(monitor.Used, enum)
+				continue
+			}
+			if ok && property.PriceType == resources.AVG {
+				monitor.Used[enum] = used * p.minutes
+			}
+		}
+		if len(monitor.Used) > 0 {
+			kept = append(kept, monitor)
+		}
+	}
+	return kept
+}
+
+// meteredResource returns the resource of the property, the gpus of all models are the gpu resource
+func meteredResource(property string) resourcesv1alpha1.MeteredResource {
+	if strings.HasPrefix(property, resources.GpuResourcePrefix) {
+		return resourcesv1alpha1.MeteredResourceGPU
+	}
+	return resourcesv1alpha1.MeteredResource(property)
+}
+
+// selectedPolicy a policy with the selector of its namespaces
+type selectedPolicy struct {
+	name     string
+	selector labels.Selector
+	policy   *namespacePolicy
+}
+
+// monitorPolicies caches the cluster scoped MonitorPolicies, refreshed from the informer cache once per cycle
+type monitorPolicies struct {
+	mu       sync.RWMutex
+	policies []selectedPolicy
+}
+
+func newMonitorPolicies() *monitorPolicies {
+	return &monitorPolicies{}
+}
+
+// refresh reads all the policies. A namespace selected by several policies uses the first valid one by name, an invalid
+// policy is skipped and its namespaces are metered by the global config. No policy is read if the CRD is not installed.
+func (p *monitorPolicies) refresh(ctx context.Context, reader client.Reader, logger logr.Logger) {
+	if p == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, monitorPolicyRefreshTimeout)
+	defer cancel()
+	list := &resourcesv1alpha1.MonitorPolicyList{}
+	if err := reader.List(ctx, list); err != nil {
+		if meta.IsNoMatchError(err) {
+			logger.V(1).Info("monitor policy crd is not installed, the namespaces are metered by the global config")
+		} else {
+			// the policies of the last cycle are kept
+			logger.Error(err, "failed to list monitor policies")
+			return
+		}
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+	policies := make([]selectedPolicy, 0, len(list.Items))
+	for _, item := range list.Items {
+		if item.Spec.NamespaceSelector == nil {
+			logger.Info("ignore the monitor policy without namespace selector", "policy", item.Name)
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(item.Spec.NamespaceSelector)
+		if err != nil {
+			logger.Error(err, "invalid namespace selector of the monitor policy, its namespaces are metered by the global config", "policy", item.Name)
+			continue
+		}
+		policy, err := newNamespacePolicy(item.Spec)
+		if err != nil {
+			logger.Error(err, "invalid monitor policy, its namespaces are metered by the global config", "policy", item.Name)
+			continue
+		}
+		policies = append(policies, selectedPolicy{name: item.Name, selector: selector, policy: policy})
+	}
+	p.mu.Lock()
+	p.policies = policies
+	p.mu.Unlock()
+}
+
+// get returns the first policy selecting the namespace, nil if the namespace has none
+func (p *monitorPolicies) get(namespace *corev1.Namespace) *namespacePolicy {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, selected := range p.policies {
+		if selected.selector.Matches(labels.Set(namespace.Labels)) {
+			return selected.policy
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	resourcesv1alpha1 "github.com/labring/sealos/controllers/resources/api/v1alpha1"
+)
+
+func TestNewNamespacePolicy(t *testing.T) {
+	tests := []struct {
+		interval    time.Duration
+		wantMinutes int64
+		wantErr     bool
+	}{
+		{interval: time.Minute, wantMinutes: 1},
+		{interval: 5 * time.Minute, wantMinutes: 5},
+		{interval: time.Hour, wantMinutes: 60},
+		{interval: 30 * time.Second, wantErr: true},
+		{interval: 90 * time.Second, wantErr: true},
+		{interval: 7 * time.Minute, wantErr: true},
+		{interval: 2 * time.Hour, wantErr: true},
+	}
+	for _, tt := range tests {
+		policy, err := newNamespacePolicy(resourcesv1alpha1.MonitorPolicySpec{Interval: &metav1.Duration{Duration: tt.interval}})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newNamespacePolicy(%s) error = %v, wantErr %v", tt.interval, err, tt.wantErr)
+			continue
+		}
+		if err == nil && policy.minutes != tt.wantMinutes {
+			t.Errorf("newNamespacePolicy(%s) minutes = %d, want %d", tt.interval, policy.minutes, tt.wantMinutes)
+		}
+	}
+}
+
+func TestNamespacePolicy_meters(t *testing.T) {
+	policy, err := newNamespacePolicy(resourcesv1alpha1.MonitorPolicySpec{Interval: &metav1.Duration{Duration: 5 * time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for minute, want := range map[int]bool{0: true, 1: false, 4: false, 5: true, 55: true} {
+		if got := policy.meters(at.Add(time.Duration(minute) * time.Minute)); got != want {
+			t.Errorf("meters(10:%02d) = %v, want %v", minute, got, want)
+		}
+	}
+	var global *namespacePolicy
+	if !global.meters(at.Add(time.Minute)) || !global.enabled() {
+		t.Error("the namespace without a policy is not metered every minute")
+	}
+	if disabled := (&namespacePolicy{disabled: true, minutes: 1}); disabled.meters(at) || disabled.enabled() {
+		t.Error("the disabled namespace is metered")
+	}
+}
+
+func TestNamespacePolicy_apply(t *testing.T) {
+	gpuType := resources.PropertyType{Name: resources.GpuResourcePrefix + "tesla-t4", Enum: 10, PriceType: resources.AVG}
+	properties := &resources.PropertyTypeLS{EnumMap: map[uint8]resources.PropertyType{gpuType.Enum: gpuType}}
+	for enum, property := range resources.DefaultPropertyTypeLS.EnumMap {
+		properties.EnumMap[enum] = property
+	}
+	cpu := resources.DefaultPropertyTypeLS.StringMap["cpu"].Enum
+	memory := resources.DefaultPropertyTypeLS.StringMap["memory"].Enum
+	network := resources.DefaultPropertyTypeLS.StringMap["network"].Enum
+	policy, err := newNamespacePolicy(resourcesv1alpha1.MonitorPolicySpec{
+		Resources: []resourcesv1alpha1.MeteredResource{resourcesv1alpha1.MeteredResourceCPU, resourcesv1alpha1.MeteredResourceGPU,
+			resourcesv1alpha1.MeteredResourceNetwork},
+		Interval: &metav1.Duration{Duration: 5 * time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitors := policy.apply(properties, []*resources.Monitor{
+		{Name: "app-a", Used: resources.EnumUsedMap{cpu: 100, memory: 512, gpuType.Enum: 1000}},
+		{Name: "bucket-a", Used: resources.EnumUsedMap{network: 64}},
+		// only the memory is used, dropped with the memory
+		{Name: "pvc-a", Used: resources.EnumUsedMap{memory: 512}},
+	})
+	if len(monitors) != 2 {
+		t.Fatalf("apply() = %d monitors, want 2", len(monitors))
+	}
+	// the average resources are scaled to the interval, the accumulated network is not
+	want := []resources.EnumUsedMap{{cpu: 500, gpuType.Enum: 5000}, {network: 64}}
+	for i, monitor := range monitors {
+		if len(monitor.Used) != len(want[i]) {
+			t.Errorf("%s used = %v, want %v", monitor.Name, monitor.Used, want[i])
+			continue
+		}
+		for enum, used := range want[i] {
+			if monitor.Used[enum] != used {
+				t.Errorf("%s used = %v, want %v", monitor.Name, monitor.Used, want[i])
+			}
+		}
+	}
+}
+
+// testPolicyLabel the namespace label selected by the test policies
+const testPolicyLabel = "monitor-policy"
+
+func selectPolicy(values ...string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: testPolicyLabel, Operator: metav1.LabelSelectorOpIn, Values: values},
+	}}
+}
+
+func TestMonitorReconciler_monitorResourceUsage_MonitorPolicy(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := resourcesv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	newPod := func(namespace string) client.Object {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "app-a", Labels: map[string]string{resources.AppLabelKey: "app-a"}},
+			Spec: corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+				},
+			}}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newPod("ns-cpu-only"), newPod("ns-disabled"), newPod("ns-global"), newPod("ns-invalid"),
+		&resourcesv1alpha1.MonitorPolicy{ObjectMeta: metav1.ObjectMeta{Name: "a-cpu-only"},
+			Spec: resourcesv1alpha1.MonitorPolicySpec{NamespaceSelector: selectPolicy("cpu-only"), Resources: []resourcesv1alpha1.MeteredResource{resourcesv1alpha1.MeteredResourceCPU}}},
+		// the namespace selected by several policies uses the first by name
+		&resourcesv1alpha1.MonitorPolicy{ObjectMeta: metav1.ObjectMeta{Name: "b-disabled"},
+			Spec: resourcesv1alpha1.MonitorPolicySpec{NamespaceSelector: selectPolicy("cpu-only", "disabled"), Disabled: true}},
+		// the invalid policy is ignored, the namespace is metered by the global config
+		&resourcesv1alpha1.MonitorPolicy{ObjectMeta: metav1.ObjectMeta{Name: "c-invalid"},
+			Spec: resourcesv1alpha1.MonitorPolicySpec{NamespaceSelector: selectPolicy("invalid"), Disabled: true, Interval: &metav1.Duration{Duration: 7 * time.Minute}}},
+		// the policy without namespace selector selects no namespace
+		&resourcesv1alpha1.MonitorPolicy{ObjectMeta: metav1.ObjectMeta{Name: "d-no-selector"},
+			Spec: resourcesv1alpha1.MonitorPolicySpec{Disabled: true}},
+	).Build()
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{
+		Client:          c,
+		Logger:          logr.Discard(),
+		DBClient:        db,
+		Properties:      resources.DefaultPropertyTypeLS,
+		MeteringPolicy:  MeteringPolicyRequests,
+		monitorPolicies: newMonitorPolicies(),
+	}
+	r.monitorPolicies.refresh(context.Background(), r.Client, r.Logger)
+	for namespace, policy := range map[string]string{"ns-cpu-only": "cpu-only", "ns-disabled": "disabled", "ns-global": "", "ns-invalid": "invalid"} {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{}}}
+		if policy != "" {
+			ns.Labels[testPolicyLabel] = policy
+		}
+		if err := r.monitorResourceUsage(ns); err != nil {
+			t.Fatalf("monitorResourceUsage(%s) error = %v", namespace, err)
+		}
+	}
+
+	cpu := resources.DefaultPropertyTypeLS.StringMap["cpu"].Enum
+	memory := resources.DefaultPropertyTypeLS.StringMap["memory"].Enum
+	metered := map[string]resources.EnumUsedMap{}
+	for _, monitor := range db.Monitors() {
+		metered[monitor.Category] = monitor.Used
+	}
+	want := map[string]resources.EnumUsedMap{
+		"ns-cpu-only": {cpu: 500},
+		"ns-global":   {cpu: 500, memory: 512},
+		"ns-invalid":  {cpu: 500, memory: 512},
+	}
+	if len(metered) != len(want) {
+		t.Errorf("metered namespaces = %v, want %v", metered, want)
+	}
+	for namespace, used := range want {
+		if len(metered[namespace]) != len(used) || metered[namespace][cpu] != used[cpu] || metered[namespace][memory] != used[memory] {
+			t.Errorf("%s used = %v, want %v", namespace, metered[namespace], used)
+		}
+	}
+}
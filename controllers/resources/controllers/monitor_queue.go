@@ -0,0 +1,192 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// MonitorWriteQueueCapacity the max namespace writes waiting in the async insert queue, 0 (default) inserts synchronously
+	MonitorWriteQueueCapacity = "MONITOR_WRITE_QUEUE_CAPACITY"
+	// MonitorWriteQueueWorkers the number of the goroutines draining the queue, default 16
+	MonitorWriteQueueWorkers = "MONITOR_WRITE_QUEUE_WORKERS"
+
+	DefaultMonitorWriteQueueWorkers = 16
+)
+
+var (
+	monitorQueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sealos_resources_monitor_queue_length",
+		Help: "Number of the namespace writes waiting in the async monitor insert queue.",
+	})
+	monitorQueueDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_queue_dropped_total",
+		Help: "Number of the monitors dropped from the full async insert queue, the oldest writes are dropped first.",
+	})
+	monitorBackpressure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_backpressure_total",
+		Help: "Number of the reconcile cycles started under the backpressure of the monitor insert queue.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(monitorQueueLength, monitorQueueDropped, monitorBackpressure)
+}
+
+// queuedMonitors the monitors of a namespace waiting in the queue
+type queuedMonitors struct {
+	namespace string
+	monitors  []*resources.Monitor
+}
+
+// monitorQueue decouples the namespace workers from the monitor inserts, so a slow database doesn't hold the workers
+// and push the cycle past the reconcile period. The queue is bounded: once full, the oldest write is dropped for the
+// newest one and counted, the newer usage is preferred as the dropped minute can't be inserted in time anyway.
+// The queue is under backpressure once it's half full, the reconcile loop then skips the non-essential work of the cycle.
+type monitorQueue struct {
+	write    func(namespace string, monitors []*resources.Monitor) error
+	capacity int
+	workers  int
+	logger   logr.Logger
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []queuedMonitors
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func newMonitorQueue(write func(namespace string, monitors []*resources.Monitor) error, capacity, workers int, logger logr.Logger) *monitorQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+	q := &monitorQueue{
+		write:    write,
+		capacity: capacity,
+		workers:  workers,
+		logger:   logger,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *monitorQueue) start() {
+	q.wg.Add(q.workers)
+	for i := 0; i < q.workers; i++ {
+		go func() {
+			defer q.wg.Done()
+			q.run()
+		}()
+	}
+}
+
+// stop writes the pending monitors and waits for the workers, no monitor may be pushed after it
+func (q *monitorQueue) stop() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// push queues the monitors of the namespace without waiting for the insert, the oldest write is dropped if the queue is full
+func (q *monitorQueue) push(namespace string, monitors []*resources.Monitor) {
+	if len(monitors) == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) >= q.capacity {
+		dropped := q.pending[0]
+		q.pending[0] = queuedMonitors{}
+		q.pending = q.pending[1:]
+		monitorQueueDropped.Add(float64(len(dropped.monitors)))
+		q.logger.Error(nil, "monitor insert queue is full, drop the oldest monitors", "namespace", dropped.namespace,
+			"monitors", len(dropped.monitors), "capacity", q.capacity)
+	}
+	q.pending = append(q.pending, queuedMonitors{namespace: namespace, monitors: monitors})
+	monitorQueueLength.Set(float64(len(q.pending)))
+	q.cond.Signal()
+}
+
+// pop waits for the next write, false once the queue is stopped and drained
+func (q *monitorQueue) pop() (queuedMonitors, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.pending) == 0 {
+		return queuedMonitors{}, false
+	}
+	next := q.pending[0]
+	q.pending[0] = queuedMonitors{}
+	q.pending = q.pending[1:]
+	monitorQueueLength.Set(float64(len(q.pending)))
+	return next, true
+}
+
+func (q *monitorQueue) run() {
+	for {
+		next, ok := q.pop()
+		if !ok {
+			return
+		}
+		if err := q.write(next.namespace, next.monitors); err != nil && !errors.Is(err, errMeteringPaused) {
+			q.logger.Error(err, "failed to write monitors", "namespace", next.namespace, "monitors", len(next.monitors))
+		}
+	}
+}
+
+func (q *monitorQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// backpressured the queue is at least half full, the writes of the last cycles are not drained yet
+func (q *monitorQueue) backpressured() bool {
+	return q.len()*2 >= q.capacity
+}
+
+// newMonitorQueueFromEnv returns nil if the capacity is 0, the namespace workers then wait for their inserts
+func newMonitorQueueFromEnv(write func(namespace string, monitors []*resources.Monitor) error, logger logr.Logger) *monitorQueue {
+	capacity := env.GetInt64EnvWithDefault(MonitorWriteQueueCapacity, 0)
+	if capacity <= 0 {
+		return nil
+	}
+	return newMonitorQueue(write, int(capacity), int(env.GetInt64EnvWithDefault(MonitorWriteQueueWorkers, DefaultMonitorWriteQueueWorkers)), logger)
+}
+
+// cycleBackpressured reports whether the cycle starts under the backpressure of the insert queue,
+// the non-essential work (the object storage metering) is then skipped for the cycle
+func (r *MonitorReconciler) cycleBackpressured() bool {
+	if r.monitorQueue == nil || !r.monitorQueue.backpressured() {
+		return false
+	}
+	monitorBackpressure.Inc()
+	r.Logger.Info("monitor insert queue is under backpressure, skip the object storage metering of the cycle",
+		"queued", r.monitorQueue.len(), "capacity", r.monitorQueue.capacity)
+	return true
+}
@@ -0,0 +1,177 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// slowDB takes delay for each write and records the written namespaces
+type slowDB struct {
+	delay time.Duration
+
+	mu      sync.Mutex
+	written []string
+}
+
+func (db *slowDB) write(namespace string, _ []*resources.Monitor) error {
+	time.Sleep(db.delay)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.written = append(db.written, namespace)
+	return nil
+}
+
+func (db *slowDB) writtenNamespaces() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string(nil), db.written...)
+}
+
+func TestMonitorQueue_DrainsOnStop(t *testing.T) {
+	db := &slowDB{delay: time.Millisecond}
+	q := newMonitorQueue(db.write, 100, 4, logr.Discard())
+	q.start()
+	for i := 0; i < 50; i++ {
+		namespace := fmt.Sprintf("ns-%d", i)
+		q.push(namespace, namespaceMonitors(namespace, 1))
+	}
+	q.push("ns-empty", nil)
+	q.stop()
+	if written := db.writtenNamespaces(); len(written) != 50 {
+		t.Errorf("wrote %d namespaces after stop, want 50", len(written))
+	}
+}
+
+func TestMonitorReconciler_StartReconciler_FlushesOnStop(t *testing.T) {
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, stopCh: make(chan struct{})}
+	// the linger never fires, the batch is only flushed by the stop
+	r.monitorWriter = newMonitorWriter(r.insertMonitorDetailed, 500, time.Hour, 1)
+	r.monitorQueue = newMonitorQueue(r.insertNamespaceMonitors, 100, 1, logr.Discard())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.StartReconciler(ctx)
+	}()
+	if err := r.writeMonitors("ns-a", namespaceMonitors("ns-a", 3)); err != nil {
+		t.Fatal(err)
+	}
+	// the signal cancels the context, the reconciler stops before the first reconcile at the next minute
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("StartReconciler() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartReconciler() didn't return once the context is canceled")
+	}
+	if got := len(db.Monitors()); got != 3 {
+		t.Errorf("monitors flushed on stop = %d, want 3", got)
+	}
+}
+
+// TestMonitorQueue_SlowDB a database far slower than the cycle: the namespace workers never block,
+// the queue stays bounded and drops the oldest writes, the newest ones are written.
+func TestMonitorQueue_SlowDB(t *testing.T) {
+	const capacity, pushed = 8, 200
+	db := &slowDB{delay: 20 * time.Millisecond}
+	q := newMonitorQueue(db.write, capacity, 1, logr.Discard())
+	droppedBefore := testutil.ToFloat64(monitorQueueDropped)
+	q.start()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < pushed; i += 4 {
+				namespace := fmt.Sprintf("ns-%d", i)
+				q.push(namespace, namespaceMonitors(namespace, 2))
+				if n := q.len(); n > capacity {
+					t.Errorf("queue length = %d, want <= %d", n, capacity)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	// 200 writes at 20ms would take 4s if the workers waited for the inserts
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("pushed in %s, want not blocked by the slow db", elapsed)
+	}
+	if !q.backpressured() {
+		t.Errorf("backpressured() = false with %d of %d queued, want true", q.len(), capacity)
+	}
+	q.stop()
+
+	written := db.writtenNamespaces()
+	dropped := testutil.ToFloat64(monitorQueueDropped) - droppedBefore
+	if got := float64(len(written)) + dropped/2; got != pushed {
+		t.Errorf("written %d + dropped %v writes = %v, want %d", len(written), dropped/2, got, pushed)
+	}
+	if len(written) > pushed/2 {
+		t.Errorf("written %d namespaces, want most of them dropped by the slow db", len(written))
+	}
+}
+
+func TestMonitorQueue_DropOldest(t *testing.T) {
+	db := &slowDB{}
+	// not started, the writes stay in the queue
+	q := newMonitorQueue(db.write, 2, 1, logr.Discard())
+	for _, namespace := range []string{"ns-a", "ns-b", "ns-c"} {
+		q.push(namespace, namespaceMonitors(namespace, 1))
+	}
+	q.start()
+	q.stop()
+	if written := db.writtenNamespaces(); len(written) != 2 || written[0] != "ns-b" || written[1] != "ns-c" {
+		t.Errorf("written %v, want the oldest ns-a dropped", written)
+	}
+}
+
+func TestMonitorReconciler_cycleBackpressured(t *testing.T) {
+	r := &MonitorReconciler{Logger: logr.Discard()}
+	if r.cycleBackpressured() {
+		t.Errorf("cycleBackpressured() without the queue = true, want false")
+	}
+	r.monitorQueue = newMonitorQueue((&slowDB{}).write, 4, 1, logr.Discard())
+	r.monitorQueue.push("ns-a", namespaceMonitors("ns-a", 1))
+	if r.cycleBackpressured() {
+		t.Errorf("cycleBackpressured() with 1 of 4 queued = true, want false")
+	}
+	before := testutil.ToFloat64(monitorBackpressure)
+	r.monitorQueue.push("ns-b", namespaceMonitors("ns-b", 1))
+	if !r.cycleBackpressured() {
+		t.Errorf("cycleBackpressured() with 2 of 4 queued = false, want true")
+	}
+	if got := testutil.ToFloat64(monitorBackpressure) - before; got != 1 {
+		t.Errorf("backpressure metric = %v, want 1", got)
+	}
+	// the queued writes are sent by writeMonitors without waiting
+	if err := r.writeMonitors("ns-c", namespaceMonitors("ns-c", 1)); err != nil || r.monitorQueue.len() != 3 {
+		t.Errorf("writeMonitors() = %v with %d queued, want queued", err, r.monitorQueue.len())
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// MonitorRetentionDays the days of the monitors kept, the older daily collections are dropped once a day, 0 never drops
+	MonitorRetentionDays = "MONITOR_RETENTION_DAYS"
+	// MonitorRetentionHour the UTC hour of the daily drop, a low-traffic hour, default 3
+	MonitorRetentionHour = "MONITOR_RETENTION_HOUR"
+	// MonitorRetentionForce allows a retention shorter than MinMonitorRetentionDays, the monitors may be dropped before they are billed
+	MonitorRetentionForce = "MONITOR_RETENTION_FORCE"
+	// MonitorRetentionMode how the expired monitors are removed, job drops the expired daily collections at the hour,
+	// ttl sets a TTL of the retention on the monitor time so the storage expires them, default job
+	MonitorRetentionMode = "MONITOR_RETENTION_MODE"
+
+	MonitorRetentionModeJob = "job"
+	MonitorRetentionModeTTL = "ttl"
+
+	DefaultMonitorRetentionDays = 30
+	DefaultMonitorRetentionHour = 3
+	// MinMonitorRetentionDays the billing cycle, the monitors are kept at least until they are billed
+	MinMonitorRetentionDays = 7
+
+	// monitorTTLTimeout bounds setting the TTL of all the daily monitor collections
+	monitorTTLTimeout = 5 * time.Minute
+)
+
+var (
+	monitorRetentionRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_retention_runs_total",
+		Help: "Number of the daily monitor retention runs by the result.",
+	}, []string{"result"})
+	monitorRetentionDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_retention_dropped_total",
+		Help: "Number of the daily monitor collections or partitions dropped by the retention.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(monitorRetentionRuns, monitorRetentionDropped)
+}
+
+// monitorRetention drops the monitors older than the days once a day at the hour, or sets the TTL of the days in the
+// ttl mode. The days 0 never drops.
+type monitorRetention struct {
+	days  int
+	hour  int
+	mode  string
+	clock clock.Clock
+	// elected is closed when the replica becomes the leader, only the leader drops the monitors
+	elected <-chan struct{}
+}
+
+// newMonitorRetentionFromEnv returns the retention of the days 0 as well, it removes the TTL of a former ttl mode
+func newMonitorRetentionFromEnv(elected <-chan struct{}) (*monitorRetention, error) {
+	days, hour, err := parseMonitorRetention(env.GetInt64EnvWithDefault(MonitorRetentionDays, DefaultMonitorRetentionDays),
+		env.GetInt64EnvWithDefault(MonitorRetentionHour, DefaultMonitorRetentionHour), env.GetBoolEnvWithDefault(MonitorRetentionForce, false))
+	if err != nil {
+		return nil, err
+	}
+	mode, err := parseMonitorRetentionMode(env.GetEnvWithDefault(MonitorRetentionMode, MonitorRetentionModeJob), days)
+	if err != nil {
+		return nil, err
+	}
+	return &monitorRetention{days: days, hour: hour, mode: mode, clock: clock.RealClock{}, elected: elected}, nil
+}
+
+func parseMonitorRetention(days, hour int64, force bool) (int, int, error) {
+	if days < 0 {
+		return 0, 0, fmt.Errorf("invalid %s %d: must be >= 0", MonitorRetentionDays, days)
+	}
+	if days > 0 && days < MinMonitorRetentionDays && !force {
+		return 0, 0, fmt.Errorf("invalid %s %d: shorter than the billing cycle of %d days, set %s=true to force it",
+			MonitorRetentionDays, days, MinMonitorRetentionDays, MonitorRetentionForce)
+	}
+	if hour < 0 || hour > 23 {
+		return 0, 0, fmt.Errorf("invalid %s %d: must be in [0, 23]", MonitorRetentionHour, hour)
+	}
+	return int(days), int(hour), nil
+}
+
+func parseMonitorRetentionMode(mode string, days int) (string, error) {
+	switch mode {
+	case MonitorRetentionModeJob:
+	case MonitorRetentionModeTTL:
+		if days == 0 {
+			return "", fmt.Errorf("invalid %s %s: requires %s > 0", MonitorRetentionMode, mode, MonitorRetentionDays)
+		}
+	default:
+		return "", fmt.Errorf("invalid %s %q: must be %s or %s", MonitorRetentionMode, mode, MonitorRetentionModeJob, MonitorRetentionModeTTL)
+	}
+	return mode, nil
+}
+
+// nextRun returns the next retention hour after now
+func (m *monitorRetention) nextRun(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), m.hour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (r *MonitorReconciler) startMonitorRetention() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		// the replicas not elected wait here, so a single replica drops the monitors
+		if r.retention.elected != nil {
+			select {
+			case <-r.retention.elected:
+			case <-r.stopCh:
+				return
+			}
+		}
+		r.setMonitorTTL()
+		if r.retention.days == 0 {
+			return
+		}
+		for {
+			now := r.retention.clock.Now()
+			select {
+			case <-r.retention.clock.After(r.retention.nextRun(now).Sub(now)):
+				// the drop is a no-op in the ttl mode, the TTL is set again for the daily collections created since
+				if r.retention.mode == MonitorRetentionModeTTL {
+					r.setMonitorTTL()
+				} else {
+					r.dropExpiredMonitors()
+				}
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (r *MonitorReconciler) dropExpiredMonitors() {
+	start := r.retention.clock.Now()
+	if r.archive != nil {
+		// the expired days are dropped once archived, a failed archival is resumed by the next run
+		if err := r.archiveExpiredMonitors(start); err != nil {
+			monitorRetentionRuns.WithLabelValues("failure").Inc()
+			r.Logger.Error(err, "failed to archive the expired monitors, they are not dropped", "retention days", r.retention.days)
+			return
+		}
+	}
+	dropped, err := r.DBClient.DropMonitorCollectionsOlderThan(r.retention.days)
+	monitorRetentionDropped.Add(float64(dropped))
+	if err != nil {
+		monitorRetentionRuns.WithLabelValues("failure").Inc()
+		r.Logger.Error(err, "failed to drop the expired monitors", "retention days", r.retention.days, "dropped", dropped)
+		return
+	}
+	monitorRetentionRuns.WithLabelValues("success").Inc()
+	r.Logger.Info("dropped the expired monitors", "retention days", r.retention.days, "dropped", dropped,
+		"duration", r.retention.clock.Since(start))
+}
+
+// setMonitorTTL sets the TTL of the retention in the ttl mode, and removes it otherwise, so switching back to the job
+// doesn't leave a TTL expiring the monitors the job keeps. Like the drop, only the primary is changed, see DualWriteStore.
+func (r *MonitorReconciler) setMonitorTTL() {
+	days := 0
+	if r.retention.mode == MonitorRetentionModeTTL {
+		days = r.retention.days
+	}
+	store := r.DBClient
+	if dual, ok := store.(*DualWriteStore); ok {
+		store = dual.MonitorStore
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), monitorTTLTimeout)
+	defer cancel()
+	changed, err := database.SetMonitorTTL(ctx, store, days)
+	if days == 0 {
+		// the storage without a TTL has none to remove
+		if err != nil && !errors.Is(err, database.ErrMonitorTTLUnsupported) {
+			r.Logger.Error(err, "failed to remove the monitor ttl of the ttl retention mode")
+		} else if changed > 0 {
+			r.Logger.Info("removed the monitor ttl of the ttl retention mode", "collections", changed)
+		}
+		return
+	}
+	if err != nil {
+		monitorRetentionRuns.WithLabelValues("failure").Inc()
+		r.Logger.Error(err, "failed to set the monitor ttl", "retention days", days, "collections", changed)
+		return
+	}
+	monitorRetentionRuns.WithLabelValues("success").Inc()
+	r.Logger.Info("set the monitor ttl", "retention days", days, "collections", changed)
+}
@@ -0,0 +1,268 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	testingclock "k8s.io/utils/clock/testing"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+)
+
+func TestParseMonitorRetention(t *testing.T) {
+	tests := []struct {
+		name     string
+		days     int64
+		hour     int64
+		force    bool
+		wantDays int
+		wantErr  bool
+	}{
+		{name: "default", days: DefaultMonitorRetentionDays, hour: DefaultMonitorRetentionHour, wantDays: 30},
+		{name: "never drop", days: 0, hour: 3, wantDays: 0},
+		{name: "billing cycle", days: MinMonitorRetentionDays, hour: 3, wantDays: 7},
+		{name: "shorter than the billing cycle", days: 3, hour: 3, wantErr: true},
+		{name: "forced", days: 3, hour: 3, force: true, wantDays: 3},
+		{name: "negative", days: -1, hour: 3, force: true, wantErr: true},
+		{name: "invalid hour", days: 30, hour: 24, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, _, err := parseMonitorRetention(tt.days, tt.hour, tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMonitorRetention() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && days != tt.wantDays {
+				t.Errorf("parseMonitorRetention() days = %d, want %d", days, tt.wantDays)
+			}
+		})
+	}
+}
+
+func TestMonitorRetention_nextRun(t *testing.T) {
+	m := &monitorRetention{hour: 3}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		now, want time.Time
+	}{
+		{now: day, want: day.Add(3 * time.Hour)},
+		{now: day.Add(3 * time.Hour), want: day.Add(27 * time.Hour)},
+		{now: day.Add(10 * time.Hour), want: day.Add(27 * time.Hour)},
+		// the local time is converted, 11:00 UTC+8 is 03:00 UTC
+		{now: time.Date(2024, 1, 1, 10, 0, 0, 0, time.FixedZone("UTC+8", 8*3600)), want: day.Add(3 * time.Hour)},
+	}
+	for _, tt := range tests {
+		if got := m.nextRun(tt.now); !got.Equal(tt.want) {
+			t.Errorf("nextRun(%s) = %s, want %s", tt.now, got, tt.want)
+		}
+	}
+}
+
+// dropRecorder records the retention days of the drops
+type dropRecorder struct {
+	database.MonitorStore
+	calls   chan int
+	dropped int
+	err     error
+}
+
+func (d *dropRecorder) DropMonitorCollectionsOlderThan(days int) (int, error) {
+	d.calls <- days
+	return d.dropped, d.err
+}
+
+func TestMonitorReconciler_startMonitorRetention(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	db := &dropRecorder{calls: make(chan int, 1), dropped: 2}
+	elected := make(chan struct{})
+	r := &MonitorReconciler{
+		Logger:    logr.Discard(),
+		DBClient:  db,
+		stopCh:    make(chan struct{}),
+		retention: &monitorRetention{days: 30, hour: 3, clock: fakeClock, elected: elected},
+	}
+	r.startMonitorRetention()
+	defer func() {
+		close(r.stopCh)
+		r.wg.Wait()
+	}()
+	waitForTimer := func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !fakeClock.HasWaiters() {
+			if time.Now().After(deadline) {
+				t.Fatal("the retention didn't wait for the next run")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	expectDrop := func(want bool) {
+		t.Helper()
+		select {
+		case days := <-db.calls:
+			if !want {
+				t.Fatalf("unexpected drop of %d days", days)
+			}
+			if days != 30 {
+				t.Errorf("drop days = %d, want 30", days)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if want {
+				t.Fatal("the retention didn't drop at the retention hour")
+			}
+		}
+	}
+
+	// the replica is not the leader yet, nothing is scheduled
+	fakeClock.Step(48 * time.Hour)
+	expectDrop(false)
+	if fakeClock.HasWaiters() {
+		t.Fatal("the retention is scheduled before the replica is elected")
+	}
+
+	close(elected)
+	waitForTimer()
+	// 2024-01-03 10:00, the next run is 2024-01-04 03:00
+	dropped := testutil.ToFloat64(monitorRetentionDropped)
+	fakeClock.Step(17*time.Hour - time.Minute)
+	expectDrop(false)
+	fakeClock.Step(time.Minute)
+	expectDrop(true)
+	waitForTimer()
+	if got := testutil.ToFloat64(monitorRetentionDropped) - dropped; got != 2 {
+		t.Errorf("dropped metric increased by %v, want 2", got)
+	}
+
+	// a failed drop is retried the next day
+	failures := testutil.ToFloat64(monitorRetentionRuns.WithLabelValues("failure"))
+	db.err = errors.New("mongo down")
+	fakeClock.Step(24 * time.Hour)
+	expectDrop(true)
+	waitForTimer()
+	if got := testutil.ToFloat64(monitorRetentionRuns.WithLabelValues("failure")) - failures; got != 1 {
+		t.Errorf("failure runs increased by %v, want 1", got)
+	}
+}
+
+func TestParseMonitorRetentionMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		days    int
+		wantErr bool
+	}{
+		{mode: MonitorRetentionModeJob, days: 30},
+		{mode: MonitorRetentionModeJob, days: 0},
+		{mode: MonitorRetentionModeTTL, days: 30},
+		{mode: MonitorRetentionModeTTL, days: 0, wantErr: true},
+		{mode: "index", days: 30, wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := parseMonitorRetentionMode(tt.mode, tt.days); (err != nil) != tt.wantErr {
+			t.Errorf("parseMonitorRetentionMode(%q, %d) error = %v, wantErr %v", tt.mode, tt.days, err, tt.wantErr)
+		}
+	}
+}
+
+// ttlRecorder records the TTL days set besides the drops
+type ttlRecorder struct {
+	dropRecorder
+	ttls chan int
+}
+
+func (d *ttlRecorder) SetMonitorTTL(_ context.Context, days int) (int, error) {
+	d.ttls <- days
+	return 1, nil
+}
+
+func TestMonitorReconciler_startMonitorRetention_mode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		days     int
+		wantTTLs []int
+		wantDrop bool
+	}{
+		// the ttl is set at the start and again at the retention hour instead of the drop
+		{name: "ttl", mode: MonitorRetentionModeTTL, days: 30, wantTTLs: []int{30, 30}},
+		// switching back to the job removes the ttl at the start
+		{name: "job", mode: MonitorRetentionModeJob, days: 30, wantTTLs: []int{0}, wantDrop: true},
+		{name: "never drop", mode: MonitorRetentionModeJob, days: 0, wantTTLs: []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClock := testingclock.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+			db := &ttlRecorder{dropRecorder: dropRecorder{calls: make(chan int, 1)}, ttls: make(chan int, 2)}
+			r := &MonitorReconciler{
+				Logger: logr.Discard(),
+				// the ttl of the primary is set
+				DBClient:  NewDualWriteStore(db, databasetest.NewMemoryStore(), logr.Discard()),
+				stopCh:    make(chan struct{}),
+				retention: &monitorRetention{days: tt.days, hour: 3, mode: tt.mode, clock: fakeClock},
+			}
+			r.startMonitorRetention()
+			defer func() {
+				close(r.stopCh)
+				r.wg.Wait()
+			}()
+			expectTTL := func(want int) {
+				t.Helper()
+				select {
+				case days := <-db.ttls:
+					if days != want {
+						t.Errorf("ttl days = %d, want %d", days, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("the retention didn't set the ttl")
+				}
+			}
+			expectTTL(tt.wantTTLs[0])
+			if tt.days == 0 {
+				time.Sleep(10 * time.Millisecond)
+				if fakeClock.HasWaiters() {
+					t.Fatal("the retention of 0 days is scheduled")
+				}
+				return
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for !fakeClock.HasWaiters() {
+				if time.Now().After(deadline) {
+					t.Fatal("the retention didn't wait for the next run")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			fakeClock.Step(17 * time.Hour)
+			if len(tt.wantTTLs) > 1 {
+				expectTTL(tt.wantTTLs[1])
+			}
+			select {
+			case days := <-db.calls:
+				if !tt.wantDrop {
+					t.Errorf("unexpected drop of %d days in the %s mode", days, tt.mode)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantDrop {
+					t.Error("the retention didn't drop at the retention hour")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/logger"
+)
+
+const (
+	// MonitorRollupAge rolls the minute monitors older than the age up into hourly sums and deletes them, eg: 72h, disabled if not set
+	MonitorRollupAge = "MONITOR_ROLLUP_AGE"
+	// MonitorRollupLookback the hours before the age checked by each run, the hours already rolled up are skipped, default 24h
+	MonitorRollupLookback = "MONITOR_ROLLUP_LOOKBACK"
+
+	DefaultMonitorRollupLookback = 24 * time.Hour
+)
+
+type monitorRollupKey struct {
+	category string
+	_type    uint8
+	name     string
+}
+
+// monitorRollup sums the minute monitors of an hour per namespace, type, name and resource.
+// The utilization is averaged over the monitors with it, the latest property, bucket detail and tenant are kept.
+type monitorRollup struct {
+	hour     time.Time
+	rollups  map[monitorRollupKey]*resources.Monitor
+	latest   map[monitorRollupKey]time.Time
+	utilSum  map[monitorRollupKey]map[uint8]int64
+	utilSeen map[monitorRollupKey]map[uint8]int64
+}
+
+func newMonitorRollup(hour time.Time) *monitorRollup {
+	return &monitorRollup{
+		hour:     hour.UTC(),
+		rollups:  make(map[monitorRollupKey]*resources.Monitor),
+		latest:   make(map[monitorRollupKey]time.Time),
+		utilSum:  make(map[monitorRollupKey]map[uint8]int64),
+		utilSeen: make(map[monitorRollupKey]map[uint8]int64),
+	}
+}
+
+func (r *monitorRollup) add(monitor *resources.Monitor) {
+	key := monitorRollupKey{category: monitor.Category, _type: monitor.Type, name: monitor.Name}
+	rollup, ok := r.rollups[key]
+	if !ok {
+		rollup = &resources.Monitor{Time: r.hour, Category: monitor.Category, Type: monitor.Type, Name: monitor.Name, Used: resources.EnumUsedMap{}}
+		r.rollups[key] = rollup
+		r.utilSum[key], r.utilSeen[key] = make(map[uint8]int64), make(map[uint8]int64)
+	}
+	for enum, used := range monitor.Used {
+		rollup.Used[enum] += used
+	}
+	for enum, util := range monitor.Utilization {
+		r.utilSum[key][enum] += util
+		r.utilSeen[key][enum]++
+	}
+	// the monitors routed to the group collections share the time, the later one fills the missing metadata
+	if !monitor.Time.Before(r.latest[key]) {
+		r.latest[key] = monitor.Time
+		if monitor.Property != "" {
+			rollup.Property = monitor.Property
+		}
+		if monitor.ObjStorage != nil {
+			rollup.ObjStorage = monitor.ObjStorage
+		}
+		if len(monitor.Tenant) > 0 {
+			rollup.Tenant = monitor.Tenant
+		}
+		if len(monitor.Labels) > 0 {
+			rollup.Labels = monitor.Labels
+		}
+	}
+}
+
+// result returns the hourly rollups sorted by the namespace, type and name
+func (r *monitorRollup) result() []*resources.Monitor {
+	rollups := make([]*resources.Monitor, 0, len(r.rollups))
+	for key, rollup := range r.rollups {
+		if len(r.utilSeen[key]) > 0 {
+			rollup.Utilization = resources.EnumUsedMap{}
+			for enum, seen := range r.utilSeen[key] {
+				rollup.Utilization[enum] = r.utilSum[key][enum] / seen
+			}
+		}
+		rollups = append(rollups, rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		a, b := rollups[i], rollups[j]
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Name < b.Name
+	})
+	return rollups
+}
+
+// rollupHour saves the hourly rollups of the hour unless it's done, then deletes the minute monitors of the hour.
+// A partial run is resumed without double counting: the rollups are only computed before the hour is marked done,
+// and the minute monitors are only deleted after that, so a rerun either replaces the same rollups or only deletes.
+func (r *MonitorReconciler) rollupHour(ctx context.Context, hour time.Time) (rolled bool, deleted int64, err error) {
+	done, err := r.DBClient.IsMonitorRollupDone(ctx, hour)
+	if err != nil {
+		return false, 0, err
+	}
+	if !done {
+		rollup := newMonitorRollup(hour)
+		if err = r.DBClient.QueryMonitorsInRange(ctx, hour, hour.Add(time.Hour), func(monitor *resources.Monitor) error {
+			rollup.add(monitor)
+			return nil
+		}); err != nil {
+			return false, 0, fmt.Errorf("failed to query the monitors of %s: %w", hour.Format(time.RFC3339), err)
+		}
+		if err = r.DBClient.SaveMonitorRollups(ctx, hour, rollup.result()); err != nil {
+			return false, 0, fmt.Errorf("failed to save the rollups of %s: %w", hour.Format(time.RFC3339), err)
+		}
+	}
+	deleted, err = r.DBClient.DeleteMonitorsInRange(ctx, hour, hour.Add(time.Hour))
+	if err != nil {
+		return !done, deleted, fmt.Errorf("failed to delete the monitors of %s: %w", hour.Format(time.RFC3339), err)
+	}
+	return !done, deleted, nil
+}
+
+// rollupMonitors rolls up the hours in [now - age - lookback, now - age), the hours are processed from the oldest
+func (r *MonitorReconciler) rollupMonitors(now time.Time) error {
+	end := now.UTC().Add(-r.RollupAge).Truncate(time.Hour)
+	var rolled, deleted int64
+	for hour := end.Add(-r.RollupLookback).Truncate(time.Hour); hour.Before(end); hour = hour.Add(time.Hour) {
+		select {
+		case <-r.stopCh:
+			return nil
+		default:
+		}
+		ok, n, err := r.rollupHour(context.Background(), hour)
+		deleted += n
+		if err != nil {
+			return err
+		}
+		if ok {
+			rolled++
+		}
+	}
+	logger.Info("monitor rollup", "end", end.Format(time.RFC3339), "rolled hours", rolled, "deleted monitors", deleted)
+	return nil
+}
+
+func (r *MonitorReconciler) startMonitorRollup() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		for {
+			if err := r.rollupMonitors(time.Now()); err != nil {
+				r.Logger.Error(err, "failed to roll up monitors")
+			}
+			select {
+			case <-ticker.C:
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,178 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMonitorRollup(t *testing.T) {
+	hour := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	detail := &resources.ObjStorageDetail{CreationTime: hour.AddDate(0, -1, 0), Region: "us-east-1"}
+	rollup := newMonitorRollup(hour)
+	for i := 0; i < 60; i++ {
+		at := hour.Add(time.Duration(i) * time.Minute)
+		rollup.add(&resources.Monitor{Time: at, Category: "ns-a", Type: 0, Name: "app", Used: resources.EnumUsedMap{0: 100, 1: 1024}})
+		// the gpu app reports the utilization every other minute
+		gpu := &resources.Monitor{Time: at, Category: "ns-a", Type: 0, Name: "gpu-app", Used: resources.EnumUsedMap{4: 1}}
+		if i%2 == 0 {
+			gpu.Utilization = resources.EnumUsedMap{4: int64(i)}
+		}
+		rollup.add(gpu)
+	}
+	// the routed network part of the bucket shares the time with the storage part
+	rollup.add(&resources.Monitor{Time: hour, Category: "ns-b", Type: 5, Name: "bucket", Used: resources.EnumUsedMap{2: 10}, ObjStorage: detail})
+	rollup.add(&resources.Monitor{Time: hour, Category: "ns-b", Type: 5, Name: "bucket", Used: resources.EnumUsedMap{3: 7}})
+	rollup.add(&resources.Monitor{Time: hour.Add(time.Minute), Category: "ns-b", Type: 5, Name: "bucket", Used: resources.EnumUsedMap{2: 10},
+		Tenant: map[string]string{"plan": "pro"}})
+
+	got := rollup.result()
+	if len(got) != 3 {
+		t.Fatalf("result() = %d rollups, want 3", len(got))
+	}
+	app, gpu, bucket := got[0], got[1], got[2]
+	if app.Name != "app" || !app.Time.Equal(hour) || !reflect.DeepEqual(app.Used, resources.EnumUsedMap{0: 6000, 1: 60 * 1024}) {
+		t.Errorf("app rollup = %+v", app)
+	}
+	// the average of 0, 2, ..., 58
+	if gpu.Name != "gpu-app" || gpu.Used[4] != 60 || gpu.Utilization[4] != 29 {
+		t.Errorf("gpu rollup = %+v, want used 60 and utilization 29", gpu)
+	}
+	if app.Utilization != nil {
+		t.Errorf("app utilization = %v, want nil", app.Utilization)
+	}
+	if !reflect.DeepEqual(bucket.Used, resources.EnumUsedMap{2: 20, 3: 7}) || bucket.ObjStorage != detail || bucket.Tenant["plan"] != "pro" {
+		t.Errorf("bucket rollup = %+v", bucket)
+	}
+}
+
+// fakeRollupDB keeps the minute monitors and the rollups in memory, the calls fail once if the error is set
+type fakeRollupDB struct {
+	database.MonitorStore
+	monitors  []*resources.Monitor
+	rollups   map[time.Time][]*resources.Monitor
+	saveErr   error
+	deleteErr error
+}
+
+func (f *fakeRollupDB) QueryMonitorsInRange(_ context.Context, startTime, endTime time.Time, handle func(monitor *resources.Monitor) error) error {
+	for _, monitor := range f.monitors {
+		if !monitor.Time.Before(startTime) && monitor.Time.Before(endTime) {
+			m := *monitor
+			if err := handle(&m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeRollupDB) IsMonitorRollupDone(_ context.Context, hour time.Time) (bool, error) {
+	_, ok := f.rollups[hour]
+	return ok, nil
+}
+
+func (f *fakeRollupDB) SaveMonitorRollups(_ context.Context, hour time.Time, rollups []*resources.Monitor) error {
+	if err := f.saveErr; err != nil {
+		f.saveErr = nil
+		return err
+	}
+	f.rollups[hour] = rollups
+	return nil
+}
+
+func (f *fakeRollupDB) DeleteMonitorsInRange(_ context.Context, startTime, endTime time.Time) (int64, error) {
+	var kept []*resources.Monitor
+	var deleted int64
+	for i, monitor := range f.monitors {
+		if !monitor.Time.Before(startTime) && monitor.Time.Before(endTime) {
+			// fail in the middle of the delete
+			if f.deleteErr != nil && deleted == 10 {
+				err := f.deleteErr
+				f.deleteErr = nil
+				f.monitors = append(kept, f.monitors[i:]...)
+				return deleted, err
+			}
+			deleted++
+			continue
+		}
+		kept = append(kept, monitor)
+	}
+	f.monitors = kept
+	return deleted, nil
+}
+
+func TestMonitorReconciler_rollupHour(t *testing.T) {
+	hour := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	db := &fakeRollupDB{rollups: map[time.Time][]*resources.Monitor{}}
+	for i := 0; i < 60; i++ {
+		db.monitors = append(db.monitors, &resources.Monitor{Time: hour.Add(time.Duration(i) * time.Minute), Category: "ns-a", Name: "app", Used: resources.EnumUsedMap{0: 100}})
+	}
+	// the next hour is not touched
+	db.monitors = append(db.monitors, &resources.Monitor{Time: hour.Add(time.Hour), Category: "ns-a", Name: "app", Used: resources.EnumUsedMap{0: 100}})
+	r := &MonitorReconciler{DBClient: db}
+
+	// the save failed, the minute monitors are kept
+	db.saveErr = errors.New("write failed")
+	if _, _, err := r.rollupHour(context.Background(), hour); err == nil {
+		t.Fatal("rollupHour() expected the save error")
+	}
+	if len(db.monitors) != 61 {
+		t.Fatalf("%d monitors after the failed save, want 61", len(db.monitors))
+	}
+	// the delete failed in the middle, the rollup is saved
+	db.deleteErr = errors.New("delete failed")
+	if _, _, err := r.rollupHour(context.Background(), hour); err == nil {
+		t.Fatal("rollupHour() expected the delete error")
+	}
+	// the rerun only deletes the rest, the rollup is not recomputed from the remaining monitors
+	rolled, deleted, err := r.rollupHour(context.Background(), hour)
+	if err != nil || rolled || deleted != 50 {
+		t.Fatalf("rollupHour() rerun = %v, %d, %v, want only the 50 remaining monitors deleted", rolled, deleted, err)
+	}
+	if rollups := db.rollups[hour]; len(rollups) != 1 || rollups[0].Used[0] != 6000 {
+		t.Errorf("rollups = %+v, want 6000 once", rollups)
+	}
+	if len(db.monitors) != 1 || !db.monitors[0].Time.Equal(hour.Add(time.Hour)) {
+		t.Errorf("%d monitors left, want the monitor of the next hour", len(db.monitors))
+	}
+}
+
+func TestMonitorReconciler_rollupMonitors(t *testing.T) {
+	now := time.Date(2024, 3, 4, 10, 30, 0, 0, time.UTC)
+	db := &fakeRollupDB{rollups: map[time.Time][]*resources.Monitor{}}
+	for h := 0; h < 96; h++ {
+		db.monitors = append(db.monitors, &resources.Monitor{Time: now.Add(-time.Duration(h) * time.Hour), Category: "ns-a", Name: "app", Used: resources.EnumUsedMap{0: 1}})
+	}
+	r := &MonitorReconciler{DBClient: db, RollupAge: 72 * time.Hour, RollupLookback: 2 * time.Hour, stopCh: make(chan struct{})}
+	if err := r.rollupMonitors(now); err != nil {
+		t.Fatal(err)
+	}
+	// the hours 08:00 and 09:00 three days ago, the hour of 10:00 is not entirely older than the age
+	end := now.Add(-72 * time.Hour).Truncate(time.Hour)
+	if len(db.rollups) != 2 || db.rollups[end.Add(-time.Hour)] == nil || db.rollups[end.Add(-2*time.Hour)] == nil {
+		t.Errorf("rolled up hours = %v, want the 2 hours before %s", len(db.rollups), end)
+	}
+	if len(db.monitors) != 94 {
+		t.Errorf("%d minute monitors left, want 94", len(db.monitors))
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	// DualWriteVerifyPath compares the monitors of the primary and the secondary monitor database
+	DualWriteVerifyPath = "/api/v1/admin/dual-write/verify"
+
+	// dualWriteVerifySettle skips the latest minutes of the sampled ranges, their inserts may still be in flight
+	dualWriteVerifySettle = 5 * time.Minute
+	// dualWriteVerifyLookback the sampled ranges are in the lookback before the settle
+	dualWriteVerifyLookback = 6 * time.Hour
+	// dualWriteVerifySpan the length of a sampled range
+	dualWriteVerifySpan = 10 * time.Minute
+	// dualWriteVerifySamples the number of the ranges sampled by default
+	dualWriteVerifySamples = 5
+	// maxDualWriteDiscrepancies bounds the discrepancies listed in a report, all are counted
+	maxDualWriteDiscrepancies = 100
+)
+
+var monitorSinkDivergence = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sealos_resources_monitor_sink_divergence_total",
+	Help: "Number of the monitors written to the primary monitor database but failed to write to the secondary by the operation.",
+}, []string{"operation"})
+
+func init() {
+	metrics.Registry.MustRegister(monitorSinkDivergence)
+}
+
+// DualWriteStore writes the monitors to the primary and the secondary monitor database at the same time, eg: during the
+// migration from mongo to clickhouse. The primary is the source of truth: its failures fail the write as before, the
+// failures of the secondary are only logged and counted as the divergence. The reads, the rollups, the aggregates and
+// the retention use the primary only.
+type DualWriteStore struct {
+	database.MonitorStore
+	Secondary database.MonitorStore
+	Logger    logr.Logger
+}
+
+var (
+	_ database.MonitorStore            = &DualWriteStore{}
+	_ database.DetailedMonitorInserter = &DualWriteStore{}
+	_ database.Pinger                  = &DualWriteStore{}
+)
+
+// NewDualWriteStore returns the primary if the secondary is nil
+func NewDualWriteStore(primary, secondary database.MonitorStore, logger logr.Logger) database.MonitorStore {
+	if secondary == nil {
+		return primary
+	}
+	return &DualWriteStore{MonitorStore: primary, Secondary: secondary, Logger: logger.WithName("dual-write")}
+}
+
+// mirror writes to the primary and the secondary concurrently, and returns the error of the primary
+func (s *DualWriteStore) mirror(operation string, monitors int, primary, secondary func() error) error {
+	var wg sync.WaitGroup
+	var secondaryErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		secondaryErr = secondary()
+	}()
+	err := primary()
+	wg.Wait()
+	s.observe(operation, monitors, secondaryErr)
+	return err
+}
+
+func (s *DualWriteStore) observe(operation string, monitors int, err error) {
+	if err == nil {
+		return
+	}
+	monitorSinkDivergence.WithLabelValues(operation).Add(float64(monitors))
+	s.Logger.Error(err, "failed to write the monitors to the secondary monitor database", "operation", operation, "monitors", monitors)
+}
+
+func (s *DualWriteStore) InsertMonitor(ctx context.Context, monitors ...*resources.Monitor) error {
+	return s.mirror("insert", len(monitors),
+		func() error { return s.MonitorStore.InsertMonitor(ctx, monitors...) },
+		func() error { return s.Secondary.InsertMonitor(ctx, monitors...) })
+}
+
+func (s *DualWriteStore) InsertMonitorBatch(ctx context.Context, monitors []*resources.Monitor) error {
+	return s.mirror("insert_batch", len(monitors),
+		func() error { return s.MonitorStore.InsertMonitorBatch(ctx, monitors) },
+		func() error { return s.Secondary.InsertMonitorBatch(ctx, monitors) })
+}
+
+func (s *DualWriteStore) ReplaceMonitors(ctx context.Context, monitors ...*resources.Monitor) error {
+	return s.mirror("replace", len(monitors),
+		func() error { return s.MonitorStore.ReplaceMonitors(ctx, monitors...) },
+		func() error { return s.Secondary.ReplaceMonitors(ctx, monitors...) })
+}
+
+// InsertMonitorDetailed reports the failed monitors of the primary, the secondary is written all or nothing. The monitors
+// failed by the primary are retried by the writer and written to the secondary again, the stores skip the duplicate ids.
+func (s *DualWriteStore) InsertMonitorDetailed(ctx context.Context, monitors ...*resources.Monitor) database.MonitorInsertResults {
+	var results database.MonitorInsertResults
+	_ = s.mirror("insert", len(monitors),
+		func() error {
+			results = database.InsertMonitorDetailed(ctx, s.MonitorStore, monitors...)
+			return nil
+		},
+		func() error { return s.Secondary.InsertMonitor(ctx, monitors...) })
+	return results
+}
+
+// Ping pings the primary, the secondary doesn't affect the readiness
+func (s *DualWriteStore) Ping(ctx context.Context) error {
+	if pinger, ok := s.MonitorStore.(database.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Reconnect reconnects the primary if it supports reconnecting
+func (s *DualWriteStore) Reconnect(ctx context.Context) error {
+	if reconnector, ok := s.MonitorStore.(monitorDBReconnector); ok {
+		return reconnector.Reconnect(ctx)
+	}
+	return fmt.Errorf("the primary monitor database doesn't support reconnecting")
+}
+
+func (s *DualWriteStore) Disconnect(ctx context.Context) error {
+	if err := s.Secondary.Disconnect(ctx); err != nil {
+		s.Logger.Error(err, "failed to disconnect the secondary monitor database")
+	}
+	return s.MonitorStore.Disconnect(ctx)
+}
+
+// DualWriteDiscrepancy a monitor differing between the primary and the secondary
+type DualWriteDiscrepancy struct {
+	// Kind missing_in_secondary, missing_in_primary or used_mismatch
+	Kind      string                `json:"kind"`
+	Time      time.Time             `json:"time"`
+	Category  string                `json:"category"`
+	Type      uint8                 `json:"type"`
+	Name      string                `json:"name"`
+	Property  string                `json:"property,omitempty"`
+	Primary   resources.EnumUsedMap `json:"primary,omitempty"`
+	Secondary resources.EnumUsedMap `json:"secondary,omitempty"`
+}
+
+// DualWriteReport the comparison of the monitors of the ranges, the discrepancies are listed up to maxDualWriteDiscrepancies
+type DualWriteReport struct {
+	Ranges             [][2]time.Time         `json:"ranges"`
+	Primary            int                    `json:"primary"`
+	Secondary          int                    `json:"secondary"`
+	MissingInSecondary int                    `json:"missingInSecondary"`
+	MissingInPrimary   int                    `json:"missingInPrimary"`
+	UsedMismatch       int                    `json:"usedMismatch"`
+	Discrepancies      []DualWriteDiscrepancy `json:"discrepancies,omitempty"`
+}
+
+// Consistent returns true if no discrepancy was found
+func (r *DualWriteReport) Consistent() bool {
+	return r.MissingInSecondary == 0 && r.MissingInPrimary == 0 && r.UsedMismatch == 0
+}
+
+func (r *DualWriteReport) add(kind string, monitor *resources.Monitor, primary, secondary resources.EnumUsedMap) {
+	switch kind {
+	case "missing_in_secondary":
+		r.MissingInSecondary++
+	case "missing_in_primary":
+		r.MissingInPrimary++
+	default:
+		r.UsedMismatch++
+	}
+	if len(r.Discrepancies) < maxDualWriteDiscrepancies {
+		r.Discrepancies = append(r.Discrepancies, DualWriteDiscrepancy{Kind: kind, Time: monitor.Time.UTC(), Category: monitor.Category,
+			Type: monitor.Type, Name: monitor.Name, Property: monitor.Property, Primary: primary, Secondary: secondary})
+	}
+}
+
+// Verify compares the minute monitors of the primary and the secondary in the ranges by the monitor ids
+func (s *DualWriteStore) Verify(ctx context.Context, ranges [][2]time.Time) (*DualWriteReport, error) {
+	report := &DualWriteReport{Ranges: ranges}
+	for _, rg := range ranges {
+		primary, err := s.queryRange(ctx, s.MonitorStore, rg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query the primary monitor database: %w", err)
+		}
+		secondary, err := s.queryRange(ctx, s.Secondary, rg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query the secondary monitor database: %w", err)
+		}
+		report.Primary += len(primary)
+		report.Secondary += len(secondary)
+		for _, id := range sortedMonitorIDs(primary) {
+			p := primary[id]
+			sec, ok := secondary[id]
+			switch {
+			case !ok:
+				report.add("missing_in_secondary", p, p.Used, nil)
+			case !equalUsed(p.Used, sec.Used):
+				report.add("used_mismatch", p, p.Used, sec.Used)
+			}
+		}
+		for _, id := range sortedMonitorIDs(secondary) {
+			if _, ok := primary[id]; !ok {
+				report.add("missing_in_primary", secondary[id], nil, secondary[id].Used)
+			}
+		}
+	}
+	return report, nil
+}
+
+// VerifyRecent verifies the samples ranges of dualWriteVerifySpan picked at random in the recent lookback
+func (s *DualWriteStore) VerifyRecent(ctx context.Context, now time.Time, samples int) (*DualWriteReport, error) {
+	latest := now.UTC().Truncate(time.Minute).Add(-dualWriteVerifySettle - dualWriteVerifySpan)
+	starts := int64((dualWriteVerifyLookback - dualWriteVerifySpan) / time.Minute)
+	ranges := make([][2]time.Time, 0, samples)
+	for i := 0; i < samples; i++ {
+		start := latest.Add(-time.Duration(rand.Int63n(starts+1)) * time.Minute)
+		ranges = append(ranges, [2]time.Time{start, start.Add(dualWriteVerifySpan)})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0].Before(ranges[j][0]) })
+	return s.Verify(ctx, ranges)
+}
+
+func (s *DualWriteStore) queryRange(ctx context.Context, store database.MonitorStore, rg [2]time.Time) (map[string]*resources.Monitor, error) {
+	monitors := map[string]*resources.Monitor{}
+	err := store.QueryMonitorsInRange(ctx, rg[0], rg[1], func(monitor *resources.Monitor) error {
+		m := *monitor
+		monitors[resources.MonitorID(&m)] = &m
+		return nil
+	})
+	return monitors, err
+}
+
+func sortedMonitorIDs(monitors map[string]*resources.Monitor) []string {
+	ids := make([]string, 0, len(monitors))
+	for id := range monitors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equalUsed(a, b resources.EnumUsedMap) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for enum, used := range a {
+		if other, ok := b[enum]; !ok || other != used {
+			return false
+		}
+	}
+	return true
+}
+
+// dualWriteVerifyHandler compares the monitors of the ranges of the primary and the secondary as json, the range of
+// start and end if set, otherwise the ranges sampled in the last hours.
+// eg: GET /api/v1/admin/dual-write/verify?start=2024-01-01T00:00:00Z&end=2024-01-01T01:00:00Z or ?samples=10
+func (r *MonitorReconciler) dualWriteVerifyHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	store, ok := r.DBClient.(*DualWriteStore)
+	if !ok {
+		http.Error(w, "the dual write is not enabled, please check env: "+database.MonitorSecondaryDBDriver, http.StatusNotFound)
+		return
+	}
+	query := req.URL.Query()
+	var report *DualWriteReport
+	var err error
+	if query.Get("start") != "" || query.Get("end") != "" {
+		var startTime, endTime time.Time
+		if startTime, endTime, err = parseTimeRange(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, err = store.Verify(req.Context(), [][2]time.Time{{startTime, endTime}})
+	} else {
+		samples := dualWriteVerifySamples
+		if value := query.Get("samples"); value != "" {
+			if samples, err = strconv.Atoi(value); err != nil || samples <= 0 {
+				http.Error(w, "samples must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+		report, err = store.VerifyRecent(req.Context(), time.Now(), samples)
+	}
+	if err != nil {
+		r.Logger.Error(err, "failed to verify the dual write")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		r.Logger.Error(err, "failed to write the dual write report")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func sinkMonitors(start time.Time, n int) []*resources.Monitor {
+	monitors := make([]*resources.Monitor, 0, n)
+	for i := 0; i < n; i++ {
+		monitors = append(monitors, &resources.Monitor{Time: start.Add(time.Duration(i) * time.Minute), Category: "ns-user-a",
+			Type: resources.AppType[resources.APP], Name: "app-a", Used: resources.EnumUsedMap{0: int64(100 + i)}})
+	}
+	return monitors
+}
+
+func TestDualWriteStore_InsertMonitor(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	primaryErr := errors.New("primary down")
+	tests := []struct {
+		name           string
+		primaryFails   bool
+		secondaryFails bool
+		wantErr        error
+		wantDivergence float64
+	}{
+		{name: "both succeed"},
+		{name: "secondary fails", secondaryFails: true, wantDivergence: 3},
+		{name: "primary fails", primaryFails: true, wantErr: primaryErr},
+		{name: "both fail", primaryFails: true, secondaryFails: true, wantErr: primaryErr, wantDivergence: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, secondary := databasetest.NewMemoryStore(), databasetest.NewMemoryStore()
+			if tt.primaryFails {
+				primary.FailNext(1, primaryErr)
+			}
+			if tt.secondaryFails {
+				secondary.FailNext(1, nil)
+			}
+			store := NewDualWriteStore(primary, secondary, logr.Discard())
+			before := testutil.ToFloat64(monitorSinkDivergence.WithLabelValues("insert"))
+			err := store.InsertMonitor(context.Background(), sinkMonitors(start, 3)...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("InsertMonitor() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := testutil.ToFloat64(monitorSinkDivergence.WithLabelValues("insert")) - before; got != tt.wantDivergence {
+				t.Errorf("divergence = %v, want %v", got, tt.wantDivergence)
+			}
+			if wantPrimary := map[bool]int{true: 0, false: 3}[tt.primaryFails]; len(primary.Monitors()) != wantPrimary {
+				t.Errorf("primary monitors = %d, want %d", len(primary.Monitors()), wantPrimary)
+			}
+			if wantSecondary := map[bool]int{true: 0, false: 3}[tt.secondaryFails]; len(secondary.Monitors()) != wantSecondary {
+				t.Errorf("secondary monitors = %d, want %d", len(secondary.Monitors()), wantSecondary)
+			}
+		})
+	}
+}
+
+func TestNewDualWriteStore_noSecondary(t *testing.T) {
+	primary := databasetest.NewMemoryStore()
+	if store := NewDualWriteStore(primary, nil, logr.Discard()); store != primary {
+		t.Errorf("NewDualWriteStore() = %T, want the primary", store)
+	}
+}
+
+func TestDualWriteStore_Verify(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	primary, secondary := databasetest.NewMemoryStore(), databasetest.NewMemoryStore()
+	store := NewDualWriteStore(primary, secondary, logr.Discard()).(*DualWriteStore)
+	if err := store.InsertMonitor(ctx, sinkMonitors(start, 5)...); err != nil {
+		t.Fatal(err)
+	}
+	ranges := [][2]time.Time{{start, start.Add(10 * time.Minute)}}
+	report, err := store.Verify(ctx, ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent() || report.Primary != 5 || report.Secondary != 5 {
+		t.Fatalf("Verify() of the consistent writes = %+v", report)
+	}
+
+	// the insert lost by the secondary and a monitor written by the secondary only
+	secondary.FailNext(1, nil)
+	if err := store.InsertMonitor(ctx, sinkMonitors(start.Add(5*time.Minute), 1)...); err != nil {
+		t.Fatal(err)
+	}
+	extra := sinkMonitors(start.Add(6*time.Minute), 1)
+	if err := secondary.InsertMonitor(ctx, extra...); err != nil {
+		t.Fatal(err)
+	}
+	// the same id with another usage
+	mismatch := sinkMonitors(start.Add(7*time.Minute), 1)
+	if err := primary.InsertMonitor(ctx, mismatch...); err != nil {
+		t.Fatal(err)
+	}
+	mismatch[0].Used = resources.EnumUsedMap{0: 1}
+	if err := secondary.InsertMonitor(ctx, mismatch...); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = store.Verify(ctx, ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Consistent() || report.MissingInSecondary != 1 || report.MissingInPrimary != 1 || report.UsedMismatch != 1 {
+		t.Fatalf("Verify() = %+v, want one discrepancy of each kind", report)
+	}
+	kinds := map[string]time.Time{}
+	for _, discrepancy := range report.Discrepancies {
+		kinds[discrepancy.Kind] = discrepancy.Time
+	}
+	want := map[string]time.Time{
+		"missing_in_secondary": start.Add(5 * time.Minute),
+		"missing_in_primary":   start.Add(6 * time.Minute),
+		"used_mismatch":        start.Add(7 * time.Minute),
+	}
+	for kind, at := range want {
+		if got, ok := kinds[kind]; !ok || !got.Equal(at) {
+			t.Errorf("discrepancy %s at %v, want %v", kind, got, at)
+		}
+	}
+}
+
+func TestDualWriteStore_VerifyRecent(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	store := NewDualWriteStore(databasetest.NewMemoryStore(), databasetest.NewMemoryStore(), logr.Discard()).(*DualWriteStore)
+	report, err := store.VerifyRecent(context.Background(), now, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Ranges) != 20 {
+		t.Fatalf("ranges = %d, want 20", len(report.Ranges))
+	}
+	earliest := now.Truncate(time.Minute).Add(-dualWriteVerifySettle - dualWriteVerifyLookback)
+	latest := now.Add(-dualWriteVerifySettle)
+	for _, rg := range report.Ranges {
+		if rg[0].Before(earliest) || rg[1].After(latest) || rg[1].Sub(rg[0]) != dualWriteVerifySpan {
+			t.Errorf("range %v - %v out of the lookback %v - %v", rg[0], rg[1], earliest, latest)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// MonitorTimeTruncation the boundary the time of the resource monitors is truncated to, default the reconcile period (1m)
+const MonitorTimeTruncation = "MONITOR_TIME_TRUNCATION"
+
+// parseMonitorTimeTruncation requires the truncation to divide the reconcile period, a longer truncation would stamp
+// the monitors of consecutive cycles with the same time, and the stores would skip them as duplicates.
+// The period is used if the truncation is 0.
+func parseMonitorTimeTruncation(truncation, period time.Duration) (time.Duration, error) {
+	if truncation == 0 {
+		return period, nil
+	}
+	if truncation < time.Second || truncation%time.Second != 0 || period%truncation != 0 {
+		return 0, fmt.Errorf("invalid %s %s: must be whole seconds dividing the reconcile period %s", MonitorTimeTruncation, truncation, period)
+	}
+	return truncation, nil
+}
+
+// newMonitorTimeTruncationFromEnv returns the truncation of the env, a mistyped value (eg: 30) fails instead of silently
+// changing the time of the monitors, and so their MonitorIDs the stores deduplicate by
+func newMonitorTimeTruncationFromEnv(period time.Duration) (time.Duration, error) {
+	raw := os.Getenv(MonitorTimeTruncation)
+	if raw == "" {
+		return period, nil
+	}
+	truncation, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", MonitorTimeTruncation, raw, err)
+	}
+	return parseMonitorTimeTruncation(truncation, period)
+}
+
+// monitorTime truncates the time to the boundary of MonitorTimeTruncation in UTC, to the minute if it's not set
+func (r *MonitorReconciler) monitorTime(now time.Time) time.Time {
+	truncation := r.MonitorTimeTruncation
+	if truncation <= 0 {
+		truncation = time.Minute
+	}
+	return now.UTC().Truncate(truncation)
+}
+
+// resourceMonitorTime the time of the resource monitors: the start of the cycle, so the monitors of the namespaces
+// metered late in a long cycle share the time of the others instead of the next boundary
+func (r *MonitorReconciler) resourceMonitorTime() time.Time {
+	if !r.cycleTime.IsZero() {
+		return r.cycleTime
+	}
+	return r.monitorTime(time.Now())
+}
@@ -0,0 +1,131 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseMonitorTimeTruncation(t *testing.T) {
+	tests := []struct {
+		truncation time.Duration
+		want       time.Duration
+		wantErr    bool
+	}{
+		{truncation: 0, want: time.Minute},
+		{truncation: time.Minute, want: time.Minute},
+		{truncation: 30 * time.Second, want: 30 * time.Second},
+		{truncation: 15 * time.Second, want: 15 * time.Second},
+		{truncation: 45 * time.Second, wantErr: true},
+		{truncation: 5 * time.Minute, wantErr: true},
+		{truncation: 500 * time.Millisecond, wantErr: true},
+		{truncation: -time.Minute, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMonitorTimeTruncation(tt.truncation, time.Minute)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMonitorTimeTruncation(%s) = %s, %v, want %s, wantErr %v", tt.truncation, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewMonitorTimeTruncationFromEnv(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{raw: "", want: time.Minute},
+		{raw: "30s", want: 30 * time.Second},
+		// the mistyped values don't change the time of the monitors silently
+		{raw: "30", wantErr: true},
+		{raw: "1min", wantErr: true},
+		{raw: "45s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv(MonitorTimeTruncation, tt.raw)
+		got, err := newMonitorTimeTruncationFromEnv(time.Minute)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("newMonitorTimeTruncationFromEnv() of %q = %s, %v, want %s, wantErr %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMonitorReconciler_monitorTime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 30, 47, 123456789, time.FixedZone("UTC+8", 8*3600))
+	tests := []struct {
+		truncation time.Duration
+		want       time.Time
+	}{
+		{want: time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)},
+		{truncation: 30 * time.Second, want: time.Date(2024, 1, 1, 0, 30, 30, 0, time.UTC)},
+		{truncation: time.Second, want: time.Date(2024, 1, 1, 0, 30, 47, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		r := &MonitorReconciler{MonitorTimeTruncation: tt.truncation}
+		if got := r.monitorTime(now); !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("monitorTime() with truncation %s = %s, want %s", tt.truncation, got, tt.want)
+		}
+	}
+}
+
+func TestMonitorReconciler_processNamespaceList_alignedTime(t *testing.T) {
+	namespaces := newTestNamespaces(5)
+	var objects []client.Object
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	for _, namespace := range namespaces {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Name: "app-0", Labels: map[string]string{resources.AppLabelKey: "app"}},
+			Spec:       corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{qosContainer(qosResources("1", "1Gi"), nil)}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		})
+	}
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{
+		Client:                fake.NewClientBuilder().WithObjects(objects...).Build(),
+		Logger:                logr.Discard(),
+		DBClient:              db,
+		Properties:            resources.DefaultPropertyTypeLS,
+		MeteringPolicy:        MeteringPolicyRequests,
+		GpuMeteringPolicy:     GpuMeteringPolicyReservation,
+		MonitorTimeTruncation: 15 * time.Second,
+	}
+	if err := r.processNamespaceList(&corev1.NamespaceList{Items: namespaces}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	monitors := db.Monitors()
+	if len(monitors) != len(namespaces) {
+		t.Fatalf("monitors = %d, want %d", len(monitors), len(namespaces))
+	}
+	// all the namespaces of the cycle share the time of its start
+	for _, monitor := range monitors {
+		if !monitor.Time.Equal(monitors[0].Time) || !monitor.Time.Equal(monitor.Time.Truncate(15*time.Second)) {
+			t.Errorf("monitor of %s at %s, want %s aligned to 15s", monitor.Category, monitor.Time, monitors[0].Time)
+		}
+	}
+	if !r.cycleTime.IsZero() {
+		t.Errorf("cycle time %s kept after the cycle", r.cycleTime)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// MonitorWriteBatchSize coalesces the monitors of the namespaces into bulk inserts of up to the size, default 500, 0 inserts per namespace
+	MonitorWriteBatchSize = "MONITOR_WRITE_BATCH_SIZE"
+	// MonitorWriteLinger the max time a monitor waits in the batch before it is flushed, default 2s
+	MonitorWriteLinger = "MONITOR_WRITE_LINGER"
+	// MonitorWriteFlushers the number of the goroutines flushing the batches, default 2
+	MonitorWriteFlushers = "MONITOR_WRITE_FLUSHERS"
+
+	DefaultMonitorWriteBatchSize = 500
+	DefaultMonitorWriteLinger    = 2 * time.Second
+	DefaultMonitorWriteFlushers  = 2
+)
+
+var (
+	monitorWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_writes_total",
+		Help: "Number of the bulk monitor inserts by the result, partial if some of the monitors failed.",
+	}, []string{"result"})
+	monitorWriteBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sealos_resources_monitor_write_batch_size",
+		Help:    "Number of the monitors of a bulk insert.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	monitorWriteFailedNamespaces = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sealos_resources_monitor_write_failed_namespaces_total",
+		Help: "Number of the namespaces with monitors failed to insert, the namespace is in the log line only.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(monitorWrites, monitorWriteBatchSize, monitorWriteFailedNamespaces)
+}
+
+// monitorWrite the monitors of a namespace waiting for the batch insert, the error of its monitors is sent to done
+type monitorWrite struct {
+	namespace string
+	monitors  []*resources.Monitor
+	done      chan error
+}
+
+// monitorWriter coalesces the monitors submitted by the namespace workers into bulk inserts.
+// A batch is flushed once it holds maxBatch monitors or its first write waited for linger.
+// The monitors of a namespace are never split across batches, and each namespace of a batch gets the errors of its own
+// monitors only, so a poison monitor doesn't fail the other namespaces of the batch.
+type monitorWriter struct {
+	// insert returns the errors of the monitors not inserted by their index, see database.InsertMonitorDetailed
+	insert   func(monitors ...*resources.Monitor) database.MonitorInsertResults
+	maxBatch int
+	linger   time.Duration
+	flushers int
+
+	writes chan *monitorWrite
+	wg     sync.WaitGroup
+	// draining is closed once stopping, the batches are then flushed without waiting for the linger
+	draining  chan struct{}
+	drainOnce sync.Once
+}
+
+func newMonitorWriter(insert func(monitors ...*resources.Monitor) database.MonitorInsertResults, maxBatch int, linger time.Duration, flushers int) *monitorWriter {
+	if flushers <= 0 {
+		flushers = 1
+	}
+	if linger <= 0 {
+		linger = DefaultMonitorWriteLinger
+	}
+	return &monitorWriter{
+		insert:   insert,
+		maxBatch: maxBatch,
+		linger:   linger,
+		flushers: flushers,
+		writes:   make(chan *monitorWrite, maxBatch),
+		draining: make(chan struct{}),
+	}
+}
+
+func (w *monitorWriter) start() {
+	w.wg.Add(w.flushers)
+	for i := 0; i < w.flushers; i++ {
+		go func() {
+			defer w.wg.Done()
+			w.run()
+		}()
+	}
+}
+
+// drain flushes the pending and the later writes without waiting for the linger, so the writes of the monitor queue
+// draining on stop don't each wait for it
+func (w *monitorWriter) drain() {
+	w.drainOnce.Do(func() {
+		close(w.draining)
+	})
+}
+
+// stop flushes the pending writes and waits for the flushers, no write may be submitted after it
+func (w *monitorWriter) stop() {
+	w.drain()
+	close(w.writes)
+	w.wg.Wait()
+}
+
+// write submits the monitors of the namespace and waits for the result of its batch
+func (w *monitorWriter) write(namespace string, monitors []*resources.Monitor) error {
+	if len(monitors) == 0 {
+		return nil
+	}
+	write := &monitorWrite{namespace: namespace, monitors: monitors, done: make(chan error, 1)}
+	w.writes <- write
+	return <-write.done
+}
+
+func (w *monitorWriter) run() {
+	var (
+		batch []*monitorWrite
+		size  int
+		timer *time.Timer
+		// nil until the first write of a batch, a receive from a nil channel blocks forever
+		lingerC <-chan time.Time
+		// nil once draining
+		drainC   = w.draining
+		draining bool
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		lingerC = nil
+		w.flush(batch, size)
+		batch, size = nil, 0
+	}
+	for {
+		select {
+		case write, ok := <-w.writes:
+			if !ok {
+				if len(batch) > 0 {
+					flush()
+				}
+				return
+			}
+			if len(batch) == 0 {
+				timer = time.NewTimer(w.linger)
+				lingerC = timer.C
+			}
+			batch = append(batch, write)
+			size += len(write.monitors)
+			if size >= w.maxBatch || draining {
+				flush()
+			}
+		case <-lingerC:
+			flush()
+		case <-drainC:
+			drainC, draining = nil, true
+			if len(batch) > 0 {
+				flush()
+			}
+		}
+	}
+}
+
+// flush bulk inserts the batch and returns to each namespace of the batch the error of its monitors
+func (w *monitorWriter) flush(batch []*monitorWrite, size int) {
+	monitors := make([]*resources.Monitor, 0, size)
+	for _, write := range batch {
+		monitors = append(monitors, write.monitors...)
+	}
+	monitorWriteBatchSize.Observe(float64(len(monitors)))
+	failed := w.insert(monitors...)
+	switch {
+	case len(failed) == 0:
+		monitorWrites.WithLabelValues("success").Inc()
+	case len(failed) < len(monitors):
+		monitorWrites.WithLabelValues("partial").Inc()
+	default:
+		monitorWrites.WithLabelValues("failed").Inc()
+	}
+	offset := 0
+	for _, write := range batch {
+		results := make(database.MonitorInsertResults)
+		for i := range write.monitors {
+			if err, ok := failed[offset+i]; ok {
+				results[i] = err
+			}
+		}
+		offset += len(write.monitors)
+		err := monitorInsertErr(len(write.monitors), results)
+		if err != nil {
+			monitorWriteFailedNamespaces.Inc()
+		}
+		write.done <- err
+	}
+}
+
+// newMonitorWriterFromEnv returns nil if the batch size is 0, the monitors are then inserted per namespace
+func newMonitorWriterFromEnv(insert func(monitors ...*resources.Monitor) database.MonitorInsertResults) (*monitorWriter, error) {
+	size := env.GetInt64EnvWithDefault(MonitorWriteBatchSize, DefaultMonitorWriteBatchSize)
+	if size <= 0 {
+		return nil, nil
+	}
+	linger, err := env.GetDurationEnvWithDefault(MonitorWriteLinger, DefaultMonitorWriteLinger)
+	if err != nil {
+		return nil, err
+	}
+	return newMonitorWriter(insert, int(size), linger, int(env.GetInt64EnvWithDefault(MonitorWriteFlushers, DefaultMonitorWriteFlushers))), nil
+}
+
+// writeMonitors queues the monitors of the namespace if the async queue is enabled, otherwise waits for the insert
+func (r *MonitorReconciler) writeMonitors(namespace string, monitors []*resources.Monitor) error {
+	if r.monitorQueue != nil {
+		r.monitorQueue.push(namespace, monitors)
+		return nil
+	}
+	return r.insertNamespaceMonitors(namespace, monitors)
+}
+
+// insertNamespaceMonitors inserts the monitors of the namespace through the writer if enabled,
+// the monitors are dropped while the metering is paused
+func (r *MonitorReconciler) insertNamespaceMonitors(namespace string, monitors []*resources.Monitor) error {
+	if r.meteringValve.paused() {
+		return errMeteringPaused
+	}
+	if r.monitorWriter == nil {
+		return r.insertMonitor(monitors...)
+	}
+	return r.monitorWriter.write(namespace, monitors)
+}
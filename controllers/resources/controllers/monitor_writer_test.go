@@ -0,0 +1,243 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+// fakeBatchInsert records the bulk inserts, the monitors of a failing namespace fail
+type fakeBatchInsert struct {
+	mu      sync.Mutex
+	batches [][]*resources.Monitor
+	failing map[string]bool
+}
+
+func (f *fakeBatchInsert) insert(monitors ...*resources.Monitor) database.MonitorInsertResults {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, monitors)
+	results := make(database.MonitorInsertResults)
+	for i, monitor := range monitors {
+		if f.failing[monitor.Category] {
+			results[i] = retry.Permanent(errors.New("write failed"))
+		}
+	}
+	return results
+}
+
+func namespaceMonitors(namespace string, n int) []*resources.Monitor {
+	monitors := make([]*resources.Monitor, n)
+	for i := range monitors {
+		monitors[i] = &resources.Monitor{Category: namespace, Name: fmt.Sprintf("app-%d", i), Used: resources.EnumUsedMap{0: 1}}
+	}
+	return monitors
+}
+
+func TestMonitorWriter_BatchSize(t *testing.T) {
+	db := &fakeBatchInsert{}
+	// the linger never fires, the batches are flushed by the size
+	w := newMonitorWriter(db.insert, 10, time.Hour, 1)
+	w.start()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := w.write(fmt.Sprintf("ns-%d", i), namespaceMonitors(fmt.Sprintf("ns-%d", i), 2)); err != nil {
+				t.Errorf("write() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	w.stop()
+	if len(db.batches) != 1 || len(db.batches[0]) != 10 {
+		t.Fatalf("inserted %d batches, want 1 batch of 10 monitors", len(db.batches))
+	}
+}
+
+func TestMonitorWriter_Linger(t *testing.T) {
+	db := &fakeBatchInsert{}
+	w := newMonitorWriter(db.insert, 500, 20*time.Millisecond, 1)
+	w.start()
+	defer w.stop()
+	start := time.Now()
+	if err := w.write("ns-a", namespaceMonitors("ns-a", 3)); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("write() returned after %s, want flushed by the linger", elapsed)
+	}
+	if err := w.write("ns-b", nil); err != nil || len(db.batches) != 1 {
+		t.Errorf("empty write() = %v with %d batches, want skipped", err, len(db.batches))
+	}
+}
+
+func TestMonitorWriter_FailedBatch(t *testing.T) {
+	db := &fakeBatchInsert{failing: map[string]bool{"ns-bad": true}}
+	w := newMonitorWriter(db.insert, 4, time.Hour, 1)
+	w.start()
+	results := make(map[string]error)
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	write := func(namespace string) {
+		defer wg.Done()
+		err := w.write(namespace, namespaceMonitors(namespace, 2))
+		mu.Lock()
+		results[namespace] = err
+		mu.Unlock()
+	}
+	// ns-bad and ns-a fill the first batch, the error is returned to ns-bad only
+	wg.Add(2)
+	go write("ns-bad")
+	go write("ns-a")
+	wg.Wait()
+	wg.Add(2)
+	go write("ns-b")
+	go write("ns-c")
+	wg.Wait()
+	w.stop()
+	if !retry.IsPermanent(results["ns-bad"]) || results["ns-a"] != nil {
+		t.Errorf("the namespaces of the partially failed batch got %v, %v, want the error of ns-bad only", results["ns-bad"], results["ns-a"])
+	}
+	if results["ns-b"] != nil || results["ns-c"] != nil {
+		t.Errorf("the namespaces of the succeeded batch got %v, %v, want nil", results["ns-b"], results["ns-c"])
+	}
+	if len(db.batches) != 2 {
+		t.Errorf("inserted %d batches, want 2", len(db.batches))
+	}
+}
+
+func TestMonitorWriter_StopFlushes(t *testing.T) {
+	db := &fakeBatchInsert{}
+	w := newMonitorWriter(db.insert, 500, time.Hour, 2)
+	w.start()
+	// submit without waiting for the result, the batch is pending until the stop
+	write := &monitorWrite{namespace: "ns-a", monitors: namespaceMonitors("ns-a", 1), done: make(chan error, 1)}
+	w.writes <- write
+	w.stop()
+	if err := <-write.done; err != nil || len(db.batches) != 1 {
+		t.Errorf("write() = %v with %d batches after stop, want the pending batch flushed", err, len(db.batches))
+	}
+}
+
+func TestMonitorWriter_PoisonMonitor(t *testing.T) {
+	db := &poisonDB{flaky: 1}
+	r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, meteringValve: newMeteringValve(1)}
+	r.monitorWriter = newMonitorWriter(r.insertMonitorDetailed, 6, time.Hour, 1)
+	r.monitorWriter.start()
+	writes := map[string][]string{
+		"ns-a": {"app-a", "app-poison"},
+		"ns-b": {"app-b", "app-flaky"},
+		"ns-c": {"app-c", "app-d"},
+	}
+	results := make(map[string]error)
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	// the 6 monitors of the namespaces fill one batch
+	for namespace, names := range writes {
+		var monitors []*resources.Monitor
+		for _, name := range names {
+			monitors = append(monitors, &resources.Monitor{Category: namespace, Name: name})
+		}
+		wg.Add(1)
+		go func(namespace string) {
+			defer wg.Done()
+			err := r.insertNamespaceMonitors(namespace, monitors)
+			mu.Lock()
+			results[namespace] = err
+			mu.Unlock()
+		}(namespace)
+	}
+	wg.Wait()
+	r.monitorWriter.stop()
+	if len(db.tries) != 2 || len(db.tries[0]) != 6 || len(db.tries[1]) != 1 || db.tries[1][0] != "app-flaky" {
+		t.Fatalf("tries = %v, want one batch then the retry of app-flaky only", db.tries)
+	}
+	if !retry.IsPermanent(results["ns-a"]) {
+		t.Errorf("ns-a error = %v, want the poison monitor reported as permanent", results["ns-a"])
+	}
+	if results["ns-b"] != nil || results["ns-c"] != nil {
+		t.Errorf("ns-b, ns-c errors = %v, %v, want nil", results["ns-b"], results["ns-c"])
+	}
+	if len(db.inserted) != 5 {
+		t.Errorf("inserted = %v, want all the monitors but the poison one", db.inserted)
+	}
+	if r.meteringValve.paused() {
+		t.Error("metering paused by a poison monitor")
+	}
+}
+
+// BenchmarkMonitorWrites compares the number of the db writes of a reconcile cycle of 1000 namespaces,
+// eg: -bench MonitorWrites reports 1000 writes/cycle per namespace, and a few writes/cycle coalesced (3000 monitors / 500).
+func BenchmarkMonitorWrites(b *testing.B) {
+	const namespaces, monitorsPerNamespace = 1000, 3
+	cycle := func(write func(namespace string, monitors []*resources.Monitor) error) {
+		var wg sync.WaitGroup
+		for i := 0; i < namespaces; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				namespace := fmt.Sprintf("ns-%d", i)
+				if err := write(namespace, namespaceMonitors(namespace, monitorsPerNamespace)); err != nil {
+					b.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	b.Run("per-namespace", func(b *testing.B) {
+		var writes int64
+		insert := func(_ ...*resources.Monitor) database.MonitorInsertResults {
+			atomic.AddInt64(&writes, 1)
+			return nil
+		}
+		for i := 0; i < b.N; i++ {
+			cycle(func(_ string, monitors []*resources.Monitor) error {
+				return insert(monitors...).Err()
+			})
+		}
+		b.ReportMetric(float64(writes)/float64(b.N), "writes/cycle")
+	})
+	b.Run("coalesced", func(b *testing.B) {
+		var writes int64
+		w := newMonitorWriter(func(_ ...*resources.Monitor) database.MonitorInsertResults {
+			atomic.AddInt64(&writes, 1)
+			return nil
+		}, DefaultMonitorWriteBatchSize, 10*time.Millisecond, DefaultMonitorWriteFlushers)
+		w.start()
+		defer w.stop()
+		for i := 0; i < b.N; i++ {
+			cycle(w.write)
+		}
+		b.ReportMetric(float64(atomic.LoadInt64(&writes))/float64(b.N), "writes/cycle")
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// NamespaceCacheSyncTimeout bounds each wait for the namespace informer to start, default 1m. The namespaces are
+	// listed from the api server directly until the informer is synced.
+	NamespaceCacheSyncTimeout = "NAMESPACE_CACHE_SYNC_TIMEOUT"
+
+	DefaultNamespaceCacheSyncTimeout = time.Minute
+)
+
+// namespaceInformerRetryInterval the wait before getting the namespace informer again once it failed
+var namespaceInformerRetryInterval = 10 * time.Second
+
+var namespaceListFallbacks = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "sealos_resources_namespace_list_fallbacks_total",
+	Help: "Number of the namespace lists served by the api server directly as the namespace informer is not synced.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(namespaceListFallbacks)
+}
+
+// namespaceCache serves the namespace list from the shared informer of the manager cache, which is kept up to date by
+// a watch instead of listing all the namespaces from the api server each cycle
+type namespaceCache struct {
+	cache       cache.Cache
+	syncTimeout time.Duration
+	// informer the cache.Informer of the namespaces once it's got
+	informer atomic.Value
+}
+
+func newNamespaceCache(c cache.Cache) (*namespaceCache, error) {
+	syncTimeout, err := env.GetDurationEnvWithDefault(NamespaceCacheSyncTimeout, DefaultNamespaceCacheSyncTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceCache{cache: c, syncTimeout: syncTimeout}, nil
+}
+
+// synced returns whether the namespaces of the informer are synced
+func (c *namespaceCache) synced() bool {
+	informer, ok := c.informer.Load().(cache.Informer)
+	return ok && informer.HasSynced()
+}
+
+// startNamespaceCache gets the namespace informer of the manager cache, and gets it again until it's got
+func (r *MonitorReconciler) startNamespaceCache() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), r.namespaceCache.syncTimeout)
+			informer, err := r.namespaceCache.cache.GetInformer(ctx, &corev1.Namespace{})
+			cancel()
+			if err == nil {
+				r.namespaceCache.informer.Store(informer)
+				return
+			}
+			r.Logger.Error(err, "failed to get namespace informer, the namespaces are listed from the api server")
+			select {
+			case <-time.After(namespaceInformerRetryInterval):
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// namespaceReader returns the reader of the namespace list, the namespace informer once synced, otherwise the api server
+func (r *MonitorReconciler) namespaceReader() client.Reader {
+	if r.namespaceCache == nil {
+		return r.Client
+	}
+	if r.namespaceCache.synced() {
+		return r.namespaceCache.cache
+	}
+	if r.APIReader == nil {
+		return r.Client
+	}
+	namespaceListFallbacks.Inc()
+	return r.APIReader
+}
@@ -0,0 +1,99 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// namespaceInformers serves the namespaces of the informer
+type namespaceInformers struct {
+	*informertest.FakeInformers
+	namespaces []corev1.Namespace
+}
+
+func (c *namespaceInformers) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	list.(*corev1.NamespaceList).Items = c.namespaces
+	return nil
+}
+
+func TestMonitorReconciler_getNamespaceList_Cache(t *testing.T) {
+	interval := namespaceInformerRetryInterval
+	namespaceInformerRetryInterval = time.Millisecond
+	defer func() { namespaceInformerRetryInterval = interval }()
+
+	newReconciler := func(informers *informertest.FakeInformers) *MonitorReconciler {
+		return &MonitorReconciler{
+			Client:            fake.NewClientBuilder().Build(),
+			APIReader:         fake.NewClientBuilder().WithObjects(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-listed"}}).Build(),
+			Logger:            logr.Discard(),
+			NamespaceSelector: labels.Everything(),
+			namespaceCache: &namespaceCache{syncTimeout: time.Second, cache: &namespaceInformers{
+				FakeInformers: informers,
+				namespaces:    []corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "ns-cached"}}},
+			}},
+			stopCh: make(chan struct{}),
+		}
+	}
+	list := func(r *MonitorReconciler, want string, wantFallbacks float64) {
+		t.Helper()
+		before := testutil.ToFloat64(namespaceListFallbacks)
+		namespaceList, err := r.getNamespaceList()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(namespaceList.Items) != 1 || namespaceList.Items[0].Name != want {
+			t.Errorf("namespaces = %+v, want %s", namespaceList.Items, want)
+		}
+		if got := testutil.ToFloat64(namespaceListFallbacks) - before; got != wantFallbacks {
+			t.Errorf("fallbacks increased by %v, want %v", got, wantFallbacks)
+		}
+	}
+
+	// the informer fails to start, the namespaces are listed from the api server
+	failing := newReconciler(&informertest.FakeInformers{Error: errors.New("cache not started")})
+	failing.startNamespaceCache()
+	list(failing, "ns-listed", 1)
+	close(failing.stopCh)
+	failing.wg.Wait()
+
+	informers := &informertest.FakeInformers{}
+	informer, err := informers.FakeInformerFor(&corev1.Namespace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReconciler(informers)
+	r.startNamespaceCache()
+	r.wg.Wait()
+	if r.namespaceCache.informer.Load() == nil {
+		t.Fatal("the namespace informer is not got")
+	}
+	// the informer is got but not synced yet
+	list(r, "ns-listed", 1)
+	informer.Synced = true
+	list(r, "ns-cached", 0)
+}
@@ -0,0 +1,89 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestNamespaces(n int) []corev1.Namespace {
+	namespaces := make([]corev1.Namespace, n)
+	for i := range namespaces {
+		namespaces[i] = corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("ns-%d", i)}}
+	}
+	return namespaces
+}
+
+func TestProcessNamespaces_Coverage(t *testing.T) {
+	for _, workers := range []int{0, 1, 4, 100} {
+		t.Run(fmt.Sprintf("workers-%d", workers), func(t *testing.T) {
+			namespaces := newTestNamespaces(50)
+			var mu sync.Mutex
+			seen := map[string]int{}
+			var running, maxRunning int64
+			processNamespaces(context.Background(), namespaces, workers, func(namespace *corev1.Namespace) {
+				cur := atomic.AddInt64(&running, 1)
+				defer atomic.AddInt64(&running, -1)
+				mu.Lock()
+				seen[namespace.Name]++
+				if cur > maxRunning {
+					maxRunning = cur
+				}
+				mu.Unlock()
+			})
+			if len(seen) != len(namespaces) {
+				t.Fatalf("processed %d namespaces, want %d", len(seen), len(namespaces))
+			}
+			for name, count := range seen {
+				if count != 1 {
+					t.Errorf("namespace %s processed %d times, want 1", name, count)
+				}
+			}
+			if limit := int64(workers); limit > 0 && maxRunning > limit {
+				t.Errorf("max concurrent workers = %d, want <= %d", maxRunning, limit)
+			}
+		})
+	}
+}
+
+func TestProcessNamespaces_Cancel(t *testing.T) {
+	namespaces := newTestNamespaces(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	var processed int64
+	processNamespaces(ctx, namespaces, 2, func(namespace *corev1.Namespace) {
+		if atomic.AddInt64(&processed, 1) == 5 {
+			cancel()
+		}
+	})
+	// the in flight namespaces of the workers may still finish after the cancellation
+	if got := atomic.LoadInt64(&processed); got >= int64(len(namespaces)) {
+		t.Errorf("processed %d namespaces after cancel, want < %d", got, len(namespaces))
+	}
+}
+
+func BenchmarkProcessNamespaces(b *testing.B) {
+	namespaces := newTestNamespaces(5000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		processNamespaces(context.Background(), namespaces, DefaultConcurrencyLimit, func(*corev1.Namespace) {})
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// ComputeNamespaceUsage returns the resources the namespace uses now by the property enum, summed over its apps, pvcs
+// and services, eg: for the quota checks of other controllers. It is computed as the metering does, but nothing is
+// written and no state of the metering changes: the object storage, the gpu utilization and the crash looping pods
+// are skipped like by the sub-minute samples, and the monitor policy of the namespace is not applied.
+// It's safe to call concurrently with the reconcile cycles.
+func (r *MonitorReconciler) ComputeNamespaceUsage(namespace *corev1.Namespace) (map[uint8]int64, error) {
+	monitors, err := r.collectMonitors(namespace, r.monitorTime(time.Now()), true)
+	if err != nil {
+		return nil, err
+	}
+	return sumMonitorsUsed(monitors), nil
+}
+
+// sumMonitorsUsed sums the used of the monitors by the property enum
+func sumMonitorsUsed(monitors []*resources.Monitor) map[uint8]int64 {
+	used := make(map[uint8]int64)
+	for _, monitor := range monitors {
+		for enum, v := range monitor.Used {
+			used[enum] += v
+		}
+	}
+	return used
+}
@@ -0,0 +1,99 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMonitorReconciler_ComputeNamespaceUsage(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	newPod := func(name, app, cpu, memory string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: name, Labels: map[string]string{resources.AppLabelKey: app}},
+			Spec:       corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{qosContainer(qosResources(cpu, memory), nil)}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		}
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: "data-app-a-0", Labels: map[string]string{resources.AppLabelKey: "app-a"}},
+		Spec: corev1.PersistentVolumeClaimSpec{Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+		}},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{
+		Client: fake.NewClientBuilder().WithObjects(
+			newPod("app-a-0", "app-a", "1", "1Gi"),
+			newPod("app-a-1", "app-a", "500m", "512Mi"),
+			newPod("app-b-0", "app-b", "250m", "256Mi"),
+			pvc,
+		).Build(),
+		Logger:            logr.Discard(),
+		DBClient:          db,
+		Properties:        resources.DefaultPropertyTypeLS,
+		MeteringPolicy:    MeteringPolicyRequests,
+		GpuMeteringPolicy: GpuMeteringPolicyReservation,
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}
+
+	usage, err := r.ComputeNamespaceUsage(namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := db.Calls("InsertMonitor") + db.Calls("InsertMonitorBatch"); calls != 0 {
+		t.Errorf("ComputeNamespaceUsage() inserted %d times, want read-only", calls)
+	}
+	units := func(name corev1.ResourceName, value string) (uint8, int64) {
+		property := resources.DefaultPropertyTypeLS.StringMap[name.String()]
+		q := resource.MustParse(value)
+		return property.Enum, property.UsedUnits(q.MilliValue())
+	}
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:     "1750m",
+		corev1.ResourceMemory:  "1792Mi",
+		corev1.ResourceStorage: "10Gi",
+	} {
+		enum, want := units(name, value)
+		if usage[enum] != want {
+			t.Errorf("%s usage = %d, want %d", name, usage[enum], want)
+		}
+	}
+
+	// the metering persists the same usage by app
+	if err := r.monitorResourceUsage(namespace); err != nil {
+		t.Fatal(err)
+	}
+	if metered := sumMonitorsUsed(db.Monitors()); len(metered) != len(usage) {
+		t.Fatalf("metered %v, want %v", metered, usage)
+	} else {
+		for enum, used := range usage {
+			if metered[enum] != used {
+				t.Errorf("metered enum %d = %d, want %d as computed", enum, metered[enum], used)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/utils/logger"
+)
+
+const (
+	// ObjStorageTimeout the timeout of each object storage metadata call, eg: listing the buckets, default 10s, 0 means no timeout
+	ObjStorageTimeout = "OBJECT_STORAGE_TIMEOUT"
+	// ObjStorageScanTimeout the timeout of listing the objects of a bucket, default 5m, 0 means no timeout
+	ObjStorageScanTimeout = "OBJECT_STORAGE_SCAN_TIMEOUT"
+	// ObjStorageBreakerThreshold opens the breaker after the consecutive failures, default 5, 0 disables the breaker
+	ObjStorageBreakerThreshold = "OBJECT_STORAGE_BREAKER_THRESHOLD"
+	// ObjStorageBreakerCooldown the number of the cycles the object storage metering is skipped once the breaker is open, default 5
+	ObjStorageBreakerCooldown = "OBJECT_STORAGE_BREAKER_COOLDOWN"
+
+	DefaultObjStorageTimeout          = 10 * time.Second
+	DefaultObjStorageScanTimeout      = 5 * time.Minute
+	DefaultObjStorageBreakerThreshold = 5
+	DefaultObjStorageBreakerCooldown  = 5
+)
+
+var objStorageBreakerOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "sealos_resources_objectstorage_breaker_open",
+	Help: "1 if the object storage metering is skipped because the object storage is unavailable, otherwise 0.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(objStorageBreakerOpen)
+}
+
+// withTimeout returns the context of an object storage call, the timeout is not applied if <= 0
+func withTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// objStorageBreaker skips the object storage metering while the object storage is unavailable,
+// so each namespace doesn't wait for the timeouts of a down MinIO.
+// After threshold consecutive failures the breaker opens and the next cooldown cycles are skipped,
+// then the object storage is probed at the start of a cycle, the breaker closes if the probe succeeds.
+// The methods of a nil breaker allow all calls.
+type objStorageBreaker struct {
+	threshold int
+	cooldown  int
+
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	remaining int
+}
+
+// newObjStorageBreaker returns nil if the threshold <= 0
+func newObjStorageBreaker(threshold, cooldown int) *objStorageBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	if cooldown < 0 {
+		cooldown = 0
+	}
+	return &objStorageBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns false if the breaker is open
+func (b *objStorageBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.open
+}
+
+// record counts the result of an object storage call, the breaker opens once the failures reach the threshold
+func (b *objStorageBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	if b.open {
+		return
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+	b.open, b.remaining = true, b.cooldown
+	objStorageBreakerOpen.Set(1)
+	logger.Error("object storage is unavailable, skip the object storage metering",
+		"consecutive failures", b.failures, "skipped cycles", b.cooldown, "err", err)
+}
+
+// startCycle counts down the skipped cycles of an open breaker and probes the object storage once the cooldown is over,
+// it's called before the namespaces of a cycle are processed.
+func (b *objStorageBreaker) startCycle(probe func() error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return
+	}
+	if b.remaining > 0 {
+		b.remaining--
+		return
+	}
+	if err := probe(); err != nil {
+		b.remaining = b.cooldown
+		logger.Error("object storage is still unavailable", "skipped cycles", b.cooldown, "err", err)
+		return
+	}
+	b.open, b.failures = false, 0
+	objStorageBreakerOpen.Set(0)
+	logger.Info("object storage recovered, resume the object storage metering")
+}
+
+// ReadyzCheck fails while the breaker is open
+func (b *objStorageBreaker) ReadyzCheck(_ *http.Request) error {
+	if !b.allow() {
+		return fmt.Errorf("object storage is unavailable, the object storage metering is skipped")
+	}
+	return nil
+}
+
+// probeObjStorage lists the buckets with the admin client
+func (r *MonitorReconciler) probeObjStorage() error {
+	return r.ObjStorageClient.Do(func(client *minio.Client) error {
+		ctx, cancel := withTimeout(r.ObjStorageTimeout)
+		defer cancel()
+		_, err := client.ListBuckets(ctx)
+		return err
+	})
+}
+
+// ObjStorageBreakerReadyzCheck fails while the object storage metering is skipped by the breaker
+func (r *MonitorReconciler) ObjStorageBreakerReadyzCheck(req *http.Request) error {
+	return r.objStorageBreaker.ReadyzCheck(req)
+}
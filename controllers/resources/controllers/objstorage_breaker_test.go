@@ -0,0 +1,176 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	objstorage "github.com/labring/sealos/controllers/pkg/objectstorage"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestObjStorageBreaker(t *testing.T) {
+	errDown := errors.New("minio unavailable")
+	b := newObjStorageBreaker(3, 2)
+	// a success resets the consecutive failures
+	b.record(errDown)
+	b.record(errDown)
+	b.record(nil)
+	b.record(errDown)
+	b.record(errDown)
+	if !b.allow() {
+		t.Fatal("breaker opened before 3 consecutive failures")
+	}
+	b.record(errDown)
+	if b.allow() || b.ReadyzCheck(nil) == nil {
+		t.Fatal("breaker is not open after 3 consecutive failures")
+	}
+
+	var probes int
+	probe := func(err error) func() error {
+		return func() error {
+			probes++
+			return err
+		}
+	}
+	// the cooldown cycles are skipped without probing
+	for i := 0; i < 2; i++ {
+		b.startCycle(probe(nil))
+		if b.allow() || probes != 0 {
+			t.Fatalf("cycle %d: allow %v with %d probes, want skipped without probe", i, b.allow(), probes)
+		}
+	}
+	// the failed probe restarts the cooldown
+	b.startCycle(probe(errDown))
+	if b.allow() || probes != 1 {
+		t.Fatalf("allow %v with %d probes after a failed probe, want skipped with 1 probe", b.allow(), probes)
+	}
+	b.startCycle(probe(nil))
+	b.startCycle(probe(nil))
+	if b.allow() || probes != 1 {
+		t.Fatalf("allow %v with %d probes in the second cooldown, want skipped with 1 probe", b.allow(), probes)
+	}
+	b.startCycle(probe(nil))
+	if !b.allow() || probes != 2 || b.ReadyzCheck(nil) != nil {
+		t.Fatalf("allow %v with %d probes after a successful probe, want closed with 2 probes", b.allow(), probes)
+	}
+	// the failures are counted from zero once closed
+	b.record(errDown)
+	b.record(errDown)
+	if !b.allow() {
+		t.Error("breaker reopened before 3 new consecutive failures")
+	}
+}
+
+func TestObjStorageBreaker_Disabled(t *testing.T) {
+	b := newObjStorageBreaker(0, 5)
+	if b != nil {
+		t.Fatalf("newObjStorageBreaker(0) = %+v, want nil", b)
+	}
+	for i := 0; i < 10; i++ {
+		b.record(errors.New("minio unavailable"))
+	}
+	b.startCycle(func() error { return errors.New("minio unavailable") })
+	if !b.allow() || b.ReadyzCheck(nil) != nil {
+		t.Error("disabled breaker blocks the calls")
+	}
+}
+
+func TestMonitorReconciler_getObjStorageUsed_Breaker(t *testing.T) {
+	var requests, down int32 = 0, 1
+	healthy := &fakeBucketServer{objects: map[string][]int64{"user-a-first": {1 << 20}}}
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		healthy.ServeHTTP(w, req)
+	}))
+	defer s3.Close()
+	client, err := NewObjStorageClient(strings.TrimPrefix(s3.URL, "http://"), false, func() (string, string, error) { return "ak", "sk", nil })
+	if err != nil {
+		t.Fatalf("failed to new object storage client: %v", err)
+	}
+	r := &MonitorReconciler{
+		Logger:              logr.Discard(),
+		ObjStorageClient:    client,
+		ObjStorageFlowQuery: objstorage.DefaultFlowQuery,
+		// the minio client retries the unavailable server, the timeout bounds each call
+		ObjStorageTimeout: 50 * time.Millisecond,
+		bucketFilter:      newBucketFilter(bucketExemption{}, nil),
+		objStorageScan:    objstorage.NewScanCycle(),
+		objStorageBreaker: newObjStorageBreaker(2, 1),
+	}
+	meter := func() error {
+		if !r.objStorageBreaker.allow() {
+			return nil
+		}
+		named := map[string]*resources.ResourceNamed{}
+		used := map[string]map[corev1.ResourceName]*quantity{}
+		_, err := r.getObjStorageUsed("user-a", nil, &named, &used)
+		r.objStorageBreaker.record(err)
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if err := meter(); err == nil {
+			t.Fatalf("call %d to the unavailable object storage succeeded", i)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("call %d took %s, want bounded by the timeout", i, elapsed)
+		}
+	}
+	if r.objStorageBreaker.allow() {
+		t.Fatal("breaker is not open after 2 failures")
+	}
+	// the open breaker doesn't hit the object storage
+	before := atomic.LoadInt32(&requests)
+	for i := 0; i < 5; i++ {
+		_ = meter()
+	}
+	r.objStorageBreaker.startCycle(r.probeObjStorage)
+	if after := atomic.LoadInt32(&requests); after != before {
+		t.Errorf("%d requests while the breaker is open in the cooldown, want 0", after-before)
+	}
+	// the probe fails while the object storage is still down
+	r.objStorageBreaker.startCycle(r.probeObjStorage)
+	if r.objStorageBreaker.allow() {
+		t.Fatal("breaker closed while the object storage is down")
+	}
+
+	atomic.StoreInt32(&down, 0)
+	r.objStorageBreaker.startCycle(r.probeObjStorage)
+	if r.objStorageBreaker.allow() {
+		t.Fatal("breaker probed in the restarted cooldown")
+	}
+	r.objStorageBreaker.startCycle(r.probeObjStorage)
+	if !r.objStorageBreaker.allow() {
+		t.Fatal("breaker is not closed after the object storage recovered")
+	}
+	if err := meter(); err != nil {
+		t.Errorf("getObjStorageUsed() after the recovery error = %v", err)
+	}
+}
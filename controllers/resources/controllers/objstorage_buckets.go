@@ -0,0 +1,136 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	objstorage "github.com/labring/sealos/controllers/pkg/objectstorage"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	// ObjStorageBucketConcurrency the number of the buckets of a user scanned at the same time, default 4.
+	// It is per namespace, so up to CONCURRENT_LIMIT * concurrency buckets are scanned at the same time.
+	ObjStorageBucketConcurrency = "OBJECT_STORAGE_BUCKET_CONCURRENCY"
+
+	DefaultObjStorageBucketConcurrency = 4
+)
+
+var objStorageCollectedTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sealos_resources_objectstorage_collected_timestamp_seconds",
+	Help: "Time of the latest object storage resource collected from any bucket, by the resource.",
+}, []string{"resource"})
+
+func init() {
+	metrics.Registry.MustRegister(objStorageCollectedTimestamp)
+}
+
+// bucketScan the result of scanning a bucket of the user
+type bucketScan struct {
+	bucket string
+	// skipped the bucket is exempt from billing
+	skipped bool
+	// err the listing of the bucket failed, the bucket is not metered
+	err    error
+	size   objstorage.BucketSize
+	detail *resources.ObjStorageDetail
+	flow   int64
+	// flowErr the flow of the bucket failed, the size is still metered
+	flowErr error
+}
+
+// processBuckets processes the buckets with a pool of workers, process is called once for each index in [0, n)
+func processBuckets(n, workers int, process func(i int)) {
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	queue := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				process(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// scanBuckets scans the buckets of the user in parallel, the results are in the order of the buckets.
+// The exempt names of the tenant replace the global ones if not nil.
+func (r *MonitorReconciler) scanBuckets(user string, exempt *bucketExemption, client *minio.Client, buckets []minio.BucketInfo) []bucketScan {
+	scans := make([]bucketScan, len(buckets))
+	processBuckets(len(buckets), r.ObjStorageBucketConcurrency, func(i int) {
+		scans[i] = r.scanBucket(user, exempt, client, buckets[i])
+	})
+	return scans
+}
+
+func (r *MonitorReconciler) scanBucket(user string, exempt *bucketExemption, client *minio.Client, bucket minio.BucketInfo) bucketScan {
+	scan := bucketScan{bucket: bucket.Name}
+	if r.bucketFilter.exempt(user, bucket.Name, exempt) {
+		r.objStorageScan.Skip()
+		scan.skipped = true
+		return scan
+	}
+	ctx, cancel := withTimeout(r.ObjStorageScanTimeout)
+	scan.size, scan.err = r.objStorageScan.Scan(ctx, client, bucket.Name, r.NoncurrentBilling.listVersions())
+	cancel()
+	if scan.err != nil || scan.size.Objects+scan.size.Versions == 0 {
+		return scan
+	}
+	sizeCollectedAt := time.Now().UTC()
+	ctx, cancel = withTimeout(r.ObjStorageTimeout)
+	scan.detail = objstorage.GetBucketDetail(ctx, client, bucket)
+	cancel()
+	// the non-current versions are listed with the latest ones
+	scan.collected(corev1.ResourceStorage.String(), sizeCollectedAt)
+	if r.NoncurrentBilling == NoncurrentBillingSeparate {
+		scan.collected(resources.ResourceObjStorageNoncurrent, sizeCollectedAt)
+	}
+	scan.flow, scan.flowErr = objstorage.GetObjectStorageFlow(r.PromURL, r.ObjStorageFlowQuery, bucket.Name, r.ObjectStorageInstance)
+	if scan.flowErr != nil {
+		r.objStorageScan.FlowFailed()
+	} else {
+		scan.flow = r.guardBytes(byteSourceObjStorageFlow, user+"/"+bucket.Name, scan.flow)
+		scan.collected(resources.ResourceNetwork, time.Now().UTC())
+	}
+	return scan
+}
+
+// collected records the time the resource of the bucket was collected in the detail saved in its monitors
+func (s *bucketScan) collected(resource string, at time.Time) {
+	if s.detail.CollectedAt == nil {
+		s.detail.CollectedAt = make(map[string]time.Time, 2)
+	}
+	s.detail.CollectedAt[resource] = at
+	objStorageCollectedTimestamp.WithLabelValues(resource).Set(float64(at.Unix()))
+}
@@ -0,0 +1,132 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	objstorage "github.com/labring/sealos/controllers/pkg/objectstorage"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestProcessBuckets(t *testing.T) {
+	const n, workers = 20, 3
+	var (
+		inFlight, maxInFlight int32
+		mu                    sync.Mutex
+		processed             = make(map[int]int)
+	)
+	processBuckets(n, workers, func(i int) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if current <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		processed[i]++
+		mu.Unlock()
+	})
+	if maxInFlight > workers {
+		t.Errorf("%d buckets processed at the same time, want <= %d", maxInFlight, workers)
+	}
+	for i := 0; i < n; i++ {
+		if processed[i] != 1 {
+			t.Errorf("bucket %d processed %d times, want 1", i, processed[i])
+		}
+	}
+	// no buckets, no workers started
+	processBuckets(0, workers, func(int) { t.Error("process called without buckets") })
+}
+
+func TestMonitorReconciler_getObjStorageUsed_Concurrency(t *testing.T) {
+	objects := map[string][]int64{"user-b-other": {1 << 20}}
+	for i := 0; i < 12; i++ {
+		objects[fmt.Sprintf("user-a-%02d", i)] = []int64{int64(i+1) << 20, 1 << 10}
+	}
+	s3 := httptest.NewServer(&fakeBucketServer{objects: objects, failing: map[string]bool{"user-a-05": true}})
+	defer s3.Close()
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"1024"]}]}}`)
+	}))
+	defer prom.Close()
+	client, err := NewObjStorageClient(strings.TrimPrefix(s3.URL, "http://"), false, func() (string, string, error) { return "ak", "sk", nil })
+	if err != nil {
+		t.Fatalf("failed to new object storage client: %v", err)
+	}
+
+	// the parallel scan sums the same usage as the sequential one
+	scan := func(concurrency int) (*ObjStorageUsage, map[string]int64) {
+		r := &MonitorReconciler{
+			Logger:                      logr.Discard(),
+			ObjStorageClient:            client,
+			PromURL:                     prom.URL,
+			ObjStorageFlowQuery:         objstorage.DefaultFlowQuery,
+			ObjStorageBucketConcurrency: concurrency,
+			bucketFilter:                newBucketFilter(bucketExemption{prefixes: []string{"00"}}, nil),
+			objStorageScan:              objstorage.NewScanCycle(),
+		}
+		named := map[string]*resources.ResourceNamed{}
+		used := map[string]map[corev1.ResourceName]*quantity{}
+		usage, err := r.getObjStorageUsed("user-a", nil, &named, &used)
+		if err != nil {
+			t.Fatalf("getObjStorageUsed() with concurrency %d error = %v", concurrency, err)
+		}
+		storage := make(map[string]int64, len(used))
+		for name, res := range used {
+			storage[name] = res[corev1.ResourceStorage].Value() + res[resources.ResourceNetwork].Value()
+		}
+		return usage, storage
+	}
+	sequential, sequentialUsed := scan(1)
+	parallel, parallelUsed := scan(8)
+
+	// user-a-00 is exempt, user-a-05 failed
+	if len(sequential.Buckets) != 10 || sequential.Size != parallel.Size || len(parallel.Buckets) != len(sequential.Buckets) {
+		t.Errorf("parallel usage = %d bytes in %d buckets, sequential %d bytes in %d buckets, want 10 buckets",
+			parallel.Size, len(parallel.Buckets), sequential.Size, len(sequential.Buckets))
+	}
+	if len(parallel.FailedBuckets) != 1 || parallel.FailedBuckets[0] != "user-a-05" {
+		t.Errorf("parallel failed buckets = %v, want [user-a-05]", parallel.FailedBuckets)
+	}
+	if len(parallelUsed) != len(sequentialUsed) {
+		t.Fatalf("parallel metered %d buckets, sequential %d", len(parallelUsed), len(sequentialUsed))
+	}
+	names := make([]string, 0, len(sequentialUsed))
+	for name := range sequentialUsed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if parallelUsed[name] != sequentialUsed[name] {
+			t.Errorf("bucket %s parallel used = %d, sequential %d", name, parallelUsed[name], sequentialUsed[name])
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/labring/sealos/controllers/pkg/utils/logger"
+)
+
+const (
+	MinioEndpoint = "MINIO_ENDPOINT"
+	MinioAk       = "MINIO_AK"
+	MinioSk       = "MINIO_SK"
+	// MinioCredentialsDir is the directory of the mounted minio credentials secret,
+	// which contains the files MINIO_AK and MINIO_SK. The credentials are reloaded when the files change.
+	MinioCredentialsDir = "MINIO_CREDENTIALS_DIR"
+	// MinioSecure connects to MINIO_ENDPOINT over https, default false
+	MinioSecure = "MINIO_SECURE"
+)
+
+const (
+	// DefaultObjStorageMaxAuthFailures consecutive auth failures make the object storage not ready
+	DefaultObjStorageMaxAuthFailures = 3
+	DefaultObjStorageReloadInterval  = 1 * time.Minute
+)
+
+// CredentialsLoader loads the access key and secret key of object storage
+type CredentialsLoader func() (ak, sk string, err error)
+
+// EnvCredentialsLoader loads the credentials from env MINIO_AK and MINIO_SK
+func EnvCredentialsLoader() (string, string, error) {
+	ak, sk := os.Getenv(MinioAk), os.Getenv(MinioSk)
+	if ak == "" || sk == "" {
+		return "", "", fmt.Errorf("minio credentials not found, please check env: %s, %s", MinioAk, MinioSk)
+	}
+	return ak, sk, nil
+}
+
+// FileCredentialsLoader loads the credentials from the files MINIO_AK and MINIO_SK in dir
+func FileCredentialsLoader(dir string) CredentialsLoader {
+	return func() (string, string, error) {
+		ak, err := os.ReadFile(filepath.Join(dir, MinioAk))
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read minio access key: %w", err)
+		}
+		sk, err := os.ReadFile(filepath.Join(dir, MinioSk))
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read minio secret key: %w", err)
+		}
+		return strings.TrimSpace(string(ak)), strings.TrimSpace(string(sk)), nil
+	}
+}
+
+// ObjStorageClient wraps the minio client, the client is rebuilt when the credentials are rotated.
+type ObjStorageClient struct {
+	mu       sync.RWMutex
+	client   *minio.Client
+	endpoint string
+	secure   bool
+	ak, sk   string
+	load     CredentialsLoader
+
+	MaxAuthFailures int64
+	authFailures    int64
+}
+
+func NewObjStorageClient(endpoint string, secure bool, load CredentialsLoader) (*ObjStorageClient, error) {
+	c := &ObjStorageClient{
+		endpoint:        endpoint,
+		secure:          secure,
+		load:            load,
+		MaxAuthFailures: DefaultObjStorageMaxAuthFailures,
+	}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Client returns the current minio client
+func (c *ObjStorageClient) Client() *minio.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client
+}
+
+func (c *ObjStorageClient) credentials() (ak, sk string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ak, c.sk
+}
+
+// Reload reloads the credentials and rebuilds the minio client if the credentials changed
+func (c *ObjStorageClient) Reload() (bool, error) {
+	ak, sk, err := c.load()
+	if err != nil {
+		return false, fmt.Errorf("failed to load object storage credentials: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil && ak == c.ak && sk == c.sk {
+		return false, nil
+	}
+	client, err := minio.New(c.endpoint, &minio.Options{Creds: credentials.NewStaticV4(ak, sk, ""), Secure: c.secure})
+	if err != nil {
+		return false, fmt.Errorf("failed to new minio client: %w", err)
+	}
+	c.client, c.ak, c.sk = client, ak, sk
+	return true, nil
+}
+
+// Do runs the object storage operation, if it fails with an auth error,
+// the credentials are reloaded and the operation is retried once with the fresh client.
+func (c *ObjStorageClient) Do(op func(client *minio.Client) error) error {
+	err := op(c.Client())
+	if !isObjStorageAuthError(err) {
+		c.recordAuth(err)
+		return err
+	}
+	if reloaded, rErr := c.Reload(); rErr != nil {
+		logger.Error("failed to reload object storage credentials", "err", rErr)
+	} else if reloaded {
+		logger.Info("object storage credentials reloaded, retry the operation")
+		err = op(c.Client())
+	}
+	c.recordAuth(err)
+	return err
+}
+
+func (c *ObjStorageClient) recordAuth(err error) {
+	if isObjStorageAuthError(err) {
+		atomic.AddInt64(&c.authFailures, 1)
+		return
+	}
+	atomic.StoreInt64(&c.authFailures, 0)
+}
+
+// ReadyzCheck fails if the object storage operations failed with consecutive auth errors
+func (c *ObjStorageClient) ReadyzCheck(_ *http.Request) error {
+	if failures := atomic.LoadInt64(&c.authFailures); c.MaxAuthFailures > 0 && failures >= c.MaxAuthFailures {
+		return fmt.Errorf("object storage has %d consecutive auth failures", failures)
+	}
+	return nil
+}
+
+// WatchCredentials reloads the credentials periodically until the context is done,
+// the mounted secret files are updated by kubelet when the secret is rotated.
+func (c *ObjStorageClient) WatchCredentials(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := c.Reload()
+			if err != nil {
+				logger.Error("failed to reload object storage credentials", "err", err)
+				continue
+			}
+			if reloaded {
+				logger.Info("object storage credentials rotated, minio client rebuilt")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func isObjStorageAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	resp := minio.ToErrorResponse(err)
+	switch resp.Code {
+	case "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken", "AccessDenied":
+		return true
+	}
+	return resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized
+}
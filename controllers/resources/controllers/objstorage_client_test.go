@@ -0,0 +1,98 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// fakeObjStorageServer accepts only the requests signed with the current access key
+type fakeObjStorageServer struct {
+	mu sync.Mutex
+	ak string
+}
+
+func (f *fakeObjStorageServer) setAccessKey(ak string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ak = ak
+}
+
+func (f *fakeObjStorageServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	ak := f.ak
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/xml")
+	if !strings.Contains(req.Header.Get("Authorization"), "Credential="+ak+"/") {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>InvalidAccessKeyId</Code><Message>The Access Key Id you provided does not exist in our records.</Message></Error>`))
+		return
+	}
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ListAllMyBucketsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Owner><ID>minio</ID><DisplayName>minio</DisplayName></Owner><Buckets><Bucket><Name>ns-test-bucket</Name><CreationDate>2024-01-01T00:00:00.000Z</CreationDate></Bucket></Buckets></ListAllMyBucketsResult>`))
+}
+
+func TestObjStorageClient_CredentialsRotation(t *testing.T) {
+	fake := &fakeObjStorageServer{ak: "old-ak"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var mu sync.Mutex
+	ak, sk := "old-ak", "old-sk"
+	load := func() (string, string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return ak, sk, nil
+	}
+	c, err := NewObjStorageClient(strings.TrimPrefix(server.URL, "http://"), false, load)
+	if err != nil {
+		t.Fatalf("failed to new object storage client: %v", err)
+	}
+	listBuckets := func(client *minio.Client) error {
+		_, err := client.ListBuckets(context.Background())
+		return err
+	}
+	if err := c.Do(listBuckets); err != nil {
+		t.Fatalf("failed to list buckets with old credentials: %v", err)
+	}
+
+	// rotate the credentials on the server, the client still uses the old ones
+	fake.setAccessKey("new-ak")
+	for i := int64(0); i < c.MaxAuthFailures; i++ {
+		if err := c.Do(listBuckets); !isObjStorageAuthError(err) {
+			t.Fatalf("expected auth error before credentials reload, got: %v", err)
+		}
+	}
+	if err := c.ReadyzCheck(nil); err == nil {
+		t.Fatalf("expected object storage not ready after %d auth failures", c.MaxAuthFailures)
+	}
+
+	// the mounted credentials are updated, the failed operation is retried with the fresh client
+	mu.Lock()
+	ak, sk = "new-ak", "new-sk"
+	mu.Unlock()
+	if err := c.Do(listBuckets); err != nil {
+		t.Fatalf("failed to list buckets after credentials rotation: %v", err)
+	}
+	if err := c.ReadyzCheck(nil); err != nil {
+		t.Fatalf("expected object storage ready after recovery, got: %v", err)
+	}
+}
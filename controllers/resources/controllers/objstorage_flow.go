@@ -0,0 +1,158 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	objstorage "github.com/labring/sealos/controllers/pkg/objectstorage"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+	"github.com/labring/sealos/controllers/pkg/utils/retry"
+)
+
+const (
+	// ObjStorageFlowQueryPreset the built-in flow query: minio-v2 (default) or minio-v3
+	ObjStorageFlowQueryPreset = "OBJECT_STORAGE_FLOW_QUERY_PRESET"
+	// ObjStorageFlowReceivedQuery overrides the received bytes query template of the preset, placeholders {{.Bucket}} and {{.Instance}}
+	ObjStorageFlowReceivedQuery = "OBJECT_STORAGE_FLOW_RECEIVED_QUERY"
+	// ObjStorageFlowSentQuery overrides the sent bytes query template of the preset
+	ObjStorageFlowSentQuery = "OBJECT_STORAGE_FLOW_SENT_QUERY"
+	// ObjStorageFlowProbeQuery overrides the probe query template of the preset, placeholder {{.Instance}}
+	ObjStorageFlowProbeQuery = "OBJECT_STORAGE_FLOW_PROBE_QUERY"
+	// ObjStorageFlowWindow the window rendered as {{.Window}} in the flow query templates, default 1m (the reconcile period)
+	ObjStorageFlowWindow = "OBJECT_STORAGE_FLOW_WINDOW"
+	// ObjStorageFlowStep the subquery resolution rendered as {{.Step}}, must divide the window, default the prometheus evaluation interval
+	ObjStorageFlowStep = "OBJECT_STORAGE_FLOW_STEP"
+	// ObjStorageFlowLabelMatchers the extra label matchers rendered as {{.Matchers}}, eg: cluster=hz-1,region=cn,
+	// scopes the flow queries of a federated prometheus to the cluster of the object storage
+	ObjStorageFlowLabelMatchers = "OBJECT_STORAGE_FLOW_LABEL_MATCHERS"
+
+	// ObjStorageInstanceDiscovery discovers the OBJECT_STORAGE_INSTANCE from prometheus if it's not set, default false
+	ObjStorageInstanceDiscovery = "OBJECT_STORAGE_INSTANCE_DISCOVERY"
+	// ObjStoragePrometheusJob the prometheus job of the object storage metrics, filters the discovered instances if set
+	ObjStoragePrometheusJob = "OBJECT_STORAGE_PROMETHEUS_JOB"
+
+	// PodNamespaceEnv and PodNameEnv the pod of the controller from the downward api, the object of the events of the
+	// controller configuration, eg: the invalid flow query
+	PodNamespaceEnv = "POD_NAMESPACE"
+	PodNameEnv      = "POD_NAME"
+
+	EventReasonObjStorageFlowQueryInvalid = "ObjStorageFlowQueryInvalid"
+
+	DefaultObjStorageFlowWindow = time.Minute
+)
+
+func newObjStorageFlowQueryFromEnv() (objstorage.FlowQuery, error) {
+	query, err := objstorage.NewFlowQuery(os.Getenv(ObjStorageFlowQueryPreset), os.Getenv(ObjStorageFlowReceivedQuery),
+		os.Getenv(ObjStorageFlowSentQuery), os.Getenv(ObjStorageFlowProbeQuery))
+	if err != nil {
+		return objstorage.FlowQuery{}, err
+	}
+	window, err := env.GetDurationEnvWithDefault(ObjStorageFlowWindow, DefaultObjStorageFlowWindow)
+	if err != nil {
+		return objstorage.FlowQuery{}, err
+	}
+	step, err := env.GetDurationEnvWithDefault(ObjStorageFlowStep, 0)
+	if err != nil {
+		return objstorage.FlowQuery{}, err
+	}
+	if query, err = query.WithRange(window, step); err != nil {
+		return objstorage.FlowQuery{}, fmt.Errorf("invalid %s / %s: %w", ObjStorageFlowWindow, ObjStorageFlowStep, err)
+	}
+	matchers, err := objstorage.ParseLabelMatchers(os.Getenv(ObjStorageFlowLabelMatchers))
+	if err != nil {
+		return objstorage.FlowQuery{}, fmt.Errorf("invalid %s: %w", ObjStorageFlowLabelMatchers, err)
+	}
+	if query, err = query.WithMatchers(matchers); err != nil {
+		return objstorage.FlowQuery{}, fmt.Errorf("invalid %s: %w", ObjStorageFlowLabelMatchers, err)
+	}
+	return query, nil
+}
+
+// DiscoverObjectStorageInstance sets the instance of the flow queries to the only instance of the flow metrics in prometheus,
+// several instances are ambiguous and must be configured by OBJECT_STORAGE_INSTANCE.
+func (r *MonitorReconciler) DiscoverObjectStorageInstance(job string) error {
+	var instance string
+	err := retry.RetryTransient(3, 1*time.Second, func() (err error) {
+		instance, err = objstorage.DiscoverInstance(r.PromURL, r.ObjStorageFlowQuery, job)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to discover the object storage instance: %w", err)
+	}
+	r.ObjectStorageInstance = instance
+	r.Logger.Info("discovered the object storage instance", "instance", instance, "label", r.ObjStorageFlowQuery.InstanceLabel, "job", job,
+		"matchers", r.ObjStorageFlowQuery.Matchers)
+	return nil
+}
+
+// flowQueryValidator remembers the result of the flow query validation,
+// the validation is retried until it succeeds once.
+type flowQueryValidator struct {
+	mu    sync.Mutex
+	valid bool
+	err   error
+	// recorder and pod report the invalid query as an event of the controller pod, no event if nil
+	recorder record.EventRecorder
+	pod      *corev1.ObjectReference
+}
+
+// SetupObjStorageFlowQueryEvents reports the invalid flow query as a warning event of the controller pod, the events
+// are not reported if the pod is not set by the downward api
+func (r *MonitorReconciler) SetupObjStorageFlowQueryEvents(recorder record.EventRecorder, namespace, name string) {
+	if namespace == "" || name == "" {
+		r.Logger.Info("the invalid object storage flow query is only logged, the events require env", "namespace", PodNamespaceEnv, "name", PodNameEnv)
+		return
+	}
+	r.flowQueryValidator.recorder = recorder
+	r.flowQueryValidator.pod = &corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: namespace, Name: name}
+}
+
+// ValidateObjStorageFlowQuery executes the flow query once against prometheus,
+// a misconfigured query fails loudly instead of billing zero flow.
+func (r *MonitorReconciler) ValidateObjStorageFlowQuery() error {
+	r.flowQueryValidator.mu.Lock()
+	defer r.flowQueryValidator.mu.Unlock()
+	if r.flowQueryValidator.valid {
+		return nil
+	}
+	err := objstorage.ValidateFlowQuery(r.PromURL, r.ObjStorageFlowQuery, r.ObjectStorageInstance)
+	if err != nil {
+		err = fmt.Errorf("invalid object storage flow query: %w", err)
+		// the readiness check validates again until it succeeds, the same error is reported once
+		if last := r.flowQueryValidator.err; last == nil || last.Error() != err.Error() {
+			r.Logger.Error(err, "please check env", "preset", ObjStorageFlowQueryPreset, "received", ObjStorageFlowReceivedQuery,
+				"sent", ObjStorageFlowSentQuery, "probe", ObjStorageFlowProbeQuery)
+			if v := &r.flowQueryValidator; v.recorder != nil {
+				v.recorder.Event(v.pod, corev1.EventTypeWarning, EventReasonObjStorageFlowQueryInvalid,
+					"the object storage flow is not metered: "+err.Error())
+			}
+		}
+	}
+	r.flowQueryValidator.valid, r.flowQueryValidator.err = err == nil, err
+	return err
+}
+
+// ObjStorageFlowReadyzCheck fails until the flow query is validated
+func (r *MonitorReconciler) ObjStorageFlowReadyzCheck(_ *http.Request) error {
+	return r.ValidateObjStorageFlowQuery()
+}
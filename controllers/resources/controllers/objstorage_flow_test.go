@@ -0,0 +1,112 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/client-go/tools/record"
+
+	objstorage "github.com/labring/sealos/controllers/pkg/objectstorage"
+)
+
+func TestNewObjStorageFlowQueryFromEnv(t *testing.T) {
+	query, err := newObjStorageFlowQueryFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Window != DefaultObjStorageFlowWindow || query.Step != 0 {
+		t.Errorf("default range = %s:%s, want %s without step", query.Window, query.Step, DefaultObjStorageFlowWindow)
+	}
+
+	t.Setenv(ObjStorageFlowWindow, "1h")
+	t.Setenv(ObjStorageFlowStep, "30s")
+	if query, err = newObjStorageFlowQueryFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if query.Window != time.Hour || query.Step != 30*time.Second {
+		t.Errorf("range = %s:%s, want 1h:30s", query.Window, query.Step)
+	}
+
+	t.Setenv(ObjStorageFlowStep, "7m")
+	if _, err = newObjStorageFlowQueryFromEnv(); err == nil {
+		t.Error("newObjStorageFlowQueryFromEnv() with the step not dividing the window, want error")
+	}
+
+	t.Setenv(ObjStorageFlowStep, "")
+
+	t.Setenv(ObjStorageFlowLabelMatchers, "cluster=hz-1")
+	if query, err = newObjStorageFlowQueryFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if query.Matchers["cluster"] != "hz-1" {
+		t.Errorf("matchers = %v, want cluster hz-1", query.Matchers)
+	}
+	t.Setenv(ObjStorageFlowReceivedQuery, `sum(rx{bucket="{{.Bucket}}"})`)
+	if _, err = newObjStorageFlowQueryFromEnv(); err == nil {
+		t.Error("newObjStorageFlowQueryFromEnv() with a template not scoped by the matchers, want error")
+	}
+}
+
+func TestMonitorReconciler_ValidateObjStorageFlowQuery_Events(t *testing.T) {
+	var exported atomic.Bool
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		result := `[]`
+		if exported.Load() {
+			result = `[{"metric":{},"value":[1700000000,"1"]}]`
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+	}))
+	defer prom.Close()
+	recorder := record.NewFakeRecorder(10)
+	r := &MonitorReconciler{Logger: logr.Discard(), PromURL: prom.URL,
+		ObjStorageFlowQuery: objstorage.FlowQueryPresets[objstorage.FlowQueryPresetMinioV2], ObjectStorageInstance: "minio:9000"}
+	r.SetupObjStorageFlowQueryEvents(recorder, "resources-system", "resources-controller-0")
+
+	// the readiness check validates again, the same error is reported once
+	for i := 0; i < 2; i++ {
+		if err := r.ValidateObjStorageFlowQuery(); err == nil {
+			t.Fatal("ValidateObjStorageFlowQuery() of the metrics not exported expected error")
+		}
+	}
+	if got := len(recorder.Events); got != 1 {
+		t.Fatalf("events = %d, want 1", got)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning "+EventReasonObjStorageFlowQueryInvalid+" the object storage flow is not metered: invalid object storage flow query") {
+		t.Errorf("event = %q", event)
+	}
+	exported.Store(true)
+	if err := r.ValidateObjStorageFlowQuery(); err != nil {
+		t.Errorf("ValidateObjStorageFlowQuery() once exported error = %v", err)
+	}
+	if got := len(recorder.Events); got != 0 {
+		t.Errorf("events once valid = %d, want 0", got)
+	}
+
+	// without the pod of the controller the error is only logged
+	r = &MonitorReconciler{Logger: logr.Discard()}
+	r.SetupObjStorageFlowQueryEvents(recorder, "", "")
+	if r.flowQueryValidator.recorder != nil {
+		t.Error("recorder set without the pod of the controller")
+	}
+}
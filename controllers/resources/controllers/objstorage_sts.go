@@ -0,0 +1,179 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/utils/logger"
+)
+
+const (
+	// ObjStorageCredentialsMode admin (default): scan all buckets with the admin client,
+	// sts: scan the buckets of each user with the short-lived credentials minted by MinIO STS AssumeRole
+	ObjStorageCredentialsMode = "OBJECT_STORAGE_CREDENTIALS_MODE"
+	// MinioSTSEndpoint the sts endpoint, default http://MINIO_ENDPOINT
+	MinioSTSEndpoint = "MINIO_STS_ENDPOINT"
+	// ObjStorageSTSFallback admin: scan with the admin client if assume role failed, skip (default): fail the storage pass of the user
+	ObjStorageSTSFallback = "OBJECT_STORAGE_STS_FALLBACK"
+
+	ObjStorageCredentialsModeAdmin = "admin"
+	ObjStorageCredentialsModeSTS   = "sts"
+	ObjStorageSTSFallbackAdmin     = "admin"
+	ObjStorageSTSFallbackSkip      = "skip"
+
+	DefaultSTSDuration = 1 * time.Hour
+)
+
+// tenantBucketPolicy only allows to list the buckets and read the objects metadata of the buckets of the user, named
+// "<user>-<name>", the dash keeps the buckets of a user whose name starts with the user out, eg: user10 for user1.
+// The versions of the versioned buckets are listed for the non-current versions metering.
+const tenantBucketPolicy = `{"Version":"2012-10-17","Statement":[` +
+	`{"Effect":"Allow","Action":["s3:ListAllMyBuckets"],"Resource":["arn:aws:s3:::*"]},` +
+	`{"Effect":"Allow","Action":["s3:ListBucket","s3:ListBucketVersions","s3:GetBucketLocation","s3:GetBucketTagging","s3:GetBucketVersioning"],"Resource":["arn:aws:s3:::%s-*"]}]}`
+
+// ErrObjStorageSTSUnavailable the credentials of the user can not be minted and the fallback is skip,
+// the storage of the user is not scanned rather than metered as empty
+var ErrObjStorageSTSUnavailable = errors.New("object storage sts credentials unavailable")
+
+var objStorageSTSFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sealos_resources_objectstorage_sts_failures_total",
+	Help: "The number of the failures to assume the role of a user, by the fallback: admin scans with the admin client, skip fails the storage pass of the user.",
+}, []string{"fallback"})
+
+func init() {
+	metrics.Registry.MustRegister(objStorageSTSFailures)
+}
+
+type tenantObjStorageEntry struct {
+	client *minio.Client
+	creds  *credentials.Credentials
+}
+
+// TenantObjStorageClients mints and caches the per tenant minio clients by STS AssumeRole
+type TenantObjStorageClients struct {
+	admin       *ObjStorageClient
+	endpoint    string
+	stsEndpoint string
+	fallback    string
+	duration    time.Duration
+
+	// mint the credentials of a user once for the concurrent callers, out of mu
+	mint  singleflight.Group
+	mu    sync.Mutex
+	cache map[string]*tenantObjStorageEntry
+}
+
+func NewTenantObjStorageClients(admin *ObjStorageClient, endpoint, stsEndpoint, fallback string) (*TenantObjStorageClients, error) {
+	switch fallback {
+	case "":
+		fallback = ObjStorageSTSFallbackSkip
+	case ObjStorageSTSFallbackAdmin, ObjStorageSTSFallbackSkip:
+	default:
+		return nil, fmt.Errorf("invalid sts fallback %q, must be one of: %s, %s", fallback, ObjStorageSTSFallbackAdmin, ObjStorageSTSFallbackSkip)
+	}
+	if stsEndpoint == "" {
+		stsEndpoint = "http://" + endpoint
+		if admin.secure {
+			stsEndpoint = "https://" + endpoint
+		}
+	}
+	return &TenantObjStorageClients{
+		admin:       admin,
+		endpoint:    endpoint,
+		stsEndpoint: stsEndpoint,
+		fallback:    fallback,
+		duration:    DefaultSTSDuration,
+		cache:       make(map[string]*tenantObjStorageEntry),
+	}, nil
+}
+
+// Do runs the operation with the client of the user. If the credentials can not be minted, the operation runs with
+// the admin client if the fallback is admin, otherwise it is not run and ErrObjStorageSTSUnavailable is returned.
+func (t *TenantObjStorageClients) Do(user string, op func(client *minio.Client) error) error {
+	client, err := t.clientFor(user)
+	if err == nil {
+		return op(client)
+	}
+	objStorageSTSFailures.WithLabelValues(t.fallback).Inc()
+	if t.fallback == ObjStorageSTSFallbackAdmin {
+		logger.Warn("failed to assume role for user, fallback to admin client", "user", user, "err", err)
+		return t.admin.Do(op)
+	}
+	return fmt.Errorf("%w for user %s: %v", ErrObjStorageSTSUnavailable, user, err)
+}
+
+// clientFor returns the cached client of the user, the credentials are minted or refreshed without holding the lock
+// of the cache, so the users don't wait for the sts calls of each other
+func (t *TenantObjStorageClients) clientFor(user string) (*minio.Client, error) {
+	t.mu.Lock()
+	entry, ok := t.cache[user]
+	t.mu.Unlock()
+	if ok && !entry.creds.IsExpired() {
+		return entry.client, nil
+	}
+	client, err, _ := t.mint.Do(user, func() (interface{}, error) {
+		// refresh the expired credentials, the entry is dropped if failed
+		if ok {
+			if _, err := entry.creds.Get(); err == nil {
+				return entry.client, nil
+			}
+			t.mu.Lock()
+			if t.cache[user] == entry {
+				delete(t.cache, user)
+			}
+			t.mu.Unlock()
+		}
+		return t.newClient(user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*minio.Client), nil
+}
+
+// newClient mints the credentials of the user and caches its client
+func (t *TenantObjStorageClients) newClient(user string) (*minio.Client, error) {
+	ak, sk := t.admin.credentials()
+	creds, err := credentials.NewSTSAssumeRole(t.stsEndpoint, credentials.STSAssumeRoleOptions{
+		AccessKey:       ak,
+		SecretKey:       sk,
+		Policy:          fmt.Sprintf(tenantBucketPolicy, user),
+		DurationSeconds: int(t.duration.Seconds()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new sts assume role: %w", err)
+	}
+	if _, err = creds.Get(); err != nil {
+		return nil, fmt.Errorf("failed to assume role: %w", err)
+	}
+	client, err := minio.New(t.endpoint, &minio.Options{Creds: creds, Secure: t.admin.secure})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new minio client: %w", err)
+	}
+	t.mu.Lock()
+	t.cache[user] = &tenantObjStorageEntry{client: client, creds: creds}
+	t.mu.Unlock()
+	return client, nil
+}
@@ -0,0 +1,208 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// fakeSTSServer mints credentials for AssumeRole, or fails all requests if broken.
+// The requests of the slow user wait for hold to be closed.
+type fakeSTSServer struct {
+	calls  int64
+	broken bool
+	expiry time.Duration
+	hold   chan struct{}
+}
+
+func (f *fakeSTSServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	n := atomic.AddInt64(&f.calls, 1)
+	_ = req.ParseForm()
+	if f.hold != nil && strings.Contains(req.Form.Get("Policy"), "arn:aws:s3:::slow-*") {
+		<-f.hold
+	}
+	if f.broken {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ErrorResponse><Error><Code>InternalError</Code><Message>sts unavailable</Message></Error></ErrorResponse>`))
+		return
+	}
+	if req.Form.Get("Action") != "AssumeRole" || !strings.Contains(req.Form.Get("Policy"), "arn:aws:s3:::") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><AssumedRoleUser><Arn></Arn><AssumeRoleId></AssumeRoleId></AssumedRoleUser><Credentials><AccessKeyId>tenant-ak-%d</AccessKeyId><SecretAccessKey>tenant-sk</SecretAccessKey><SessionToken>token</SessionToken><Expiration>%s</Expiration></Credentials></AssumeRoleResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></AssumeRoleResponse>`,
+		n, time.Now().Add(f.expiry).UTC().Format(time.RFC3339))
+}
+
+func newTestAdminClient(t *testing.T, endpoint string) *ObjStorageClient {
+	admin, err := NewObjStorageClient(endpoint, false, func() (string, string, error) { return "admin-ak", "admin-sk", nil })
+	if err != nil {
+		t.Fatalf("failed to new admin client: %v", err)
+	}
+	return admin
+}
+
+func TestTenantObjStorageClients_clientFor(t *testing.T) {
+	sts := &fakeSTSServer{expiry: time.Hour}
+	server := httptest.NewServer(sts)
+	defer server.Close()
+	endpoint := strings.TrimPrefix(server.URL, "http://")
+
+	tenants, err := NewTenantObjStorageClients(newTestAdminClient(t, endpoint), endpoint, server.URL, "")
+	if err != nil {
+		t.Fatalf("failed to new tenant clients: %v", err)
+	}
+	first, err := tenants.clientFor("user1")
+	if err != nil {
+		t.Fatalf("failed to assume role: %v", err)
+	}
+	second, err := tenants.clientFor("user1")
+	if err != nil || second != first {
+		t.Fatalf("expected cached tenant client, err: %v", err)
+	}
+	if calls := atomic.LoadInt64(&sts.calls); calls != 1 {
+		t.Errorf("sts calls = %d, want 1", calls)
+	}
+
+	// expired credentials are refreshed
+	tenants.cache["user1"].creds.Expire()
+	if _, err := tenants.clientFor("user1"); err != nil {
+		t.Fatalf("failed to refresh expired credentials: %v", err)
+	}
+	if calls := atomic.LoadInt64(&sts.calls); calls != 2 {
+		t.Errorf("sts calls after expiry = %d, want 2", calls)
+	}
+}
+
+func TestTenantObjStorageClients_Fallback(t *testing.T) {
+	sts := &fakeSTSServer{broken: true}
+	server := httptest.NewServer(sts)
+	defer server.Close()
+	endpoint := strings.TrimPrefix(server.URL, "http://")
+	admin := newTestAdminClient(t, endpoint)
+
+	for _, tt := range []struct {
+		fallback  string
+		wantAdmin bool
+		wantErr   error
+	}{
+		// the storage of the user fails rather than being metered as empty
+		{fallback: ObjStorageSTSFallbackSkip, wantAdmin: false, wantErr: ErrObjStorageSTSUnavailable},
+		{fallback: ObjStorageSTSFallbackAdmin, wantAdmin: true},
+	} {
+		tenants, err := NewTenantObjStorageClients(admin, endpoint, server.URL, tt.fallback)
+		if err != nil {
+			t.Fatalf("failed to new tenant clients: %v", err)
+		}
+		called := false
+		err = tenants.Do("user1", func(client *minio.Client) error {
+			called = true
+			if client != admin.Client() {
+				t.Errorf("fallback %s: expected admin client", tt.fallback)
+			}
+			return nil
+		})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("fallback %s: error = %v, want %v", tt.fallback, err, tt.wantErr)
+		}
+		if called != tt.wantAdmin {
+			t.Errorf("fallback %s: operation called = %v, want %v", tt.fallback, called, tt.wantAdmin)
+		}
+	}
+	if _, err := NewTenantObjStorageClients(admin, endpoint, server.URL, "deny"); err == nil {
+		t.Errorf("expected error for invalid fallback")
+	}
+}
+
+func TestTenantBucketPolicy(t *testing.T) {
+	var policy struct {
+		Statement []struct {
+			Action   []string
+			Resource []string
+		}
+	}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(tenantBucketPolicy, "user1")), &policy); err != nil {
+		t.Fatalf("invalid policy: %v", err)
+	}
+	if len(policy.Statement) != 2 {
+		t.Fatalf("policy statements = %d, want 2", len(policy.Statement))
+	}
+	buckets := policy.Statement[1]
+	if len(buckets.Resource) != 1 || buckets.Resource[0] != "arn:aws:s3:::user1-*" {
+		t.Errorf("bucket resources = %v, want only the buckets of user1", buckets.Resource)
+	}
+	for _, action := range []string{"s3:ListBucket", "s3:ListBucketVersions", "s3:GetBucketVersioning"} {
+		found := false
+		for _, allowed := range buckets.Action {
+			found = found || allowed == action
+		}
+		if !found {
+			t.Errorf("bucket actions = %v, want %s", buckets.Action, action)
+		}
+	}
+}
+
+func TestTenantObjStorageClients_clientForConcurrent(t *testing.T) {
+	sts := &fakeSTSServer{expiry: time.Hour, hold: make(chan struct{})}
+	server := httptest.NewServer(sts)
+	defer server.Close()
+	endpoint := strings.TrimPrefix(server.URL, "http://")
+	tenants, err := NewTenantObjStorageClients(newTestAdminClient(t, endpoint), endpoint, server.URL, "")
+	if err != nil {
+		t.Fatalf("failed to new tenant clients: %v", err)
+	}
+
+	// the sts call of the slow user doesn't block the other users, its concurrent callers share one call
+	slow := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := tenants.clientFor("slow")
+			slow <- err
+		}()
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := tenants.clientFor("user1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to assume role of user1: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("user1 waited for the sts call of the slow user")
+	}
+	close(sts.hold)
+	for i := 0; i < 2; i++ {
+		if err := <-slow; err != nil {
+			t.Fatalf("failed to assume role of the slow user: %v", err)
+		}
+	}
+	if calls := atomic.LoadInt64(&sts.calls); calls != 2 {
+		t.Errorf("sts calls = %d, want one per user", calls)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	objstorage "github.com/labring/sealos/controllers/pkg/objectstorage"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// fakeBucketServer serves the bucket listing and the objects of the buckets, the listing of the failing buckets returns NoSuchBucket
+type fakeBucketServer struct {
+	objects map[string][]int64
+	failing map[string]bool
+}
+
+func (f *fakeBucketServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/xml")
+	bucket := strings.Trim(req.URL.Path, "/")
+	switch {
+	case bucket == "":
+		var buckets strings.Builder
+		for name := range f.objects {
+			fmt.Fprintf(&buckets, `<Bucket><Name>%s</Name><CreationDate>2024-01-01T00:00:00.000Z</CreationDate></Bucket>`, name)
+		}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListAllMyBucketsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Owner><ID>minio</ID></Owner><Buckets>%s</Buckets></ListAllMyBucketsResult>`, buckets.String())
+	case req.URL.Query().Has("location"):
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+	case f.failing[bucket]:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist</Message><BucketName>%s</BucketName></Error>`, bucket)
+	default:
+		var contents strings.Builder
+		for i, size := range f.objects[bucket] {
+			fmt.Fprintf(&contents, `<Contents><Key>object-%d</Key><Size>%d</Size><LastModified>2024-01-01T00:00:00.000Z</LastModified></Contents>`, i, size)
+		}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><KeyCount>%d</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>%s</ListBucketResult>`,
+			bucket, len(f.objects[bucket]), contents.String())
+	}
+}
+
+func TestMonitorReconciler_getObjStorageUsed_PartialFailure(t *testing.T) {
+	s3 := httptest.NewServer(&fakeBucketServer{
+		objects: map[string][]int64{
+			"user-a-first":  {1 << 20, 1 << 20},
+			"user-a-broken": {1 << 30},
+			"user-a-third":  {3 << 20},
+			"user-b-other":  {1 << 20},
+		},
+		failing: map[string]bool{"user-a-broken": true},
+	})
+	defer s3.Close()
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"1024"]}]}}`)
+	}))
+	defer prom.Close()
+
+	client, err := NewObjStorageClient(strings.TrimPrefix(s3.URL, "http://"), false, func() (string, string, error) { return "ak", "sk", nil })
+	if err != nil {
+		t.Fatalf("failed to new object storage client: %v", err)
+	}
+	r := &MonitorReconciler{
+		Logger:              logr.Discard(),
+		ObjStorageClient:    client,
+		PromURL:             prom.URL,
+		ObjStorageFlowQuery: objstorage.DefaultFlowQuery,
+		bucketFilter:        newBucketFilter(bucketExemption{}, nil),
+		objStorageScan:      objstorage.NewScanCycle(),
+	}
+	named := map[string]*resources.ResourceNamed{}
+	used := map[string]map[corev1.ResourceName]*quantity{}
+	usage, err := r.getObjStorageUsed("user-a", nil, &named, &used)
+	if err != nil {
+		t.Fatalf("getObjStorageUsed() error = %v, want the broken bucket skipped", err)
+	}
+	if usage.Size != 5<<20 || len(usage.Buckets) != 2 {
+		t.Errorf("getObjStorageUsed() size = %d with %d buckets, want %d with 2", usage.Size, len(usage.Buckets), 5<<20)
+	}
+	if len(usage.FailedBuckets) != 1 || usage.FailedBuckets[0] != "user-a-broken" {
+		t.Errorf("getObjStorageUsed() failed buckets = %v, want [user-a-broken]", usage.FailedBuckets)
+	}
+	for _, bucket := range []string{"user-a-first", "user-a-third"} {
+		res, ok := used[resources.NewObjStorageResourceNamed(bucket).String()]
+		if !ok {
+			t.Errorf("bucket %s is not metered", bucket)
+			continue
+		}
+		if res[resources.ResourceNetwork].Value() != 2048 {
+			t.Errorf("bucket %s flow = %d, want 2048", bucket, res[resources.ResourceNetwork].Value())
+		}
+		detail := named[resources.NewObjStorageResourceNamed(bucket).String()].ObjStorageDetail()
+		if detail == nil || detail.CreationTime.Year() != 2024 {
+			t.Errorf("bucket %s detail = %+v, want the creation time", bucket, detail)
+		}
+	}
+	if _, ok := used[resources.NewObjStorageResourceNamed("user-a-broken").String()]; ok {
+		t.Error("the broken bucket is metered")
+	}
+	if summary := r.objStorageScan.Finish(0); summary.Scanned != 2 || summary.Failed != 1 {
+		t.Errorf("scan cycle = %d scanned, %d failed, want 2, 1", summary.Scanned, summary.Failed)
+	}
+}
+
+func TestMonitorReconciler_getObjStorageUsed_CollectedAt(t *testing.T) {
+	s3 := httptest.NewServer(&fakeBucketServer{objects: map[string][]int64{"user-a-flow": {1 << 20}, "user-a-noflow": {1 << 20}}})
+	defer s3.Close()
+	// the flow of user-a-noflow fails
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(req.FormValue("query"), "user-a-noflow") {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"status":"error","errorType":"internal","error":"unavailable"}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"1024"]}]}}`)
+	}))
+	defer prom.Close()
+
+	client, err := NewObjStorageClient(strings.TrimPrefix(s3.URL, "http://"), false, func() (string, string, error) { return "ak", "sk", nil })
+	if err != nil {
+		t.Fatalf("failed to new object storage client: %v", err)
+	}
+	r := &MonitorReconciler{
+		Logger:              logr.Discard(),
+		ObjStorageClient:    client,
+		PromURL:             prom.URL,
+		ObjStorageFlowQuery: objstorage.DefaultFlowQuery,
+		bucketFilter:        newBucketFilter(bucketExemption{}, nil),
+		objStorageScan:      objstorage.NewScanCycle(),
+	}
+	start := time.Now().UTC()
+	named := map[string]*resources.ResourceNamed{}
+	used := map[string]map[corev1.ResourceName]*quantity{}
+	if _, err := r.getObjStorageUsed("user-a", nil, &named, &used); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now().UTC()
+	for bucket, wantFlow := range map[string]bool{"user-a-flow": true, "user-a-noflow": false} {
+		detail := named[resources.NewObjStorageResourceNamed(bucket).String()].ObjStorageDetail()
+		if detail == nil {
+			t.Fatalf("bucket %s has no detail", bucket)
+		}
+		if at, ok := detail.CollectedAt[corev1.ResourceStorage.String()]; !ok || at.Before(start) || at.After(end) {
+			t.Errorf("bucket %s storage collected at %v, want in the scan", bucket, at)
+		}
+		if at, ok := detail.CollectedAt[resources.ResourceNetwork]; ok != wantFlow || (ok && (at.Before(start) || at.After(end))) {
+			t.Errorf("bucket %s network collected at %v (%v), want collected %v", bucket, at, ok, wantFlow)
+		}
+	}
+}
+
+func TestMonitorReconciler_enforceObjStorageQuota_FailedBuckets(t *testing.T) {
+	enforcer := &fakeQuotaEnforcer{}
+	source, err := newQuotaSource(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &MonitorReconciler{Logger: logr.Discard(), quotaEnforcer: enforcer, quotaSource: source, quotaTracker: newQuotaTracker(10)}
+	namespace := &corev1.Namespace{}
+	namespace.Name = "ns-user-a"
+	namespace.Annotations = map[string]string{ObjStorageQuotaAnnotation: "1Ki"}
+	r.enforceObjStorageQuota(namespace, &ObjStorageUsage{User: "user-a", Size: 2048})
+	// the usage drops because a bucket failed to scan, the enforcement is kept
+	r.enforceObjStorageQuota(namespace, &ObjStorageUsage{User: "user-a", Size: 10, FailedBuckets: []string{"user-a-big"}})
+	if enforcer.enforced != 1 || enforcer.released != 0 {
+		t.Errorf("enforced %d, released %d, want 1 enforced, 0 released", enforcer.enforced, enforcer.released)
+	}
+	r.enforceObjStorageQuota(namespace, &ObjStorageUsage{User: "user-a", Size: 10})
+	if enforcer.released != 1 {
+		t.Errorf("released %d, want 1 once all buckets are scanned", enforcer.released)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	objstorage "github.com/labring/sealos/controllers/pkg/objectstorage"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// ObjStorageNoncurrentBillingEnv decides how the non-current versions of the versioned buckets are billed
+const ObjStorageNoncurrentBillingEnv = "OBJECT_STORAGE_NONCURRENT_BILLING"
+
+// NoncurrentBilling decides how the non-current versions and delete markers of the object storage are metered
+type NoncurrentBilling string
+
+const (
+	// NoncurrentBillingNone only meters the latest versions, the versions are not listed. (default)
+	NoncurrentBillingNone NoncurrentBilling = "none"
+	// NoncurrentBillingStorage meters the non-current versions as storage, at the same rate as the latest versions.
+	NoncurrentBillingStorage NoncurrentBilling = "storage"
+	// NoncurrentBillingSeparate meters the non-current versions as storage.noncurrent, which can be priced at a discounted rate.
+	NoncurrentBillingSeparate NoncurrentBilling = "separate"
+)
+
+func parseNoncurrentBilling(billing string) (NoncurrentBilling, error) {
+	switch b := NoncurrentBilling(billing); b {
+	case "":
+		return NoncurrentBillingNone, nil
+	case NoncurrentBillingNone, NoncurrentBillingStorage, NoncurrentBillingSeparate:
+		return b, nil
+	}
+	return "", fmt.Errorf("invalid object storage non-current billing %q, must be one of: %s, %s, %s", billing,
+		NoncurrentBillingNone, NoncurrentBillingStorage, NoncurrentBillingSeparate)
+}
+
+// listVersions the versions are only listed if the non-current versions are billed
+func (b NoncurrentBilling) listVersions() bool {
+	return b == NoncurrentBillingStorage || b == NoncurrentBillingSeparate
+}
+
+// ValidateNoncurrentBilling the separate billing requires the storage.noncurrent property to be priced
+func (r *MonitorReconciler) ValidateNoncurrentBilling() error {
+	if r.NoncurrentBilling != NoncurrentBillingSeparate {
+		return nil
+	}
+	if _, ok := r.properties().StringMap[resources.ResourceObjStorageNoncurrent]; !ok {
+		return fmt.Errorf("property %s not found, it is required by the %s non-current billing", resources.ResourceObjStorageNoncurrent, NoncurrentBillingSeparate)
+	}
+	return nil
+}
+
+// meterBucketSize adds the metered bucket size to the resources of the bucket
+func (b NoncurrentBilling) meterBucketSize(res map[corev1.ResourceName]*quantity, size objstorage.BucketSize) {
+	switch b {
+	case NoncurrentBillingStorage:
+		res[corev1.ResourceStorage].Add(*resource.NewQuantity(size.Total(), resource.BinarySI))
+	case NoncurrentBillingSeparate:
+		res[corev1.ResourceStorage].Add(*resource.NewQuantity(size.Current, resource.BinarySI))
+		if size.Noncurrent == 0 {
+			return
+		}
+		if res[resources.ResourceObjStorageNoncurrent] == nil {
+			res[resources.ResourceObjStorageNoncurrent] = &quantity{Quantity: resource.NewQuantity(0, resource.BinarySI), detail: ""}
+		}
+		res[resources.ResourceObjStorageNoncurrent].Add(*resource.NewQuantity(size.Noncurrent, resource.BinarySI))
+	default:
+		res[corev1.ResourceStorage].Add(*resource.NewQuantity(size.Current, resource.BinarySI))
+	}
+}
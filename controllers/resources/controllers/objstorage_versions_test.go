@@ -0,0 +1,76 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	objstorage "github.com/labring/sealos/controllers/pkg/objectstorage"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseNoncurrentBilling(t *testing.T) {
+	if b, err := parseNoncurrentBilling(""); err != nil || b != NoncurrentBillingNone || b.listVersions() {
+		t.Errorf("parseNoncurrentBilling(\"\") = %v, %v, want none without versions", b, err)
+	}
+	if b, err := parseNoncurrentBilling("separate"); err != nil || b != NoncurrentBillingSeparate || !b.listVersions() {
+		t.Errorf("parseNoncurrentBilling(\"separate\") = %v, %v, want separate with versions", b, err)
+	}
+	if _, err := parseNoncurrentBilling("discount"); err == nil {
+		t.Error("parseNoncurrentBilling(\"discount\") expected error")
+	}
+}
+
+func TestNoncurrentBilling_meterBucketSize(t *testing.T) {
+	size := objstorage.BucketSize{Current: 1 << 20, Noncurrent: 3 << 20, Objects: 1, Versions: 2}
+	tests := []struct {
+		billing        NoncurrentBilling
+		wantStorage    int64
+		wantNoncurrent int64
+	}{
+		{billing: NoncurrentBillingNone, wantStorage: 1 << 20},
+		{billing: NoncurrentBillingStorage, wantStorage: 4 << 20},
+		{billing: NoncurrentBillingSeparate, wantStorage: 1 << 20, wantNoncurrent: 3 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.billing), func(t *testing.T) {
+			res := initResources()
+			tt.billing.meterBucketSize(res, size)
+			if got := res[corev1.ResourceStorage].Value(); got != tt.wantStorage {
+				t.Errorf("storage = %d, want %d", got, tt.wantStorage)
+			}
+			var noncurrent int64
+			if q := res[resources.ResourceObjStorageNoncurrent]; q != nil {
+				noncurrent = q.Value()
+			}
+			if noncurrent != tt.wantNoncurrent {
+				t.Errorf("%s = %d, want %d", resources.ResourceObjStorageNoncurrent, noncurrent, tt.wantNoncurrent)
+			}
+		})
+	}
+}
+
+func TestMonitorReconciler_ValidateNoncurrentBilling(t *testing.T) {
+	r := &MonitorReconciler{NoncurrentBilling: NoncurrentBillingSeparate, Properties: resources.DefaultPropertyTypeLS}
+	if err := r.ValidateNoncurrentBilling(); err == nil {
+		t.Error("ValidateNoncurrentBilling() expected error without the storage.noncurrent property")
+	}
+	r.NoncurrentBilling = NoncurrentBillingStorage
+	if err := r.ValidateNoncurrentBilling(); err != nil {
+		t.Errorf("ValidateNoncurrentBilling() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	MeteringGranularityEnv = "METERING_GRANULARITY"
+
+	// PodPropertyPrefix the property of the monitors of a pod metered per pod is the prefix followed by the pod name, eg: pod/app-a-0
+	PodPropertyPrefix = "pod/"
+)
+
+// MeteringGranularity decides whether the pods of a workload are metered together or apart. The pods are keyed by
+// their ResourceNamed, the type and the name of the workload, eg: app/app-a, so the pods of a deployment, and all
+// the standalone pods of a namespace (other/), are summed into a single monitor.
+type MeteringGranularity string
+
+const (
+	// MeteringGranularityWorkload sums the pods of the same ResourceNamed into a monitor. (default)
+	MeteringGranularityWorkload MeteringGranularity = "workload"
+	// MeteringGranularityPod meters each pod apart with the property pod/<pod name>, the monitors keep the name of the workload.
+	MeteringGranularityPod MeteringGranularity = "pod"
+)
+
+func parseMeteringGranularity(granularity string) (MeteringGranularity, error) {
+	switch g := MeteringGranularity(granularity); g {
+	case "":
+		return MeteringGranularityWorkload, nil
+	case MeteringGranularityWorkload, MeteringGranularityPod:
+		return g, nil
+	}
+	return "", fmt.Errorf("invalid metering granularity %q, must be one of: %s, %s", granularity, MeteringGranularityWorkload, MeteringGranularityPod)
+}
+
+// podProperty returns the property of the monitors of the pod, empty if the pods are metered by the workload
+func (r *MonitorReconciler) podProperty(pod *corev1.Pod) string {
+	if r.MeteringGranularity != MeteringGranularityPod {
+		return ""
+	}
+	return PodPropertyPrefix + pod.Name
+}
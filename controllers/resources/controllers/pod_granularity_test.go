@@ -0,0 +1,117 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseMeteringGranularity(t *testing.T) {
+	for granularity, want := range map[string]MeteringGranularity{
+		"":         MeteringGranularityWorkload,
+		"workload": MeteringGranularityWorkload,
+		"pod":      MeteringGranularityPod,
+	} {
+		if got, err := parseMeteringGranularity(granularity); err != nil || got != want {
+			t.Errorf("parseMeteringGranularity(%q) = %q, %v, want %q", granularity, got, err, want)
+		}
+	}
+	if _, err := parseMeteringGranularity("container"); err == nil {
+		t.Error("parseMeteringGranularity(container) expected error")
+	}
+}
+
+func TestMonitorReconciler_monitorResourceUsage_Granularity(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	// two pods of the deployment app-a
+	newPod := func(name, cpu, memory string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: name, Labels: map[string]string{resources.AppLabelKey: "app-a"}},
+			Spec:       corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{qosContainer(qosResources(cpu, memory), nil)}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		}
+	}
+	c := fake.NewClientBuilder().WithObjects(newPod("app-a-0", "1", "1Gi"), newPod("app-a-1", "500m", "512Mi")).Build()
+	cpu := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()]
+	memory := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceMemory.String()]
+	used := func(cpuQuantity, memoryQuantity string) resources.EnumUsedMap {
+		c, m := resource.MustParse(cpuQuantity), resource.MustParse(memoryQuantity)
+		return resources.EnumUsedMap{cpu.Enum: cpu.UsedUnits(c.MilliValue()), memory.Enum: memory.UsedUnits(m.MilliValue())}
+	}
+
+	tests := []struct {
+		name        string
+		granularity MeteringGranularity
+		byQOS       bool
+		want        map[string]resources.EnumUsedMap
+	}{
+		{name: "workload", granularity: MeteringGranularityWorkload,
+			want: map[string]resources.EnumUsedMap{"": used("1500m", "1536Mi")}},
+		{name: "pod", granularity: MeteringGranularityPod, want: map[string]resources.EnumUsedMap{
+			PodPropertyPrefix + "app-a-0": used("1", "1Gi"),
+			PodPropertyPrefix + "app-a-1": used("500m", "512Mi"),
+		}},
+		{name: "pod with the qos class", granularity: MeteringGranularityPod, byQOS: true, want: map[string]resources.EnumUsedMap{
+			PodPropertyPrefix + "app-a-0" + propertySeparator + QOSPropertyPrefix + "Burstable": used("1", "1Gi"),
+			PodPropertyPrefix + "app-a-1" + propertySeparator + QOSPropertyPrefix + "Burstable": used("500m", "512Mi"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := databasetest.NewMemoryStore()
+			r := &MonitorReconciler{
+				Client:              c,
+				Logger:              logr.Discard(),
+				DBClient:            db,
+				Properties:          resources.DefaultPropertyTypeLS,
+				MeteringPolicy:      MeteringPolicyRequests,
+				GpuMeteringPolicy:   GpuMeteringPolicyReservation,
+				MeteringGranularity: tt.granularity,
+				MeterByQOSClass:     tt.byQOS,
+			}
+			if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+				t.Fatalf("monitorResourceUsage() error = %v", err)
+			}
+			got := map[string]resources.EnumUsedMap{}
+			for _, monitor := range db.Monitors() {
+				// the monitors keep the name of the workload in both modes
+				if monitor.Name != "app-a" {
+					t.Errorf("monitor name = %q, want app-a", monitor.Name)
+				}
+				got[monitor.Property] = monitor.Used
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("monitors = %v, want %v", got, tt.want)
+			}
+			for property, want := range tt.want {
+				for enum, units := range want {
+					if got[property][enum] != units {
+						t.Errorf("property %q enum %d = %d, want %d", property, enum, got[property][enum], units)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	// PropertiesConfigMap namespace/name of the configmap with the properties, the properties are reloaded once it
+	// changes instead of being read from the database at startup only. Disabled if not set.
+	PropertiesConfigMap = "PROPERTIES_CONFIGMAP"
+	// PropertiesConfigMapKey the key of the properties in the configmap, a json list of the properties as stored in the
+	// properties collection, with the encrypted prices
+	PropertiesConfigMapKey = "properties"
+
+	EventReasonPropertiesReloaded = "PropertiesReloaded"
+	EventReasonPropertiesInvalid  = "PropertiesInvalid"
+)
+
+// propertiesRewatchInterval the wait before watching the configmap again once the watch is closed
+var propertiesRewatchInterval = 5 * time.Second
+
+var propertiesReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sealos_resources_properties_reloads_total",
+	Help: "Number of the changes of the properties configmap by the result, applied or invalid.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(propertiesReloads)
+}
+
+// propertiesReloader keeps the latest valid properties of the configmap, a cycle meters with the properties latest at
+// its start so all the monitors of the cycle are of one set
+type propertiesReloader struct {
+	client    client.WithWatch
+	configMap types.NamespacedName
+	recorder  record.EventRecorder
+	logger    logr.Logger
+
+	latest atomic.Pointer[resources.PropertyTypeLS]
+	// resourceVersion the version of the configmap last loaded, the watch delivers the configmap again once restarted
+	resourceVersion string
+}
+
+// SetupPropertiesReload loads the properties of the configmap and reloads them once it changes, the properties are
+// kept if the configmap is not found. An invalid configmap fails the setup, a later invalid change is ignored.
+func (r *MonitorReconciler) SetupPropertiesReload(configMap string, c client.WithWatch, recorder record.EventRecorder) error {
+	namespace, name, ok := strings.Cut(configMap, "/")
+	if !ok || namespace == "" || name == "" {
+		return fmt.Errorf("invalid properties configmap %q, want namespace/name", configMap)
+	}
+	p := &propertiesReloader{
+		client:    c,
+		configMap: types.NamespacedName{Namespace: namespace, Name: name},
+		recorder:  recorder,
+		logger:    r.Logger.WithName("properties"),
+	}
+	p.latest.Store(r.Properties)
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), p.configMap, cm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get properties configmap %s: %w", p.configMap, err)
+		}
+		p.logger.Info("properties configmap not found, the properties of the database are used until it is created", "configmap", p.configMap)
+	} else if err := p.load(cm); err != nil {
+		return err
+	}
+	r.cycleProperties.Store(p.latest.Load())
+	r.propertiesReloader = p
+	return nil
+}
+
+// load validates the properties of the configmap and makes them the latest, the latest are kept if invalid
+func (p *propertiesReloader) load(cm *corev1.ConfigMap) error {
+	if cm.ResourceVersion != "" && cm.ResourceVersion == p.resourceVersion {
+		return nil
+	}
+	p.resourceVersion = cm.ResourceVersion
+	ls, err := parseProperties(cm.Data[PropertiesConfigMapKey])
+	if err != nil {
+		err = fmt.Errorf("invalid properties configmap %s: %w", p.configMap, err)
+		propertiesReloads.WithLabelValues("invalid").Inc()
+		p.event(cm, corev1.EventTypeWarning, EventReasonPropertiesInvalid, "the properties are kept: "+err.Error())
+		return err
+	}
+	p.latest.Store(ls)
+	propertiesReloads.WithLabelValues("applied").Inc()
+	p.event(cm, corev1.EventTypeNormal, EventReasonPropertiesReloaded, fmt.Sprintf("%d properties are metered from the next cycle", len(ls.Types)))
+	p.logger.Info("properties reloaded", "configmap", p.configMap, "resourceVersion", cm.ResourceVersion, "properties", len(ls.Types))
+	return nil
+}
+
+func (p *propertiesReloader) event(cm *corev1.ConfigMap, eventType, reason, message string) {
+	if p.recorder != nil {
+		p.recorder.Event(cm, eventType, reason, message)
+	}
+}
+
+func parseProperties(data string) (*resources.PropertyTypeLS, error) {
+	var types []resources.PropertyType
+	if err := json.Unmarshal([]byte(data), &types); err != nil {
+		return nil, fmt.Errorf("failed to decode key %s: %w", PropertiesConfigMapKey, err)
+	}
+	return resources.ParsePropertyTypeLS(types)
+}
+
+// watch loads the changes of the configmap until the watch is closed or stopped
+func (p *propertiesReloader) watch(stopCh <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := p.client.Watch(ctx, &corev1.ConfigMapList{}, client.InNamespace(p.configMap.Namespace),
+		client.MatchingFields{"metadata.name": p.configMap.Name})
+	if err != nil {
+		return fmt.Errorf("failed to watch properties configmap %s: %w", p.configMap, err)
+	}
+	defer w.Stop()
+	for {
+		select {
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				cm, ok := event.Object.(*corev1.ConfigMap)
+				if !ok || cm.Name != p.configMap.Name {
+					continue
+				}
+				if err := p.load(cm); err != nil {
+					p.logger.Error(err, "failed to reload properties")
+				}
+			case watch.Deleted:
+				p.logger.Info("properties configmap deleted, the latest properties are kept", "configmap", p.configMap)
+			case watch.Error:
+				return fmt.Errorf("failed to watch properties configmap %s: %v", p.configMap, apierrors.FromObject(event.Object))
+			}
+		case <-stopCh:
+			return nil
+		}
+	}
+}
+
+// startPropertiesReload watches the properties configmap, and watches it again once the watch is closed
+func (r *MonitorReconciler) startPropertiesReload() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			if err := r.propertiesReloader.watch(r.stopCh); err != nil {
+				r.Logger.Error(err, "properties are not reloaded until the configmap is watched again")
+			}
+			select {
+			case <-time.After(propertiesRewatchInterval):
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// snapshotProperties makes the latest properties the ones of the cycle starting
+func (r *MonitorReconciler) snapshotProperties() {
+	if r.propertiesReloader != nil {
+		r.cycleProperties.Store(r.propertiesReloader.latest.Load())
+	}
+}
+
+// properties returns the properties of the running cycle, the ones at startup if they are not reloaded. A function
+// metering a resource loads them once, so its monitors are of one set even if the next cycle swaps them meanwhile.
+func (r *MonitorReconciler) properties() *resources.PropertyTypeLS {
+	if properties := r.cycleProperties.Load(); properties != nil {
+		return properties
+	}
+	return r.Properties
+}
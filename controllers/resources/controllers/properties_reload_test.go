@@ -0,0 +1,232 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/crypto"
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// propertiesConfigMap returns the properties configmap of the default properties changed by mutate, with the prices encrypted
+func propertiesConfigMap(t *testing.T, mutate func(types []resources.PropertyType)) *corev1.ConfigMap {
+	types := append([]resources.PropertyType{}, resources.DefaultPropertyTypeList...)
+	for i := range types {
+		price, err := crypto.EncryptFloat64(types[i].UnitPrice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		types[i].EncryptUnitPrice = *price
+	}
+	if mutate != nil {
+		mutate(types)
+	}
+	data, err := json.Marshal(types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "resources-system", Name: "properties"},
+		Data:       map[string]string{PropertiesConfigMapKey: string(data)},
+	}
+}
+
+// nodePortRatio sets the ratio of the node ports
+func nodePortRatio(ratio int64) func(types []resources.PropertyType) {
+	return func(types []resources.PropertyType) {
+		for i := range types {
+			if types[i].Name == corev1.ResourceServicesNodePorts.String() {
+				types[i].Ratio = ratio
+			}
+		}
+	}
+}
+
+// enumCollision gives the memory the enum of the cpu
+func enumCollision(types []resources.PropertyType) {
+	for i := range types {
+		if types[i].Name == corev1.ResourceMemory.String() {
+			types[i].Enum = resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()].Enum
+		}
+	}
+}
+
+func TestMonitorReconciler_SetupPropertiesReload(t *testing.T) {
+	tests := []struct {
+		name      string
+		configMap string
+		objects   []client.Object
+		wantRatio int64
+		wantErr   bool
+	}{
+		{name: "invalid name", configMap: "properties", wantErr: true},
+		{name: "not found", configMap: "resources-system/properties", wantRatio: resources.DefaultNodePortRatio},
+		{name: "loaded", configMap: "resources-system/properties", objects: []client.Object{propertiesConfigMap(t, nodePortRatio(1))}, wantRatio: 1},
+		{name: "enum collision", configMap: "resources-system/properties", objects: []client.Object{propertiesConfigMap(t, enumCollision)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &MonitorReconciler{Logger: logr.Discard(), Properties: resources.DefaultPropertyTypeLS}
+			err := r.SetupPropertiesReload(tt.configMap, fake.NewClientBuilder().WithObjects(tt.objects...).Build(), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetupPropertiesReload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := r.properties().GetRatio(corev1.ResourceServicesNodePorts.String(), 0); got != tt.wantRatio {
+				t.Errorf("node port ratio = %d, want %d", got, tt.wantRatio)
+			}
+		})
+	}
+}
+
+// reloadingClient loads the properties configmap once the services are listed, in the middle of collecting a namespace
+type reloadingClient struct {
+	client.WithWatch
+	reload func()
+}
+
+func (c *reloadingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, ok := list.(*corev1.ServiceList); ok && c.reload != nil {
+		c.reload()
+		c.reload = nil
+	}
+	return c.WithWatch.List(ctx, list, opts...)
+}
+
+func TestMonitorReconciler_processNamespaceList_PropertiesReload(t *testing.T) {
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Name: "app-a", Labels: map[string]string{resources.AppLabelKey: "app-a"}},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, Ports: []corev1.ServicePort{{Port: 80, NodePort: 30080}}},
+	}
+	recorder := record.NewFakeRecorder(10)
+	c := &reloadingClient{WithWatch: fake.NewClientBuilder().WithObjects(svc).Build()}
+	db := databasetest.NewMemoryStore()
+	r := &MonitorReconciler{
+		Client:            c,
+		Logger:            logr.Discard(),
+		DBClient:          db,
+		Properties:        resources.DefaultPropertyTypeLS,
+		MeteringPolicy:    MeteringPolicyLimits,
+		GpuMeteringPolicy: GpuMeteringPolicyReservation,
+	}
+	if err := r.SetupPropertiesReload("resources-system/properties", c, recorder); err != nil {
+		t.Fatal(err)
+	}
+	invalid := testutil.ToFloat64(propertiesReloads.WithLabelValues("invalid"))
+	if err := r.propertiesReloader.load(propertiesConfigMap(t, enumCollision)); err == nil {
+		t.Error("load() of the colliding enums expected error")
+	}
+	if got := testutil.ToFloat64(propertiesReloads.WithLabelValues("invalid")) - invalid; got != 1 {
+		t.Errorf("invalid reloads increased by %v, want 1", got)
+	}
+	if event := <-recorder.Events; event != "Warning "+EventReasonPropertiesInvalid+" the properties are kept: invalid properties configmap resources-system/properties: enum 0 of property memory collides with property cpu" {
+		t.Errorf("event = %q", event)
+	}
+
+	nodePorts := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceServicesNodePorts.String()].Enum
+	// the traffic and the api read the properties while the cycles swap them, run with -race
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = r.nodePortQuantity()
+				_ = r.namedUsed(resources.EnumUsedMap{nodePorts: 1})
+			}
+		}
+	}()
+	// the properties are swapped while the cycle collects the namespace
+	c.reload = func() {
+		if err := r.propertiesReloader.load(propertiesConfigMap(t, nodePortRatio(1))); err != nil {
+			t.Error(err)
+		}
+	}
+	for cycle, want := range []int64{resources.DefaultNodePortRatio, 1} {
+		db = databasetest.NewMemoryStore()
+		r.DBClient = db
+		if err := r.processNamespaceList(&corev1.NamespaceList{Items: []corev1.Namespace{namespace}}, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		monitors := db.Monitors()
+		if len(monitors) != 1 || monitors[0].Used[nodePorts] != want {
+			t.Errorf("monitors of cycle %d = %+v, want the node ports used %d", cycle, monitors, want)
+		}
+	}
+	close(stop)
+	readers.Wait()
+	if event := <-recorder.Events; event != "Normal "+EventReasonPropertiesReloaded+" 5 properties are metered from the next cycle" {
+		t.Errorf("event = %q", event)
+	}
+}
+
+func TestMonitorReconciler_startPropertiesReload(t *testing.T) {
+	interval := propertiesRewatchInterval
+	propertiesRewatchInterval = time.Millisecond
+	defer func() { propertiesRewatchInterval = interval }()
+
+	c := fake.NewClientBuilder().WithObjects(propertiesConfigMap(t, nil)).Build()
+	r := &MonitorReconciler{Logger: logr.Discard(), Properties: resources.DefaultPropertyTypeLS, stopCh: make(chan struct{})}
+	if err := r.SetupPropertiesReload("resources-system/properties", c, nil); err != nil {
+		t.Fatal(err)
+	}
+	r.startPropertiesReload()
+	defer func() {
+		close(r.stopCh)
+		r.wg.Wait()
+	}()
+	// the configmap is changed until the watch started delivers the change
+	deadline := time.Now().Add(5 * time.Second)
+	for r.propertiesReloader.latest.Load().GetRatio(corev1.ResourceServicesNodePorts.String(), 0) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the properties are not reloaded")
+		}
+		cm := &corev1.ConfigMap{}
+		if err := c.Get(context.Background(), client.ObjectKey{Namespace: "resources-system", Name: "properties"}, cm); err != nil {
+			t.Fatal(err)
+		}
+		cm.Data = propertiesConfigMap(t, nodePortRatio(1)).Data
+		cm.Annotations = map[string]string{"updated": time.Now().String()}
+		if err := c.Update(context.Background(), cm); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the properties of the cycle are only swapped at the start of the next cycle
+	if got := r.properties().GetRatio(corev1.ResourceServicesNodePorts.String(), 0); got != resources.DefaultNodePortRatio {
+		t.Errorf("node port ratio before the next cycle = %d, want %d", got, resources.DefaultNodePortRatio)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	// PendingPVCMeteringGrace meters the pvcs pending for longer than the grace, eg: 24h, only the bound pvcs are metered if not set
+	PendingPVCMeteringGrace = "PENDING_PVC_METERING_GRACE"
+
+	// PendingPVCProperty the property of the monitors of the pending pvcs, they are metered apart from the bound pvcs of the app
+	PendingPVCProperty = "pvc-pending"
+)
+
+// meteredPVC returns the resource of the pvc and true if its storage is metered: the bound pvcs,
+// and the pvcs pending for longer than the grace if enabled, eg: waiting for the first consumer, which still reserve the quota.
+// The pending pvcs are tagged with PendingPVCProperty.
+func (r *MonitorReconciler) meteredPVC(pvc *corev1.PersistentVolumeClaim, now time.Time) (*resources.ResourceNamed, bool) {
+	if pvc.Name == resources.KubeBlocksBackUpName {
+		return nil, false
+	}
+	switch pvc.Status.Phase {
+	case corev1.ClaimBound:
+		return resources.NewResourceNamed(pvc), true
+	case corev1.ClaimPending, "":
+		if r.PendingPVCGrace <= 0 || now.Sub(pvc.CreationTimestamp.Time) <= r.PendingPVCGrace {
+			return nil, false
+		}
+		return resources.NewResourceNamed(pvc).WithProperty(PendingPVCProperty), true
+	}
+	return nil, false
+}
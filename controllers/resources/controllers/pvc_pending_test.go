@@ -0,0 +1,86 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMonitorReconciler_monitorResourceUsage_PendingPVC(t *testing.T) {
+	newPVC := func(name string, phase corev1.PersistentVolumeClaimPhase, age time.Duration) client.Object {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: name, Labels: map[string]string{resources.AppLabelKey: "app-a"},
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-age))},
+			Spec: corev1.PersistentVolumeClaimSpec{Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			}},
+			Status: corev1.PersistentVolumeClaimStatus{Phase: phase},
+		}
+	}
+	objects := []client.Object{
+		newPVC("data-bound", corev1.ClaimBound, 48*time.Hour),
+		// waiting for the first consumer for 2 days, older than the grace
+		newPVC("data-pending", corev1.ClaimPending, 48*time.Hour),
+		newPVC("data-new", corev1.ClaimPending, time.Hour),
+	}
+	storage := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceStorage.String()]
+	gi := resource.MustParse("1Gi")
+	want := storage.UsedUnits(gi.MilliValue())
+
+	tests := []struct {
+		name        string
+		grace       time.Duration
+		wantPending int64
+	}{
+		{name: "bound only by default", grace: 0},
+		{name: "pending older than the grace", grace: 24 * time.Hour, wantPending: want},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := databasetest.NewMemoryStore()
+			r := &MonitorReconciler{
+				Client:          fake.NewClientBuilder().WithObjects(objects...).Build(),
+				Logger:          logr.Discard(),
+				DBClient:        db,
+				Properties:      resources.DefaultPropertyTypeLS,
+				PendingPVCGrace: tt.grace,
+			}
+			if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+				t.Fatalf("monitorResourceUsage() error = %v", err)
+			}
+			metered := map[string]int64{}
+			for _, monitor := range db.Monitors() {
+				metered[monitor.Property] += monitor.Used[storage.Enum]
+			}
+			if metered[""] != want {
+				t.Errorf("bound storage = %d, want %d", metered[""], want)
+			}
+			if metered[PendingPVCProperty] != tt.wantPending {
+				t.Errorf("pending storage = %d, want %d, the pvc within the grace is never metered", metered[PendingPVCProperty], tt.wantPending)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// MeterByQOSClass meters the pods of each QoS class of an app apart, so the QoS multipliers apply to the prices
+	MeterByQOSClass = "METER_BY_QOS_CLASS"
+
+	// QOSPropertyPrefix the property of the monitors of the pods is the prefix followed by the QoS class, eg: qos/Guaranteed
+	QOSPropertyPrefix = "qos/"
+	// propertySeparator joins the properties of a resource, eg: sidecar/istio-proxy,qos/Burstable
+	propertySeparator = ","
+)
+
+// podQOSClass returns the QoS class of the pod, the class set by the api server if any, otherwise computed as Kubernetes does
+func podQOSClass(pod *corev1.Pod) corev1.PodQOSClass {
+	if pod.Status.QOSClass != "" {
+		return pod.Status.QOSClass
+	}
+	return computePodQOSClass(pod)
+}
+
+// computePodQOSClass mirrors GetPodQOS of Kubernetes (pkg/apis/core/v1/helper/qos), only the cpu and memory count:
+// BestEffort if no container requests or limits them, Guaranteed if every container limits both and the requests
+// equal the limits, otherwise Burstable. The zero quantities are ignored. The requests missing from a container
+// default to its limits, as the api server defaults them on create.
+func computePodQOSClass(pod *corev1.Pod) corev1.PodQOSClass {
+	requests := corev1.ResourceList{}
+	limits := corev1.ResourceList{}
+	guaranteed := true
+	containers := append(append([]corev1.Container{}, pod.Spec.Containers...), pod.Spec.InitContainers...)
+	for _, container := range containers {
+		containerRequests := container.Resources.Requests.DeepCopy()
+		for name, quantity := range container.Resources.Limits {
+			if _, ok := containerRequests[name]; !ok {
+				if containerRequests == nil {
+					containerRequests = corev1.ResourceList{}
+				}
+				containerRequests[name] = quantity
+			}
+		}
+		addQOSQuantities(requests, containerRequests)
+		if found := addQOSQuantities(limits, container.Resources.Limits); !found[corev1.ResourceCPU] || !found[corev1.ResourceMemory] {
+			guaranteed = false
+		}
+	}
+	if len(requests) == 0 && len(limits) == 0 {
+		return corev1.PodQOSBestEffort
+	}
+	if guaranteed {
+		for name, request := range requests {
+			if limit, ok := limits[name]; !ok || limit.Cmp(request) != 0 {
+				guaranteed = false
+				break
+			}
+		}
+	}
+	if guaranteed && len(requests) == len(limits) {
+		return corev1.PodQOSGuaranteed
+	}
+	return corev1.PodQOSBurstable
+}
+
+// addQOSQuantities sums the positive cpu and memory of the list into total, and returns the resources found
+func addQOSQuantities(total, list corev1.ResourceList) map[corev1.ResourceName]bool {
+	found := map[corev1.ResourceName]bool{}
+	for name, quantity := range list {
+		if name != corev1.ResourceCPU && name != corev1.ResourceMemory || quantity.Sign() <= 0 {
+			continue
+		}
+		found[name] = true
+		sum := quantity.DeepCopy()
+		if existing, ok := total[name]; ok {
+			sum.Add(existing)
+		}
+		total[name] = sum
+	}
+	return found
+}
+
+// qosProperty returns the property of the monitors of the pod, empty if the pods are not metered by the QoS class
+func (r *MonitorReconciler) qosProperty(pod *corev1.Pod) string {
+	if !r.MeterByQOSClass {
+		return ""
+	}
+	return QOSPropertyPrefix + string(podQOSClass(pod))
+}
+
+// joinProperties joins the non-empty properties of a resource
+func joinProperties(properties ...string) string {
+	joined := ""
+	for _, property := range properties {
+		if property == "" {
+			continue
+		}
+		if joined != "" {
+			joined += propertySeparator
+		}
+		joined += property
+	}
+	return joined
+}
@@ -0,0 +1,177 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/gpu"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func qosContainer(requests, limits corev1.ResourceList) corev1.Container {
+	return corev1.Container{Name: "app", Resources: corev1.ResourceRequirements{Requests: requests, Limits: limits}}
+}
+
+func qosResources(cpu, memory string) corev1.ResourceList {
+	list := corev1.ResourceList{}
+	if cpu != "" {
+		list[corev1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		list[corev1.ResourceMemory] = resource.MustParse(memory)
+	}
+	return list
+}
+
+func TestComputePodQOSClass(t *testing.T) {
+	tests := []struct {
+		name           string
+		containers     []corev1.Container
+		initContainers []corev1.Container
+		want           corev1.PodQOSClass
+	}{
+		{name: "no resources", containers: []corev1.Container{qosContainer(nil, nil)}, want: corev1.PodQOSBestEffort},
+		{name: "zero requests are ignored", containers: []corev1.Container{qosContainer(qosResources("0", "0"), nil)},
+			want: corev1.PodQOSBestEffort},
+		{name: "only the gpu is limited", containers: []corev1.Container{
+			qosContainer(nil, corev1.ResourceList{gpu.NvidiaGpuKey: resource.MustParse("1")})}, want: corev1.PodQOSBestEffort},
+		{name: "requests equal limits", containers: []corev1.Container{
+			qosContainer(qosResources("1", "1Gi"), qosResources("1", "1Gi"))}, want: corev1.PodQOSGuaranteed},
+		{name: "requests defaulted to limits", containers: []corev1.Container{
+			qosContainer(nil, qosResources("500m", "512Mi"))}, want: corev1.PodQOSGuaranteed},
+		{name: "equal quantities in other units", containers: []corev1.Container{
+			qosContainer(qosResources("1000m", "1024Mi"), qosResources("1", "1Gi"))}, want: corev1.PodQOSGuaranteed},
+		{name: "requests below limits", containers: []corev1.Container{
+			qosContainer(qosResources("500m", "1Gi"), qosResources("1", "1Gi"))}, want: corev1.PodQOSBurstable},
+		{name: "only the cpu is limited", containers: []corev1.Container{
+			qosContainer(qosResources("1", ""), qosResources("1", ""))}, want: corev1.PodQOSBurstable},
+		{name: "only requests", containers: []corev1.Container{qosContainer(qosResources("100m", ""), nil)},
+			want: corev1.PodQOSBurstable},
+		{name: "a container without limits", containers: []corev1.Container{
+			qosContainer(qosResources("1", "1Gi"), qosResources("1", "1Gi")), qosContainer(nil, nil)}, want: corev1.PodQOSBurstable},
+		{name: "an init container below its limits",
+			containers:     []corev1.Container{qosContainer(qosResources("1", "1Gi"), qosResources("1", "1Gi"))},
+			initContainers: []corev1.Container{qosContainer(qosResources("100m", "1Gi"), qosResources("1", "1Gi"))},
+			want:           corev1.PodQOSBurstable},
+		{name: "guaranteed init container",
+			containers:     []corev1.Container{qosContainer(qosResources("1", "1Gi"), qosResources("1", "1Gi"))},
+			initContainers: []corev1.Container{qosContainer(nil, qosResources("200m", "256Mi"))},
+			want:           corev1.PodQOSGuaranteed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: tt.containers, InitContainers: tt.initContainers}}
+			if got := computePodQOSClass(pod); got != tt.want {
+				t.Errorf("computePodQOSClass() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodQOSClass_status(t *testing.T) {
+	// the class set by the api server wins, the resources of a running pod don't change its class
+	pod := &corev1.Pod{
+		Spec:   corev1.PodSpec{Containers: []corev1.Container{qosContainer(nil, nil)}},
+		Status: corev1.PodStatus{QOSClass: corev1.PodQOSGuaranteed},
+	}
+	if got := podQOSClass(pod); got != corev1.PodQOSGuaranteed {
+		t.Errorf("podQOSClass() = %s, want %s", got, corev1.PodQOSGuaranteed)
+	}
+}
+
+func TestMonitorReconciler_monitorResourceUsage_QOSClass(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	newPod := func(name string, containers ...corev1.Container) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: name, Labels: map[string]string{resources.AppLabelKey: "app-a"}},
+			Spec:       corev1.PodSpec{NodeName: "node-a", Containers: containers},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		}
+	}
+	sidecar := qosContainer(qosResources("100m", "128Mi"), qosResources("100m", "128Mi"))
+	sidecar.Name = "istio-proxy"
+	c := fake.NewClientBuilder().WithObjects(
+		newPod("app-a-0", qosContainer(qosResources("1", "1Gi"), qosResources("1", "1Gi")), sidecar),
+		newPod("app-a-1", qosContainer(qosResources("500m", "512Mi"), qosResources("2", "1Gi")), sidecar),
+		newPod("app-a-2", qosContainer(qosResources("1", "1Gi"), nil)),
+	).Build()
+	cpu := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()]
+	memory := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceMemory.String()]
+	used := func(cpuQuantity, memoryQuantity string) resources.EnumUsedMap {
+		c, m := resource.MustParse(cpuQuantity), resource.MustParse(memoryQuantity)
+		return resources.EnumUsedMap{cpu.Enum: cpu.UsedUnits(c.MilliValue()), memory.Enum: memory.UsedUnits(m.MilliValue())}
+	}
+
+	tests := []struct {
+		name     string
+		byQOS    bool
+		sidecars []string
+		want     map[string]resources.EnumUsedMap
+	}{
+		{name: "not metered by the qos class",
+			want: map[string]resources.EnumUsedMap{"": used("2700m", "2816Mi")}},
+		{name: "metered by the qos class", byQOS: true, want: map[string]resources.EnumUsedMap{
+			QOSPropertyPrefix + "Guaranteed": used("1100m", "1152Mi"),
+			QOSPropertyPrefix + "Burstable":  used("1600m", "1664Mi"),
+		}},
+		{name: "sidecars keep the qos class of the pod", byQOS: true, sidecars: []string{"istio-proxy"}, want: map[string]resources.EnumUsedMap{
+			QOSPropertyPrefix + "Guaranteed": used("1", "1Gi"),
+			QOSPropertyPrefix + "Burstable":  used("1500m", "1536Mi"),
+			SidecarPropertyPrefix + "istio-proxy" + propertySeparator + QOSPropertyPrefix + "Guaranteed": used("100m", "128Mi"),
+			SidecarPropertyPrefix + "istio-proxy" + propertySeparator + QOSPropertyPrefix + "Burstable":  used("100m", "128Mi"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := databasetest.NewMemoryStore()
+			r := &MonitorReconciler{
+				Client:            c,
+				Logger:            logr.Discard(),
+				DBClient:          db,
+				Properties:        resources.DefaultPropertyTypeLS,
+				MeteringPolicy:    MeteringPolicyRequests,
+				GpuMeteringPolicy: GpuMeteringPolicyReservation,
+				SidecarContainers: tt.sidecars,
+				MeterByQOSClass:   tt.byQOS,
+			}
+			if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+				t.Fatalf("monitorResourceUsage() error = %v", err)
+			}
+			got := map[string]resources.EnumUsedMap{}
+			for _, monitor := range db.Monitors() {
+				got[monitor.Property] = monitor.Used
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("monitors = %v, want %v", got, tt.want)
+			}
+			for property, want := range tt.want {
+				for enum, units := range want {
+					if got[property][enum] != units {
+						t.Errorf("property %q enum %d = %d, want %d", property, enum, got[property][enum], units)
+					}
+				}
+			}
+		})
+	}
+}
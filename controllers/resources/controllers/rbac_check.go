@@ -0,0 +1,128 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+const (
+	// RBACCheck reviews the permissions of the controller at the start: disabled, warn (default) logs the missing
+	// permissions, fail exits if any is missing
+	RBACCheck = "RBAC_CHECK"
+
+	RBACCheckDisabled = "disabled"
+	RBACCheckWarn     = "warn"
+	RBACCheckFail     = "fail"
+
+	rbacCheckTimeout = 30 * time.Second
+)
+
+// requiredPermission a verb on a resource the controller requires, see the kubebuilder rbac markers
+type requiredPermission struct {
+	group       string
+	resource    string
+	subresource string
+	verb        string
+}
+
+func (p requiredPermission) String() string {
+	resource := p.resource
+	if p.group != "" {
+		resource += "." + p.group
+	}
+	if p.subresource != "" {
+		resource += "/" + p.subresource
+	}
+	return p.verb + " " + resource
+}
+
+// requiredPermissions the permissions used by the metering, the markers of the resources not read (eg: the
+// resourcequotas and the infras) are not required
+var requiredPermissions = func() []requiredPermission {
+	var permissions []requiredPermission
+	add := func(group, resource string, verbs ...string) {
+		for _, verb := range verbs {
+			permissions = append(permissions, requiredPermission{group: group, resource: resource, verb: verb})
+		}
+	}
+	for _, resource := range []string{"namespaces", "pods", "persistentvolumeclaims", "services", "nodes"} {
+		add("", resource, "get", "list", "watch")
+	}
+	add("", "configmaps", "get")
+	add("", "events", "create", "patch")
+	add("resources.sealos.io", "monitorpolicies", "get", "list", "watch")
+	return permissions
+}()
+
+// checkRBAC reviews the permissions by SelfSubjectAccessReviews, and returns the denied ones
+func checkRBAC(ctx context.Context, c client.Client, permissions []requiredPermission) ([]string, error) {
+	var missing []string
+	for _, permission := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Group:       permission.group,
+				Resource:    permission.resource,
+				Subresource: permission.subresource,
+				Verb:        permission.verb,
+			}},
+		}
+		if err := c.Create(ctx, review); err != nil {
+			return nil, fmt.Errorf("failed to review %s: %w", permission, err)
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, permission.String())
+		}
+	}
+	return missing, nil
+}
+
+// CheckRBACFromEnv reviews the permissions of the controller by RBACCheck and logs the missing ones at once, instead
+// of the errors of each namespace deep in the reconcile. It returns an error in the fail mode if any is missing or the
+// reviews failed.
+func CheckRBACFromEnv(ctx context.Context, c client.Client, logger logr.Logger) error {
+	mode := env.GetEnvWithDefault(RBACCheck, RBACCheckWarn)
+	switch mode {
+	case RBACCheckDisabled:
+		return nil
+	case RBACCheckWarn, RBACCheckFail:
+	default:
+		return fmt.Errorf("invalid %s %q, must be one of: %s, %s, %s", RBACCheck, mode, RBACCheckDisabled, RBACCheckWarn, RBACCheckFail)
+	}
+	ctx, cancel := context.WithTimeout(ctx, rbacCheckTimeout)
+	defer cancel()
+	missing, err := checkRBAC(ctx, c, requiredPermissions)
+	if err == nil && len(missing) == 0 {
+		logger.Info("rbac permissions checked", "permissions", len(requiredPermissions))
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("missing %d of %d rbac permissions: %s", len(missing), len(requiredPermissions), strings.Join(missing, ", "))
+	}
+	if mode == RBACCheckFail {
+		return err
+	}
+	logger.Error(err, "the namespaces may fail to be metered, check the role of the controller")
+	return nil
+}
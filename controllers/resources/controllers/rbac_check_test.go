@@ -0,0 +1,96 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeAuthorizer answers the self subject access reviews, the resources denied are not allowed any verb
+type fakeAuthorizer struct {
+	client.Client
+	denied  map[string]bool
+	err     error
+	reviews int
+}
+
+func (a *fakeAuthorizer) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	review, ok := obj.(*authorizationv1.SelfSubjectAccessReview)
+	if !ok {
+		return errors.New("only the access reviews are created")
+	}
+	a.reviews++
+	if a.err != nil {
+		return a.err
+	}
+	review.Status.Allowed = !a.denied[review.Spec.ResourceAttributes.Resource]
+	return nil
+}
+
+func TestCheckRBAC(t *testing.T) {
+	authorizer := &fakeAuthorizer{denied: map[string]bool{"pods": true}}
+	missing, err := checkRBAC(context.Background(), authorizer, requiredPermissions)
+	if err != nil {
+		t.Fatalf("checkRBAC() error = %v", err)
+	}
+	if want := []string{"get pods", "list pods", "watch pods"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("checkRBAC() missing = %v, want %v", missing, want)
+	}
+	if authorizer.reviews != len(requiredPermissions) {
+		t.Errorf("reviews = %d, want one per permission %d", authorizer.reviews, len(requiredPermissions))
+	}
+	if got := (requiredPermission{group: "resources.sealos.io", resource: "monitorpolicies", subresource: "status", verb: "get"}).String(); got != "get monitorpolicies.resources.sealos.io/status" {
+		t.Errorf("permission = %q", got)
+	}
+}
+
+func TestCheckRBACFromEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		denied      map[string]bool
+		reviewErr   error
+		wantErr     bool
+		wantReviews bool
+	}{
+		{name: "default warns", denied: map[string]bool{"pods": true}, wantReviews: true},
+		{name: "fail with a missing permission", mode: RBACCheckFail, denied: map[string]bool{"pods": true}, wantErr: true, wantReviews: true},
+		{name: "fail with all granted", mode: RBACCheckFail, wantReviews: true},
+		{name: "fail if the reviews failed", mode: RBACCheckFail, reviewErr: errors.New("forbidden"), wantErr: true, wantReviews: true},
+		{name: "warn if the reviews failed", mode: RBACCheckWarn, reviewErr: errors.New("forbidden"), wantReviews: true},
+		{name: "disabled", mode: RBACCheckDisabled, denied: map[string]bool{"pods": true}},
+		{name: "invalid", mode: "strict", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(RBACCheck, tt.mode)
+			authorizer := &fakeAuthorizer{denied: tt.denied, err: tt.reviewErr}
+			err := CheckRBACFromEnv(context.Background(), authorizer, logr.Discard())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckRBACFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (authorizer.reviews > 0) != tt.wantReviews {
+				t.Errorf("reviews = %d, want reviewed %v", authorizer.reviews, tt.wantReviews)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	// SidecarContainerNames the comma separated names of the sidecar containers metered apart from their app, eg: istio-proxy
+	SidecarContainerNames = "SIDECAR_CONTAINER_NAMES"
+
+	// SidecarPropertyPrefix the property of the monitors of a sidecar is the prefix followed by the container name, eg: sidecar/istio-proxy
+	SidecarPropertyPrefix = "sidecar/"
+)
+
+// isSidecar returns true if the container is one of the SidecarContainers
+func (r *MonitorReconciler) isSidecar(container string) bool {
+	for _, name := range r.SidecarContainers {
+		if name == container {
+			return true
+		}
+	}
+	return false
+}
+
+// containerResource returns the key of the resource the cpu and memory of the container are metered to.
+// The sidecars, eg: the envoy proxies of a service mesh, are metered to the app of the pod tagged with the sidecar property,
+// so their overhead is a line item of its own instead of a part of the app. The other containers are metered to the pod resource.
+func (r *MonitorReconciler) containerResource(pod *corev1.Pod, podRes *resources.ResourceNamed, container string,
+	resNamed map[string]*resources.ResourceNamed, resUsed map[string]map[corev1.ResourceName]*quantity) string {
+	if !r.isSidecar(container) {
+		return podRes.String()
+	}
+	// the sidecar keeps the QoS class of the pod
+	sidecarRes := resources.NewResourceNamed(pod).WithProperty(joinProperties(SidecarPropertyPrefix+container, podRes.Property()))
+	if resUsed[sidecarRes.String()] == nil {
+		resNamed[sidecarRes.String()] = sidecarRes
+		resUsed[sidecarRes.String()] = initResources()
+	}
+	return sidecarRes.String()
+}
@@ -0,0 +1,97 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMonitorReconciler_monitorResourceUsage_Sidecar(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	container := func(name, cpu, memory string) corev1.Container {
+		return corev1.Container{Name: name, Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)},
+		}}
+	}
+	meshed := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: "app-a-0", Labels: map[string]string{resources.AppLabelKey: "app-a"}},
+		Spec: corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{
+			container("app", "1", "1Gi"),
+			container("istio-proxy", "100m", "128Mi"),
+		}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+	}
+	cpu := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()]
+	memory := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceMemory.String()]
+	used := func(cpuQuantity, memoryQuantity string) resources.EnumUsedMap {
+		c, m := resource.MustParse(cpuQuantity), resource.MustParse(memoryQuantity)
+		return resources.EnumUsedMap{cpu.Enum: cpu.UsedUnits(c.MilliValue()), memory.Enum: memory.UsedUnits(m.MilliValue())}
+	}
+
+	tests := []struct {
+		name     string
+		sidecars []string
+		want     map[string]resources.EnumUsedMap
+	}{
+		{name: "sidecar in the pod total by default",
+			want: map[string]resources.EnumUsedMap{"": used("1100m", "1152Mi")}},
+		{name: "sidecar metered apart", sidecars: []string{"linkerd-proxy", "istio-proxy"},
+			want: map[string]resources.EnumUsedMap{"": used("1", "1Gi"), SidecarPropertyPrefix + "istio-proxy": used("100m", "128Mi")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := databasetest.NewMemoryStore()
+			r := &MonitorReconciler{
+				Client:            fake.NewClientBuilder().WithObjects(meshed.DeepCopy()).Build(),
+				Logger:            logr.Discard(),
+				DBClient:          db,
+				Properties:        resources.DefaultPropertyTypeLS,
+				MeteringPolicy:    MeteringPolicyRequests,
+				GpuMeteringPolicy: GpuMeteringPolicyReservation,
+				SidecarContainers: tt.sidecars,
+			}
+			if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+				t.Fatalf("monitorResourceUsage() error = %v", err)
+			}
+			got := map[string]resources.EnumUsedMap{}
+			for _, monitor := range db.Monitors() {
+				if monitor.Name != "app-a" || monitor.Type != resources.AppType[resources.APP] {
+					t.Errorf("monitor %s/%d, want the app app-a", monitor.Name, monitor.Type)
+				}
+				got[monitor.Property] = monitor.Used
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("monitors = %v, want %v", got, tt.want)
+			}
+			for property, want := range tt.want {
+				for enum, units := range want {
+					if got[property][enum] != units {
+						t.Errorf("property %q enum %d = %d, want %d", property, enum, got[property][enum], units)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+const (
+	// SubMinuteSampleInterval samples the resource usage every interval within the minute, eg: 15s, the monitor of the
+	// minute aggregates the samples. Disabled by default (0), the interval must divide the minute.
+	SubMinuteSampleInterval = "SUB_MINUTE_SAMPLE_INTERVAL"
+	// SubMinuteSampleAggregation the aggregation of the samples of the minute: avg (default) or max
+	SubMinuteSampleAggregation = "SUB_MINUTE_SAMPLE_AGGREGATION"
+
+	SampleAggregationAvg = "avg"
+	SampleAggregationMax = "max"
+)
+
+// subSampler accumulates the sub-minute samples of each namespace until the minute pass aggregates them into the monitors
+// of the minute. The object storage is sampled once per minute and passed through as is.
+type subSampler struct {
+	interval    time.Duration
+	aggregation string
+
+	mu         sync.Mutex
+	namespaces map[string]*namespaceSamples
+}
+
+// namespaceSamples the samples of a namespace since the last minute pass
+type namespaceSamples struct {
+	count    int64
+	monitors map[string]*sampledMonitor
+}
+
+// sampledMonitor the latest sample of a resource and the sum or the max of its used over the samples
+type sampledMonitor struct {
+	monitor *resources.Monitor
+	used    resources.EnumUsedMap
+}
+
+// newSubSamplerFromEnv returns nil if the sampling is disabled, a value without a unit (eg: 15) fails instead of disabling it
+func newSubSamplerFromEnv() (*subSampler, error) {
+	raw := os.Getenv(SubMinuteSampleInterval)
+	if raw == "" {
+		return nil, nil
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", SubMinuteSampleInterval, raw, err)
+	}
+	if interval <= 0 {
+		return nil, nil
+	}
+	return newSubSampler(interval, os.Getenv(SubMinuteSampleAggregation))
+}
+
+func newSubSampler(interval time.Duration, aggregation string) (*subSampler, error) {
+	if interval >= time.Minute || time.Minute%interval != 0 {
+		return nil, fmt.Errorf("invalid %s %s, must divide the minute", SubMinuteSampleInterval, interval)
+	}
+	switch aggregation = strings.ToLower(strings.TrimSpace(aggregation)); aggregation {
+	case "":
+		aggregation = SampleAggregationAvg
+	case SampleAggregationAvg, SampleAggregationMax:
+	default:
+		return nil, fmt.Errorf("invalid %s %q, must be %s or %s", SubMinuteSampleAggregation, aggregation, SampleAggregationAvg, SampleAggregationMax)
+	}
+	return &subSampler{interval: interval, aggregation: aggregation, namespaces: map[string]*namespaceSamples{}}, nil
+}
+
+func sampleKey(monitor *resources.Monitor) string {
+	return fmt.Sprintf("%d/%s/%s", monitor.Type, monitor.Name, monitor.Property)
+}
+
+func isObjStorageMonitor(monitor *resources.Monitor) bool {
+	return monitor.Type == resources.AppType[resources.ObjectStorage]
+}
+
+// add accumulates a sample of the namespace
+func (s *subSampler) add(namespace string, monitors []*resources.Monitor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addLocked(namespace, monitors)
+}
+
+func (s *subSampler) addLocked(namespace string, monitors []*resources.Monitor) {
+	samples := s.namespaces[namespace]
+	if samples == nil {
+		samples = &namespaceSamples{monitors: map[string]*sampledMonitor{}}
+		s.namespaces[namespace] = samples
+	}
+	samples.count++
+	for _, monitor := range monitors {
+		if isObjStorageMonitor(monitor) {
+			continue
+		}
+		key := sampleKey(monitor)
+		sampled := samples.monitors[key]
+		if sampled == nil {
+			sampled = &sampledMonitor{used: resources.EnumUsedMap{}}
+			samples.monitors[key] = sampled
+		}
+		sampled.monitor = monitor
+		for enum, used := range monitor.Used {
+			switch s.aggregation {
+			case SampleAggregationMax:
+				if used > sampled.used[enum] {
+					sampled.used[enum] = used
+				}
+			default:
+				sampled.used[enum] += used
+			}
+		}
+	}
+}
+
+// aggregate adds the sample of the minute pass and returns the monitors of the minute aggregated over the samples since
+// the last pass, the samples of the namespace are reset. A resource missing from a sample counts as unused in the average,
+// eg: a pod running for 15s of the minute is metered a quarter of its reservation. A nil sampler returns the monitors as is.
+func (s *subSampler) aggregate(namespace string, timeStamp time.Time, monitors []*resources.Monitor) []*resources.Monitor {
+	if s == nil {
+		return monitors
+	}
+	s.mu.Lock()
+	s.addLocked(namespace, monitors)
+	samples := s.namespaces[namespace]
+	delete(s.namespaces, namespace)
+	s.mu.Unlock()
+
+	var aggregated []*resources.Monitor
+	for _, monitor := range monitors {
+		if isObjStorageMonitor(monitor) {
+			aggregated = append(aggregated, monitor)
+		}
+	}
+	keys := make([]string, 0, len(samples.monitors))
+	for key := range samples.monitors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sampled := samples.monitors[key]
+		monitor := *sampled.monitor
+		monitor.Time = timeStamp
+		monitor.Used = make(resources.EnumUsedMap, len(sampled.used))
+		for enum, used := range sampled.used {
+			if s.aggregation == SampleAggregationAvg {
+				// rounded up, the usage of the minute is never metered below a unit
+				used = (used + samples.count - 1) / samples.count
+			}
+			monitor.Used[enum] = used
+		}
+		aggregated = append(aggregated, &monitor)
+	}
+	return aggregated
+}
+
+// forget drops the samples of the namespaces not in the active set, eg: deleted since the samples were taken
+func (s *subSampler) forget(active map[string]struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for namespace := range s.namespaces {
+		if _, ok := active[namespace]; !ok {
+			delete(s.namespaces, namespace)
+		}
+	}
+}
+
+// startSubSampling samples the namespaces at each interval within the minute, the sample at the minute is taken by the
+// minute pass. A sample not finished before the next one is cut short, its namespaces not sampled are skipped.
+func (r *MonitorReconciler) startSubSampling() {
+	interval := r.subSampler.interval
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if !waitNextMinute(r.clock(), r.stopCh) {
+			return
+		}
+		ticker := r.clock().NewTicker(interval)
+		for {
+			select {
+			case now := <-ticker.C():
+				// the ticks near the minute are left to the minute pass
+				if offset := now.Sub(now.Truncate(time.Minute)); offset < interval/2 || time.Minute-offset < interval/2 {
+					continue
+				}
+				r.sampleNamespaces(interval)
+			case <-r.stopCh:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (r *MonitorReconciler) sampleNamespaces(timeout time.Duration) {
+	if r.meteringValve.paused() {
+		return
+	}
+	namespaceList, err := r.getNamespaceList()
+	if err != nil {
+		r.Logger.Error(err, "failed to list namespaces to sample")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	processNamespaces(ctx, namespaceList.Items, int(concurrentLimit), func(namespace *corev1.Namespace) {
+		if !r.monitorPolicies.get(namespace).enabled() {
+			return
+		}
+		monitors, err := r.collectMonitors(namespace, time.Now().UTC(), true)
+		if err != nil {
+			r.Logger.Error(err, "failed to sample resource usage", "namespace", namespace.Name)
+			return
+		}
+		r.subSampler.add(namespace.Name, monitors)
+	})
+}
@@ -0,0 +1,152 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestSubSampler_aggregate(t *testing.T) {
+	const cpu, memory = 0, 1
+	minute := time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC)
+	sample := func(name string, cpuUsed, memoryUsed int64, at time.Time) *resources.Monitor {
+		return &resources.Monitor{Category: "ns-user-a", Type: resources.AppType[resources.APP], Name: name, Time: at,
+			Used: resources.EnumUsedMap{cpu: cpuUsed, memory: memoryUsed}}
+	}
+	objStorage := &resources.Monitor{Category: "ns-user-a", Type: resources.AppType[resources.ObjectStorage], Name: "bucket",
+		Time: minute, Used: resources.EnumUsedMap{2: 100}}
+	// 15s samples, app-b stops after the first one, the minute sample has the object storage
+	samples := [][]*resources.Monitor{
+		{sample("app-a", 1000, 512, minute.Add(-45*time.Second)), sample("app-b", 500, 256, minute.Add(-45*time.Second))},
+		{sample("app-a", 3000, 512, minute.Add(-30*time.Second))},
+		{sample("app-a", 2000, 1024, minute.Add(-15*time.Second))},
+	}
+	last := []*resources.Monitor{sample("app-a", 1000, 512, minute), objStorage}
+
+	tests := []struct {
+		aggregation string
+		want        map[string]resources.EnumUsedMap
+	}{
+		{aggregation: SampleAggregationAvg, want: map[string]resources.EnumUsedMap{
+			"app-a":  {cpu: 1750, memory: 640},
+			"app-b":  {cpu: 125, memory: 64},
+			"bucket": {2: 100},
+		}},
+		{aggregation: SampleAggregationMax, want: map[string]resources.EnumUsedMap{
+			"app-a":  {cpu: 3000, memory: 1024},
+			"app-b":  {cpu: 500, memory: 256},
+			"bucket": {2: 100},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.aggregation, func(t *testing.T) {
+			s, err := newSubSampler(15*time.Second, tt.aggregation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, monitors := range samples {
+				s.add("ns-user-a", monitors)
+			}
+			got := s.aggregate("ns-user-a", minute, last)
+			if len(got) != len(tt.want) {
+				t.Fatalf("aggregate() = %d monitors, want %d", len(got), len(tt.want))
+			}
+			for _, monitor := range got {
+				want := tt.want[monitor.Name]
+				for enum, used := range want {
+					if monitor.Used[enum] != used {
+						t.Errorf("%s used[%d] = %d, want %d", monitor.Name, enum, monitor.Used[enum], used)
+					}
+				}
+				if !monitor.Time.Equal(minute) {
+					t.Errorf("%s time = %v, want the minute %v", monitor.Name, monitor.Time, minute)
+				}
+			}
+			// the samples are reset by the minute pass
+			if got := s.aggregate("ns-user-a", minute.Add(time.Minute), last[:1]); len(got) != 1 || got[0].Used[cpu] != 1000 {
+				t.Errorf("next minute = %v, want the sample of the minute only", got)
+			}
+		})
+	}
+}
+
+func TestSubSampler_forget(t *testing.T) {
+	s, err := newSubSampler(30*time.Second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.add("ns-deleted", []*resources.Monitor{{Name: "app-a", Used: resources.EnumUsedMap{0: 1}}})
+	s.add("ns-user-a", []*resources.Monitor{{Name: "app-a", Used: resources.EnumUsedMap{0: 1}}})
+	s.forget(map[string]struct{}{"ns-user-a": {}})
+	if _, ok := s.namespaces["ns-deleted"]; ok {
+		t.Error("the samples of the deleted namespace are kept")
+	}
+	if _, ok := s.namespaces["ns-user-a"]; !ok {
+		t.Error("the samples of the active namespace are dropped")
+	}
+}
+
+func TestNewSubSampler(t *testing.T) {
+	tests := []struct {
+		interval    time.Duration
+		aggregation string
+		wantErr     bool
+	}{
+		{interval: 15 * time.Second},
+		{interval: 20 * time.Second, aggregation: "MAX"},
+		{interval: 25 * time.Second, wantErr: true},
+		{interval: time.Minute, wantErr: true},
+		{interval: 15 * time.Second, aggregation: "median", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := newSubSampler(tt.interval, tt.aggregation); (err != nil) != tt.wantErr {
+			t.Errorf("newSubSampler(%s, %q) error = %v, wantErr %v", tt.interval, tt.aggregation, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewSubSamplerFromEnv(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{raw: ""},
+		{raw: "0s"},
+		{raw: "15s", want: 15 * time.Second},
+		// the interval without a unit doesn't disable the sampling silently
+		{raw: "15", wantErr: true},
+		{raw: "25s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv(SubMinuteSampleInterval, tt.raw)
+		s, err := newSubSamplerFromEnv()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newSubSamplerFromEnv() of %q error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		// 0 is the sampling disabled
+		var got time.Duration
+		if s != nil {
+			got = s.interval
+		}
+		if got != tt.want {
+			t.Errorf("newSubSamplerFromEnv() of %q interval = %s, want %s", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// SyntheticLoad the synthetic cluster metered by RunSyntheticLoad
+type SyntheticLoad struct {
+	// Namespaces the size of the NamespaceList of the cycle
+	Namespaces int
+	// PodsPerNamespace the running pods of each namespace, spread over the apps
+	PodsPerNamespace int
+	// AppsPerNamespace the apps of each namespace, each app is a monitor per cycle
+	AppsPerNamespace int
+	// PVCsPerNamespace the bound pvcs of each namespace, spread over the apps
+	PVCsPerNamespace int
+	// NodePortsPerNamespace the NodePort services of each namespace, spread over the apps
+	NodePortsPerNamespace int
+	// Concurrency the namespaces metered at the same time, see CONCURRENT_LIMIT
+	Concurrency int
+}
+
+// SyntheticLoadResult the throughput of a cycle of the synthetic load
+type SyntheticLoadResult struct {
+	Namespaces int
+	// Monitors the monitors inserted by the cycle
+	Monitors            int64
+	Duration            time.Duration
+	NamespacesPerSecond float64
+	// AllocatedBytes the bytes allocated on the heap during the cycle
+	AllocatedBytes uint64
+	// HeapInUseBytes the heap in use at the end of the cycle
+	HeapInUseBytes uint64
+}
+
+// RunSyntheticLoad meters a synthetic NamespaceList through processNamespaceList to benchmark the reconcile loop
+// without a cluster. The pods, pvcs and services of a namespace are generated by a fake client on each list, as the
+// informer cache copies them, and the monitors are counted by a store discarding them, so the result is the cost of
+// the reconcile path alone. It sets the global concurrency limit during the cycle, so it must not run beside a reconciler.
+func RunSyntheticLoad(load SyntheticLoad) (*SyntheticLoadResult, error) {
+	if load.Namespaces <= 0 || load.AppsPerNamespace <= 0 {
+		return nil, fmt.Errorf("the synthetic load requires namespaces and apps, got %d namespaces and %d apps", load.Namespaces, load.AppsPerNamespace)
+	}
+	namespaces := make([]corev1.Namespace, load.Namespaces)
+	for i := range namespaces {
+		namespaces[i] = corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("ns-synthetic-%d", i)}}
+	}
+	store := &discardStore{}
+	r := &MonitorReconciler{
+		Client:            newSyntheticClient(load),
+		Logger:            logr.Discard(),
+		DBClient:          store,
+		Properties:        resources.DefaultPropertyTypeLS,
+		MeteringPolicy:    MeteringPolicyLimits,
+		GpuMeteringPolicy: GpuMeteringPolicyReservation,
+	}
+	limit := concurrentLimit
+	concurrentLimit = int64(load.Concurrency)
+	defer func() {
+		concurrentLimit = limit
+	}()
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	if err := r.processNamespaceList(&corev1.NamespaceList{Items: namespaces}, time.Time{}); err != nil {
+		return nil, err
+	}
+	duration := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return &SyntheticLoadResult{
+		Namespaces:          load.Namespaces,
+		Monitors:            atomic.LoadInt64(&store.inserted),
+		Duration:            duration,
+		NamespacesPerSecond: float64(load.Namespaces) / duration.Seconds(),
+		AllocatedBytes:      after.TotalAlloc - before.TotalAlloc,
+		HeapInUseBytes:      after.HeapInuse,
+	}, nil
+}
+
+// syntheticClient lists the same objects in every namespace, the other calls are not supported
+type syntheticClient struct {
+	client.Client
+	pods     []corev1.Pod
+	pvcs     []corev1.PersistentVolumeClaim
+	services []corev1.Service
+}
+
+func newSyntheticClient(load SyntheticLoad) *syntheticClient {
+	c := &syntheticClient{}
+	started := metav1.NewTime(time.Now().Add(-time.Hour))
+	app := func(i int) map[string]string {
+		return map[string]string{resources.AppLabelKey: fmt.Sprintf("app-%d", i%load.AppsPerNamespace)}
+	}
+	for i := 0; i < load.PodsPerNamespace; i++ {
+		c.pods = append(c.pods, corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i), Labels: app(i)},
+			Spec: corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse("512Mi"),
+				}},
+			}}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		})
+	}
+	for i := 0; i < load.PVCsPerNamespace; i++ {
+		c.pvcs = append(c.pvcs, corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pvc-%d", i), Labels: app(i)},
+			Spec: corev1.PersistentVolumeClaimSpec{Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			}},
+			Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		})
+	}
+	for i := 0; i < load.NodePortsPerNamespace; i++ {
+		c.services = append(c.services, corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("svc-%d", i), Labels: app(i)},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, Ports: []corev1.ServicePort{{Port: 80, NodePort: int32(30000 + i)}}},
+		})
+	}
+	return c
+}
+
+func (c *syntheticClient) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	namespace := (&client.ListOptions{}).ApplyOptions(opts).Namespace
+	switch l := list.(type) {
+	case *corev1.PodList:
+		l.Items = make([]corev1.Pod, len(c.pods))
+		for i := range c.pods {
+			c.pods[i].DeepCopyInto(&l.Items[i])
+			l.Items[i].Namespace = namespace
+		}
+	case *corev1.PersistentVolumeClaimList:
+		l.Items = make([]corev1.PersistentVolumeClaim, len(c.pvcs))
+		for i := range c.pvcs {
+			c.pvcs[i].DeepCopyInto(&l.Items[i])
+			l.Items[i].Namespace = namespace
+		}
+	case *corev1.ServiceList:
+		l.Items = make([]corev1.Service, len(c.services))
+		for i := range c.services {
+			c.services[i].DeepCopyInto(&l.Items[i])
+			l.Items[i].Namespace = namespace
+		}
+	default:
+		return fmt.Errorf("the synthetic client doesn't list %T", list)
+	}
+	return nil
+}
+
+// discardStore counts the inserted monitors and discards them, the other calls are not supported
+type discardStore struct {
+	database.MonitorStore
+	inserted int64
+}
+
+func (s *discardStore) InsertMonitor(_ context.Context, monitors ...*resources.Monitor) error {
+	atomic.AddInt64(&s.inserted, int64(len(monitors)))
+	return nil
+}
+
+func (s *discardStore) InsertMonitorBatch(_ context.Context, monitors []*resources.Monitor) error {
+	atomic.AddInt64(&s.inserted, int64(len(monitors)))
+	return nil
+}
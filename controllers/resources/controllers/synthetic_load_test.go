@@ -0,0 +1,140 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/labring/sealos/controllers/pkg/database/stdout"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestRunSyntheticLoad(t *testing.T) {
+	limit := concurrentLimit
+	result, err := RunSyntheticLoad(SyntheticLoad{
+		Namespaces: 50, PodsPerNamespace: 6, AppsPerNamespace: 3, PVCsPerNamespace: 3, NodePortsPerNamespace: 1, Concurrency: 8,
+	})
+	if err != nil {
+		t.Fatalf("RunSyntheticLoad() error = %v", err)
+	}
+	// the pods, pvcs and node ports of an app are a monitor
+	if result.Namespaces != 50 || result.Monitors != 50*3 {
+		t.Errorf("RunSyntheticLoad() = %d namespaces, %d monitors, want 50 namespaces, 150 monitors", result.Namespaces, result.Monitors)
+	}
+	if result.NamespacesPerSecond <= 0 || result.AllocatedBytes == 0 {
+		t.Errorf("RunSyntheticLoad() = %v namespaces/s, %d bytes allocated, want measured", result.NamespacesPerSecond, result.AllocatedBytes)
+	}
+	if concurrentLimit != limit {
+		t.Errorf("concurrent limit = %d after the load, want restored to %d", concurrentLimit, limit)
+	}
+	if _, err := RunSyntheticLoad(SyntheticLoad{Namespaces: 1}); err == nil {
+		t.Error("RunSyntheticLoad() without apps expected error")
+	}
+}
+
+// countingWriter buffers the output and counts the writes
+type countingWriter struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func TestSyntheticCycle_stdout(t *testing.T) {
+	limit := concurrentLimit
+	concurrentLimit = 4
+	defer func() {
+		concurrentLimit = limit
+	}()
+	load := SyntheticLoad{Namespaces: 4, PodsPerNamespace: 2, AppsPerNamespace: 2, PVCsPerNamespace: 2}
+	namespaces := make([]corev1.Namespace, load.Namespaces)
+	for i := range namespaces {
+		namespaces[i] = corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("ns-synthetic-%d", i)}}
+	}
+	out := &countingWriter{}
+	r := &MonitorReconciler{
+		Client:            newSyntheticClient(load),
+		Logger:            logr.Discard(),
+		DBClient:          stdout.NewWriterInterface(out),
+		Properties:        resources.DefaultPropertyTypeLS,
+		MeteringPolicy:    MeteringPolicyLimits,
+		GpuMeteringPolicy: GpuMeteringPolicyReservation,
+	}
+	// the batches of 2 namespaces are written at once, like the bulk inserts of a database
+	r.monitorWriter = newMonitorWriter(r.insertMonitorDetailed, 4, time.Hour, 1)
+	r.monitorWriter.start()
+	if err := r.processNamespaceList(&corev1.NamespaceList{Items: namespaces}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	r.monitorWriter.stop()
+
+	if out.writes != 2 {
+		t.Errorf("the cycle wrote %d times, want a write per batch", out.writes)
+	}
+	apps := map[string]bool{}
+	scanner := bufio.NewScanner(&out.buf)
+	for scanner.Scan() {
+		var monitor resources.Monitor
+		if err := json.Unmarshal(scanner.Bytes(), &monitor); err != nil {
+			t.Fatalf("invalid json line %q: %v", scanner.Text(), err)
+		}
+		if monitor.MonitorID == "" || len(monitor.Used) == 0 {
+			t.Errorf("monitor = %+v, want the id and the usage", monitor)
+		}
+		apps[monitor.Category+"/"+monitor.Name] = true
+	}
+	if len(apps) != load.Namespaces*load.AppsPerNamespace {
+		t.Errorf("the cycle wrote the monitors of %v, want the 2 apps of each namespace", apps)
+	}
+}
+
+// BenchmarkProcessNamespaceList meters a synthetic cluster per iteration by the concurrency, eg:
+// -bench ProcessNamespaceList -benchtime 3x reports the namespaces/s and the heap of 30000 namespaces.
+func BenchmarkProcessNamespaceList(b *testing.B) {
+	for _, concurrency := range []int{10, 100, DefaultConcurrencyLimit} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			load := SyntheticLoad{
+				Namespaces: 30000, PodsPerNamespace: 4, AppsPerNamespace: 2, PVCsPerNamespace: 2, NodePortsPerNamespace: 1, Concurrency: concurrency,
+			}
+			var throughput, heap float64
+			for i := 0; i < b.N; i++ {
+				result, err := RunSyntheticLoad(load)
+				if err != nil {
+					b.Fatal(err)
+				}
+				throughput += result.NamespacesPerSecond
+				heap += float64(result.HeapInUseBytes)
+			}
+			b.ReportMetric(throughput/float64(b.N), "namespaces/s")
+			b.ReportMetric(heap/float64(b.N)/(1<<20), "heap-MiB")
+		})
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/labring/sealos/controllers/pkg/database"
+)
+
+// DeletedTenantPurgeGracePeriod is the time to keep the monitors of a deleted tenant namespace before purging them,
+// eg: 72h. The monitors are kept if not set.
+const DeletedTenantPurgeGracePeriod = "DELETED_TENANT_PURGE_GRACE_PERIOD"
+
+// parsePurgeGracePeriod returns the grace period of the deleted tenants, 0 if not set. A value without a unit
+// (eg: 72) is rejected instead of never purging, the monitors of the deleted tenants would be kept silently.
+func parsePurgeGracePeriod(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	gracePeriod, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", DeletedTenantPurgeGracePeriod, raw, err)
+	}
+	if gracePeriod < 0 {
+		return 0, fmt.Errorf("invalid %s %v: must not be negative, not set disables the purge", DeletedTenantPurgeGracePeriod, gracePeriod)
+	}
+	return gracePeriod, nil
+}
+
+func newPurgeGracePeriodFromEnv() (time.Duration, error) {
+	return parsePurgeGracePeriod(os.Getenv(DeletedTenantPurgeGracePeriod))
+}
+
+// tenantTracker diffs the tenant namespaces between reconcile cycles to find the deleted tenants.
+// It is only kept in memory, namespaces deleted while the controller is down are not tracked.
+type tenantTracker struct {
+	mu sync.Mutex
+	// namespaces seen in the last cycle
+	known map[string]struct{}
+	// deleted namespaces and the time they were found deleted
+	deleted map[string]time.Time
+}
+
+func newTenantTracker() *tenantTracker {
+	return &tenantTracker{
+		known:   make(map[string]struct{}),
+		deleted: make(map[string]time.Time),
+	}
+}
+
+// sync records the current tenant namespaces and returns the namespaces that should still be metered,
+// terminating namespaces are treated as deleted and are not metered anymore.
+func (t *tenantTracker) sync(namespaceList *corev1.NamespaceList, now time.Time) *corev1.NamespaceList {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	active := &corev1.NamespaceList{}
+	current := make(map[string]struct{}, len(namespaceList.Items))
+	for i := range namespaceList.Items {
+		ns := namespaceList.Items[i]
+		if ns.DeletionTimestamp != nil || ns.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		current[ns.Name] = struct{}{}
+		// namespace with the same name is recreated, cancel purge
+		delete(t.deleted, ns.Name)
+		active.Items = append(active.Items, ns)
+	}
+	for name := range t.known {
+		if _, ok := current[name]; !ok {
+			if _, ok := t.deleted[name]; !ok {
+				t.deleted[name] = now
+			}
+		}
+	}
+	t.known = current
+	return active
+}
+
+// expired returns the deleted namespaces which exceed the grace period
+func (t *tenantTracker) expired(gracePeriod time.Duration, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var names []string
+	for name, deletedAt := range t.deleted {
+		if now.Sub(deletedAt) >= gracePeriod {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (t *tenantTracker) purged(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.deleted, name)
+}
+
+// TenantPurgeOptions the options of a tenant purge
+type TenantPurgeOptions struct {
+	// Requester who requested the purge, eg: the account controller of a terminated user, recorded by the audit log
+	Requester string
+	// DryRun only counts the records which would be deleted
+	DryRun bool
+}
+
+// TenantPurgeResult the number of the records of the tenant deleted, or counted by a dry run
+type TenantPurgeResult struct {
+	// Monitors the monitors, the rollups and the aggregates
+	Monitors int64
+	// Traffic the raw traffic records, 0 if the traffic source keeps no records (eg: prometheus)
+	Traffic int64
+}
+
+// requesterRetention the requester of the purges of the deleted tenants after the grace period
+const requesterRetention = "deleted-tenant-retention"
+
+// PurgeTenantMonitors deletes all monitors and traffic records of the tenant namespace immediately, eg: for the deletion
+// request of a terminated user account. Every purge, including a dry run and a failed one, is recorded by the audit log.
+func (r *MonitorReconciler) PurgeTenantMonitors(namespace string, opts TenantPurgeOptions) (TenantPurgeResult, error) {
+	result, err := r.purgeTenant(namespace, opts.DryRun)
+	audit := []interface{}{"namespace", namespace, "requester", opts.Requester, "dryRun", opts.DryRun,
+		"monitors", result.Monitors, "traffic", result.Traffic}
+	if err != nil {
+		r.Logger.WithName("audit").Error(err, "failed to purge tenant", audit...)
+		return result, fmt.Errorf("failed to purge namespace %s: %w", namespace, err)
+	}
+	if !opts.DryRun {
+		r.tenants.purged(namespace)
+	}
+	r.Logger.WithName("audit").Info("purged tenant", audit...)
+	return result, nil
+}
+
+// purgeTenant deletes or counts the records of the namespace, the records deleted before a failure are still counted
+func (r *MonitorReconciler) purgeTenant(namespace string, dryRun bool) (TenantPurgeResult, error) {
+	var (
+		result TenantPurgeResult
+		err    error
+	)
+	source := r.TrafficClient
+	if instrumented, ok := source.(*database.InstrumentedTraffic); ok {
+		source = instrumented.Traffic
+	}
+	traffic, purgeTraffic := source.(database.TrafficPurger)
+	if dryRun {
+		if result.Monitors, err = r.DBClient.CountMonitorsByCategory(namespace); err != nil {
+			return result, fmt.Errorf("failed to count monitors: %w", err)
+		}
+		if purgeTraffic {
+			if result.Traffic, err = traffic.CountTrafficByNamespace(namespace); err != nil {
+				return result, fmt.Errorf("failed to count traffic: %w", err)
+			}
+		}
+		return result, nil
+	}
+	if result.Monitors, err = r.DBClient.DeleteMonitorsByCategory(namespace); err != nil {
+		return result, fmt.Errorf("failed to delete monitors: %w", err)
+	}
+	if purgeTraffic {
+		if result.Traffic, err = traffic.DeleteTrafficByNamespace(namespace); err != nil {
+			return result, fmt.Errorf("failed to delete traffic: %w", err)
+		}
+	}
+	return result, nil
+}
+
+func (r *MonitorReconciler) purgeDeletedTenants() {
+	if r.PurgeGracePeriod <= 0 {
+		return
+	}
+	for _, namespace := range r.tenants.expired(r.PurgeGracePeriod, time.Now()) {
+		if _, err := r.PurgeTenantMonitors(namespace, TenantPurgeOptions{Requester: requesterRetention}); err != nil {
+			r.Logger.Error(err, "failed to purge deleted tenant monitors", "namespace", namespace)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestMonitorReconciler_PurgeTenantMonitors(t *testing.T) {
+	app := resources.AppType[resources.APP]
+	at := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	db := databasetest.NewMemoryStore()
+	if err := db.InsertMonitor(context.Background(),
+		&resources.Monitor{Time: at, Category: "ns-terminated", Type: app, Name: "app-a", Used: resources.EnumUsedMap{0: 100}},
+		&resources.Monitor{Time: at.Add(time.Minute), Category: "ns-terminated", Type: app, Name: "app-a", Used: resources.EnumUsedMap{0: 100}},
+		&resources.Monitor{Time: at, Category: "ns-user-a", Type: app, Name: "app-a", Used: resources.EnumUsedMap{0: 100}},
+	); err != nil {
+		t.Fatal(err)
+	}
+	db.AddTraffic(
+		databasetest.TrafficRecord{Time: at, Namespace: "ns-terminated", Type: app, Name: "app-a", Pod: "app-a-0", Sent: 100},
+		databasetest.TrafficRecord{Time: at, Namespace: "ns-user-a", Type: app, Name: "app-a", Pod: "app-a-0", Sent: 100},
+	)
+	var audit []string
+	logger := funcr.New(func(prefix, args string) {
+		if strings.HasSuffix(prefix, "audit") {
+			audit = append(audit, args)
+		}
+	}, funcr.Options{})
+	r := &MonitorReconciler{Logger: logger, DBClient: db, TrafficClient: db, tenants: newTenantTracker()}
+
+	// the dry run only counts
+	result, err := r.PurgeTenantMonitors("ns-terminated", TenantPurgeOptions{Requester: "account-controller", DryRun: true})
+	if err != nil {
+		t.Fatalf("PurgeTenantMonitors() dry run error = %v", err)
+	}
+	if result != (TenantPurgeResult{Monitors: 2, Traffic: 1}) {
+		t.Errorf("PurgeTenantMonitors() dry run = %+v, want 2 monitors and 1 traffic", result)
+	}
+	if got := db.Monitors(); len(got) != 3 {
+		t.Errorf("monitors after the dry run = %d, want 3", len(got))
+	}
+
+	result, err = r.PurgeTenantMonitors("ns-terminated", TenantPurgeOptions{Requester: "account-controller"})
+	if err != nil {
+		t.Fatalf("PurgeTenantMonitors() error = %v", err)
+	}
+	if result != (TenantPurgeResult{Monitors: 2, Traffic: 1}) {
+		t.Errorf("PurgeTenantMonitors() = %+v, want 2 monitors and 1 traffic", result)
+	}
+	// the other namespaces are untouched
+	for _, monitor := range db.Monitors() {
+		if monitor.Category != "ns-user-a" {
+			t.Errorf("monitor of %s is kept", monitor.Category)
+		}
+	}
+	if got := db.Monitors(); len(got) != 1 {
+		t.Errorf("monitors after the purge = %d, want 1 of ns-user-a", len(got))
+	}
+	if count, err := db.CountTrafficByNamespace("ns-terminated"); err != nil || count != 0 {
+		t.Errorf("traffic of the purged namespace = %d, %v, want 0", count, err)
+	}
+	if count, err := db.CountTrafficByNamespace("ns-user-a"); err != nil || count != 1 {
+		t.Errorf("traffic of ns-user-a = %d, %v, want 1", count, err)
+	}
+
+	if len(audit) != 2 {
+		t.Fatalf("audit entries = %v, want the dry run and the purge", audit)
+	}
+	for i, dryRun := range []string{`"dryRun"=true`, `"dryRun"=false`} {
+		for _, want := range []string{`"namespace"="ns-terminated"`, `"requester"="account-controller"`, dryRun, `"monitors"=2`, `"traffic"=1`} {
+			if !strings.Contains(audit[i], want) {
+				t.Errorf("audit entry %d = %s, want %s", i, audit[i], want)
+			}
+		}
+	}
+}
+
+func TestMonitorReconciler_PurgeTenantMonitors_Failed(t *testing.T) {
+	db := databasetest.NewMemoryStore()
+	if err := db.InsertMonitor(context.Background(), &resources.Monitor{Time: time.Now(), Category: "ns-terminated", Name: "app-a"}); err != nil {
+		t.Fatal(err)
+	}
+	var audit []string
+	logger := funcr.New(func(prefix, args string) {
+		audit = append(audit, args)
+	}, funcr.Options{})
+	r := &MonitorReconciler{Logger: logger, DBClient: db, tenants: newTenantTracker()}
+	r.tenants.deleted["ns-terminated"] = time.Now()
+
+	db.FailNext(1, nil)
+	if _, err := r.PurgeTenantMonitors("ns-terminated", TenantPurgeOptions{Requester: "account-controller"}); err == nil {
+		t.Fatal("PurgeTenantMonitors() expected the injected error")
+	}
+	if _, ok := r.tenants.deleted["ns-terminated"]; !ok {
+		t.Error("the failed purge is not retried after the grace period")
+	}
+	if len(audit) != 1 || !strings.Contains(audit[0], `"requester"="account-controller"`) {
+		t.Errorf("audit entries = %v, want the failed purge", audit)
+	}
+	if got := db.Monitors(); len(got) != 1 {
+		t.Errorf("monitors after the failed purge = %d, want 1", len(got))
+	}
+}
+
+func TestParsePurgeGracePeriod(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{raw: "", want: 0},
+		{raw: "72h", want: 72 * time.Hour},
+		{raw: "0s", want: 0},
+		// the hours without the unit are not read as never purge
+		{raw: "72", wantErr: true},
+		{raw: "3d", wantErr: true},
+		{raw: "-1h", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePurgeGracePeriod(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePurgeGracePeriod(%q) = %v, %v, want %v, wantErr %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTenantTracker(t *testing.T) {
+	namespaces := func(names ...string) *corev1.NamespaceList {
+		list := &corev1.NamespaceList{}
+		for _, name := range names {
+			list.Items = append(list.Items, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+		return list
+	}
+	names := func(list *corev1.NamespaceList) []string {
+		var names []string
+		for _, ns := range list.Items {
+			names = append(names, ns.Name)
+		}
+		return names
+	}
+	at := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tracker := newTenantTracker()
+
+	terminating := namespaces("ns-user-a", "ns-user-b", "ns-user-c")
+	terminating.Items[2].Status.Phase = corev1.NamespaceTerminating
+	if got := names(tracker.sync(terminating, at)); !reflect.DeepEqual(got, []string{"ns-user-a", "ns-user-b"}) {
+		t.Errorf("sync() = %v, want the terminating namespace not metered", got)
+	}
+	// ns-user-b is deleted in the next cycle, ns-user-c was never metered
+	tracker.sync(namespaces("ns-user-a"), at.Add(time.Minute))
+	if got := tracker.expired(time.Hour, at.Add(time.Hour)); len(got) != 0 {
+		t.Errorf("expired() in the grace period = %v, want none", got)
+	}
+	if got := tracker.expired(time.Hour, at.Add(time.Hour+time.Minute)); !reflect.DeepEqual(got, []string{"ns-user-b"}) {
+		t.Errorf("expired() after the grace period = %v, want ns-user-b", got)
+	}
+	// the deletion time is kept by the later cycles
+	tracker.sync(namespaces("ns-user-a"), at.Add(2*time.Minute))
+	if got := tracker.expired(time.Hour, at.Add(time.Hour+time.Minute)); !reflect.DeepEqual(got, []string{"ns-user-b"}) {
+		t.Errorf("expired() after a later cycle = %v, want ns-user-b", got)
+	}
+	// the namespace recreated with the same name cancels the purge
+	tracker.sync(namespaces("ns-user-a", "ns-user-b"), at.Add(3*time.Minute))
+	if got := tracker.expired(time.Hour, at.Add(2*time.Hour)); len(got) != 0 {
+		t.Errorf("expired() of the recreated namespace = %v, want none", got)
+	}
+}
+
+func TestMonitorReconciler_purgeDeletedTenants(t *testing.T) {
+	app := resources.AppType[resources.APP]
+	at := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, gracePeriod := range []time.Duration{0, time.Hour} {
+		db := databasetest.NewMemoryStore()
+		for _, namespace := range []string{"ns-expired", "ns-recent", "ns-user-a"} {
+			if err := db.InsertMonitor(context.Background(),
+				&resources.Monitor{Time: at, Category: namespace, Type: app, Name: "app-a", Used: resources.EnumUsedMap{0: 100}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, tenants: newTenantTracker(), PurgeGracePeriod: gracePeriod}
+		r.tenants.deleted["ns-expired"] = time.Now().Add(-2 * time.Hour)
+		r.tenants.deleted["ns-recent"] = time.Now()
+		r.purgeDeletedTenants()
+
+		var kept []string
+		for _, monitor := range db.Monitors() {
+			kept = append(kept, monitor.Category)
+		}
+		sort.Strings(kept)
+		want, wantTracked := []string{"ns-expired", "ns-recent", "ns-user-a"}, 2
+		if gracePeriod > 0 {
+			// only the tenant deleted before the grace period is purged, and is not purged again
+			want, wantTracked = []string{"ns-recent", "ns-user-a"}, 1
+		}
+		if !reflect.DeepEqual(kept, want) {
+			t.Errorf("grace period %v: monitors kept of %v, want %v", gracePeriod, kept, want)
+		}
+		if len(r.tenants.deleted) != wantTracked {
+			t.Errorf("grace period %v: deleted tenants tracked = %v, want %d", gracePeriod, r.tenants.deleted, wantTracked)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// TrafficSource provides the network traffic sent by the pods of an app (type + name) in the namespace
+type TrafficSource interface {
+	GetTrafficSentBytes(startTime, endTime time.Time, namespace string, _type uint8, name string) (int64, error)
+}
+
+const (
+	// TrafficSourceEnv selects the traffic source: mongo (sealos networkmanager, default) or cilium
+	TrafficSourceEnv    = "TRAFFIC_SOURCE"
+	TrafficSourceMongo  = "mongo"
+	TrafficSourceCilium = "cilium"
+	// CiliumTrafficMetric the prometheus counter of the pod egress bytes exported by cilium/hubble, the metric must
+	// have the labels source_namespace and source_workload (hubble labelsContext=source_namespace,source_workload)
+	CiliumTrafficMetric = "CILIUM_TRAFFIC_METRIC"
+)
+
+// ciliumTrafficSource queries the pod egress bytes of cilium/hubble metrics from prometheus.
+// The pods of the app are matched by the workload exported in the metric rather than by the live pods, so the traffic
+// of the pods deleted in the window is still metered, eg: the pods replaced by a rollout.
+type ciliumTrafficSource struct {
+	promAPI v1.API
+	metric  string
+}
+
+func NewCiliumTrafficSource(promURL, metric string) (TrafficSource, error) {
+	if promURL == "" || metric == "" {
+		return nil, fmt.Errorf("cilium traffic source requires env: %s, %s", PrometheusURL, CiliumTrafficMetric)
+	}
+	promClient, err := api.NewClient(api.Config{Address: promURL})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new prometheus client: %w", err)
+	}
+	return &ciliumTrafficSource{promAPI: v1.NewAPI(promClient), metric: metric}, nil
+}
+
+func (c *ciliumTrafficSource) GetTrafficSentBytes(startTime, endTime time.Time, namespace string, _type uint8, name string) (int64, error) {
+	workloads := ciliumWorkloadRegex(_type, name)
+	if workloads == "" {
+		return 0, nil
+	}
+	query := ciliumTrafficQuery(c.metric, namespace, workloads, endTime.Sub(startTime))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, _, err := c.promAPI.Query(ctx, query, endTime)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query prometheus, query: %v, err: %w", query, err)
+	}
+	vector, ok := result.(model.Vector)
+	if !ok {
+		return 0, fmt.Errorf("unexpected prometheus result type %s, query: %v", result.Type(), query)
+	}
+	var total float64
+	for _, sample := range vector {
+		total += float64(sample.Value)
+	}
+	return int64(total), nil
+}
+
+// ciliumWorkloadRegex returns the regex of the workloads of the pods named by the type and the name, see
+// resources.NewResourceNamed, empty if the pods can't be told by their workload:
+// the deployment or the statefulset of an app is named by the app, the statefulsets of a database cluster by
+// <cluster>-<component>, and the jobs by <name>-<suffix>
+func ciliumWorkloadRegex(_type uint8, name string) string {
+	if name == "" {
+		return ""
+	}
+	switch resources.AppTypeReverse[_type] {
+	case resources.APP:
+		return "^" + regexp.QuoteMeta(name) + "$"
+	case resources.DB:
+		return "^" + regexp.QuoteMeta(name) + "-.+$"
+	case resources.JOB:
+		return "^" + regexp.QuoteMeta(name) + "(-.*)?$"
+	}
+	return ""
+}
+
+func ciliumTrafficQuery(metric, namespace, workloads string, window time.Duration) string {
+	return fmt.Sprintf(`sum(increase(%s{source_namespace=%q, source_workload=~%q}[%ds]))`, metric, namespace, workloads, int64(window.Seconds()))
+}
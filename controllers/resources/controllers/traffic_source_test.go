@@ -0,0 +1,86 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestCiliumTrafficQuery(t *testing.T) {
+	tests := []struct {
+		_type uint8
+		name  string
+		want  string
+	}{
+		{_type: resources.AppType[resources.APP], name: "nginx",
+			want: `sum(increase(hubble_flows_bytes_total{source_namespace="ns-a", source_workload=~"^nginx$"}[3600s]))`},
+		{_type: resources.AppType[resources.APP], name: "web.v2",
+			want: `sum(increase(hubble_flows_bytes_total{source_namespace="ns-a", source_workload=~"^web\\.v2$"}[3600s]))`},
+		{_type: resources.AppType[resources.DB], name: "pg",
+			want: `sum(increase(hubble_flows_bytes_total{source_namespace="ns-a", source_workload=~"^pg-.+$"}[3600s]))`},
+		{_type: resources.AppType[resources.JOB], name: "backup",
+			want: `sum(increase(hubble_flows_bytes_total{source_namespace="ns-a", source_workload=~"^backup(-.*)?$"}[3600s]))`},
+		// the terminals and the other pods are not told by their workload
+		{_type: resources.AppType[resources.TERMINAL], name: ""},
+		{_type: resources.AppType[resources.OTHER], name: "pod"},
+	}
+	for _, tt := range tests {
+		var got string
+		if workloads := ciliumWorkloadRegex(tt._type, tt.name); workloads != "" {
+			got = ciliumTrafficQuery("hubble_flows_bytes_total", "ns-a", workloads, time.Hour)
+		}
+		if got != tt.want {
+			t.Errorf("query of %s %q = %s, want %s", resources.AppTypeReverse[tt._type], tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCiliumTrafficSource_DeletedPods(t *testing.T) {
+	var queries []string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		queries = append(queries, req.Form.Get("query"))
+		w.Header().Set("Content-Type", "application/json")
+		// the pods of the rollout in the window, all deleted by now
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"2048"]}]}}`)
+	}))
+	defer prom.Close()
+	source, err := NewCiliumTrafficSource(prom.URL, "hubble_flows_bytes_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	bytes, err := source.GetTrafficSentBytes(end.Add(-time.Hour), end, "ns-a", resources.AppType[resources.APP], "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes != 2048 {
+		t.Errorf("GetTrafficSentBytes() = %d, want the traffic of the deleted pods 2048", bytes)
+	}
+	if len(queries) != 1 || queries[0] != `sum(increase(hubble_flows_bytes_total{source_namespace="ns-a", source_workload=~"^nginx$"}[3600s]))` {
+		t.Errorf("queries = %q, want the workload of the app", queries)
+	}
+	if bytes, err := source.GetTrafficSentBytes(end.Add(-time.Hour), end, "ns-a", resources.AppType[resources.TERMINAL], ""); err != nil || bytes != 0 || len(queries) != 1 {
+		t.Errorf("terminal traffic = %d, %v, want 0 without a query", bytes, err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"time"
+	// the image has no zoneinfo, the billing time zone is loaded from the embedded database
+	_ "time/tzdata"
+)
+
+const (
+	// TrafficWindow the window of the traffic monitors, eg: 15m or 24h, aligned to the multiples of the window since the midnight
+	// of the BillingTimezone. The window must be whole minutes and divide a day, default 1h
+	TrafficWindow = "TRAFFIC_WINDOW"
+	// BillingTimezone the IANA time zone of the midnight the traffic windows are aligned to, eg: Asia/Shanghai, default UTC.
+	// The monitors are still stored in UTC
+	BillingTimezone = "BILLING_TIMEZONE"
+
+	DefaultTrafficWindow = time.Hour
+)
+
+func parseTrafficWindow(window time.Duration) (time.Duration, error) {
+	if window <= 0 {
+		return DefaultTrafficWindow, nil
+	}
+	if window%time.Minute != 0 || (24*time.Hour)%window != 0 {
+		return 0, fmt.Errorf("invalid %s %s: must be whole minutes and divide 24h, eg: 15m, 1h or 24h", TrafficWindow, window)
+	}
+	return window, nil
+}
+
+// newTrafficWindowFromEnv returns the traffic window of the env, a value without a unit (eg: 15) or of an unknown unit
+// (eg: 1day) fails instead of metering the traffic by the default window
+func newTrafficWindowFromEnv() (time.Duration, error) {
+	raw := os.Getenv(TrafficWindow)
+	if raw == "" {
+		return DefaultTrafficWindow, nil
+	}
+	window, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", TrafficWindow, raw, err)
+	}
+	return parseTrafficWindow(window)
+}
+
+// parseBillingTimezone returns the location of the time zone, UTC if empty
+func parseBillingTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", BillingTimezone, name, err)
+	}
+	return loc, nil
+}
+
+// trafficWindowEnd returns the end of the window containing now in UTC, UTC windows if loc is nil. The windows of a day
+// start at the midnight of loc and are window long, the last window of the day ends at the next midnight. A day shortened
+// or lengthened by the daylight saving time has its last window shortened or lengthened by the shift (eg: the daily
+// window of 23h or 25h), so the windows neither overlap nor leave gaps across the transitions.
+func trafficWindowEnd(now time.Time, window time.Duration, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	nextMidnight := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+	end := midnight.Add(now.Sub(midnight).Truncate(window) + window)
+	// the remainder of a longer day is kept in the last window rather than a short window of its own
+	if nextMidnight.Sub(end) < window {
+		end = nextMidnight
+	}
+	return end.UTC()
+}
+
+// trafficMonitorTime the time of the traffic monitor of the window ending at the end, the last minute of the window,
+// so the monitor falls in [end-window, end) and the daily window is kept in the day of the traffic
+func trafficMonitorTime(end time.Time) time.Time {
+	return end.Add(-time.Minute)
+}
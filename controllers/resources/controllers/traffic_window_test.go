@@ -0,0 +1,228 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseTrafficWindow(t *testing.T) {
+	tests := []struct {
+		window  time.Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{window: 0, want: DefaultTrafficWindow},
+		{window: 15 * time.Minute, want: 15 * time.Minute},
+		{window: time.Hour, want: time.Hour},
+		{window: 24 * time.Hour, want: 24 * time.Hour},
+		{window: 7 * time.Minute, wantErr: true},
+		{window: 90 * time.Second, wantErr: true},
+		{window: 48 * time.Hour, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTrafficWindow(tt.window)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTrafficWindow(%s) error = %v, wantErr %v", tt.window, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseTrafficWindow(%s) = %s, want %s", tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestNewTrafficWindowFromEnv(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{raw: "", want: DefaultTrafficWindow},
+		{raw: "15m", want: 15 * time.Minute},
+		{raw: "24h", want: 24 * time.Hour},
+		// the values not parsed are not billed by the default window
+		{raw: "15", wantErr: true},
+		{raw: "1day", wantErr: true},
+		{raw: "7m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv(TrafficWindow, tt.raw)
+		got, err := newTrafficWindowFromEnv()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("newTrafficWindowFromEnv() of %q = %s, %v, want %s, wantErr %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTrafficWindowEnd(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		now    time.Time
+		window time.Duration
+		want   time.Time
+	}{
+		{now: day.Add(10*time.Hour + 7*time.Minute), window: 15 * time.Minute, want: day.Add(10*time.Hour + 15*time.Minute)},
+		{now: day.Add(10*time.Hour + 15*time.Minute), window: 15 * time.Minute, want: day.Add(10*time.Hour + 30*time.Minute)},
+		{now: day.Add(10*time.Hour + 7*time.Minute), window: time.Hour, want: day.Add(11 * time.Hour)},
+		{now: day.Add(10 * time.Hour), window: 24 * time.Hour, want: day.AddDate(0, 0, 1)},
+		// the daily window ends at the UTC midnight in any zone
+		{now: time.Date(2024, 1, 2, 7, 0, 0, 0, time.FixedZone("UTC+8", 8*3600)), window: 24 * time.Hour, want: day.AddDate(0, 0, 1)},
+	}
+	for _, tt := range tests {
+		if got := trafficWindowEnd(tt.now, tt.window, nil); !got.Equal(tt.want) {
+			t.Errorf("trafficWindowEnd(%s, %s) = %s, want %s", tt.now, tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestTrafficWindowEnd_DaylightSaving(t *testing.T) {
+	loc, err := parseBillingTimezone("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		day    time.Time
+		window time.Duration
+		// want the lengths of the windows of the local day
+		want []time.Duration
+	}{
+		{name: "regular day", day: time.Date(2024, 3, 9, 0, 0, 0, 0, loc), window: 6 * time.Hour,
+			want: []time.Duration{6 * time.Hour, 6 * time.Hour, 6 * time.Hour, 6 * time.Hour}},
+		{name: "23h day of 1h windows", day: time.Date(2024, 3, 10, 0, 0, 0, 0, loc), window: time.Hour,
+			want: repeatDuration(time.Hour, 23)},
+		{name: "25h day of 1h windows", day: time.Date(2024, 11, 3, 0, 0, 0, 0, loc), window: time.Hour,
+			want: repeatDuration(time.Hour, 25)},
+		{name: "23h day of 15m windows", day: time.Date(2024, 3, 10, 0, 0, 0, 0, loc), window: 15 * time.Minute,
+			want: repeatDuration(15*time.Minute, 92)},
+		{name: "23h day of 2h windows", day: time.Date(2024, 3, 10, 0, 0, 0, 0, loc), window: 2 * time.Hour,
+			want: append(repeatDuration(2*time.Hour, 10), 3*time.Hour)},
+		{name: "25h day of 2h windows", day: time.Date(2024, 11, 3, 0, 0, 0, 0, loc), window: 2 * time.Hour,
+			want: append(repeatDuration(2*time.Hour, 11), 3*time.Hour)},
+		{name: "23h daily window", day: time.Date(2024, 3, 10, 0, 0, 0, 0, loc), window: 24 * time.Hour,
+			want: []time.Duration{23 * time.Hour}},
+		{name: "25h daily window", day: time.Date(2024, 11, 3, 0, 0, 0, 0, loc), window: 24 * time.Hour,
+			want: []time.Duration{25 * time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextDay := time.Date(tt.day.Year(), tt.day.Month(), tt.day.Day()+1, 0, 0, 0, 0, loc)
+			var got []time.Duration
+			// each window starts at the end of the previous one, the first at the midnight
+			for start := tt.day.UTC(); start.Before(nextDay); {
+				end := trafficWindowEnd(start, tt.window, loc)
+				if mid := trafficWindowEnd(start.Add((end.Sub(start))/2), tt.window, loc); !mid.Equal(end) {
+					t.Fatalf("the window [%s, %s) ends at %s in the middle", start, end, mid)
+				}
+				got = append(got, end.Sub(start))
+				start = end
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("windows = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("windows = %v, want %v", got, tt.want)
+					break
+				}
+			}
+			// the last window ends at the next local midnight
+			if end := trafficWindowEnd(nextDay.Add(-time.Minute), tt.window, loc); !end.Equal(nextDay) {
+				t.Errorf("the last window ends at %s, want %s", end, nextDay.UTC())
+			}
+		})
+	}
+}
+
+func repeatDuration(d time.Duration, n int) []time.Duration {
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		durations[i] = d
+	}
+	return durations
+}
+
+func TestParseBillingTimezone(t *testing.T) {
+	if loc, err := parseBillingTimezone(""); err != nil || loc != time.UTC {
+		t.Errorf("parseBillingTimezone(\"\") = %v, %v, want UTC", loc, err)
+	}
+	if loc, err := parseBillingTimezone("Asia/Shanghai"); err != nil || loc.String() != "Asia/Shanghai" {
+		t.Errorf("parseBillingTimezone(\"Asia/Shanghai\") = %v, %v, want Asia/Shanghai", loc, err)
+	}
+	if _, err := parseBillingTimezone("Mars/Olympus"); err == nil {
+		t.Error("parseBillingTimezone(\"Mars/Olympus\") expected error")
+	}
+}
+
+// windowTrafficSource records the windows queried
+type windowTrafficSource struct {
+	windows [][2]time.Time
+}
+
+func (s *windowTrafficSource) GetTrafficSentBytes(startTime, endTime time.Time, _ string, _ uint8, _ string) (int64, error) {
+	s.windows = append(s.windows, [2]time.Time{startTime, endTime})
+	return 1 << 30, nil
+}
+
+func TestMonitorReconciler_monitorPodTrafficUsed_Window(t *testing.T) {
+	app := resources.AppType[resources.APP]
+	source := &windowTrafficSource{}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, window := range []time.Duration{15 * time.Minute, 24 * time.Hour} {
+		source.windows = nil
+		end := trafficWindowEnd(day.Add(10*time.Hour), window, nil)
+		start := end.Add(-window)
+		// the app metered in the window
+		db := databasetest.NewMemoryStore()
+		if err := db.InsertMonitor(context.Background(), &resources.Monitor{Time: start, Category: "ns-user-a", Type: app, Name: "app-a",
+			Used: resources.EnumUsedMap{0: 1}}); err != nil {
+			t.Fatal(err)
+		}
+		r := &MonitorReconciler{Logger: logr.Discard(), DBClient: db, TrafficClient: source, Properties: resources.DefaultPropertyTypeLS}
+		if err := r.monitorPodTrafficUsed(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}, start, end); err != nil {
+			t.Fatalf("monitorPodTrafficUsed() error = %v", err)
+		}
+		if len(source.windows) != 1 || !source.windows[0][0].Equal(start) || !source.windows[0][1].Equal(end) {
+			t.Fatalf("window %s: traffic queried in %v, want [%s, %s)", window, source.windows, start, end)
+		}
+		var traffic []*resources.Monitor
+		for _, monitor := range db.Monitors() {
+			if monitor.IdempotencyKey != "" {
+				traffic = append(traffic, monitor)
+			}
+		}
+		if len(traffic) != 1 {
+			t.Fatalf("window %s: %d traffic monitors inserted, want 1", window, len(traffic))
+		}
+		if want := resources.TrafficMonitorKey("ns-user-a", app, "app-a", end); traffic[0].IdempotencyKey != want {
+			t.Errorf("window %s: idempotency key = %q, want %q", window, traffic[0].IdempotencyKey, want)
+		}
+		// the monitor is in the window and in the day of the traffic
+		got := traffic[0].Time
+		if got.Before(start) || !got.Before(end) || got.Truncate(24*time.Hour) != start.Truncate(24*time.Hour) {
+			t.Errorf("window %s: monitor time = %s, want in [%s, %s) of the same day", window, got, start, end)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// UnboundedContainerCPU the cpu metered for a container without a cpu request or limit, eg: 100m. Disabled if empty,
+	// such containers are metered no cpu
+	UnboundedContainerCPU = "UNBOUNDED_CONTAINER_CPU"
+	// UnboundedContainerMemory the memory metered for a container without a memory request or limit, eg: 128Mi.
+	// Disabled if empty, such containers are metered no memory
+	UnboundedContainerMemory = "UNBOUNDED_CONTAINER_MEMORY"
+)
+
+var unboundedContainers = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sealos_resources_unbounded_containers_metered_total",
+	Help: "Number of the containers without a request or limit metered by the unbounded container default, by the resource.",
+}, []string{"resource"})
+
+func init() {
+	metrics.Registry.MustRegister(unboundedContainers)
+}
+
+// parseUnboundedContainerDefaults returns the quantities metered for the containers without a request or limit of
+// the resource, empty if disabled
+func parseUnboundedContainerDefaults() (corev1.ResourceList, error) {
+	defaults := corev1.ResourceList{}
+	for name, key := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:    UnboundedContainerCPU,
+		corev1.ResourceMemory: UnboundedContainerMemory,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", key, value, err)
+		}
+		if quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid %s %q, must be positive", key, value)
+		}
+		defaults[name] = quantity
+	}
+	return defaults, nil
+}
+
+// unboundedQuantity returns the default of the resource if the container has neither a request nor a limit of it,
+// so a container can't dodge the metering by omitting them. A zero request or limit set explicitly is kept.
+func (r *MonitorReconciler) unboundedQuantity(res corev1.ResourceRequirements, name corev1.ResourceName) (resource.Quantity, bool) {
+	quantity, ok := r.UnboundedContainerDefaults[name]
+	if !ok {
+		return resource.Quantity{}, false
+	}
+	if _, hasRequest := res.Requests[name]; hasRequest {
+		return resource.Quantity{}, false
+	}
+	if _, hasLimit := res.Limits[name]; hasLimit {
+		return resource.Quantity{}, false
+	}
+	unboundedContainers.WithLabelValues(name.String()).Inc()
+	return quantity.DeepCopy(), true
+}
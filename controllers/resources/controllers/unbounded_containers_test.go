@@ -0,0 +1,146 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestParseUnboundedContainerDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		cpu     string
+		memory  string
+		want    corev1.ResourceList
+		wantErr bool
+	}{
+		{name: "disabled", want: corev1.ResourceList{}},
+		{name: "cpu only", cpu: "100m", want: qosResources("100m", "")},
+		{name: "both", cpu: "100m", memory: "128Mi", want: qosResources("100m", "128Mi")},
+		{name: "invalid", cpu: "a lot", wantErr: true},
+		{name: "zero", memory: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(UnboundedContainerCPU, tt.cpu)
+			t.Setenv(UnboundedContainerMemory, tt.memory)
+			got, err := parseUnboundedContainerDefaults()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUnboundedContainerDefaults() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !equalResources(got, tt.want) {
+				t.Errorf("parseUnboundedContainerDefaults() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMonitorReconciler_monitorResourceUsage_Unbounded(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	pod := func(name string, container corev1.Container) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: name + "-0", Labels: map[string]string{resources.AppLabelKey: name}},
+			Spec:       corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{container}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		}
+	}
+	// app-a sets neither requests nor limits, app-b only limits the cpu and app-c sets a zero cpu limit
+	c := fake.NewClientBuilder().WithObjects(
+		pod("app-a", qosContainer(nil, nil)),
+		pod("app-b", qosContainer(nil, qosResources("2", ""))),
+		pod("app-c", qosContainer(nil, qosResources("0", "1Gi"))),
+	).Build()
+	cpu := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()]
+	memory := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceMemory.String()]
+	units := func(property resources.PropertyType, value string) int64 {
+		quantity := resource.MustParse(value)
+		return property.UsedUnits(quantity.MilliValue())
+	}
+
+	for _, tt := range []struct {
+		name       string
+		defaults   corev1.ResourceList
+		wantCPU    map[string]int64
+		wantMemory map[string]int64
+	}{
+		{name: "disabled", defaults: corev1.ResourceList{},
+			wantCPU:    map[string]int64{"app-b": units(cpu, "2")},
+			wantMemory: map[string]int64{"app-c": units(memory, "1Gi")}},
+		{name: "defaults", defaults: qosResources("100m", "128Mi"),
+			wantCPU:    map[string]int64{"app-a": units(cpu, "100m"), "app-b": units(cpu, "2")},
+			wantMemory: map[string]int64{"app-a": units(memory, "128Mi"), "app-b": units(memory, "128Mi"), "app-c": units(memory, "1Gi")}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db := databasetest.NewMemoryStore()
+			r := &MonitorReconciler{
+				Client:                     c,
+				Logger:                     logr.Discard(),
+				DBClient:                   db,
+				Properties:                 resources.DefaultPropertyTypeLS,
+				MeteringPolicy:             MeteringPolicyLimits,
+				GpuMeteringPolicy:          GpuMeteringPolicyReservation,
+				UnboundedContainerDefaults: tt.defaults,
+			}
+			if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+				t.Fatalf("monitorResourceUsage() error = %v", err)
+			}
+			gotCPU, gotMemory := map[string]int64{}, map[string]int64{}
+			for _, monitor := range db.Monitors() {
+				if used := monitor.Used[cpu.Enum]; used != 0 {
+					gotCPU[monitor.Name] = used
+				}
+				if used := monitor.Used[memory.Enum]; used != 0 {
+					gotMemory[monitor.Name] = used
+				}
+			}
+			for _, check := range []struct {
+				resource  string
+				got, want map[string]int64
+			}{{"cpu", gotCPU, tt.wantCPU}, {"memory", gotMemory, tt.wantMemory}} {
+				if len(check.got) != len(check.want) {
+					t.Errorf("%s = %v, want %v", check.resource, check.got, check.want)
+					continue
+				}
+				for app, want := range check.want {
+					if check.got[app] != want {
+						t.Errorf("%s of %s = %d, want %d", check.resource, app, check.got[app], want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func equalResources(got, want corev1.ResourceList) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for name, quantity := range want {
+		if q, ok := got[name]; !ok || q.Cmp(quantity) != 0 {
+			return false
+		}
+	}
+	return true
+}
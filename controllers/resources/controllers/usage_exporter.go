@@ -0,0 +1,126 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
+)
+
+// UsageExporter publishes the used quantities of the latest metering of each namespace as the gauges of
+// sealos_resources_namespace_used, default false. A series per namespace, app and resource, so the cardinality grows
+// with the apps of the cluster.
+const UsageExporter = "USAGE_EXPORTER"
+
+var namespaceUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sealos_resources_namespace_used",
+	Help: "The used quantity of a resource of an app of the namespace by the latest metering, in the unit of the monitors.",
+}, []string{"namespace", "type", "name", "resource"})
+
+func init() {
+	metrics.Registry.MustRegister(namespaceUsed)
+}
+
+// usageSeries the labels of a series of a namespace
+type usageSeries struct {
+	appType  string
+	name     string
+	resource string
+}
+
+// usageExporter keeps the series of each namespace, so the series of the apps and the resources gone since the
+// previous metering are deleted instead of keeping their last value
+type usageExporter struct {
+	gauge *prometheus.GaugeVec
+
+	mu     sync.Mutex
+	series map[string]map[usageSeries]struct{}
+}
+
+func newUsageExporter(gauge *prometheus.GaugeVec) *usageExporter {
+	return &usageExporter{gauge: gauge, series: make(map[string]map[usageSeries]struct{})}
+}
+
+// newUsageExporterFromEnv returns nil if the usage is not exported
+func newUsageExporterFromEnv() *usageExporter {
+	if !env.GetBoolEnvWithDefault(UsageExporter, false) {
+		return nil
+	}
+	return newUsageExporter(namespaceUsed)
+}
+
+// observe replaces the series of the namespace by the used of the monitors, the monitors of the same app and
+// resource (eg: of different properties) are summed
+func (e *usageExporter) observe(namespace string, monitors []*resources.Monitor, properties *resources.PropertyTypeLS) {
+	used := make(map[usageSeries]int64)
+	for _, monitor := range monitors {
+		for enum, value := range monitor.Used {
+			used[usageSeries{
+				appType:  resources.AppTypeReverse[monitor.Type],
+				name:     monitor.Name,
+				resource: propertyName(properties, enum),
+			}] += value
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for series := range e.series[namespace] {
+		if _, ok := used[series]; !ok {
+			e.gauge.DeleteLabelValues(namespace, series.appType, series.name, series.resource)
+		}
+	}
+	current := make(map[usageSeries]struct{}, len(used))
+	for series, value := range used {
+		e.gauge.WithLabelValues(namespace, series.appType, series.name, series.resource).Set(float64(value))
+		current[series] = struct{}{}
+	}
+	e.series[namespace] = current
+}
+
+// forget deletes the series of the namespaces not in the active set, eg: deleted or no longer selected
+func (e *usageExporter) forget(active map[string]struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for namespace := range e.series {
+		if _, ok := active[namespace]; !ok {
+			e.gauge.DeletePartialMatch(prometheus.Labels{"namespace": namespace})
+			delete(e.series, namespace)
+		}
+	}
+}
+
+// propertyName returns the name of the property of the enum, the enum itself if the property is unknown
+func propertyName(properties *resources.PropertyTypeLS, enum uint8) string {
+	if properties != nil {
+		if property, ok := properties.EnumMap[enum]; ok {
+			return property.Name
+		}
+	}
+	return strconv.Itoa(int(enum))
+}
+
+// exportUsage publishes the used of the monitors of the namespace
+func (r *MonitorReconciler) exportUsage(namespace string, monitors []*resources.Monitor) {
+	if r.usageExporter == nil {
+		return
+	}
+	r.usageExporter.observe(namespace, monitors, r.properties())
+}
@@ -0,0 +1,77 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+func TestNewUsageExporterFromEnv(t *testing.T) {
+	t.Setenv(UsageExporter, "")
+	if e := newUsageExporterFromEnv(); e != nil {
+		t.Errorf("newUsageExporterFromEnv() = %v, want nil by default", e)
+	}
+	t.Setenv(UsageExporter, "true")
+	if e := newUsageExporterFromEnv(); e == nil {
+		t.Error("newUsageExporterFromEnv() = nil, want the exporter")
+	}
+}
+
+func TestUsageExporter(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_namespace_used"}, []string{"namespace", "type", "name", "resource"})
+	e := newUsageExporter(gauge)
+	properties := &resources.PropertyTypeLS{EnumMap: map[uint8]resources.PropertyType{
+		0: {Name: "cpu"}, 1: {Name: "memory"},
+	}}
+	app := resources.AppType[resources.APP]
+	db := resources.AppType[resources.DB]
+
+	e.observe("ns-a", []*resources.Monitor{
+		{Type: app, Name: "web", Used: resources.EnumUsedMap{0: 100, 1: 256}},
+		// the monitors of the same app and resource are summed, eg: of different properties
+		{Type: app, Name: "web", Used: resources.EnumUsedMap{0: 50}, Property: "qos.besteffort"},
+		{Type: db, Name: "pg", Used: resources.EnumUsedMap{0: 200, 9: 1}},
+	}, properties)
+	e.observe("ns-b", []*resources.Monitor{{Type: app, Name: "web", Used: resources.EnumUsedMap{0: 10}}}, properties)
+	if got := testutil.CollectAndCount(gauge); got != 5 {
+		t.Fatalf("series = %d, want 5", got)
+	}
+	if got := testutil.ToFloat64(gauge.WithLabelValues("ns-a", resources.APP, "web", "cpu")); got != 150 {
+		t.Errorf("cpu of ns-a/web = %v, want 150", got)
+	}
+	if got := testutil.ToFloat64(gauge.WithLabelValues("ns-a", resources.DB, "pg", "9")); got != 1 {
+		t.Errorf("unknown property of ns-a/pg = %v, want 1 labeled by the enum", got)
+	}
+
+	// the series of the app gone since the previous metering are deleted
+	e.observe("ns-a", []*resources.Monitor{{Type: db, Name: "pg", Used: resources.EnumUsedMap{0: 300}}}, properties)
+	if got := testutil.CollectAndCount(gauge); got != 2 {
+		t.Errorf("series after ns-a/web is gone = %d, want 2", got)
+	}
+	if got := testutil.ToFloat64(gauge.WithLabelValues("ns-a", resources.DB, "pg", "cpu")); got != 300 {
+		t.Errorf("cpu of ns-a/pg = %v, want 300", got)
+	}
+
+	// the series of the namespaces gone are deleted
+	e.forget(map[string]struct{}{"ns-a": {}})
+	if got := testutil.CollectAndCount(gauge); got != 1 {
+		t.Errorf("series after ns-b is gone = %d, want 1", got)
+	}
+}
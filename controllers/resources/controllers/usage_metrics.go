@@ -0,0 +1,190 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// UsageMetricsResources the resources metered by the actual usage of the containers from the prometheus container
+	// metrics instead of METERING_POLICY, comma separated: cpu, memory. Disabled if empty
+	UsageMetricsResources = "USAGE_METRICS_RESOURCES"
+	// UsageMetricsCPUQuery the cpu cores query template per pod and container, placeholder {{.Namespace}}
+	UsageMetricsCPUQuery = "USAGE_METRICS_CPU_QUERY"
+	// UsageMetricsMemoryQuery the memory bytes query template per pod and container, placeholder {{.Namespace}}
+	UsageMetricsMemoryQuery = "USAGE_METRICS_MEMORY_QUERY"
+
+	DefaultUsageMetricsCPUQuery    = `sum by (pod, container) (rate(container_cpu_usage_seconds_total{namespace="{{.Namespace}}",container!="",container!="POD"}[2m]))`
+	DefaultUsageMetricsMemoryQuery = `sum by (pod, container) (container_memory_working_set_bytes{namespace="{{.Namespace}}",container!="",container!="POD"})`
+
+	usageMetricsTimeout = 10 * time.Second
+)
+
+var usageMetricsFallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sealos_resources_usage_metrics_fallbacks_total",
+	Help: "Number of the containers metered by the requests or limits because their usage metrics were unavailable, by the resource.",
+}, []string{"resource"})
+
+func init() {
+	metrics.Registry.MustRegister(usageMetricsFallbacks)
+}
+
+// usageMetrics queries the actual cpu and memory usage of the containers from the cadvisor metrics in prometheus,
+// eg: scraped from the kubelets or the metrics-server pipeline
+type usageMetrics struct {
+	promAPI v1.API
+	queries map[corev1.ResourceName]*template.Template
+}
+
+// containerUsage the usage of the containers of a namespace by the resource and "pod/container"
+type containerUsage map[corev1.ResourceName]map[string]resource.Quantity
+
+func containerKey(pod, container string) string {
+	return pod + "/" + container
+}
+
+// newUsageMetricsFromEnv returns nil if no resource is metered by the usage metrics
+func newUsageMetricsFromEnv(promURL string) (*usageMetrics, error) {
+	names := splitList(os.Getenv(UsageMetricsResources))
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if promURL == "" {
+		return nil, fmt.Errorf("%s requires env: %s", UsageMetricsResources, PrometheusURL)
+	}
+	defaults := map[corev1.ResourceName][2]string{
+		corev1.ResourceCPU:    {UsageMetricsCPUQuery, DefaultUsageMetricsCPUQuery},
+		corev1.ResourceMemory: {UsageMetricsMemoryQuery, DefaultUsageMetricsMemoryQuery},
+	}
+	queries := make(map[corev1.ResourceName]*template.Template, len(names))
+	for _, name := range names {
+		query, ok := defaults[corev1.ResourceName(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %q: only cpu and memory can be metered by the usage metrics", UsageMetricsResources, name)
+		}
+		raw := os.Getenv(query[0])
+		if raw == "" {
+			raw = query[1]
+		}
+		tmpl, err := template.New(name).Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template %q: %w", query[0], raw, err)
+		}
+		queries[corev1.ResourceName(name)] = tmpl
+	}
+	promClient, err := api.NewClient(api.Config{Address: promURL})
+	if err != nil {
+		return nil, fmt.Errorf("failed to new prometheus client: %w", err)
+	}
+	return &usageMetrics{promAPI: v1.NewAPI(promClient), queries: queries}, nil
+}
+
+// namespaceUsage returns the usage of the containers of the namespace of the metered resources, a resource failed to
+// query is missing from the result and its error is returned with the others
+func (m *usageMetrics) namespaceUsage(namespace string) (containerUsage, error) {
+	usage := make(containerUsage, len(m.queries))
+	var errs []string
+	for name, tmpl := range m.queries {
+		containers, err := m.query(tmpl, name, namespace)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		usage[name] = containers
+	}
+	if len(errs) > 0 {
+		return usage, fmt.Errorf("failed to query the usage metrics: %s", strings.Join(errs, "; "))
+	}
+	return usage, nil
+}
+
+func (m *usageMetrics) query(tmpl *template.Template, name corev1.ResourceName, namespace string) (map[string]resource.Quantity, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, struct{ Namespace string }{Namespace: namespace}); err != nil {
+		return nil, fmt.Errorf("failed to render %s usage query: %w", name, err)
+	}
+	query := sb.String()
+	ctx, cancel := context.WithTimeout(context.Background(), usageMetricsTimeout)
+	defer cancel()
+	result, _, err := m.promAPI.Query(ctx, query, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prometheus, query: %v, err: %w", query, err)
+	}
+	vector, ok := result.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("unexpected prometheus result type %s, query: %v", result.Type(), query)
+	}
+	containers := make(map[string]resource.Quantity, len(vector))
+	for _, sample := range vector {
+		pod, container := string(sample.Metric["pod"]), string(sample.Metric["container"])
+		value := float64(sample.Value)
+		if pod == "" || container == "" || math.IsNaN(value) || value < 0 {
+			continue
+		}
+		if name == corev1.ResourceCPU {
+			// the cores rounded up to the millicore
+			containers[containerKey(pod, container)] = *resource.NewMilliQuantity(int64(math.Ceil(value*1000)), resource.DecimalSI)
+		} else {
+			containers[containerKey(pod, container)] = *resource.NewQuantity(int64(math.Ceil(value)), resource.BinarySI)
+		}
+	}
+	return containers, nil
+}
+
+// usageOfNamespace returns the usage of the containers of the namespace, nil if the usage metrics are disabled.
+// The containers are metered by the requests or limits if the query failed.
+func (r *MonitorReconciler) usageOfNamespace(namespace string) containerUsage {
+	if r.usageMetrics == nil {
+		return nil
+	}
+	usage, err := r.usageMetrics.namespaceUsage(namespace)
+	if err != nil {
+		r.Logger.Error(err, "the containers are metered by the requests or limits", "namespace", namespace)
+	}
+	return usage
+}
+
+// containerQuantity returns the usage of the resource of the container if the resource is metered by the usage
+// metrics and the container has them, otherwise the quantity of the metering policy of its resources, or the
+// unbounded container default if the resources have neither a request nor a limit of it
+func (r *MonitorReconciler) containerQuantity(usage containerUsage, pod *corev1.Pod, container *corev1.Container, name corev1.ResourceName) resource.Quantity {
+	if r.usageMetrics != nil {
+		if _, metered := r.usageMetrics.queries[name]; metered {
+			if quantity, ok := usage[name][containerKey(pod.Name, container.Name)]; ok {
+				return quantity
+			}
+			usageMetricsFallbacks.WithLabelValues(name.String()).Inc()
+		}
+	}
+	if quantity, ok := r.unboundedQuantity(container.Resources, name); ok {
+		return quantity
+	}
+	return r.MeteringPolicy.quantity(container.Resources, name)
+}
@@ -0,0 +1,144 @@
+// Copyright © 2024 sealos.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/labring/sealos/controllers/pkg/database/databasetest"
+	"github.com/labring/sealos/controllers/pkg/resources"
+)
+
+// newUsagePrometheus answers the cpu and memory usage queries, app-a-0 has the metrics and app-a-1 has none
+func newUsagePrometheus(t *testing.T, fail bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		if fail {
+			http.Error(w, "prometheus unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		value := "268435456"
+		if strings.Contains(req.Form.Get("query"), "container_cpu_usage_seconds_total") {
+			value = "0.1234"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[`+
+			`{"metric":{"pod":"app-a-0","container":"app"},"value":[1700000000,%q]}]}}`, value)
+	}))
+}
+
+func TestMonitorReconciler_monitorResourceUsage_UsageMetrics(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	newPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-user-a", Name: name, Labels: map[string]string{resources.AppLabelKey: "app-a"}},
+			Spec:       corev1.PodSpec{NodeName: "node-a", Containers: []corev1.Container{qosContainer(qosResources("1", "1Gi"), nil)}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, StartTime: &started},
+		}
+	}
+	cpu := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceCPU.String()]
+	memory := resources.DefaultPropertyTypeLS.StringMap[corev1.ResourceMemory.String()]
+	units := func(property resources.PropertyType, value string) int64 {
+		q := resource.MustParse(value)
+		return property.UsedUnits(q.MilliValue())
+	}
+
+	tests := []struct {
+		name          string
+		resources     string
+		fail          bool
+		wantCPU       int64
+		wantMemory    int64
+		wantFallbacks float64
+	}{
+		// app-a-0 by its usage, app-a-1 without metrics by its requests
+		{name: "cpu and memory by the usage", resources: "cpu,memory",
+			wantCPU: units(cpu, "1124m"), wantMemory: units(memory, "1280Mi"), wantFallbacks: 1},
+		{name: "only cpu by the usage", resources: "cpu",
+			wantCPU: units(cpu, "1124m"), wantMemory: units(memory, "2Gi"), wantFallbacks: 1},
+		{name: "prometheus unavailable", resources: "cpu,memory", fail: true,
+			wantCPU: units(cpu, "2"), wantMemory: units(memory, "2Gi"), wantFallbacks: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prom := newUsagePrometheus(t, tt.fail)
+			defer prom.Close()
+			t.Setenv(UsageMetricsResources, tt.resources)
+			metrics, err := newUsageMetricsFromEnv(prom.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db := databasetest.NewMemoryStore()
+			r := &MonitorReconciler{
+				Client:            fake.NewClientBuilder().WithObjects(newPod("app-a-0"), newPod("app-a-1")).Build(),
+				Logger:            logr.Discard(),
+				DBClient:          db,
+				Properties:        resources.DefaultPropertyTypeLS,
+				MeteringPolicy:    MeteringPolicyRequests,
+				GpuMeteringPolicy: GpuMeteringPolicyReservation,
+				usageMetrics:      metrics,
+			}
+			before := testutil.ToFloat64(usageMetricsFallbacks.WithLabelValues(corev1.ResourceCPU.String()))
+			if err := r.monitorResourceUsage(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-user-a"}}); err != nil {
+				t.Fatalf("monitorResourceUsage() error = %v", err)
+			}
+			used := sumMonitorsUsed(db.Monitors())
+			if used[cpu.Enum] != tt.wantCPU || used[memory.Enum] != tt.wantMemory {
+				t.Errorf("cpu = %d, memory = %d, want %d, %d", used[cpu.Enum], used[memory.Enum], tt.wantCPU, tt.wantMemory)
+			}
+			if got := testutil.ToFloat64(usageMetricsFallbacks.WithLabelValues(corev1.ResourceCPU.String())) - before; got != tt.wantFallbacks {
+				t.Errorf("cpu fallbacks = %v, want %v", got, tt.wantFallbacks)
+			}
+		})
+	}
+}
+
+func TestNewUsageMetricsFromEnv(t *testing.T) {
+	t.Setenv(UsageMetricsResources, "")
+	if m, err := newUsageMetricsFromEnv(""); m != nil || err != nil {
+		t.Errorf("newUsageMetricsFromEnv() disabled = %v, %v, want nil", m, err)
+	}
+	t.Setenv(UsageMetricsResources, "cpu")
+	if _, err := newUsageMetricsFromEnv(""); err == nil {
+		t.Error("newUsageMetricsFromEnv() without the prometheus url expected error")
+	}
+	t.Setenv(UsageMetricsResources, "cpu,storage")
+	if _, err := newUsageMetricsFromEnv("http://prometheus:9090"); err == nil {
+		t.Error("newUsageMetricsFromEnv() of storage expected error")
+	}
+	t.Setenv(UsageMetricsResources, "memory")
+	t.Setenv(UsageMetricsMemoryQuery, `sum by (pod, container) (container_memory_rss{namespace="{{.Namespace}}"})`)
+	m, err := newUsageMetricsFromEnv("http://prometheus:9090")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.queries[corev1.ResourceCPU]; ok || len(m.queries) != 1 {
+		t.Errorf("queries = %v, want memory only", m.queries)
+	}
+}
@@ -19,7 +19,10 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/labring/sealos/controllers/pkg/database/mongo"
@@ -27,11 +30,11 @@ import (
 	"github.com/labring/sealos/controllers/pkg/database"
 
 	"github.com/labring/sealos/controllers/pkg/resources"
+	"github.com/labring/sealos/controllers/pkg/utils/env"
 
+	resourcesv1alpha1 "github.com/labring/sealos/controllers/resources/api/v1alpha1"
 	"github.com/labring/sealos/controllers/resources/controllers"
 
-	objectstoragev1 "github/labring/sealos/controllers/objectstorage/api/v1"
-
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -40,6 +43,7 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	//+kubebuilder:scaffold:imports
@@ -52,6 +56,7 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(resourcesv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -59,8 +64,10 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var apiAddr string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&apiAddr, "api-bind-address", "", "The address the api endpoint binds to, eg: :8082. Disabled if empty, requires ADMIN_TOKEN.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -95,14 +102,62 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	objStorageClient := newObjStorageClient()
+	// the checks must be added before the manager starts, the reconciler is created after
+	var objStorageReconciler atomic.Pointer[controllers.MonitorReconciler]
+	if objStorageClient != nil {
+		if err := mgr.AddReadyzCheck("objectstorage", objStorageClient.ReadyzCheck); err != nil {
+			setupLog.Error(err, "unable to set up object storage ready check")
+			os.Exit(1)
+		}
+		if os.Getenv(controllers.PrometheusURL) != "" {
+			if err := mgr.AddReadyzCheck("objectstorage-flow", func(req *http.Request) error {
+				if r := objStorageReconciler.Load(); r != nil {
+					return r.ObjStorageFlowReadyzCheck(req)
+				}
+				return fmt.Errorf("monitor reconciler not initialized")
+			}); err != nil {
+				setupLog.Error(err, "unable to set up object storage flow ready check")
+				os.Exit(1)
+			}
+		}
+		if err := mgr.AddReadyzCheck("objectstorage-breaker", func(req *http.Request) error {
+			if r := objStorageReconciler.Load(); r != nil {
+				return r.ObjStorageBreakerReadyzCheck(req)
+			}
+			return fmt.Errorf("monitor reconciler not initialized")
+		}); err != nil {
+			setupLog.Error(err, "unable to set up object storage breaker ready check")
+			os.Exit(1)
+		}
+	}
+
+	var monitorDBReconciler atomic.Pointer[controllers.MonitorReconciler]
+	if err := mgr.AddReadyzCheck("monitor-db", func(req *http.Request) error {
+		if r := monitorDBReconciler.Load(); r != nil {
+			return r.MonitorDBReadyzCheck(req)
+		}
+		return fmt.Errorf("monitor reconciler not initialized")
+	}); err != nil {
+		setupLog.Error(err, "unable to set up monitor database ready check")
+		os.Exit(1)
+	}
+
+	// the client writes directly to the api server, the reviews don't need the cache of the manager
+	if err := controllers.CheckRBACFromEnv(context.Background(), mgr.GetClient(), setupLog); err != nil {
+		setupLog.Error(err, "failed to check the rbac permissions")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	//if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 	//	setupLog.Error(err, "problem running manager")
 	//	os.Exit(1)
 	//}
+	// the signal stops the manager and the reconciler, which flushes the queued and the batched monitors on stop
+	ctx := ctrl.SetupSignalHandler()
 	go func() {
-		if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		if err := mgr.Start(ctx); err != nil {
 			setupLog.Error(err, "problem running manager")
 			os.Exit(1)
 		}
@@ -113,29 +168,67 @@ func main() {
 		setupLog.Error(err, "failed to init monitor reconciler")
 		os.Exit(1)
 	}
-	reconciler.DBClient, err = mongo.NewMongoInterface(context.Background(), os.Getenv(database.MongoURI))
+	// the health check replaces the connection of the store if the database stays unreachable, eg: after a failover,
+	// each connection records the latency and the errors of its operations
+	reconciler.DBClient, err = database.NewReconnectingStore(context.Background(), func(ctx context.Context) (database.MonitorStore, error) {
+		store, err := controllers.NewMonitorDBClient(ctx)
+		if err != nil {
+			return store, err
+		}
+		return database.NewInstrumentedStore(store), nil
+	})
 	if err != nil {
 		setupLog.Error(err, "failed to init db client")
 		os.Exit(1)
 	}
+	secondaryDBClient, err := controllers.NewMonitorSecondaryDBClient(context.Background())
+	if err != nil {
+		setupLog.Error(err, "failed to init secondary db client")
+		_ = reconciler.DBClient.Disconnect(context.Background())
+		os.Exit(1)
+	}
+	if err := controllers.MigrateMonitorDBSchema(reconciler.DBClient, secondaryDBClient, setupLog); err != nil {
+		setupLog.Error(err, "failed to migrate the monitor schema")
+		_ = reconciler.DBClient.Disconnect(context.Background())
+		if secondaryDBClient != nil {
+			_ = secondaryDBClient.Disconnect(context.Background())
+		}
+		os.Exit(1)
+	}
+	reconciler.DBClient = controllers.NewDualWriteStore(reconciler.DBClient, secondaryDBClient, setupLog)
 	defer func() {
 		if err := reconciler.DBClient.Disconnect(context.Background()); err != nil {
 			setupLog.Error(err, "failed to disconnect db client")
 		}
 	}()
-	if trafficURI := os.Getenv(database.TrafficMongoURI); trafficURI != "" {
-		reconciler.TrafficClient, err = mongo.NewMongoInterface(context.Background(), trafficURI)
+	monitorDBReconciler.Store(reconciler)
+	switch source := env.GetEnvWithDefault(controllers.TrafficSourceEnv, controllers.TrafficSourceMongo); source {
+	case controllers.TrafficSourceCilium:
+		reconciler.TrafficClient, err = controllers.NewCiliumTrafficSource(reconciler.PromURL, os.Getenv(controllers.CiliumTrafficMetric))
+		if err != nil {
+			setupLog.Error(err, "failed to init cilium traffic source")
+			os.Exit(1)
+		}
+	case controllers.TrafficSourceMongo:
+		trafficURI := os.Getenv(database.TrafficMongoURI)
+		if trafficURI == "" {
+			setupLog.Info("traffic mongo uri not found, please check env: TRAFFIC_MONGO_URI")
+			break
+		}
+		trafficClient, err := mongo.NewMongoInterface(context.Background(), trafficURI)
 		if err != nil {
 			setupLog.Error(err, "failed to init traffic db client")
 			os.Exit(1)
 		}
+		reconciler.TrafficClient = database.NewInstrumentedTraffic(trafficClient)
 		defer func() {
-			if err := reconciler.TrafficClient.Disconnect(context.Background()); err != nil {
+			if err := trafficClient.Disconnect(context.Background()); err != nil {
 				setupLog.Error(err, "failed to disconnect traffic db client")
 			}
 		}()
-	} else {
-		setupLog.Info("traffic mongo uri not found, please check env: TRAFFIC_MONGO_URI")
+	default:
+		setupLog.Error(fmt.Errorf("unknown traffic source %q", source), "please check env: TRAFFIC_SOURCE")
+		os.Exit(1)
 	}
 
 	err = reconciler.DBClient.InitDefaultPropertyTypeLS()
@@ -144,33 +237,79 @@ func main() {
 		os.Exit(1)
 	}
 	reconciler.Properties = resources.DefaultPropertyTypeLS
-	const (
-		MinioEndpoint = "MINIO_ENDPOINT"
-		MinioAk       = "MINIO_AK"
-		MinioSk       = "MINIO_SK"
-		PromURL       = "PROM_URL"
-	)
-	if endpoint, ak, sk := os.Getenv(MinioEndpoint), os.Getenv(MinioAk), os.Getenv(MinioSk); endpoint != "" && ak != "" && sk != "" {
-		reconciler.Logger.Info("init minio client")
-		if reconciler.ObjStorageClient, err = objectstoragev1.NewOSClient(endpoint, ak, sk); err != nil {
-			reconciler.Logger.Error(err, "failed to new minio client")
+	if err := reconciler.Properties.Validate(); err != nil {
+		setupLog.Error(err, "invalid properties, please check the property types")
+		os.Exit(1)
+	}
+	if configMap := os.Getenv(controllers.PropertiesConfigMap); configMap != "" {
+		watchClient, err := client.NewWithWatch(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+		if err != nil {
+			setupLog.Error(err, "failed to init properties watch client")
 			os.Exit(1)
 		}
-		_, err := reconciler.ObjStorageClient.ListBuckets(context.Background())
-		if err != nil {
-			reconciler.Logger.Error(err, "failed to list minio buckets")
+		if err := reconciler.SetupPropertiesReload(configMap, watchClient, mgr.GetEventRecorderFor("resources-controller")); err != nil {
+			setupLog.Error(err, "please check env: "+controllers.PropertiesConfigMap)
+			os.Exit(1)
+		}
+	}
+	if reconciler.BillingReconciliationEnabled() {
+		var billings database.BillingReader
+		if billingURI := env.GetEnvWithDefault(database.BillingMongoURI, os.Getenv(database.MongoURI)); billingURI != "" {
+			billingClient, err := mongo.NewMongoInterface(context.Background(), billingURI)
+			if err != nil {
+				setupLog.Error(err, "failed to init billing db client")
+				os.Exit(1)
+			}
+			billings, _ = billingClient.(database.BillingReader)
+			defer func() {
+				if err := billingClient.Disconnect(context.Background()); err != nil {
+					setupLog.Error(err, "failed to disconnect billing db client")
+				}
+			}()
+		}
+		if err := reconciler.SetupBillingReconciliation(billings, mgr.GetEventRecorderFor("resources-controller")); err != nil {
+			setupLog.Error(err, "failed to init billing reconciliation")
+			os.Exit(1)
+		}
+	}
+	if objStorageClient != nil {
+		reconciler.ObjStorageClient = objStorageClient
+		if env.GetEnvWithDefault(controllers.ObjStorageCredentialsMode, controllers.ObjStorageCredentialsModeAdmin) == controllers.ObjStorageCredentialsModeSTS {
+			reconciler.ObjStorageTenantClients, err = controllers.NewTenantObjStorageClients(objStorageClient, os.Getenv(controllers.MinioEndpoint), os.Getenv(controllers.MinioSTSEndpoint), os.Getenv(controllers.ObjStorageSTSFallback))
+			if err != nil {
+				setupLog.Error(err, "failed to init object storage tenant clients")
+				os.Exit(1)
+			}
+		}
+		if err := reconciler.SetupObjStorageQuota(os.Getenv(controllers.ObjStorageQuotaEnforcement), mgr.GetEventRecorderFor("resources-controller"), os.Getenv(controllers.MinioEndpoint)); err != nil {
+			setupLog.Error(err, "failed to init object storage quota enforcement")
 			os.Exit(1)
 		}
-		if promURL := os.Getenv(PromURL); promURL == "" {
-			reconciler.Logger.Info("prometheus url not found, please check env: PROM_URL")
-		} else {
-			reconciler.PromURL = promURL
+		if err := reconciler.ValidateNoncurrentBilling(); err != nil {
+			setupLog.Error(err, "please check env: "+controllers.ObjStorageNoncurrentBillingEnv)
+			os.Exit(1)
+		}
+		// the object storage flow is metered from prometheus
+		if reconciler.PromURL == "" {
+			setupLog.Error(fmt.Errorf("prometheus url not found"), "object storage metering is enabled, please check env: PROM_URL")
+			os.Exit(1)
 		}
-	} else {
-		reconciler.Logger.Info("minio info not found, please check env: MINIO_ENDPOINT, MINIO_AK, MINIO_SK")
+		if reconciler.ObjectStorageInstance == "" && env.GetBoolEnvWithDefault(controllers.ObjStorageInstanceDiscovery, false) {
+			if err := reconciler.DiscoverObjectStorageInstance(os.Getenv(controllers.ObjStoragePrometheusJob)); err != nil {
+				setupLog.Error(err, "please check env: "+controllers.ObjectStorageInstance)
+				os.Exit(1)
+			}
+		}
+		// fail loudly on a misconfigured flow query, the readiness check keeps retrying
+		reconciler.SetupObjStorageFlowQueryEvents(mgr.GetEventRecorderFor("resources-controller"),
+			os.Getenv(controllers.PodNamespaceEnv), os.Getenv(controllers.PodNameEnv))
+		if err := reconciler.ValidateObjStorageFlowQuery(); err != nil {
+			setupLog.Error(err, "the object storage flow is not metered until the flow query is valid, the readiness check fails meanwhile")
+		}
+		objStorageReconciler.Store(reconciler)
 	}
 	// timer creates tomorrow's timing table in advance to ensure that tomorrow's table exists
-	// Execute immediately and then every 24 hours.
+	// Execute immediately and then every 24 hours, the expired monitors are dropped by the reconciler.
 	time.AfterFunc(time.Until(getNextMidnight()), func() {
 		ticker := time.NewTicker(24 * time.Hour)
 		defer ticker.Stop()
@@ -179,15 +318,30 @@ func main() {
 			if err != nil {
 				reconciler.Logger.Error(err, "failed to create monitor time series")
 			}
-			if err := reconciler.DropMonitorCollectionOlder(); err != nil {
-				reconciler.Logger.Error(err, "failed to drop monitor collection")
-			}
 			<-ticker.C
 		}
 	})
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if apiAddr != "" {
+		mux := http.NewServeMux()
+		if err := reconciler.RegisterHandlers(mux); err != nil {
+			setupLog.Error(err, "unable to start api server")
+			os.Exit(1)
+		}
+		server := &http.Server{Addr: apiAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			setupLog.Info("starting api server", "addr", apiAddr)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				setupLog.Error(err, "problem running api server")
+			}
+		}()
+		defer func() {
+			_ = server.Shutdown(context.Background())
+		}()
+	}
+	if objStorageClient != nil {
+		go objStorageClient.WatchCredentials(ctx, controllers.DefaultObjStorageReloadInterval)
+	}
 
 	if err := reconciler.StartReconciler(ctx); err != nil {
 		setupLog.Error(err, "failed to start monitor reconciler")
@@ -195,6 +349,33 @@ func main() {
 	}
 }
 
+// newObjStorageClient returns nil if the minio info is not set,
+// the credentials are loaded from MINIO_CREDENTIALS_DIR if set, otherwise from env MINIO_AK and MINIO_SK.
+func newObjStorageClient() *controllers.ObjStorageClient {
+	endpoint := os.Getenv(controllers.MinioEndpoint)
+	load := controllers.CredentialsLoader(controllers.EnvCredentialsLoader)
+	if dir := os.Getenv(controllers.MinioCredentialsDir); dir != "" {
+		load = controllers.FileCredentialsLoader(dir)
+	} else if os.Getenv(controllers.MinioAk) == "" || os.Getenv(controllers.MinioSk) == "" {
+		endpoint = ""
+	}
+	if endpoint == "" {
+		setupLog.Info("minio info not found, please check env: MINIO_ENDPOINT, MINIO_AK, MINIO_SK")
+		return nil
+	}
+	setupLog.Info("init minio client")
+	client, err := controllers.NewObjStorageClient(endpoint, env.GetBoolEnvWithDefault(controllers.MinioSecure, false), load)
+	if err != nil {
+		setupLog.Error(err, "failed to new minio client")
+		os.Exit(1)
+	}
+	if _, err := client.Client().ListBuckets(context.Background()); err != nil {
+		setupLog.Error(err, "failed to list minio buckets")
+		os.Exit(1)
+	}
+	return client
+}
+
 // getNextMidnight returns the next midnight time from now
 func getNextMidnight() time.Time {
 	now := time.Now().UTC()